@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/nieveai/d-agents/internal/agents"
+	"github.com/nieveai/d-agents/internal/api"
+	"github.com/nieveai/d-agents/internal/browser"
+	"github.com/nieveai/d-agents/internal/database"
+	"github.com/nieveai/d-agents/internal/health"
+	"github.com/nieveai/d-agents/internal/logging"
+	"github.com/nieveai/d-agents/internal/metrics"
+	"github.com/nieveai/d-agents/internal/models"
+	"github.com/nieveai/d-agents/internal/scheduler"
+	"github.com/nieveai/d-agents/internal/worker"
+)
+
+func main() {
+	addr := flag.String("addr", ":8080", "Address to serve the REST API on")
+	workers := flag.Int("workers", 5, "Number of workers")
+	queueDepth := flag.Int("queue-depth", worker.DefaultQueueDepth, "Maximum number of queued workloads")
+	maxRetries := flag.Int("max-retries", worker.DefaultMaxRetries, "Number of times a failing workload is retried")
+	workloadTimeout := flag.Duration("workload-timeout", worker.DefaultWorkloadTimeout, "Maximum time a workload may run, retries included (0 disables)")
+	maxInFlight := flag.Int("max-in-flight", worker.DefaultMaxInFlight, "Maximum number of concurrent LLM calls")
+	healthAddr := flag.String("health-addr", "", "Address to serve /healthz and /readyz on (e.g. :8081); disabled when empty")
+	metricsAddr := flag.String("metrics-addr", "", "Address to serve Prometheus metrics on (e.g. :9090); disabled when empty")
+	cacheTTL := flag.Duration("llm-cache-ttl", 0, "Cache identical LLM requests for this long (0 disables the cache)")
+	cacheSize := flag.Int("llm-cache-size", worker.DefaultCacheSize, "Maximum number of cached LLM responses")
+	browserTimeout := flag.Duration("browser-timeout", browser.DefaultTimeout, "Give up on a page the agents fetch after this long")
+	reapInterval := flag.Duration("reap-interval", worker.DefaultReapInterval, "How often to look for running workloads whose worker stopped responding")
+	staleAfter := flag.Duration("stale-after", worker.DefaultStaleAfter, "Fail a running workload after this long without a heartbeat (0 disables)")
+	audit := flag.Bool("audit", true, "Record the prompt and response of every model call in the session's audit trail")
+	auditRedactInput := flag.Bool("audit-redact-input", false, "Leave the input out of the audit trail, for sensitive data")
+	agentLimits := flag.String("agent-limits", "", "Most workloads of an agent type run at once, e.g. ShoppingAgent=2,ChatAgent=10 (default the agent_limits setting)")
+	openDB := database.RegisterFlags(flag.CommandLine)
+	setupLogging := logging.RegisterFlags()
+	flag.Parse()
+
+	if err := setupLogging(); err != nil {
+		log.Fatal(err)
+	}
+
+	worker.SetMaxRetries(*maxRetries)
+	worker.SetWorkloadTimeout(*workloadTimeout)
+	if *cacheTTL > 0 {
+		worker.SetResponseCache(worker.NewResponseCache(*cacheSize, *cacheTTL))
+	}
+	worker.SetMaxInFlight(*maxInFlight)
+	worker.SetAudit(*audit, *auditRedactInput)
+
+	browserOpts := browser.DefaultOptions()
+	browserOpts.Timeout = *browserTimeout
+	pool := browser.NewBrowserPool(browserOpts)
+	defer pool.Close()
+	agents.SetBrowserPool(pool)
+
+	db, err := openDB()
+	if err != nil {
+		log.Fatalf("Error opening database: %s", err)
+	}
+	if err := database.SeedSettings(db, database.ConfigFile, database.CredentialsFile); err != nil {
+		log.Printf("Error seeding settings: %s", err)
+	}
+	if err := worker.ConfigureAgentLimits(*agentLimits, db); err != nil {
+		log.Fatalf("Invalid agent limits: %s", err)
+	}
+
+	dbModels, err := db.ListModels()
+	if err != nil {
+		log.Fatalf("Error loading models from database: %s", err)
+	}
+	if err := worker.Init(context.Background(), dbModels, db); err != nil {
+		log.Fatalf("Failed to initialize worker: %v", err)
+	}
+	defer database.CloseNeo4jDriver()
+
+	queue := worker.NewQueue(*queueDepth)
+	metrics.SetQueue(queue.Len)
+	if *metricsAddr != "" {
+		go func() {
+			if err := metrics.Serve(*metricsAddr); err != nil {
+				log.Printf("Metrics server stopped: %s", err)
+			}
+		}()
+	}
+	worker.RunWorkers(context.Background(), *workers, queue)
+
+	if *healthAddr != "" {
+		checker := health.NewServiceChecker(db)
+		checker.AddLiveness("workers", health.Workers)
+		go func() {
+			if err := checker.Serve(*healthAddr); err != nil {
+				log.Printf("Health server stopped: %s", err)
+			}
+		}()
+	}
+	if recovered, err := worker.RecoverWorkloads(queue); err != nil {
+		log.Printf("Error recovering workloads: %s", err)
+	} else if len(recovered) > 0 {
+		log.Printf("Recovered %d interrupted workloads", len(recovered))
+	}
+
+	// Sessions scheduled from the UI controller run from here too.
+	sessionScheduler := scheduler.New(db, queue)
+	go sessionScheduler.Run(context.Background())
+
+	if *staleAfter > 0 {
+		go worker.RunReaper(context.Background(), *reapInterval, *staleAfter)
+	}
+
+	apiServer := api.NewServer(db, queue)
+	// ReloadModels reads the models itself, so two changes made at once
+	// can't leave the older list in use.
+	apiServer.OnModelsChanged = func([]*models.Model) {
+		if err := worker.ReloadModels(context.Background()); err != nil {
+			log.Printf("Error reinitializing LLM client: %s", err)
+		}
+	}
+	apiServer.CancelSession = sessionScheduler.Cancel
+
+	server := &http.Server{Addr: *addr, Handler: apiServer.Handler()}
+	go func() {
+		log.Printf("Serving REST API on %s", *addr)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("API server failed: %v", err)
+		}
+	}()
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	<-sigChan
+
+	log.Println("Shutting down API server...")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Printf("Error shutting down API server: %s", err)
+	}
+}
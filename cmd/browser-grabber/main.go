@@ -2,33 +2,165 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
+	"time"
 
+	"github.com/chromedp/cdproto/page"
 	"github.com/chromedp/chromedp"
+	"github.com/nieveai/d-agents/internal/browser"
+	"github.com/nieveai/d-agents/internal/textutil"
 )
 
 func main() {
-	if len(os.Args) < 2 {
+	headful := flag.Bool("headful", false, "Show the browser window.")
+	userAgent := flag.String("user-agent", "", "The user agent to browse with.")
+	proxy := flag.String("proxy", "", "A proxy server for the browser, e.g. socks5://127.0.0.1:1080.")
+	waitSelector := flag.String("wait", "", "Wait until an element matching this CSS selector is visible before grabbing.")
+	scrolls := flag.Int("scroll", 0, "Scroll to the bottom this many times to trigger lazy loading.")
+	scrollPause := flag.Duration("scroll-pause", time.Second, "How long to wait after each scroll.")
+	timeout := flag.Duration("timeout", time.Minute, "Give up on the page after this long.")
+	screenshotFile := flag.String("screenshot", "", "Save a full-page PNG screenshot to this file instead of printing the HTML.")
+	maxHeight := flag.Int("max-height", 16384, "Cut full-page screenshots off at this many CSS pixels.")
+	pdfFile := flag.String("pdf", "", "Save the page as a PDF to this file instead of printing the HTML.")
+	text := flag.Bool("text", false, "Print the page as markdown text, as the agents send it to models, instead of the HTML.")
+
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [flags] <url>\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() < 1 {
 		log.Fatal("Please provide a URL as a command-line argument.")
 	}
-	url := os.Args[1]
+	url := flag.Arg(0)
 
-	// create context
-	ctx, cancel := chromedp.NewContext(context.Background())
-	defer cancel()
+	opts := browser.DefaultOptions()
+	opts.Headless = !*headful
+	opts.UserAgent = *userAgent
+	opts.Proxy = *proxy
+	opts.Timeout = *timeout
+	pool := browser.NewBrowserPool(opts)
+	defer pool.Close()
+
+	// build task list
+	tasks := chromedp.Tasks{chromedp.Navigate(url)}
+	found := false
+	if *waitSelector != "" {
+		tasks = append(tasks,
+			chromedp.WaitVisible(*waitSelector, chromedp.ByQuery),
+			chromedp.ActionFunc(func(context.Context) error {
+				found = true
+				return nil
+			}),
+		)
+	}
+	for i := 0; i < *scrolls; i++ {
+		tasks = append(tasks,
+			chromedp.Evaluate(`window.scrollTo(0, document.body.scrollHeight);`, nil),
+			chromedp.Sleep(*scrollPause),
+		)
+	}
+
+	// Captures come before the HTML cleanup below, which strips the styles.
+	var screenshot, pdf []byte
+	if *screenshotFile != "" {
+		tasks = append(tasks, fullScreenshot(&screenshot, float64(*maxHeight)))
+	}
+	if *pdfFile != "" {
+		tasks = append(tasks, chromedp.ActionFunc(func(ctx context.Context) error {
+			var err error
+			pdf, _, err = page.PrintToPDF().WithPrintBackground(true).Do(ctx)
+			return err
+		}))
+	}
 
-	// run task list
 	var res string
-	err := chromedp.Run(ctx,
-		chromedp.Navigate(url),
-		chromedp.Evaluate(`document.querySelectorAll('head, script, style, link, class, href').forEach(el => el.remove());`, nil),
-		chromedp.OuterHTML("html", &res),
-	)
+	printHTML := *screenshotFile == "" && *pdfFile == ""
+	if printHTML {
+		tasks = append(tasks,
+			chromedp.Evaluate(`document.querySelectorAll('head, script, style, link').forEach(el => el.remove());`, nil),
+			chromedp.OuterHTML("html", &res),
+		)
+	}
+
+	// run task list
+	err := pool.Run(context.Background(), tasks)
 	if err != nil {
+		pool.Close()
+		// A selector that never shows up only surfaces as the timeout.
+		if *waitSelector != "" && !found && errors.Is(err, context.DeadlineExceeded) {
+			log.Fatalf("Timed out after %s waiting for %q to become visible on %s", *timeout, *waitSelector, url)
+		}
 		log.Fatal(err)
 	}
 
-	fmt.Println(res)
+	if *screenshotFile != "" {
+		if err := writeFileAtomic(*screenshotFile, screenshot); err != nil {
+			log.Fatalf("Failed to write screenshot: %v", err)
+		}
+	}
+	if *pdfFile != "" {
+		if err := writeFileAtomic(*pdfFile, pdf); err != nil {
+			log.Fatalf("Failed to write PDF: %v", err)
+		}
+	}
+	if printHTML && *text {
+		fmt.Println(textutil.CleanHTML(res))
+	} else if printHTML {
+		fmt.Println(res)
+	}
+}
+
+// fullScreenshot is chromedp.FullScreenshot with the height capped, since
+// Chrome fails or produces huge images on endless pages.
+func fullScreenshot(res *[]byte, maxHeight float64) chromedp.Action {
+	return chromedp.ActionFunc(func(ctx context.Context) error {
+		_, _, _, _, _, contentSize, err := page.GetLayoutMetrics().Do(ctx)
+		if err != nil {
+			return err
+		}
+		height := contentSize.Height
+		if maxHeight > 0 && height > maxHeight {
+			log.Printf("Page is %.0fpx tall, cutting the screenshot off at %.0fpx", height, maxHeight)
+			height = maxHeight
+		}
+
+		*res, err = page.CaptureScreenshot().
+			WithCaptureBeyondViewport(true).
+			WithFromSurface(true).
+			WithFormat(page.CaptureScreenshotFormatPng).
+			WithClip(&page.Viewport{Width: contentSize.Width, Height: height, Scale: 1}).
+			Do(ctx)
+		return err
+	})
+}
+
+// writeFileAtomic writes data next to path and renames it into place, so a
+// failed run never leaves a truncated file behind.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	// CreateTemp makes the file private; use the usual permissions instead.
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
 }
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "out.png")
+	if err := os.WriteFile(path, []byte("old"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeFileAtomic(path, []byte("new")); err != nil {
+		t.Fatalf("writeFileAtomic: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil || string(data) != "new" {
+		t.Errorf("file = %q, %v; want \"new\"", data, err)
+	}
+	info, _ := os.Stat(path)
+	if info.Mode().Perm() != 0o644 {
+		t.Errorf("mode = %v, want 0644", info.Mode().Perm())
+	}
+	// No temporary file is left behind.
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("directory has %d entries, want only the file", len(entries))
+	}
+
+	if err := writeFileAtomic(filepath.Join(dir, "missing", "out.png"), []byte("x")); err == nil {
+		t.Error("writeFileAtomic succeeded in a missing directory")
+	}
+}
+
+// grabber builds the command into a temporary directory. It skips the test
+// when there is no Chrome to run it with.
+func grabber(t *testing.T) string {
+	t.Helper()
+	found := false
+	for _, name := range []string{"headless_shell", "headless-shell", "chromium", "chromium-browser", "google-chrome", "google-chrome-stable", "chrome"} {
+		if _, err := exec.LookPath(name); err == nil {
+			found = true
+			break
+		}
+	}
+	if !found {
+		t.Skip("no Chrome to grab pages with")
+	}
+	bin := filepath.Join(t.TempDir(), "browser-grabber")
+	if out, err := exec.Command("go", "build", "-o", bin, ".").CombinedOutput(); err != nil {
+		t.Fatalf("go build: %v\n%s", err, out)
+	}
+	return bin
+}
+
+func testPage(t *testing.T) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<html><head><style>p { color: red }</style></head><body><p id="x">hello grabber</p><div style="height: 3000px"></div></body></html>`)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestScreenshotAndPDF(t *testing.T) {
+	bin := grabber(t)
+	page := testPage(t)
+	dir := t.TempDir()
+	png, pdf := filepath.Join(dir, "page.png"), filepath.Join(dir, "page.pdf")
+
+	out, err := exec.Command(bin, "-screenshot", png, "-pdf", pdf, "-max-height", "1000", page.URL).CombinedOutput()
+	if err != nil {
+		t.Fatalf("browser-grabber: %v\n%s", err, out)
+	}
+	for path, magic := range map[string]string{png: "\x89PNG", pdf: "%PDF"} {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("reading %s: %v", path, err)
+		}
+		if !bytes.HasPrefix(data, []byte(magic)) {
+			t.Errorf("%s doesn't start with %q", filepath.Base(path), magic)
+		}
+	}
+	// With a capture asked for, the HTML isn't printed.
+	if strings.Contains(string(out), "hello grabber") {
+		t.Errorf("output has the HTML: %s", out)
+	}
+}
+
+func TestHTMLIsTheDefault(t *testing.T) {
+	bin := grabber(t)
+	page := testPage(t)
+	out, err := exec.Command(bin, page.URL).Output()
+	if err != nil {
+		t.Fatalf("browser-grabber: %v", err)
+	}
+	if !strings.Contains(string(out), "hello grabber") || strings.Contains(string(out), "<style") {
+		t.Errorf("output = %s, want the cleaned HTML", out)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// checkpoint records the companies that were processed successfully, one name
+// per line, so an interrupted run can pick up where it stopped.
+type checkpoint struct {
+	mu   sync.Mutex
+	file *os.File
+	done map[string]bool
+}
+
+// openCheckpoint opens the checkpoint at path. With resume the companies
+// already in it are kept, otherwise it starts out empty.
+func openCheckpoint(path string, resume bool) (*checkpoint, error) {
+	done := make(map[string]bool)
+	flags := os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	if resume {
+		f, err := os.Open(path)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("failed to read checkpoint: %w", err)
+		}
+		if f != nil {
+			scanner := bufio.NewScanner(f)
+			for scanner.Scan() {
+				if name := scanner.Text(); name != "" {
+					done[name] = true
+				}
+			}
+			f.Close()
+			if err := scanner.Err(); err != nil {
+				return nil, fmt.Errorf("failed to read checkpoint: %w", err)
+			}
+		}
+	} else {
+		flags |= os.O_TRUNC
+	}
+
+	file, err := os.OpenFile(path, flags, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open checkpoint: %w", err)
+	}
+	return &checkpoint{file: file, done: done}, nil
+}
+
+// Done reports whether name was processed by an earlier run.
+func (c *checkpoint) Done(name string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.done[name]
+}
+
+// Record marks name as processed. It is written out straight away so a crash
+// right after doesn't lose it.
+func (c *checkpoint) Record(name string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.done[name] {
+		return nil
+	}
+	if _, err := fmt.Fprintln(c.file, name); err != nil {
+		return err
+	}
+	c.done[name] = true
+	return c.file.Sync()
+}
+
+func (c *checkpoint) Close() error {
+	return c.file.Close()
+}
+
+// readCompanies reads the company names in r, one per line, leaving out those
+// done says an earlier run already processed. skipped is how many it left out.
+func readCompanies(r io.Reader, done *checkpoint) (companies []string, skipped int, err error) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		name := scanner.Text()
+		if name == "" {
+			continue
+		}
+		if done.Done(name) {
+			skipped++
+			continue
+		}
+		companies = append(companies, name)
+	}
+	return companies, skipped, scanner.Err()
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+const companiesFile = "Apple\nTSMC\n\nNvidia\nSamsung\n"
+
+// run is one run of the builder over companiesFile that fails on the
+// companies in fail, and returns the companies it processed.
+func run(t *testing.T, path string, resume bool, fail ...string) []string {
+	t.Helper()
+	done, err := openCheckpoint(path, resume)
+	if err != nil {
+		t.Fatalf("openCheckpoint: %v", err)
+	}
+	defer done.Close()
+	companies, _, err := readCompanies(strings.NewReader(companiesFile), done)
+	if err != nil {
+		t.Fatalf("readCompanies: %v", err)
+	}
+
+	var processed []string
+	processAll(context.Background(), companies, 2, func(ctx context.Context, name string) error {
+		if slices.Contains(fail, name) {
+			return errors.New("rate limited")
+		}
+		return nil
+	}, func(name string, err error) {
+		processed = append(processed, name)
+		if err == nil {
+			if err := done.Record(name); err != nil {
+				t.Errorf("Record: %v", err)
+			}
+		}
+	})
+	return processed
+}
+
+func TestResume(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "checkpoint.txt")
+	all := []string{"Apple", "TSMC", "Nvidia", "Samsung"}
+
+	if got := run(t, path, true, "Nvidia", "Samsung"); !slices.Equal(got, all) {
+		t.Fatalf("first run processed %q, want %q", got, all)
+	}
+	// Only the companies that failed are left for the second run.
+	if got, want := run(t, path, true, "Samsung"), []string{"Nvidia", "Samsung"}; !slices.Equal(got, want) {
+		t.Errorf("second run with -resume processed %q, want %q", got, want)
+	}
+	if got, want := run(t, path, true), []string{"Samsung"}; !slices.Equal(got, want) {
+		t.Errorf("third run with -resume processed %q, want %q", got, want)
+	}
+	if got := run(t, path, true); len(got) != 0 {
+		t.Errorf("run after everything was done processed %q, want nothing", got)
+	}
+
+	// Without -resume (or with -force) everything is processed again.
+	if got := run(t, path, false); !slices.Equal(got, all) {
+		t.Errorf("run without -resume processed %q, want %q", got, all)
+	}
+}
+
+func TestReadCompaniesSkipped(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "checkpoint.txt")
+	done, err := openCheckpoint(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done.Record("TSMC")
+	done.Record("TSMC")
+	done.Close()
+
+	done, err = openCheckpoint(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer done.Close()
+	companies, skipped, err := readCompanies(strings.NewReader(companiesFile), done)
+	if err != nil {
+		t.Fatalf("readCompanies: %v", err)
+	}
+	if want := []string{"Apple", "Nvidia", "Samsung"}; !slices.Equal(companies, want) || skipped != 1 {
+		t.Errorf("readCompanies = %q, %d skipped, want %q, 1 skipped", companies, skipped, want)
+	}
+}
@@ -1,12 +1,13 @@
 package main
 
 import (
-	"bufio"
 	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
 	"os"
+	"sync"
 
 	"github.com/google/uuid"
 	"github.com/nieveai/d-agents/internal/agents"
@@ -18,28 +19,44 @@ import (
 
 func main() {
 	// --- Command-line Flags ---
-	modelID := flag.String("model", "", "The ID of the model to use for processing. This flag is required.")
+	modelID := flag.String("model", "", "The ID, alias or model ID of the model to use for processing. Without it you are asked to pick one.")
+	listOnly := flag.Bool("list-models", false, "Print the available models and exit.")
+	store := flag.String("store", "neo4j", "Where to store relationships: neo4j (falls back to sqlite when Neo4j is unavailable) or sqlite.")
+	concurrency := flag.Int("concurrency", 1, "Number of companies to process at once.")
+	resume := flag.Bool("resume", false, "Skip the companies the checkpoint says were already processed.")
+	force := flag.Bool("force", false, "Reprocess every company even with -resume, starting a new checkpoint.")
+	checkpointPath := flag.String("checkpoint", "", "File recording the processed companies. Defaults to <file_path>.checkpoint.")
+	outPath := flag.String("out", "", "Also write the relationships found to this file, as CSV if it ends in .csv and JSON otherwise.")
+	openDB := database.RegisterFlags(flag.CommandLine)
 
 	flag.Usage = func() {
-		fmt.Fprintf(os.Stderr, "Usage: %s -model <model_id> <file_path>\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Usage: %s [-model <model_id>] [-store neo4j|sqlite] [-concurrency N] [-resume [-force]] [-checkpoint path] [-out file] <file_path>\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "Processes a list of company names from a text file to find and store their relationships.\n\n")
 		fmt.Fprintf(os.Stderr, "Arguments:\n")
 		fmt.Fprintf(os.Stderr, "  <file_path>\n\tThe path to a text file containing company names, one per line.\n\n")
 		fmt.Fprintf(os.Stderr, "Flags:\n")
 		flag.PrintDefaults()
+		fmt.Fprintf(os.Stderr, "\n%s -list-models prints the available models.\n", os.Args[0])
 	}
 
 	flag.Parse()
 
-	if *modelID == "" || flag.NArg() < 1 {
+	if !*listOnly && flag.NArg() < 1 {
+		flag.Usage()
+		os.Exit(1)
+	}
+	if (*store != "neo4j" && *store != "sqlite") || *concurrency < 1 {
 		flag.Usage()
 		os.Exit(1)
 	}
 	filePath := flag.Arg(0)
+	if *checkpointPath == "" {
+		*checkpointPath = filePath + ".checkpoint"
+	}
 	// --- End Flags ---
 
 	// --- Database and Model Initialization ---
-	db, err := database.NewSQLiteDatastore("d-agents.db")
+	db, err := openDB()
 	if err != nil {
 		log.Fatalf("Error opening database: %s", err)
 	}
@@ -53,16 +70,30 @@ func main() {
 		log.Fatal("No models found in the database. Please add a model using the controller program first.")
 	}
 
-	var selectedModel *models.Model
-	for _, m := range dbModels {
-		if m.ID == *modelID {
-			selectedModel = m
-			break
-		}
+	sortModels(dbModels)
+	if *listOnly {
+		listModels(os.Stdout, dbModels)
+		return
 	}
 
-	if selectedModel == nil {
-		log.Fatalf("Model with ID '%s' not found in the database.", *modelID)
+	var selectedModel *models.Model
+	if *modelID == "" {
+		if !isTerminal(os.Stdin) {
+			fmt.Fprintln(os.Stderr, "No -model given. Available models:")
+			listModels(os.Stderr, dbModels)
+			os.Exit(1)
+		}
+		selectedModel, err = pickModel(os.Stdin, os.Stdout, dbModels)
+		if err != nil {
+			log.Fatalf("Error picking a model: %s", err)
+		}
+	} else {
+		selectedModel, err = database.ResolveModel(db, *modelID)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s. Available models:\n", err)
+			listModels(os.Stderr, dbModels)
+			os.Exit(1)
+		}
 	}
 
 	log.Printf("Using model: %s (%s/%s)", selectedModel.ID, selectedModel.Provider, selectedModel.ModelID)
@@ -79,18 +110,52 @@ func main() {
 	}
 	defer file.Close()
 
-	companyAgent, err := agents.NewCompanyRelationshipAgent()
+	var companyAgent *agents.CompanyRelationshipAgent
+	if *store == "sqlite" {
+		companyAgent = agents.NewCompanyRelationshipAgentWithStore(db)
+	} else {
+		agents.SetRelationshipStore(db)
+		companyAgent, err = agents.NewCompanyRelationshipAgent()
+		if err != nil {
+			log.Fatalf("Failed to create company relationship agent: %v", err)
+		}
+		defer database.CloseNeo4jDriver()
+	}
+
+	done, err := openCheckpoint(*checkpointPath, *resume && !*force)
 	if err != nil {
-		log.Fatalf("Failed to create company relationship agent: %v", err)
+		log.Fatalf("Failed to open checkpoint %s: %v", *checkpointPath, err)
 	}
+	defer done.Close()
 
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		companyName := scanner.Text()
-		if companyName == "" {
-			continue
+	var out *relationshipWriter
+	if *outPath != "" {
+		out, err = createRelationshipWriter(*outPath)
+		if err != nil {
+			log.Fatalf("Failed to open %s: %v", *outPath, err)
 		}
+		defer func() {
+			if err := out.Close(); err != nil {
+				log.Printf("Failed to finish %s: %v", *outPath, err)
+			}
+		}()
+	}
 
+	companies, skipped, err := readCompanies(file, done)
+	if err != nil {
+		log.Fatalf("Failed to read file: %v", err)
+	}
+	if skipped > 0 {
+		fmt.Printf("Skipping %d companies already processed according to %s\n", skipped, *checkpointPath)
+	}
+
+	// found holds the relationships of each company until report writes them
+	// out, in the order of the input file.
+	var foundMu sync.Mutex
+	found := make(map[string][]agents.CompanyRelationship)
+
+	// The agent is safe to share: each DoWork opens its own Neo4j session.
+	process := func(ctx context.Context, companyName string) error {
 		fmt.Printf("Processing company: %s\n", companyName)
 
 		workload := &pb.Workload{
@@ -100,15 +165,49 @@ func main() {
 			Models:  []string{selectedModel.ID},
 			Status:  pb.WorkloadStatus_RUNNING,
 		}
+		if out != nil {
+			// The json output format puts the relationships found in the metadata.
+			workload.Config, _ = agents.WithOutputFormat("", agents.OutputJSON)
+		}
+		if err := companyAgent.DoWork(ctx, workload, genAIClient); err != nil {
+			return err
+		}
+		if out != nil {
+			var result struct {
+				Metadata struct {
+					Relationships []agents.CompanyRelationship `json:"relationships"`
+				} `json:"metadata"`
+			}
+			if err := json.Unmarshal(workload.Payload, &result); err != nil {
+				return fmt.Errorf("failed to read the relationships found: %w", err)
+			}
+			foundMu.Lock()
+			found[companyName] = result.Metadata.Relationships
+			foundMu.Unlock()
+		}
+		if err := done.Record(companyName); err != nil {
+			log.Printf("Failed to update checkpoint for %s: %v", companyName, err)
+		}
+		return nil
+	}
 
-		if err := companyAgent.DoWork(workload, genAIClient); err != nil {
+	failed := 0
+	processAll(context.Background(), companies, *concurrency, process, func(companyName string, err error) {
+		if err != nil {
+			failed++
 			log.Printf("Failed to process workload for %s: %v", companyName, err)
 		} else {
 			fmt.Printf("Successfully processed and stored relationships for %s\n", companyName)
+			if out != nil {
+				foundMu.Lock()
+				relationships := found[companyName]
+				delete(found, companyName)
+				foundMu.Unlock()
+				if err := out.Write(companyName, relationships); err != nil {
+					log.Printf("Failed to write relationships of %s to %s: %v", companyName, *outPath, err)
+				}
+			}
 		}
-	}
-
-	if err := scanner.Err(); err != nil {
-		log.Fatalf("Failed to read file: %v", err)
-	}
-}
\ No newline at end of file
+	})
+	fmt.Printf("Processed %d companies, %d failed\n", len(companies), failed)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/nieveai/d-agents/internal/models"
+)
+
+// sortModels orders models by ID, so the numbers printed by listModels stay
+// the same between runs.
+func sortModels(list []*models.Model) {
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+}
+
+// listModels prints the models numbered from 1, the way pickModel expects.
+func listModels(w io.Writer, list []*models.Model) {
+	for i, model := range list {
+		fmt.Fprintf(w, "  %d) %s: %s/%s", i+1, model.ID, model.Provider, model.ModelID)
+		if model.Alias != "" {
+			fmt.Fprintf(w, " (%s)", model.Alias)
+		}
+		fmt.Fprintln(w)
+	}
+}
+
+// pickModel lists the models on out and reads the number of one from in,
+// asking again until it gets a valid one.
+func pickModel(in io.Reader, out io.Writer, list []*models.Model) (*models.Model, error) {
+	if len(list) == 0 {
+		return nil, fmt.Errorf("no models to pick from")
+	}
+	fmt.Fprintln(out, "Available models:")
+	listModels(out, list)
+	scanner := bufio.NewScanner(in)
+	for {
+		fmt.Fprintf(out, "Select a model [1-%d]: ", len(list))
+		if !scanner.Scan() {
+			if err := scanner.Err(); err != nil {
+				return nil, err
+			}
+			return nil, fmt.Errorf("no model selected")
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(scanner.Text()))
+		if err == nil && n >= 1 && n <= len(list) {
+			return list[n-1], nil
+		}
+		fmt.Fprintf(out, "Please enter a number from 1 to %d.\n", len(list))
+	}
+}
+
+// isTerminal reports whether f is a terminal rather than a file or pipe.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
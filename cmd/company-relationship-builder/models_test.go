@@ -0,0 +1,77 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/nieveai/d-agents/internal/database"
+	"github.com/nieveai/d-agents/internal/models"
+)
+
+// seededModels returns the models of a datastore seeded with three, sorted
+// the way the builder lists them.
+func seededModels(t *testing.T) []*models.Model {
+	t.Helper()
+	db := database.NewMemoryDatastore()
+	for _, model := range []*models.Model{
+		{ID: "gpt", Provider: "openai", ModelID: "gpt-4o-mini", Alias: "fast"},
+		{ID: "claude", Provider: "anthropic", ModelID: "claude-sonnet"},
+		{ID: "local", Provider: "ollama", ModelID: "llama3"},
+	} {
+		if err := db.AddModel(model); err != nil {
+			t.Fatal(err)
+		}
+	}
+	list, err := db.ListModels()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sortModels(list)
+	return list
+}
+
+func TestListModels(t *testing.T) {
+	var out strings.Builder
+	listModels(&out, seededModels(t))
+	want := "  1) claude: anthropic/claude-sonnet\n" +
+		"  2) gpt: openai/gpt-4o-mini (fast)\n" +
+		"  3) local: ollama/llama3\n"
+	if out.String() != want {
+		t.Errorf("listModels printed\n%s\nwant\n%s", out.String(), want)
+	}
+}
+
+func TestPickModel(t *testing.T) {
+	list := seededModels(t)
+	tests := []struct {
+		input   string
+		want    string
+		wantErr bool
+	}{
+		{"2\n", "gpt", false},
+		{" 3 \n", "local", false},
+		// Asked again until the number is valid.
+		{"0\nfour\n4\n1\n", "claude", false},
+		{"", "", true},
+		{"9\n", "", true},
+	}
+	for _, tt := range tests {
+		var out strings.Builder
+		model, err := pickModel(strings.NewReader(tt.input), &out, list)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("pickModel(%q) = %s, want an error", tt.input, model.ID)
+			}
+			continue
+		}
+		if err != nil || model.ID != tt.want {
+			t.Errorf("pickModel(%q) = %v, %v, want %s", tt.input, model, err, tt.want)
+		}
+		if !strings.HasPrefix(out.String(), "Available models:\n  1) claude") {
+			t.Errorf("pickModel(%q) printed %q, want the list first", tt.input, out.String())
+		}
+	}
+	if _, err := pickModel(strings.NewReader("1\n"), &strings.Builder{}, nil); err == nil {
+		t.Error("pickModel without models succeeded")
+	}
+}
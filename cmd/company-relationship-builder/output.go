@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/nieveai/d-agents/internal/agents"
+)
+
+// companyRelationships is the record written for each company.
+type companyRelationships struct {
+	Company       string           `json:"company"`
+	Relationships []relatedCompany `json:"relationships"`
+}
+
+type relatedCompany struct {
+	Name  string   `json:"name"`
+	Types []string `json:"types"`
+}
+
+// relationshipWriter writes the relationships found for each company to a
+// JSON or CSV file as soon as they come in, so a crash or a failed write
+// later on doesn't lose the companies already written.
+type relationshipWriter struct {
+	file *os.File
+	// csv is nil when writing JSON.
+	csv   *csv.Writer
+	count int
+}
+
+// createRelationshipWriter creates the file at path, CSV when it ends in .csv
+// and a JSON array otherwise.
+func createRelationshipWriter(path string) (*relationshipWriter, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create output file: %w", err)
+	}
+	w := &relationshipWriter{file: file}
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		w.csv = csv.NewWriter(file)
+		w.csv.Write([]string{"company", "related_company", "types"})
+		w.csv.Flush()
+		err = w.csv.Error()
+	} else {
+		_, err = file.WriteString("[")
+	}
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to write output file: %w", err)
+	}
+	return w, nil
+}
+
+// Write adds the relationships of company to the file and syncs it.
+func (w *relationshipWriter) Write(company string, relationships []agents.CompanyRelationship) error {
+	record := companyRelationships{Company: company, Relationships: []relatedCompany{}}
+	for _, rel := range relationships {
+		related := relatedCompany{Name: rel.Name, Types: []string{}}
+		for _, t := range strings.Split(rel.Relationship, ",") {
+			if t = strings.TrimSpace(t); t != "" {
+				related.Types = append(related.Types, t)
+			}
+		}
+		record.Relationships = append(record.Relationships, related)
+	}
+
+	if w.csv != nil {
+		for _, related := range record.Relationships {
+			w.csv.Write([]string{company, related.Name, strings.Join(related.Types, ";")})
+		}
+		w.csv.Flush()
+		if err := w.csv.Error(); err != nil {
+			return err
+		}
+	} else {
+		data, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		sep := ",\n"
+		if w.count == 0 {
+			sep = "\n"
+		}
+		if _, err := w.file.WriteString(sep + string(data)); err != nil {
+			return err
+		}
+	}
+	w.count++
+	return w.file.Sync()
+}
+
+// Close finishes the JSON array, if any, and closes the file.
+func (w *relationshipWriter) Close() error {
+	if w.csv == nil {
+		if _, err := w.file.WriteString("\n]\n"); err != nil {
+			w.file.Close()
+			return err
+		}
+	}
+	return w.file.Close()
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/nieveai/d-agents/internal/agents"
+)
+
+// twoCompanies writes the relationships of Nvidia and Apple with a new
+// relationshipWriter at path.
+func twoCompanies(t *testing.T, path string) {
+	t.Helper()
+	w, err := createRelationshipWriter(path)
+	if err != nil {
+		t.Fatalf("createRelationshipWriter: %v", err)
+	}
+	if err := w.Write("Nvidia", []agents.CompanyRelationship{
+		{Name: "TSMC", Relationship: "vendor"},
+		{Name: "AMD", Relationship: "competitor, customer"},
+	}); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := w.Write("Apple", []agents.CompanyRelationship{{Name: "Foxconn", Relationship: "vendor"}}); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+}
+
+func TestRelationshipWriterJSON(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "relationships.json")
+	twoCompanies(t, path)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []companyRelationships
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("output isn't a JSON array: %v\n%s", err, data)
+	}
+	want := []companyRelationships{
+		{Company: "Nvidia", Relationships: []relatedCompany{
+			{Name: "TSMC", Types: []string{"vendor"}},
+			{Name: "AMD", Types: []string{"competitor", "customer"}},
+		}},
+		{Company: "Apple", Relationships: []relatedCompany{{Name: "Foxconn", Types: []string{"vendor"}}}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("output = %+v, want %+v", got, want)
+	}
+}
+
+func TestRelationshipWriterCSV(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "relationships.CSV")
+	twoCompanies(t, path)
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	rows, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatalf("output isn't CSV: %v", err)
+	}
+	want := [][]string{
+		{"company", "related_company", "types"},
+		{"Nvidia", "TSMC", "vendor"},
+		{"Nvidia", "AMD", "competitor;customer"},
+		{"Apple", "Foxconn", "vendor"},
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("rows = %q, want %q", rows, want)
+	}
+}
+
+func TestRelationshipWriterIsIncremental(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "relationships.json")
+	w, err := createRelationshipWriter(path)
+	if err != nil {
+		t.Fatalf("createRelationshipWriter: %v", err)
+	}
+	defer w.Close()
+	if err := w.Write("Nvidia", nil); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	// Before Close the company is already on disk, only the closing bracket
+	// is missing.
+	data, _ := os.ReadFile(path)
+	var got []companyRelationships
+	if err := json.Unmarshal(append(data, ']'), &got); err != nil || len(got) != 1 || got[0].Company != "Nvidia" {
+		t.Errorf("file before Close = %s, want Nvidia written", data)
+	}
+	if !strings.Contains(string(data), `"relationships":[]`) {
+		t.Errorf("file = %s, want an empty list for a company without relationships", data)
+	}
+}
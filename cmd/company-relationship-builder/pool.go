@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// result is the outcome of processing one company.
+type result struct {
+	index int
+	name  string
+	err   error
+}
+
+// processAll runs process on every name using at most concurrency goroutines.
+// A failure doesn't stop the others. report is called once per name, in the
+// order of names, as soon as that name and all those before it are done.
+func processAll(ctx context.Context, names []string, concurrency int, process func(ctx context.Context, name string) error, report func(name string, err error)) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	jobs := make(chan int)
+	results := make(chan result)
+	var wg sync.WaitGroup
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results <- result{index: i, name: names[i], err: process(ctx, names[i])}
+			}
+		}()
+	}
+	go func() {
+		for i := range names {
+			jobs <- i
+		}
+		close(jobs)
+		wg.Wait()
+		close(results)
+	}()
+
+	// Hold on to results that finish early until their turn comes.
+	pending := make(map[int]result)
+	next := 0
+	for r := range results {
+		pending[r.index] = r
+		for {
+			r, ok := pending[next]
+			if !ok {
+				break
+			}
+			delete(pending, next)
+			report(r.name, r.err)
+			next++
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestProcessAll(t *testing.T) {
+	var names []string
+	for i := range 20 {
+		names = append(names, fmt.Sprintf("company %d", i))
+	}
+	for _, concurrency := range []int{0, 1, 3, 50} {
+		t.Run(fmt.Sprint(concurrency), func(t *testing.T) {
+			var inFlight, maxInFlight atomic.Int32
+			var mu sync.Mutex
+			processed := make(map[string]int)
+			// A stand-in for the agent: later companies finish first, and
+			// every fifth one fails.
+			process := func(ctx context.Context, name string) error {
+				n := inFlight.Add(1)
+				defer inFlight.Add(-1)
+				for {
+					top := maxInFlight.Load()
+					if n <= top || maxInFlight.CompareAndSwap(top, n) {
+						break
+					}
+				}
+				var i int
+				fmt.Sscanf(name, "company %d", &i)
+				time.Sleep(time.Duration(20-i) * time.Millisecond / 4)
+				mu.Lock()
+				processed[name]++
+				mu.Unlock()
+				if i%5 == 0 {
+					return errors.New("no answer")
+				}
+				return nil
+			}
+
+			var reported []string
+			failed := 0
+			processAll(context.Background(), names, concurrency, process, func(name string, err error) {
+				reported = append(reported, name)
+				if err != nil {
+					failed++
+				}
+			})
+
+			want := max(concurrency, 1)
+			if got := int(maxInFlight.Load()); got > want {
+				t.Errorf("%d companies in flight at once, want at most %d", got, want)
+			}
+			if concurrency == 3 && maxInFlight.Load() < 2 {
+				t.Errorf("at most %d company in flight, want them processed in parallel", maxInFlight.Load())
+			}
+			for _, name := range names {
+				if processed[name] != 1 {
+					t.Errorf("%s processed %d times, want once", name, processed[name])
+				}
+			}
+			if !slices.Equal(reported, names) {
+				t.Errorf("reported %q, want every company in input order", reported)
+			}
+			if failed != 4 {
+				t.Errorf("%d failures reported, want 4", failed)
+			}
+		})
+	}
+}
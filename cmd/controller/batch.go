@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nieveai/d-agents/internal/database"
+	"github.com/nieveai/d-agents/internal/worker"
+	pb "github.com/nieveai/d-agents/proto"
+)
+
+// batchPollInterval is how often runBatch checks on the sessions it ran.
+const batchPollInterval = 500 * time.Millisecond
+
+// maxBatchLine is the longest line of batch input, payloads included.
+const maxBatchLine = 10 << 20
+
+// batchRequest is a line of batch input written as JSON. Other lines are run
+// as they are, like lines typed at the prompt.
+type batchRequest struct {
+	// ID is copied to the response, to match the two up.
+	ID      json.RawMessage `json:"id,omitempty"`
+	Command string          `json:"command"`
+	// Payload is added to the payload of the session being edited before
+	// Command runs, so a session can be started and run in two requests.
+	Payload string `json:"payload,omitempty"`
+}
+
+// batchResponse is a line of batch output. Type is "response" for the result
+// of a line of input, "message" for a command finishing in the background and
+// "session" for a session the batch ran, once it is done.
+type batchResponse struct {
+	Type    string          `json:"type"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Line    int             `json:"line,omitempty"`
+	Command string          `json:"command,omitempty"`
+	OK      bool            `json:"ok"`
+	Output  string          `json:"output,omitempty"`
+	Error   string          `json:"error,omitempty"`
+	Session *batchSession   `json:"session,omitempty"`
+}
+
+type batchSession struct {
+	ID               string  `json:"id"`
+	Name             string  `json:"name"`
+	Status           string  `json:"status"`
+	Payload          string  `json:"payload"`
+	PromptTokens     int64   `json:"prompt_tokens"`
+	CompletionTokens int64   `json:"completion_tokens"`
+	EstimatedCost    float64 `json:"estimated_cost"`
+}
+
+// runBatch runs the commands in r, one per line, and writes a JSON response
+// to w for each. Lines that aren't commands are payload, as at the prompt,
+// and have no response. Once r is done, or at /quit, it waits for the
+// sessions the commands ran and writes each with its result. The error says
+// how many commands and sessions failed.
+func runBatch(db database.Datastore, queue *worker.Queue, r io.Reader, w io.Writer) error {
+	var mu sync.Mutex
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	write := func(resp batchResponse) {
+		mu.Lock()
+		defer mu.Unlock()
+		if err := enc.Encode(resp); err != nil {
+			log.Printf("Error writing batch output: %s", err)
+		}
+	}
+	notify = func(msg responseMsg) {
+		write(batchResponse{Type: "message", OK: true, Output: string(msg)})
+	}
+
+	ed := &editor{}
+	var ran []string
+	commandsRun, commandsFailed := 0, 0
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxBatchLine)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := scanner.Text()
+		name, args, isCommand := parseCommand(line)
+		isJSON := strings.HasPrefix(strings.TrimSpace(line), "{")
+		if !isCommand && !isJSON && (ed.inPayload || strings.TrimSpace(line) == "") {
+			execute(db, queue, ed, line)
+			continue
+		}
+
+		req := batchRequest{Command: line}
+		var err error
+		if isJSON {
+			req = batchRequest{}
+			err = json.Unmarshal([]byte(line), &req)
+			if err != nil {
+				err = fmt.Errorf("invalid JSON request: %w", err)
+			} else if req.Payload != "" && ed.session == nil {
+				err = errors.New("no session to add the payload to, use '/session start <agent-id>' first")
+			} else if req.Payload != "" {
+				ed.payload.WriteString(req.Payload)
+				if !strings.HasSuffix(req.Payload, "\n") {
+					ed.payload.WriteString("\n")
+				}
+			}
+			name, args, _ = parseCommand(req.Command)
+		}
+		if err == nil && name == "/quit" {
+			break
+		}
+
+		commandsRun++
+		resp := batchResponse{Type: "response", ID: req.ID, Line: lineNo, Command: req.Command}
+
+		if err == nil && req.Command != "" {
+			target := runTarget(ed, name, args)
+			var out responseMsg
+			out, err = execute(db, queue, ed, req.Command)
+			if out != "`clear`" {
+				resp.Output = string(out)
+			}
+			if err == nil && target != "" && !slices.Contains(ran, target) {
+				if session, _ := db.GetSession(target); session != nil && session.Status == pb.WorkloadStatus_RUNNING {
+					ran = append(ran, target)
+				}
+			}
+		}
+		resp.OK = err == nil
+		if err != nil {
+			resp.Error = err.Error()
+			commandsFailed++
+		}
+		write(resp)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("error reading commands: %w", err)
+	}
+
+	sessionsFailed := 0
+	for _, id := range ran {
+		session, err := waitForSession(db, id)
+		if err != nil {
+			write(batchResponse{Type: "session", Error: fmt.Sprintf("error loading session %s: %s", id, err)})
+			sessionsFailed++
+			continue
+		}
+		resp := batchResponse{
+			Type: "session",
+			OK:   session.Status == pb.WorkloadStatus_COMPLETED,
+			Session: &batchSession{
+				ID:               session.Id,
+				Name:             session.Name,
+				Status:           session.Status.String(),
+				Payload:          string(session.Payload),
+				PromptTokens:     session.PromptTokens,
+				CompletionTokens: session.CompletionTokens,
+				EstimatedCost:    session.EstimatedCost,
+			},
+		}
+		if !resp.OK {
+			resp.Error = session.Error
+			sessionsFailed++
+		}
+		write(resp)
+	}
+
+	if commandsFailed > 0 || sessionsFailed > 0 {
+		return fmt.Errorf("%d of %d commands and %d of %d sessions failed", commandsFailed, commandsRun, sessionsFailed, len(ran))
+	}
+	return nil
+}
+
+// runTarget is the session that /session run with args runs, or "" for any
+// other command.
+func runTarget(ed *editor, name string, args []string) string {
+	if name != "/session" || len(args) == 0 || args[0] != "run" {
+		return ""
+	}
+	if len(args) > 1 {
+		return args[1]
+	}
+	if ed.session != nil {
+		return ed.session.Id
+	}
+	return ""
+}
+
+// waitForSession returns session id once it is no longer running.
+func waitForSession(db database.Datastore, id string) (*pb.Workload, error) {
+	for {
+		session, err := db.GetSession(id)
+		if err != nil || session.Status != pb.WorkloadStatus_RUNNING {
+			return session, err
+		}
+		time.Sleep(batchPollInterval)
+	}
+}
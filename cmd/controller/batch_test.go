@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/nieveai/d-agents/internal/models"
+	"github.com/nieveai/d-agents/internal/testutil"
+	"github.com/nieveai/d-agents/internal/worker"
+)
+
+func TestRunBatch(t *testing.T) {
+	db := newTestController(t)
+	if err := worker.Init(context.Background(), []*models.Model{}, db); err != nil {
+		t.Fatalf("worker.Init: %v", err)
+	}
+	t.Cleanup(func() { worker.Init(context.Background(), []*models.Model{}, nil) })
+	queue := worker.NewQueue(10)
+	client := testutil.NewFakeGenAIClient("Paris.", "Rome.")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		for {
+			workload, ok := queue.Pop(ctx)
+			if !ok {
+				return
+			}
+			worker.ProcessWorkloadWithClient(ctx, workload, client)
+		}
+	}()
+
+	script := strings.Join([]string{
+		"/session start a1 m1",
+		"What is the capital of France?",
+		"/session run",
+		"",
+		`{"id": 2, "command": "/session start a1 m1"}`,
+		`{"id": "q2", "command": "/session run", "payload": "What is the capital of Italy?"}`,
+		"/nope",
+		`{"id": 3, "command": `,
+		"/quit",
+		"/session run",
+	}, "\n")
+	var out bytes.Buffer
+	err := runBatch(db, queue, strings.NewReader(script), &out)
+	if err == nil || err.Error() != "2 of 6 commands and 0 of 2 sessions failed" {
+		t.Errorf("runBatch = %v, want the two bad lines counted", err)
+	}
+
+	var responses []batchResponse
+	dec := json.NewDecoder(&out)
+	for dec.More() {
+		var resp batchResponse
+		if err := dec.Decode(&resp); err != nil {
+			t.Fatalf("output isn't JSON lines: %v", err)
+		}
+		if resp.Type != "message" {
+			responses = append(responses, resp)
+		}
+	}
+	if len(responses) != 8 {
+		t.Fatalf("got %d responses, want 6 commands and 2 sessions: %+v", len(responses), responses)
+	}
+
+	want := []struct {
+		typ  string
+		line int
+		id   string
+		ok   bool
+	}{
+		{"response", 1, "", true},
+		{"response", 3, "", true},
+		{"response", 5, "2", true},
+		{"response", 6, `"q2"`, true},
+		{"response", 7, "", false},
+		{"response", 8, "", false},
+		{"session", 0, "", true},
+		{"session", 0, "", true},
+	}
+	for i, w := range want {
+		resp := responses[i]
+		if resp.Type != w.typ || resp.Line != w.line || string(resp.ID) != w.id || resp.OK != w.ok {
+			t.Errorf("response %d = %+v, want type %s, line %d, id %s, ok %v", i+1, resp, w.typ, w.line, w.id, w.ok)
+		}
+	}
+	if !strings.Contains(responses[4].Error, "Unknown command") || !strings.Contains(responses[5].Error, "invalid JSON request") {
+		t.Errorf("errors = %q, %q", responses[4].Error, responses[5].Error)
+	}
+
+	answers := map[string]string{}
+	for _, resp := range responses[6:] {
+		if resp.Session == nil || resp.Session.Status != "COMPLETED" {
+			t.Fatalf("session result = %+v, want it COMPLETED", resp)
+		}
+		question, answer, _ := strings.Cut(resp.Session.Payload, "\n\n---\n\n")
+		answers[strings.TrimSpace(question)] = answer
+	}
+	if answers["What is the capital of France?"] != "Paris." || answers["What is the capital of Italy?"] != "Rome." {
+		t.Errorf("answers = %q", answers)
+	}
+}
+
+func TestRunBatchPayloadWithoutSession(t *testing.T) {
+	db := newTestController(t)
+	var out bytes.Buffer
+	runBatch(db, worker.NewQueue(1), strings.NewReader(`{"command": "/session run", "payload": "hi"}`), &out)
+	var resp batchResponse
+	if err := json.Unmarshal(out.Bytes(), &resp); err != nil {
+		t.Fatalf("output %q isn't JSON: %v", out.String(), err)
+	}
+	if resp.OK || !strings.Contains(resp.Error, "no session to add the payload to") {
+		t.Errorf("response = %+v, want an error", resp)
+	}
+}
@@ -0,0 +1,365 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nieveai/d-agents/internal/models"
+	"github.com/nieveai/d-agents/internal/testutil"
+	"github.com/nieveai/d-agents/internal/worker"
+	pb "github.com/nieveai/d-agents/proto"
+)
+
+func TestSessionDelete(t *testing.T) {
+	db := newTestController(t)
+	for _, id := range []string{"s1", "s2"} {
+		session := &pb.Workload{Id: id, Name: "prices " + id, Status: pb.WorkloadStatus_COMPLETED}
+		db.AddSession(session)
+		sessions.Store(id, session)
+	}
+	// s1 is open at the prompt.
+	ed := &editor{}
+	execute(db, nil, ed, "/session load s1")
+	if ed.session == nil {
+		t.Fatal("/session load didn't open the session")
+	}
+
+	response, err := execute(db, nil, ed, "/session delete s1")
+	if err != nil || response != "Deleted session s1 (prices s1)." {
+		t.Errorf("/session delete = %q, %v", response, err)
+	}
+	if _, err := db.GetSession("s1"); err == nil {
+		t.Error("s1 is still stored")
+	}
+	if _, ok := sessions.Load("s1"); ok {
+		t.Error("s1 is still cached")
+	}
+	if ed.session != nil {
+		t.Error("the deleted session is still being edited")
+	}
+	if _, err := db.GetSession("s2"); err != nil {
+		t.Errorf("s2 was deleted too: %v", err)
+	}
+
+	if response, _ := execute(db, nil, ed, "/session delete missing"); !strings.HasPrefix(string(response), "Error loading session 'missing'") {
+		t.Errorf("/session delete of a missing session = %q", response)
+	}
+}
+
+func TestSessionClear(t *testing.T) {
+	db := newTestController(t)
+	for id, status := range map[string]pb.WorkloadStatus_Status{
+		"done1":   pb.WorkloadStatus_COMPLETED,
+		"done2":   pb.WorkloadStatus_COMPLETED,
+		"failed":  pb.WorkloadStatus_FAILED,
+		"running": pb.WorkloadStatus_RUNNING,
+	} {
+		session := &pb.Workload{Id: id, Status: status}
+		db.AddSession(session)
+		sessions.Store(id, session)
+	}
+	ed := &editor{}
+
+	// Nothing is deleted without confirm.
+	response, _ := execute(db, nil, ed, "/session clear completed")
+	if response != "This deletes 2 completed session(s). Run '/session clear completed confirm' to go ahead." {
+		t.Errorf("/session clear completed = %q", response)
+	}
+	if all, _ := db.ListSessions(); len(all) != 4 {
+		t.Fatalf("%d sessions left before confirming, want 4", len(all))
+	}
+
+	if response, _ := execute(db, nil, ed, "/session clear completed confirm"); response != "Removed 2 completed session(s)." {
+		t.Errorf("/session clear completed confirm = %q", response)
+	}
+	for _, id := range []string{"done1", "done2"} {
+		if _, ok := sessions.Load(id); ok {
+			t.Errorf("%s is still cached", id)
+		}
+	}
+	all, _ := db.ListSessions()
+	if len(all) != 2 {
+		t.Errorf("%d sessions left, want the failed and running ones", len(all))
+	}
+
+	if response, _ := execute(db, nil, ed, "/session clear cancelled confirm"); response != "Removed 0 cancelled session(s)." {
+		t.Errorf("/session clear cancelled confirm = %q", response)
+	}
+	if response, _ := execute(db, nil, ed, "/session clear running confirm"); !strings.HasPrefix(string(response), "Usage:") {
+		t.Errorf("/session clear running = %q, want running sessions left alone", response)
+	}
+}
+
+func TestSessionPriority(t *testing.T) {
+	db := newTestController(t)
+	ed := &editor{}
+	if response, _ := execute(db, nil, ed, "/session priority high"); !strings.HasPrefix(string(response), "No active session") {
+		t.Errorf("/session priority without a session = %q", response)
+	}
+
+	session := &pb.Workload{Id: "s1"}
+	db.AddSession(session)
+	sessions.Store("s1", session)
+	execute(db, nil, ed, "/session load s1")
+	if ed.session == nil {
+		t.Fatal("/session load didn't open the session")
+	}
+
+	tests := []struct {
+		line, response string
+		want           int32
+	}{
+		{"/session priority high", "Priority for session s1: high", 10},
+		{"/session priority", "Usage: /session priority <low|normal|high|number>\nPriority: high", 10},
+		{"/session priority 3", "Priority for session s1: 3", 3},
+		{"/session priority urgent", `invalid priority "urgent": use low, normal, high or a number`, 3},
+	}
+	for _, tt := range tests {
+		response, err := execute(db, nil, ed, tt.line)
+		if err != nil || string(response) != tt.response {
+			t.Errorf("%s = %q, %v, want %q", tt.line, response, err, tt.response)
+		}
+		if ed.session.Priority != tt.want {
+			t.Errorf("after %s, priority = %d, want %d", tt.line, ed.session.Priority, tt.want)
+		}
+	}
+}
+
+func TestSessionDuplicates(t *testing.T) {
+	db := newTestController(t)
+	ed := &editor{}
+	if response, _ := execute(db, nil, ed, "/session duplicates"); response != "No sessions at least 60% similar to an older one." {
+		t.Errorf("/session duplicates without sessions = %q", response)
+	}
+
+	for _, session := range []*pb.Workload{
+		{Id: "s1", Name: "march", AgentType: "ShoppingAgent", Timestamp: 1, Payload: []byte("Find the cheapest flights from London to New York in March.")},
+		{Id: "s2", Name: "april", AgentType: "ShoppingAgent", Timestamp: 2, Payload: []byte("find the cheapest flights from London to New York in April")},
+		{Id: "s3", Name: "shoes", AgentType: "ShoppingAgent", Timestamp: 3, Payload: []byte("Compare prices of running shoes in size 42.")},
+	} {
+		db.AddSession(session)
+	}
+
+	response, _ := execute(db, nil, ed, "/session duplicates")
+	if want := "  - s2: april looks like s1: march (82% similar)\n"; !strings.HasPrefix(string(response), want) || strings.Contains(string(response), "s3") {
+		t.Errorf("/session duplicates = %q, want only s2 flagged", response)
+	}
+	response, _ = execute(db, nil, ed, "/session duplicates s1")
+	if want := "  - s2: april (82% similar)\n"; !strings.HasPrefix(string(response), want) {
+		t.Errorf("/session duplicates s1 = %q, want s2", response)
+	}
+	if response, _ := execute(db, nil, ed, "/session duplicates s3"); response != "No sessions at least 60% similar to s3." {
+		t.Errorf("/session duplicates s3 = %q", response)
+	}
+	if sessions, _ := db.ListSessions(); len(sessions) != 3 {
+		t.Errorf("%d sessions left, want all 3", len(sessions))
+	}
+}
+
+func TestSessionTags(t *testing.T) {
+	db := newTestController(t)
+	for _, id := range []string{"s1", "s2"} {
+		session := &pb.Workload{Id: id, Name: "prices " + id}
+		db.AddSession(session)
+		sessions.Store(id, session)
+	}
+	ed := &editor{}
+	execute(db, nil, ed, "/session load s1")
+
+	tests := []struct{ line, want string }{
+		{"/session tag s1 Shopping", "Tags of session s1: shopping"},
+		{"/session tag s1 weekly", "Tags of session s1: shopping, weekly"},
+		{"/session tag s2 shopping", "Tags of session s2: shopping"},
+		{"/session tag missing shopping", "Session with ID 'missing' not found."},
+		{"/session tag s1 a,b", `tag "a,b" can only have letters, digits and -_.:/`},
+		{"/session untag s1 weekly", "Tags of session s1: shopping"},
+	}
+	for _, tt := range tests {
+		if response, err := execute(db, nil, ed, tt.line); err != nil || string(response) != tt.want {
+			t.Errorf("%s = %q, %v, want %q", tt.line, response, err, tt.want)
+		}
+	}
+	// The open session and the cached one see the tags too.
+	if !slices.Equal(ed.session.Tags, []string{"shopping"}) {
+		t.Errorf("tags of the open session = %q", ed.session.Tags)
+	}
+	if cached, _ := sessions.Load("s2"); !slices.Equal(cached.Tags, []string{"shopping"}) {
+		t.Errorf("tags of the cached session = %q", cached.Tags)
+	}
+
+	response, _ := execute(db, nil, ed, "/list session shopping")
+	if !strings.Contains(string(response), "s1: prices s1") || !strings.Contains(string(response), "s2: prices s2") || !strings.Contains(string(response), "    Tags: shopping\n") {
+		t.Errorf("/list session shopping = %q, want both sessions with their tags", response)
+	}
+	execute(db, nil, ed, "/session untag s2 shopping")
+	if response, _ := execute(db, nil, ed, "/list session shopping"); strings.Contains(string(response), "s2") {
+		t.Errorf("/list session shopping = %q, want only s1", response)
+	}
+	if response, _ := execute(db, nil, ed, "/list session research"); response != "No sessions tagged 'research'." {
+		t.Errorf("/list session research = %q", response)
+	}
+}
+
+func TestSessionAudit(t *testing.T) {
+	db := newTestController(t)
+	db.AddSession(&pb.Workload{Id: "s1"})
+	db.AddSession(&pb.Workload{Id: "s2"})
+	at := time.Date(2024, 5, 6, 9, 0, 0, 0, time.UTC)
+	db.AddAuditEntry(&models.AuditEntry{SessionID: "s1", ModelID: "m1", SystemPrompt: "be brief", Input: "hello", Output: "hi", PromptTokens: 10, CompletionTokens: 5, Timestamp: at})
+	db.AddAuditEntry(&models.AuditEntry{SessionID: "s1", ModelID: "m1", Input: "again", Error: "quota exceeded", Timestamp: at.Add(time.Minute)})
+	ed := &editor{}
+
+	response, err := execute(db, nil, ed, "/session audit s1")
+	if err != nil {
+		t.Fatalf("/session audit: %v", err)
+	}
+	for _, want := range []string{
+		"Audit trail of session s1, 2 call(s):",
+		"#1 " + at.Local().Format("2006-01-02 15:04:05") + ", model m1, 10 prompt + 5 completion tokens\nSystem prompt:\nbe brief\nInput:\nhello\nOutput:\nhi",
+		"#2 " + at.Add(time.Minute).Local().Format("2006-01-02 15:04:05") + ", model m1, 0 prompt + 0 completion tokens\nInput:\nagain\nError: quota exceeded",
+	} {
+		if !strings.Contains(string(response), want) {
+			t.Errorf("/session audit s1 = %q, want it to contain %q", response, want)
+		}
+	}
+
+	tests := []struct{ line, want string }{
+		{"/session audit s2", "No model calls recorded for session s2."},
+		{"/session audit missing", "Session with ID 'missing' not found."},
+	}
+	for _, tt := range tests {
+		if response, err := execute(db, nil, ed, tt.line); err != nil || string(response) != tt.want {
+			t.Errorf("%s = %q, %v, want %q", tt.line, response, err, tt.want)
+		}
+	}
+}
+
+func TestSessionStartResolvesModels(t *testing.T) {
+	db := newTestController(t)
+	for _, model := range []*models.Model{
+		{ID: "m2", Provider: "openai", ModelID: "gpt-4o", Alias: "fast"},
+		{ID: "m3", Provider: "openai", ModelID: "gpt-4o-mini"},
+	} {
+		if err := db.AddModel(model); err != nil {
+			t.Fatal(err)
+		}
+	}
+	start := func(line string) (responseMsg, []string) {
+		t.Helper()
+		ed := &editor{}
+		response, err := execute(db, nil, ed, line)
+		if err != nil {
+			t.Fatalf("%s: %v", line, err)
+		}
+		if ed.session == nil {
+			return response, nil
+		}
+		t.Cleanup(func() { sessions.Delete(ed.session.Id) })
+		return response, ed.session.Models
+	}
+
+	tests := []struct {
+		line       string
+		wantModels []string
+		wantErr    string
+	}{
+		{"/session start a1 m1", []string{"m1"}, ""},
+		{"/session start a1 fast", []string{"m2"}, ""},
+		{"/session start a1 gpt-4o", []string{"m2"}, ""},
+		{"/session start a1 fast,m3", []string{"m2", "m3"}, ""},
+		{"/session start a1 gpt-4o-mini", nil, `ambiguous model reference: "gpt-4o-mini" matches models m1, m3, use one of their IDs`},
+		{"/session start a1 claude", nil, "claude"},
+		{"/session start a1", nil, "No models given and no default_model setting."},
+	}
+	for _, tt := range tests {
+		response, got := start(tt.line)
+		if tt.wantErr != "" {
+			if got != nil || !strings.Contains(string(response), tt.wantErr) {
+				t.Errorf("%s = %q with models %v, want an error containing %q", tt.line, response, got, tt.wantErr)
+			}
+			continue
+		}
+		if !slices.Equal(got, tt.wantModels) {
+			t.Errorf("%s started a session with models %v (%q), want %v", tt.line, got, response, tt.wantModels)
+		}
+	}
+
+	if response, _ := execute(db, nil, &editor{}, "/settings set default_model gpt-4o-mini"); !strings.Contains(string(response), "ambiguous model reference") {
+		t.Errorf("setting an ambiguous default model = %q, want an error", response)
+	}
+	if response, _ := execute(db, nil, &editor{}, "/settings set default_model fast"); response != "Setting default_model set to fast" {
+		t.Errorf("/settings set default_model fast = %q", response)
+	}
+	if _, got := start("/session start a1"); !slices.Equal(got, []string{"m2"}) {
+		t.Errorf("/session start without models used %v, want the default model m2", got)
+	}
+}
+
+func TestSessionContinueChat(t *testing.T) {
+	for _, format := range []string{"markdown", "plain", "json"} {
+		t.Run(format, func(t *testing.T) {
+			db := newTestController(t)
+			if err := worker.Init(context.Background(), []*models.Model{}, db); err != nil {
+				t.Fatalf("worker.Init: %v", err)
+			}
+			t.Cleanup(func() { worker.Init(context.Background(), []*models.Model{}, nil) })
+			queue := worker.NewQueue(1)
+			client := testutil.NewFakeGenAIClient("Hello! How can I help?", "I'm fine, thanks.")
+			ed := &editor{}
+			// run sends the typed lines like the TUI does and processes the
+			// queued workload.
+			run := func(lines ...string) {
+				t.Helper()
+				for _, line := range append(lines, "/session run") {
+					if _, err := execute(db, queue, ed, line); err != nil {
+						t.Fatalf("%s: %v", line, err)
+					}
+				}
+				ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+				defer cancel()
+				workload, ok := queue.Pop(ctx)
+				if !ok {
+					t.Fatal("nothing was queued")
+				}
+				worker.ProcessWorkloadWithClient(ctx, workload, client)
+			}
+
+			run("/session start a1 m1", `/session config {"output_format":"`+format+`"}`, "hi there")
+			id := ed.session.Id
+			run("/session load "+id, "how are you?")
+
+			call, _ := client.LastCall()
+			want := []string{"user: hi there", "assistant: Hello! How can I help?", "user: how are you?"}
+			var got []string
+			for _, msg := range call.Messages {
+				got = append(got, msg.Role+": "+msg.Content)
+			}
+			if !slices.Equal(got, want) {
+				t.Errorf("second run sent %q, want %q", got, want)
+			}
+			if session, _ := db.GetSession(id); session.Status != pb.WorkloadStatus_COMPLETED || len(session.History) != 4 {
+				t.Errorf("session = %v %q with %d messages of history, want COMPLETED with 4", session.Status, session.Error, len(session.History))
+			}
+		})
+	}
+}
+
+func TestSessionCancelUnschedules(t *testing.T) {
+	db := newTestController(t)
+	db.AddSession(&pb.Workload{Id: "s1", Status: pb.WorkloadStatus_COMPLETED})
+	db.AddSchedule(&models.Schedule{SessionID: "s1", Interval: time.Hour, NextRun: time.Now()})
+	ed := &editor{}
+
+	if response, _ := execute(db, nil, ed, "/session cancel s1"); string(response) != "Cancelled session s1" {
+		t.Errorf("/session cancel s1 = %q", response)
+	}
+	if schedules, _ := db.ListSchedules(); len(schedules) != 0 {
+		t.Errorf("schedules = %+v after cancelling, want none", schedules)
+	}
+	if response, _ := execute(db, nil, ed, "/session cancel s1"); !strings.Contains(string(response), "neither running nor scheduled") {
+		t.Errorf("/session cancel of a cancelled session = %q", response)
+	}
+}
@@ -2,13 +2,17 @@ package main
 
 import (
 	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"os"
+	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/charmbracelet/bubbles/textarea"
 	"github.com/charmbracelet/bubbles/viewport"
@@ -18,41 +22,44 @@ import (
 	
 	"github.com/google/uuid"
 	"github.com/atotto/clipboard"
+	"github.com/nieveai/d-agents/internal/agents"
+	"github.com/nieveai/d-agents/internal/browser"
 	"github.com/nieveai/d-agents/internal/database"
+	"github.com/nieveai/d-agents/internal/health"
+	"github.com/nieveai/d-agents/internal/logging"
+	"github.com/nieveai/d-agents/internal/metrics"
 	"github.com/nieveai/d-agents/internal/models"
+	"github.com/nieveai/d-agents/internal/scheduler"
+	"github.com/nieveai/d-agents/internal/syncmap"
+	"github.com/nieveai/d-agents/internal/textutil"
 	"github.com/nieveai/d-agents/internal/worker"
 	"golang.org/x/text/encoding/unicode"
 	pb "github.com/nieveai/d-agents/proto"
 )
 
-type Config struct {
-	Workers int `json:"workers"`
-}
-
 
-var modelStore = make(map[string]*models.Model)
-var sessions = make(map[string]*pb.Workload)
-var currentSession *pb.Workload
-var inPayloadInputMode = false
-var payloadBuffer strings.Builder
+var modelStore syncmap.Map[string, *models.Model]
+var sessions syncmap.Map[string, *pb.Workload]
+var sessionScheduler *scheduler.Scheduler
 
-type Command func(db *database.SQLiteDatastore, workloadChan chan<- *pb.Workload, args []string) responseMsg
+type Command func(db database.Datastore, queue *worker.Queue, ed *editor, args []string) responseMsg
 
 var commands map[string]Command
 
 type model struct {
-	viewport     viewport.Model
-	messages     []string
-	textarea     textarea.Model
-	senderStyle  lipgloss.Style
-	err          error
-	db           *database.SQLiteDatastore
-	workloadChan chan<- *pb.Workload
+	viewport    viewport.Model
+	messages    []string
+	textarea    textarea.Model
+	senderStyle lipgloss.Style
+	err         error
+	db          database.Datastore
+	queue       *worker.Queue
+	editor      *editor
 }
 
 type responseMsg string
 
-func initialModel(db *database.SQLiteDatastore, workloadChan chan<- *pb.Workload) *model {
+func initialModel(db database.Datastore, queue *worker.Queue) *model {
 	ta := textarea.New()
 	ta.Placeholder = "Type a command ..."
 	ta.Focus()
@@ -75,13 +82,14 @@ Type a command to get started. (e.g. /help)`)
 	ta.KeyMap.InsertNewline.SetEnabled(false)
 
 	return &model{
-		textarea:     ta,
-		messages:     []string{},
-		viewport:     vp,
-		senderStyle:  lipgloss.NewStyle().Foreground(lipgloss.Color("5")),
-		err:          nil,
-		db:           db,
-		workloadChan: workloadChan,
+		textarea:    ta,
+		messages:    []string{},
+		viewport:    vp,
+		senderStyle: lipgloss.NewStyle().Foreground(lipgloss.Color("5")),
+		err:         nil,
+		db:          db,
+		queue:       queue,
+		editor:      &editor{},
 	}
 }
 
@@ -107,30 +115,17 @@ func (m *model) renderMessages() {
 }
 
 func (m *model) processCommand() {
-	input := m.textarea.Value()
-	if strings.HasPrefix(input, "/") {
-		parts := strings.Fields(input)
-		if cmd, ok := commands[parts[0]]; ok {
-			rsm := cmd(m.db, m.workloadChan, parts[1:])
-			if rsm == "`clear`" {
-				m.messages = []string{}
-				m.viewport.SetContent("")
-			} else {
-				m.messages = append(m.messages, string(rsm))
-				m.renderMessages()
-			}
-		} else {
-			m.messages = append(m.messages, "Unknown command. Type /help for a list of commands.")
-			m.renderMessages()
-		}
-	} else {
-		if inPayloadInputMode {
-			payloadBuffer.WriteString(input)
-			payloadBuffer.WriteString("\n")
-		} else {
-			m.messages = append(m.messages, "Invalid command. Please use the format 'type payload' or start a session.")
-			m.renderMessages()
-		}
+	rsm, err := execute(m.db, m.queue, m.editor, m.textarea.Value())
+	switch {
+	case err != nil:
+		m.messages = append(m.messages, err.Error())
+		m.renderMessages()
+	case rsm == "`clear`":
+		m.messages = []string{}
+		m.viewport.SetContent("")
+	case rsm != "":
+		m.messages = append(m.messages, string(rsm))
+		m.renderMessages()
 	}
 }
 
@@ -159,6 +154,12 @@ func (m *model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			m.viewport.GotoBottom()
 		}
 
+	// Results of commands that finish in the background.
+	case responseMsg:
+		m.messages = append(m.messages, string(msg))
+		m.renderMessages()
+		m.viewport.GotoBottom()
+
 	// We handle errors just like any other message
 	case error:
 		m.err = msg
@@ -186,38 +187,91 @@ func (m *model) View() string {
 
 var p *tea.Program
 
+// notify shows the result of a command that finished in the background.
+var notify = func(msg responseMsg) { p.Send(msg) }
+
 func main() {
 	// Command-line flags
 	workers := flag.Int("workers", 0, "Number of workers")
+	listenAddr := flag.String("listen", "", "Address to accept remote workers on (e.g. :50051)")
+	queueDepth := flag.Int("queue-depth", 0, "Maximum number of queued workloads")
+	maxRetries := flag.Int("max-retries", -1, "Number of times a failing workload is retried")
+	workloadTimeout := flag.Duration("workload-timeout", worker.DefaultWorkloadTimeout, "Maximum time a workload may run, retries included (0 disables)")
+	healthAddr := flag.String("health-addr", "", "Address to serve /healthz and /readyz on (e.g. :8081); disabled when empty")
+	metricsAddr := flag.String("metrics-addr", "", "Address to serve Prometheus metrics on (e.g. :9090); disabled when empty")
+	cacheTTL := flag.Duration("llm-cache-ttl", 0, "Cache identical LLM requests for this long (0 disables the cache)")
+	cacheSize := flag.Int("llm-cache-size", worker.DefaultCacheSize, "Maximum number of cached LLM responses")
+	browserTimeout := flag.Duration("browser-timeout", browser.DefaultTimeout, "Give up on a page the agents fetch after this long")
+	reapInterval := flag.Duration("reap-interval", worker.DefaultReapInterval, "How often to look for running workloads whose worker stopped responding")
+	staleAfter := flag.Duration("stale-after", worker.DefaultStaleAfter, "Fail a running workload after this long without a heartbeat (0 disables)")
+	audit := flag.Bool("audit", true, "Record the prompt and response of every model call in the session's audit trail")
+	auditRedactInput := flag.Bool("audit-redact-input", false, "Leave the input out of the audit trail, for sensitive data")
+	agentLimits := flag.String("agent-limits", "", "Most workloads of an agent type run at once, e.g. ShoppingAgent=2,ChatAgent=10 (default the agent_limits setting)")
+	batch := flag.Bool("batch", false, "Run the commands read from stdin, one per line, and print a JSON result for each instead of starting the terminal UI")
+	openDB := database.RegisterFlags(flag.CommandLine)
+	setupLogging := logging.RegisterFlags()
 	flag.Parse()
 
-	// Configuration file
-	config := &Config{}
-	configFile, err := os.Open("config.json")
-	if err == nil {
-		defer configFile.Close()
-		jsonParser := json.NewDecoder(configFile)
-		jsonParser.Decode(config)
+	if err := setupLogging(); err != nil {
+		log.Fatal(err)
 	}
 
-	numWorkers := config.Workers
+	// Database
+	db, err := openDB()
+	if err != nil {
+		log.Fatalf("Error opening database: %s", err)
+	}
+
+	// Settings, seeded from config.json on first run. Flags win over both.
+	if err := database.SeedSettings(db, database.ConfigFile, database.CredentialsFile); err != nil {
+		log.Printf("Error seeding settings: %s", err)
+	}
+	intSetting := func(key string, def int) int {
+		n, err := database.IntSetting(db, key, def)
+		if err != nil {
+			log.Printf("Error reading settings: %s", err)
+		}
+		return n
+	}
+
+	numWorkers := intSetting(database.SettingWorkers, 5)
 	if *workers > 0 {
 		numWorkers = *workers
 	}
 
-	if numWorkers == 0 {
+	if numWorkers <= 0 {
 		numWorkers = 5 // Default value
 	}
 
-	log.Printf("Starting controller with %d workers", numWorkers)
+	depth := intSetting(database.SettingQueueDepth, worker.DefaultQueueDepth)
+	if *queueDepth > 0 {
+		depth = *queueDepth
+	}
+	if depth <= 0 {
+		depth = worker.DefaultQueueDepth
+	}
 
-	// Database
-	db, err := database.NewSQLiteDatastore("d-agents.db")
-	if err != nil {
-		log.Fatalf("Error opening database: %s", err)
+	retries := intSetting(database.SettingMaxRetries, worker.DefaultMaxRetries)
+	if *maxRetries >= 0 {
+		retries = *maxRetries
+	}
+	worker.SetMaxRetries(retries)
+	worker.SetWorkloadTimeout(*workloadTimeout)
+	if *cacheTTL > 0 {
+		worker.SetResponseCache(worker.NewResponseCache(*cacheSize, *cacheTTL))
+	}
+	worker.SetAudit(*audit, *auditRedactInput)
+	if err := worker.ConfigureAgentLimits(*agentLimits, db); err != nil {
+		log.Fatalf("Invalid agent limits: %s", err)
 	}
 
-	
+	log.Printf("Starting controller with %d workers", numWorkers)
+
+	browserOpts := browser.DefaultOptions()
+	browserOpts.Timeout = *browserTimeout
+	pool := browser.NewBrowserPool(browserOpts)
+	defer pool.Close()
+	agents.SetBrowserPool(pool)
 
 	// Load sessions from database
 	dbSessions, err := db.ListSessions()
@@ -225,7 +279,7 @@ func main() {
 		log.Printf("Error loading sessions from database: %s", err)
 	}
 	for _, session := range dbSessions {
-		sessions[session.Id] = session
+		sessions.Store(session.Id, session)
 	}
 
 	// Load models from database
@@ -234,41 +288,147 @@ func main() {
 		log.Printf("Error loading models from database: %s", err)
 	}
 	for _, model := range dbModels {
-		modelStore[model.ID] = model
+		modelStore.Store(model.ID, model)
 	}
 
-	commands = map[string]Command{
-		"/help": func(db *database.SQLiteDatastore, workloadChan chan<- *pb.Workload, args []string) responseMsg {
+	commands = newCommands()
+
+	queue := worker.NewQueue(depth)
+	metrics.SetQueue(queue.Len)
+	if *metricsAddr != "" {
+		go func() {
+			if err := metrics.Serve(*metricsAddr); err != nil {
+				log.Printf("Metrics server stopped: %s", err)
+			}
+		}()
+	}
+	// init the workers.
+	if err := worker.Init(context.Background(), dbModels, db); err != nil {
+		log.Fatalf("Error initializing worker: %s", err)
+	}
+
+	// Start worker goroutines
+	worker.RunWorkers(context.Background(), numWorkers, queue)
+
+	if *healthAddr != "" {
+		checker := health.NewServiceChecker(db)
+		checker.AddLiveness("workers", health.Workers)
+		go func() {
+			if err := checker.Serve(*healthAddr); err != nil {
+				log.Printf("Health server stopped: %s", err)
+			}
+		}()
+	}
+
+	// Pick up workloads interrupted by a previous run.
+	recovered, err := worker.RecoverWorkloads(queue)
+	if err != nil {
+		log.Printf("Error recovering workloads: %s", err)
+	}
+	for _, session := range recovered {
+		sessions.Store(session.Id, session)
+	}
+
+	// Sessions scheduled from the UI controller run from here too.
+	sessionScheduler = scheduler.New(db, queue)
+	go sessionScheduler.Run(context.Background())
+
+	if *staleAfter > 0 {
+		go worker.RunReaper(context.Background(), *reapInterval, *staleAfter)
+	}
+
+	// Remote workers pull from the same queue as the local ones.
+	if *listenAddr != "" {
+		go func() {
+			if err := worker.ServeRemoteWorkers(*listenAddr, queue); err != nil {
+				log.Printf("Remote worker server stopped: %s", err)
+			}
+		}()
+	}
+
+	if *batch {
+		err := runBatch(db, queue, os.Stdin, os.Stdout)
+		pool.Close()
+		if err != nil {
+			log.Printf("Batch failed: %s", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	p = tea.NewProgram(initialModel(db, queue))
+
+	if _, err := p.Run(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// newCommands returns the commands of the prompt, shared by the terminal UI
+// and -batch.
+func newCommands() map[string]Command {
+	return map[string]Command{
+		"/help": func(db database.Datastore, queue *worker.Queue, ed *editor, args []string) responseMsg {
 			helpText := `Available commands: 🇨🇳
  - /help - Show this help message
  - /clear - Clear the screen
  - /list agent - List all registered agents
- - /list session - List all created sessions
+ - /list session [tag] - List all created sessions, or only those with the tag
  - /list model - List all registered models
+ - /list relationships <company> [depth] - List company relationships up to depth hops away
  - /add agent @<filename> - Add an agent from a configuration file
  - /add model @<filename> - Add a model from a configuration file
- - /session start <agent-id> <model-id1,model-id2,...> - Create a new agent workload
+ - /model set <model> <temperature|max_tokens|top_p|rpm|max_input_bytes|alias|response_cleaners> <value|default> - Set a generation parameter, the alias or the comma separated response cleaners
+ - /model test <model> - Check the model's API key, URL and model ID
+ - /session start <agent-id> [model1,model2,...] - Create a new agent workload, with the default model if none given. Models are given by ID, alias or model ID
  - /session run [session-id] - Run the current session or a specific session by ID
  - /session save - Save the current session
+ - /session cancel [session-id] - Cancel the current session or a specific running session
+ - /session config <json> - Set the agent config of the current session
+ - /session pipeline <agent-type1,agent-type2,...|none> - Chain agents on the current session
+ - /session fallback <model1,model2,...|none> - Models to try when the session's model fails
+ - /session dryrun <on|off> - Show what the session would send and store instead of running it
+ - /session priority <low|normal|high|number> - Run the current session before queued ones with a lower priority
  - /session load <workload-id> - Load a session by ID
+ - /session clone <workload-id> - Copy a session into a new one and load it for editing
+ - /session delete <workload-id> - Delete a session
+ - /session clear <completed|failed|cancelled> [confirm] - Delete all sessions with that status
+ - /session duplicates [session-id] - List sessions whose payload looks like an older one's, or like the given session's
+ - /session tag <session-id> <tag> - Label a session, to list it with '/list session <tag>'
+ - /session untag <session-id> <tag> - Remove a label from a session
+ - /session audit <session-id> - Show what was sent to and received from the models for a session
+ - /search <term> - Find saved sessions whose name or payload contain all the words
+ - /settings - List the settings
+ - /settings set <key> <value> - Change a setting; most take effect on the next start
  - /quit - Exit the program`
 			return responseMsg(helpText)
 		},
-		"/quit": func(db *database.SQLiteDatastore, workloadChan chan<- *pb.Workload, args []string) responseMsg {
+		"/quit": func(db database.Datastore, queue *worker.Queue, ed *editor, args []string) responseMsg {
 			os.Exit(0)
 			return "nil"
 		},
-		"/clear": func(db *database.SQLiteDatastore, workloadChan chan<- *pb.Workload, args []string) responseMsg {
+		"/clear": func(db database.Datastore, queue *worker.Queue, ed *editor, args []string) responseMsg {
 			return responseMsg("`clear`")
 		},
-		"/session": func(db *database.SQLiteDatastore, workloadChan chan<- *pb.Workload, args []string) responseMsg {
+		"/session": func(db database.Datastore, queue *worker.Queue, ed *editor, args []string) responseMsg {
 			var response responseMsg
 			if len(args) > 0 {
 				switch args[0] {
 				case "start":
-					if len(args) > 2 {
+					if len(args) > 1 {
 						agentID := args[1]
-						modelIDsRaw := args[2]
+						modelIDsRaw := ""
+						if len(args) > 2 {
+							modelIDsRaw = args[2]
+						} else {
+							defaultModel, err := database.StringSetting(db, database.SettingDefaultModel, "")
+							if err != nil {
+								return responseMsg(err.Error())
+							}
+							if defaultModel == "" {
+								return responseMsg("No models given and no default_model setting. Use '/settings set default_model <model>' to set one.")
+							}
+							modelIDsRaw = defaultModel
+						}
 						agent, err := db.GetAgent(agentID)
 						if err != nil {
 							response = (responseMsg(fmt.Sprintf("Error getting agent with ID '%s': %s", agentID, err)))
@@ -279,11 +439,9 @@ func main() {
 							return response
 						}
 
-						modelIDs := strings.Split(modelIDsRaw, ",")
-						for _, modelID := range modelIDs {
-							if _, ok := modelStore[modelID]; !ok {
-								return(responseMsg(fmt.Sprintf("Model with ID '%s' not found.", modelID)))
-							}
+						modelIDs, err := database.ResolveModels(db, modelIDsRaw)
+						if err != nil {
+							return responseMsg(fmt.Sprintf("Error: %s", err))
 						}
 
 						workloadID := uuid.New().String()
@@ -298,38 +456,48 @@ func main() {
 							Status:      pb.WorkloadStatus_PENDING,
 						}
 
-						sessions[workloadID] = workload
-						currentSession = workload
-						inPayloadInputMode = true
-						payloadBuffer.Reset()
+						sessions.Store(workloadID, workload)
+						ed.session = workload
+						ed.inPayload = true
+						ed.payload.Reset()
 						response=(responseMsg("what would you like the agent to do? Please enter your instruction below."))
 					} else {
-						response=(responseMsg("Usage: /session start <agent-id> <model-id1,model-id2,...>"))
+						response=(responseMsg("Usage: /session start <agent-id> [model1,model2,...]"))
 					}
 
 				case "run":
 					if len(args) > 1 {
 						sessionID := args[1]
-						session, ok := sessions[sessionID]
+						session, ok := sessions.Load(sessionID)
 						if !ok {
 							response=(responseMsg(fmt.Sprintf("Session with ID '%s' not found.", sessionID)))
 							return response
 						}
 						session.Status = pb.WorkloadStatus_RUNNING
+						session.Error = ""
+						session.RetryCount = 0
+						session.LastHeartbeat = 0
+						session.Stage = ""
+						session.Progress = 0
 						db.AddSession(session)
-						workloadChan <- session
+						queue.Push(session)
 						response=(responseMsg(fmt.Sprintf("Running session with workload ID %s", session.Id)))
 					} else {
-						if currentSession != nil {
-							inPayloadInputMode = false
-							payload := payloadBuffer.String()
-							payloadBuffer.Reset()
-
-							currentSession.Payload = []byte(payload)
-							currentSession.Status = pb.WorkloadStatus_RUNNING
-							db.AddSession(currentSession)
-							workloadChan <- currentSession
-							response=(responseMsg(fmt.Sprintf("Running session with workload ID %s", currentSession.Id)))
+						if ed.session != nil {
+							ed.inPayload = false
+							payload := ed.payload.String()
+							ed.payload.Reset()
+
+							ed.session.Payload = []byte(payload)
+							ed.session.Status = pb.WorkloadStatus_RUNNING
+							ed.session.Error = ""
+							ed.session.RetryCount = 0
+							ed.session.LastHeartbeat = 0
+							ed.session.Stage = ""
+							ed.session.Progress = 0
+							db.AddSession(ed.session)
+							queue.Push(ed.session)
+							response=(responseMsg(fmt.Sprintf("Running session with workload ID %s", ed.session.Id)))
 						} else {
 							response=(responseMsg("No active session. Use '/session start <agent-id>' to start one."))
 						}
@@ -337,18 +505,96 @@ func main() {
 					}
 					
 
+				case "cancel":
+					sessionID := ""
+					if len(args) > 1 {
+						sessionID = args[1]
+					} else if ed.session != nil {
+						sessionID = ed.session.Id
+					} else {
+						return responseMsg("Usage: /session cancel [session-id]")
+					}
+					if err := sessionScheduler.Cancel(sessionID); err != nil {
+						return responseMsg(fmt.Sprintf("Error cancelling session: %s", err))
+					}
+					response=(responseMsg(fmt.Sprintf("Cancelled session %s", sessionID)))
 				case "save":
-					if currentSession != nil {
-						inPayloadInputMode = false
-						payload := payloadBuffer.String()
-
-						currentSession.Payload = []byte(payload)
-						db.AddSession(currentSession)
-						sessions[currentSession.Id] = currentSession
-						response=(responseMsg(fmt.Sprintf("Saved session with workload ID %s", currentSession.Id)))
+					if ed.session != nil {
+						ed.inPayload = false
+						payload := ed.payload.String()
+
+						ed.session.Payload = []byte(payload)
+						db.AddSession(ed.session)
+						sessions.Store(ed.session.Id, ed.session)
+						response=(responseMsg(fmt.Sprintf("Saved session with workload ID %s", ed.session.Id)))
 					} else {
 						response=(responseMsg("No active session. Use '/session start <agent-id> <model-id1,model-id2...>' to start one."))
 					}
+				case "config":
+					if ed.session == nil {
+						response=(responseMsg("No active session. Use '/session start <agent-id> <model-id1,model-id2...>' to start one."))
+					} else if len(args) > 1 {
+						ed.session.Config = strings.Join(args[1:], " ")
+						response=(responseMsg(fmt.Sprintf("Config for session %s set to:\n%s", ed.session.Id, ed.session.Config)))
+					} else {
+						response=(responseMsg(fmt.Sprintf("Usage: /session config <json>\nCurrent config: %s", ed.session.Config)))
+					}
+				case "pipeline":
+					if ed.session == nil {
+						response=(responseMsg("No active session. Use '/session start <agent-id> <model-id1,model-id2...>' to start one."))
+					} else if len(args) > 1 {
+						pipeline, err := parsePipeline(args[1])
+						if err != nil {
+							return responseMsg(err.Error())
+						}
+						ed.session.Pipeline = pipeline
+						if len(pipeline) == 0 {
+							response=(responseMsg(fmt.Sprintf("Pipeline cleared for session %s", ed.session.Id)))
+						} else {
+							response=(responseMsg(fmt.Sprintf("Pipeline for session %s set to: %s", ed.session.Id, strings.Join(pipeline, " -> "))))
+						}
+					} else {
+						response=(responseMsg(fmt.Sprintf("Usage: /session pipeline <agent-type1,agent-type2,...|none>\nCurrent pipeline: %s\nAgent types: %s", strings.Join(ed.session.Pipeline, " -> "), strings.Join(models.RegisteredAgentTypes(), ", "))))
+					}
+				case "fallback":
+					if ed.session == nil {
+						response=(responseMsg("No active session. Use '/session start <agent-id> <model-id1,model-id2...>' to start one."))
+					} else if len(args) > 1 {
+						fallback, err := parseFallbackModels(db, args[1])
+						if err != nil {
+							return responseMsg(err.Error())
+						}
+						ed.session.FallbackModels = fallback
+						if len(fallback) == 0 {
+							response=(responseMsg(fmt.Sprintf("Fallback models cleared for session %s", ed.session.Id)))
+						} else {
+							response=(responseMsg(fmt.Sprintf("Fallback models for session %s set to: %s", ed.session.Id, strings.Join(fallback, " -> "))))
+						}
+					} else {
+						response=(responseMsg(fmt.Sprintf("Usage: /session fallback <model1,model2,...|none>\nCurrent fallback models: %s", strings.Join(ed.session.FallbackModels, " -> "))))
+					}
+				case "dryrun":
+					if ed.session == nil {
+						response=(responseMsg("No active session. Use '/session start <agent-id> <model-id1,model-id2...>' to start one."))
+					} else if len(args) > 1 && (args[1] == "on" || args[1] == "off") {
+						ed.session.DryRun = args[1] == "on"
+						response=(responseMsg(fmt.Sprintf("Dry run for session %s: %s", ed.session.Id, args[1])))
+					} else {
+						response=(responseMsg(fmt.Sprintf("Usage: /session dryrun <on|off>\nDry run: %t", ed.session.DryRun)))
+					}
+				case "priority":
+					if ed.session == nil {
+						response=(responseMsg("No active session. Use '/session start <agent-id> <model-id1,model-id2...>' to start one."))
+					} else if len(args) > 1 {
+						priority, err := worker.ParsePriority(args[1])
+						if err != nil {
+							return responseMsg(err.Error())
+						}
+						ed.session.Priority = priority
+						response=(responseMsg(fmt.Sprintf("Priority for session %s: %s", ed.session.Id, worker.PriorityName(priority))))
+					} else {
+						response=(responseMsg(fmt.Sprintf("Usage: /session priority <low|normal|high|number>\nPriority: %s", worker.PriorityName(ed.session.Priority))))
+					}
 				case "load":
 					if len(args) > 1 {
 						sessionID := args[1]
@@ -361,24 +607,87 @@ func main() {
 							response=(responseMsg(fmt.Sprintf("Session with ID '%s' not found.", sessionID)))
 							return response
 						}
-						currentSession = session
-						sessions[session.Id] = session
-						payloadBuffer.Reset()
-						payloadBuffer.Write(session.Payload)
-						inPayloadInputMode = true
-						response=(responseMsg(fmt.Sprintf("Loaded session with ID: %s\nPayload:\n%s", session.Id, string(session.Payload))))
+						ed.session = session
+						sessions.Store(session.Id, session)
+						ed.payload.Reset()
+						ed.payload.Write(session.Payload)
+						ed.inPayload = true
+						response=(responseMsg(fmt.Sprintf("Loaded session with ID: %s\nConfig: %s\nPayload:\n%s", session.Id, session.Config, string(session.Payload))))
 					} else {
 						response=(responseMsg("Usage: /session load <workload-id>"))
 					}
+				case "clone":
+					if len(args) > 1 {
+						session, err := database.CloneSession(db, args[1])
+						if err != nil {
+							return responseMsg(fmt.Sprintf("Error cloning session: %s", err))
+						}
+						ed.session = session
+						sessions.Store(session.Id, session)
+						ed.payload.Reset()
+						ed.payload.Write(session.Payload)
+						ed.inPayload = true
+						response=(responseMsg(fmt.Sprintf("Cloned session %s into %s (%s)\nConfig: %s\nPayload:\n%s", args[1], session.Id, session.Name, session.Config, string(session.Payload))))
+					} else {
+						response=(responseMsg("Usage: /session clone <workload-id>"))
+					}
+				case "delete":
+					sessionID := args[1]
+					session, err := db.GetSession(sessionID)
+					if err != nil {
+						return responseMsg(fmt.Sprintf("Error loading session '%s': %s", sessionID, err))
+					}
+					if session.Status == pb.WorkloadStatus_RUNNING {
+						// Not running here is fine, it may be left over from a crash.
+						worker.CancelWorkload(sessionID)
+					}
+					if err := db.DeleteSession(sessionID); err != nil {
+						return responseMsg(fmt.Sprintf("Error deleting session: %s", err))
+					}
+					forgetSession(ed, sessionID)
+					response=(responseMsg(fmt.Sprintf("Deleted session %s (%s).", sessionID, session.Name)))
+				case "clear":
+					status, ok := clearableStatuses[args[1]]
+					if !ok {
+						return responseMsg("Usage: /session clear <completed|failed|cancelled> [confirm]")
+					}
+					if len(args) < 3 || args[2] != "confirm" {
+						matched, err := database.SessionsWithStatus(db, status)
+						if err != nil {
+							return responseMsg(err.Error())
+						}
+						if len(matched) == 0 {
+							return responseMsg(fmt.Sprintf("No %s sessions to clear.", args[1]))
+						}
+						return responseMsg(fmt.Sprintf("This deletes %d %s session(s). Run '/session clear %s confirm' to go ahead.", len(matched), args[1], args[1]))
+					}
+					deleted, err := database.DeleteSessionsWithStatus(db, status)
+					for _, id := range deleted {
+						forgetSession(ed, id)
+					}
+					if err != nil {
+						return responseMsg(fmt.Sprintf("Removed %d %s session(s) before failing: %s", len(deleted), args[1], err))
+					}
+					response=(responseMsg(fmt.Sprintf("Removed %d %s session(s).", len(deleted), args[1])))
+				case "duplicates":
+					sessionID := ""
+					if len(args) > 1 {
+						sessionID = args[1]
+					}
+					return responseMsg(duplicatesText(db, sessionID))
+				case "tag", "untag":
+					return responseMsg(tagText(db, ed, args[1], args[2], args[0] == "untag"))
+				case "audit":
+					return responseMsg(auditText(db, args[1]))
 				default:
-					response=(responseMsg("Unknown command for /session. Available commands: start, run, save, load"))
+					response=(responseMsg("Unknown command for /session. Available commands: start, run, cancel, save, config, pipeline, fallback, dryrun, priority, load, clone, delete, clear, duplicates, tag, untag, audit"))
 				}
 			} else {
-				response=(responseMsg("Usage: /session <start|run|save|load>"))
+				response=(responseMsg("Usage: /session <start|run|cancel|save|config|pipeline|fallback|dryrun|priority|load|clone|delete|clear|duplicates|tag|untag|audit>"))
 			}
 			return response
 		},
-		"/list": func(db *database.SQLiteDatastore, workloadChan chan<- *pb.Workload, args []string) responseMsg {
+		"/list": func(db database.Datastore, queue *worker.Queue, ed *editor, args []string) responseMsg {
 			var response responseMsg
 			if len(args) > 0 {
 				switch args[0] {
@@ -395,55 +704,252 @@ func main() {
 					var builder strings.Builder
 					for _, agent := range dbAgents {
 						builder.WriteString(fmt.Sprintf("  - %s: %s (%s)\n    Description: %s\n", agent.ID, agent.Name, agent.Type, agent.Description))
+						if agent.SystemPrompt != "" {
+							builder.WriteString(fmt.Sprintf("    System prompt: %s\n", textutil.Preview(agent.SystemPrompt, 80)))
+						}
 					}
 					response=(responseMsg(builder.String()))
 
 				case "session":
-					dbSessions, err := db.ListSessions()
+					var dbSessions []*pb.Workload
+					var err error
+					if len(args) > 1 {
+						dbSessions, err = db.ListSessionsByTag(args[1])
+					} else {
+						dbSessions, err = db.ListSessions()
+					}
 					if err != nil {
 						response=(responseMsg(fmt.Sprintf("Error loading sessions from database: %s", err)))
 						return response
 					}
 					if len(dbSessions) == 0 {
+						if len(args) > 1 {
+							return responseMsg(fmt.Sprintf("No sessions tagged '%s'.", args[1]))
+						}
 						response=(responseMsg("No sessions created."))
 						return response
 					}
+					threshold, err := database.DuplicateThreshold(db)
+					if err != nil {
+						return responseMsg(err.Error())
+					}
+					duplicates := database.FindDuplicates(dbSessions, threshold)
 					var builder strings.Builder
 					for _, session := range dbSessions {
-						payload := string(session.Payload)
-						if len(payload) > 50 {
-							payload = payload[:50] + "..."
+						payload := textutil.Preview(string(session.Payload), 50)
+						builder.WriteString(fmt.Sprintf("  - %s: %s (%s)\n    Payload: %s\n", session.Id, session.Name, statusText(session.Status)+progressText(session), payload))
+						if len(session.Tags) > 0 {
+							builder.WriteString(fmt.Sprintf("    Tags: %s\n", strings.Join(session.Tags, ", ")))
+						}
+						if len(session.Pipeline) > 0 {
+							builder.WriteString(fmt.Sprintf("    Pipeline: %s\n", strings.Join(session.Pipeline, " -> ")))
+						}
+						if len(session.FallbackModels) > 0 {
+							builder.WriteString(fmt.Sprintf("    Fallback models: %s\n", strings.Join(session.FallbackModels, " -> ")))
+						}
+						if session.DryRun {
+							builder.WriteString("    Dry run\n")
+						}
+						if duplicate, ok := duplicates[session.Id]; ok {
+							builder.WriteString(fmt.Sprintf("    Possible duplicate of %s (%.0f%% similar)\n", duplicate.Session.Id, duplicate.Similarity*100))
+						}
+						if session.Status == pb.WorkloadStatus_RUNNING && session.Stage != "" {
+							builder.WriteString(fmt.Sprintf("    Stage: %s\n", session.Stage))
+						}
+						if (session.Status == pb.WorkloadStatus_FAILED || session.Status == pb.WorkloadStatus_CANCELLED) && session.Error != "" {
+							builder.WriteString(fmt.Sprintf("    Error: %s\n", session.Error))
+						}
+						if session.PromptTokens > 0 || session.CompletionTokens > 0 {
+							builder.WriteString(fmt.Sprintf("    Tokens: %d prompt / %d completion (~$%.4f)\n", session.PromptTokens, session.CompletionTokens, session.EstimatedCost))
 						}
-						builder.WriteString(fmt.Sprintf("  - %s: %s (%s)\n    Payload: %s\n", session.Id, session.Name, session.Status, payload))
 					}
 					response=(responseMsg(builder.String()))
 
 				case "model":
-					if len(modelStore) == 0 {
+					if modelStore.Len() == 0 {
 						response=(responseMsg("No models registered."))
 						return response
 					}
 					var builder strings.Builder
-					for _, model := range modelStore {
+					for _, model := range modelStore.Values() {
 						builder.WriteString(fmt.Sprintf("  - %s: %s/%s\n", model.ID, model.Provider, model.ModelID))
+						if model.Alias != "" {
+							builder.WriteString(fmt.Sprintf("    Alias: %s\n", model.Alias))
+						}
+						if len(model.ResponseCleaners) > 0 {
+							builder.WriteString(fmt.Sprintf("    Response Cleaners: %s\n", strings.Join(model.ResponseCleaners, ", ")))
+						}
 						if model.APIURL != "" {
 							builder.WriteString(fmt.Sprintf("    API URL: %s\n", model.APIURL))
 						}
 						if model.APISpec != "" {
 							builder.WriteString(fmt.Sprintf("    API Spec: %s\n", model.APISpec))
 						}
+						if model.EnableWebSearch {
+							builder.WriteString("    Web Search: enabled\n")
+						}
+						if model.Temperature != nil {
+							builder.WriteString(fmt.Sprintf("    Temperature: %g\n", *model.Temperature))
+						}
+						if model.MaxTokens != nil {
+							builder.WriteString(fmt.Sprintf("    Max Tokens: %d\n", *model.MaxTokens))
+						}
+						if model.TopP != nil {
+							builder.WriteString(fmt.Sprintf("    Top P: %g\n", *model.TopP))
+						}
+					}
+					response=(responseMsg(builder.String()))
+
+				case "relationships":
+					if len(args) < 2 {
+						return responseMsg("Usage: /list relationships <company> [depth]")
+					}
+					company := args[1]
+					depth := 1
+					if len(args) > 2 {
+						// Company names often contain spaces, so a trailing number is the depth.
+						if n, err := strconv.Atoi(args[len(args)-1]); err == nil {
+							depth = n
+							company = strings.Join(args[1:len(args)-1], " ")
+						} else {
+							company = strings.Join(args[1:], " ")
+						}
+					}
+					companyAgent, err := agents.NewCompanyRelationshipAgent()
+					if err != nil {
+						return responseMsg(fmt.Sprintf("Error creating company relationship agent: %s", err))
+					}
+					related, err := companyAgent.GetRelated(company, depth)
+					if err != nil {
+						return responseMsg(fmt.Sprintf("Error loading relationships: %s", err))
+					}
+					if len(related) == 0 {
+						return responseMsg(fmt.Sprintf("No relationships found for %s.", company))
+					}
+					var builder strings.Builder
+					for _, rel := range related {
+						builder.WriteString(fmt.Sprintf("  - %s -[%s]-> %s\n", rel.Source, rel.Type, rel.Target))
 					}
 					response=(responseMsg(builder.String()))
 
 				default:
-					response=(responseMsg("Unknown subcommand for /list. Try '/list agent', '/list session', or '/list model'"))
+					response=(responseMsg("Unknown subcommand for /list. Try '/list agent', '/list session', '/list model', or '/list relationships <company>'"))
 				}
 			} else {
-				response=(responseMsg("Usage: /list <agent|session|model>"))
+				response=(responseMsg("Usage: /list <agent|session|model|relationships>"))
 			}
 			return response
 		},
-		"/add": func(db *database.SQLiteDatastore, workloadChan chan<- *pb.Workload, args []string) responseMsg {
+		"/search": func(db database.Datastore, queue *worker.Queue, ed *editor, args []string) responseMsg {
+			if len(args) == 0 {
+				return responseMsg("Usage: /search <term>")
+			}
+			query := strings.Join(args, " ")
+			found, err := db.SearchSessions(query)
+			if err != nil {
+				return responseMsg(fmt.Sprintf("Error searching sessions: %s", err))
+			}
+			if len(found) == 0 {
+				return responseMsg(fmt.Sprintf("No sessions mention '%s'.", query))
+			}
+			var builder strings.Builder
+			for _, session := range found {
+				builder.WriteString(fmt.Sprintf("  - %s: %s (%s, %s)\n    %s\n", session.Id, session.Name, statusText(session.Status),
+					time.Unix(session.Timestamp, 0).Format("2006-01-02 15:04"), searchSnippet(string(session.Payload), args[0])))
+			}
+			return responseMsg(builder.String())
+		},
+		"/settings": func(db database.Datastore, queue *worker.Queue, ed *editor, args []string) responseMsg {
+			if len(args) == 0 {
+				settings, err := db.ListSettings()
+				if err != nil {
+					return responseMsg(fmt.Sprintf("Error loading settings: %s", err))
+				}
+				var builder strings.Builder
+				for _, info := range database.KnownSettings {
+					value, ok := settings[info.Key]
+					switch {
+					case !ok:
+						value = "(not set)"
+					case info.Secret:
+						value = "********"
+					}
+					builder.WriteString(fmt.Sprintf("  - %s: %s\n    %s\n", info.Key, value, info.Description))
+				}
+				return responseMsg(builder.String())
+			}
+			if args[0] != "set" || len(args) != 3 {
+				return responseMsg("Usage: /settings [set <key> <value>]")
+			}
+			key, value := args[1], args[2]
+			if err := database.ValidateSetting(key, value); err != nil {
+				return responseMsg(err.Error())
+			}
+			if key == database.SettingDefaultModel {
+				if _, err := database.ResolveModels(db, value); err != nil {
+					return responseMsg(fmt.Sprintf("Error: %s", err))
+				}
+			}
+			if err := db.SetSetting(key, value); err != nil {
+				return responseMsg(fmt.Sprintf("Error saving setting: %s", err))
+			}
+			if key == database.SettingDefaultModel {
+				return responseMsg(fmt.Sprintf("Setting %s set to %s", key, value))
+			}
+			return responseMsg(fmt.Sprintf("Setting %s saved, restart the controller to apply it", key))
+		},
+		"/model": func(db database.Datastore, queue *worker.Queue, ed *editor, args []string) responseMsg {
+			if len(args) == 0 {
+				return responseMsg("Usage:\n - /model set <model> <temperature|max_tokens|top_p|rpm|max_input_bytes|alias|response_cleaners> <value|default>\n - /model test <model>")
+			}
+			switch args[0] {
+			case "set":
+				if len(args) != 4 {
+					return responseMsg("Usage: /model set <model> <temperature|max_tokens|top_p|rpm|max_input_bytes|alias|response_cleaners> <value|default>")
+				}
+				model, err := database.ResolveModel(db, args[1])
+				if err != nil {
+					return responseMsg(fmt.Sprintf("Error: %s", err))
+				}
+				updated := *model
+				if err := setModelParam(&updated, args[2], args[3]); err != nil {
+					return responseMsg(fmt.Sprintf("Error: %s", err))
+				}
+				if err := updated.Validate(); err != nil {
+					return responseMsg(err.Error())
+				}
+				if err := database.CheckModelAlias(db, &updated); err != nil {
+					return responseMsg(fmt.Sprintf("Error: %s", err))
+				}
+				if err := db.UpdateModel(&updated); err != nil {
+					return responseMsg(fmt.Sprintf("Error updating model: %s", err))
+				}
+				modelStore.Store(updated.ID, &updated)
+				if err := worker.ReloadModels(context.Background()); err != nil {
+					log.Printf("Error reinitializing LLM client: %s", err)
+				}
+				return responseMsg(fmt.Sprintf("Set %s of model '%s' to %s.", args[2], updated.ID, args[3]))
+			case "test":
+				if len(args) != 2 {
+					return responseMsg("Usage: /model test <model>")
+				}
+				model, err := database.ResolveModel(db, args[1])
+				if err != nil {
+					return responseMsg(fmt.Sprintf("Error: %s", err))
+				}
+				go func() {
+					if err := worker.TestModel(context.Background(), model); err != nil {
+						notify(responseMsg(fmt.Sprintf("Model '%s' failed: %s", model.ID, err)))
+						return
+					}
+					notify(responseMsg(fmt.Sprintf("Model '%s' works.", model.ID)))
+				}()
+				return responseMsg(fmt.Sprintf("Testing model '%s'...", model.ID))
+			default:
+				return responseMsg("Unknown subcommand for /model. Available commands: set, test")
+			}
+		},
+		"/add": func(db database.Datastore, queue *worker.Queue, ed *editor, args []string) responseMsg {
 			var response responseMsg
 			if len(args) > 0 {
 				switch args[0] {
@@ -464,13 +970,21 @@ func main() {
 							return response
 						}
 
+						if err := agent.Validate(); err != nil {
+							return responseMsg(err.Error())
+						}
+						_, err = db.GetAgent(agent.ID)
+						existed := err == nil
 						if err := db.AddAgent(&agent); err != nil {
 							response=(responseMsg(fmt.Sprintf("Error adding agent to database: %s", err)))
 							return response
 						}
 
-						
-						response=(responseMsg(fmt.Sprintf("Agent '%s' with ID '%s' added.", agent.Name, agent.ID)))
+						if existed {
+							response=(responseMsg(fmt.Sprintf("Agent '%s' with ID '%s' updated.", agent.Name, agent.ID)))
+						} else {
+							response=(responseMsg(fmt.Sprintf("Agent '%s' with ID '%s' added.", agent.Name, agent.ID)))
+						}
 					} else {
 						response=(responseMsg("Usage: /add agent @<filename>"))
 					}
@@ -491,13 +1005,27 @@ func main() {
 							return response
 						}
 
+						if err := model.Validate(); err != nil {
+							return responseMsg(err.Error())
+						}
+						if err := database.CheckModelAlias(db, &model); err != nil {
+							return responseMsg(err.Error())
+						}
+						_, existed := modelStore.Load(model.ID)
 						if err := db.AddModel(&model); err != nil {
 							response=(responseMsg(fmt.Sprintf("Error adding model to database: %s", err)))
 							return response
 						}
 
-						modelStore[model.ID] = &model
-						response=(responseMsg(fmt.Sprintf("Model '%s' with ID '%s' added.", model.ModelID, model.ID)))
+						modelStore.Store(model.ID, &model)
+						if err := worker.ReloadModels(context.Background()); err != nil {
+							log.Printf("Error reinitializing LLM client: %s", err)
+						}
+						if existed {
+							response=(responseMsg(fmt.Sprintf("Model '%s' with ID '%s' updated.", model.ModelID, model.ID)))
+						} else {
+							response=(responseMsg(fmt.Sprintf("Model '%s' with ID '%s' added.", model.ModelID, model.ID)))
+						}
 					} else {
 						response=(responseMsg("Usage: /add model @<filename>"))
 					}
@@ -510,29 +1038,279 @@ func main() {
 			return response
 		},
 	}
+}
 
-	workloadChan := make(chan *pb.Workload)
-	// init the workers.
-	if err := worker.Init(context.Background(), dbModels, db); err != nil {
-		log.Fatalf("Error initializing worker: %s", err)
+// clearableStatuses are the statuses /session clear deletes by.
+var clearableStatuses = map[string]pb.WorkloadStatus_Status{
+	"completed": pb.WorkloadStatus_COMPLETED,
+	"failed":    pb.WorkloadStatus_FAILED,
+	"cancelled": pb.WorkloadStatus_CANCELLED,
+}
+
+// forgetSession drops a deleted session from the cache, and from the editor
+// if it was being edited.
+func forgetSession(ed *editor, id string) {
+	sessions.Delete(id)
+	if ed.session != nil && ed.session.Id == id {
+		ed.session = nil
+		ed.inPayload = false
+		ed.payload.Reset()
 	}
+}
 
-	// Start worker goroutines
-	for i := 0; i < numWorkers; i++ {
-		go runWorker(i, workloadChan)
+// duplicatesText lists the sessions similar to session id, or every session
+// that looks like a duplicate of an older one when id is empty. Nothing is
+// deleted, that is left to /session delete.
+func duplicatesText(db database.Datastore, id string) string {
+	threshold, err := database.DuplicateThreshold(db)
+	if err != nil {
+		return err.Error()
+	}
+	if threshold <= 0 {
+		threshold = database.DefaultDuplicateThreshold
 	}
 
-	p = tea.NewProgram(initialModel(db, workloadChan))
+	var builder strings.Builder
+	if id != "" {
+		similar, err := database.SimilarSessions(db, id, threshold)
+		if err != nil {
+			return err.Error()
+		}
+		if len(similar) == 0 {
+			return fmt.Sprintf("No sessions at least %.0f%% similar to %s.", threshold*100, id)
+		}
+		for _, s := range similar {
+			builder.WriteString(fmt.Sprintf("  - %s: %s (%.0f%% similar)\n", s.Session.Id, s.Session.Name, s.Similarity*100))
+		}
+	} else {
+		sessions, err := db.ListSessions()
+		if err != nil {
+			return fmt.Sprintf("Error loading sessions from database: %s", err)
+		}
+		duplicates := database.FindDuplicates(sessions, threshold)
+		if len(duplicates) == 0 {
+			return fmt.Sprintf("No sessions at least %.0f%% similar to an older one.", threshold*100)
+		}
+		for _, session := range sessions {
+			if duplicate, ok := duplicates[session.Id]; ok {
+				builder.WriteString(fmt.Sprintf("  - %s: %s looks like %s: %s (%.0f%% similar)\n", session.Id, session.Name, duplicate.Session.Id, duplicate.Session.Name, duplicate.Similarity*100))
+			}
+		}
+	}
+	builder.WriteString("Use '/session delete <workload-id>' to remove the ones you don't need.")
+	return builder.String()
+}
 
-	if _, err := p.Run(); err != nil {
-		log.Fatal(err)
+// tagText adds tag to session id, or removes it when remove is set, and
+// updates the copies of the session the controller holds.
+func tagText(db database.Datastore, ed *editor, id, tag string, remove bool) string {
+	tag, err := database.NormalizeTag(tag)
+	if err != nil {
+		return err.Error()
+	}
+	update := db.AddTag
+	if remove {
+		update = db.RemoveTag
+	}
+	if err := update(id, tag); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Sprintf("Session with ID '%s' not found.", id)
+		}
+		return fmt.Sprintf("Error updating tags of session %s: %s", id, err)
+	}
+	session, err := db.GetSession(id)
+	if err != nil {
+		return fmt.Sprintf("Error loading session '%s': %s", id, err)
+	}
+	if cached, ok := sessions.Load(id); ok {
+		cached.Tags = session.Tags
+	}
+	if ed.session != nil && ed.session.Id == id {
+		ed.session.Tags = session.Tags
+	}
+	if len(session.Tags) == 0 {
+		return fmt.Sprintf("Session %s has no tags.", id)
+	}
+	return fmt.Sprintf("Tags of session %s: %s", id, strings.Join(session.Tags, ", "))
+}
+
+// auditText shows the model calls made for session id, oldest first, as they
+// were recorded.
+func auditText(db database.Datastore, id string) string {
+	entries, err := db.ListAuditEntries(id)
+	if err != nil {
+		return fmt.Sprintf("Error loading the audit trail of session %s: %s", id, err)
+	}
+	if len(entries) == 0 {
+		if session, err := db.GetSession(id); err != nil || session == nil {
+			return fmt.Sprintf("Session with ID '%s' not found.", id)
+		}
+		return fmt.Sprintf("No model calls recorded for session %s.", id)
+	}
+
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "Audit trail of session %s, %d call(s):\n", id, len(entries))
+	for i, entry := range entries {
+		fmt.Fprintf(&builder, "\n#%d %s, model %s, %d prompt + %d completion tokens\n", i+1, entry.Timestamp.Local().Format("2006-01-02 15:04:05"), entry.ModelID, entry.PromptTokens, entry.CompletionTokens)
+		if entry.SystemPrompt != "" {
+			fmt.Fprintf(&builder, "System prompt:\n%s\n", entry.SystemPrompt)
+		}
+		fmt.Fprintf(&builder, "Input:\n%s\n", entry.Input)
+		if entry.Error != "" {
+			fmt.Fprintf(&builder, "Error: %s\n", entry.Error)
+		} else {
+			fmt.Fprintf(&builder, "Output:\n%s\n", entry.Output)
+		}
+	}
+	return strings.TrimSuffix(builder.String(), "\n")
+}
+
+// progressText shows how far along a running session is, e.g. " [45%]".
+func progressText(session *pb.Workload) string {
+	if session.Status != pb.WorkloadStatus_RUNNING {
+		return ""
 	}
+	return fmt.Sprintf(" [%d%%]", session.Progress)
 }
 
-func runWorker(id int, workloadChan <-chan *pb.Workload) {
-	for workload := range workloadChan {
-		log.Printf("Worker %d processing workload: %s", id, strings.Join(workload.Models, ","))
-		worker.ProcessWorkload(workload)
+// statusText marks a session status with a symbol so finished sessions stand
+// out in listings.
+func statusText(status pb.WorkloadStatus_Status) string {
+	switch status {
+	case pb.WorkloadStatus_COMPLETED:
+		return "✔ " + status.String()
+	case pb.WorkloadStatus_FAILED:
+		return "✘ " + status.String()
+	case pb.WorkloadStatus_CANCELLED:
+		return "⊘ " + status.String()
+	case pb.WorkloadStatus_RUNNING:
+		return "▶ " + status.String()
+	default:
+		return status.String()
+	}
+}
+
+// searchSnippet returns the part of text around the first mention of term,
+// on one line.
+func searchSnippet(text string, term string) string {
+	const around = 40
+	text = strings.Join(strings.Fields(text), " ")
+	i := strings.Index(strings.ToLower(text), strings.ToLower(term))
+	if i < 0 || i > len(text) {
+		i = 0
+	}
+	start, end := max(i-around, 0), min(i+len(term)+around, len(text))
+	// Don't cut a multi-byte character in half.
+	for start > 0 && !utf8.RuneStart(text[start]) {
+		start--
+	}
+	for end < len(text) && !utf8.RuneStart(text[end]) {
+		end++
+	}
+	snippet := text[start:end]
+	if start > 0 {
+		snippet = "..." + snippet
+	}
+	if end < len(text) {
+		snippet += "..."
+	}
+	return snippet
+}
+
+// parsePipeline parses a comma separated list of agent types. "none" clears
+// the pipeline.
+func parsePipeline(raw string) ([]string, error) {
+	if raw == "none" {
+		return nil, nil
+	}
+	known := make(map[string]bool)
+	for _, agentType := range models.RegisteredAgentTypes() {
+		known[agentType] = true
+	}
+	var pipeline []string
+	for _, agentType := range strings.Split(raw, ",") {
+		agentType = strings.TrimSpace(agentType)
+		if agentType == "" {
+			continue
+		}
+		if !known[agentType] {
+			return nil, fmt.Errorf("unknown agent type: %s", agentType)
+		}
+		pipeline = append(pipeline, agentType)
+	}
+	return pipeline, nil
+}
+
+// parseFallbackModels parses a comma separated list of model IDs, aliases or
+// ModelIDs to model IDs. "none" clears the list.
+func parseFallbackModels(db database.Datastore, raw string) ([]string, error) {
+	if raw == "none" {
+		return nil, nil
+	}
+	return database.ResolveModels(db, raw)
+}
+
+// setModelParam sets a generation parameter from its string form. "default"
+// clears it so the provider default is used.
+func setModelParam(model *models.Model, name string, value string) error {
+	switch name {
+	case "temperature", "top_p":
+		var v *float64
+		if value != "default" {
+			f, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return fmt.Errorf("invalid %s '%s': %w", name, value, err)
+			}
+			v = &f
+		}
+		if name == "temperature" {
+			model.Temperature = v
+		} else {
+			model.TopP = v
+		}
+	case "max_tokens":
+		model.MaxTokens = nil
+		if value != "default" {
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("invalid max_tokens '%s': %w", value, err)
+			}
+			model.MaxTokens = &n
+		}
+	case "rpm":
+		model.RPM = 0
+		if value != "default" {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				return fmt.Errorf("invalid rpm '%s', expected a number of requests per minute", value)
+			}
+			model.RPM = n
+		}
+	case "alias":
+		model.Alias = ""
+		if value != "default" {
+			model.Alias = value
+		}
+	case "response_cleaners":
+		model.ResponseCleaners = nil
+		if value != "default" {
+			for _, name := range strings.Split(value, ",") {
+				if name = strings.TrimSpace(name); name != "" {
+					model.ResponseCleaners = append(model.ResponseCleaners, name)
+				}
+			}
+		}
+	case "max_input_bytes":
+		model.MaxInputBytes = 0
+		if value != "default" {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				return fmt.Errorf("invalid max_input_bytes '%s', expected a number of bytes", value)
+			}
+			model.MaxInputBytes = n
+		}
+	default:
+		return fmt.Errorf("unknown parameter '%s', expected temperature, max_tokens, top_p, rpm, max_input_bytes, alias or response_cleaners", name)
 	}
-	log.Printf("Worker %d shutting down", id)
+	return nil
 }
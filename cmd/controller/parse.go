@@ -0,0 +1,125 @@
+package main
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/nieveai/d-agents/internal/database"
+	"github.com/nieveai/d-agents/internal/worker"
+	pb "github.com/nieveai/d-agents/proto"
+)
+
+// editor is the session being written at the prompt. Each model has its own,
+// so nothing else can switch the session or mix into the payload.
+type editor struct {
+	session *pb.Workload
+	// inPayload is set while lines that aren't commands go to payload.
+	inPayload bool
+	payload   strings.Builder
+}
+
+// argSpec is how many arguments a command takes after its name, and
+// subcommand if it has one. max is -1 when there is no limit.
+type argSpec struct {
+	min, max int
+	usage    string
+}
+
+// commandArgs is checked before a command runs, so handlers never see the
+// wrong number of arguments. Commands and subcommands not listed here check
+// their own.
+var commandArgs = map[string]argSpec{
+	"/help":               {0, 0, "/help"},
+	"/quit":               {0, 0, "/quit"},
+	"/clear":              {0, 0, "/clear"},
+	"/session start":      {1, 2, "/session start <agent-id> [model1,model2,...]"},
+	"/session run":        {0, 1, "/session run [session-id]"},
+	"/session cancel":     {0, 1, "/session cancel [session-id]"},
+	"/session save":       {0, 0, "/session save"},
+	"/session config":     {0, -1, "/session config <json>"},
+	"/session pipeline":   {0, 1, "/session pipeline <agent-type1,agent-type2,...|none>"},
+	"/session fallback":   {0, 1, "/session fallback <model1,model2,...|none>"},
+	"/session dryrun":     {0, 1, "/session dryrun <on|off>"},
+	"/session priority":   {0, 1, "/session priority <low|normal|high|number>"},
+	"/session load":       {1, 1, "/session load <workload-id>"},
+	"/session clone":      {1, 1, "/session clone <workload-id>"},
+	"/session delete":     {1, 1, "/session delete <workload-id>"},
+	"/session clear":      {1, 2, "/session clear <completed|failed|cancelled> [confirm]"},
+	"/session duplicates": {0, 1, "/session duplicates [session-id]"},
+	"/session tag":        {2, 2, "/session tag <session-id> <tag>"},
+	"/session untag":      {2, 2, "/session untag <session-id> <tag>"},
+	"/session audit":      {1, 1, "/session audit <session-id>"},
+	"/list agent":         {0, 0, "/list agent"},
+	"/list session":       {0, 1, "/list session [tag]"},
+	"/list model":         {0, 0, "/list model"},
+	"/list relationships": {1, -1, "/list relationships <company> [depth]"},
+	"/search":             {1, -1, "/search <term>"},
+	"/settings set":       {2, 2, "/settings set <key> <value>"},
+	"/model set":          {3, 3, "/model set <model> <temperature|max_tokens|top_p|rpm|max_input_bytes|alias|response_cleaners> <value|default>"},
+	"/model test":         {1, 1, "/model test <model>"},
+	"/add agent":          {1, 1, "/add agent @<filename>"},
+	"/add model":          {1, 1, "/add model @<filename>"},
+}
+
+// parseCommand splits a command line into the command name and its
+// arguments, ignoring extra whitespace. ok is false when line isn't a command.
+func parseCommand(line string) (name string, args []string, ok bool) {
+	parts := strings.Fields(line)
+	if len(parts) == 0 || !strings.HasPrefix(parts[0], "/") {
+		return "", nil, false
+	}
+	return parts[0], parts[1:], true
+}
+
+// checkArgs reports whether args fit the command, and its usage when not.
+func checkArgs(name string, args []string) (string, bool) {
+	spec, ok := argSpec{}, false
+	rest := args
+	if len(args) > 0 {
+		spec, ok = commandArgs[name+" "+args[0]]
+		rest = args[1:]
+	}
+	if !ok {
+		spec, ok = commandArgs[name]
+		rest = args
+	}
+	if !ok {
+		return "", true
+	}
+	if len(rest) < spec.min || (spec.max >= 0 && len(rest) > spec.max) {
+		return "Usage: " + spec.usage, false
+	}
+	return "", true
+}
+
+var (
+	errNotCommand     = errors.New("Invalid command. Please use the format 'type payload' or start a session.")
+	errUnknownCommand = errors.New("Unknown command. Type /help for a list of commands.")
+)
+
+// execute runs a line of input the way the prompt does. Lines that aren't
+// commands go to the payload of the session being edited, if there is one,
+// and return an empty response. The error is set when line couldn't be run:
+// it isn't a command, the command doesn't exist or the arguments don't fit,
+// in which case it holds the usage.
+func execute(db database.Datastore, queue *worker.Queue, ed *editor, line string) (responseMsg, error) {
+	name, args, ok := parseCommand(line)
+	if !ok {
+		if ed.inPayload {
+			ed.payload.WriteString(line)
+			ed.payload.WriteString("\n")
+		} else if strings.TrimSpace(line) != "" {
+			return "", errNotCommand
+		}
+		return "", nil
+	}
+
+	cmd, ok := commands[name]
+	if !ok {
+		return "", errUnknownCommand
+	}
+	if usage, ok := checkArgs(name, args); !ok {
+		return "", errors.New(usage)
+	}
+	return cmd(db, queue, ed, args), nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/nieveai/d-agents/internal/database"
+	"github.com/nieveai/d-agents/internal/models"
+	"github.com/nieveai/d-agents/internal/scheduler"
+)
+
+// newTestController sets up the command table and a store with an agent a1
+// and a model m1.
+func newTestController(t *testing.T) database.Datastore {
+	t.Helper()
+	commands = newCommands()
+	db := database.NewMemoryDatastore()
+	sessionScheduler = scheduler.New(db, nil)
+	if err := db.AddAgent(&models.Agent{ID: "a1", Name: "Chat", Type: "ChatAgent"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AddModel(&models.Model{ID: "m1", Provider: "openai", ModelID: "gpt-4o-mini"}); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestParseCommand(t *testing.T) {
+	tests := []struct {
+		line string
+		name string
+		args []string
+		ok   bool
+	}{
+		{"/help", "/help", []string{}, true},
+		{"  /session   start \t a1  m1,m2 ", "/session", []string{"start", "a1", "m1,m2"}, true},
+		{"hello /session", "", nil, false},
+		{"", "", nil, false},
+		{"   ", "", nil, false},
+	}
+	for _, tt := range tests {
+		name, args, ok := parseCommand(tt.line)
+		if name != tt.name || !slices.Equal(args, tt.args) || ok != tt.ok {
+			t.Errorf("parseCommand(%q) = %q, %q, %v, want %q, %q, %v", tt.line, name, args, ok, tt.name, tt.args, tt.ok)
+		}
+	}
+}
+
+func TestExecuteMalformed(t *testing.T) {
+	db := newTestController(t)
+	tests := []struct {
+		line string
+		// want is in the error, or the response when the handler checks
+		// its own arguments.
+		want string
+	}{
+		{"/", "Unknown command"},
+		{"/nope", "Unknown command"},
+		{"hello", "Invalid command"},
+		{"/help extra", "Usage: /help"},
+		{"/session", "Usage: /session <start|"},
+		{"/session bogus", "Unknown command for /session"},
+		{"/session start", "Usage: /session start"},
+		{"  /session   start  ", "Usage: /session start"},
+		{"/session start a1 m1 extra", "Usage: /session start"},
+		{"/session load", "Usage: /session load"},
+		{"/session load a b", "Usage: /session load"},
+		{"/session tag s1", "Usage: /session tag"},
+		{"/session delete", "Usage: /session delete"},
+		{"/session clear", "Usage: /session clear"},
+		{"/session clear bogus", "Usage: /session clear"},
+		{"/list", "Usage: /list"},
+		{"/list bogus", "Unknown subcommand for /list"},
+		{"/list relationships", "Usage: /list relationships"},
+		{"/model", "Usage:"},
+		{"/model set m1", "Usage: /model set"},
+		{"/model test", "Usage: /model test"},
+		{"/add", "Usage: /add"},
+		{"/add agent", "Usage: /add agent"},
+		{"/add agent noat", "Usage: /add agent"},
+		{"/settings set k", "Usage: /settings set"},
+		{"/search", "Usage: /search"},
+	}
+	for _, tt := range tests {
+		ed := &editor{}
+		response, err := execute(db, nil, ed, tt.line)
+		got := string(response)
+		if err != nil {
+			got = err.Error()
+		}
+		if !strings.Contains(got, tt.want) {
+			t.Errorf("execute(%q) = %q, want %q", tt.line, got, tt.want)
+		}
+		if ed.session != nil || ed.inPayload {
+			t.Errorf("execute(%q) started a session", tt.line)
+		}
+	}
+
+	// Blank lines are ignored.
+	for _, line := range []string{"", "   "} {
+		if response, err := execute(db, nil, &editor{}, line); response != "" || err != nil {
+			t.Errorf("execute(%q) = %q, %v, want nothing", line, response, err)
+		}
+	}
+}
+
+func TestEditorsAreSeparate(t *testing.T) {
+	db := newTestController(t)
+	first, second := &editor{}, &editor{}
+
+	if _, err := execute(db, nil, first, "/session start a1 m1"); err != nil {
+		t.Fatalf("/session start: %v", err)
+	}
+	if !first.inPayload || first.session == nil {
+		t.Fatal("/session start didn't start writing a payload")
+	}
+	execute(db, nil, first, "compare these prices")
+	if _, err := execute(db, nil, second, "not a command"); err == nil {
+		t.Error("a line at the other prompt went to the first one's payload")
+	}
+	if second.inPayload || second.session != nil || second.payload.Len() != 0 {
+		t.Errorf("the other editor = %+v, want it untouched", second)
+	}
+	if got := first.payload.String(); got != "compare these prices\n" {
+		t.Errorf("payload = %q, want the line typed", got)
+	}
+}
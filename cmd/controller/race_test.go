@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/nieveai/d-agents/internal/worker"
+	pb "github.com/nieveai/d-agents/proto"
+	"google.golang.org/protobuf/proto"
+)
+
+// TestConcurrentSessions creates and runs sessions from several prompts at
+// once while a worker finishes them and the sessions are listed, for go test
+// -race.
+func TestConcurrentSessions(t *testing.T) {
+	db := newTestController(t)
+	queue := worker.NewQueue(1000)
+	const prompts, perPrompt = 4, 10
+
+	// The worker: like the real ones it stores a finished copy.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		for range prompts * perPrompt {
+			workload, ok := queue.Pop(ctx)
+			if !ok {
+				return
+			}
+			done := proto.Clone(workload).(*pb.Workload)
+			done.Status = pb.WorkloadStatus_COMPLETED
+			db.AddSession(done)
+			sessions.Store(done.Id, done)
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for p := range prompts {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			ed := &editor{}
+			for i := range perPrompt {
+				for _, line := range []string{"/session start a1 m1", fmt.Sprintf("prompt %d question %d", p, i), "/session run"} {
+					if _, err := execute(db, queue, ed, line); err != nil {
+						t.Errorf("%s: %v", line, err)
+					}
+				}
+			}
+		}()
+		// Someone refreshing the lists meanwhile.
+		go func() {
+			defer wg.Done()
+			for range perPrompt {
+				for _, line := range []string{"/list session", "/list model", "/list agent"} {
+					execute(db, queue, &editor{}, line)
+				}
+				sessions.Values()
+			}
+		}()
+	}
+	wg.Wait()
+	<-finished
+
+	all, _ := db.ListSessions()
+	completed := 0
+	for _, session := range all {
+		if session.Status == pb.WorkloadStatus_COMPLETED && strings.HasPrefix(string(session.Payload), "prompt ") {
+			completed++
+		}
+	}
+	if completed != prompts*perPrompt {
+		t.Errorf("%d sessions completed, want %d", completed, prompts*perPrompt)
+	}
+}
@@ -2,12 +2,18 @@ package main
 
 import (
 	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
-	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
+	"unicode"
 
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/app"
@@ -19,50 +25,89 @@ import (
 	"github.com/google/uuid"
 	"github.com/nieveai/d-agents/internal/database"
 	amodels "github.com/nieveai/d-agents/internal/models"
+	"github.com/nieveai/d-agents/internal/scheduler"
+	"github.com/nieveai/d-agents/internal/syncmap"
+	"github.com/nieveai/d-agents/internal/textutil"
 	"github.com/nieveai/d-agents/internal/worker"
 	pb "github.com/nieveai/d-agents/proto"
 )
 
-type Config struct {
-	Workers int `json:"workers"`
-}
+var modelStore syncmap.Map[string, *amodels.Model]
+var sessions syncmap.Map[string, *pb.Workload]
+var openSessionTabs syncmap.Map[string, *container.TabItem]
+var sessionScheduler *scheduler.Scheduler
+
+// sessionUpdates pushes the updates of sessions run here to their tabs. Tabs
+// poll the datastore when it's nil.
+var sessionUpdates *worker.Broker
 
-var modelStore = make(map[string]*amodels.Model)
-var sessions = make(map[string]*pb.Workload)
-var openSessionTabs = make(map[string]*container.TabItem)
-var scheduledSessions = make(map[string]*time.Ticker)
+// scheduledRunHandlers has a func for every open session tab, called when
+// the scheduler queues that session.
+var scheduledRunHandlers syncmap.Map[string, func(session *pb.Workload)]
 var currentSession *pb.Workload
 
 func main() {
 	// Command-line flags
 	workers := flag.Int("workers", 0, "Number of workers")
+	listenAddr := flag.String("listen", "", "Address to accept remote workers on (e.g. :50051)")
+	queueDepth := flag.Int("queue-depth", 0, "Maximum number of queued workloads")
+	maxRetries := flag.Int("max-retries", -1, "Number of times a failing workload is retried")
+	workloadTimeout := flag.Duration("workload-timeout", worker.DefaultWorkloadTimeout, "Maximum time a workload may run, retries included (0 disables)")
+	reapInterval := flag.Duration("reap-interval", worker.DefaultReapInterval, "How often to look for running workloads whose worker stopped responding")
+	staleAfter := flag.Duration("stale-after", worker.DefaultStaleAfter, "Fail a running workload after this long without a heartbeat (0 disables)")
+	audit := flag.Bool("audit", true, "Record the prompt and response of every model call in the session's audit trail")
+	auditRedactInput := flag.Bool("audit-redact-input", false, "Leave the input out of the audit trail, for sensitive data")
+	agentLimits := flag.String("agent-limits", "", "Most workloads of an agent type run at once, e.g. ShoppingAgent=2,ChatAgent=10 (default the agent_limits setting)")
+	openDB := database.RegisterFlags(flag.CommandLine)
 	flag.Parse()
 
-	// Configuration file
-	config := &Config{}
-	configFile, err := os.Open("config.json")
-	if err == nil {
-		defer configFile.Close()
-		jsonParser := json.NewDecoder(configFile)
-		jsonParser.Decode(config)
+	// Database
+	db, err := openDB()
+	if err != nil {
+		log.Fatalf("Error opening database: %s", err)
+	}
+
+	// Settings, seeded from config.json on first run. Flags win over both.
+	if err := database.SeedSettings(db, database.ConfigFile, database.CredentialsFile); err != nil {
+		log.Printf("Error seeding settings: %s", err)
+	}
+	intSetting := func(key string, def int) int {
+		n, err := database.IntSetting(db, key, def)
+		if err != nil {
+			log.Printf("Error reading settings: %s", err)
+		}
+		return n
 	}
 
-	numWorkers := config.Workers
+	numWorkers := intSetting(database.SettingWorkers, 5)
 	if *workers > 0 {
 		numWorkers = *workers
 	}
 
-	if numWorkers == 0 {
+	if numWorkers <= 0 {
 		numWorkers = 5 // Default value
 	}
 
-	log.Printf("Starting controller with %d workers", numWorkers)
+	depth := intSetting(database.SettingQueueDepth, worker.DefaultQueueDepth)
+	if *queueDepth > 0 {
+		depth = *queueDepth
+	}
+	if depth <= 0 {
+		depth = worker.DefaultQueueDepth
+	}
 
-	// Database
-	db, err := database.NewSQLiteDatastore("d-agents.db")
-	if err != nil {
-		log.Fatalf("Error opening database: %s", err)
+	retries := intSetting(database.SettingMaxRetries, worker.DefaultMaxRetries)
+	if *maxRetries >= 0 {
+		retries = *maxRetries
 	}
+	worker.SetMaxRetries(retries)
+	worker.SetWorkloadTimeout(*workloadTimeout)
+	worker.SetAudit(*audit, *auditRedactInput)
+	if err := worker.ConfigureAgentLimits(*agentLimits, db); err != nil {
+		log.Fatalf("Invalid agent limits: %s", err)
+	}
+
+	log.Printf("Starting controller with %d workers", numWorkers)
 
 	// Load sessions from database
 	dbSessions, err := db.ListSessions()
@@ -70,7 +115,7 @@ func main() {
 		log.Printf("Error loading sessions from database: %s", err)
 	}
 	for _, session := range dbSessions {
-		sessions[session.Id] = session
+		sessions.Store(session.Id, session)
 	}
 
 	// Load models from database
@@ -79,19 +124,46 @@ func main() {
 		log.Printf("Error loading models from database: %s", err)
 	}
 	for _, model := range dbModels {
-		modelStore[model.ID] = model
+		modelStore.Store(model.ID, model)
 	}
 
-	workloadChan := make(chan *pb.Workload)
+	queue := worker.NewQueue(depth)
 	refreshChan := make(chan bool, 1)
+	sessionUpdates = worker.NewBroker()
+	worker.SetBroker(sessionUpdates)
 	// init the workers.
 	if err := worker.Init(context.Background(), dbModels, db); err != nil {
 		log.Fatalf("Error initializing worker: %s", err)
 	}
 
 	// Start worker goroutines
-	for i := 0; i < numWorkers; i++ {
-		go runWorker(i, workloadChan)
+	worker.RunWorkers(context.Background(), numWorkers, queue)
+
+	// Pick up workloads interrupted by a previous run.
+	if _, err := worker.RecoverWorkloads(queue); err != nil {
+		log.Printf("Error recovering workloads: %s", err)
+	}
+
+	sessionScheduler = scheduler.New(db, queue)
+	sessionScheduler.OnRun = func(session *pb.Workload) {
+		if handler, ok := scheduledRunHandlers.Load(session.Id); ok {
+			handler(session)
+		}
+		refreshChan <- true
+	}
+	go sessionScheduler.Run(context.Background())
+
+	if *staleAfter > 0 {
+		go worker.RunReaper(context.Background(), *reapInterval, *staleAfter)
+	}
+
+	// Remote workers pull from the same queue as the local ones.
+	if *listenAddr != "" {
+		go func() {
+			if err := worker.ServeRemoteWorkers(*listenAddr, queue); err != nil {
+				log.Printf("Remote worker server stopped: %s", err)
+			}
+		}()
 	}
 
 	a := app.New()
@@ -100,14 +172,15 @@ func main() {
 	tabs := container.NewAppTabs()
 	tabs.Append(container.NewTabItem("Agents", makeAgentsTab(db, w)))
 	tabs.Append(container.NewTabItem("Models", makeModelsTab(db, w)))
-	tabs.Append(container.NewTabItem("Sessions", makeSessionsTab(db, tabs, workloadChan, w, refreshChan)))
+	tabs.Append(container.NewTabItem("Sessions", makeSessionsTab(db, tabs, queue, w, refreshChan)))
+	tabs.Append(container.NewTabItem("Settings", makeSettingsTab(db, w)))
 
 	w.SetContent(tabs)
 	w.Resize(fyne.NewSize(1000, 800))
 	w.ShowAndRun()
 }
 
-func makeAgentsTab(db *database.SQLiteDatastore, window fyne.Window) fyne.CanvasObject {
+func makeAgentsTab(db database.Datastore, window fyne.Window) fyne.CanvasObject {
 	agents, err := db.ListAgents()
 	if err != nil {
 		log.Printf("Error loading agents from database: %s", err)
@@ -124,6 +197,10 @@ func makeAgentsTab(db *database.SQLiteDatastore, window fyne.Window) fyne.Canvas
 			o.(*widget.Label).SetText(agents[i].Name)
 		},
 	)
+	list.OnSelected = func(i widget.ListItemID) {
+		list.Unselect(i)
+		showAgentPromptDialog(db, agents[i], window)
+	}
 
 	addButton := widget.NewButton("Add Agent", func() {
 		dialog.ShowFileOpen(func(reader fyne.URIReadCloser, err error) {
@@ -143,6 +220,10 @@ func makeAgentsTab(db *database.SQLiteDatastore, window fyne.Window) fyne.Canvas
 				return
 			}
 
+			if err := agent.Validate(); err != nil {
+				dialog.ShowError(err, window)
+				return
+			}
 			if err := db.AddAgent(&agent); err != nil {
 				dialog.ShowError(err, window)
 				return
@@ -162,7 +243,39 @@ func makeAgentsTab(db *database.SQLiteDatastore, window fyne.Window) fyne.Canvas
 	return container.NewBorder(nil, addButton, nil, nil, list)
 }
 
-func makeModelsTab(db *database.SQLiteDatastore, window fyne.Window) fyne.CanvasObject {
+// showAgentPromptDialog lets the user replace the system prompt of an agent.
+// Leaving it empty goes back to the agent type's built-in prompt.
+func showAgentPromptDialog(db database.Datastore, agent *amodels.Agent, window fyne.Window) {
+	promptEntry := widget.NewMultiLineEntry()
+	promptEntry.SetPlaceHolder("built-in prompt of " + agent.Type)
+	promptEntry.SetText(agent.SystemPrompt)
+	promptEntry.Wrapping = fyne.TextWrapWord
+	promptEntry.SetMinRowsVisible(8)
+
+	promptItem := widget.NewFormItem("System Prompt", promptEntry)
+	promptItem.HintText = "{{.Name}} is the session name, also {{.Description}}, {{.AgentType}} and {{.Date}}"
+
+	d := dialog.NewForm(fmt.Sprintf("Agent: %s", agent.Name), "Save", "Cancel", []*widget.FormItem{promptItem}, func(b bool) {
+		if !b {
+			return
+		}
+		updated := *agent
+		updated.SystemPrompt = strings.TrimSpace(promptEntry.Text)
+		if err := updated.Validate(); err != nil {
+			dialog.ShowError(err, window)
+			return
+		}
+		if err := db.AddAgent(&updated); err != nil {
+			dialog.ShowError(err, window)
+			return
+		}
+		*agent = updated
+	}, window)
+	d.Resize(fyne.NewSize(600, 0))
+	d.Show()
+}
+
+func makeModelsTab(db database.Datastore, window fyne.Window) fyne.CanvasObject {
 	models, err := db.ListModels()
 	if err != nil {
 		log.Printf("Error loading models from database: %s", err)
@@ -173,13 +286,23 @@ func makeModelsTab(db *database.SQLiteDatastore, window fyne.Window) fyne.Canvas
 			return len(models)
 		},
 		func() fyne.CanvasObject {
-			return widget.NewLabel("template")
+			return container.NewBorder(nil, nil, nil, widget.NewButton("Test", nil), widget.NewLabel("template"))
 		},
 		func(i widget.ListItemID, o fyne.CanvasObject) {
-			o.(*widget.Label).SetText(models[i].ModelID)
+			row := o.(*fyne.Container)
+			row.Objects[0].(*widget.Label).SetText(models[i].ModelID)
+			model := models[i]
+			row.Objects[1].(*widget.Button).OnTapped = func() {
+				testModel(model, window)
+			}
 		},
 	)
 
+	list.OnSelected = func(i widget.ListItemID) {
+		list.Unselect(i)
+		showModelParamsDialog(db, models[i], window)
+	}
+
 	addButton := widget.NewButton("Add Model", func() {
 		dialog.ShowFileOpen(func(reader fyne.URIReadCloser, err error) {
 			if err != nil {
@@ -198,6 +321,10 @@ func makeModelsTab(db *database.SQLiteDatastore, window fyne.Window) fyne.Canvas
 				return
 			}
 
+			if err := model.Validate(); err != nil {
+				dialog.ShowError(err, window)
+				return
+			}
 			if err := db.AddModel(&model); err != nil {
 				dialog.ShowError(err, window)
 				return
@@ -210,6 +337,10 @@ func makeModelsTab(db *database.SQLiteDatastore, window fyne.Window) fyne.Canvas
 			} else {
 				models = newModels
 				list.Refresh()
+				// Pick up the new model, or the changes to a re-imported one.
+				if err := worker.ReloadModels(context.Background()); err != nil {
+					log.Printf("Error reinitializing LLM client: %s", err)
+				}
 			}
 		}, window)
 	})
@@ -217,23 +348,224 @@ func makeModelsTab(db *database.SQLiteDatastore, window fyne.Window) fyne.Canvas
 	return container.NewBorder(nil, addButton, nil, nil, list)
 }
 
-func makeSessionsTab(db *database.SQLiteDatastore, tabs *container.AppTabs, workloadChan chan<- *pb.Workload, window fyne.Window, refreshChan chan bool) fyne.CanvasObject {
+// testModel checks the model's credentials in the background and reports
+// the result in a dialog.
+func testModel(model *amodels.Model, window fyne.Window) {
+	progress := dialog.NewCustomWithoutButtons(fmt.Sprintf("Testing %s", model.ModelID), widget.NewProgressBarInfinite(), window)
+	progress.Show()
+	go func() {
+		err := worker.TestModel(context.Background(), model)
+		fyne.Do(func() {
+			progress.Hide()
+			if err != nil {
+				dialog.ShowError(fmt.Errorf("model %s: %w", model.ID, err), window)
+				return
+			}
+			dialog.ShowInformation("Model test", fmt.Sprintf("Model %s works.", model.ID), window)
+		})
+	}()
+}
+
+// showModelParamsDialog lets the user edit the generation parameters, alias
+// and response cleaners of a model. Empty fields fall back to the provider
+// default.
+func showModelParamsDialog(db database.Datastore, model *amodels.Model, window fyne.Window) {
+	aliasEntry := widget.NewEntry()
+	aliasEntry.SetPlaceHolder("none")
+	aliasEntry.SetText(model.Alias)
+	cleanersEntry := widget.NewEntry()
+	cleanersEntry.SetPlaceHolder(strings.Join(amodels.ResponseCleanerNames(), ","))
+	cleanersEntry.SetText(strings.Join(model.ResponseCleaners, ","))
+	temperatureEntry := widget.NewEntry()
+	temperatureEntry.SetPlaceHolder("provider default")
+	maxTokensEntry := widget.NewEntry()
+	maxTokensEntry.SetPlaceHolder("provider default")
+	topPEntry := widget.NewEntry()
+	topPEntry.SetPlaceHolder("provider default")
+	rpmEntry := widget.NewEntry()
+	rpmEntry.SetPlaceHolder("unlimited")
+	if model.RPM > 0 {
+		rpmEntry.SetText(strconv.Itoa(model.RPM))
+	}
+	maxInputEntry := widget.NewEntry()
+	maxInputEntry.SetPlaceHolder("unlimited")
+	if model.MaxInputBytes > 0 {
+		maxInputEntry.SetText(strconv.Itoa(model.MaxInputBytes))
+	}
+	if model.Temperature != nil {
+		temperatureEntry.SetText(strconv.FormatFloat(*model.Temperature, 'g', -1, 64))
+	}
+	if model.MaxTokens != nil {
+		maxTokensEntry.SetText(strconv.Itoa(*model.MaxTokens))
+	}
+	if model.TopP != nil {
+		topPEntry.SetText(strconv.FormatFloat(*model.TopP, 'g', -1, 64))
+	}
+
+	dialog.ShowForm(fmt.Sprintf("Model: %s", model.ModelID), "Save", "Cancel", []*widget.FormItem{
+		widget.NewFormItem("Alias", aliasEntry),
+		widget.NewFormItem("Temperature", temperatureEntry),
+		widget.NewFormItem("Max Tokens", maxTokensEntry),
+		widget.NewFormItem("Top P", topPEntry),
+		widget.NewFormItem("Requests / Minute", rpmEntry),
+		widget.NewFormItem("Max Input Bytes", maxInputEntry),
+		widget.NewFormItem("Response Cleaners", cleanersEntry),
+	}, func(b bool) {
+		if !b {
+			return
+		}
+
+		updated := *model
+		var err error
+		if updated.Temperature, err = parseOptionalFloat(temperatureEntry.Text); err != nil {
+			dialog.ShowError(fmt.Errorf("invalid temperature: %w", err), window)
+			return
+		}
+		if updated.TopP, err = parseOptionalFloat(topPEntry.Text); err != nil {
+			dialog.ShowError(fmt.Errorf("invalid top p: %w", err), window)
+			return
+		}
+		updated.MaxTokens = nil
+		if maxTokensEntry.Text != "" {
+			n, err := strconv.Atoi(maxTokensEntry.Text)
+			if err != nil {
+				dialog.ShowError(fmt.Errorf("invalid max tokens: %w", err), window)
+				return
+			}
+			updated.MaxTokens = &n
+		}
+		updated.RPM = 0
+		if rpmEntry.Text != "" {
+			n, err := strconv.Atoi(rpmEntry.Text)
+			if err != nil || n < 0 {
+				dialog.ShowError(fmt.Errorf("invalid requests per minute: %q", rpmEntry.Text), window)
+				return
+			}
+			updated.RPM = n
+		}
+		updated.MaxInputBytes = 0
+		if maxInputEntry.Text != "" {
+			n, err := strconv.Atoi(maxInputEntry.Text)
+			if err != nil || n < 0 {
+				dialog.ShowError(fmt.Errorf("invalid max input bytes: %q", maxInputEntry.Text), window)
+				return
+			}
+			updated.MaxInputBytes = n
+		}
+		updated.Alias = strings.TrimSpace(aliasEntry.Text)
+		updated.ResponseCleaners = nil
+		for _, name := range strings.Split(cleanersEntry.Text, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				updated.ResponseCleaners = append(updated.ResponseCleaners, name)
+			}
+		}
+		if err := updated.Validate(); err != nil {
+			dialog.ShowError(err, window)
+			return
+		}
+		if err := database.CheckModelAlias(db, &updated); err != nil {
+			dialog.ShowError(err, window)
+			return
+		}
+
+		if err := db.UpdateModel(&updated); err != nil {
+			dialog.ShowError(err, window)
+			return
+		}
+		*model = updated
+		modelStore.Store(model.ID, model)
+		if err := worker.ReloadModels(context.Background()); err != nil {
+			log.Printf("Error reinitializing LLM client: %s", err)
+		}
+	}, window)
+}
+
+func parseOptionalFloat(s string) (*float64, error) {
+	if s == "" {
+		return nil, nil
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+func makeSettingsTab(db database.Datastore, window fyne.Window) fyne.CanvasObject {
+	settings, err := db.ListSettings()
+	if err != nil {
+		log.Printf("Error loading settings from database: %s", err)
+	}
+
+	entries := make([]*widget.Entry, len(database.KnownSettings))
+	form := widget.NewForm()
+	for i, info := range database.KnownSettings {
+		if info.Secret {
+			entries[i] = widget.NewPasswordEntry()
+		} else {
+			entries[i] = widget.NewEntry()
+		}
+		entries[i].SetPlaceHolder("not set")
+		entries[i].SetText(settings[info.Key])
+		item := widget.NewFormItem(info.Key, entries[i])
+		item.HintText = info.Description
+		form.AppendItem(item)
+	}
+
+	saveButton := widget.NewButton("Save", func() {
+		for i, info := range database.KnownSettings {
+			value := strings.TrimSpace(entries[i].Text)
+			// Settings can't be unset, so leave the empty ones alone.
+			if value == "" || value == settings[info.Key] {
+				continue
+			}
+			if err := database.ValidateSetting(info.Key, value); err != nil {
+				dialog.ShowError(err, window)
+				return
+			}
+			if info.Key == database.SettingDefaultModel {
+				if _, err := database.ResolveModels(db, value); err != nil {
+					dialog.ShowError(err, window)
+					return
+				}
+			}
+			if err := db.SetSetting(info.Key, value); err != nil {
+				dialog.ShowError(err, window)
+				return
+			}
+			settings[info.Key] = value
+		}
+		dialog.ShowInformation("Settings", "Settings saved. Most of them take effect on the next start.", window)
+	})
+
+	return container.NewBorder(nil, saveButton, nil, nil, container.NewVScroll(form))
+}
+
+func makeSessionsTab(db database.Datastore, tabs *container.AppTabs, queue *worker.Queue, window fyne.Window, refreshChan chan bool) fyne.CanvasObject {
 	sessions, err := db.ListSessions()
 	if err != nil {
 		log.Printf("Error loading sessions from database: %s", err)
 	}
-
-	columnWidths := []float32{150, 100, 250, 300, 50}
+	duplicates := findDuplicates(db, sessions)
+
+	columnWidths := []float32{150, 100, 250, 300, 120, 50, 60, 60}
+	// Measuring wrapped text is slow and cells are rendered all the time, so
+	// payload heights are cached and rows only resized when they change. Both
+	// maps are only used on the UI goroutine and cleared when the rows reload.
+	payloadHeights := make(map[string]float32)
+	rowHeights := make(map[int]float32)
 	var table *widget.Table
 	table = widget.NewTable(
 		func() (int, int) {
-			return len(sessions) + 1, 5 // Add 1 for header row, 5 columns
+			return len(sessions) + 1, 8 // Add 1 for header row, 8 columns
 		},
 		func() fyne.CanvasObject {
 			return widget.NewLabel("template")
 		},
 		func(id widget.TableCellID, o fyne.CanvasObject) {
 			label := o.(*widget.Label)
+			// Cells are reused, so reset the status color on every update.
+			label.Importance = widget.MediumImportance
 			if id.Row == 0 {
 				// Header row
 				switch id.Col {
@@ -246,7 +578,13 @@ func makeSessionsTab(db *database.SQLiteDatastore, tabs *container.AppTabs, work
 				case 3:
 					label.SetText("Payload")
 				case 4:
+					label.SetText("Tags")
+				case 5:
 					label.SetText("Action")
+				case 6:
+					label.SetText("Clone")
+				case 7:
+					label.SetText("Delete")
 				}
 				return
 			}
@@ -260,29 +598,43 @@ func makeSessionsTab(db *database.SQLiteDatastore, tabs *container.AppTabs, work
 			session := sessions[id.Row-1]
 			switch id.Col {
 			case 0:
-				label.SetText(session.Name)
+				if _, ok := duplicates[session.Id]; ok {
+					label.Importance = widget.WarningImportance
+					label.SetText(session.Name + " (duplicate?)")
+				} else {
+					label.SetText(session.Name)
+				}
 			case 1:
+				label.Importance = statusImportance(session.Status)
 				label.SetText(session.Status.String())
 			case 2:
 				label.SetText(time.Unix(session.Timestamp, 0).Format(time.RFC1123))
 			case 3:
-				payload := string(session.Payload)
-				if len(payload) > 100 {
-					payload = payload[:100] + "..."
-				}
+				payload := textutil.Preview(string(session.Payload), 100)
 				label.SetText(payload)
 
 				// Calculate required height for wrapped text
-				tempLabel := widget.NewLabel(payload)
-				tempLabel.Wrapping = fyne.TextWrapWord
-				tempLabel.Resize(fyne.NewSize(columnWidths[id.Col], 0))
-				requiredHeight := tempLabel.MinSize().Height
-
-				table.SetRowHeight(id.Row, requiredHeight)
+				requiredHeight, ok := payloadHeights[payload]
+				if !ok {
+					tempLabel := widget.NewLabel(payload)
+					tempLabel.Wrapping = fyne.TextWrapWord
+					tempLabel.Resize(fyne.NewSize(columnWidths[id.Col], 0))
+					requiredHeight = tempLabel.MinSize().Height
+					payloadHeights[payload] = requiredHeight
+				}
+				if rowHeights[id.Row] != requiredHeight {
+					rowHeights[id.Row] = requiredHeight
+					table.SetRowHeight(id.Row, requiredHeight)
+				}
 
 			case 4:
+				label.SetText(strings.Join(session.Tags, ", "))
+			case 5:
 				label.SetText("Load")
-
+			case 6:
+				label.SetText("Clone")
+			case 7:
+				label.SetText("Delete")
 			}
 		},
 	)
@@ -291,30 +643,100 @@ func makeSessionsTab(db *database.SQLiteDatastore, tabs *container.AppTabs, work
 	}
 
 	table.OnSelected = func(id widget.TableCellID) {
-		if id.Row > 0 && id.Col == 4 {
+		if id.Row > 0 && id.Col == 5 {
 			session := sessions[id.Row-1]
-			if tab, ok := openSessionTabs[session.Id]; ok {
+			if tab, ok := openSessionTabs.Load(session.Id); ok {
 				tabs.Select(tab)
 			} else {
 				tab := container.NewTabItem(session.Name, nil)
-				tab.Content = makeSessionTab(session, db, workloadChan, refreshChan, tabs, tab, window)
-				openSessionTabs[session.Id] = tab
+				tab.Content = makeSessionTab(session, db, queue, refreshChan, tabs, tab, window)
+				openSessionTabs.Store(session.Id, tab)
 				tabs.Append(tab)
 				tabs.Select(tab)
 			}
 		}
+		if id.Row > 0 && id.Col == 6 {
+			clone, err := database.CloneSession(db, sessions[id.Row-1].Id)
+			if err != nil {
+				dialog.ShowError(err, window)
+			} else {
+				tab := container.NewTabItem(clone.Name, nil)
+				tab.Content = makeSessionTab(clone, db, queue, refreshChan, tabs, tab, window)
+				openSessionTabs.Store(clone.Id, tab)
+				tabs.Append(tab)
+				tabs.Select(tab)
+				refreshChan <- true
+			}
+		}
+		if id.Row > 0 && id.Col == 7 {
+			session := sessions[id.Row-1]
+			if _, ok := openSessionTabs.Load(session.Id); ok {
+				dialog.ShowError(fmt.Errorf("close the session tab for '%s' before deleting it", session.Name), window)
+			} else {
+				message := fmt.Sprintf("Delete session '%s'?", session.Name)
+				if duplicate, ok := duplicates[session.Id]; ok {
+					message += fmt.Sprintf("\nIt looks like a duplicate of '%s' from %s (%.0f%% similar).", duplicate.Session.Name, time.Unix(duplicate.Session.Timestamp, 0).Format(time.RFC1123), duplicate.Similarity*100)
+				}
+				dialog.ShowConfirm("Delete Session", message, func(b bool) {
+					if !b {
+						return
+					}
+					if err := db.DeleteSession(session.Id); err != nil {
+						dialog.ShowError(err, window)
+						return
+					}
+					refreshChan <- true
+				}, window)
+			}
+		}
 		table.Unselect(id)
 	}
 
+	// The search box filters the table; it is read by the refresh goroutine.
+	var searchQuery atomic.Value
+	searchQuery.Store("")
+	searchEntry := widget.NewEntry()
+	searchEntry.SetPlaceHolder("Search sessions...")
+	searchEntry.OnChanged = func(query string) {
+		searchQuery.Store(strings.TrimSpace(query))
+		refreshChan <- true
+	}
+	// Like the search box, the tag filter is read by the refresh goroutine.
+	var tagFilter atomic.Value
+	tagFilter.Store("")
+	tagEntry := widget.NewEntry()
+	tagEntry.SetPlaceHolder("Filter by tag...")
+	tagEntry.OnChanged = func(tag string) {
+		tagFilter.Store(strings.TrimSpace(tag))
+		refreshChan <- true
+	}
+
 	go func(table *widget.Table, sessions *[]*pb.Workload) {
 		for range refreshChan {
-			newSessions, err := db.ListSessions()
+			var newSessions []*pb.Workload
+			var err error
+			query, tag := searchQuery.Load().(string), tagFilter.Load().(string)
+			switch {
+			case query != "":
+				newSessions, err = db.SearchSessions(query)
+				if err == nil && tag != "" {
+					newSessions, err = taggedSessions(newSessions, tag)
+				}
+			case tag != "":
+				newSessions, err = db.ListSessionsByTag(tag)
+			default:
+				newSessions, err = db.ListSessions()
+			}
 			if err != nil {
 				log.Printf("Error loading sessions from database: %s", err)
 				continue
 			}
-			*sessions = newSessions
+			newDuplicates := findDuplicates(db, newSessions)
 			fyne.Do(func() {
+				*sessions = newSessions
+				duplicates = newDuplicates
+				clear(payloadHeights)
+				clear(rowHeights)
 				table.Refresh()
 			})
 		}
@@ -337,6 +759,11 @@ func makeSessionsTab(db *database.SQLiteDatastore, tabs *container.AppTabs, work
 		sessionNameEntry := widget.NewEntry()
 		sessionNameEntry.SetPlaceHolder("Enter session name...")
 
+		prioritySelect := widget.NewSelect(worker.PriorityNames, nil)
+		prioritySelect.SetSelected(worker.PriorityName(worker.PriorityNormal))
+		tagsEntry := widget.NewEntry()
+		tagsEntry.SetPlaceHolder("e.g. research, weekly")
+
 		agentSelect := widget.NewSelect(agentNames(agents), func(s string) {
 			for _, a := range agents {
 				if a.Name == s {
@@ -357,11 +784,28 @@ func makeSessionsTab(db *database.SQLiteDatastore, tabs *container.AppTabs, work
 				}
 			}
 		})
+		if defaultModel, err := database.StringSetting(db, database.SettingDefaultModel, ""); err != nil {
+			log.Printf("Error reading settings: %s", err)
+		} else if defaultModel != "" {
+			ids, err := database.ResolveModels(db, defaultModel)
+			if err != nil {
+				log.Printf("Error resolving default model: %s", err)
+			}
+			var selected []string
+			for _, m := range models {
+				if slices.Contains(ids, m.ID) {
+					selected = append(selected, m.ModelID)
+				}
+			}
+			modelCheck.SetSelected(selected)
+		}
 
 		d := dialog.NewForm("Create Session", "Create", "Cancel", []*widget.FormItem{
 			widget.NewFormItem("Session Name", sessionNameEntry),
 			widget.NewFormItem("Agent", agentSelect),
 			widget.NewFormItem("Models", modelCheck),
+			widget.NewFormItem("Priority", prioritySelect),
+			widget.NewFormItem("Tags", tagsEntry),
 		}, func(b bool) {
 			if !b {
 				return
@@ -377,18 +821,31 @@ func makeSessionsTab(db *database.SQLiteDatastore, tabs *container.AppTabs, work
 				sessionName = selectedAgent.Name
 			}
 
+			priority, err := worker.ParsePriority(prioritySelect.Selected)
+			if err != nil {
+				dialog.ShowError(err, window)
+				return
+			}
+			tags, err := parseTags(tagsEntry.Text)
+			if err != nil {
+				dialog.ShowError(err, window)
+				return
+			}
+
 			newSession := &pb.Workload{
 				Id:        uuid.New().String(),
 				Name:      sessionName,
 				AgentId:   selectedAgent.ID,
 				AgentType: selectedAgent.Type,
 				Models:    modelIDs,
+				Priority:  priority,
+				Tags:      tags,
 				Timestamp: time.Now().Unix(),
 				Status:    pb.WorkloadStatus_PENDING,
 			}
 			tab := container.NewTabItem(newSession.Name, nil)
-			tab.Content = makeSessionTab(newSession, db, workloadChan, refreshChan, tabs, tab, window)
-			openSessionTabs[newSession.Id] = tab
+			tab.Content = makeSessionTab(newSession, db, queue, refreshChan, tabs, tab, window)
+			openSessionTabs.Store(newSession.Id, tab)
 			tabs.Append(tab)
 			tabs.Select(tab)
 		}, window)
@@ -401,22 +858,38 @@ func makeSessionsTab(db *database.SQLiteDatastore, tabs *container.AppTabs, work
 		refreshChan <- true
 	})
 
-	return container.NewBorder(nil, container.NewHBox(createButton, refreshButton), nil, nil, table)
+	filters := container.NewBorder(nil, nil, nil, container.NewGridWrap(fyne.NewSize(200, tagEntry.MinSize().Height), tagEntry), searchEntry)
+	return container.NewBorder(filters, container.NewHBox(createButton, refreshButton), nil, nil, table)
 }
 
-func makeSessionTab(session *pb.Workload, db *database.SQLiteDatastore, workloadChan chan<- *pb.Workload, refreshChan chan bool, tabs *container.AppTabs, tab *container.TabItem, window fyne.Window) fyne.CanvasObject {
-	label := widget.NewLabel(fmt.Sprintf("Session: %s", session.Name))
+func makeSessionTab(session *pb.Workload, db database.Datastore, queue *worker.Queue, refreshChan chan bool, tabs *container.AppTabs, tab *container.TabItem, window fyne.Window) fyne.CanvasObject {
+	label := widget.NewLabel(sessionTitle(session))
 	statusLabel := widget.NewLabel(fmt.Sprintf("Status: %s Agent: %s Models: %s", session.Status.String(), session.AgentId, session.Models))
+	if schedule, _ := sessionScheduler.Get(session.Id); schedule != nil && session.Status != pb.WorkloadStatus_RUNNING {
+		statusLabel.SetText(fmt.Sprintf("Status: Scheduled every %s Agent: %s Models: %s", schedule.Interval, session.AgentId, session.Models))
+	}
+	progressBar := widget.NewProgressBar()
+	progressBar.Max = 100
+	progressBar.SetValue(float64(session.Progress))
+	if session.Status != pb.WorkloadStatus_RUNNING {
+		progressBar.Hide()
+	}
+	errorLabel := widget.NewLabel("")
+	errorLabel.Importance = widget.DangerImportance
+	errorLabel.Wrapping = fyne.TextWrapWord
+	if session.Status == pb.WorkloadStatus_FAILED && session.Error != "" {
+		errorLabel.SetText(fmt.Sprintf("Error: %s", session.Error))
+	} else {
+		errorLabel.Hide()
+	}
 	done := make(chan struct{})
 
 	closeButton := widget.NewButton("X", func() {
-		if ticker, ok := scheduledSessions[session.Id]; ok {
-			ticker.Stop()
-			delete(scheduledSessions, session.Id)
-		}
+		// The schedule, if any, keeps running with the tab closed.
+		scheduledRunHandlers.Delete(session.Id)
 		close(done)
 		tabs.Remove(tab)
-		delete(openSessionTabs, session.Id)
+		openSessionTabs.Delete(session.Id)
 	})
 
 	// View mode widgets
@@ -429,18 +902,30 @@ func makeSessionTab(session *pb.Workload, db *database.SQLiteDatastore, workload
 	payloadBinding.Set(string(session.Payload))
 	payloadEntry := widget.NewEntryWithData(payloadBinding)
 	payloadEntry.MultiLine = true
-	editScroll := container.NewScroll(payloadEntry)
+	configEntry := widget.NewMultiLineEntry()
+	configEntry.SetPlaceHolder("Agent config (JSON)")
+	configEntry.SetText(session.Config)
+	editScroll := container.NewScroll(container.NewBorder(configEntry, nil, nil, nil, payloadEntry))
 
-	var editButton, saveButton, runButton, stopButton *widget.Button
+	var editButton, saveButton, runButton, stopButton, cancelButton, tagsButton, auditButton *widget.Button
 
 	runSession := func() {
 		text, _ := payloadBinding.Get()
 		session.Payload = []byte(text)
+		session.Config = configEntry.Text
 		session.Status = pb.WorkloadStatus_RUNNING
+		session.Error = ""
+		session.RetryCount = 0
+		session.LastHeartbeat = 0
+		session.Stage = ""
+		session.Progress = 0
 		db.AddSession(session)
+		errorLabel.Hide()
+		progressBar.SetValue(0)
+		progressBar.Show()
 		richText.ParseMarkdown(string(session.Payload))
 		statusLabel.SetText(fmt.Sprintf("Status: %s Agent: %s Models: %s", session.Status.String(), session.AgentId, session.Models))
-		workloadChan <- session
+		queue.Push(session)
 		refreshChan <- true
 	}
 
@@ -451,7 +936,8 @@ func makeSessionTab(session *pb.Workload, db *database.SQLiteDatastore, workload
 		editButton.Show()
 		saveButton.Hide()
 		runButton.Show()
-		if _, ok := scheduledSessions[session.Id]; ok {
+		cancelButton.Show()
+		if isScheduled(session.Id) {
 			stopButton.Show()
 			runButton.Hide()
 		} else {
@@ -467,6 +953,7 @@ func makeSessionTab(session *pb.Workload, db *database.SQLiteDatastore, workload
 		saveButton.Show()
 		runButton.Show()
 		stopButton.Hide()
+		cancelButton.Hide()
 	}
 
 	var startPolling func()
@@ -476,11 +963,82 @@ func makeSessionTab(session *pb.Workload, db *database.SQLiteDatastore, workload
 				return
 			}
 
-			ticker := time.NewTicker(5 * time.Second)
+			// apply shows newSession and reports whether it's finished.
+			apply := func(newSession *pb.Workload) bool {
+				if newSession.Status == pb.WorkloadStatus_RUNNING && newSession.Stage != session.Stage {
+					session.Stage = newSession.Stage
+					fyne.Do(func() {
+						statusLabel.SetText(fmt.Sprintf("Status: %s (stage %s) Agent: %s Models: %s", session.Status.String(), session.Stage, session.AgentId, session.Models))
+					})
+				}
+
+				if newSession.Status == pb.WorkloadStatus_RUNNING && newSession.Progress != session.Progress {
+					session.Progress = newSession.Progress
+					fyne.Do(func() {
+						progressBar.SetValue(float64(session.Progress))
+					})
+				}
+
+				if newSession.Status == pb.WorkloadStatus_RUNNING && string(newSession.Payload) != string(session.Payload) {
+					// Show partial output from streaming agents.
+					session.Payload = newSession.Payload
+					fyne.Do(func() {
+						richText.ParseMarkdown(string(session.Payload))
+					})
+				}
+
+				if newSession.Status != pb.WorkloadStatus_RUNNING {
+					session.Status = newSession.Status
+					session.Progress = newSession.Progress
+					fyne.Do(progressBar.Hide)
+					statusLabel.SetText(fmt.Sprintf("Status: %s Agent: %s Models: %s", session.Status.String(), session.AgentId, session.Models))
+
+					if newSession.Status == pb.WorkloadStatus_FAILED {
+						log.Printf("Session %s failed: %s", session.Id, newSession.Error)
+						session.Error = newSession.Error
+						fyne.Do(func() {
+							errorLabel.SetText(fmt.Sprintf("Error: %s", session.Error))
+							errorLabel.Show()
+						})
+					}
+
+					if newSession.Status == pb.WorkloadStatus_COMPLETED {
+						log.Printf("Session %s completed. Reloading payload.", session.Id)
+						session.Payload = newSession.Payload
+						session.PromptTokens = newSession.PromptTokens
+						session.CompletionTokens = newSession.CompletionTokens
+						session.EstimatedCost = newSession.EstimatedCost
+						richText.ParseMarkdown(string(session.Payload))
+						payloadBinding.Set(string(session.Payload))
+						label.SetText(sessionTitle(session))
+					}
+					return true
+				}
+				return false
+			}
+
+			// Sessions run by another process sharing the datastore don't go
+			// through the broker, so it's still polled, just less often.
+			var updates <-chan *pb.Workload
+			interval := 5 * time.Second
+			if sessionUpdates != nil {
+				updates = sessionUpdates.SessionUpdates(session.Id)
+				defer sessionUpdates.Unsubscribe(session.Id, updates)
+				interval = 30 * time.Second
+			}
+			ticker := time.NewTicker(interval)
 			defer ticker.Stop()
 
 			for {
 				select {
+				case newSession, ok := <-updates:
+					if !ok {
+						updates = nil
+						continue
+					}
+					if apply(newSession) {
+						return // Stop polling
+					}
 				case <-ticker.C:
 					log.Printf("Checking status for session %s", session.Id)
 					newSession, err := db.GetSession(session.Id)
@@ -488,17 +1046,7 @@ func makeSessionTab(session *pb.Workload, db *database.SQLiteDatastore, workload
 						log.Printf("Error checking session %s: %s", session.Id, err)
 						continue
 					}
-
-					if newSession.Status != pb.WorkloadStatus_RUNNING {
-						session.Status = newSession.Status
-						statusLabel.SetText(fmt.Sprintf("Status: %s Agent: %s Models: %s", session.Status.String(), session.AgentId, session.Models))
-
-						if newSession.Status == pb.WorkloadStatus_COMPLETED {
-							log.Printf("Session %s completed. Reloading payload.", session.Id)
-							session.Payload = newSession.Payload
-							richText.ParseMarkdown(string(session.Payload))
-							payloadBinding.Set(string(session.Payload))
-						}
+					if apply(newSession) {
 						return // Stop polling
 					}
 				case <-done:
@@ -509,10 +1057,26 @@ func makeSessionTab(session *pb.Workload, db *database.SQLiteDatastore, workload
 		}()
 	}
 
+	// Runs queued by the scheduler show up like the ones started here.
+	scheduledRunHandlers.Store(session.Id, func(run *pb.Workload) {
+		fyne.Do(func() {
+			session.Status = run.Status
+			session.Error = ""
+			session.Stage = ""
+			session.Progress = 0
+			errorLabel.Hide()
+			progressBar.SetValue(0)
+			progressBar.Show()
+			statusLabel.SetText(fmt.Sprintf("Status: %s Agent: %s Models: %s", session.Status.String(), session.AgentId, session.Models))
+			startPolling()
+		})
+	})
+
 	editButton = widget.NewButton("Edit", showEditMode)
 	saveButton = widget.NewButton("Save", func() {
 		text, _ := payloadBinding.Get()
 		session.Payload = []byte(text)
+		session.Config = configEntry.Text
 		db.AddSession(session)
 		richText.ParseMarkdown(string(session.Payload))
 		showViewMode()
@@ -562,53 +1126,226 @@ func makeSessionTab(session *pb.Workload, db *database.SQLiteDatastore, workload
 				return
 			}
 
-			ticker := time.NewTicker(interval)
-			scheduledSessions[session.Id] = ticker
-			go func() {
-				for {
-					select {
-					case <-ticker.C:
-						if session.Status == pb.WorkloadStatus_RUNNING {
-							log.Printf("Session %s is already running. Skipping scheduled run.", session.Id)
-							continue
-						}
-						runSession()
-						startPolling()
-					case <-done:
-						return
-					}
-				}
-			}()
+			// Scheduled runs start from the session as saved.
+			text, _ := payloadBinding.Get()
+			session.Payload = []byte(text)
+			session.Config = configEntry.Text
+			if err := db.AddSession(session); err != nil {
+				dialog.ShowError(err, window)
+				return
+			}
+			if err := sessionScheduler.Schedule(session.Id, interval); err != nil {
+				dialog.ShowError(err, window)
+				return
+			}
 			statusLabel.SetText(fmt.Sprintf("Status: Scheduled every %s Agent: %s Models: %s", interval, session.AgentId, session.Models))
 			showViewMode()
 		}, window)
 	})
 
 	stopButton = widget.NewButton("Stop", func() {
-		if ticker, ok := scheduledSessions[session.Id]; ok {
-			ticker.Stop()
-			delete(scheduledSessions, session.Id)
+		unscheduled, err := sessionScheduler.Unschedule(session.Id)
+		if err != nil {
+			dialog.ShowError(err, window)
+			return
+		}
+		if unscheduled {
 			statusLabel.SetText(fmt.Sprintf("Status: %s Agent: %s Models: %s", session.Status.String(), session.AgentId, session.Models))
 			showViewMode()
 		}
 	})
 
-	buttonContainer := container.NewHBox(editButton, saveButton, runButton, stopButton)
+	// Cancel stops both the schedule and the run in progress, if any.
+	cancelButton = widget.NewButton("Cancel", func() {
+		if err := sessionScheduler.Cancel(session.Id); errors.Is(err, scheduler.ErrNotCancellable) {
+			dialog.ShowInformation("Cancel", "The session is not running.", window)
+			return
+		} else if err != nil {
+			dialog.ShowError(err, window)
+			return
+		}
+		statusLabel.SetText(fmt.Sprintf("Status: %s Agent: %s Models: %s", session.Status.String(), session.AgentId, session.Models))
+		showViewMode()
+	})
+
+	tagsButton = widget.NewButton("Tags", func() {
+		tagsEntry := widget.NewEntry()
+		tagsEntry.SetPlaceHolder("e.g. research, weekly")
+		tagsEntry.SetText(strings.Join(session.Tags, ", "))
+		dialog.ShowForm("Session Tags", "Save", "Cancel", []*widget.FormItem{
+			widget.NewFormItem("Tags", tagsEntry),
+		}, func(b bool) {
+			if !b {
+				return
+			}
+			tags, err := parseTags(tagsEntry.Text)
+			if err != nil {
+				dialog.ShowError(err, window)
+				return
+			}
+			if err := setTags(db, session, tags); err != nil {
+				dialog.ShowError(err, window)
+				return
+			}
+			refreshChan <- true
+		}, window)
+	})
+
+	auditButton = widget.NewButton("Audit", func() {
+		showAuditDialog(db, session.Id, window)
+	})
+
+	buttonContainer := container.NewHBox(editButton, saveButton, runButton, stopButton, cancelButton, tagsButton, auditButton)
 
 	content := container.NewStack(viewScroll, editScroll)
 
-	showViewMode()
+	// Sessions that never ran, new ones and clones, open ready to edit.
+	if session.Status == pb.WorkloadStatus_PENDING {
+		showEditMode()
+	} else {
+		showViewMode()
+	}
 	startPolling()
 
 	return container.NewBorder(
 		container.NewBorder(nil, nil, nil, container.NewHBox(buttonContainer, closeButton), label),
-		statusLabel,
+		container.NewVBox(errorLabel, statusLabel, progressBar),
 		nil,
 		nil,
 		content,
 	)
 }
 
+// showAuditDialog shows the model calls made for session id, with what was
+// sent and what came back, newest first.
+func showAuditDialog(db database.Datastore, id string, window fyne.Window) {
+	entries, err := db.ListAuditEntries(id)
+	if err != nil {
+		dialog.ShowError(err, window)
+		return
+	}
+	if len(entries) == 0 {
+		dialog.ShowInformation("Audit", "No model calls recorded for this session yet.", window)
+		return
+	}
+
+	text := func(s string) *widget.Label {
+		label := widget.NewLabel(s)
+		label.Wrapping = fyne.TextWrapWord
+		label.Selectable = true
+		return label
+	}
+	heading := func(s string) *widget.Label {
+		return widget.NewLabelWithStyle(s, fyne.TextAlignLeading, fyne.TextStyle{Bold: true})
+	}
+	accordion := widget.NewAccordion()
+	for i := len(entries) - 1; i >= 0; i-- {
+		entry := entries[i]
+		details := container.NewVBox()
+		if entry.SystemPrompt != "" {
+			details.Add(heading("System prompt"))
+			details.Add(text(entry.SystemPrompt))
+		}
+		details.Add(heading("Input"))
+		details.Add(text(entry.Input))
+		if entry.Error != "" {
+			details.Add(heading("Error"))
+			details.Add(text(entry.Error))
+		} else {
+			details.Add(heading("Output"))
+			details.Add(text(entry.Output))
+		}
+		title := fmt.Sprintf("#%d %s  %s  %d + %d tokens", i+1, entry.Timestamp.Local().Format("2006-01-02 15:04:05"), entry.ModelID, entry.PromptTokens, entry.CompletionTokens)
+		if entry.Error != "" {
+			title += "  (failed)"
+		}
+		accordion.Append(widget.NewAccordionItem(title, details))
+	}
+	accordion.Open(0)
+
+	d := dialog.NewCustom(fmt.Sprintf("Audit: %d model call(s)", len(entries)), "Close", container.NewVScroll(accordion), window)
+	d.Resize(fyne.NewSize(800, 600))
+	d.Show()
+}
+
+// parseTags reads the tags typed into a form, separated by commas or spaces.
+func parseTags(text string) ([]string, error) {
+	return database.NormalizeTags(strings.FieldsFunc(text, func(r rune) bool {
+		return r == ',' || unicode.IsSpace(r)
+	}))
+}
+
+// setTags gives session the tags, adding and removing tags in the database
+// to match. A session that was never saved keeps them until it is.
+func setTags(db database.Datastore, session *pb.Workload, tags []string) error {
+	stored, err := db.GetSession(session.Id)
+	if errors.Is(err, sql.ErrNoRows) {
+		session.Tags = tags
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, tag := range stored.Tags {
+		if !slices.Contains(tags, tag) {
+			if err := db.RemoveTag(session.Id, tag); err != nil {
+				return err
+			}
+		}
+	}
+	for _, tag := range tags {
+		if !slices.Contains(stored.Tags, tag) {
+			if err := db.AddTag(session.Id, tag); err != nil {
+				return err
+			}
+		}
+	}
+	session.Tags = tags
+	return nil
+}
+
+// taggedSessions keeps the sessions tagged tag.
+func taggedSessions(sessions []*pb.Workload, tag string) ([]*pb.Workload, error) {
+	tag, err := database.NormalizeTag(tag)
+	if err != nil {
+		return nil, err
+	}
+	var tagged []*pb.Workload
+	for _, session := range sessions {
+		if slices.Contains(session.Tags, tag) {
+			tagged = append(tagged, session)
+		}
+	}
+	return tagged, nil
+}
+
+// findDuplicates flags the sessions that look like an older one, when the
+// duplicate_threshold setting is on.
+func findDuplicates(db database.Datastore, sessions []*pb.Workload) map[string]database.SimilarSession {
+	threshold, err := database.DuplicateThreshold(db)
+	if err != nil {
+		log.Printf("Error reading settings: %s", err)
+	}
+	return database.FindDuplicates(sessions, threshold)
+}
+
+// isScheduled reports whether the session has a schedule.
+func isScheduled(id string) bool {
+	schedule, err := sessionScheduler.Get(id)
+	if err != nil {
+		log.Printf("Error checking schedule of session %s: %s", id, err)
+	}
+	return schedule != nil
+}
+
+// sessionTitle returns the session tab heading including token usage, if any.
+func sessionTitle(session *pb.Workload) string {
+	if session.PromptTokens == 0 && session.CompletionTokens == 0 {
+		return fmt.Sprintf("Session: %s", session.Name)
+	}
+	return fmt.Sprintf("Session: %s (tokens: %d prompt / %d completion, ~$%.4f)", session.Name, session.PromptTokens, session.CompletionTokens, session.EstimatedCost)
+}
+
 func agentNames(agents []*amodels.Agent) []string {
 	names := make([]string, len(agents))
 	for i, a := range agents {
@@ -625,10 +1362,18 @@ func modelNames(models []*amodels.Model) []string {
 	return names
 }
 
-func runWorker(id int, workloadChan <-chan *pb.Workload) {
-	for workload := range workloadChan {
-		log.Printf("Worker %d processing workload: %s", id, workload.Id)
-		worker.ProcessWorkload(workload)
+// statusImportance picks the color a session status is shown in.
+func statusImportance(status pb.WorkloadStatus_Status) widget.Importance {
+	switch status {
+	case pb.WorkloadStatus_COMPLETED:
+		return widget.SuccessImportance
+	case pb.WorkloadStatus_FAILED:
+		return widget.DangerImportance
+	case pb.WorkloadStatus_CANCELLED:
+		return widget.WarningImportance
+	case pb.WorkloadStatus_RUNNING:
+		return widget.HighImportance
+	default:
+		return widget.MediumImportance
 	}
-	log.Printf("Worker %d shutting down", id)
 }
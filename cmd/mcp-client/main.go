@@ -1,24 +1,39 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
 	"log"
+	"os"
 
-	"github.com/modelcontextprotocol/go-sdk/mcp"
 	localmcp "github.com/nieveai/d-agents/internal/mcp"
 )
 
 func main() {
+	transport := flag.String("transport", "stdio", "How to reach the server: stdio, sse, http, or command (runs the remaining arguments as the server).")
+	endpoint := flag.String("endpoint", "", "The server URL for the sse and http transports.")
+
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [-transport stdio|sse|http] [-endpoint <url>]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s -transport command <server> [args...]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	cfg := localmcp.TransportConfig{Transport: *transport, Endpoint: *endpoint}
+	if *transport == "command" && flag.NArg() > 0 {
+		cfg.Command = flag.Arg(0)
+		cfg.Args = flag.Args()[1:]
+	}
+
 	// Create a new MCP client.
 	client := localmcp.NewClient()
 
-	// Create a new stdio transport.
-	transport := &mcp.StdioTransport{}
-
 	// Connect to the server.
-	session, err := localmcp.Connect(client, transport)
+	session, err := localmcp.Dial(context.Background(), client, cfg)
 	if err != nil {
-		log.Fatalf("failed to connect to MCP server: %v", err)
+		log.Fatal(err)
 	}
 	defer session.Close()
 
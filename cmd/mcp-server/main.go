@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+	"github.com/nieveai/d-agents/internal/database"
+	localmcp "github.com/nieveai/d-agents/internal/mcp"
+	"github.com/nieveai/d-agents/internal/worker"
+)
+
+// mcp-server lets MCP hosts run d-agents over stdio. Logs go to stderr since
+// stdout carries the protocol.
+func main() {
+	openDB := database.RegisterFlags(flag.CommandLine)
+	flag.Parse()
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	db, err := openDB()
+	if err != nil {
+		log.Fatalf("Error opening database: %s", err)
+	}
+
+	dbModels, err := db.ListModels()
+	if err != nil {
+		log.Fatalf("Error loading models from database: %s", err)
+	}
+	if err := worker.Init(ctx, dbModels, db); err != nil {
+		log.Fatalf("Failed to initialize worker: %v", err)
+	}
+	defer database.CloseNeo4jDriver()
+
+	server := localmcp.NewServer(db, worker.ProcessWorkload)
+	if err := server.Run(ctx, &mcp.StdioTransport{}); err != nil && ctx.Err() == nil {
+		log.Fatalf("MCP server stopped: %v", err)
+	}
+}
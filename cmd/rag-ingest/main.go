@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/nieveai/d-agents/internal/agents"
+	"github.com/nieveai/d-agents/internal/database"
+	"github.com/nieveai/d-agents/internal/models"
+	"github.com/nieveai/d-agents/internal/worker"
+)
+
+func main() {
+	modelID := flag.String("model", "", "ID, alias or model ID of the embedding model to use. This flag is required.")
+	collection := flag.String("collection", agents.DefaultCollection, "Collection to add the documents to")
+	chunkSize := flag.Int("chunk-size", agents.DefaultChunkSize, "Maximum size of a chunk in characters")
+	openDB := database.RegisterFlags(flag.CommandLine)
+
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s -model <model_id> [-collection name] <file>...\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Splits text files into chunks, embeds them and stores them for RAGAgent.\n\n")
+		fmt.Fprintf(os.Stderr, "Flags:\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if *modelID == "" || flag.NArg() < 1 {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	db, err := openDB()
+	if err != nil {
+		log.Fatalf("Error opening database: %s", err)
+	}
+
+	model, err := database.ResolveModel(db, *modelID)
+	if err != nil {
+		log.Fatalf("Error loading model '%s': %s", *modelID, err)
+	}
+
+	ctx := context.Background()
+	client, err := worker.NewLLMClient(ctx, []*models.Model{model})
+	if err != nil {
+		log.Fatalf("Failed to create LLM client: %v", err)
+	}
+
+	total := 0
+	for _, path := range flag.Args() {
+		text, err := os.ReadFile(path)
+		if err != nil {
+			log.Fatalf("Failed to read %s: %v", path, err)
+		}
+		n, err := agents.IngestDocument(ctx, db, client, model.ID, *collection, path, string(text), *chunkSize)
+		if err != nil {
+			log.Fatalf("Failed to ingest %s: %v", path, err)
+		}
+		log.Printf("Ingested %s: %d chunks", path, n)
+		total += n
+	}
+	log.Printf("Added %d chunks to collection %s", total, *collection)
+}
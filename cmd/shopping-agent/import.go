@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/nieveai/d-agents/internal/agents"
+	"github.com/nieveai/d-agents/internal/database"
+)
+
+// dateFormats are the date formats accepted in CSV imports. Slashed dates
+// with the year last are read month first, as in 01/31/2024.
+var dateFormats = []string{
+	time.RFC3339,
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+	"2006/01/02",
+	"01/02/2006",
+	"Jan 2, 2006",
+	"2 Jan 2006",
+}
+
+// rowError is a CSV row that couldn't be imported.
+type rowError struct {
+	Line int
+	Err  error
+}
+
+func (e rowError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Err)
+}
+
+// importRow is a validated CSV row.
+type importRow struct {
+	Name     string
+	Price    float64
+	Currency string
+	Date     time.Time
+	Source   string
+	URL      string
+}
+
+// importProducts records the prices in a CSV with the columns name, price,
+// date, source and url, with an optional header row. Rows that can't be read
+// or saved are returned and skipped, the rest are still imported. An error is
+// only returned if r can't be read at all.
+func importProducts(db *database.ShoppingDB, r io.Reader) (int, []rowError, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	reader.Comment = '#'
+
+	imported := 0
+	var rowErrs []rowError
+	for first := true; ; first = false {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			rowErrs = append(rowErrs, rowError{Line: parseErr.Line, Err: parseErr.Err})
+			continue
+		}
+		if err != nil {
+			return imported, rowErrs, err
+		}
+		line, _ := reader.FieldPos(0)
+		if first && strings.EqualFold(strings.TrimSpace(record[0]), "name") {
+			continue
+		}
+
+		row, err := parseRow(record)
+		if err == nil {
+			err = db.InsertProduct(row.Name, row.Price, row.Currency, row.Date, row.Source, row.URL)
+		}
+		if err != nil {
+			rowErrs = append(rowErrs, rowError{Line: line, Err: err})
+			continue
+		}
+		imported++
+	}
+	return imported, rowErrs, nil
+}
+
+func parseRow(record []string) (importRow, error) {
+	if len(record) != 5 {
+		return importRow{}, fmt.Errorf("expected 5 fields (name, price, date, source, url), got %d", len(record))
+	}
+	for i := range record {
+		record[i] = strings.TrimSpace(record[i])
+	}
+
+	row := importRow{Name: record[0], Source: record[3], URL: record[4]}
+	if row.Name == "" {
+		return importRow{}, fmt.Errorf("missing product name")
+	}
+	var err error
+	row.Price, row.Currency, err = agents.ParsePriceText(record[1])
+	if err != nil {
+		return importRow{}, err
+	}
+	// ParsePriceText reads scraped text and drops the sign.
+	if row.Price <= 0 || strings.HasPrefix(record[1], "-") {
+		return importRow{}, fmt.Errorf("price %q is not positive", record[1])
+	}
+	row.Date, err = parseDate(record[2])
+	if err != nil {
+		return importRow{}, err
+	}
+	if row.URL != "" {
+		u, err := url.Parse(row.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return importRow{}, fmt.Errorf("invalid url %q", row.URL)
+		}
+	}
+	return row, nil
+}
+
+func parseDate(s string) (time.Time, error) {
+	for _, format := range dateFormats {
+		if t, err := time.Parse(format, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("can't read date %q, use e.g. 2024-01-31", s)
+}
+
+// importFile imports the CSV at path into the shopping database at dbPath and
+// reports the rows that were skipped.
+func importFile(path, dbPath string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	db, err := database.NewShoppingDB(dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	imported, rowErrs, err := importProducts(db, file)
+	for _, rowErr := range rowErrs {
+		fmt.Fprintf(os.Stderr, "%s: skipped %s\n", path, rowErr)
+	}
+	fmt.Printf("Imported %d rows from %s, skipped %d.\n", imported, path, len(rowErrs))
+	if err != nil {
+		return fmt.Errorf("error reading %s: %w", path, err)
+	}
+	if len(rowErrs) > 0 {
+		return fmt.Errorf("%d rows could not be imported", len(rowErrs))
+	}
+	return nil
+}
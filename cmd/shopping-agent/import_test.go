@@ -0,0 +1,133 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nieveai/d-agents/internal/database"
+)
+
+const pricesCSV = `name,price,date,source,url
+USB-C Hub,24.99 EUR,2024-01-31,shop.test,https://shop.test/hub
+# a comment
+USB-C Hub,$19.99,02/01/2024,other.test,https://other.test/hub
+4K Monitor,299,"Mar 5, 2024",shop.test,
+Broken Row,12.50,2024-01-31
+,9.99,2024-01-31,shop.test,https://shop.test/x
+Keyboard,free,2024-01-31,shop.test,https://shop.test/kb
+Mouse,-5,2024-01-31,shop.test,https://shop.test/mouse
+Pad,0.00,2024-01-31,shop.test,https://shop.test/pad
+Webcam,49.99,31.01.2024,shop.test,https://shop.test/cam
+Speaker,79.99,2024-01-31,shop.test,ftp://shop.test/speaker
+Cable,"5.99,2024-01-31,shop.test,https://shop.test/cable
+`
+
+func newTestShoppingDB(t *testing.T) *database.ShoppingDB {
+	t.Helper()
+	db, err := database.NewShoppingDB(filepath.Join(t.TempDir(), "shopping.db"))
+	if err != nil {
+		t.Fatalf("NewShoppingDB: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestImportProducts(t *testing.T) {
+	db := newTestShoppingDB(t)
+	imported, rowErrs, err := importProducts(db, strings.NewReader(pricesCSV))
+	if err != nil {
+		t.Fatalf("importProducts: %v", err)
+	}
+	if imported != 3 {
+		t.Errorf("imported %d rows, want 3", imported)
+	}
+
+	products, err := db.GetAllProducts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]*database.Product)
+	for _, p := range products {
+		got[p.Name+" "+p.Source] = p
+	}
+	if p := got["USB-C Hub shop.test"]; p == nil || p.Price != 24.99 || p.Currency != "EUR" || !p.Date.Equal(time.Date(2024, 1, 31, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("hub at shop.test = %+v", p)
+	}
+	// Slashed dates are month first.
+	if p := got["USB-C Hub other.test"]; p == nil || p.Price != 19.99 || !p.Date.Equal(time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("hub at other.test = %+v", p)
+	}
+	if p := got["4K Monitor shop.test"]; p == nil || p.Price != 299 || p.URL != "" || !p.Date.Equal(time.Date(2024, 3, 5, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("monitor = %+v", p)
+	}
+	if len(products) != 3 {
+		t.Errorf("stored %d products, want 3", len(products))
+	}
+
+	wantErrs := []struct {
+		line int
+		text string
+	}{
+		{6, "expected 5 fields"},
+		{7, "missing product name"},
+		{8, "free"},
+		{9, "not positive"},
+		{10, "not positive"},
+		{11, `can't read date "31.01.2024"`},
+		{12, "invalid url"},
+		{13, "quoted-field"},
+	}
+	if len(rowErrs) != len(wantErrs) {
+		t.Fatalf("row errors = %v, want %d", rowErrs, len(wantErrs))
+	}
+	for i, want := range wantErrs {
+		if rowErrs[i].Line != want.line || !strings.Contains(rowErrs[i].Error(), want.text) {
+			t.Errorf("row error %d = %q, want line %d with %q", i+1, rowErrs[i], want.line, want.text)
+		}
+	}
+}
+
+func TestImportProductsWithoutHeader(t *testing.T) {
+	db := newTestShoppingDB(t)
+	imported, rowErrs, err := importProducts(db, strings.NewReader("Hub,24.99,2024-01-31,shop.test,https://shop.test/hub\n"))
+	if imported != 1 || len(rowErrs) != 0 || err != nil {
+		t.Errorf("importProducts = %d, %v, %v, want the one row", imported, rowErrs, err)
+	}
+}
+
+func TestParseDate(t *testing.T) {
+	want := time.Date(2024, 1, 31, 0, 0, 0, 0, time.UTC)
+	for _, s := range []string{"2024-01-31", "2024/01/31", "01/31/2024", "Jan 31, 2024", "31 Jan 2024", "2024-01-31T00:00:00Z", "2024-01-31 00:00:00"} {
+		if got, err := parseDate(s); err != nil || !got.Equal(want) {
+			t.Errorf("parseDate(%q) = %s, %v, want %s", s, got, err, want)
+		}
+	}
+	if _, err := parseDate("yesterday"); err == nil {
+		t.Error("parseDate accepted yesterday")
+	}
+}
+
+func TestImportFile(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "shopping.db")
+	if err := importFile(filepath.Join(dir, "missing.csv"), dbPath); err == nil {
+		t.Error("importFile of a missing file succeeded")
+	}
+
+	path := filepath.Join(dir, "prices.csv")
+	if err := os.WriteFile(path, []byte(pricesCSV), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := importFile(path, dbPath); err == nil || !strings.Contains(err.Error(), "8 rows could not be imported") {
+		t.Errorf("importFile = %v, want the skipped rows counted", err)
+	}
+	if err := os.WriteFile(path, []byte("Hub,24.99,2024-01-31,shop.test,https://shop.test/hub\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := importFile(path, dbPath); err != nil {
+		t.Errorf("importFile of good rows = %v", err)
+	}
+}
@@ -1,15 +1,232 @@
 package main
 
 import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
 	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
+	"github.com/google/uuid"
+	"github.com/nieveai/d-agents/internal/agents"
+	"github.com/nieveai/d-agents/internal/browser"
+	"github.com/nieveai/d-agents/internal/database"
+	m "github.com/nieveai/d-agents/internal/models"
 	"github.com/nieveai/d-agents/internal/worker"
+	pb "github.com/nieveai/d-agents/proto"
 )
 
+// product is one line of the products file: a product name followed by the
+// URL to scrape for it.
+type product struct {
+	Name string
+	URL  string
+}
+
 func main() {
-	log.Println("Starting shopping agent worker...")
-	// In a real implementation, this worker would connect to the controller
-	// to receive workloads. For now, it just starts and waits.
-	worker.ProcessWorkload( /* workload for shopping agent */ )
-	log.Println("Shopping agent worker finished.")
-}
\ No newline at end of file
+	// --- Command-line Flags ---
+	modelID := flag.String("model", "", "The ID, alias or model ID of the model to use for processing. This flag is required.")
+	productName := flag.String("name", "", "The name of a single product to check.")
+	productURL := flag.String("url", "", "The URL to scrape for the product given by -name.")
+	productsFile := flag.String("products", "", "A text file with one product per line: <product name> <url>. Use instead of -name and -url.")
+	nextPage := flag.String("next-page", "", "The CSS selector of the \"next page\" link of paginated product listings, e.g. a[rel=next].")
+	maxPages := flag.Int("max-pages", 0, "The most pages to follow with -next-page. Zero uses the agent's default.")
+	interval := flag.Duration("interval", 0, "How often to scrape and check for price drops, e.g. 6h. Zero runs once and exits.")
+	notifyConfig := flag.String("notify-config", "", "A JSON file with the notification channels (smtp_host, email_to, slack_webhook, webhook_url, telegram_bot_token, telegram_chat_id, ...).")
+	dryRun := flag.Bool("dry-run", false, "Log the notifications that would be sent instead of sending them.")
+	headful := flag.Bool("headful", false, "Show the browser window while scraping.")
+	userAgent := flag.String("user-agent", "", "The user agent to scrape with.")
+	proxy := flag.String("proxy", "", "A proxy server for the browser, e.g. socks5://127.0.0.1:1080.")
+	browserTimeout := flag.Duration("browser-timeout", browser.DefaultTimeout, "Give up on a product page after this long.")
+	importCSV := flag.String("import", "", "A CSV file of past prices (name, price, date, source, url) to load into the shopping database, then exit.")
+	shoppingDB := flag.String("shopping-db", database.DefaultShoppingDB, "The SQLite file the prices are kept in.")
+	openDB := database.RegisterFlags(flag.CommandLine)
+
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s -model <model_id> (-name <product> -url <url> | -products <file_path>) [-next-page <selector> [-max-pages n]] [-interval 6h] [-notify-config <file>] [-dry-run]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s -import <prices.csv>\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Scrapes product prices into the -shopping-db file and sends alerts when prices drop.\n\n")
+		fmt.Fprintf(os.Stderr, "Flags:\n")
+		flag.PrintDefaults()
+	}
+
+	flag.Parse()
+	agents.SetShoppingDBPath(*shoppingDB)
+
+	if *importCSV != "" {
+		if err := importFile(*importCSV, *shoppingDB); err != nil {
+			log.Fatalf("Import incomplete: %v", err)
+		}
+		return
+	}
+
+	single := *productName != "" || *productURL != ""
+	if *modelID == "" || single == (*productsFile != "") || (single && (*productName == "" || *productURL == "")) {
+		flag.Usage()
+		os.Exit(1)
+	}
+	// --- End Flags ---
+
+	products := []product{{Name: *productName, URL: *productURL}}
+	if *productsFile != "" {
+		var err error
+		products, err = readProducts(*productsFile)
+		if err != nil {
+			log.Fatalf("Failed to read products: %v", err)
+		}
+		if len(products) == 0 {
+			log.Fatalf("No products found in %s", *productsFile)
+		}
+	}
+
+	var notificationConfig agents.NotificationConfig
+	if *notifyConfig != "" {
+		data, err := os.ReadFile(*notifyConfig)
+		if err != nil {
+			log.Fatalf("Failed to read notification config: %v", err)
+		}
+		if err := json.Unmarshal(data, &notificationConfig); err != nil {
+			log.Fatalf("Failed to parse notification config: %v", err)
+		}
+	}
+	notificationConfig.DryRun = *dryRun || notificationConfig.DryRun
+	config, err := json.Marshal(notificationConfig)
+	if err != nil {
+		log.Fatalf("Failed to encode notification config: %v", err)
+	}
+	crawlConfig, err := json.Marshal(agents.ShoppingConfig{NextPageSelector: *nextPage, MaxPages: *maxPages})
+	if err != nil {
+		log.Fatalf("Failed to encode crawl config: %v", err)
+	}
+
+	// --- Database and Model Initialization ---
+	db, err := openDB()
+	if err != nil {
+		log.Fatalf("Error opening database: %s", err)
+	}
+
+	selectedModel, err := database.ResolveModel(db, *modelID)
+	if err != nil {
+		log.Fatalf("Error loading model: %s", err)
+	}
+	log.Printf("Using model: %s (%s/%s)", selectedModel.ID, selectedModel.Provider, selectedModel.ModelID)
+
+	genAIClient, err := worker.NewLLMClient(context.Background(), []*m.Model{selectedModel})
+	if err != nil {
+		log.Fatalf("Failed to create GenAI client: %v", err)
+	}
+
+	browserOpts := browser.DefaultOptions()
+	browserOpts.Headless = !*headful
+	browserOpts.UserAgent = *userAgent
+	browserOpts.Proxy = *proxy
+	browserOpts.Timeout = *browserTimeout
+	pool := browser.NewBrowserPool(browserOpts)
+	defer pool.Close()
+	agents.SetBrowserPool(pool)
+
+	shoppingAgent, err := agents.NewShoppingAgent()
+	if err != nil {
+		log.Fatalf("Failed to create shopping agent: %v", err)
+	}
+	notificationAgent, err := agents.NewShoppingNotificationAgent()
+	if err != nil {
+		log.Fatalf("Failed to create shopping notification agent: %v", err)
+	}
+	// --- End Initialization ---
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigChan
+		log.Println("Shutting down shopping agent...")
+		cancel()
+	}()
+
+	runOnce := func() {
+		for _, p := range products {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("Scraping %s from %s", p.Name, p.URL)
+			workload := &pb.Workload{
+				Id:        uuid.New().String(),
+				Name:      p.Name,
+				AgentType: "ShoppingAgent",
+				Config:    string(crawlConfig),
+				Payload:   []byte(p.URL),
+				Models:    []string{selectedModel.ID},
+				Status:    pb.WorkloadStatus_RUNNING,
+			}
+			if err := shoppingAgent.DoWork(ctx, workload, genAIClient); err != nil {
+				log.Printf("Failed to scrape %s: %v", p.Name, err)
+			}
+		}
+
+		workload := &pb.Workload{
+			Id:        uuid.New().String(),
+			Name:      "price drop notifications",
+			AgentType: "ShoppingNotificationAgent",
+			Config:    string(config),
+			Status:    pb.WorkloadStatus_RUNNING,
+		}
+		if err := notificationAgent.DoWork(ctx, workload, genAIClient); err != nil {
+			log.Printf("Failed to send notifications: %v", err)
+		}
+		log.Println(string(workload.Payload))
+	}
+
+	runOnce()
+	if *interval <= 0 {
+		return
+	}
+
+	log.Printf("Checking prices every %s", *interval)
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			runOnce()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// readProducts reads a products file. The URL is the last field on each line,
+// so product names may contain spaces. Blank lines and # comments are skipped.
+func readProducts(path string) ([]product, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var products []product
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.LastIndexAny(line, " \t")
+		if i < 0 {
+			return nil, fmt.Errorf("expected \"<product name> <url>\", got %q", line)
+		}
+		products = append(products, product{
+			Name: strings.TrimSpace(line[:i]),
+			URL:  line[i+1:],
+		})
+	}
+	return products, scanner.Err()
+}
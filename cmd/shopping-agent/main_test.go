@@ -0,0 +1,130 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/nieveai/d-agents/internal/database"
+	m "github.com/nieveai/d-agents/internal/models"
+)
+
+func TestReadProducts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "products.txt")
+	content := "# wish list\nUSB-C Hub https://shop.test/hub\n\n  4K Monitor 27in\thttps://shop.test/monitor  \n"
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	products, err := readProducts(path)
+	if err != nil {
+		t.Fatalf("readProducts: %v", err)
+	}
+	want := []product{{"USB-C Hub", "https://shop.test/hub"}, {"4K Monitor 27in", "https://shop.test/monitor"}}
+	if len(products) != len(want) || products[0] != want[0] || products[1] != want[1] {
+		t.Errorf("products = %v, want %v", products, want)
+	}
+
+	if err := os.WriteFile(path, []byte("no-url-here\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readProducts(path); err == nil {
+		t.Error("readProducts accepted a line without a URL")
+	}
+}
+
+// buildShoppingAgent builds the command into a temporary directory.
+func buildShoppingAgent(t *testing.T) string {
+	t.Helper()
+	bin := filepath.Join(t.TempDir(), "shopping-agent")
+	out, err := exec.Command("go", "build", "-o", bin, ".").CombinedOutput()
+	if err != nil {
+		t.Fatalf("go build: %v\n%s", err, out)
+	}
+	return bin
+}
+
+func TestUsage(t *testing.T) {
+	bin := buildShoppingAgent(t)
+	for _, args := range [][]string{
+		nil,
+		{"-model", "m1"},
+		{"-model", "m1", "-name", "hub"},
+		{"-model", "m1", "-name", "hub", "-url", "https://shop.test", "-products", "p.txt"},
+	} {
+		err := exec.Command(bin, args...).Run()
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) || exitErr.ExitCode() != 1 {
+			t.Errorf("%v: err = %v, want exit status 1", args, err)
+		}
+	}
+}
+
+// chromeNames are the browsers chromedp looks for.
+var chromeNames = []string{"headless_shell", "headless-shell", "chromium", "chromium-browser", "google-chrome", "google-chrome-stable", "chrome"}
+
+func TestSmoke(t *testing.T) {
+	found := false
+	for _, name := range chromeNames {
+		if _, err := exec.LookPath(name); err == nil {
+			found = true
+			break
+		}
+	}
+	if !found {
+		t.Skip("no Chrome to scrape with")
+	}
+
+	page := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<html><body><div class="product">USB-C Hub <span>$24.99</span></div></body></html>`)
+	}))
+	defer page.Close()
+	// The mock model finds one product on any page.
+	model := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		items := `{"items": [{"name": "USB-C Hub", "price": 24.99, "currency": "USD", "source": "shop.test", "url": "` + page.URL + `"}]}`
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"id":      "chatcmpl-1",
+			"object":  "chat.completion",
+			"choices": []map[string]any{{"index": 0, "message": map[string]any{"role": "assistant", "content": items}, "finish_reason": "stop"}},
+		})
+	}))
+	defer model.Close()
+
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "agents.db")
+	store, err := database.NewSQLiteDatastore(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.AddModel(&m.Model{ID: "mock", ModelID: "mock-model", APISpec: "openai", APIKey: "test-key", APIURL: model.URL})
+	store.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	shoppingDB := filepath.Join(dir, "shopping.db")
+	cmd := exec.Command(buildShoppingAgent(t), "-db", dbPath, "-shopping-db", shoppingDB, "-model", "mock", "-name", "USB-C Hub", "-url", page.URL, "-dry-run")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("shopping-agent: %v\n%s", err, out)
+	}
+
+	db, err := sql.Open("sqlite3", shoppingDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var n int
+	if err := db.QueryRow("SELECT COUNT(*) FROM products WHERE name = 'USB-C Hub'").Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("%d products stored, want 1", n)
+	}
+}
@@ -2,37 +2,113 @@ package main
 
 import (
 	"context"
+	"flag"
 	"log"
 	"os"
 	"os/signal"
 	"syscall"
 
+	"github.com/nieveai/d-agents/internal/agents"
+	"github.com/nieveai/d-agents/internal/browser"
 	"github.com/nieveai/d-agents/internal/database"
+	"github.com/nieveai/d-agents/internal/health"
+	"github.com/nieveai/d-agents/internal/logging"
+	"github.com/nieveai/d-agents/internal/metrics"
 	"github.com/nieveai/d-agents/internal/worker"
 )
 
 func main() {
+	controllerAddr := flag.String("controller", "", "Address of the controller to receive workloads from (e.g. localhost:50051)")
+	workerID := flag.String("id", "", "Worker ID reported to the controller (defaults to the hostname)")
+	healthAddr := flag.String("health-addr", "", "Address to serve /healthz and /readyz on (e.g. :8081); disabled when empty")
+	metricsAddr := flag.String("metrics-addr", "", "Address to serve Prometheus metrics on (e.g. :9090); disabled when empty")
+	browserTimeout := flag.Duration("browser-timeout", browser.DefaultTimeout, "Give up on a page the agents fetch after this long")
+	audit := flag.Bool("audit", true, "Record the prompt and response of every model call in the session's audit trail")
+	auditRedactInput := flag.Bool("audit-redact-input", false, "Leave the input out of the audit trail, for sensitive data")
+	agentLimits := flag.String("agent-limits", "", "Most workloads of an agent type run at once, e.g. ShoppingAgent=2,ChatAgent=10 (default the agent_limits setting)")
+	openDB := database.RegisterFlags(flag.CommandLine)
+	setupLogging := logging.RegisterFlags()
+	flag.Parse()
+
+	if err := setupLogging(); err != nil {
+		log.Fatal(err)
+	}
+
 	log.Println("Starting worker...")
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	browserOpts := browser.DefaultOptions()
+	browserOpts.Timeout = *browserTimeout
+	pool := browser.NewBrowserPool(browserOpts)
+	defer pool.Close()
+	agents.SetBrowserPool(pool)
+
 	// Initialize the database connection
-	db, err := database.NewSQLiteDatastore("d-agents.db")
+	db, err := openDB()
 	if err != nil {
 		log.Fatalf("Failed to initialize database: %v", err)
 	}
+	if err := database.SeedSettings(db, database.ConfigFile, database.CredentialsFile); err != nil {
+		log.Printf("Error seeding settings: %s", err)
+	}
+	if err := worker.ConfigureAgentLimits(*agentLimits, db); err != nil {
+		log.Fatalf("Invalid agent limits: %s", err)
+	}
 
-	// Initialize the worker
-	if err := worker.Init(ctx, nil, db); err != nil {
+	// Initialize the worker. The sessions of a remote worker live on the
+	// controller, the local database only has its models and settings.
+	worker.SetAudit(*audit, *auditRedactInput)
+	if *controllerAddr != "" {
+		err = worker.InitRemote(ctx, nil, db)
+	} else {
+		err = worker.Init(ctx, nil, db)
+	}
+	if err != nil {
 		log.Fatalf("Failed to initialize worker: %v", err)
 	}
 	defer database.CloseNeo4jDriver()
 
-	// In a real implementation, this worker would connect to the controller
-	// to receive workloads. For now, it just starts and waits.
+	if *metricsAddr != "" {
+		go func() {
+			if err := metrics.Serve(*metricsAddr); err != nil {
+				log.Printf("Metrics server stopped: %s", err)
+			}
+		}()
+	}
+
+	if *healthAddr != "" {
+		checker := health.NewServiceChecker(db)
+		go func() {
+			if err := checker.Serve(*healthAddr); err != nil {
+				log.Printf("Health server stopped: %s", err)
+			}
+		}()
+	}
+
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 
-	<-sigChan
-	log.Println("Shutting down worker...")
-}
\ No newline at end of file
+	if *controllerAddr == "" {
+		log.Println("No -controller given, nothing to do until interrupted.")
+		<-sigChan
+		log.Println("Shutting down worker...")
+		return
+	}
+
+	id := *workerID
+	if id == "" {
+		id, _ = os.Hostname()
+	}
+
+	go func() {
+		<-sigChan
+		log.Println("Shutting down worker...")
+		cancel()
+	}()
+
+	log.Printf("Worker %s receiving workloads from %s", id, *controllerAddr)
+	if err := worker.RunRemoteWorker(ctx, *controllerAddr, id); err != nil {
+		log.Fatalf("Remote worker stopped: %v", err)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/nieveai/d-agents/internal/database"
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage:\n")
+	fmt.Fprintf(os.Stderr, "  %s export [-db database] [-redact-keys] [-o file]\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "  %s import [-db database] <file>\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "Exports or imports all agents, models and sessions as a single JSON file.\n")
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(1)
+	}
+
+	switch os.Args[1] {
+	case "export":
+		fs := flag.NewFlagSet("export", flag.ExitOnError)
+		redactKeys := fs.Bool("redact-keys", false, "Leave the API keys of models out of the export")
+		output := fs.String("o", "", "File to write to (default stdout)")
+		openDB := database.RegisterFlags(fs)
+		fs.Parse(os.Args[2:])
+
+		db := openDatastore(openDB)
+		if *output == "" {
+			if err := database.ExportWorkspace(db, os.Stdout, *redactKeys); err != nil {
+				log.Fatalf("Failed to export workspace: %v", err)
+			}
+			return
+		}
+		f, err := os.Create(*output)
+		if err != nil {
+			log.Fatalf("Failed to create %s: %v", *output, err)
+		}
+		if err := database.ExportWorkspace(db, f, *redactKeys); err != nil {
+			log.Fatalf("Failed to export workspace: %v", err)
+		}
+		if err := f.Close(); err != nil {
+			log.Fatalf("Failed to write %s: %v", *output, err)
+		}
+		log.Printf("Exported workspace to %s", *output)
+
+	case "import":
+		fs := flag.NewFlagSet("import", flag.ExitOnError)
+		openDB := database.RegisterFlags(fs)
+		fs.Parse(os.Args[2:])
+		if fs.NArg() != 1 {
+			usage()
+			os.Exit(1)
+		}
+		path := fs.Arg(0)
+		f, err := os.Open(path)
+		if err != nil {
+			log.Fatalf("Failed to open %s: %v", path, err)
+		}
+		defer f.Close()
+
+		db := openDatastore(openDB)
+		if err := database.ImportWorkspace(db, f); err != nil {
+			log.Fatalf("Failed to import workspace: %v", err)
+		}
+		log.Printf("Imported %s", path)
+
+	default:
+		usage()
+		os.Exit(1)
+	}
+}
+
+func openDatastore(open func() (database.Datastore, error)) database.Datastore {
+	db, err := open()
+	if err != nil {
+		log.Fatalf("Error opening database: %s", err)
+	}
+	return db
+}
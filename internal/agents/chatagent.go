@@ -1,15 +1,53 @@
 package agents
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
+	"strings"
+	"time"
 
 	m "github.com/nieveai/d-agents/internal/models"
 	pb "github.com/nieveai/d-agents/proto"
 )
 
-type ChatAgent struct{}
+type ChatAgent struct {
+	// OnUpdate, when set, is called with the partially updated workload while
+	// a streamed response is being received.
+	OnUpdate func(workload *pb.Workload)
+}
+
+func init() {
+	m.RegisterAgent("ChatAgent", func() (m.AgentInterface, error) {
+		return &ChatAgent{}, nil
+	})
+}
+
+// SetOnUpdate implements m.UpdateNotifier.
+func (a *ChatAgent) SetOnUpdate(fn func(workload *pb.Workload)) {
+	a.OnUpdate = fn
+}
+
+// chatConfig is the optional workload config understood by ChatAgent.
+type chatConfig struct {
+	Stream bool `json:"stream"`
+	// HistoryTokens caps the estimated size of the earlier turns sent along
+	// with the latest message.
+	HistoryTokens int `json:"history_tokens"`
+}
+
+// defaultHistoryTokens is the history budget when the config doesn't set one.
+const defaultHistoryTokens = 8000
+
+// transcriptSeparator separates the turns of a chat payload.
+const transcriptSeparator = "\n\n---\n\n"
 
-func (a *ChatAgent) DoWork(workload *pb.Workload, genAIClient m.GenAIClient) error {
+// streamUpdateInterval limits how often OnUpdate is called while streaming.
+const streamUpdateInterval = time.Second
+
+func (a *ChatAgent) DoWork(ctx context.Context, workload *pb.Workload, genAIClient m.GenAIClient) error {
 	if workload == nil {
 		return fmt.Errorf("workload is nil")
 	}
@@ -17,18 +55,195 @@ func (a *ChatAgent) DoWork(workload *pb.Workload, genAIClient m.GenAIClient) err
 		return fmt.Errorf("genAIClient is nil")
 	}
 
-	// For ChatAgent, the input to the LLM is simply the payload.
-	input := string(workload.Payload)
-
-	responseText, err := genAIClient.GenerateContent(workload, input)
+	// The conversation so far is kept in the workload history. The payload
+	// is whatever the previous run left there, in any output format, with
+	// the user's new message typed after it.
+	message, err := newChatMessage(workload)
 	if err != nil {
-		return fmt.Errorf("error generating content: %w", err)
+		return err
+	}
+	turns := append(historyMessages(workload.History), m.Message{Role: m.RoleUser, Content: message})
+	transcript := renderTranscript(turns)
+	cfg := parseChatConfig(workload.Config)
+	messages := trimHistory(turns, cfg.HistoryTokens)
+
+	if workload.DryRun {
+		workload.Payload = []byte(dryRunPreview(transcript,
+			previewStep{"Models", strings.Join(workload.Models, ", ")},
+			previewStep{"Messages", formatMessages(messages)},
+		))
+		return nil
 	}
 
-	fmt.Printf("\n\n%s\n", responseText)
+	var responseText string
+	if len(workload.Models) > 1 {
+		// Every model gets the whole transcript as one prompt, as the earlier
+		// answers interleave all of their responses.
+		text, err := generateMultiModel(ctx, workload, transcript, genAIClient)
+		if err != nil {
+			return err
+		}
+		responseText = text
+	} else if cfg.Stream {
+		text, err := a.streamResponse(ctx, workload, transcript, messages, genAIClient)
+		if err != nil {
+			return err
+		}
+		responseText = text
+	} else {
+		text, err := genAIClient.GenerateChat(ctx, workload, messages, "")
+		if err != nil {
+			return fmt.Errorf("error generating content: %w", err)
+		}
+		responseText = text
+	}
 
-	newPayload := fmt.Sprintf("%s\n\n---\n\n%s", string(workload.Payload), responseText)
-	workload.Payload = []byte(newPayload)
+	workload.History = append(workload.History,
+		&pb.ChatMessage{Role: m.RoleUser, Content: message},
+		&pb.ChatMessage{Role: m.RoleAssistant, Content: responseText},
+	)
+	FormatResult(workload, message, responseText, transcript+transcriptSeparator+responseText, nil)
 
 	return nil
 }
+
+// streamResponse consumes a streamed response, appending chunks to the
+// transcript in the workload payload as they arrive.
+func (a *ChatAgent) streamResponse(ctx context.Context, workload *pb.Workload, transcript string, messages []m.Message, genAIClient m.GenAIClient) (string, error) {
+	input := workload.Payload
+	out := make(chan string)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- genAIClient.GenerateChatStream(ctx, workload, messages, "", out)
+	}()
+
+	var builder strings.Builder
+	lastUpdate := time.Now()
+	for chunk := range out {
+		builder.WriteString(chunk)
+		workload.Payload = []byte(transcript + transcriptSeparator + builder.String())
+		if a.OnUpdate != nil && time.Since(lastUpdate) >= streamUpdateInterval {
+			a.OnUpdate(workload)
+			lastUpdate = time.Now()
+		}
+	}
+
+	if err := <-errCh; err != nil {
+		workload.Payload = input
+		return "", fmt.Errorf("error streaming content: %w", err)
+	}
+	return builder.String(), nil
+}
+
+func parseChatConfig(config string) chatConfig {
+	cfg := chatConfig{HistoryTokens: defaultHistoryTokens}
+	if config == "" {
+		return cfg
+	}
+	if err := json.Unmarshal([]byte(config), &cfg); err != nil {
+		log.Printf("Ignoring invalid chat config: %v", err)
+	}
+	return cfg
+}
+
+// newChatMessage returns the user's new message: the payload without what
+// the previous run left in it. That is the transcript for the markdown
+// output format, the answer for plain and the Result for json. A payload
+// that starts with none of them is all new.
+func newChatMessage(workload *pb.Workload) (string, error) {
+	payload := string(workload.Payload)
+	if n := len(workload.History); n > 0 {
+		answer := workload.History[n-1].Content
+		dec := json.NewDecoder(strings.NewReader(payload))
+		var result Result
+		if rest, ok := strings.CutPrefix(payload, renderTranscript(historyMessages(workload.History))); ok {
+			payload = rest
+		} else if rest, ok := strings.CutPrefix(payload, answer); ok {
+			payload = rest
+		} else if dec.Decode(&result) == nil && result.Output == answer {
+			payload = payload[dec.InputOffset():]
+		}
+		// Typing a separator before the new message is fine too.
+		payload = strings.TrimPrefix(strings.TrimSpace(payload), "---")
+	}
+	message := strings.TrimSpace(payload)
+	if message == "" {
+		return "", fmt.Errorf("no new message to send")
+	}
+	return message, nil
+}
+
+// historyMessages converts a workload history to model messages.
+func historyMessages(history []*pb.ChatMessage) []m.Message {
+	messages := make([]m.Message, len(history))
+	for i, msg := range history {
+		messages[i] = m.Message{Role: msg.Role, Content: msg.Content}
+	}
+	return messages
+}
+
+// renderTranscript joins the turns of a conversation for the markdown
+// output format.
+func renderTranscript(messages []m.Message) string {
+	turns := make([]string, len(messages))
+	for i, msg := range messages {
+		turns[i] = msg.Content
+	}
+	return strings.Join(turns, transcriptSeparator)
+}
+
+// formatMessages renders a conversation for previews.
+func formatMessages(messages []m.Message) string {
+	parts := make([]string, len(messages))
+	for i, msg := range messages {
+		parts[i] = fmt.Sprintf("[%s]\n%s", msg.Role, msg.Content)
+	}
+	return strings.Join(parts, "\n\n")
+}
+
+// trimHistory drops the oldest turns until the history before the latest
+// message fits in budget tokens, estimated at four characters a token. The
+// history always starts with a user turn.
+func trimHistory(messages []m.Message, budget int) []m.Message {
+	last := len(messages) - 1
+	used := 0
+	start := last
+	for start > 0 {
+		cost := len(messages[start-1].Content)/4 + 1
+		if used+cost > budget {
+			break
+		}
+		used += cost
+		start--
+	}
+	for start < last && messages[start].Role != m.RoleUser {
+		start++
+	}
+	return messages[start:]
+}
+
+// generateMultiModel asks every selected model and renders one markdown
+// section per model. It only fails when no model produced a response.
+func generateMultiModel(ctx context.Context, workload *pb.Workload, input string, genAIClient m.GenAIClient) (string, error) {
+	results, err := genAIClient.GenerateContentMulti(ctx, workload, input, "")
+	if len(results) == 0 {
+		return "", fmt.Errorf("error generating content: %w", err)
+	}
+
+	var modelErrs m.ModelErrors
+	errors.As(err, &modelErrs)
+
+	var builder strings.Builder
+	for i, modelID := range workload.Models {
+		if i > 0 {
+			builder.WriteString("\n\n")
+		}
+		builder.WriteString(fmt.Sprintf("## %s\n\n", modelID))
+		if text, ok := results[modelID]; ok {
+			builder.WriteString(text)
+		} else if modelErr, ok := modelErrs[modelID]; ok {
+			builder.WriteString(fmt.Sprintf("_Error: %s_", modelErr))
+		}
+	}
+	return builder.String(), nil
+}
@@ -0,0 +1,236 @@
+package agents
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	m "github.com/nieveai/d-agents/internal/models"
+	"github.com/nieveai/d-agents/internal/testutil"
+	pb "github.com/nieveai/d-agents/proto"
+)
+
+func TestChatAgentMultiModel(t *testing.T) {
+	tests := []struct {
+		name    string
+		answers map[string]testutil.Response
+		want    []string
+		wantErr bool
+	}{
+		{
+			name: "all models answer",
+			answers: map[string]testutil.Response{
+				"fast": {Text: "fast answer"},
+				"slow": {Text: "slow answer"},
+			},
+			want: []string{"## fast\n\nfast answer", "## slow\n\nslow answer"},
+		},
+		{
+			name: "one model fails",
+			answers: map[string]testutil.Response{
+				"fast": {Text: "fast answer"},
+				"slow": {Err: errors.New("quota exceeded")},
+			},
+			want: []string{"## fast\n\nfast answer", "## slow\n\n_Error: quota exceeded_"},
+		},
+		{
+			name: "all models fail",
+			answers: map[string]testutil.Response{
+				"fast": {Err: errors.New("down")},
+				"slow": {Err: errors.New("down")},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := testutil.NewFakeGenAIClient()
+			for model, resp := range tt.answers {
+				client.RespondForModel(model, resp)
+			}
+			workload := &pb.Workload{Id: "s1", Models: []string{"fast", "slow"}, Payload: []byte("hello")}
+
+			err := (&ChatAgent{}).DoWork(context.Background(), workload, client)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("DoWork succeeded without any answer")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("DoWork: %v", err)
+			}
+			calls := client.Calls()
+			if len(calls) != 1 || calls[0].Method != "GenerateContentMulti" || calls[0].Input != "hello" {
+				t.Errorf("calls = %+v, want one GenerateContentMulti with the transcript", calls)
+			}
+			payload := string(workload.Payload)
+			if !strings.HasPrefix(payload, "hello"+transcriptSeparator) {
+				t.Errorf("payload %q doesn't start with the transcript", payload)
+			}
+			if got := strings.TrimPrefix(payload, "hello"+transcriptSeparator); got != strings.Join(tt.want, "\n\n") {
+				t.Errorf("response = %q, want %q", got, strings.Join(tt.want, "\n\n"))
+			}
+		})
+	}
+}
+
+func TestChatAgent(t *testing.T) {
+	client := testutil.NewFakeGenAIClient("Hello! How can I help?")
+	workload := &pb.Workload{Id: "s1", Models: []string{"m1"}, Payload: []byte("hi there")}
+
+	if err := (&ChatAgent{}).DoWork(context.Background(), workload, client); err != nil {
+		t.Fatalf("DoWork: %v", err)
+	}
+	calls := client.Calls()
+	if len(calls) != 1 || calls[0].Method != "GenerateChat" || calls[0].Input != "hi there" || len(calls[0].Messages) != 1 {
+		t.Errorf("calls = %+v, want one GenerateChat of the message", calls)
+	}
+	if got, want := string(workload.Payload), "hi there"+transcriptSeparator+"Hello! How can I help?"; got != want {
+		t.Errorf("payload = %q, want %q", got, want)
+	}
+}
+
+func TestChatAgentError(t *testing.T) {
+	quota := errors.New("quota exceeded")
+	client := testutil.NewFakeGenAIClient().Fail(quota)
+	workload := &pb.Workload{Id: "s1", Models: []string{"m1"}, Payload: []byte("hello")}
+
+	if err := (&ChatAgent{}).DoWork(context.Background(), workload, client); !errors.Is(err, quota) {
+		t.Fatalf("DoWork = %v, want the model's error", err)
+	}
+	if string(workload.Payload) != "hello" {
+		t.Errorf("payload = %q, want the input back", workload.Payload)
+	}
+
+	if err := (&ChatAgent{}).DoWork(context.Background(), nil, client); err == nil {
+		t.Error("DoWork succeeded without a workload")
+	}
+	if err := (&ChatAgent{}).DoWork(context.Background(), workload, nil); err == nil {
+		t.Error("DoWork succeeded without a client")
+	}
+}
+
+func TestChatAgentStream(t *testing.T) {
+	client := testutil.NewFakeGenAIClient("streamed answer here")
+	workload := &pb.Workload{Id: "s1", Models: []string{"m1"}, Payload: []byte("hello"), Config: `{"stream": true}`}
+
+	if err := (&ChatAgent{}).DoWork(context.Background(), workload, client); err != nil {
+		t.Fatalf("DoWork: %v", err)
+	}
+	if call, _ := client.LastCall(); call.Method != "GenerateChatStream" || call.Input != "hello" {
+		t.Errorf("last call = %+v, want GenerateChatStream of the message", call)
+	}
+	if got, want := string(workload.Payload), "hello"+transcriptSeparator+"streamed answer here"; got != want {
+		t.Errorf("payload = %q, want %q", got, want)
+	}
+}
+
+func TestChatAgentStreamError(t *testing.T) {
+	client := testutil.NewFakeGenAIClient().Fail(errors.New("stream broke"))
+	workload := &pb.Workload{Id: "s1", Models: []string{"m1"}, Payload: []byte("hello"), Config: `{"stream": true}`}
+
+	err := (&ChatAgent{}).DoWork(context.Background(), workload, client)
+	if err == nil || !strings.Contains(err.Error(), "stream broke") {
+		t.Fatalf("DoWork = %v, want the stream error", err)
+	}
+	if string(workload.Payload) != "hello" {
+		t.Errorf("payload = %q, want the input back", workload.Payload)
+	}
+}
+
+func TestChatAgentSecondRunIncludesFirstTurn(t *testing.T) {
+	for _, format := range []string{OutputMarkdown, OutputPlain, OutputJSON} {
+		t.Run(format, func(t *testing.T) {
+			client := testutil.NewFakeGenAIClient("Hello! How can I help?", "I'm fine, thanks.")
+			config := `{"output_format": "` + format + `"}`
+			workload := &pb.Workload{Id: "s1", Models: []string{"m1"}, Payload: []byte("hi there\n"), Config: config}
+			agent := &ChatAgent{}
+
+			if err := agent.DoWork(context.Background(), workload, client); err != nil {
+				t.Fatalf("first DoWork: %v", err)
+			}
+			// Editors append the next message straight after the result.
+			workload.Payload = append(workload.Payload, "how are you?\n"...)
+			if err := agent.DoWork(context.Background(), workload, client); err != nil {
+				t.Fatalf("second DoWork: %v", err)
+			}
+
+			call, _ := client.LastCall()
+			want := []m.Message{
+				{Role: m.RoleUser, Content: "hi there"},
+				{Role: m.RoleAssistant, Content: "Hello! How can I help?"},
+				{Role: m.RoleUser, Content: "how are you?"},
+			}
+			if call.Method != "GenerateChat" || !slices.EqualFunc(call.Messages, want, sameMessage) {
+				t.Errorf("second call = %s %+v, want GenerateChat with %+v", call.Method, call.Messages, want)
+			}
+			want = append(want, m.Message{Role: m.RoleAssistant, Content: "I'm fine, thanks."})
+			if got := historyMessages(workload.History); !slices.EqualFunc(got, want, sameMessage) {
+				t.Errorf("history = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func sameMessage(a, b m.Message) bool {
+	return a.Role == b.Role && a.Content == b.Content
+}
+
+func TestNewChatMessage(t *testing.T) {
+	history := []*pb.ChatMessage{{Role: m.RoleUser, Content: "hi"}, {Role: m.RoleAssistant, Content: "hello"}}
+	tests := []struct {
+		name    string
+		history []*pb.ChatMessage
+		payload string
+		want    string
+		wantErr bool
+	}{
+		{"first message", nil, "hi\n", "hi", false},
+		{"after the transcript", history, "hi" + transcriptSeparator + "hello" + "bye\n", "bye", false},
+		{"after a separator", history, "hi" + transcriptSeparator + "hello" + transcriptSeparator + "bye", "bye", false},
+		{"after the answer", history, "hellobye", "bye", false},
+		{"after the result", history, `{"input":"hi","output":"hello"}` + "\nbye", "bye", false},
+		{"after another result", history, `{"input":"hi","output":"other"}`, `{"input":"hi","output":"other"}`, false},
+		{"replaced", history, "something else", "something else", false},
+		{"nothing new", history, "hi" + transcriptSeparator + "hello\n", "", true},
+		{"empty", nil, " ", "", true},
+	}
+	for _, tt := range tests {
+		got, err := newChatMessage(&pb.Workload{History: tt.history, Payload: []byte(tt.payload)})
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("%s: newChatMessage = %q, %v, want %q, error %v", tt.name, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestTrimHistory(t *testing.T) {
+	// Each earlier turn costs 26 tokens at four characters a token.
+	turn := strings.Repeat("x", 100)
+	messages := []m.Message{
+		{Role: m.RoleUser, Content: turn},
+		{Role: m.RoleAssistant, Content: turn},
+		{Role: m.RoleUser, Content: turn},
+		{Role: m.RoleAssistant, Content: turn},
+		{Role: m.RoleUser, Content: "latest"},
+	}
+	tests := []struct {
+		budget int
+		want   int
+	}{
+		{1000, 5},
+		// Room for three turns, but the history has to start with the user.
+		{80, 3},
+		{52, 3},
+		{51, 1},
+		{0, 1},
+	}
+	for _, tt := range tests {
+		got := trimHistory(messages, tt.budget)
+		if len(got) != tt.want || got[len(got)-1].Content != "latest" || got[0].Role != m.RoleUser {
+			t.Errorf("trimHistory(budget %d) kept %d messages starting with %s, want %d", tt.budget, len(got), got[0].Role, tt.want)
+		}
+	}
+}
@@ -1,8 +1,11 @@
 package agents
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"log"
+	"regexp"
 	"strings"
 
 	"github.com/neo4j/neo4j-go-driver/v4/neo4j"
@@ -17,21 +20,66 @@ type CompanyRelationship struct {
 	Relationship string `json:"relationship"`
 }
 
+// RelationshipStore is where relationships go when Neo4j isn't used.
+type RelationshipStore interface {
+	AddRelationship(rel *m.Relationship) error
+}
+
 type CompanyRelationshipAgent struct {
 	DbDriver neo4j.Driver
+	// Store is used instead of Neo4j when DbDriver is nil.
+	Store RelationshipStore
+	progress
+	customPrompt
+}
+
+// relationshipStore is the fallback for agents created while Neo4j is unavailable.
+var relationshipStore RelationshipStore
+
+// SetRelationshipStore sets the store new CompanyRelationshipAgents fall back
+// to when they can't reach Neo4j.
+func SetRelationshipStore(store RelationshipStore) {
+	relationshipStore = store
+}
+
+func init() {
+	m.RegisterAgent("CompanyRelationshipAgent", func() (m.AgentInterface, error) {
+		return NewCompanyRelationshipAgent()
+	})
 }
 
 func NewCompanyRelationshipAgent() (*CompanyRelationshipAgent, error) {
 	driver, err := database.GetNeo4jDriver()
 	if err != nil {
-		return nil, fmt.Errorf("failed to get Neo4j driver: %w", err)
+		if relationshipStore == nil {
+			return nil, fmt.Errorf("failed to get Neo4j driver: %w", err)
+		}
+		log.Printf("Neo4j unavailable, storing company relationships in the relational DB instead: %s", err)
+		return NewCompanyRelationshipAgentWithStore(relationshipStore), nil
 	}
 	return &CompanyRelationshipAgent{DbDriver: driver}, nil
 }
 
+// NewCompanyRelationshipAgentWithStore creates an agent that writes to store
+// and doesn't touch Neo4j at all.
+func NewCompanyRelationshipAgentWithStore(store RelationshipStore) *CompanyRelationshipAgent {
+	return &CompanyRelationshipAgent{Store: store}
+}
+
 const companyRelationshipSystemPrompt = `you are a stock analyst. plesae find all the companies that are related to the one mentioned in user message. please include all the important relationships such as vendors, customers, competitors, etc. the output should in json format. for example: [ { "name" : "nvidia", "relationship": "vendor"}, ... ]. a company may have multiple relationship. for example, it can be vendor as well as competitor.`
 
-func (a *CompanyRelationshipAgent) DoWork(workload *pb.Workload, genAIClient m.GenAIClient) error {
+// companyRelationshipSchema is the JSON schema of a CompanyRelationship.
+var companyRelationshipSchema = map[string]any{
+	"type": "object",
+	"properties": map[string]any{
+		"name":         map[string]any{"type": "string"},
+		"relationship": map[string]any{"type": "string"},
+	},
+	"required":             []string{"name", "relationship"},
+	"additionalProperties": false,
+}
+
+func (a *CompanyRelationshipAgent) DoWork(ctx context.Context, workload *pb.Workload, genAIClient m.GenAIClient) error {
 	if workload == nil {
 		return fmt.Errorf("workload is nil")
 	}
@@ -42,33 +90,45 @@ func (a *CompanyRelationshipAgent) DoWork(workload *pb.Workload, genAIClient m.G
 		return fmt.Errorf("workload name (session name) is empty, which is required as a primary company node")
 	}
 
-	input := string(workload.Payload)
-	// Pass the payload to the GenAI client to get the relationship JSON
-	llmResponse, err := genAIClient.GenerateContentWithSystemPrompt(workload, input, companyRelationshipSystemPrompt)
+	systemPrompt, err := a.promptFor(workload, companyRelationshipSystemPrompt)
 	if err != nil {
-		return fmt.Errorf("error generating content: %w", err)
+		return err
+	}
+
+	input := string(workload.Payload)
+	if workload.DryRun {
+		workload.Payload = []byte(dryRunPreview(input, a.preview(workload.Name, input, systemPrompt)...))
+		return nil
 	}
 
-	// Extract the JSON part from the response
-	jsonString := extractJSONArray(llmResponse)
-	if jsonString == "" {
-		return fmt.Errorf("no JSON array found in the LLM response")
+	// Pass the payload to the GenAI client to get the relationship JSON
+	list, llmResponse, err := generateJSONList(ctx, genAIClient, workload, input, systemPrompt, companyRelationshipSchema)
+	if err != nil {
+		return err
 	}
 
 	var relationships []CompanyRelationship
-	if err := json.Unmarshal([]byte(jsonString), &relationships); err != nil {
-		return fmt.Errorf("failed to parse JSON from LLM response: %w", err)
+	if err := json.Unmarshal(list, &relationships); err != nil {
+		return fmt.Errorf("%w: failed to parse JSON from LLM response: %w", m.ErrInvalidResponse, err)
 	}
+	a.reportProgress(workload, 50)
 
-	// Process the relationships and update Neo4j
-	summary, err := a.updateRelationshipsInNeo4j(workload.Name, relationships)
-	if err != nil {
-		return fmt.Errorf("failed to update Neo4j database: %w", err)
+	// Process the relationships and update the graph
+	var summary string
+	if a.DbDriver != nil {
+		summary, err = a.updateRelationshipsInNeo4j(workload, relationships)
+		if err != nil {
+			return fmt.Errorf("failed to update Neo4j database: %w", err)
+		}
+	} else if a.Store != nil {
+		summary = a.updateRelationshipsInStore(workload, relationships)
+	} else {
+		return fmt.Errorf("no relationship store configured")
 	}
 
 	// Update the payload with the results
 	newPayload := fmt.Sprintf("%s\n\n---\n\n%s\n\nProcessed Relationships:\n%s", input, llmResponse, summary)
-	workload.Payload = []byte(newPayload)
+	FormatResult(workload, input, llmResponse, newPayload, map[string]any{"company": workload.Name, "relationships": relationships})
 
 	return nil
 }
@@ -76,43 +136,158 @@ func (a *CompanyRelationshipAgent) DoWork(workload *pb.Workload, genAIClient m.G
 
 
 
-// sanitizeRelationshipType prepares a string to be used as a Neo4j relationship type.
-func sanitizeRelationshipType(s string) string {
+// relationshipTypePattern is what a relationship type must look like to be
+// formatted into a Cypher query unquoted.
+var relationshipTypePattern = regexp.MustCompile(`^[A-Z][A-Z0-9_]*$`)
+
+// sanitizeRelationshipType prepares a string to be used as a Neo4j relationship
+// type. Relationship types can't be query parameters, so anything that could
+// break out of the pattern, backticks in particular, is rejected rather than
+// cleaned up. An empty type returns "" and no error.
+func sanitizeRelationshipType(s string) (string, error) {
 	s = strings.TrimSpace(s)
 	s = strings.ToUpper(s)
 	s = strings.ReplaceAll(s, " ", "_")
-	// Add any other sanitization rules if necessary
-	return s
+	s = strings.ReplaceAll(s, "-", "_")
+	if s == "" {
+		return "", nil
+	}
+	if strings.Contains(s, "`") {
+		return "", fmt.Errorf("relationship type %q contains a backtick", s)
+	}
+	if !relationshipTypePattern.MatchString(s) {
+		return "", fmt.Errorf("invalid relationship type %q", s)
+	}
+	return s, nil
+}
+
+// relationshipDirection says which way an edge points relative to how the LLM
+// reports it: "X is a <type> of the company".
+type relationshipDirection int
+
+const (
+	// X -[TYPE]-> company
+	directionForward relationshipDirection = iota
+	// company -[TYPE]-> X
+	directionReverse
+	// Symmetric; stored once between the pair, whichever side it was found from.
+	directionUndirected
+)
+
+// relationshipTypeRules maps relationship types to a canonical type and direction,
+// so e.g. "A is a customer of B" and "B is a vendor of A" end up as the same
+// edge. Types not listed are kept as-is, pointing at the company.
+var relationshipTypeRules = map[string]struct {
+	canonical string
+	direction relationshipDirection
+}{
+	"VENDOR":      {"VENDOR", directionForward},
+	"SUPPLIER":    {"VENDOR", directionForward},
+	"CUSTOMER":    {"VENDOR", directionReverse},
+	"CLIENT":      {"VENDOR", directionReverse},
+	"INVESTOR":    {"INVESTOR", directionForward},
+	"SHAREHOLDER": {"INVESTOR", directionForward},
+	"INVESTMENT":  {"INVESTOR", directionReverse},
+	"PARENT":      {"PARENT", directionForward},
+	"SUBSIDIARY":  {"PARENT", directionReverse},
+	"COMPETITOR":  {"COMPETITOR", directionUndirected},
+	"PARTNER":     {"PARTNER", directionUndirected},
+}
+
+// normalizeRelationship turns "other is a relType of company" into the edge to
+// store. It returns a nil edge for an empty type. Undirected edges have their
+// endpoints in name order so both sides of a pair produce the same edge.
+func normalizeRelationship(other, company, relType string) (*m.Relationship, bool, error) {
+	sanitized, err := sanitizeRelationshipType(relType)
+	if err != nil || sanitized == "" {
+		return nil, false, err
+	}
+
+	edge := &m.Relationship{Source: other, Target: company, Type: sanitized}
+	known, ok := relationshipTypeRules[sanitized]
+	if !ok {
+		return edge, false, nil
+	}
+
+	edge.Type = known.canonical
+	switch known.direction {
+	case directionReverse:
+		edge.Source, edge.Target = company, other
+	case directionUndirected:
+		if edge.Source > edge.Target {
+			edge.Source, edge.Target = edge.Target, edge.Source
+		}
+		return edge, true, nil
+	}
+	return edge, false, nil
+}
+
+// mergeQuery builds the Cypher that stores one edge. An undirected MERGE
+// matches the edge whichever way round it was stored, so reciprocal edges
+// aren't duplicated.
+func mergeQuery(relType string, undirected bool) string {
+	query := `
+					MERGE (c1:Company {name: $source})
+					MERGE (c2:Company {name: $target})
+					MERGE (c1)-[r:%s]->(c2)`
+	if undirected {
+		query = `
+					MERGE (c1:Company {name: $source})
+					MERGE (c2:Company {name: $target})
+					MERGE (c1)-[r:%s]-(c2)`
+	}
+	// Note: Relationship types cannot be parameterized directly in Cypher.
+	// sanitizeRelationshipType only lets through [A-Z0-9_].
+	return fmt.Sprintf(query, relType)
+}
+
+// preview describes what DoWork would send and store for company.
+func (a *CompanyRelationshipAgent) preview(company, input, systemPrompt string) []previewStep {
+	steps := []previewStep{
+		{"System prompt", systemPrompt},
+		{"User message", input},
+	}
+	if a.DbDriver != nil {
+		steps = append(steps, previewStep{
+			"Cypher run for each relationship found",
+			strings.TrimSpace(mergeQuery("<TYPE>", false)) + fmt.Sprintf("\n\n$source/$target: the related company and %q, depending on the relationship type", company),
+		})
+	} else {
+		steps = append(steps, previewStep{
+			"Relational store",
+			fmt.Sprintf("one (source, target, type) row per relationship found, with %q as one end", company),
+		})
+	}
+	return steps
 }
 
-func (a *CompanyRelationshipAgent) updateRelationshipsInNeo4j(sessionName string, relationships []CompanyRelationship) (string, error) {
-	session := a.DbDriver.NewSession(neo4j.SessionConfig{AccessMode: neo4j.AccessModeWrite})
+// updateRelationshipsInNeo4j merges the edges of relationships into the graph.
+// The second half of the workload's progress is spread over them.
+func (a *CompanyRelationshipAgent) updateRelationshipsInNeo4j(workload *pb.Workload, relationships []CompanyRelationship) (string, error) {
+	sessionName := workload.Name
+	session := a.DbDriver.NewSession(database.Neo4jSessionConfig(neo4j.AccessModeWrite))
 	defer session.Close()
 
 	var summaryBuilder strings.Builder
 
-	for _, rel := range relationships {
+	for i, rel := range relationships {
 		otherCompany := rel.Name
 		relationshipTypes := strings.Split(rel.Relationship, ",")
 
 		for _, relType := range relationshipTypes {
-			sanitizedRelType := sanitizeRelationshipType(relType)
-			if sanitizedRelType == "" {
+			edge, undirected, err := normalizeRelationship(otherCompany, sessionName, relType)
+			if err != nil {
+				summaryBuilder.WriteString(fmt.Sprintf("Skipped relationship between %s and %s: %v\n", otherCompany, sessionName, err))
+				continue
+			}
+			if edge == nil {
 				continue
 			}
 
-			_, err := session.WriteTransaction(func(tx neo4j.Transaction) (interface{}, error) {
-				query := `
-					MERGE (c1:Company {name: $sessionName})
-					MERGE (c2:Company {name: $otherCompany})
-					MERGE (c2)-[r:%s]->(c1)`
-				// Note: Relationship types cannot be parameterized directly in Cypher.
-				// It's generally safe here as we are sanitizing the input string.
-				finalQuery := fmt.Sprintf(query, sanitizedRelType)
-
-				result, err := tx.Run(finalQuery, map[string]interface{}{
-					"sessionName":  sessionName,
-					"otherCompany": otherCompany,
+			_, err = session.WriteTransaction(func(tx neo4j.Transaction) (interface{}, error) {
+				result, err := tx.Run(mergeQuery(edge.Type, undirected), map[string]interface{}{
+					"source": edge.Source,
+					"target": edge.Target,
 				})
 				if err != nil {
 					return nil, err
@@ -121,15 +296,160 @@ func (a *CompanyRelationshipAgent) updateRelationshipsInNeo4j(sessionName string
 			})
 
 			if err != nil {
-				errorMsg := fmt.Sprintf("Failed to add relationship: %s -[%s]-> %s. Error: %v\n", otherCompany, sanitizedRelType, sessionName, err)
+				errorMsg := fmt.Sprintf("Failed to add relationship: %s -[%s]-> %s. Error: %v\n", edge.Source, edge.Type, edge.Target, err)
 				summaryBuilder.WriteString(errorMsg)
 				// Decide if we should continue or return on first error. Continuing for now.
 			} else {
-				successMsg := fmt.Sprintf("Added relationship: %s -[%s]-> %s\n", otherCompany, sanitizedRelType, sessionName)
+				successMsg := fmt.Sprintf("Added relationship: %s -[%s]-> %s\n", edge.Source, edge.Type, edge.Target)
 				summaryBuilder.WriteString(successMsg)
 			}
 		}
+		a.reportProgress(workload, stepProgress(50, 100, i+1, len(relationships)))
 	}
 
 	return summaryBuilder.String(), nil
 }
+
+// updateRelationshipsInStore writes the same edges as updateRelationshipsInNeo4j
+// to the relational store.
+func (a *CompanyRelationshipAgent) updateRelationshipsInStore(workload *pb.Workload, relationships []CompanyRelationship) string {
+	sessionName := workload.Name
+	var summaryBuilder strings.Builder
+
+	for i, rel := range relationships {
+		for _, relType := range strings.Split(rel.Relationship, ",") {
+			edge, _, err := normalizeRelationship(rel.Name, sessionName, relType)
+			if err != nil {
+				summaryBuilder.WriteString(fmt.Sprintf("Skipped relationship between %s and %s: %v\n", rel.Name, sessionName, err))
+				continue
+			}
+			if edge == nil {
+				continue
+			}
+
+			if err := a.Store.AddRelationship(edge); err != nil {
+				summaryBuilder.WriteString(fmt.Sprintf("Failed to add relationship: %s -[%s]-> %s. Error: %v\n", edge.Source, edge.Type, edge.Target, err))
+			} else {
+				summaryBuilder.WriteString(fmt.Sprintf("Added relationship: %s -[%s]-> %s\n", edge.Source, edge.Type, edge.Target))
+			}
+		}
+		a.reportProgress(workload, stepProgress(50, 100, i+1, len(relationships)))
+	}
+
+	return summaryBuilder.String()
+}
+
+// maxRelationshipDepth caps GetRelated traversals so a densely connected graph
+// can't turn a lookup into a walk of the whole database.
+const maxRelationshipDepth = 5
+
+// relationshipLister is implemented by stores that can read relationships back.
+type relationshipLister interface {
+	ListRelationships() ([]*m.Relationship, error)
+}
+
+// relatedQuery builds the Cypher traversal for GetRelated. Variable length
+// bounds can't be parameters in Cypher, so depth is formatted in after clamping.
+func relatedQuery(depth int) string {
+	depth = clampDepth(depth)
+	return fmt.Sprintf(`
+		MATCH p = (c:Company {name: $company})-[*1..%d]-(:Company)
+		UNWIND relationships(p) AS r
+		RETURN DISTINCT startNode(r).name AS source, endNode(r).name AS target, type(r) AS type
+		ORDER BY target, source, type`, depth)
+}
+
+func clampDepth(depth int) int {
+	if depth < 1 {
+		return 1
+	}
+	if depth > maxRelationshipDepth {
+		return maxRelationshipDepth
+	}
+	return depth
+}
+
+// GetRelated returns the relationships within depth hops of company, in either
+// direction. It returns no edges, and no error, when the company isn't known.
+func (a *CompanyRelationshipAgent) GetRelated(company string, depth int) ([]*m.Relationship, error) {
+	if a.DbDriver != nil {
+		session := a.DbDriver.NewSession(database.Neo4jSessionConfig(neo4j.AccessModeRead))
+		defer session.Close()
+		return getRelatedFromNeo4j(session, company, depth)
+	}
+	if lister, ok := a.Store.(relationshipLister); ok {
+		all, err := lister.ListRelationships()
+		if err != nil {
+			return nil, err
+		}
+		return getRelatedFromList(all, company, depth), nil
+	}
+	return nil, fmt.Errorf("no relationship store configured")
+}
+
+func getRelatedFromNeo4j(session neo4j.Session, company string, depth int) ([]*m.Relationship, error) {
+	result, err := session.ReadTransaction(func(tx neo4j.Transaction) (interface{}, error) {
+		records, err := tx.Run(relatedQuery(depth), map[string]interface{}{"company": company})
+		if err != nil {
+			return nil, err
+		}
+
+		var relationships []*m.Relationship
+		for records.Next() {
+			record := records.Record()
+			source, _ := record.Get("source")
+			target, _ := record.Get("target")
+			relType, _ := record.Get("type")
+			relationships = append(relationships, &m.Relationship{
+				Source: fmt.Sprint(source),
+				Target: fmt.Sprint(target),
+				Type:   fmt.Sprint(relType),
+			})
+		}
+		return relationships, records.Err()
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to query related companies: %w", err)
+	}
+	relationships, _ := result.([]*m.Relationship)
+	return relationships, nil
+}
+
+// getRelatedFromList does the same traversal as relatedQuery over an in-memory
+// list of edges.
+func getRelatedFromList(all []*m.Relationship, company string, depth int) []*m.Relationship {
+	depth = clampDepth(depth)
+
+	seen := make(map[*m.Relationship]bool)
+	visited := map[string]bool{company: true}
+	frontier := []string{company}
+	var related []*m.Relationship
+
+	for hop := 0; hop < depth && len(frontier) > 0; hop++ {
+		var next []string
+		for _, name := range frontier {
+			for _, rel := range all {
+				var other string
+				switch name {
+				case rel.Source:
+					other = rel.Target
+				case rel.Target:
+					other = rel.Source
+				default:
+					continue
+				}
+				if !seen[rel] {
+					seen[rel] = true
+					related = append(related, rel)
+				}
+				if !visited[other] {
+					visited[other] = true
+					next = append(next, other)
+				}
+			}
+		}
+		frontier = next
+	}
+
+	return related
+}
@@ -0,0 +1,280 @@
+package agents
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/neo4j/neo4j-go-driver/v4/neo4j"
+	"github.com/nieveai/d-agents/internal/database"
+	m "github.com/nieveai/d-agents/internal/models"
+	"github.com/nieveai/d-agents/internal/testutil"
+	pb "github.com/nieveai/d-agents/proto"
+)
+
+func TestCompanyRelationshipAgentWithStore(t *testing.T) {
+	store := database.NewMemoryDatastore()
+	agent := NewCompanyRelationshipAgentWithStore(store)
+	answer := `[{"name": "TSMC", "relationship": "vendor"}, {"name": "AMD", "relationship": "competitor,customer"}]`
+	// The same answer twice, as when the agent is run again.
+	client := testutil.NewFakeGenAIClient(answer, answer)
+
+	for range 2 {
+		workload := &pb.Workload{Id: "s1", Name: "Nvidia", Models: []string{"m1"}, Payload: []byte("Nvidia")}
+		if err := agent.DoWork(context.Background(), workload, client); err != nil {
+			t.Fatalf("DoWork: %v", err)
+		}
+	}
+
+	rels, err := store.ListRelationships()
+	if err != nil {
+		t.Fatalf("ListRelationships: %v", err)
+	}
+	seen := make(map[string]int)
+	for _, rel := range rels {
+		seen[rel.Source+">"+rel.Target+":"+rel.Type]++
+	}
+	if len(rels) != 3 {
+		t.Errorf("stored %d relationships, want 3: %v", len(rels), seen)
+	}
+	for key, n := range seen {
+		if n > 1 {
+			t.Errorf("%s stored %d times", key, n)
+		}
+	}
+}
+
+func TestCompanyRelationshipAgentNeedsName(t *testing.T) {
+	agent := NewCompanyRelationshipAgentWithStore(database.NewMemoryDatastore())
+	workload := &pb.Workload{Id: "s1", Models: []string{"m1"}, Payload: []byte("Nvidia")}
+	if err := agent.DoWork(context.Background(), workload, testutil.NewFakeGenAIClient("[]")); err == nil {
+		t.Error("DoWork succeeded without a session name")
+	}
+}
+
+func TestCompanyRelationshipAgentInvalidResponse(t *testing.T) {
+	agent := NewCompanyRelationshipAgentWithStore(database.NewMemoryDatastore())
+	for _, answer := range []string{"I don't know any.", `[{"name": "TSMC", "relationship": ["vendor"]}]`} {
+		workload := &pb.Workload{Id: "s1", Name: "Nvidia", Models: []string{"m1"}, Payload: []byte("Nvidia")}
+		if err := agent.DoWork(context.Background(), workload, testutil.NewFakeGenAIClient(answer)); !errors.Is(err, m.ErrInvalidResponse) {
+			t.Errorf("DoWork with the answer %q = %v, want ErrInvalidResponse", answer, err)
+		}
+	}
+}
+
+// stubSession is a neo4j.Session answering every query with rows of source,
+// target and type, and recording the queries run.
+type stubSession struct {
+	neo4j.Session
+	rows    [][]any
+	queries []string
+	params  []map[string]any
+}
+
+func (s *stubSession) ReadTransaction(work neo4j.TransactionWork, configurers ...func(*neo4j.TransactionConfig)) (any, error) {
+	return work(&stubTransaction{s})
+}
+
+type stubTransaction struct {
+	*stubSession
+}
+
+func (tx *stubTransaction) Run(cypher string, params map[string]any) (neo4j.Result, error) {
+	tx.queries = append(tx.queries, cypher)
+	tx.params = append(tx.params, params)
+	return &stubResult{rows: tx.rows, next: -1}, nil
+}
+
+func (tx *stubTransaction) Commit() error   { return nil }
+func (tx *stubTransaction) Rollback() error { return nil }
+func (tx *stubTransaction) Close() error    { return nil }
+
+type stubResult struct {
+	neo4j.Result
+	rows [][]any
+	next int
+}
+
+func (r *stubResult) Next() bool {
+	r.next++
+	return r.next < len(r.rows)
+}
+
+func (r *stubResult) Record() *neo4j.Record {
+	return &neo4j.Record{Keys: []string{"source", "target", "type"}, Values: r.rows[r.next]}
+}
+
+func (r *stubResult) Err() error { return nil }
+
+func TestRelatedQuery(t *testing.T) {
+	tests := []struct {
+		depth int
+		want  string
+	}{
+		{-1, "[*1..1]"},
+		{0, "[*1..1]"},
+		{2, "[*1..2]"},
+		{maxRelationshipDepth, fmt.Sprintf("[*1..%d]", maxRelationshipDepth)},
+		{1000, fmt.Sprintf("[*1..%d]", maxRelationshipDepth)},
+	}
+	for _, tt := range tests {
+		query := relatedQuery(tt.depth)
+		if !strings.Contains(query, tt.want) {
+			t.Errorf("relatedQuery(%d) = %q, want %s", tt.depth, query, tt.want)
+		}
+		// The company is always a parameter.
+		if !strings.Contains(query, "{name: $company}") {
+			t.Errorf("relatedQuery(%d) doesn't use the $company parameter: %q", tt.depth, query)
+		}
+	}
+}
+
+func TestGetRelatedFromNeo4j(t *testing.T) {
+	session := &stubSession{rows: [][]any{
+		{"TSMC", "Nvidia", "VENDOR"},
+		{"AMD", "Nvidia", "COMPETITOR"},
+	}}
+	rels, err := getRelatedFromNeo4j(session, `Nvidia"}) DETACH DELETE (n`, 3)
+	if err != nil {
+		t.Fatalf("getRelatedFromNeo4j: %v", err)
+	}
+	if len(session.queries) != 1 || !strings.Contains(session.queries[0], "[*1..3]") {
+		t.Errorf("queries = %q", session.queries)
+	}
+	if company := session.params[0]["company"]; company != `Nvidia"}) DETACH DELETE (n` {
+		t.Errorf("company parameter = %q, want the name as given", company)
+	}
+	if len(rels) != 2 || *rels[0] != (m.Relationship{Source: "TSMC", Target: "Nvidia", Type: "VENDOR"}) {
+		t.Errorf("relationships = %v", rels)
+	}
+
+	empty := &stubSession{}
+	rels, err = getRelatedFromNeo4j(empty, "Unknown", 2)
+	if err != nil || len(rels) != 0 {
+		t.Errorf("empty graph = %v, %v; want no edges and no error", rels, err)
+	}
+}
+
+func TestGetRelatedFromList(t *testing.T) {
+	all := []*m.Relationship{
+		{Source: "TSMC", Target: "Nvidia", Type: "VENDOR"},
+		{Source: "ASML", Target: "TSMC", Type: "VENDOR"},
+		{Source: "Zeiss", Target: "ASML", Type: "VENDOR"},
+		{Source: "Apple", Target: "Samsung", Type: "CUSTOMER"},
+	}
+	tests := []struct {
+		company string
+		depth   int
+		want    int
+	}{
+		{"Nvidia", 1, 1},
+		{"Nvidia", 2, 2},
+		{"Nvidia", 3, 3},
+		{"Nvidia", 0, 1},
+		{"TSMC", 1, 2},
+		{"Unknown", 5, 0},
+	}
+	for _, tt := range tests {
+		if got := getRelatedFromList(all, tt.company, tt.depth); len(got) != tt.want {
+			t.Errorf("getRelatedFromList(%s, %d) = %d edges, want %d", tt.company, tt.depth, len(got), tt.want)
+		}
+	}
+}
+
+func TestGetRelatedWithStore(t *testing.T) {
+	store := database.NewMemoryDatastore()
+	store.AddRelationship(&m.Relationship{Source: "TSMC", Target: "Nvidia", Type: "VENDOR"})
+	agent := NewCompanyRelationshipAgentWithStore(store)
+
+	rels, err := agent.GetRelated("Nvidia", 2)
+	if err != nil || len(rels) != 1 {
+		t.Errorf("GetRelated = %v, %v; want the one edge", rels, err)
+	}
+	if rels, err := agent.GetRelated("Unknown", 2); err != nil || len(rels) != 0 {
+		t.Errorf("GetRelated of an unknown company = %v, %v", rels, err)
+	}
+}
+
+func TestSanitizeRelationshipType(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{"vendor", "VENDOR", false},
+		{" joint venture ", "JOINT_VENTURE", false},
+		{"co-founder", "CO_FOUNDER", false},
+		{"", "", false},
+		{"   ", "", false},
+		{"VENDOR`]->(x) DETACH DELETE x //", "", true},
+		{"`", "", true},
+		{"VENDOR]->(c2) MATCH (n) DETACH DELETE n", "", true},
+		{"vendor;drop", "", true},
+		{"vendor{x:1}", "", true},
+		{"1ST_TIER", "", true},
+		{"émetteur", "", true},
+	}
+	for _, tt := range tests {
+		got, err := sanitizeRelationshipType(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("sanitizeRelationshipType(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("sanitizeRelationshipType(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizeRelationship(t *testing.T) {
+	tests := []struct {
+		other, relType string
+		want           m.Relationship
+		undirected     bool
+	}{
+		{"TSMC", "vendor", m.Relationship{Source: "TSMC", Target: "Nvidia", Type: "VENDOR"}, false},
+		{"TSMC", "supplier", m.Relationship{Source: "TSMC", Target: "Nvidia", Type: "VENDOR"}, false},
+		{"Dell", "customer", m.Relationship{Source: "Nvidia", Target: "Dell", Type: "VENDOR"}, false},
+		{"Mellanox", "subsidiary", m.Relationship{Source: "Nvidia", Target: "Mellanox", Type: "PARENT"}, false},
+		{"AMD", "competitor", m.Relationship{Source: "AMD", Target: "Nvidia", Type: "COMPETITOR"}, true},
+		{"Zotac", "competitor", m.Relationship{Source: "Nvidia", Target: "Zotac", Type: "COMPETITOR"}, true},
+		{"Arm", "licensor", m.Relationship{Source: "Arm", Target: "Nvidia", Type: "LICENSOR"}, false},
+	}
+	for _, tt := range tests {
+		edge, undirected, err := normalizeRelationship(tt.other, "Nvidia", tt.relType)
+		if err != nil {
+			t.Errorf("normalizeRelationship(%s, %s): %v", tt.other, tt.relType, err)
+			continue
+		}
+		if *edge != tt.want || undirected != tt.undirected {
+			t.Errorf("normalizeRelationship(%s, %s) = %v %v, want %v %v", tt.other, tt.relType, *edge, undirected, tt.want, tt.undirected)
+		}
+	}
+
+	// Found from either side, a pair gives the same edge.
+	a, _, _ := normalizeRelationship("Dell", "Nvidia", "customer")
+	b, _, _ := normalizeRelationship("Nvidia", "Dell", "vendor")
+	if *a != *b {
+		t.Errorf("customer edge %v and vendor edge %v differ", *a, *b)
+	}
+	c, _, _ := normalizeRelationship("AMD", "Nvidia", "competitor")
+	d, _, _ := normalizeRelationship("Nvidia", "AMD", "competitor")
+	if *c != *d {
+		t.Errorf("competitor edges %v and %v differ", *c, *d)
+	}
+
+	if edge, _, err := normalizeRelationship("X", "Nvidia", "bad`type"); err == nil || edge != nil {
+		t.Errorf("normalizeRelationship with a backtick = %v, %v; want an error", edge, err)
+	}
+}
+
+func TestMergeQuery(t *testing.T) {
+	if q := mergeQuery("VENDOR", false); !strings.Contains(q, "MERGE (c1)-[r:VENDOR]->(c2)") {
+		t.Errorf("directed query = %q", q)
+	}
+	if q := mergeQuery("COMPETITOR", true); !strings.Contains(q, "MERGE (c1)-[r:COMPETITOR]-(c2)") {
+		t.Errorf("undirected query = %q", q)
+	}
+}
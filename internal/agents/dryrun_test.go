@@ -0,0 +1,119 @@
+package agents
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/neo4j/neo4j-go-driver/v4/neo4j"
+	"github.com/nieveai/d-agents/internal/database"
+	m "github.com/nieveai/d-agents/internal/models"
+	pb "github.com/nieveai/d-agents/proto"
+)
+
+// stubDriver is a neo4j.Driver counting the sessions opened.
+type stubDriver struct {
+	neo4j.Driver
+	sessions int
+}
+
+func (d *stubDriver) NewSession(config neo4j.SessionConfig) neo4j.Session {
+	d.sessions++
+	return &stubSession{}
+}
+
+func TestDryRun(t *testing.T) {
+	relationships := database.NewMemoryDatastore()
+	driver := &stubDriver{}
+	shopping, fetched := newTestShoppingAgent(t, nil)
+	vectors := database.NewMemoryDatastore()
+
+	tests := []struct {
+		name    string
+		agent   m.AgentInterface
+		payload string
+		config  string
+		// want are the things the preview has to show.
+		want []string
+	}{
+		{
+			name:    "chat",
+			agent:   &ChatAgent{},
+			payload: "hello",
+			want:    []string{"### Messages", "[user]\nhello"},
+		},
+		{
+			name:    "company relationships in Neo4j",
+			agent:   &CompanyRelationshipAgent{DbDriver: driver},
+			payload: "Nvidia",
+			want:    []string{companyRelationshipSystemPrompt, "MERGE (c1)-[r:<TYPE>]->(c2)", `"Nvidia"`},
+		},
+		{
+			name:    "company relationships in the store",
+			agent:   NewCompanyRelationshipAgentWithStore(relationships),
+			payload: "Nvidia",
+			want:    []string{"### Relational store", `"Nvidia"`},
+		},
+		{
+			name:    "shopping",
+			agent:   shopping,
+			payload: "https://shop.test/hub",
+			want:    []string{"### Page to fetch\n\n```\nhttps://shop.test/hub\n```", "### Extraction prompt", "Nvidia"},
+		},
+		{
+			name:    "translation",
+			agent:   &TranslationAgent{},
+			payload: "Hello, run `make`.",
+			config:  `{"target": "fr"}`,
+			want:    []string{"### System prompt", "### User message"},
+		},
+		{
+			name:    "rag",
+			agent:   &RAGAgent{Store: vectors},
+			payload: "How long do cats sleep?",
+			config:  `{"embedding_model": "emb", "top_k": 2}`,
+			want:    []string{"embed the question with emb and take the top 2 chunks"},
+		},
+		{
+			name:    "mcp",
+			agent:   &MCPAgent{Transport: newFakeMCPServer(t)},
+			payload: "what is 2 + 3?",
+			want:    []string{"### MCP server", "<tools of the server>"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newEmbeddingClient()
+			workload := &pb.Workload{Id: "s1", Name: "Nvidia", Models: []string{"m1"}, Payload: []byte(tt.payload), Config: tt.config, DryRun: true}
+			if err := tt.agent.DoWork(context.Background(), workload, client); err != nil {
+				t.Fatalf("DoWork: %v", err)
+			}
+
+			if calls := client.Calls(); len(calls) > 0 || len(client.models) > 0 {
+				t.Errorf("the model was called in a dry run: %v, embeddings with %v", calls, client.models)
+			}
+			payload := string(workload.Payload)
+			if !strings.HasPrefix(payload, tt.payload) || !strings.Contains(payload, "**Dry run:**") {
+				t.Errorf("payload doesn't have the input and the dry run report:\n%s", payload)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(payload, want) {
+					t.Errorf("preview doesn't show %q:\n%s", want, payload)
+				}
+			}
+		})
+	}
+
+	if driver.sessions > 0 {
+		t.Errorf("%d Neo4j sessions opened in a dry run", driver.sessions)
+	}
+	if rels, _ := relationships.ListRelationships(); len(rels) > 0 {
+		t.Errorf("relationships stored in a dry run: %v", rels)
+	}
+	if len(*fetched) > 0 {
+		t.Errorf("pages fetched in a dry run: %v", *fetched)
+	}
+	if products, _ := shopping.Db.GetAllProducts(); len(products) > 0 {
+		t.Errorf("products stored in a dry run: %v", products)
+	}
+}
@@ -0,0 +1,397 @@
+package agents
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/nieveai/d-agents/internal/database"
+	m "github.com/nieveai/d-agents/internal/models"
+	"github.com/nieveai/d-agents/internal/textutil"
+	pb "github.com/nieveai/d-agents/proto"
+)
+
+const (
+	// defaultDigestMessages is how many messages go in one digest when the
+	// config doesn't say.
+	defaultDigestMessages = 50
+	// maxDigestMessageBytes is how much of each message is fetched.
+	maxDigestMessageBytes = 64 << 10
+	// maxDigestMessageChars is how much of each message's text the model
+	// gets to read.
+	maxDigestMessageChars = 3000
+)
+
+// EmailDigestConfig is the workload config of EmailDigestAgent. The
+// notification settings are optional: when set, the digest is sent there as
+// well, e.g. emailed with smtp_host and email_to.
+type EmailDigestConfig struct {
+	IMAPHost     string `json:"imap_host"`
+	IMAPPort     int    `json:"imap_port"`
+	IMAPUsername string `json:"imap_username"`
+	IMAPPassword string `json:"imap_password"`
+	// IMAPInsecure connects without TLS, for local bridges.
+	IMAPInsecure bool `json:"imap_insecure"`
+	// Mailbox defaults to INBOX.
+	Mailbox string `json:"mailbox"`
+	// MaxMessages is the most messages in one digest. The newest are kept.
+	MaxMessages int `json:"max_messages"`
+	NotificationConfig
+}
+
+func parseEmailDigestConfig(config string) (EmailDigestConfig, error) {
+	var cfg EmailDigestConfig
+	if config != "" {
+		if err := json.Unmarshal([]byte(config), &cfg); err != nil {
+			return cfg, fmt.Errorf("invalid email digest config: %w", err)
+		}
+	}
+	if cfg.IMAPHost == "" || cfg.IMAPUsername == "" {
+		return cfg, fmt.Errorf("email digest config needs imap_host and imap_username")
+	}
+	if cfg.IMAPPort == 0 {
+		cfg.IMAPPort = 993
+		if cfg.IMAPInsecure {
+			cfg.IMAPPort = 143
+		}
+	}
+	if cfg.Mailbox == "" {
+		cfg.Mailbox = "INBOX"
+	}
+	if cfg.MaxMessages <= 0 {
+		cfg.MaxMessages = defaultDigestMessages
+	}
+	return cfg, nil
+}
+
+func (c EmailDigestConfig) addr() string {
+	return net.JoinHostPort(c.IMAPHost, strconv.Itoa(c.IMAPPort))
+}
+
+// account is what the mailbox state is stored under.
+func (c EmailDigestConfig) account() string {
+	return c.IMAPUsername + "@" + c.addr()
+}
+
+// digestMessage is an email as the model gets to read it.
+type digestMessage struct {
+	UID     uint32 `json:"uid"`
+	From    string `json:"from"`
+	Subject string `json:"subject"`
+	Date    string `json:"date"`
+	Text    string `json:"-"`
+}
+
+// EmailDigestAgent summarizes the mail that came into an IMAP mailbox since
+// its last run. Messages are only read, never marked as read, and the UID of
+// the last one summarized is kept in email_digest.db so the next run starts
+// after it.
+type EmailDigestAgent struct {
+	customPrompt
+	Db *database.DigestDB
+	// dial opens the mailbox of config, on its IMAP server unless replaced.
+	dial func(ctx context.Context, config EmailDigestConfig) (mailbox, error)
+}
+
+func init() {
+	m.RegisterAgent("EmailDigestAgent", func() (m.AgentInterface, error) {
+		return NewEmailDigestAgent()
+	})
+}
+
+func NewEmailDigestAgent() (*EmailDigestAgent, error) {
+	db, err := database.NewDigestDB()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get digest db: %w", err)
+	}
+	return &EmailDigestAgent{Db: db, dial: dialMailbox}, nil
+}
+
+func dialMailbox(ctx context.Context, config EmailDigestConfig) (mailbox, error) {
+	client, err := dialIMAP(ctx, config.addr(), !config.IMAPInsecure, config.IMAPUsername, config.IMAPPassword)
+	if err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+const emailDigestSystemPrompt = `you write a digest of someone's unread email. start with the messages that need a reply or some action and say what is asked, then sum up the rest grouped by topic, in a sentence or two each. name the sender and subject of every message you mention. leave out newsletters and notifications that need nothing beyond a short mention. only use what is in the messages. write it in markdown.`
+
+func (a *EmailDigestAgent) DoWork(ctx context.Context, workload *pb.Workload, genAIClient m.GenAIClient) error {
+	if workload == nil {
+		return fmt.Errorf("workload is nil")
+	}
+	if genAIClient == nil {
+		return fmt.Errorf("genAIClient is nil")
+	}
+	config, err := parseEmailDigestConfig(workload.Config)
+	if err != nil {
+		return err
+	}
+	systemPrompt, err := a.promptFor(workload, emailDigestSystemPrompt)
+	if err != nil {
+		return err
+	}
+	input := string(workload.Payload)
+	account := config.account()
+
+	if workload.DryRun {
+		workload.Payload = []byte(dryRunPreview(input,
+			previewStep{"Mailbox", fmt.Sprintf("the unread messages of %s on %s after the last one summarized, at most %d", config.Mailbox, account, config.MaxMessages)},
+			previewStep{"System prompt", systemPrompt},
+			previewStep{"Digest database", fmt.Sprintf("the UID of the last message summarized in %s", config.Mailbox)},
+		))
+		return nil
+	}
+
+	state, err := a.Db.GetMailboxState(account, config.Mailbox)
+	if err != nil {
+		return err
+	}
+	mb, err := a.dial(ctx, config)
+	if err != nil {
+		return err
+	}
+	defer mb.Close()
+	messages, next, skipped, err := fetchNewMessages(mb, config.Mailbox, state, config.MaxMessages)
+	if err != nil {
+		return err
+	}
+
+	if len(messages) == 0 {
+		if err := a.Db.SetMailboxState(account, config.Mailbox, next); err != nil {
+			return err
+		}
+		report := fmt.Sprintf("_No new mail in %s since the last run._", config.Mailbox)
+		FormatResult(workload, input, report, withInput(input, report), map[string]any{"mailbox": config.Mailbox, "messages": []digestMessage{}})
+		return nil
+	}
+
+	summary, err := genAIClient.GenerateContentWithSystemPrompt(ctx, workload, formatDigestInput(input, config.Mailbox, messages), systemPrompt)
+	if err != nil {
+		return fmt.Errorf("error generating content: %w", err)
+	}
+	if err := a.Db.SetMailboxState(account, config.Mailbox, next); err != nil {
+		return err
+	}
+
+	subject := fmt.Sprintf("Email digest: %d new in %s", len(messages), config.Mailbox)
+	report := "## " + subject + "\n\n"
+	if skipped > 0 {
+		report += fmt.Sprintf("_%d older unread messages were left out._\n\n", skipped)
+	}
+	report += strings.TrimSpace(summary)
+
+	// The digest is in the payload either way, so failing to send it
+	// doesn't fail the run.
+	notifiers := config.Notifiers()
+	if config.DryRun {
+		for _, n := range notifiers {
+			log.Printf("Dry run: would send %s notification:\n%s", n.Name(), report)
+		}
+	} else {
+		failures := notifyAll(ctx, notifiers, subject, report)
+		for _, n := range notifiers {
+			if err, failed := failures[n.Name()]; failed {
+				log.Printf("Failed to send %s notification: %v", n.Name(), err)
+				report += fmt.Sprintf("\n\nFailed to send %s notification: %v", n.Name(), err)
+			}
+		}
+	}
+
+	FormatResult(workload, input, report, withInput(input, report), map[string]any{"mailbox": config.Mailbox, "messages": messages, "skipped": skipped})
+	return nil
+}
+
+// withInput puts the report after the input, if there was any.
+func withInput(input, report string) string {
+	if strings.TrimSpace(input) == "" {
+		return report
+	}
+	return input + transcriptSeparator + report
+}
+
+// fetchNewMessages returns the unread messages of mailbox name after the one
+// state stopped at, and the state to save once they are summarized. Only the
+// newest max are fetched; skipped is how many older ones were left out.
+func fetchNewMessages(mb mailbox, name string, state database.MailboxState, max int) (messages []digestMessage, next database.MailboxState, skipped int, err error) {
+	validity, err := mb.Select(name)
+	if err != nil {
+		return nil, state, 0, fmt.Errorf("failed to open mailbox %s: %w", name, err)
+	}
+	after := state.LastUID
+	if validity != state.UIDValidity {
+		// The mailbox was renumbered, so the UID of the last run means
+		// nothing, or there wasn't one.
+		after = 0
+	}
+	next = database.MailboxState{UIDValidity: validity, LastUID: after}
+
+	uids, err := mb.Unseen(after)
+	if err != nil {
+		return nil, state, 0, fmt.Errorf("failed to search mailbox %s: %w", name, err)
+	}
+	if len(uids) == 0 {
+		return nil, next, 0, nil
+	}
+	next.LastUID = uids[len(uids)-1]
+	if len(uids) > max {
+		skipped = len(uids) - max
+		uids = uids[skipped:]
+	}
+
+	for _, uid := range uids {
+		raw, err := mb.Fetch(uid, maxDigestMessageBytes)
+		if err != nil {
+			return nil, state, 0, fmt.Errorf("failed to fetch message %d: %w", uid, err)
+		}
+		messages = append(messages, parseDigestMessage(uid, raw))
+	}
+	return messages, next, skipped, nil
+}
+
+func formatDigestInput(input, mailbox string, messages []digestMessage) string {
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "write a digest of these %d unread emails in %s.\n", len(messages), mailbox)
+	for i, msg := range messages {
+		fmt.Fprintf(&builder, "\n--- Email %d ---\nFrom: %s\nDate: %s\nSubject: %s\n\n%s\n", i+1, msg.From, msg.Date, msg.Subject, msg.Text)
+	}
+	if strings.TrimSpace(input) != "" {
+		builder.WriteString("\n\n" + input)
+	}
+	return builder.String()
+}
+
+// parseDigestMessage reads the headers and text of a raw message, which may
+// be cut off.
+func parseDigestMessage(uid uint32, raw []byte) digestMessage {
+	msg := digestMessage{UID: uid}
+	parsed, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		msg.Text = clipMessageText(strings.ToValidUTF8(string(raw), "?"))
+		return msg
+	}
+	decoder := new(mime.WordDecoder)
+	decode := func(s string) string {
+		if decoded, err := decoder.DecodeHeader(s); err == nil {
+			return decoded
+		}
+		return s
+	}
+	msg.From = decode(parsed.Header.Get("From"))
+	msg.Subject = decode(parsed.Header.Get("Subject"))
+	msg.Date = parsed.Header.Get("Date")
+	if date, err := parsed.Header.Date(); err == nil {
+		msg.Date = date.Format("2006-01-02 15:04 -0700")
+	}
+	msg.Text = clipMessageText(messageText(textproto.MIMEHeader(parsed.Header), parsed.Body, 0))
+	return msg
+}
+
+// messageText returns the readable text of a message or part of one: the
+// plain text of a multipart message if it has any, else its HTML as
+// markdown. Attachments are left out.
+func messageText(header textproto.MIMEHeader, body io.Reader, depth int) string {
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		mediaType, params = "text/plain", nil
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		if depth > 5 || params["boundary"] == "" {
+			return ""
+		}
+		reader := multipart.NewReader(body, params["boundary"])
+		html := ""
+		for {
+			part, err := reader.NextRawPart()
+			if err != nil {
+				// Also where a cut off message ends.
+				return html
+			}
+			if strings.HasPrefix(strings.ToLower(part.Header.Get("Content-Disposition")), "attachment") {
+				continue
+			}
+			text := messageText(part.Header, part, depth+1)
+			if text == "" {
+				continue
+			}
+			if partType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type")); partType == "text/html" {
+				if html == "" {
+					html = text
+				}
+				continue
+			}
+			return text
+		}
+	}
+	if mediaType != "text/plain" && mediaType != "text/html" {
+		return ""
+	}
+
+	// A cut off body is still read up to where it stops.
+	data, _ := io.ReadAll(body)
+	switch strings.ToLower(strings.TrimSpace(header.Get("Content-Transfer-Encoding"))) {
+	case "quoted-printable":
+		data, _ = io.ReadAll(quotedprintable.NewReader(bytes.NewReader(data)))
+	case "base64":
+		encoded := strings.Join(strings.Fields(string(data)), "")
+		decoded := make([]byte, base64.StdEncoding.DecodedLen(len(encoded)))
+		n, _ := base64.StdEncoding.Decode(decoded, []byte(encoded[:len(encoded)/4*4]))
+		data = decoded[:n]
+	}
+
+	text := string(data)
+	if charset := strings.ToLower(params["charset"]); !utf8.Valid(data) && (charset == "iso-8859-1" || charset == "latin1" || charset == "windows-1252") {
+		runes := make([]rune, len(data))
+		for i, b := range data {
+			runes[i] = rune(b)
+		}
+		text = string(runes)
+	}
+	text = strings.ToValidUTF8(text, "?")
+	if mediaType == "text/html" {
+		text = textutil.CleanHTML(text)
+	}
+	return text
+}
+
+// clipMessageText drops quoted replies and blank line runs from text and
+// cuts it to maxDigestMessageChars.
+func clipMessageText(text string) string {
+	var lines []string
+	blank := false
+	for _, line := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n") {
+		line = strings.TrimRight(line, " \t")
+		if strings.HasPrefix(line, ">") {
+			continue
+		}
+		if line == "" {
+			blank = len(lines) > 0
+			continue
+		}
+		if blank {
+			lines = append(lines, "")
+			blank = false
+		}
+		lines = append(lines, line)
+	}
+	text = strings.Join(lines, "\n")
+	if utf8.RuneCountInString(text) <= maxDigestMessageChars {
+		return text
+	}
+	return string([]rune(text)[:maxDigestMessageChars]) + "…"
+}
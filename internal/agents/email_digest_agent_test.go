@@ -0,0 +1,374 @@
+package agents
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/nieveai/d-agents/internal/database"
+	"github.com/nieveai/d-agents/internal/testutil"
+	pb "github.com/nieveai/d-agents/proto"
+)
+
+// rawMail returns a message from Alice with subject and body.
+func rawMail(subject, body string) string {
+	return "From: Alice <alice@mail.test>\r\nSubject: " + subject + "\r\nDate: Mon, 06 May 2024 09:00:00 +0000\r\n\r\n" + body + "\r\n"
+}
+
+// fakeMailbox is a mailbox whose messages all stay unread, as they do when
+// the agent only peeks at them.
+type fakeMailbox struct {
+	validity uint32
+	messages map[uint32]string
+	// err, if set, is returned by the method it's keyed by.
+	err map[string]error
+
+	searched []uint32
+	fetched  []uint32
+	closed   bool
+}
+
+func (f *fakeMailbox) Select(name string) (uint32, error) {
+	return f.validity, f.err["Select"]
+}
+
+func (f *fakeMailbox) Unseen(after uint32) ([]uint32, error) {
+	f.searched = append(f.searched, after)
+	var uids []uint32
+	for uid := range f.messages {
+		if uid > after {
+			uids = append(uids, uid)
+		}
+	}
+	slices.Sort(uids)
+	return uids, f.err["Unseen"]
+}
+
+func (f *fakeMailbox) Fetch(uid uint32, max int) ([]byte, error) {
+	f.fetched = append(f.fetched, uid)
+	if err := f.err["Fetch"]; err != nil {
+		return nil, err
+	}
+	return []byte(f.messages[uid]), nil
+}
+
+func (f *fakeMailbox) Close() error {
+	f.closed = true
+	return nil
+}
+
+func TestFetchNewMessages(t *testing.T) {
+	messages := map[uint32]string{3: rawMail("three", "3"), 5: rawMail("five", "5"), 8: rawMail("eight", "8"), 9: rawMail("nine", "9")}
+	tests := []struct {
+		name        string
+		state       database.MailboxState
+		max         int
+		wantUIDs    []uint32
+		wantNext    database.MailboxState
+		wantSkipped int
+	}{
+		{"first run", database.MailboxState{}, 10, []uint32{3, 5, 8, 9}, database.MailboxState{UIDValidity: 7, LastUID: 9}, 0},
+		{"since the last run", database.MailboxState{UIDValidity: 7, LastUID: 5}, 10, []uint32{8, 9}, database.MailboxState{UIDValidity: 7, LastUID: 9}, 0},
+		{"nothing new", database.MailboxState{UIDValidity: 7, LastUID: 9}, 10, nil, database.MailboxState{UIDValidity: 7, LastUID: 9}, 0},
+		{"renumbered mailbox", database.MailboxState{UIDValidity: 6, LastUID: 8}, 10, []uint32{3, 5, 8, 9}, database.MailboxState{UIDValidity: 7, LastUID: 9}, 0},
+		{"newest only", database.MailboxState{}, 2, []uint32{8, 9}, database.MailboxState{UIDValidity: 7, LastUID: 9}, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mb := &fakeMailbox{validity: 7, messages: messages}
+			got, next, skipped, err := fetchNewMessages(mb, "INBOX", tt.state, tt.max)
+			if err != nil {
+				t.Fatalf("fetchNewMessages: %v", err)
+			}
+			var uids []uint32
+			for _, msg := range got {
+				uids = append(uids, msg.UID)
+				if want := strconv.Itoa(int(msg.UID)); msg.Text != want {
+					t.Errorf("message %d has text %q, want %q", msg.UID, msg.Text, want)
+				}
+			}
+			if !slices.Equal(uids, tt.wantUIDs) || !slices.Equal(mb.fetched, tt.wantUIDs) {
+				t.Errorf("got messages %v and fetched %v, want %v", uids, mb.fetched, tt.wantUIDs)
+			}
+			if next != tt.wantNext || skipped != tt.wantSkipped {
+				t.Errorf("next = %+v, skipped %d, want %+v, %d", next, skipped, tt.wantNext, tt.wantSkipped)
+			}
+		})
+	}
+}
+
+func TestFetchNewMessagesErrors(t *testing.T) {
+	state := database.MailboxState{UIDValidity: 7, LastUID: 3}
+	for _, method := range []string{"Select", "Unseen", "Fetch"} {
+		mb := &fakeMailbox{validity: 7, messages: map[uint32]string{5: rawMail("five", "5")}, err: map[string]error{method: errors.New("connection reset")}}
+		_, next, _, err := fetchNewMessages(mb, "INBOX", state, 10)
+		if err == nil || !strings.Contains(err.Error(), "connection reset") {
+			t.Errorf("fetchNewMessages with %s failing = %v, want its error", method, err)
+		}
+		if next != state {
+			t.Errorf("fetchNewMessages with %s failing moved the state to %+v", method, next)
+		}
+	}
+}
+
+// newTestDigestAgent returns an EmailDigestAgent with a fresh digest database
+// that reads mb.
+func newTestDigestAgent(t *testing.T, mb mailbox) *EmailDigestAgent {
+	t.Helper()
+	t.Chdir(t.TempDir())
+	db, err := database.NewDigestDB()
+	if err != nil {
+		t.Fatalf("NewDigestDB: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return &EmailDigestAgent{Db: db, dial: func(ctx context.Context, config EmailDigestConfig) (mailbox, error) {
+		return mb, nil
+	}}
+}
+
+const digestConfig = `{"imap_host": "imap.test", "imap_username": "me"}`
+
+func TestEmailDigestAgentOnlySummarizesNewMail(t *testing.T) {
+	mb := &fakeMailbox{validity: 7, messages: map[uint32]string{3: rawMail("Lunch?", "are you free at noon"), 5: rawMail("Invoice", "attached")}}
+	agent := newTestDigestAgent(t, mb)
+	client := testutil.NewFakeGenAIClient("Alice asks about lunch.", "Alice sent the report.")
+	run := func() *pb.Workload {
+		t.Helper()
+		workload := &pb.Workload{Id: "s1", Models: []string{"m1"}, Config: digestConfig}
+		if err := agent.DoWork(context.Background(), workload, client); err != nil {
+			t.Fatalf("DoWork: %v", err)
+		}
+		return workload
+	}
+
+	workload := run()
+	call, _ := client.LastCall()
+	if !strings.Contains(call.Input, "Subject: Lunch?") || !strings.Contains(call.Input, "Subject: Invoice") {
+		t.Errorf("the model got %q, want both messages", call.Input)
+	}
+	if want := "## Email digest: 2 new in INBOX\n\nAlice asks about lunch."; string(workload.Payload) != want {
+		t.Errorf("payload = %q, want %q", workload.Payload, want)
+	}
+	if !mb.closed {
+		t.Error("the mailbox wasn't closed")
+	}
+
+	// The messages are still unread, but only the new one is summarized.
+	mb.messages[9] = rawMail("Report", "the Q2 numbers")
+	workload = run()
+	call, _ = client.LastCall()
+	if !strings.Contains(call.Input, "Subject: Report") || strings.Contains(call.Input, "Lunch?") || strings.Contains(call.Input, "Invoice") {
+		t.Errorf("the model got %q, want only the new message", call.Input)
+	}
+	if !strings.HasPrefix(string(workload.Payload), "## Email digest: 1 new in INBOX") {
+		t.Errorf("payload = %q, want a digest of one message", workload.Payload)
+	}
+
+	workload = run()
+	if len(client.Calls()) != 2 {
+		t.Errorf("the model was called %d times, want no call without new mail", len(client.Calls()))
+	}
+	if want := "_No new mail in INBOX since the last run._"; string(workload.Payload) != want {
+		t.Errorf("payload = %q, want %q", workload.Payload, want)
+	}
+	if !slices.Equal(mb.searched, []uint32{0, 5, 9}) {
+		t.Errorf("searched after UIDs %v, want 0, 5, 9", mb.searched)
+	}
+}
+
+func TestEmailDigestAgentKeepsStateOnFailure(t *testing.T) {
+	mb := &fakeMailbox{validity: 7, messages: map[uint32]string{3: rawMail("Lunch?", "noon")}}
+	agent := newTestDigestAgent(t, mb)
+	workload := &pb.Workload{Id: "s1", Models: []string{"m1"}, Config: digestConfig}
+
+	client := testutil.NewFakeGenAIClient().Fail(errors.New("model down"))
+	if err := agent.DoWork(context.Background(), workload, client); err == nil {
+		t.Fatal("DoWork succeeded without a summary")
+	}
+	if state, _ := agent.Db.GetMailboxState("me@imap.test:993", "INBOX"); state != (database.MailboxState{}) {
+		t.Errorf("state after a failed run = %+v, want it unchanged", state)
+	}
+
+	// The next run picks the message up again.
+	client = testutil.NewFakeGenAIClient("Alice asks about lunch.")
+	if err := agent.DoWork(context.Background(), workload, client); err != nil {
+		t.Fatalf("DoWork: %v", err)
+	}
+	if call, _ := client.LastCall(); !strings.Contains(call.Input, "Lunch?") {
+		t.Errorf("the model got %q, want the message of the failed run", call.Input)
+	}
+}
+
+func TestEmailDigestAgentConnectionErrors(t *testing.T) {
+	authErr := &imapError{Command: "LOGIN", Status: "NO", Text: "[AUTHENTICATIONFAILED] Invalid credentials"}
+	agent := newTestDigestAgent(t, nil)
+	agent.dial = func(ctx context.Context, config EmailDigestConfig) (mailbox, error) {
+		return nil, fmt.Errorf("failed to log in as %s: %w", config.IMAPUsername, authErr)
+	}
+	client := testutil.NewFakeGenAIClient("unused")
+	workload := &pb.Workload{Id: "s1", Models: []string{"m1"}, Config: digestConfig}
+
+	err := agent.DoWork(context.Background(), workload, client)
+	var imapErr *imapError
+	if !errors.As(err, &imapErr) || imapErr.Status != "NO" {
+		t.Errorf("DoWork with a wrong password = %v, want the IMAP error", err)
+	}
+	if len(client.Calls()) != 0 {
+		t.Error("the model was called without a mailbox")
+	}
+
+	for _, config := range []string{"", `{"imap_host": "imap.test"}`, `{"imap_host": `} {
+		workload := &pb.Workload{Id: "s1", Models: []string{"m1"}, Config: config}
+		if err := agent.DoWork(context.Background(), workload, client); err == nil {
+			t.Errorf("DoWork with config %q succeeded", config)
+		}
+	}
+}
+
+// fakeIMAP is an IMAP server with just the commands imapClient sends. Its
+// messages are never marked as read.
+type fakeIMAP struct {
+	addr     string
+	password string
+
+	mu       sync.Mutex
+	messages map[uint32]string
+	searches []string
+}
+
+func newFakeIMAP(t *testing.T, password string, messages map[uint32]string) *fakeIMAP {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	f := &fakeIMAP{addr: listener.Addr().String(), password: password, messages: messages}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	return f
+}
+
+func (f *fakeIMAP) add(uid uint32, raw string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.messages[uid] = raw
+}
+
+func (f *fakeIMAP) Searches() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return slices.Clone(f.searches)
+}
+
+func (f *fakeIMAP) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	fmt.Fprint(conn, "* OK fake IMAP ready\r\n")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		tag, cmd, _ := strings.Cut(strings.TrimRight(line, "\r\n"), " ")
+		f.mu.Lock()
+		switch {
+		case strings.HasPrefix(cmd, "LOGIN "):
+			if strings.HasSuffix(cmd, ` "`+f.password+`"`) {
+				fmt.Fprintf(conn, "%s OK logged in\r\n", tag)
+			} else {
+				fmt.Fprintf(conn, "%s NO [AUTHENTICATIONFAILED] Invalid credentials\r\n", tag)
+			}
+		case cmd == `SELECT "INBOX"`:
+			fmt.Fprintf(conn, "* %d EXISTS\r\n* OK [UIDVALIDITY 42] UIDs valid\r\n%s OK [READ-WRITE] done\r\n", len(f.messages), tag)
+		case strings.HasPrefix(cmd, "UID SEARCH "):
+			f.searches = append(f.searches, cmd)
+			var from uint32
+			fmt.Sscanf(cmd, "UID SEARCH UNSEEN UID %d:*", &from)
+			var found []string
+			var last uint32
+			for uid := range f.messages {
+				if uid >= from {
+					found = append(found, strconv.Itoa(int(uid)))
+				}
+				last = max(last, uid)
+			}
+			if len(found) == 0 && last > 0 {
+				// n:* matches the last message even when its UID is below n.
+				found = append(found, strconv.Itoa(int(last)))
+			}
+			fmt.Fprintf(conn, "* SEARCH %s\r\n%s OK done\r\n", strings.Join(found, " "), tag)
+		case strings.HasPrefix(cmd, "UID FETCH "):
+			var uid uint32
+			fmt.Sscanf(cmd, "UID FETCH %d", &uid)
+			raw := f.messages[uid]
+			fmt.Fprintf(conn, "* 1 FETCH (UID %d BODY[]<0> {%d}\r\n%s)\r\n%s OK done\r\n", uid, len(raw), raw, tag)
+		case cmd == "LOGOUT":
+			fmt.Fprintf(conn, "* BYE\r\n%s OK bye\r\n", tag)
+			f.mu.Unlock()
+			return
+		default:
+			fmt.Fprintf(conn, "%s BAD unknown command\r\n", tag)
+		}
+		f.mu.Unlock()
+	}
+}
+
+func TestEmailDigestAgentWithIMAPServer(t *testing.T) {
+	server := newFakeIMAP(t, "s3cret", map[uint32]string{3: rawMail("Lunch?", "noon"), 7: rawMail("Invoice", "attached")})
+	host, port, _ := net.SplitHostPort(server.addr)
+	agent := newTestDigestAgent(t, nil)
+	agent.dial = dialMailbox
+	client := testutil.NewFakeGenAIClient("first digest", "second digest")
+	run := func(password string) error {
+		config := fmt.Sprintf(`{"imap_host": %q, "imap_port": %s, "imap_insecure": true, "imap_username": "me", "imap_password": %q}`, host, port, password)
+		return agent.DoWork(context.Background(), &pb.Workload{Id: "s1", Models: []string{"m1"}, Config: config}, client)
+	}
+
+	if err := run("s3cret"); err != nil {
+		t.Fatalf("DoWork: %v", err)
+	}
+	server.add(12, rawMail("Report", "the Q2 numbers"))
+	if err := run("s3cret"); err != nil {
+		t.Fatalf("DoWork: %v", err)
+	}
+	// Nothing new, even though the server answers 13:* with message 12.
+	if err := run("s3cret"); err != nil {
+		t.Fatalf("DoWork: %v", err)
+	}
+
+	calls := client.Calls()
+	if len(calls) != 2 {
+		t.Fatalf("the model was called %d times, want 2", len(calls))
+	}
+	if !strings.Contains(calls[0].Input, "Lunch?") || !strings.Contains(calls[0].Input, "Invoice") {
+		t.Errorf("first digest of %q, want both messages", calls[0].Input)
+	}
+	if !strings.Contains(calls[1].Input, "Report") || strings.Contains(calls[1].Input, "Lunch?") {
+		t.Errorf("second digest of %q, want only the new message", calls[1].Input)
+	}
+	want := []string{"UID SEARCH UNSEEN UID 1:*", "UID SEARCH UNSEEN UID 8:*", "UID SEARCH UNSEEN UID 13:*"}
+	if got := server.Searches(); !slices.Equal(got, want) {
+		t.Errorf("searches = %q, want %q", got, want)
+	}
+
+	err := run("wrong")
+	var imapErr *imapError
+	if !errors.As(err, &imapErr) || imapErr.Command != "LOGIN" || strings.Contains(err.Error(), "wrong") {
+		t.Errorf("DoWork with a wrong password = %v, want a LOGIN error without the password", err)
+	}
+}
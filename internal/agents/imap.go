@@ -0,0 +1,270 @@
+package agents
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// imapTimeout bounds a whole IMAP session when the context has no deadline.
+const imapTimeout = 2 * time.Minute
+
+// maxIMAPLiteral is the largest literal read from a server, to not be made
+// to allocate whatever size it claims.
+const maxIMAPLiteral = 16 << 20
+
+// mailbox is the part of an IMAP account EmailDigestAgent reads, so the agent
+// can be run against something other than a live server.
+type mailbox interface {
+	// Select opens the mailbox name and returns its UIDVALIDITY.
+	Select(name string) (uint32, error)
+	// Unseen returns the UIDs of the unread messages after uid, lowest first.
+	Unseen(after uint32) ([]uint32, error)
+	// Fetch returns the first max bytes of the raw message uid, leaving it
+	// unread.
+	Fetch(uid uint32, max int) ([]byte, error)
+	Close() error
+}
+
+// imapClient is just enough of an IMAP4rev1 client for EmailDigestAgent: log
+// in, select a mailbox, search it and fetch messages by UID.
+type imapClient struct {
+	conn net.Conn
+	r    *bufio.Reader
+	tag  int
+	stop func() bool
+}
+
+// imapError is a NO or BAD answer of an IMAP server to a command.
+type imapError struct {
+	Command string
+	Status  string
+	Text    string
+}
+
+func (e *imapError) Error() string {
+	return fmt.Sprintf("IMAP %s failed: %s %s", e.Command, e.Status, e.Text)
+}
+
+// imapLine is a response line, with its literals taken out and left as {n}
+// in text.
+type imapLine struct {
+	text     string
+	literals [][]byte
+}
+
+// dialIMAP connects to the server at addr over TLS, or in the clear if
+// useTLS is false, and logs in. The connection is closed when ctx is done.
+func dialIMAP(ctx context.Context, addr string, useTLS bool, username, password string) (*imapClient, error) {
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+	var conn net.Conn
+	var err error
+	if useTLS {
+		host, _, _ := net.SplitHostPort(addr)
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: &tls.Config{ServerName: host}}).DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to IMAP server %s: %w", addr, err)
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(imapTimeout)
+	}
+	conn.SetDeadline(deadline)
+
+	c := &imapClient{conn: conn, r: bufio.NewReader(conn)}
+	c.stop = context.AfterFunc(ctx, func() { conn.Close() })
+
+	greeting, err := c.readLine()
+	if err != nil {
+		c.Close()
+		return nil, fmt.Errorf("failed to read IMAP greeting: %w", err)
+	}
+	switch {
+	case strings.HasPrefix(greeting.text, "* PREAUTH"):
+		return c, nil
+	case !strings.HasPrefix(greeting.text, "* OK"):
+		c.Close()
+		return nil, fmt.Errorf("IMAP server refused the connection: %s", greeting.text)
+	}
+
+	user, err := imapQuote(username)
+	if err == nil {
+		var pass string
+		pass, err = imapQuote(password)
+		if err == nil {
+			_, err = c.command("LOGIN", "LOGIN "+user+" "+pass)
+		}
+	}
+	if err != nil {
+		c.Close()
+		return nil, fmt.Errorf("failed to log in as %s: %w", username, err)
+	}
+	return c, nil
+}
+
+func (c *imapClient) Select(name string) (uint32, error) {
+	quoted, err := imapQuote(name)
+	if err != nil {
+		return 0, err
+	}
+	lines, err := c.command("SELECT", "SELECT "+quoted)
+	if err != nil {
+		return 0, err
+	}
+	for _, line := range lines {
+		if _, rest, ok := strings.Cut(line.text, "[UIDVALIDITY "); ok {
+			value, _, _ := strings.Cut(rest, "]")
+			validity, err := strconv.ParseUint(value, 10, 32)
+			if err != nil {
+				return 0, fmt.Errorf("invalid UIDVALIDITY %q", value)
+			}
+			return uint32(validity), nil
+		}
+	}
+	return 0, fmt.Errorf("IMAP server sent no UIDVALIDITY for %s", name)
+}
+
+func (c *imapClient) Unseen(after uint32) ([]uint32, error) {
+	lines, err := c.command("SEARCH", fmt.Sprintf("UID SEARCH UNSEEN UID %d:*", after+1))
+	if err != nil {
+		return nil, err
+	}
+	var uids []uint32
+	for _, line := range lines {
+		fields := strings.Fields(line.text)
+		if len(fields) < 2 || fields[0] != "*" || !strings.EqualFold(fields[1], "SEARCH") {
+			continue
+		}
+		for _, field := range fields[2:] {
+			uid, err := strconv.ParseUint(field, 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("invalid UID %q in search results", field)
+			}
+			// n:* always matches the last message, even when its UID is
+			// below n.
+			if uint32(uid) > after {
+				uids = append(uids, uint32(uid))
+			}
+		}
+	}
+	slices.Sort(uids)
+	return slices.Compact(uids), nil
+}
+
+func (c *imapClient) Fetch(uid uint32, max int) ([]byte, error) {
+	lines, err := c.command("FETCH", fmt.Sprintf("UID FETCH %d (BODY.PEEK[]<0.%d>)", uid, max))
+	if err != nil {
+		return nil, err
+	}
+	// Flag changes can come as FETCH responses too, but without a body.
+	for _, line := range lines {
+		if strings.Contains(strings.ToUpper(line.text), " FETCH ") && len(line.literals) > 0 {
+			return line.literals[0], nil
+		}
+	}
+	return nil, fmt.Errorf("IMAP server sent no message for UID %d", uid)
+}
+
+// Close logs out, without waiting long for the server to answer.
+func (c *imapClient) Close() error {
+	c.conn.SetDeadline(time.Now().Add(5 * time.Second))
+	c.command("LOGOUT", "LOGOUT")
+	if c.stop != nil {
+		c.stop()
+	}
+	return c.conn.Close()
+}
+
+// command sends cmd and returns the untagged responses to it. It fails unless
+// the server answers OK. name is what cmd is called in errors, which would
+// show the password of a LOGIN.
+func (c *imapClient) command(name, cmd string) ([]imapLine, error) {
+	c.tag++
+	tag := "a" + strconv.Itoa(c.tag)
+	if _, err := io.WriteString(c.conn, tag+" "+cmd+"\r\n"); err != nil {
+		return nil, fmt.Errorf("failed to send IMAP %s: %w", name, err)
+	}
+	var untagged []imapLine
+	for {
+		line, err := c.readLine()
+		if err != nil {
+			return untagged, fmt.Errorf("failed to read IMAP %s response: %w", name, err)
+		}
+		if rest, ok := strings.CutPrefix(line.text, tag+" "); ok {
+			status, text, _ := strings.Cut(rest, " ")
+			if !strings.EqualFold(status, "OK") {
+				return untagged, &imapError{Command: name, Status: strings.ToUpper(status), Text: text}
+			}
+			return untagged, nil
+		}
+		if strings.HasPrefix(line.text, "+") {
+			// None of the commands send literals.
+			return untagged, fmt.Errorf("unexpected IMAP continuation for %s: %s", name, line.text)
+		}
+		untagged = append(untagged, line)
+	}
+}
+
+// readLine reads a response line along with the literals in it.
+func (c *imapClient) readLine() (imapLine, error) {
+	var line imapLine
+	var text strings.Builder
+	for {
+		s, err := c.r.ReadString('\n')
+		if err != nil {
+			return line, err
+		}
+		s = strings.TrimRight(s, "\r\n")
+		text.WriteString(s)
+		size, ok := literalSize(s)
+		if !ok {
+			break
+		}
+		if size > maxIMAPLiteral {
+			return line, fmt.Errorf("IMAP literal of %d bytes is too large", size)
+		}
+		literal := make([]byte, size)
+		if _, err := io.ReadFull(c.r, literal); err != nil {
+			return line, err
+		}
+		line.literals = append(line.literals, literal)
+	}
+	line.text = text.String()
+	return line, nil
+}
+
+// literalSize returns n if s ends with a literal's {n}.
+func literalSize(s string) (int, bool) {
+	if !strings.HasSuffix(s, "}") {
+		return 0, false
+	}
+	start := strings.LastIndexByte(s, '{')
+	if start < 0 {
+		return 0, false
+	}
+	size, err := strconv.Atoi(s[start+1 : len(s)-1])
+	if err != nil || size < 0 {
+		return 0, false
+	}
+	return size, true
+}
+
+// imapQuote writes s as an IMAP quoted string.
+func imapQuote(s string) (string, error) {
+	if strings.ContainsAny(s, "\r\n\x00") {
+		return "", fmt.Errorf("IMAP strings can't contain line breaks")
+	}
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	return `"` + s + `"`, nil
+}
@@ -0,0 +1,248 @@
+package agents
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+	localmcp "github.com/nieveai/d-agents/internal/mcp"
+	m "github.com/nieveai/d-agents/internal/models"
+	pb "github.com/nieveai/d-agents/proto"
+)
+
+// MCPConfig is the workload config understood by MCPAgent, e.g.
+// {"transport":"command","command":"my-mcp-server","max_steps":5}.
+type MCPConfig struct {
+	localmcp.TransportConfig
+	// MaxSteps limits how many tool calls the model can make before it has to answer.
+	MaxSteps int `json:"max_steps,omitempty"`
+	// NativeTools passes the tools to the model through the provider's tool
+	// calling instead of the system prompt. Models without it fall back to the prompt.
+	NativeTools bool `json:"native_tools,omitempty"`
+}
+
+const defaultMCPMaxSteps = 8
+
+const mcpSystemPromptTemplate = `you are an assistant that can use tools to answer the user message. the tools are:
+
+%s
+to call a tool, reply with only a JSON object like {"tool": "tool_name", "arguments": {...}} where the arguments match the tool's input schema. you will then get the tool result and can call another tool. when you have everything you need, reply with {"answer": "your final answer"}.`
+
+const mcpNativeSystemPrompt = `you are an assistant that can use tools to answer the user message. call the tools you need, then reply with your final answer.`
+
+// mcpToolCall is the JSON the model replies with to call a tool or answer.
+type mcpToolCall struct {
+	Tool      string         `json:"tool"`
+	Arguments map[string]any `json:"arguments"`
+	Answer    string         `json:"answer"`
+}
+
+// MCPAgent answers the payload using the tools of an MCP server. By default the
+// tools are described to the model in the system prompt and the model asks for
+// calls in JSON, so it works with any model rather than only ones with native
+// tool use; set native_tools in the config to use the provider's tool calling.
+type MCPAgent struct {
+	// Transport, if set, is used instead of the one in the workload config.
+	Transport mcp.Transport
+}
+
+func init() {
+	m.RegisterAgent("MCPAgent", func() (m.AgentInterface, error) {
+		return &MCPAgent{}, nil
+	})
+}
+
+func (a *MCPAgent) DoWork(ctx context.Context, workload *pb.Workload, genAIClient m.GenAIClient) error {
+	if workload == nil {
+		return fmt.Errorf("workload is nil")
+	}
+	if genAIClient == nil {
+		return fmt.Errorf("genAIClient is nil")
+	}
+
+	config, err := parseMCPConfig(workload.Config)
+	if err != nil {
+		return err
+	}
+	if workload.DryRun {
+		server := "the transport set on the agent"
+		if a.Transport == nil {
+			server = describeTransport(config.TransportConfig)
+		}
+		systemPrompt := fmt.Sprintf(mcpSystemPromptTemplate, "<tools of the server>\n")
+		if config.NativeTools {
+			systemPrompt = mcpNativeSystemPrompt + "\n\n(the tools of the server are sent as native tool definitions)"
+		}
+		workload.Payload = []byte(dryRunPreview(string(workload.Payload),
+			previewStep{"MCP server", server},
+			previewStep{"System prompt", systemPrompt},
+			previewStep{"User message", string(workload.Payload)},
+		))
+		return nil
+	}
+
+	var session *mcp.ClientSession
+	if a.Transport != nil {
+		session, err = localmcp.Connect(ctx, localmcp.NewClient(), a.Transport)
+	} else {
+		session, err = localmcp.Dial(ctx, localmcp.NewClient(), config.TransportConfig)
+	}
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	var tools []*mcp.Tool
+	for tool, err := range session.Tools(ctx, nil) {
+		if err != nil {
+			return fmt.Errorf("failed to list MCP tools: %w", err)
+		}
+		tools = append(tools, tool)
+	}
+	input := string(workload.Payload)
+	answer := ""
+	if caller, ok := genAIClient.(m.ToolCaller); ok && config.NativeTools {
+		answer, err = caller.GenerateWithTools(ctx, workload, input, mcpNativeSystemPrompt, nativeTools(session, tools), config.MaxSteps)
+		if errors.Is(err, m.ErrToolsNotSupported) {
+			log.Printf("MCPAgent: %v, describing the tools in the prompt instead", err)
+			answer, err = answerWithPrompt(ctx, workload, genAIClient, session, tools, input, config.MaxSteps)
+		} else if err != nil {
+			err = fmt.Errorf("error generating content: %w", err)
+		}
+	} else {
+		answer, err = answerWithPrompt(ctx, workload, genAIClient, session, tools, input, config.MaxSteps)
+	}
+	if err != nil {
+		return err
+	}
+
+	newPayload := fmt.Sprintf("%s\n\n---\n\n%s", input, answer)
+	FormatResult(workload, input, answer, newPayload, nil)
+
+	return nil
+}
+
+func parseMCPConfig(config string) (MCPConfig, error) {
+	var cfg MCPConfig
+	if strings.TrimSpace(config) != "" {
+		if err := json.Unmarshal([]byte(config), &cfg); err != nil {
+			return cfg, fmt.Errorf("invalid MCP config: %w", err)
+		}
+	}
+	if cfg.MaxSteps <= 0 {
+		cfg.MaxSteps = defaultMCPMaxSteps
+	}
+	return cfg, nil
+}
+
+// answerWithPrompt runs the tool loop with the tools described in the system
+// prompt and the calls and their results added to the user message.
+func answerWithPrompt(ctx context.Context, workload *pb.Workload, genAIClient m.GenAIClient, session *mcp.ClientSession, tools []*mcp.Tool, input string, maxSteps int) (string, error) {
+	systemPrompt := fmt.Sprintf(mcpSystemPromptTemplate, describeTools(tools))
+
+	var transcript strings.Builder
+	transcript.WriteString(input)
+
+	for step := 0; step <= maxSteps; step++ {
+		llmResponse, err := genAIClient.GenerateContentWithSystemPrompt(ctx, workload, transcript.String(), systemPrompt)
+		if err != nil {
+			return "", fmt.Errorf("error generating content: %w", err)
+		}
+
+		var call mcpToolCall
+		jsonString := extractJSONObject(llmResponse)
+		if jsonString == "" || json.Unmarshal([]byte(jsonString), &call) != nil || call.Tool == "" {
+			// Anything that isn't a tool call is taken as the answer.
+			if call.Answer != "" {
+				return call.Answer, nil
+			}
+			return llmResponse, nil
+		}
+		if step == maxSteps {
+			break
+		}
+
+		result := callMCPTool(ctx, session, call)
+		args, _ := json.Marshal(call.Arguments)
+		fmt.Fprintf(&transcript, "\n\nTool call: %s %s\nTool result:\n%s", call.Tool, args, result)
+	}
+	return "", fmt.Errorf("no answer after %d tool calls", maxSteps)
+}
+
+// nativeTools wraps the MCP tools for GenerateWithTools.
+func nativeTools(session *mcp.ClientSession, tools []*mcp.Tool) []m.Tool {
+	result := make([]m.Tool, 0, len(tools))
+	for _, tool := range tools {
+		var schema map[string]any
+		if data, err := json.Marshal(tool.InputSchema); err == nil {
+			json.Unmarshal(data, &schema)
+		}
+		if schema == nil {
+			schema = map[string]any{"type": "object", "properties": map[string]any{}}
+		}
+		name := tool.Name
+		result = append(result, m.Tool{
+			Name:        name,
+			Description: tool.Description,
+			Parameters:  schema,
+			Call: func(ctx context.Context, arguments string) (string, error) {
+				call := mcpToolCall{Tool: name}
+				if strings.TrimSpace(arguments) != "" {
+					if err := json.Unmarshal([]byte(arguments), &call.Arguments); err != nil {
+						return "", fmt.Errorf("arguments aren't a JSON object: %w", err)
+					}
+				}
+				return callMCPTool(ctx, session, call), nil
+			},
+		})
+	}
+	return result
+}
+
+// describeTools lists the tools with their input schemas for the system prompt.
+func describeTools(tools []*mcp.Tool) string {
+	if len(tools) == 0 {
+		return "(none)\n"
+	}
+	var b strings.Builder
+	for _, tool := range tools {
+		schema, _ := json.Marshal(tool.InputSchema)
+		fmt.Fprintf(&b, "- %s: %s\n  input schema: %s\n", tool.Name, tool.Description, schema)
+	}
+	return b.String()
+}
+
+// callMCPTool runs a tool call and returns its text output. Failures are
+// returned as text too, so the model can see them and try something else.
+func callMCPTool(ctx context.Context, session *mcp.ClientSession, call mcpToolCall) string {
+	res, err := session.CallTool(ctx, &mcp.CallToolParams{Name: call.Tool, Arguments: call.Arguments})
+	if err != nil {
+		return fmt.Sprintf("error: %v", err)
+	}
+
+	var b strings.Builder
+	if res.IsError {
+		b.WriteString("error: ")
+	}
+	for _, content := range res.Content {
+		if text, ok := content.(*mcp.TextContent); ok {
+			b.WriteString(text.Text)
+		} else {
+			data, _ := json.Marshal(content)
+			b.Write(data)
+		}
+	}
+	return b.String()
+}
+
+// describeTransport says which MCP server a transport config connects to.
+func describeTransport(cfg localmcp.TransportConfig) string {
+	if cfg.Endpoint != "" {
+		return fmt.Sprintf("%s %s", cfg.Transport, cfg.Endpoint)
+	}
+	return strings.TrimSpace(fmt.Sprintf("%s %s %s", cfg.Transport, cfg.Command, strings.Join(cfg.Args, " ")))
+}
@@ -0,0 +1,101 @@
+package agents
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+	"github.com/nieveai/d-agents/internal/testutil"
+	pb "github.com/nieveai/d-agents/proto"
+)
+
+type addInput struct {
+	A int `json:"a"`
+	B int `json:"b"`
+}
+
+// newFakeMCPServer runs an in-memory MCP server with an add tool and returns
+// the transport to reach it.
+func newFakeMCPServer(t *testing.T) mcp.Transport {
+	t.Helper()
+	server := mcp.NewServer(&mcp.Implementation{Name: "fake", Version: "v0.0.1"}, nil)
+	mcp.AddTool(server, &mcp.Tool{Name: "add", Description: "Add two numbers."},
+		func(ctx context.Context, req *mcp.CallToolRequest, in addInput) (*mcp.CallToolResult, any, error) {
+			return &mcp.CallToolResult{Content: []mcp.Content{&mcp.TextContent{Text: fmt.Sprint(in.A + in.B)}}}, nil, nil
+		})
+
+	serverTransport, clientTransport := mcp.NewInMemoryTransports()
+	session, err := server.Connect(context.Background(), serverTransport, nil)
+	if err != nil {
+		t.Fatalf("server.Connect: %v", err)
+	}
+	t.Cleanup(func() { session.Close() })
+	return clientTransport
+}
+
+func TestMCPAgent(t *testing.T) {
+	tests := []struct {
+		name        string
+		responses   []string
+		wantAnswer  string
+		wantResults []string
+	}{
+		{
+			name:        "tool call then answer",
+			responses:   []string{`{"tool": "add", "arguments": {"a": 2, "b": 3}}`, `{"answer": "2 + 3 is 5"}`},
+			wantAnswer:  "2 + 3 is 5",
+			wantResults: []string{"Tool call: add {\"a\":2,\"b\":3}\nTool result:\n5"},
+		},
+		{
+			name:       "answer straight away",
+			responses:  []string{"It's 5."},
+			wantAnswer: "It's 5.",
+		},
+		{
+			name:        "unknown tool",
+			responses:   []string{`{"tool": "multiply", "arguments": {}}`, `{"answer": "can't multiply"}`},
+			wantAnswer:  "can't multiply",
+			wantResults: []string{"Tool call: multiply {}\nTool result:\nerror: "},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := testutil.NewFakeGenAIClient(tt.responses...)
+			workload := &pb.Workload{Id: "s1", Models: []string{"m1"}, Payload: []byte("what is 2 + 3?")}
+
+			agent := &MCPAgent{Transport: newFakeMCPServer(t)}
+			if err := agent.DoWork(context.Background(), workload, client); err != nil {
+				t.Fatalf("DoWork: %v", err)
+			}
+
+			calls := client.Calls()
+			if len(calls) != len(tt.responses) {
+				t.Fatalf("%d model calls, want %d", len(calls), len(tt.responses))
+			}
+			if !strings.Contains(calls[0].SystemPrompt, "- add: Add two numbers.") {
+				t.Errorf("system prompt doesn't list the add tool:\n%s", calls[0].SystemPrompt)
+			}
+			for i, want := range tt.wantResults {
+				if !strings.Contains(calls[i+1].Input, want) {
+					t.Errorf("call %d input = %q, want it to contain %q", i+1, calls[i+1].Input, want)
+				}
+			}
+			if got, want := string(workload.Payload), "what is 2 + 3?\n\n---\n\n"+tt.wantAnswer; got != want {
+				t.Errorf("payload = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestMCPAgentMaxSteps(t *testing.T) {
+	call := `{"tool": "add", "arguments": {"a": 1, "b": 1}}`
+	client := testutil.NewFakeGenAIClient(call, call, call)
+	workload := &pb.Workload{Id: "s1", Models: []string{"m1"}, Payload: []byte("loop"), Config: `{"max_steps": 2}`}
+
+	err := (&MCPAgent{Transport: newFakeMCPServer(t)}).DoWork(context.Background(), workload, client)
+	if err == nil || !strings.Contains(err.Error(), "no answer after 2 tool calls") {
+		t.Errorf("DoWork = %v, want it to give up after 2 tool calls", err)
+	}
+}
@@ -0,0 +1,118 @@
+package agents
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/nieveai/d-agents/internal/database"
+	m "github.com/nieveai/d-agents/internal/models"
+	pb "github.com/nieveai/d-agents/proto"
+)
+
+// NewsResult defines the structure for the JSON output from the GenAI client.
+type NewsResult struct {
+	Headline string `json:"headline"`
+	URL      string `json:"url"`
+}
+
+// NewsMonitorAgent searches recent news on the topic in workload.Name and
+// reports the headlines it hasn't reported before, so scheduled runs only
+// show what changed. It needs a Gemini model with web search enabled.
+type NewsMonitorAgent struct {
+	Db *database.NewsDB
+}
+
+func init() {
+	m.RegisterAgent("NewsMonitorAgent", func() (m.AgentInterface, error) {
+		return NewNewsMonitorAgent()
+	})
+}
+
+func NewNewsMonitorAgent() (*NewsMonitorAgent, error) {
+	db, err := database.NewNewsDB()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get news db: %w", err)
+	}
+	return &NewsMonitorAgent{Db: db}, nil
+}
+
+const newsSystemPromptTemplate = `you are a news researcher. search the web for the most recent news about "%s" and list the headlines with the URL of the article. only include articles you actually found. the output should be a JSON array. for example: [ { "headline" : "headline of the article", "url": "https://example.com/article" }, ...]`
+
+func (a *NewsMonitorAgent) DoWork(ctx context.Context, workload *pb.Workload, genAIClient m.GenAIClient) error {
+	if workload == nil {
+		return fmt.Errorf("workload is nil")
+	}
+	if genAIClient == nil {
+		return fmt.Errorf("genAIClient is nil")
+	}
+	topic := strings.TrimSpace(workload.Name)
+	if topic == "" {
+		return fmt.Errorf("workload name (the news topic) is empty")
+	}
+
+	input := string(workload.Payload)
+	systemPrompt := fmt.Sprintf(newsSystemPromptTemplate, topic)
+	message := fmt.Sprintf("latest news about %s", topic)
+	if strings.TrimSpace(input) != "" {
+		message += "\n\n" + input
+	}
+
+	if workload.DryRun {
+		workload.Payload = []byte(dryRunPreview(input,
+			previewStep{"System prompt", systemPrompt},
+			previewStep{"User message", message},
+			previewStep{"News database", fmt.Sprintf("one news row (topic, headline, url, date) per headline whose URL wasn't seen for %q yet", topic)},
+		))
+		return nil
+	}
+
+	llmResponse, err := genAIClient.GenerateContentWithSystemPrompt(ctx, workload, message, systemPrompt)
+	if err != nil {
+		return fmt.Errorf("error generating content: %w", err)
+	}
+
+	jsonString := extractJSONArray(llmResponse)
+	if jsonString == "" {
+		return fmt.Errorf("%w: no JSON array found in the LLM response", m.ErrInvalidResponse)
+	}
+	var results []NewsResult
+	if err := json.Unmarshal([]byte(jsonString), &results); err != nil {
+		return fmt.Errorf("%w: failed to parse JSON from LLM response: %w", m.ErrInvalidResponse, err)
+	}
+
+	items := make([]database.NewsItem, len(results))
+	for i, result := range results {
+		items[i] = database.NewsItem{Headline: strings.TrimSpace(result.Headline), URL: result.URL}
+	}
+	added, err := a.Db.AddNewItems(topic, items, time.Now())
+	if err != nil {
+		return err
+	}
+
+	report := formatNews(topic, added)
+	markdown := report
+	if strings.TrimSpace(input) != "" {
+		markdown = input + transcriptSeparator + report
+	}
+	FormatResult(workload, input, report, markdown, map[string]any{"topic": topic, "headlines": added})
+	return nil
+}
+
+func formatNews(topic string, items []database.NewsItem) string {
+	if len(items) == 0 {
+		return fmt.Sprintf("_No new headlines about %s since the last run._", topic)
+	}
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "## New on %s\n\n", topic)
+	for _, item := range items {
+		headline := item.Headline
+		if headline == "" {
+			headline = item.URL
+		}
+		fmt.Fprintf(&builder, "- [%s](%s)\n", headline, item.URL)
+	}
+	return builder.String()
+}
@@ -0,0 +1,65 @@
+package agents
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	m "github.com/nieveai/d-agents/internal/models"
+	"github.com/nieveai/d-agents/internal/testutil"
+	pb "github.com/nieveai/d-agents/proto"
+)
+
+func TestNewsMonitorAgentReportsOnlyNewItems(t *testing.T) {
+	t.Chdir(t.TempDir())
+	agent, err := NewNewsMonitorAgent()
+	if err != nil {
+		t.Fatalf("NewNewsMonitorAgent: %v", err)
+	}
+	t.Cleanup(func() { agent.Db.Close() })
+	client := testutil.NewFakeGenAIClient(
+		`[{"headline": "Chip sales up", "url": "https://news.test/1"}, {"headline": "New fab opens", "url": "https://news.test/2"}]`,
+		"Here you go:\n"+`[{"headline": "New fab opens", "url": "https://news.test/2"}, {"headline": "Export rules change", "url": "https://news.test/3"}]`,
+		`[{"headline": "New fab opens", "url": "https://news.test/2"}]`,
+	)
+
+	run := func() string {
+		t.Helper()
+		workload := &pb.Workload{Id: "s1", Name: "chips", Models: []string{"m1"}}
+		if err := agent.DoWork(context.Background(), workload, client); err != nil {
+			t.Fatalf("DoWork: %v", err)
+		}
+		return string(workload.Payload)
+	}
+
+	if first := run(); !strings.Contains(first, "news.test/1") || !strings.Contains(first, "news.test/2") {
+		t.Errorf("first run = %q, want both headlines", first)
+	}
+	second := run()
+	if !strings.Contains(second, "[Export rules change](https://news.test/3)") || strings.Contains(second, "news.test/2") {
+		t.Errorf("second run = %q, want only the new headline", second)
+	}
+	if third := run(); !strings.Contains(third, "No new headlines about chips") {
+		t.Errorf("third run = %q, want nothing new", third)
+	}
+	if call, _ := client.LastCall(); !strings.Contains(call.SystemPrompt, `news about "chips"`) {
+		t.Errorf("system prompt = %q, want the topic", call.SystemPrompt)
+	}
+}
+
+func TestNewsMonitorAgentInvalidResponse(t *testing.T) {
+	t.Chdir(t.TempDir())
+	agent, err := NewNewsMonitorAgent()
+	if err != nil {
+		t.Fatalf("NewNewsMonitorAgent: %v", err)
+	}
+	t.Cleanup(func() { agent.Db.Close() })
+
+	for _, answer := range []string{"Sorry, I found no news.", `[{"headline": "Chip sales up", "url": 42}]`} {
+		workload := &pb.Workload{Id: "s1", Name: "chips", Models: []string{"m1"}}
+		if err := agent.DoWork(context.Background(), workload, testutil.NewFakeGenAIClient(answer)); !errors.Is(err, m.ErrInvalidResponse) {
+			t.Errorf("DoWork with the answer %q = %v, want ErrInvalidResponse", answer, err)
+		}
+	}
+}
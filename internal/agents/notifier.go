@@ -0,0 +1,279 @@
+package agents
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/smtp"
+	neturl "net/url"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// Notifier sends a message on one notification channel.
+type Notifier interface {
+	Notify(ctx context.Context, subject, body string) error
+	Name() string
+}
+
+// NotificationConfig is the workload config understood by
+// ShoppingNotificationAgent. Every channel that is configured gets the alert.
+type NotificationConfig struct {
+	SMTPHost     string   `json:"smtp_host"`
+	SMTPPort     int      `json:"smtp_port"`
+	SMTPUsername string   `json:"smtp_username"`
+	SMTPPassword string   `json:"smtp_password"`
+	EmailFrom    string   `json:"email_from"`
+	EmailTo      []string `json:"email_to"`
+	SlackWebhook string   `json:"slack_webhook"`
+	WebhookURL   string   `json:"webhook_url"`
+	// TelegramChatID is a chat's numeric ID or a channel's @username, as a
+	// string.
+	TelegramBotToken string `json:"telegram_bot_token"`
+	TelegramChatID   string `json:"telegram_chat_id"`
+	// DryRun logs what would be sent instead of sending it.
+	DryRun bool `json:"dry_run"`
+}
+
+func parseNotificationConfig(config string) (NotificationConfig, error) {
+	var cfg NotificationConfig
+	if config == "" {
+		return cfg, nil
+	}
+	if err := json.Unmarshal([]byte(config), &cfg); err != nil {
+		return cfg, fmt.Errorf("invalid notification config: %w", err)
+	}
+	return cfg, nil
+}
+
+// Notifiers returns a notifier for each configured channel.
+func (c NotificationConfig) Notifiers() []Notifier {
+	var notifiers []Notifier
+	if c.SMTPHost != "" && len(c.EmailTo) > 0 {
+		port := c.SMTPPort
+		if port == 0 {
+			port = 587
+		}
+		notifiers = append(notifiers, &EmailNotifier{
+			Host:     c.SMTPHost,
+			Port:     port,
+			Username: c.SMTPUsername,
+			Password: c.SMTPPassword,
+			From:     c.EmailFrom,
+			To:       c.EmailTo,
+		})
+	}
+	if c.SlackWebhook != "" {
+		notifiers = append(notifiers, &SlackNotifier{WebhookURL: c.SlackWebhook})
+	}
+	if c.WebhookURL != "" {
+		notifiers = append(notifiers, &WebhookNotifier{URL: c.WebhookURL})
+	}
+	if c.TelegramBotToken != "" && c.TelegramChatID != "" {
+		notifiers = append(notifiers, &TelegramNotifier{BotToken: c.TelegramBotToken, ChatID: c.TelegramChatID})
+	}
+	return notifiers
+}
+
+// EmailNotifier sends notifications over SMTP.
+type EmailNotifier struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+	To       []string
+}
+
+func (n *EmailNotifier) Name() string { return "email" }
+
+func (n *EmailNotifier) Notify(ctx context.Context, subject, body string) error {
+	var auth smtp.Auth
+	if n.Username != "" {
+		auth = smtp.PlainAuth("", n.Username, n.Password, n.Host)
+	}
+	from := n.From
+	if from == "" {
+		from = n.Username
+	}
+	msg := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\n\r\n%s\r\n", from, strings.Join(n.To, ", "), subject, body)
+	addr := fmt.Sprintf("%s:%d", n.Host, n.Port)
+	if err := smtp.SendMail(addr, auth, from, n.To, []byte(msg)); err != nil {
+		return fmt.Errorf("failed to send email: %w", err)
+	}
+	return nil
+}
+
+// SlackNotifier posts notifications to a Slack incoming webhook.
+type SlackNotifier struct {
+	WebhookURL string
+	Client     *http.Client
+}
+
+func (n *SlackNotifier) Name() string { return "slack" }
+
+func (n *SlackNotifier) Notify(ctx context.Context, subject, body string) error {
+	payload := map[string]string{"text": fmt.Sprintf("*%s*\n%s", subject, body)}
+	return postJSON(ctx, n.Client, n.WebhookURL, payload)
+}
+
+// WebhookNotifier posts notifications as JSON to an arbitrary URL.
+type WebhookNotifier struct {
+	URL    string
+	Client *http.Client
+}
+
+// WebhookPayload is the JSON body WebhookNotifier sends.
+type WebhookPayload struct {
+	Subject string `json:"subject"`
+	Body    string `json:"body"`
+}
+
+func (n *WebhookNotifier) Name() string { return "webhook" }
+
+func (n *WebhookNotifier) Notify(ctx context.Context, subject, body string) error {
+	return postJSON(ctx, n.Client, n.URL, WebhookPayload{Subject: subject, Body: body})
+}
+
+// telegramAPIURL is where the Telegram Bot API is served.
+const telegramAPIURL = "https://api.telegram.org"
+
+// telegramMaxMessage is the most characters Telegram takes in one message.
+const telegramMaxMessage = 4096
+
+// TelegramNotifier sends notifications to a chat through a Telegram bot.
+type TelegramNotifier struct {
+	BotToken string
+	ChatID   string
+	// APIURL replaces the Bot API's URL, e.g. for a local Bot API server.
+	APIURL string
+	Client *http.Client
+}
+
+func (n *TelegramNotifier) Name() string { return "telegram" }
+
+// Notify sends the notification as plain text, split into several messages
+// when it is over Telegram's length limit.
+func (n *TelegramNotifier) Notify(ctx context.Context, subject, body string) error {
+	client := n.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	apiURL := n.APIURL
+	if apiURL == "" {
+		apiURL = telegramAPIURL
+	}
+	url := fmt.Sprintf("%s/bot%s/sendMessage", strings.TrimRight(apiURL, "/"), n.BotToken)
+
+	parts := splitMessage(subject+"\n\n"+body, telegramMaxMessage)
+	for i, text := range parts {
+		data, err := json.Marshal(map[string]string{"chat_id": n.ChatID, "text": text})
+		if err != nil {
+			return err
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+		if err != nil {
+			return fmt.Errorf("invalid Telegram API URL %q", apiURL)
+		}
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := client.Do(req)
+		if err != nil {
+			// The URL holds the bot token, so it is left out of the error.
+			var urlErr *neturl.Error
+			if errors.As(err, &urlErr) {
+				err = urlErr.Err
+			}
+			return fmt.Errorf("failed to send Telegram message %d of %d: %w", i+1, len(parts), err)
+		}
+		var result struct {
+			OK          bool   `json:"ok"`
+			Description string `json:"description"`
+		}
+		decodeErr := json.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if resp.StatusCode >= 300 || decodeErr != nil || !result.OK {
+			return fmt.Errorf("Telegram refused message %d of %d: %s %s", i+1, len(parts), resp.Status, result.Description)
+		}
+	}
+	return nil
+}
+
+// splitMessage cuts text into parts of at most limit UTF-16 code units, the
+// unit Telegram counts in, between lines where it can.
+func splitMessage(text string, limit int) []string {
+	var parts []string
+	var part strings.Builder
+	size := 0
+	flush := func() {
+		if s := strings.TrimSpace(part.String()); s != "" {
+			parts = append(parts, s)
+		}
+		part.Reset()
+		size = 0
+	}
+	for _, line := range strings.SplitAfter(text, "\n") {
+		if size+utf16Len(line) > limit {
+			flush()
+		}
+		// A line too long by itself is cut wherever the limit falls.
+		for _, r := range line {
+			if size+utf16.RuneLen(r) > limit {
+				flush()
+			}
+			part.WriteRune(r)
+			size += utf16.RuneLen(r)
+		}
+	}
+	flush()
+	return parts
+}
+
+func utf16Len(s string) int {
+	n := 0
+	for _, r := range s {
+		n += utf16.RuneLen(r)
+	}
+	return n
+}
+
+func postJSON(ctx context.Context, client *http.Client, url string, payload interface{}) error {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to post to %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("post to %s returned %s", url, resp.Status)
+	}
+	return nil
+}
+
+// notifyAll sends the message on every channel. A failing channel doesn't stop
+// the others; the failures are returned keyed by channel name.
+func notifyAll(ctx context.Context, notifiers []Notifier, subject, body string) map[string]error {
+	failures := make(map[string]error)
+	for _, n := range notifiers {
+		if err := n.Notify(ctx, subject, body); err != nil {
+			failures[n.Name()] = err
+		}
+	}
+	return failures
+}
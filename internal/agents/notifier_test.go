@@ -0,0 +1,215 @@
+package agents
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// recordingServer answers every request with status and keeps the JSON bodies.
+func recordingServer(t *testing.T, status int) (*httptest.Server, *[]map[string]any) {
+	t.Helper()
+	var bodies []map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("got %s with Content-Type %q, want a JSON POST", r.Method, r.Header.Get("Content-Type"))
+		}
+		data, _ := io.ReadAll(r.Body)
+		var body map[string]any
+		if err := json.Unmarshal(data, &body); err != nil {
+			t.Errorf("body %q isn't JSON: %v", data, err)
+		}
+		bodies = append(bodies, body)
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+	return server, &bodies
+}
+
+func TestWebhookNotifier(t *testing.T) {
+	server, bodies := recordingServer(t, http.StatusOK)
+	n := &WebhookNotifier{URL: server.URL}
+	if err := n.Notify(context.Background(), "Price drop", "Widget is now $5"); err != nil {
+		t.Fatalf("Notify: %v", err)
+	}
+	if len(*bodies) != 1 {
+		t.Fatalf("got %d requests, want 1", len(*bodies))
+	}
+	if got := (*bodies)[0]; got["subject"] != "Price drop" || got["body"] != "Widget is now $5" {
+		t.Errorf("payload = %v", got)
+	}
+}
+
+func TestSlackNotifier(t *testing.T) {
+	server, bodies := recordingServer(t, http.StatusOK)
+	n := &SlackNotifier{WebhookURL: server.URL}
+	if err := n.Notify(context.Background(), "Price drop", "Widget is now $5"); err != nil {
+		t.Fatalf("Notify: %v", err)
+	}
+	if got := (*bodies)[0]["text"]; got != "*Price drop*\nWidget is now $5" {
+		t.Errorf("text = %q", got)
+	}
+}
+
+func TestNotifyAllIsolatesFailures(t *testing.T) {
+	failing, _ := recordingServer(t, http.StatusInternalServerError)
+	working, bodies := recordingServer(t, http.StatusOK)
+	notifiers := []Notifier{
+		&SlackNotifier{WebhookURL: failing.URL},
+		&WebhookNotifier{URL: working.URL},
+	}
+
+	failures := notifyAll(context.Background(), notifiers, "subject", "body")
+	if len(failures) != 1 || failures["slack"] == nil {
+		t.Errorf("failures = %v, want only slack", failures)
+	}
+	if len(*bodies) != 1 {
+		t.Errorf("the webhook got %d requests after slack failed, want 1", len(*bodies))
+	}
+}
+
+func TestNotificationConfigNotifiers(t *testing.T) {
+	tests := []struct {
+		config string
+		want   []string
+	}{
+		{"", nil},
+		{`{"slack_webhook": "https://hooks.slack.test/x"}`, []string{"slack"}},
+		{`{"smtp_host": "smtp.test", "email_to": ["a@b.test"], "webhook_url": "https://hook.test"}`, []string{"email", "webhook"}},
+		// Email needs recipients.
+		{`{"smtp_host": "smtp.test"}`, nil},
+		{`{"telegram_bot_token": "123:abc", "telegram_chat_id": "42", "slack_webhook": "https://hooks.slack.test/x"}`, []string{"slack", "telegram"}},
+		// Telegram needs a chat.
+		{`{"telegram_bot_token": "123:abc"}`, nil},
+	}
+	for _, tt := range tests {
+		cfg, err := parseNotificationConfig(tt.config)
+		if err != nil {
+			t.Fatalf("parseNotificationConfig(%q): %v", tt.config, err)
+		}
+		var names []string
+		for _, n := range cfg.Notifiers() {
+			names = append(names, n.Name())
+		}
+		if !slices.Equal(names, tt.want) {
+			t.Errorf("%q: notifiers = %v, want %v", tt.config, names, tt.want)
+		}
+	}
+	cfg, _ := parseNotificationConfig(`{"smtp_host": "smtp.test", "email_to": ["a@b.test"]}`)
+	if email := cfg.Notifiers()[0].(*EmailNotifier); email.Port != 587 {
+		t.Errorf("default SMTP port = %d, want 587", email.Port)
+	}
+	if _, err := parseNotificationConfig("{"); err == nil {
+		t.Error("parseNotificationConfig accepted invalid JSON")
+	}
+}
+
+// fakeTelegram is a Bot API server answering sendMessage with ok, or with
+// the error description when it is set.
+type fakeTelegram struct {
+	*httptest.Server
+	paths    []string
+	messages []map[string]string
+	refuse   string
+}
+
+func newFakeTelegram(t *testing.T) *fakeTelegram {
+	t.Helper()
+	f := &fakeTelegram{}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.paths = append(f.paths, r.Method+" "+r.URL.Path)
+		var msg map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+			t.Errorf("body isn't JSON: %v", err)
+		}
+		f.messages = append(f.messages, msg)
+		if f.refuse != "" {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, `{"ok": false, "error_code": 400, "description": %q}`, f.refuse)
+			return
+		}
+		fmt.Fprint(w, `{"ok": true, "result": {"message_id": 1}}`)
+	}))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func TestTelegramNotifier(t *testing.T) {
+	f := newFakeTelegram(t)
+	n := &TelegramNotifier{BotToken: "123:secret", ChatID: "@deals", APIURL: f.URL + "/"}
+	if err := n.Notify(context.Background(), "Price drop", "Widget is now $5"); err != nil {
+		t.Fatalf("Notify: %v", err)
+	}
+	if !slices.Equal(f.paths, []string{"POST /bot123:secret/sendMessage"}) {
+		t.Errorf("requests = %v, want one sendMessage", f.paths)
+	}
+	if msg := f.messages[0]; msg["chat_id"] != "@deals" || msg["text"] != "Price drop\n\nWidget is now $5" {
+		t.Errorf("message = %v", msg)
+	}
+
+	// Long alerts are split between lines.
+	f.messages = nil
+	line := strings.Repeat("é", 99) + "\n"
+	body := strings.Repeat(line, 100)
+	if err := n.Notify(context.Background(), "Price drops", body); err != nil {
+		t.Fatalf("Notify: %v", err)
+	}
+	if len(f.messages) != 3 {
+		t.Fatalf("sent %d messages, want 3", len(f.messages))
+	}
+	var sent strings.Builder
+	for i, msg := range f.messages {
+		if n := utf16Len(msg["text"]); n > telegramMaxMessage {
+			t.Errorf("message %d is %d long, over Telegram's limit", i+1, n)
+		}
+		if i > 0 && !strings.HasPrefix(msg["text"], "é") {
+			t.Errorf("message %d = %.20q..., want it to start a line", i+1, msg["text"])
+		}
+		sent.WriteString(msg["text"] + "\n")
+	}
+	if strings.Count(sent.String(), "é") != 99*100 {
+		t.Error("the split messages lost text")
+	}
+}
+
+func TestTelegramNotifierErrors(t *testing.T) {
+	f := newFakeTelegram(t)
+	f.refuse = "Bad Request: chat not found"
+	n := &TelegramNotifier{BotToken: "123:secret", ChatID: "42", APIURL: f.URL}
+	err := n.Notify(context.Background(), "Price drop", "Widget is now $5")
+	if err == nil || !strings.Contains(err.Error(), "chat not found") {
+		t.Errorf("Notify to a missing chat = %v, want Telegram's description", err)
+	}
+
+	f.Close()
+	err = n.Notify(context.Background(), "Price drop", "Widget is now $5")
+	if err == nil || strings.Contains(err.Error(), "secret") {
+		t.Errorf("Notify to a closed server = %v, want an error without the token", err)
+	}
+}
+
+func TestSplitMessage(t *testing.T) {
+	tests := []struct {
+		text  string
+		limit int
+		want  []string
+	}{
+		{"short", 10, []string{"short"}},
+		{"line one\nline two\nline three", 18, []string{"line one\nline two", "line three"}},
+		{"abcdefghij", 4, []string{"abcd", "efgh", "ij"}},
+		// An emoji is two UTF-16 code units.
+		{"😀😀😀", 4, []string{"😀😀", "😀"}},
+		{"", 10, nil},
+	}
+	for _, tt := range tests {
+		if got := splitMessage(tt.text, tt.limit); !slices.Equal(got, tt.want) {
+			t.Errorf("splitMessage(%q, %d) = %q, want %q", tt.text, tt.limit, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package agents
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+
+	pb "github.com/nieveai/d-agents/proto"
+)
+
+// Output formats a workload can ask for with "output_format" in its config.
+const (
+	// OutputMarkdown is the agent's own rendering, usually the input followed
+	// by the result. It is the default.
+	OutputMarkdown = "markdown"
+	// OutputJSON is a Result envelope.
+	OutputJSON = "json"
+	// OutputPlain is only the result text.
+	OutputPlain = "plain"
+)
+
+// Result is the payload of a workload with the json output format.
+type Result struct {
+	Input    string         `json:"input"`
+	Output   string         `json:"output"`
+	Metadata map[string]any `json:"metadata,omitempty"`
+}
+
+// outputConfig is the part of the workload config every agent understands.
+type outputConfig struct {
+	OutputFormat string `json:"output_format"`
+}
+
+// ParseOutputFormat returns the output format config asks for, markdown when
+// it doesn't say.
+func ParseOutputFormat(config string) (string, error) {
+	if config == "" {
+		return OutputMarkdown, nil
+	}
+	var cfg outputConfig
+	if err := json.Unmarshal([]byte(config), &cfg); err != nil {
+		return OutputMarkdown, fmt.Errorf("invalid config: %w", err)
+	}
+	switch cfg.OutputFormat {
+	case "":
+		return OutputMarkdown, nil
+	case OutputMarkdown, OutputJSON, OutputPlain:
+		return cfg.OutputFormat, nil
+	}
+	return OutputMarkdown, fmt.Errorf("unknown output format %q, want markdown, json or plain", cfg.OutputFormat)
+}
+
+// WithOutputFormat returns config with its output format set to format.
+func WithOutputFormat(config, format string) (string, error) {
+	fields := make(map[string]any)
+	if config != "" {
+		if err := json.Unmarshal([]byte(config), &fields); err != nil {
+			return "", fmt.Errorf("config must be a JSON object: %w", err)
+		}
+	}
+	fields["output_format"] = format
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return "", err
+	}
+	if _, err := ParseOutputFormat(string(data)); err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// FormatResult sets the payload of workload to an agent's result in the
+// output format its config asks for. markdown is what the agent shows by
+// default, output the bare result and metadata anything structured worth
+// passing on in the json format. Stages before the last of a pipeline always
+// get markdown, since it is what the next agent reads.
+func FormatResult(workload *pb.Workload, input, output, markdown string, metadata map[string]any) {
+	format, err := ParseOutputFormat(workload.Config)
+	if err != nil {
+		log.Printf("Ignoring output format of workload %s: %v", workload.Id, err)
+	}
+	if !lastStage(workload) {
+		format = OutputMarkdown
+	}
+
+	switch format {
+	case OutputPlain:
+		workload.Payload = []byte(output)
+	case OutputJSON:
+		if metadata == nil {
+			metadata = make(map[string]any)
+		}
+		metadata["agent_type"] = workload.AgentType
+		metadata["models"] = workload.Models
+		data, err := json.Marshal(Result{Input: input, Output: output, Metadata: metadata})
+		if err != nil {
+			log.Printf("Error encoding result of workload %s, falling back to markdown: %v", workload.Id, err)
+			workload.Payload = []byte(markdown)
+			return
+		}
+		workload.Payload = data
+	default:
+		workload.Payload = []byte(markdown)
+	}
+}
+
+// lastStage reports whether workload isn't in a pipeline or is running its
+// last stage. Stages are named "i/n agent" by the worker.
+func lastStage(workload *pb.Workload) bool {
+	var i, n int
+	if _, err := fmt.Sscanf(workload.Stage, "%d/%d", &i, &n); err != nil {
+		return true
+	}
+	return i >= n
+}
@@ -0,0 +1,131 @@
+package agents
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	m "github.com/nieveai/d-agents/internal/models"
+	"github.com/nieveai/d-agents/internal/testutil"
+	pb "github.com/nieveai/d-agents/proto"
+)
+
+func TestParseOutputFormat(t *testing.T) {
+	tests := []struct {
+		config  string
+		want    string
+		wantErr bool
+	}{
+		{"", OutputMarkdown, false},
+		{`{}`, OutputMarkdown, false},
+		{`{"output_format": "json"}`, OutputJSON, false},
+		{`{"output_format": "plain", "target": "fr"}`, OutputPlain, false},
+		{`{"output_format": "markdown"}`, OutputMarkdown, false},
+		{`{"output_format": "xml"}`, OutputMarkdown, true},
+		{`{"output_format": `, OutputMarkdown, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseOutputFormat(tt.config)
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("ParseOutputFormat(%q) = %q, %v, want %q, error %v", tt.config, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestWithOutputFormat(t *testing.T) {
+	config, err := WithOutputFormat(`{"target": "fr"}`, OutputJSON)
+	if err != nil {
+		t.Fatalf("WithOutputFormat: %v", err)
+	}
+	var fields map[string]string
+	json.Unmarshal([]byte(config), &fields)
+	if fields["target"] != "fr" || fields["output_format"] != "json" {
+		t.Errorf("WithOutputFormat = %s, want the target kept and json set", config)
+	}
+	if _, err := WithOutputFormat(`[1]`, OutputJSON); err == nil {
+		t.Error("WithOutputFormat of a config that isn't an object succeeded")
+	}
+	if _, err := WithOutputFormat("", "xml"); err == nil {
+		t.Error("WithOutputFormat with an unknown format succeeded")
+	}
+}
+
+func TestOutputFormats(t *testing.T) {
+	tests := []struct {
+		name   string
+		agent  m.AgentInterface
+		config string
+		answer string
+		// markdown is the payload in the markdown format, metadata a key
+		// the json format has.
+		markdown string
+		metadata string
+	}{
+		{
+			name:     "ChatAgent",
+			agent:    &ChatAgent{},
+			answer:   "Bonjour !",
+			markdown: "say hello in French" + transcriptSeparator + "Bonjour !",
+		},
+		{
+			name:     "TranslationAgent",
+			agent:    &TranslationAgent{},
+			config:   `{"target": "fr"}`,
+			answer:   "Bonjour !",
+			markdown: "say hello in French" + transcriptSeparator + "Bonjour !",
+			metadata: "target",
+		},
+	}
+	for _, a := range tests {
+		for _, format := range []string{"", OutputMarkdown, OutputJSON, OutputPlain} {
+			t.Run(a.name+"/"+format, func(t *testing.T) {
+				config := a.config
+				if format != "" {
+					config, _ = WithOutputFormat(config, format)
+				}
+				workload := &pb.Workload{Id: "s1", AgentType: a.name, Models: []string{"m1"}, Payload: []byte("say hello in French"), Config: config}
+				if err := a.agent.DoWork(context.Background(), workload, testutil.NewFakeGenAIClient(a.answer)); err != nil {
+					t.Fatalf("DoWork: %v", err)
+				}
+
+				payload := string(workload.Payload)
+				switch format {
+				case "", OutputMarkdown:
+					if payload != a.markdown {
+						t.Errorf("payload = %q, want %q", payload, a.markdown)
+					}
+				case OutputPlain:
+					if payload != a.answer {
+						t.Errorf("payload = %q, want only the answer %q", payload, a.answer)
+					}
+				case OutputJSON:
+					var result Result
+					if err := json.Unmarshal(workload.Payload, &result); err != nil {
+						t.Fatalf("payload isn't a Result: %v\n%s", err, payload)
+					}
+					if result.Input != "say hello in French" || result.Output != a.answer {
+						t.Errorf("result = %+v, want the input and answer", result)
+					}
+					if result.Metadata["agent_type"] != a.name {
+						t.Errorf("metadata agent_type = %v, want %s", result.Metadata["agent_type"], a.name)
+					}
+					if _, ok := result.Metadata[a.metadata]; a.metadata != "" && !ok {
+						t.Errorf("metadata = %v, want %q in it", result.Metadata, a.metadata)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestFormatResultInAPipeline(t *testing.T) {
+	config, _ := WithOutputFormat("", OutputJSON)
+	for stage, wantJSON := range map[string]bool{"": true, "1/2 ChatAgent": false, "2/2 ChatAgent": true} {
+		workload := &pb.Workload{Id: "s1", Config: config, Stage: stage}
+		FormatResult(workload, "in", "out", "in\n\nout", nil)
+		if got := strings.HasPrefix(string(workload.Payload), "{"); got != wantJSON {
+			t.Errorf("stage %q: payload = %q, want json %v", stage, workload.Payload, wantJSON)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package agents
+
+import (
+	pb "github.com/nieveai/d-agents/proto"
+)
+
+// progress is embedded by agents that work in several steps, to report how
+// far along they are.
+type progress struct {
+	onProgress func(id string, pct int32)
+}
+
+// SetOnProgress implements m.ProgressNotifier.
+func (p *progress) SetOnProgress(fn func(id string, pct int32)) {
+	p.onProgress = fn
+}
+
+// reportProgress passes pct on to the callback, if there is one.
+func (p *progress) reportProgress(workload *pb.Workload, pct int32) {
+	if p.onProgress != nil {
+		p.onProgress(workload.Id, pct)
+	}
+}
+
+// stepProgress is the progress after done of total steps that together make
+// up the range from start to end.
+func stepProgress(start, end int32, done, total int) int32 {
+	if total <= 0 {
+		return end
+	}
+	return start + (end-start)*int32(done)/int32(total)
+}
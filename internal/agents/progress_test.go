@@ -0,0 +1,58 @@
+package agents
+
+import (
+	"context"
+	"testing"
+
+	"github.com/nieveai/d-agents/internal/database"
+	"github.com/nieveai/d-agents/internal/testutil"
+	pb "github.com/nieveai/d-agents/proto"
+)
+
+func TestStepProgress(t *testing.T) {
+	tests := []struct {
+		start, end  int32
+		done, total int
+		want        int32
+	}{
+		{0, 100, 0, 4, 0},
+		{0, 100, 1, 4, 25},
+		{0, 100, 4, 4, 100},
+		{50, 100, 1, 2, 75},
+		{50, 100, 0, 0, 100},
+	}
+	for _, tt := range tests {
+		if got := stepProgress(tt.start, tt.end, tt.done, tt.total); got != tt.want {
+			t.Errorf("stepProgress(%d, %d, %d, %d) = %d, want %d", tt.start, tt.end, tt.done, tt.total, got, tt.want)
+		}
+	}
+}
+
+func TestCompanyRelationshipAgentProgress(t *testing.T) {
+	agent := NewCompanyRelationshipAgentWithStore(database.NewMemoryDatastore())
+	var reported []int32
+	agent.SetOnProgress(func(id string, pct int32) {
+		if id != "s1" {
+			t.Errorf("progress reported for %q, want s1", id)
+		}
+		reported = append(reported, pct)
+	})
+	answer := `[{"name": "TSMC", "relationship": "vendor"}, {"name": "AMD", "relationship": "competitor"}, {"name": "Dell", "relationship": "customer"}, {"name": "Micron", "relationship": "vendor"}]`
+	workload := &pb.Workload{Id: "s1", Name: "Nvidia", Models: []string{"m1"}, Payload: []byte("Nvidia")}
+	if err := agent.DoWork(context.Background(), workload, testutil.NewFakeGenAIClient(answer)); err != nil {
+		t.Fatalf("DoWork: %v", err)
+	}
+
+	// Once after the model answered and once per relationship stored.
+	if len(reported) != 5 {
+		t.Fatalf("progress reported %v, want 5 steps", reported)
+	}
+	for i := 1; i < len(reported); i++ {
+		if reported[i] <= reported[i-1] {
+			t.Errorf("progress went from %d to %d: %v", reported[i-1], reported[i], reported)
+		}
+	}
+	if last := reported[len(reported)-1]; last != 100 {
+		t.Errorf("last progress = %d, want 100", last)
+	}
+}
@@ -0,0 +1,32 @@
+package agents
+
+import (
+	"fmt"
+
+	m "github.com/nieveai/d-agents/internal/models"
+	pb "github.com/nieveai/d-agents/proto"
+)
+
+// customPrompt is embedded by agents whose system prompt can be replaced by
+// the one set on their Agent.
+type customPrompt struct {
+	systemPrompt string
+}
+
+// SetSystemPrompt implements m.SystemPromptSetter.
+func (c *customPrompt) SetSystemPrompt(prompt string) {
+	c.systemPrompt = prompt
+}
+
+// promptFor returns the custom system prompt rendered for workload, or def
+// when there is none.
+func (c *customPrompt) promptFor(workload *pb.Workload, def string) (string, error) {
+	if c.systemPrompt == "" {
+		return def, nil
+	}
+	prompt, err := m.RenderSystemPrompt(c.systemPrompt, workload)
+	if err != nil {
+		return "", fmt.Errorf("error in the agent's system prompt: %w", err)
+	}
+	return prompt, nil
+}
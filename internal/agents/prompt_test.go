@@ -0,0 +1,78 @@
+package agents
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/nieveai/d-agents/internal/database"
+	m "github.com/nieveai/d-agents/internal/models"
+	"github.com/nieveai/d-agents/internal/testutil"
+	pb "github.com/nieveai/d-agents/proto"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestCustomSystemPrompt(t *testing.T) {
+	shopping := func(t *testing.T) m.AgentInterface {
+		agent, _ := newTestShoppingAgent(t, map[string]string{"https://shop.test/hub": "<p>USB-C Hub $24.99</p>"})
+		return agent
+	}
+	companies := func(t *testing.T) m.AgentInterface {
+		return NewCompanyRelationshipAgentWithStore(database.NewMemoryDatastore())
+	}
+	hub := &pb.Workload{Id: "s1", Name: "USB-C Hub", AgentType: "ShoppingAgent", Models: []string{"m1"}, Payload: []byte("https://shop.test/hub")}
+	nvidia := &pb.Workload{Id: "s2", Name: "Nvidia", Description: "chips", AgentType: "CompanyRelationshipAgent", Models: []string{"m1"}, Payload: []byte("Nvidia")}
+	tests := []struct {
+		name     string
+		newAgent func(t *testing.T) m.AgentInterface
+		workload *pb.Workload
+		prompt   string
+		// want is the whole system prompt for a custom one, and part of the
+		// built-in one otherwise.
+		want string
+	}{
+		{"shopping default", shopping, hub, "", `"USB-C Hub"`},
+		{"shopping custom", shopping, hub, "list the prices of {{.Name}} ({{.AgentType}}) as JSON", "list the prices of USB-C Hub (ShoppingAgent) as JSON"},
+		{"companies default", companies, nvidia, "", companyRelationshipSystemPrompt},
+		{"companies custom", companies, nvidia, "only list the suppliers of {{.Name}}: {{.Description}}", "only list the suppliers of Nvidia: chips"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			agent := tt.newAgent(t)
+			if tt.prompt != "" {
+				agent.(m.SystemPromptSetter).SetSystemPrompt(tt.prompt)
+			}
+			client := testutil.NewFakeGenAIClient("[]")
+			workload := proto.Clone(tt.workload).(*pb.Workload)
+			if err := agent.DoWork(context.Background(), workload, client); err != nil {
+				t.Fatalf("DoWork: %v", err)
+			}
+			call, ok := client.LastCall()
+			if !ok {
+				t.Fatal("the model wasn't called")
+			}
+			if tt.prompt == "" {
+				if !strings.Contains(call.SystemPrompt, tt.want) {
+					t.Errorf("system prompt = %q, want the built-in one", call.SystemPrompt)
+				}
+			} else if call.SystemPrompt != tt.want {
+				t.Errorf("system prompt = %q, want %q", call.SystemPrompt, tt.want)
+			}
+		})
+	}
+}
+
+func TestCustomSystemPromptBrokenTemplate(t *testing.T) {
+	agent := NewCompanyRelationshipAgentWithStore(database.NewMemoryDatastore())
+	agent.SetSystemPrompt("suppliers of {{.Nme}}")
+	client := testutil.NewFakeGenAIClient("[]")
+	workload := &pb.Workload{Id: "s1", Name: "Nvidia", Models: []string{"m1"}, Payload: []byte("Nvidia")}
+
+	err := agent.DoWork(context.Background(), workload, client)
+	if err == nil || !strings.Contains(err.Error(), "system prompt") {
+		t.Errorf("DoWork with a broken prompt = %v, want an error about it", err)
+	}
+	if len(client.Calls()) != 0 {
+		t.Errorf("the model was called %d times with a broken prompt", len(client.Calls()))
+	}
+}
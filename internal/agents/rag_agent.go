@@ -0,0 +1,207 @@
+package agents
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	m "github.com/nieveai/d-agents/internal/models"
+	pb "github.com/nieveai/d-agents/proto"
+)
+
+// VectorStore holds document chunks and finds the ones closest to a query.
+type VectorStore interface {
+	AddChunks(chunks []*m.Chunk) error
+	SearchChunks(collection string, query []float32, k int) ([]*m.Chunk, error)
+}
+
+// vectorStore is used by RAGAgents created through the registry.
+var vectorStore VectorStore
+
+// SetVectorStore sets the store new RAGAgents retrieve chunks from.
+func SetVectorStore(store VectorStore) {
+	vectorStore = store
+}
+
+// RAGAgent answers the question in the payload using the chunks of a
+// collection that are most relevant to it.
+type RAGAgent struct {
+	Store VectorStore
+	progress
+}
+
+func init() {
+	m.RegisterAgent("RAGAgent", func() (m.AgentInterface, error) {
+		if vectorStore == nil {
+			return nil, errors.New("no vector store configured for RAGAgent")
+		}
+		return &RAGAgent{Store: vectorStore}, nil
+	})
+}
+
+// RAGConfig is the workload config understood by RAGAgent.
+type RAGConfig struct {
+	// EmbeddingModel is the model ID used to embed the question. It must be
+	// the one the collection was ingested with.
+	EmbeddingModel string `json:"embedding_model"`
+	Collection     string `json:"collection,omitempty"`
+	TopK           int    `json:"top_k,omitempty"`
+}
+
+const (
+	DefaultCollection = "default"
+	DefaultChunkSize  = 1000
+	defaultTopK       = 5
+)
+
+const ragSystemPrompt = `Answer the user's question using the context below. If the context doesn't contain the answer, say so instead of guessing. Cite the sources you used by their number, e.g. [2].
+
+Context:
+`
+
+func (a *RAGAgent) DoWork(ctx context.Context, workload *pb.Workload, genAIClient m.GenAIClient) error {
+	if workload == nil {
+		return fmt.Errorf("workload is nil")
+	}
+	if genAIClient == nil {
+		return fmt.Errorf("genAIClient is nil")
+	}
+	embedder, ok := genAIClient.(m.Embedder)
+	if !ok {
+		return fmt.Errorf("LLM client doesn't support embeddings")
+	}
+
+	var cfg RAGConfig
+	if workload.Config != "" {
+		if err := json.Unmarshal([]byte(workload.Config), &cfg); err != nil {
+			return fmt.Errorf("invalid RAGAgent config: %w", err)
+		}
+	}
+	if cfg.EmbeddingModel == "" {
+		return fmt.Errorf("RAGAgent config needs an embedding_model")
+	}
+	if cfg.Collection == "" {
+		cfg.Collection = DefaultCollection
+	}
+	if cfg.TopK <= 0 {
+		cfg.TopK = defaultTopK
+	}
+
+	question := strings.TrimSpace(string(workload.Payload))
+	if question == "" {
+		return fmt.Errorf("payload has no question")
+	}
+
+	if workload.DryRun {
+		workload.Payload = []byte(dryRunPreview(question,
+			previewStep{"Retrieval", fmt.Sprintf("embed the question with %s and take the top %d chunks of collection %s", cfg.EmbeddingModel, cfg.TopK, cfg.Collection)},
+			previewStep{"System prompt", ragSystemPrompt + "<retrieved chunks>"},
+			previewStep{"User message", question},
+		))
+		return nil
+	}
+
+	vectors, err := embedder.Embed(ctx, cfg.EmbeddingModel, []string{question})
+	if err != nil {
+		return fmt.Errorf("error embedding question: %w", err)
+	}
+	a.reportProgress(workload, 25)
+	chunks, err := a.Store.SearchChunks(cfg.Collection, vectors[0], cfg.TopK)
+	if err != nil {
+		return fmt.Errorf("error searching collection %s: %w", cfg.Collection, err)
+	}
+	if len(chunks) == 0 {
+		return fmt.Errorf("collection %s has no documents", cfg.Collection)
+	}
+	a.reportProgress(workload, 50)
+
+	answer, err := genAIClient.GenerateContentWithSystemPrompt(ctx, workload, question, ragSystemPrompt+formatContext(chunks))
+	if err != nil {
+		return fmt.Errorf("error generating content: %w", err)
+	}
+
+	sources := make([]string, len(chunks))
+	for i, chunk := range chunks {
+		sources[i] = chunk.Source
+	}
+	FormatResult(workload, question, answer, question+transcriptSeparator+answer, map[string]any{"collection": cfg.Collection, "sources": sources})
+	return nil
+}
+
+// formatContext numbers the chunks so the model can cite them.
+func formatContext(chunks []*m.Chunk) string {
+	var builder strings.Builder
+	for i, chunk := range chunks {
+		fmt.Fprintf(&builder, "[%d] (%s)\n%s\n\n", i+1, chunk.Source, chunk.Text)
+	}
+	return builder.String()
+}
+
+// IngestDocument splits text into chunks, embeds them with modelID and adds
+// them to collection. It returns the number of chunks stored.
+func IngestDocument(ctx context.Context, store VectorStore, embedder m.Embedder, modelID string, collection string, source string, text string, chunkSize int) (int, error) {
+	pieces := ChunkText(text, chunkSize)
+	if len(pieces) == 0 {
+		return 0, nil
+	}
+	vectors, err := embedder.Embed(ctx, modelID, pieces)
+	if err != nil {
+		return 0, fmt.Errorf("error embedding %s: %w", source, err)
+	}
+
+	chunks := make([]*m.Chunk, len(pieces))
+	for i, piece := range pieces {
+		chunks[i] = &m.Chunk{Collection: collection, Source: source, Text: piece, Embedding: vectors[i]}
+	}
+	if err := store.AddChunks(chunks); err != nil {
+		return 0, fmt.Errorf("error storing chunks of %s: %w", source, err)
+	}
+	return len(chunks), nil
+}
+
+// ChunkText splits text into pieces of at most size characters, keeping
+// paragraphs together where they fit. Paragraphs longer than size are split
+// on word boundaries.
+func ChunkText(text string, size int) []string {
+	if size <= 0 {
+		size = DefaultChunkSize
+	}
+	var chunks []string
+	var current strings.Builder
+	flush := func() {
+		if current.Len() > 0 {
+			chunks = append(chunks, current.String())
+			current.Reset()
+		}
+	}
+	add := func(piece string, sep string) {
+		if current.Len() > 0 && utf8.RuneCountInString(current.String())+len(sep)+utf8.RuneCountInString(piece) > size {
+			flush()
+		}
+		if current.Len() > 0 {
+			current.WriteString(sep)
+		}
+		current.WriteString(piece)
+	}
+
+	for _, paragraph := range strings.Split(text, "\n\n") {
+		paragraph = strings.TrimSpace(paragraph)
+		if paragraph == "" {
+			continue
+		}
+		if utf8.RuneCountInString(paragraph) <= size {
+			add(paragraph, "\n\n")
+			continue
+		}
+		flush()
+		for _, word := range strings.Fields(paragraph) {
+			add(word, " ")
+		}
+		flush()
+	}
+	flush()
+	return chunks
+}
@@ -0,0 +1,129 @@
+package agents
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/nieveai/d-agents/internal/database"
+	"github.com/nieveai/d-agents/internal/testutil"
+	pb "github.com/nieveai/d-agents/proto"
+)
+
+// embeddingClient is a fake client that also embeds, giving every text a
+// vector by the first of its words it has one for.
+type embeddingClient struct {
+	*testutil.FakeGenAIClient
+	vectors map[string][]float32
+	models  []string
+}
+
+func (c *embeddingClient) Embed(ctx context.Context, modelID string, texts []string) ([][]float32, error) {
+	c.models = append(c.models, modelID)
+	out := make([][]float32, len(texts))
+	for i, text := range texts {
+		out[i] = []float32{0, 0, 1}
+		for _, word := range strings.Fields(strings.ToLower(text)) {
+			if v, ok := c.vectors[strings.Trim(word, "?.,")]; ok {
+				out[i] = v
+				break
+			}
+		}
+	}
+	return out, nil
+}
+
+func newEmbeddingClient(answers ...string) *embeddingClient {
+	return &embeddingClient{
+		FakeGenAIClient: testutil.NewFakeGenAIClient(answers...),
+		vectors: map[string][]float32{
+			"cats":   {1, 0, 0},
+			"kitten": {0.9, 0.1, 0},
+			"dogs":   {0, 1, 0},
+		},
+	}
+}
+
+func TestRAGAgent(t *testing.T) {
+	store := database.NewMemoryDatastore()
+	client := newEmbeddingClient("Cats sleep a lot [1].")
+	for source, text := range map[string]string{
+		"cats.md":   "Cats sleep sixteen hours a day.",
+		"kitten.md": "Kitten care: feed them often.",
+		"dogs.md":   "Dogs need walks.",
+	} {
+		if _, err := IngestDocument(context.Background(), store, client, "emb", DefaultCollection, source, text, 0); err != nil {
+			t.Fatalf("IngestDocument: %v", err)
+		}
+	}
+
+	workload := &pb.Workload{Id: "s1", Models: []string{"m1"}, Payload: []byte("How long do cats sleep?"), Config: `{"embedding_model": "emb", "top_k": 2}`}
+	if err := (&RAGAgent{Store: store}).DoWork(context.Background(), workload, client); err != nil {
+		t.Fatalf("DoWork: %v", err)
+	}
+
+	call, _ := client.LastCall()
+	cats := strings.Index(call.SystemPrompt, "[1] (cats.md)")
+	kitten := strings.Index(call.SystemPrompt, "[2] (kitten.md)")
+	if cats < 0 || kitten < cats {
+		t.Errorf("system prompt doesn't list cats.md then kitten.md:\n%s", call.SystemPrompt)
+	}
+	if strings.Contains(call.SystemPrompt, "dogs.md") {
+		t.Errorf("system prompt has more than the top 2 chunks:\n%s", call.SystemPrompt)
+	}
+	if call.Input != "How long do cats sleep?" {
+		t.Errorf("user message = %q, want the question", call.Input)
+	}
+	if !strings.HasSuffix(string(workload.Payload), "Cats sleep a lot [1].") {
+		t.Errorf("payload = %q, want the answer", workload.Payload)
+	}
+	if slices.ContainsFunc(client.models, func(id string) bool { return id != "emb" }) {
+		t.Errorf("embedded with models %v, want only the configured one", client.models)
+	}
+}
+
+func TestRAGAgentErrors(t *testing.T) {
+	tests := []struct {
+		name, config, payload, want string
+	}{
+		{"no embedding model", `{}`, "question", "needs an embedding_model"},
+		{"no question", `{"embedding_model": "emb"}`, "  ", "no question"},
+		{"empty collection", `{"embedding_model": "emb", "collection": "empty"}`, "question", "has no documents"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			workload := &pb.Workload{Id: "s1", Models: []string{"m1"}, Payload: []byte(tt.payload), Config: tt.config}
+			err := (&RAGAgent{Store: database.NewMemoryDatastore()}).DoWork(context.Background(), workload, newEmbeddingClient())
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("DoWork = %v, want an error containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestChunkText(t *testing.T) {
+	long := strings.Repeat("word ", 30)
+	tests := []struct {
+		name string
+		text string
+		size int
+		want []string
+	}{
+		{"paragraphs kept together", "one\n\ntwo", 100, []string{"one\n\ntwo"}},
+		{"paragraphs split when too big", "one two\n\nthree four", 10, []string{"one two", "three four"}},
+		{"empty paragraphs skipped", "\n\n  \n\none", 100, []string{"one"}},
+		{"nothing", "", 100, nil},
+	}
+	for _, tt := range tests {
+		if got := ChunkText(tt.text, tt.size); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: ChunkText = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+	for _, chunk := range ChunkText(long, 22) {
+		if n := utf8.RuneCountInString(chunk); n > 22 || strings.HasPrefix(chunk, " ") {
+			t.Errorf("chunk %q of a long paragraph has %d characters, want words up to 22", chunk, n)
+		}
+	}
+}
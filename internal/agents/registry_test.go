@@ -0,0 +1,17 @@
+package agents
+
+import (
+	"testing"
+
+	m "github.com/nieveai/d-agents/internal/models"
+)
+
+func TestBuiltinAgentsAreRegistered(t *testing.T) {
+	// Some agents open their default databases in the working directory.
+	t.Chdir(t.TempDir())
+	for _, agentType := range []string{"ChatAgent", "ShoppingAgent", "NewsMonitorAgent", "ShoppingNotificationAgent"} {
+		if _, err := m.NewAgent(agentType); err != nil {
+			t.Errorf("NewAgent(%q): %v", agentType, err)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package agents
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	m "github.com/nieveai/d-agents/internal/models"
+	"github.com/nieveai/d-agents/internal/textutil"
+	pb "github.com/nieveai/d-agents/proto"
+)
+
+// SentimentResult is the JSON SentimentAnalysisAgent asks the model for.
+// Scores go from -1 (very negative) to 1 (very positive).
+type SentimentResult struct {
+	Label      string               `json:"label"`
+	Score      float64              `json:"score"`
+	Paragraphs []ParagraphSentiment `json:"paragraphs"`
+}
+
+type ParagraphSentiment struct {
+	Index int     `json:"index"`
+	Label string  `json:"label"`
+	Score float64 `json:"score"`
+}
+
+var sentimentLabels = map[string]bool{"positive": true, "negative": true, "neutral": true, "mixed": true}
+
+const sentimentSystemPrompt = `you are a sentiment analyst. the user message is a text split into numbered paragraphs. rate the sentiment of the whole text and of each paragraph. labels are "positive", "negative", "neutral" or "mixed"; scores go from -1 (very negative) to 1 (very positive). the output should be a JSON object, for example: { "label": "positive", "score": 0.6, "paragraphs": [ { "index": 1, "label": "positive", "score": 0.8 }, { "index": 2, "label": "neutral", "score": 0.1 } ] }`
+
+// sentimentRetryPrompt is used once when the first answer can't be parsed.
+const sentimentRetryPrompt = sentimentSystemPrompt + `
+
+your previous answer was rejected: %s. reply with the JSON object only, no prose and no code fences. include exactly one entry in "paragraphs" for each of the %d paragraphs.`
+
+type SentimentAnalysisAgent struct{}
+
+func init() {
+	m.RegisterAgent("SentimentAnalysisAgent", func() (m.AgentInterface, error) {
+		return &SentimentAnalysisAgent{}, nil
+	})
+}
+
+func (a *SentimentAnalysisAgent) DoWork(ctx context.Context, workload *pb.Workload, genAIClient m.GenAIClient) error {
+	if workload == nil {
+		return fmt.Errorf("workload is nil")
+	}
+	if genAIClient == nil {
+		return fmt.Errorf("genAIClient is nil")
+	}
+
+	input := string(workload.Payload)
+	paragraphs := splitParagraphs(input)
+	if len(paragraphs) == 0 {
+		return fmt.Errorf("payload has no text")
+	}
+	message := numberParagraphs(paragraphs)
+
+	if workload.DryRun {
+		workload.Payload = []byte(dryRunPreview(input,
+			previewStep{"System prompt", sentimentSystemPrompt},
+			previewStep{"User message", message},
+		))
+		return nil
+	}
+
+	llmResponse, err := genAIClient.GenerateContentWithSystemPrompt(ctx, workload, message, sentimentSystemPrompt)
+	if err != nil {
+		return fmt.Errorf("error generating content: %w", err)
+	}
+	result, err := parseSentiment(llmResponse, len(paragraphs))
+	if err != nil {
+		// Models sometimes wrap the JSON in prose or skip paragraphs, ask once more.
+		llmResponse, err = genAIClient.GenerateContentWithSystemPrompt(ctx, workload, message, fmt.Sprintf(sentimentRetryPrompt, err, len(paragraphs)))
+		if err != nil {
+			return fmt.Errorf("error generating content: %w", err)
+		}
+		result, err = parseSentiment(llmResponse, len(paragraphs))
+		if err != nil {
+			return fmt.Errorf("invalid sentiment from model: %w", err)
+		}
+	}
+
+	raw, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding sentiment: %w", err)
+	}
+	summary := formatSentiment(result, paragraphs)
+	markdown := fmt.Sprintf("%s%s%s\n```json\n%s\n```", input, transcriptSeparator, summary, raw)
+	FormatResult(workload, input, summary, markdown, map[string]any{"sentiment": result})
+	return nil
+}
+
+func splitParagraphs(s string) []string {
+	var paragraphs []string
+	for _, p := range strings.Split(s, "\n\n") {
+		if p = strings.TrimSpace(p); p != "" {
+			paragraphs = append(paragraphs, p)
+		}
+	}
+	return paragraphs
+}
+
+func numberParagraphs(paragraphs []string) string {
+	var builder strings.Builder
+	for i, p := range paragraphs {
+		fmt.Fprintf(&builder, "[%d] %s\n\n", i+1, p)
+	}
+	return strings.TrimSpace(builder.String())
+}
+
+// parseSentiment extracts and validates the model's JSON for a text of n paragraphs.
+func parseSentiment(response string, n int) (*SentimentResult, error) {
+	jsonString := extractJSONObject(response)
+	if jsonString == "" {
+		return nil, fmt.Errorf("%w: no JSON object in response", m.ErrInvalidResponse)
+	}
+	var result SentimentResult
+	if err := json.Unmarshal([]byte(jsonString), &result); err != nil {
+		return nil, fmt.Errorf("%w: error unmarshalling JSON: %w", m.ErrInvalidResponse, err)
+	}
+
+	result.Label = strings.ToLower(strings.TrimSpace(result.Label))
+	if err := checkSentiment(result.Label, result.Score); err != nil {
+		return nil, fmt.Errorf("overall sentiment: %w", err)
+	}
+	if len(result.Paragraphs) != n {
+		return nil, fmt.Errorf("expected %d paragraphs, got %d", n, len(result.Paragraphs))
+	}
+	seen := make(map[int]bool)
+	for i := range result.Paragraphs {
+		p := &result.Paragraphs[i]
+		if p.Index < 1 || p.Index > n || seen[p.Index] {
+			return nil, fmt.Errorf("bad paragraph index %d", p.Index)
+		}
+		seen[p.Index] = true
+		p.Label = strings.ToLower(strings.TrimSpace(p.Label))
+		if err := checkSentiment(p.Label, p.Score); err != nil {
+			return nil, fmt.Errorf("paragraph %d: %w", p.Index, err)
+		}
+	}
+	return &result, nil
+}
+
+func checkSentiment(label string, score float64) error {
+	if !sentimentLabels[label] {
+		return fmt.Errorf("unknown label %q", label)
+	}
+	if score < -1 || score > 1 {
+		return fmt.Errorf("score %v out of range", score)
+	}
+	return nil
+}
+
+// formatSentiment renders the result as markdown, quoting the start of each paragraph.
+func formatSentiment(result *SentimentResult, paragraphs []string) string {
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "**Overall sentiment:** %s (%+.2f)\n\n", result.Label, result.Score)
+	fmt.Fprintf(&builder, "| # | Sentiment | Score | Paragraph |\n|---|---|---|---|\n")
+	for _, p := range result.Paragraphs {
+		excerpt := textutil.Preview(paragraphs[p.Index-1], 60)
+		fmt.Fprintf(&builder, "| %d | %s | %+.2f | %s |\n", p.Index, p.Label, p.Score, strings.ReplaceAll(excerpt, "|", "\\|"))
+	}
+	return builder.String()
+}
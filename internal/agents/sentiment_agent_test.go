@@ -0,0 +1,101 @@
+package agents
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	m "github.com/nieveai/d-agents/internal/models"
+	"github.com/nieveai/d-agents/internal/testutil"
+	pb "github.com/nieveai/d-agents/proto"
+)
+
+const validSentiment = `{"label": "Mixed", "score": 0.1, "paragraphs": [{"index": 1, "label": "positive", "score": 0.8}, {"index": 2, "label": "negative", "score": -0.6}]}`
+
+func TestParseSentiment(t *testing.T) {
+	tests := []struct {
+		name     string
+		response string
+		want     string // part of the error, empty if it parses
+	}{
+		{"plain", validSentiment, ""},
+		{"in prose and a fence", "Sure [see below]:\n```json\n" + validSentiment + "\n```\nHope that helps!", ""},
+		{"no JSON", "It's mostly positive.", "no JSON object"},
+		{"unknown label", `{"label": "happy", "score": 0.5, "paragraphs": []}`, `unknown label "happy"`},
+		{"score out of range", `{"label": "positive", "score": 3, "paragraphs": []}`, "out of range"},
+		{"missing paragraph", `{"label": "positive", "score": 0.5, "paragraphs": [{"index": 1, "label": "positive", "score": 0.5}]}`, "expected 2 paragraphs, got 1"},
+		{"repeated index", `{"label": "positive", "score": 0.5, "paragraphs": [{"index": 1, "label": "positive", "score": 0.5}, {"index": 1, "label": "neutral", "score": 0}]}`, "bad paragraph index 1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := parseSentiment(tt.response, 2)
+			if tt.want == "" {
+				if err != nil {
+					t.Fatalf("parseSentiment: %v", err)
+				}
+				if result.Label != "mixed" || len(result.Paragraphs) != 2 || result.Paragraphs[1].Score != -0.6 {
+					t.Errorf("result = %+v", result)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("parseSentiment = %v, want an error containing %q", err, tt.want)
+			}
+		})
+	}
+	if _, err := parseSentiment("nothing here", 1); !errors.Is(err, m.ErrInvalidResponse) {
+		t.Errorf("error without JSON = %v, want ErrInvalidResponse", err)
+	}
+}
+
+func TestSentimentAnalysisAgent(t *testing.T) {
+	input := "I love the new design.\n\nBut the battery dies by noon."
+	tests := []struct {
+		name      string
+		responses []string
+		wantCalls int
+		wantErr   bool
+	}{
+		{"valid answer", []string{validSentiment}, 1, false},
+		{"retry fixes it", []string{"Overall it's mixed.", validSentiment}, 2, false},
+		{"retry fails too", []string{"Overall it's mixed.", `{"label": "mixed"}`}, 2, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := testutil.NewFakeGenAIClient(tt.responses...)
+			workload := &pb.Workload{Id: "s1", Models: []string{"m1"}, Payload: []byte(input)}
+			err := (&SentimentAnalysisAgent{}).DoWork(context.Background(), workload, client)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("DoWork = %v, want error %v", err, tt.wantErr)
+			}
+
+			calls := client.Calls()
+			if len(calls) != tt.wantCalls {
+				t.Fatalf("model called %d times, want %d", len(calls), tt.wantCalls)
+			}
+			if !strings.Contains(calls[0].Input, "[1] I love the new design.\n\n[2] But the battery") {
+				t.Errorf("user message = %q, want numbered paragraphs", calls[0].Input)
+			}
+			if tt.wantCalls > 1 && !strings.Contains(calls[1].SystemPrompt, "your previous answer was rejected: ") {
+				t.Errorf("retry system prompt isn't stricter:\n%s", calls[1].SystemPrompt)
+			}
+			if tt.wantErr {
+				return
+			}
+			payload := string(workload.Payload)
+			for _, want := range []string{"**Overall sentiment:** mixed (+0.10)", "| 2 | negative | -0.60 | But the battery", "```json\n{"} {
+				if !strings.Contains(payload, want) {
+					t.Errorf("payload doesn't contain %q:\n%s", want, payload)
+				}
+			}
+		})
+	}
+}
+
+func TestSentimentAnalysisAgentEmptyPayload(t *testing.T) {
+	workload := &pb.Workload{Id: "s1", Models: []string{"m1"}, Payload: []byte(" \n\n ")}
+	if err := (&SentimentAnalysisAgent{}).DoWork(context.Background(), workload, testutil.NewFakeGenAIClient()); err == nil {
+		t.Error("DoWork succeeded without text")
+	}
+}
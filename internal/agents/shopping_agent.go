@@ -1,38 +1,118 @@
 package agents
 
 import (
+	"context"
+	"crypto/sha256"
 	"encoding/json"
 	"fmt"
+	"log"
+	"strconv"
+	"strings"
 	"time"
+	"unicode"
 
 	"github.com/nieveai/d-agents/internal/database"
 	m "github.com/nieveai/d-agents/internal/models"
+	"github.com/nieveai/d-agents/internal/textutil"
 	pb "github.com/nieveai/d-agents/proto"
 )
 
 // ShoppingResult defines the structure for the JSON output from the GenAI client.
+// Price is usually a number but models sometimes copy the price text from the
+// page, e.g. "$1,299.00", so it is parsed by parsePrice.
 type ShoppingResult struct {
-	Name   string  `json:"name"`
-	Price  float64 `json:"price"`
-	Source string  `json:"source"`
-	URL    string  `json:"url"`
+	Name     string          `json:"name"`
+	Price    json.RawMessage `json:"price"`
+	Currency string          `json:"currency"`
+	Source   string          `json:"source"`
+	URL      string          `json:"url"`
+}
+
+// ShoppingConfig is the optional workload config of ShoppingAgent. With
+// NextPageSelector, the CSS selector of a catalog's "next page" link, the
+// agent follows it for up to MaxPages pages.
+type ShoppingConfig struct {
+	NextPageSelector string `json:"next_page_selector"`
+	MaxPages         int    `json:"max_pages"`
+}
+
+const (
+	// defaultShoppingPages is how many pages are crawled when the config has
+	// a next_page_selector but no max_pages.
+	defaultShoppingPages = 5
+	maxShoppingPages     = 50
+)
+
+func parseShoppingConfig(config string) (ShoppingConfig, error) {
+	var cfg ShoppingConfig
+	if strings.TrimSpace(config) != "" {
+		if err := json.Unmarshal([]byte(config), &cfg); err != nil {
+			return cfg, fmt.Errorf("invalid shopping config: %w", err)
+		}
+	}
+	switch {
+	case cfg.MaxPages < 0:
+		return cfg, fmt.Errorf("max_pages can't be negative")
+	case cfg.NextPageSelector == "":
+		cfg.MaxPages = 1
+	case cfg.MaxPages == 0:
+		cfg.MaxPages = defaultShoppingPages
+	case cfg.MaxPages > maxShoppingPages:
+		cfg.MaxPages = maxShoppingPages
+	}
+	return cfg, nil
 }
 
 type ShoppingAgent struct {
 	Db *database.ShoppingDB
+	customPrompt
+	progress
+	// FetchPage gets a page and the URL of the page after it, see
+	// browser.BrowserPool.FetchPage. It defaults to the shared browser.
+	FetchPage func(ctx context.Context, url, nextSelector string) (html, next string, err error)
+}
+
+// shoppingDBPath is the prices database of the shopping agents.
+var shoppingDBPath = database.DefaultShoppingDB
+
+// SetShoppingDBPath makes shopping agents created from now on keep their
+// prices in the SQLite file at path.
+func SetShoppingDBPath(path string) {
+	shoppingDBPath = path
+}
+
+func init() {
+	m.RegisterAgent("ShoppingAgent", func() (m.AgentInterface, error) {
+		return NewShoppingAgent()
+	})
 }
 
 func NewShoppingAgent() (*ShoppingAgent, error) {
-	db, err := database.NewShoppingDB()
+	db, err := database.NewShoppingDB(shoppingDBPath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get shopping db: %w", err)
 	}
 	return &ShoppingAgent{Db: db}, nil
 }
 
-const shoppingSystemPromptTemplate = `you are a shopping assistant. from the provided HTML content, please find all products similar to "%s". extract the product name, price, source and product URL for each. the output should be a JSON array. for example: [ { "name" : "product name", "price": 12.34, "source": "amazon.com", "url": "http://amazon.com/product/123" }, ...]`
+const shoppingSystemPromptTemplate = `you are a shopping assistant. from the provided page content, please find all products similar to "%s". extract the product name, price, currency, source and product URL for each. the price is a number and the currency an ISO 4217 code. the output should be a JSON array. for example: [ { "name" : "product name", "price": 12.34, "currency": "USD", "source": "amazon.com", "url": "http://amazon.com/product/123" }, ...]`
+
+// shoppingResultSchema is the JSON schema of a ShoppingResult. Enforced
+// output always has a numeric price.
+var shoppingResultSchema = map[string]any{
+	"type": "object",
+	"properties": map[string]any{
+		"name":     map[string]any{"type": "string"},
+		"price":    map[string]any{"type": "number"},
+		"currency": map[string]any{"type": "string"},
+		"source":   map[string]any{"type": "string"},
+		"url":      map[string]any{"type": "string"},
+	},
+	"required":             []string{"name", "price", "currency", "source", "url"},
+	"additionalProperties": false,
+}
 
-func (a *ShoppingAgent) DoWork(workload *pb.Workload, genAIClient m.GenAIClient) error {
+func (a *ShoppingAgent) DoWork(ctx context.Context, workload *pb.Workload, genAIClient m.GenAIClient) error {
 	if workload == nil {
 		return fmt.Errorf("workload is nil")
 	}
@@ -43,49 +123,277 @@ func (a *ShoppingAgent) DoWork(workload *pb.Workload, genAIClient m.GenAIClient)
 		return fmt.Errorf("workload name (the product name) is empty")
 	}
 
+	config, err := parseShoppingConfig(workload.Config)
+	if err != nil {
+		return err
+	}
 	input := string(workload.Payload)
 	url := extractURL(input)
+	systemPrompt, err := a.promptFor(workload, fmt.Sprintf(shoppingSystemPromptTemplate, workload.Name))
+	if err != nil {
+		return err
+	}
 
-	var processedInput string
-	if url != "" {
-		htmlContent, err := getHTMLFromURL(url)
-		if err != nil {
-			return fmt.Errorf("failed to get HTML from URL %s: %w", url, err)
+	if workload.DryRun {
+		fetch := "No URL in the payload, it is sent to the model as is."
+		if url != "" {
+			fetch = url
+			if config.NextPageSelector != "" {
+				fetch += fmt.Sprintf(" and up to %d pages after it, following %q", config.MaxPages-1, config.NextPageSelector)
+			}
 		}
-		processedInput = htmlContent
-	} else {
-		processedInput = input
+		workload.Payload = []byte(dryRunPreview(input,
+			previewStep{"Page to fetch", fetch},
+			previewStep{"Extraction prompt", systemPrompt},
+			previewStep{"Shopping database", "one products row (name, price, currency, date, source, url) per product found, unless the product was already recorded today"},
+		))
+		return nil
 	}
 
-	// Pass the payload to the GenAI client to get the shopping result JSON
-	systemPrompt := fmt.Sprintf(shoppingSystemPromptTemplate, workload.Name)
-	llmResponse, err := genAIClient.GenerateContentWithSystemPrompt(workload, processedInput, systemPrompt)
-	if err != nil {
-		return fmt.Errorf("error generating content: %w", err)
+	if url == "" {
+		_, err := a.extractProducts(ctx, workload, genAIClient, input, systemPrompt, make(map[string]bool))
+		return err
 	}
+	return a.crawl(ctx, workload, genAIClient, url, config, systemPrompt)
+}
 
-	// Extract the JSON part from the response
-	jsonString := extractJSONArray(llmResponse)
+// crawl extracts the products on the page at start and, with a next page
+// selector, on the pages after it. Where a paginated crawl is at is saved
+// after every page, so one that is cancelled or fails is resumed by the next
+// run on the same day.
+func (a *ShoppingAgent) crawl(ctx context.Context, workload *pb.Workload, genAIClient m.GenAIClient, start string, config ShoppingConfig, systemPrompt string) error {
+	fetch := a.FetchPage
+	if fetch == nil {
+		fetch = getBrowserPool().FetchPage
+	}
+	paginated := config.NextPageSelector != ""
+	url, done := start, 0
+	if paginated {
+		state, err := a.Db.GetCrawlState(start, config.NextPageSelector)
+		if err != nil {
+			return err
+		}
+		// Products are recorded once a day, so older crawls start over.
+		if state.NextURL != "" && sameDay(state.Updated, time.Now()) && state.Pages < config.MaxPages {
+			url, done = state.NextURL, state.Pages
+			log.Printf("ShoppingAgent: resuming the crawl of %s at page %d, %s", start, done+1, url)
+		}
+	}
+
+	visited := make(map[string]bool)
+	contents := make(map[[sha256.Size]byte]bool)
+	seen := make(map[string]bool)
+	found := 0
+	for url != "" && done < config.MaxPages {
+		if ctx.Err() != nil {
+			return fmt.Errorf("crawl of %s stopped after %d pages: %w", start, done, context.Cause(ctx))
+		}
+		visited[url] = true
+		htmlContent, next, err := fetch(ctx, url, config.NextPageSelector)
+		if err != nil {
+			return fmt.Errorf("failed to get HTML from URL %s: %w", url, err)
+		}
+		text := textutil.CleanHTML(htmlContent)
+		// Some sites serve the last page again for pages past the end.
+		sum := sha256.Sum256([]byte(text))
+		if contents[sum] {
+			log.Printf("ShoppingAgent: %s is the same as an earlier page, stopping", url)
+			break
+		}
+		contents[sum] = true
+
+		n, err := a.extractProducts(ctx, workload, genAIClient, text, systemPrompt, seen)
+		if err != nil {
+			return err
+		}
+		found += n
+		done++
+		a.reportProgress(workload, stepProgress(0, 100, done, config.MaxPages))
+
+		if visited[next] {
+			next = ""
+		}
+		if paginated && next != "" && done < config.MaxPages {
+			if err := a.Db.SetCrawlState(start, config.NextPageSelector, database.CrawlState{NextURL: next, Pages: done}); err != nil {
+				return err
+			}
+		}
+		url = next
+	}
+	if paginated {
+		log.Printf("ShoppingAgent: found %d products on %d pages of %s", found, done, start)
+		return a.Db.DeleteCrawlState(start, config.NextPageSelector)
+	}
+	return nil
+}
 
-	if jsonString == "" {
-		fmt.Printf("%s\n", llmResponse)
-		return fmt.Errorf("no JSON array found in the LLM response")
+// extractProducts has the model find the products in input and records them.
+// Products in seen, from earlier pages of the same crawl, are skipped. It
+// returns how many new products were found.
+func (a *ShoppingAgent) extractProducts(ctx context.Context, workload *pb.Workload, genAIClient m.GenAIClient, input, systemPrompt string, seen map[string]bool) (int, error) {
+	list, llmResponse, err := generateJSONList(ctx, genAIClient, workload, input, systemPrompt, shoppingResultSchema)
+	if err != nil {
+		if llmResponse != "" {
+			fmt.Printf("%s\n", llmResponse)
+		}
+		return 0, err
 	}
 
 	var results []ShoppingResult
-	if err := json.Unmarshal([]byte(jsonString), &results); err != nil {
-		return fmt.Errorf("failed to parse JSON from LLM response: %w", err)
+	if err := json.Unmarshal(list, &results); err != nil {
+		return 0, fmt.Errorf("%w: failed to parse JSON from LLM response: %w", m.ErrInvalidResponse, err)
 	}
 
-	// Process the shopping results and update the database
+	found := 0
 	for _, result := range results {
-		err = a.Db.InsertProduct(result.Name, result.Price, time.Now(), result.Source, result.URL)
+		key := productKey(result)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		price, currency, err := parsePrice(result.Price)
+		if err != nil {
+			log.Printf("skipping product %s: %v", result.Name, err)
+			continue
+		}
+		if currency == "" {
+			currency = normalizeCurrency(result.Currency)
+		}
+		err = a.Db.InsertProduct(result.Name, price, currency, time.Now(), result.Source, result.URL)
 		if err != nil {
 			// Log the error and continue with the next product
 			fmt.Printf("failed to insert product %s: %v\n", result.Name, err)
+			continue
 		}
+		found++
 	}
+	return found, nil
+}
 
-	return nil
+// productKey tells products apart across the pages of a catalog: by URL, or
+// by name and shop when the model found no URL.
+func productKey(result ShoppingResult) string {
+	if result.URL != "" {
+		return result.URL
+	}
+	return strings.ToLower(strings.TrimSpace(result.Name)) + "\x00" + result.Source
+}
+
+// sameDay reports whether a and b fall on the same UTC day, the unit prices
+// are recorded in.
+func sameDay(a, b time.Time) bool {
+	return a.UTC().Truncate(24 * time.Hour).Equal(b.UTC().Truncate(24 * time.Hour))
+}
+
+// currencySymbols maps the currency signs found in prices to ISO 4217 codes.
+// Longer signs come first so "US$" isn't read as "$".
+var currencySymbols = []struct{ symbol, code string }{
+	{"US$", "USD"}, {"CA$", "CAD"}, {"AU$", "AUD"}, {"NZ$", "NZD"}, {"HK$", "HKD"},
+	{"C$", "CAD"}, {"A$", "AUD"}, {"R$", "BRL"}, {"zł", "PLN"},
+	{"$", "USD"}, {"€", "EUR"}, {"£", "GBP"}, {"¥", "JPY"}, {"₹", "INR"}, {"₩", "KRW"},
+}
+
+// parsePrice reads a price the model returned either as a JSON number or as
+// text like "$1,299.00", "1.299,00 €" or "CHF 1'299.50". The currency is
+// returned as an ISO 4217 code, or empty when the price doesn't say.
+func parsePrice(raw json.RawMessage) (float64, string, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return 0, "", fmt.Errorf("no price")
+	}
+	var number float64
+	if err := json.Unmarshal(raw, &number); err == nil {
+		return number, "", nil
+	}
+	var text string
+	if err := json.Unmarshal(raw, &text); err != nil {
+		return 0, "", fmt.Errorf("price %s is neither a number nor a string", raw)
+	}
+	return ParsePriceText(text)
 }
 
+// ParsePriceText reads a price written as text, see parsePrice.
+func ParsePriceText(text string) (float64, string, error) {
+	text = strings.TrimSpace(text)
+	currency := ""
+	// A three letter code such as EUR before or after the amount.
+	for _, field := range strings.FieldsFunc(text, func(r rune) bool { return !unicode.IsLetter(r) }) {
+		if len(field) == 3 && strings.ToUpper(field) == field {
+			currency = field
+			break
+		}
+	}
+	if currency == "" {
+		for _, cs := range currencySymbols {
+			if strings.Contains(text, cs.symbol) {
+				currency = cs.code
+				break
+			}
+		}
+	}
+
+	// Keep the digits and separators of the first number in the text.
+	var digits strings.Builder
+scan:
+	for _, r := range text {
+		switch {
+		case unicode.IsDigit(r), r == '.', r == ',':
+			digits.WriteRune(r)
+		case r == '\'' || r == ' ' || r == '\u00a0' || r == '\u202f':
+			// Thousands separators in Swiss and French formats, or the
+			// space after an abbreviation like "Rs.".
+			if !strings.ContainsAny(digits.String(), "0123456789") {
+				digits.Reset()
+			}
+		case strings.ContainsAny(digits.String(), "0123456789"):
+			break scan
+		default:
+			digits.Reset()
+		}
+	}
+	number := strings.TrimRight(digits.String(), ".,")
+	if !strings.ContainsAny(number, "0123456789") {
+		return 0, "", fmt.Errorf("no number in price %q", text)
+	}
+	if strings.IndexAny(number, ".,") == 0 {
+		number = "0" + number
+	}
+	price, err := strconv.ParseFloat(normalizeDecimal(number), 64)
+	if err != nil {
+		return 0, "", fmt.Errorf("can't read price %q: %w", text, err)
+	}
+	return price, currency, nil
+}
+
+// normalizeDecimal turns a number written with any mix of "." and "," into
+// the form strconv understands. The last separator is the decimal point,
+// unless it appears more than once or is followed by exactly three digits,
+// in which case it groups thousands ("1,299" and "1.299" are both 1299).
+func normalizeDecimal(number string) string {
+	last := strings.LastIndexAny(number, ".,")
+	if last < 0 {
+		return number
+	}
+	sep := number[last]
+	decimals := len(number) - last - 1
+	if strings.Count(number, string(sep)) > 1 ||
+		(decimals == 3 && !strings.ContainsAny(number[:last], ".,") && strings.Trim(number[:last], "0") != "") {
+		return strings.NewReplacer(".", "", ",", "").Replace(number)
+	}
+	whole := strings.NewReplacer(".", "", ",", "").Replace(number[:last])
+	return whole + "." + number[last+1:]
+}
+
+// normalizeCurrency turns a currency the model returned, either a code or a
+// sign, into an ISO 4217 code.
+func normalizeCurrency(currency string) string {
+	currency = strings.TrimSpace(currency)
+	for _, cs := range currencySymbols {
+		if currency == cs.symbol {
+			return cs.code
+		}
+	}
+	if len(currency) == 3 {
+		return strings.ToUpper(currency)
+	}
+	return ""
+}
@@ -0,0 +1,406 @@
+package agents
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nieveai/d-agents/internal/database"
+	m "github.com/nieveai/d-agents/internal/models"
+	"github.com/nieveai/d-agents/internal/testutil"
+	pb "github.com/nieveai/d-agents/proto"
+)
+
+// newTestShoppingAgent returns a ShoppingAgent with a fresh shopping database
+// that gets its pages from pages, by URL, instead of a browser.
+func newTestShoppingAgent(t *testing.T, pages map[string]string) (*ShoppingAgent, *[]string) {
+	t.Helper()
+	db, err := database.NewShoppingDB(filepath.Join(t.TempDir(), "shopping.db"))
+	if err != nil {
+		t.Fatalf("NewShoppingDB: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	var fetched []string
+	agent := &ShoppingAgent{Db: db}
+	agent.FetchPage = func(ctx context.Context, url, nextSelector string) (string, string, error) {
+		fetched = append(fetched, url)
+		return pages[url], "", nil
+	}
+	return agent, &fetched
+}
+
+func TestSetShoppingDBPath(t *testing.T) {
+	t.Chdir(t.TempDir())
+	t.Cleanup(func() { SetShoppingDBPath(database.DefaultShoppingDB) })
+	path := filepath.Join("projects", "prices", "custom.db")
+	SetShoppingDBPath(path)
+
+	shopping, err := NewShoppingAgent()
+	if err != nil {
+		t.Fatalf("NewShoppingAgent: %v", err)
+	}
+	shopping.Db.Close()
+	notifications, err := NewShoppingNotificationAgent()
+	if err != nil {
+		t.Fatalf("NewShoppingNotificationAgent: %v", err)
+	}
+	notifications.Db.Close()
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("shopping database not created at %s: %v", path, err)
+	}
+	if _, err := os.Stat(database.DefaultShoppingDB); err == nil {
+		t.Errorf("a shopping database was created at %s too", database.DefaultShoppingDB)
+	}
+}
+
+func TestShoppingAgentUsesItsFetcher(t *testing.T) {
+	agent, fetched := newTestShoppingAgent(t, map[string]string{
+		"https://shop.test/hub": "<html><body><p>USB-C Hub $24.99</p></body></html>",
+	})
+	client := testutil.NewFakeGenAIClient(`[{"name": "USB-C Hub", "price": 24.99, "currency": "USD", "source": "shop.test", "url": "https://shop.test/hub"}]`)
+	workload := &pb.Workload{Id: "s1", Name: "USB-C Hub", Models: []string{"m1"}, Payload: []byte("https://shop.test/hub")}
+
+	if err := agent.DoWork(context.Background(), workload, client); err != nil {
+		t.Fatalf("DoWork: %v", err)
+	}
+	if len(*fetched) != 1 || (*fetched)[0] != "https://shop.test/hub" {
+		t.Errorf("fetched %v, want the product page once", *fetched)
+	}
+	if call, _ := client.LastCall(); call.Input != "USB-C Hub $24.99" {
+		t.Errorf("the model got %q, want the page's text", call.Input)
+	}
+	products, err := agent.Db.GetAllProducts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(products) != 1 || products[0].Price != 24.99 || products[0].Currency != "USD" {
+		t.Errorf("products = %v", products)
+	}
+}
+
+func TestShoppingAgentSendsCleanText(t *testing.T) {
+	agent, _ := newTestShoppingAgent(t, map[string]string{
+		"https://shop.test/hub": `<html><head><script>track()</script></head><body><nav><a href="/">Home</a></nav>` +
+			`<div class="product" data-id="42"><h1 class="title">USB-C Hub</h1><span class="price" style="color:red">$24.99</span></div>` +
+			`<footer>© Shop</footer></body></html>`,
+	})
+	client := testutil.NewFakeGenAIClient("[]")
+	workload := &pb.Workload{Id: "s1", Name: "USB-C Hub", Models: []string{"m1"}, Payload: []byte("https://shop.test/hub")}
+
+	if err := agent.DoWork(context.Background(), workload, client); err != nil {
+		t.Fatalf("DoWork: %v", err)
+	}
+	if call, _ := client.LastCall(); call.Input != "# USB-C Hub\n\n$24.99" {
+		t.Errorf("the model got %q, want the page's text without markup, nav or footer", call.Input)
+	}
+}
+
+func TestShoppingAgentFetchHonoursTheDeadline(t *testing.T) {
+	agent, _ := newTestShoppingAgent(t, nil)
+	// A page that never finishes loading.
+	agent.FetchPage = func(ctx context.Context, url, nextSelector string) (string, string, error) {
+		<-ctx.Done()
+		return "", "", ctx.Err()
+	}
+	client := testutil.NewFakeGenAIClient("[]")
+	workload := &pb.Workload{Id: "s1", Name: "USB-C Hub", Models: []string{"m1"}, Payload: []byte("https://shop.test/hub")}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err := agent.DoWork(ctx, workload, client)
+	if !errors.Is(err, context.DeadlineExceeded) || !strings.Contains(err.Error(), "https://shop.test/hub") {
+		t.Errorf("DoWork = %v, want a deadline error naming the page", err)
+	}
+	if len(client.Calls()) > 0 {
+		t.Error("the model was called without a page")
+	}
+}
+
+func TestShoppingAgentInvalidResponse(t *testing.T) {
+	agent, _ := newTestShoppingAgent(t, map[string]string{
+		"https://shop.test/hub": "<html><body><p>USB-C Hub $24.99</p></body></html>",
+	})
+	for _, answer := range []string{"The hub costs $24.99.", `["USB-C Hub", 24.99]`} {
+		workload := &pb.Workload{Id: "s1", Name: "USB-C Hub", Models: []string{"m1"}, Payload: []byte("https://shop.test/hub")}
+		if err := agent.DoWork(context.Background(), workload, testutil.NewFakeGenAIClient(answer)); !errors.Is(err, m.ErrInvalidResponse) {
+			t.Errorf("DoWork with the answer %q = %v, want ErrInvalidResponse", answer, err)
+		}
+	}
+}
+
+func TestParsePrice(t *testing.T) {
+	tests := []struct {
+		raw          string
+		want         float64
+		wantCurrency string
+	}{
+		{`12.34`, 12.34, ""},
+		{`"$1,299.00"`, 1299, "USD"},
+		{`"1.299,00 €"`, 1299, "EUR"},
+		{`"1 299,50 €"`, 1299.5, "EUR"},
+		{`"CHF 1'299.50"`, 1299.5, "CHF"},
+		{`"£9.99"`, 9.99, "GBP"},
+		{`"US$ 15"`, 15, "USD"},
+		{`"¥1,280"`, 1280, "JPY"},
+		{`"1.299 EUR"`, 1299, "EUR"},
+		{`"0,99 €"`, 0.99, "EUR"},
+		{`"R$ 49,90"`, 49.9, "BRL"},
+		{`"Rs. 2,499"`, 2499, ""},
+		{`"from 1.234.567,89 zł"`, 1234567.89, "PLN"},
+	}
+	for _, tt := range tests {
+		price, currency, err := parsePrice(json.RawMessage(tt.raw))
+		if err != nil {
+			t.Errorf("parsePrice(%s): %v", tt.raw, err)
+			continue
+		}
+		if price != tt.want || currency != tt.wantCurrency {
+			t.Errorf("parsePrice(%s) = %v %q, want %v %q", tt.raw, price, currency, tt.want, tt.wantCurrency)
+		}
+	}
+
+	for _, raw := range []string{``, `null`, `"call for price"`, `{"amount": 3}`} {
+		if _, _, err := parsePrice(json.RawMessage(raw)); err == nil {
+			t.Errorf("parsePrice(%s) succeeded", raw)
+		}
+	}
+}
+
+func TestNormalizeCurrency(t *testing.T) {
+	for in, want := range map[string]string{"usd": "USD", " EUR ": "EUR", "€": "EUR", "C$": "CAD", "dollars": ""} {
+		if got := normalizeCurrency(in); got != want {
+			t.Errorf("normalizeCurrency(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+// catalog is a shop whose products are split over three pages linked by
+// a.next, the last page having no link.
+var catalog = map[string]string{
+	"/catalog":        `<html><body><h2>Hub A</h2><p>$10</p><a class="next" href="/catalog?page=2">Next</a></body></html>`,
+	"/catalog?page=2": `<html><body><h2>Hub A</h2><p>$10</p><h2>Hub B</h2><p>$20</p><a class="next" href="/catalog?page=3">Next</a></body></html>`,
+	"/catalog?page=3": `<html><body><h2>Hub C</h2><p>$30</p></body></html>`,
+}
+
+var nextLink = regexp.MustCompile(`<a class="next" href="([^"]+)"`)
+
+// newTestCrawl serves pages, by path and query, and returns a ShoppingAgent
+// fetching them over HTTP, the paths it fetched and the site's URL. The a.next
+// link stands in for the browser's CSS selector.
+func newTestCrawl(t *testing.T, pages map[string]string) (*ShoppingAgent, *[]string, string) {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		page, ok := pages[r.URL.RequestURI()]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, page)
+	}))
+	t.Cleanup(server.Close)
+
+	agent, _ := newTestShoppingAgent(t, nil)
+	var fetched []string
+	agent.FetchPage = func(ctx context.Context, url, nextSelector string) (string, string, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return "", "", err
+		}
+		resp, err := server.Client().Do(req)
+		if err != nil {
+			return "", "", err
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return "", "", err
+		}
+		fetched = append(fetched, strings.TrimPrefix(url, server.URL))
+		next := ""
+		if match := nextLink.FindStringSubmatch(string(body)); match != nil && nextSelector == "a.next" {
+			next = server.URL + match[1]
+		}
+		return string(body), next, nil
+	}
+	return agent, &fetched, server.URL
+}
+
+// catalogModel answers with the hubs named in its input, from the shop
+// source. With sourcePerCall each call names another source instead, so a
+// product stored twice in a crawl shows up twice.
+func catalogModel(sourcePerCall bool) *testutil.FakeGenAIClient {
+	calls := 0
+	answer := func(call testutil.Call) testutil.Response {
+		calls++
+		source := "shop"
+		if sourcePerCall {
+			source = fmt.Sprintf("call %d", calls)
+		}
+		results := []map[string]any{}
+		for i, name := range []string{"Hub A", "Hub B", "Hub C"} {
+			if strings.Contains(call.Input, name) {
+				results = append(results, map[string]any{"name": name, "price": 10 * (i + 1), "currency": "USD", "source": source, "url": "https://shop.test/" + name})
+			}
+		}
+		data, _ := json.Marshal(results)
+		return testutil.Response{Text: string(data)}
+	}
+	client := testutil.NewFakeGenAIClient()
+	for range maxShoppingPages {
+		client.RespondWith(answer)
+	}
+	return client
+}
+
+// productNames returns the names of the products in db, sorted.
+func productNames(t *testing.T, db *database.ShoppingDB) []string {
+	t.Helper()
+	products, err := db.GetAllProducts()
+	if err != nil {
+		t.Fatalf("GetAllProducts: %v", err)
+	}
+	var names []string
+	for _, product := range products {
+		names = append(names, product.Name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+func TestShoppingAgentCrawlsPages(t *testing.T) {
+	// Some shops serve their last page for any page after it.
+	lastPage := `<html><body><h2>Hub C</h2><p>$30</p><a class="next" href="/catalog?page=4">Next</a></body></html>`
+	tests := []struct {
+		name         string
+		config       string
+		pages        map[string]string
+		wantFetched  []string
+		wantProducts []string
+	}{
+		{"all pages", `{"next_page_selector": "a.next"}`, catalog,
+			[]string{"/catalog", "/catalog?page=2", "/catalog?page=3"}, []string{"Hub A", "Hub B", "Hub C"}},
+		{"max pages", `{"next_page_selector": "a.next", "max_pages": 2}`, catalog,
+			[]string{"/catalog", "/catalog?page=2"}, []string{"Hub A", "Hub B"}},
+		{"no selector", "", catalog,
+			[]string{"/catalog"}, []string{"Hub A"}},
+		{"link back to the start", `{"next_page_selector": "a.next"}`, map[string]string{
+			"/catalog":        catalog["/catalog"],
+			"/catalog?page=2": `<html><body><h2>Hub B</h2><p>$20</p><a class="next" href="/catalog">Back to the start</a></body></html>`,
+		}, []string{"/catalog", "/catalog?page=2"}, []string{"Hub A", "Hub B"}},
+		{"last page served again", `{"next_page_selector": "a.next"}`, map[string]string{
+			"/catalog":        catalog["/catalog"],
+			"/catalog?page=2": catalog["/catalog?page=2"],
+			"/catalog?page=3": lastPage,
+			"/catalog?page=4": lastPage,
+		}, []string{"/catalog", "/catalog?page=2", "/catalog?page=3", "/catalog?page=4"}, []string{"Hub A", "Hub B", "Hub C"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			agent, fetched, site := newTestCrawl(t, tt.pages)
+			client := catalogModel(true)
+			workload := &pb.Workload{Id: "s1", Name: "USB hub", Models: []string{"m1"}, Payload: []byte(site + "/catalog"), Config: tt.config}
+
+			if err := agent.DoWork(context.Background(), workload, client); err != nil {
+				t.Fatalf("DoWork: %v", err)
+			}
+			if !slices.Equal(*fetched, tt.wantFetched) {
+				t.Errorf("fetched %q, want %q", *fetched, tt.wantFetched)
+			}
+			// Hub A is on two pages but stored once.
+			if got := productNames(t, agent.Db); !slices.Equal(got, tt.wantProducts) {
+				t.Errorf("products = %q, want %q", got, tt.wantProducts)
+			}
+			if state, _ := agent.Db.GetCrawlState(site+"/catalog", "a.next"); state.NextURL != "" {
+				t.Errorf("crawl state left after finishing: %+v", state)
+			}
+		})
+	}
+}
+
+func TestShoppingAgentResumesCrawl(t *testing.T) {
+	agent, fetched, site := newTestCrawl(t, catalog)
+	start := site + "/catalog"
+	workload := func() *pb.Workload {
+		return &pb.Workload{Id: "s1", Name: "USB hub", Models: []string{"m1"}, Payload: []byte(start), Config: `{"next_page_selector": "a.next"}`}
+	}
+
+	// The model fails on the second page.
+	client := testutil.NewFakeGenAIClient(`[{"name": "Hub A", "price": 10, "currency": "USD", "source": "shop", "url": "https://shop.test/Hub A"}]`).Fail(errors.New("model down"))
+	if err := agent.DoWork(context.Background(), workload(), client); err == nil {
+		t.Fatal("DoWork succeeded with the model down")
+	}
+	state, err := agent.Db.GetCrawlState(start, "a.next")
+	if err != nil || state.NextURL != site+"/catalog?page=2" || state.Pages != 1 {
+		t.Fatalf("crawl state = %+v, %v, want page 2 after 1 page", state, err)
+	}
+
+	*fetched = nil
+	if err := agent.DoWork(context.Background(), workload(), catalogModel(false)); err != nil {
+		t.Fatalf("DoWork: %v", err)
+	}
+	if want := []string{"/catalog?page=2", "/catalog?page=3"}; !slices.Equal(*fetched, want) {
+		t.Errorf("resumed crawl fetched %q, want %q", *fetched, want)
+	}
+	if got := productNames(t, agent.Db); !slices.Equal(got, []string{"Hub A", "Hub B", "Hub C"}) {
+		t.Errorf("products = %q, want all three hubs", got)
+	}
+	if state, _ := agent.Db.GetCrawlState(start, "a.next"); state.NextURL != "" {
+		t.Errorf("crawl state left after finishing: %+v", state)
+	}
+}
+
+func TestShoppingAgentCrawlCancelled(t *testing.T) {
+	agent, fetched, site := newTestCrawl(t, catalog)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// Cancelled while the first page is being read.
+	client := testutil.NewFakeGenAIClient().RespondWith(func(call testutil.Call) testutil.Response {
+		cancel()
+		return testutil.Response{Text: "[]"}
+	})
+	workload := &pb.Workload{Id: "s1", Name: "USB hub", Models: []string{"m1"}, Payload: []byte(site + "/catalog"), Config: `{"next_page_selector": "a.next"}`}
+
+	err := agent.DoWork(ctx, workload, client)
+	if !errors.Is(err, context.Canceled) || !strings.Contains(err.Error(), "stopped after 1 pages") {
+		t.Errorf("DoWork = %v, want it cancelled after the first page", err)
+	}
+	if len(*fetched) != 1 {
+		t.Errorf("fetched %q after the crawl was cancelled", *fetched)
+	}
+	if state, _ := agent.Db.GetCrawlState(site+"/catalog", "a.next"); state.NextURL != site+"/catalog?page=2" {
+		t.Errorf("crawl state = %+v, want the next page saved", state)
+	}
+}
+
+func TestParseShoppingConfig(t *testing.T) {
+	tests := []struct {
+		config  string
+		want    ShoppingConfig
+		wantErr bool
+	}{
+		{"", ShoppingConfig{MaxPages: 1}, false},
+		{`{"max_pages": 9}`, ShoppingConfig{MaxPages: 1}, false},
+		{`{"next_page_selector": "a.next"}`, ShoppingConfig{NextPageSelector: "a.next", MaxPages: defaultShoppingPages}, false},
+		{`{"next_page_selector": "a.next", "max_pages": 3}`, ShoppingConfig{NextPageSelector: "a.next", MaxPages: 3}, false},
+		{`{"next_page_selector": "a.next", "max_pages": 1000}`, ShoppingConfig{NextPageSelector: "a.next", MaxPages: maxShoppingPages}, false},
+		{`{"next_page_selector": "a.next", "max_pages": -1}`, ShoppingConfig{}, true},
+		{`{"max_pages": "3"}`, ShoppingConfig{}, true},
+	}
+	for _, tt := range tests {
+		got, err := parseShoppingConfig(tt.config)
+		if (err != nil) != tt.wantErr || (!tt.wantErr && got != tt.want) {
+			t.Errorf("parseShoppingConfig(%q) = %+v, %v, want %+v, error %v", tt.config, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
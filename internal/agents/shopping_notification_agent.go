@@ -1,9 +1,11 @@
 package agents
 
 import (
+	"context"
 	"fmt"
+	"log"
 	"sort"
-	"time"
+	"strings"
 
 	"github.com/nieveai/d-agents/internal/database"
 	m "github.com/nieveai/d-agents/internal/models"
@@ -14,89 +16,93 @@ type ShoppingNotificationAgent struct {
 	Db *database.ShoppingDB
 }
 
+func init() {
+	m.RegisterAgent("ShoppingNotificationAgent", func() (m.AgentInterface, error) {
+		return NewShoppingNotificationAgent()
+	})
+}
+
 func NewShoppingNotificationAgent() (*ShoppingNotificationAgent, error) {
-	db, err := database.NewShoppingDB()
+	db, err := database.NewShoppingDB(shoppingDBPath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get shopping db: %w", err)
 	}
 	return &ShoppingNotificationAgent{Db: db}, nil
 }
 
-func (a *ShoppingNotificationAgent) DoWork(workload *pb.Workload, genAIClient m.GenAIClient) error {
-	products, err := a.Db.GetAllProducts()
+func (a *ShoppingNotificationAgent) DoWork(ctx context.Context, workload *pb.Workload, genAIClient m.GenAIClient) error {
+	config, err := parseNotificationConfig(workload.Config)
 	if err != nil {
-		return fmt.Errorf("failed to get products: %w", err)
+		return err
 	}
 
-	// Group products by name
-	productsByName := make(map[string][]*database.Product)
-	for _, p := range products {
-		productsByName[p.Name] = append(productsByName[p.Name], p)
+	names, err := a.Db.ListProductNames()
+	if err != nil {
+		return fmt.Errorf("failed to get products: %w", err)
 	}
 
 	var notifications []string
-	for name, productList := range productsByName {
-		// Sort products by date
-		sort.Slice(productList, func(i, j int) bool {
-			return productList[i].Date.Before(productList[j].Date)
-		})
-
-		if len(productList) < 2 {
-			continue
+	for _, name := range names {
+		history, err := a.Db.GetPriceHistory(name)
+		if err != nil {
+			return fmt.Errorf("failed to get price history of %s: %w", name, err)
 		}
-
-		// Find the lowest price in the most recent period
-		mostRecentPeriod := productList[len(productList)-1].Date
-		var recentPrices []float64
-		for _, p := range productList {
-			if p.Date.Equal(mostRecentPeriod) {
-				recentPrices = append(recentPrices, p.Price)
-			}
+		// Prices in different currencies aren't compared.
+		byCurrency := make(map[string][]database.PricePoint)
+		for _, point := range history {
+			byCurrency[point.Currency] = append(byCurrency[point.Currency], point)
 		}
-		lowestRecentPrice := recentPrices[0]
-		for _, price := range recentPrices {
-			if price < lowestRecentPrice {
-				lowestRecentPrice = price
+		for currency, points := range byCurrency {
+			if len(points) < 2 {
+				continue
 			}
-		}
-
-		// Find the lowest price in the previous period
-		previousPeriod := time.Time{}
-		for i := len(productList) - 1; i >= 0; i-- {
-			if productList[i].Date.Before(mostRecentPeriod) {
-				previousPeriod = productList[i].Date
-				break
+			// Compare the lowest price of the most recent day with the one before.
+			recent, previous := points[len(points)-1], points[len(points)-2]
+			if recent.Price < previous.Price {
+				notifications = append(notifications, fmt.Sprintf("Price drop for %s: %s (was %s)", name, formatPrice(recent.Price, currency), formatPrice(previous.Price, currency)))
 			}
 		}
+	}
 
-		if previousPeriod.IsZero() {
-			continue
-		}
+	input := string(workload.Payload)
+	if len(notifications) == 0 {
+		FormatResult(workload, input, "No price drops detected.", "No price drops detected.", map[string]any{"price_drops": []string{}})
+		return nil
+	}
 
-		var previousPrices []float64
-		for _, p := range productList {
-			if p.Date.Equal(previousPeriod) {
-				previousPrices = append(previousPrices, p.Price)
-			}
-		}
+	sort.Strings(notifications)
+	body := strings.Join(notifications, "\n")
+	payload := fmt.Sprintf("Price drop alerts:\n%s", body)
 
-		lowestPreviousPrice := previousPrices[0]
-		for _, price := range previousPrices {
-			if price < lowestPreviousPrice {
-				lowestPreviousPrice = price
-			}
+	notifiers := config.Notifiers()
+	if config.DryRun || workload.DryRun {
+		for _, n := range notifiers {
+			log.Printf("Dry run: would send %s notification:\n%s", n.Name(), body)
 		}
-
-		if lowestRecentPrice < lowestPreviousPrice {
-			notifications = append(notifications, fmt.Sprintf("Price drop for %s: $%.2f (was $%.2f)", name, lowestRecentPrice, lowestPreviousPrice))
+		FormatResult(workload, input, payload, payload, map[string]any{"price_drops": notifications})
+		return nil
+	}
+	failures := notifyAll(ctx, notifiers, "Price drop alerts", body)
+	for _, n := range notifiers {
+		if err, failed := failures[n.Name()]; failed {
+			log.Printf("Failed to send %s notification: %v", n.Name(), err)
+			payload += fmt.Sprintf("\n\nFailed to send %s notification: %v", n.Name(), err)
 		}
 	}
+	FormatResult(workload, input, payload, payload, map[string]any{"price_drops": notifications})
 
-	if len(notifications) > 0 {
-		workload.Payload = []byte(fmt.Sprintf("Price drop alerts:\n%s", notifications))
-	} else {
-		workload.Payload = []byte("No price drops detected.")
+	// Only fail when nothing got through, so a retry doesn't resend alerts.
+	if len(notifiers) > 0 && len(failures) == len(notifiers) {
+		return fmt.Errorf("all %d notification channels failed", len(notifiers))
 	}
-
 	return nil
 }
+
+// formatPrice writes price with its currency. Products recorded before
+// currencies were tracked have none and are shown in dollars as before.
+func formatPrice(price float64, currency string) string {
+	if currency == "" || currency == "USD" {
+		return fmt.Sprintf("$%.2f", price)
+	}
+	return fmt.Sprintf("%.2f %s", price, currency)
+}
@@ -0,0 +1,54 @@
+package agents
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+
+	m "github.com/nieveai/d-agents/internal/models"
+	pb "github.com/nieveai/d-agents/proto"
+)
+
+// generateJSONList asks the model for a JSON array of items following
+// itemSchema and returns the array along with the model's raw answer. Clients
+// that can enforce a schema are asked for {"items": [...]}, since structured
+// output has to be an object. Otherwise the system prompt alone describes the
+// format and the array is scraped from the answer.
+func generateJSONList(ctx context.Context, genAIClient m.GenAIClient, workload *pb.Workload, input, systemPrompt string, itemSchema map[string]any) (json.RawMessage, string, error) {
+	if generator, ok := genAIClient.(m.StructuredGenerator); ok {
+		schema := map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"items": map[string]any{"type": "array", "items": itemSchema},
+			},
+			"required":             []string{"items"},
+			"additionalProperties": false,
+		}
+		raw, err := generator.GenerateStructured(ctx, workload, input, systemPrompt, schema)
+		if err == nil {
+			var list struct {
+				Items json.RawMessage `json:"items"`
+			}
+			if err := json.Unmarshal(raw, &list); err != nil {
+				return nil, "", fmt.Errorf("%w: failed to parse structured output: %w", m.ErrInvalidResponse, err)
+			}
+			return list.Items, string(raw), nil
+		}
+		if !errors.Is(err, m.ErrStructuredOutputNotSupported) {
+			return nil, "", fmt.Errorf("error generating content: %w", err)
+		}
+		log.Printf("%v, extracting the JSON from the answer instead", err)
+	}
+
+	llmResponse, err := genAIClient.GenerateContentWithSystemPrompt(ctx, workload, input, systemPrompt)
+	if err != nil {
+		return nil, "", fmt.Errorf("error generating content: %w", err)
+	}
+	jsonString := extractJSONArray(llmResponse)
+	if jsonString == "" {
+		return nil, llmResponse, fmt.Errorf("%w: no JSON array found in the LLM response", m.ErrInvalidResponse)
+	}
+	return json.RawMessage(jsonString), llmResponse, nil
+}
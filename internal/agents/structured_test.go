@@ -0,0 +1,104 @@
+package agents
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	m "github.com/nieveai/d-agents/internal/models"
+	"github.com/nieveai/d-agents/internal/testutil"
+	pb "github.com/nieveai/d-agents/proto"
+)
+
+// structuredClient is a fake client that can enforce a schema, answering
+// with structured or failing with err.
+type structuredClient struct {
+	*testutil.FakeGenAIClient
+	structured string
+	err        error
+	schemas    []any
+}
+
+func (c *structuredClient) GenerateStructured(ctx context.Context, workload *pb.Workload, input, systemPrompt string, schema any) (json.RawMessage, error) {
+	c.schemas = append(c.schemas, schema)
+	if c.err != nil {
+		return nil, c.err
+	}
+	return json.RawMessage(c.structured), nil
+}
+
+func TestGenerateJSONList(t *testing.T) {
+	itemSchema := map[string]any{"type": "string"}
+	tests := []struct {
+		name   string
+		client m.GenAIClient
+		want   string
+		// wantErr is in the error, which wraps m.ErrInvalidResponse.
+		wantErr string
+	}{
+		{
+			name:   "structured",
+			client: &structuredClient{FakeGenAIClient: testutil.NewFakeGenAIClient(), structured: `{"items": ["TSMC", "AMD"]}`},
+			want:   `["TSMC", "AMD"]`,
+		},
+		{
+			name: "structured output not supported",
+			client: &structuredClient{
+				FakeGenAIClient: testutil.NewFakeGenAIClient("Sure:\n```json\n[\"TSMC\"]\n```"),
+				err:             fmt.Errorf("%w: c1", m.ErrStructuredOutputNotSupported),
+			},
+			want: `["TSMC"]`,
+		},
+		{
+			name:   "client without structured output",
+			client: testutil.NewFakeGenAIClient(`The suppliers are ["TSMC"].`),
+			want:   `["TSMC"]`,
+		},
+		{
+			name:    "invalid structured output",
+			client:  &structuredClient{FakeGenAIClient: testutil.NewFakeGenAIClient(), structured: `{"items": [`},
+			wantErr: "failed to parse structured output",
+		},
+		{
+			name:    "no JSON in the answer",
+			client:  testutil.NewFakeGenAIClient("I don't know."),
+			wantErr: "no JSON array found",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			workload := &pb.Workload{Id: "s1", Models: []string{"m1"}}
+			list, _, err := generateJSONList(context.Background(), tt.client, workload, "Nvidia", "list suppliers", itemSchema)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) || !errors.Is(err, m.ErrInvalidResponse) {
+					t.Errorf("generateJSONList = %s, %v, want an invalid response error containing %q", list, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("generateJSONList: %v", err)
+			}
+			if string(list) != tt.want {
+				t.Errorf("generateJSONList = %s, want %s", list, tt.want)
+			}
+		})
+	}
+}
+
+func TestGenerateJSONListOtherErrors(t *testing.T) {
+	client := &structuredClient{FakeGenAIClient: testutil.NewFakeGenAIClient(`["not used"]`), err: errors.New("quota exceeded")}
+	_, _, err := generateJSONList(context.Background(), client, &pb.Workload{Id: "s1", Models: []string{"m1"}}, "Nvidia", "", map[string]any{"type": "string"})
+	if err == nil || !strings.Contains(err.Error(), "quota exceeded") {
+		t.Errorf("generateJSONList = %v, want the structured output error", err)
+	}
+	if len(client.Calls()) != 0 {
+		t.Error("fell back to scraping after an error that isn't about structured output")
+	}
+	schema, _ := client.schemas[0].(map[string]any)
+	if schema["type"] != "object" || schema["required"] == nil {
+		t.Errorf("schema = %v, want the list wrapped in an object", schema)
+	}
+}
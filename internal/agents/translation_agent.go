@@ -0,0 +1,115 @@
+package agents
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	m "github.com/nieveai/d-agents/internal/models"
+	pb "github.com/nieveai/d-agents/proto"
+)
+
+// TranslationConfig is the workload config understood by TranslationAgent,
+// e.g. {"target":"fr"}.
+type TranslationConfig struct {
+	Target string `json:"target"`
+}
+
+const defaultTranslationTarget = "en"
+
+// alreadyTranslatedMarker is what the model answers when no translation is needed.
+const alreadyTranslatedMarker = "ALREADY_IN_TARGET_LANGUAGE"
+
+const translationSystemPromptTemplate = `you are a professional translator. translate the user message into the language with code "%s". keep the markdown structure (headings, lists, tables, emphasis) exactly as it is. tokens of the form [[KEEP_n]] are code or links and must be copied unchanged. reply with the translation only. if the text is already written in that language, reply with exactly ` + alreadyTranslatedMarker + ` and nothing else.`
+
+// protectedPattern matches the parts of a markdown document that must not be
+// translated: fenced code blocks, inline code and URLs.
+var protectedPattern = regexp.MustCompile("(?s)```.*?```|`[^`\n]+`|https?://[^\\s)\\]>]+")
+
+type TranslationAgent struct{}
+
+func init() {
+	m.RegisterAgent("TranslationAgent", func() (m.AgentInterface, error) {
+		return &TranslationAgent{}, nil
+	})
+}
+
+func (a *TranslationAgent) DoWork(ctx context.Context, workload *pb.Workload, genAIClient m.GenAIClient) error {
+	if workload == nil {
+		return fmt.Errorf("workload is nil")
+	}
+	if genAIClient == nil {
+		return fmt.Errorf("genAIClient is nil")
+	}
+
+	config, err := parseTranslationConfig(workload.Config)
+	if err != nil {
+		return err
+	}
+
+	input := string(workload.Payload)
+	masked, protected := protectMarkdown(input)
+
+	systemPrompt := fmt.Sprintf(translationSystemPromptTemplate, config.Target)
+	if workload.DryRun {
+		workload.Payload = []byte(dryRunPreview(input,
+			previewStep{"System prompt", systemPrompt},
+			previewStep{"User message", masked},
+		))
+		return nil
+	}
+
+	llmResponse, err := genAIClient.GenerateContentWithSystemPrompt(ctx, workload, masked, systemPrompt)
+	if err != nil {
+		return fmt.Errorf("error generating content: %w", err)
+	}
+
+	var result string
+	if strings.TrimSpace(llmResponse) == alreadyTranslatedMarker {
+		result = fmt.Sprintf("_Text is already in the target language (%s), nothing to translate._", config.Target)
+	} else {
+		result = restoreMarkdown(llmResponse, protected)
+	}
+
+	newPayload := fmt.Sprintf("%s\n\n---\n\n%s", input, result)
+	FormatResult(workload, input, result, newPayload, map[string]any{"target": config.Target})
+
+	return nil
+}
+
+// parseTranslationConfig reads the target language from the workload config,
+// defaulting to English when the config is empty.
+func parseTranslationConfig(config string) (TranslationConfig, error) {
+	var cfg TranslationConfig
+	if strings.TrimSpace(config) != "" {
+		if err := json.Unmarshal([]byte(config), &cfg); err != nil {
+			return cfg, fmt.Errorf("invalid translation config: %w", err)
+		}
+	}
+	cfg.Target = strings.TrimSpace(cfg.Target)
+	if cfg.Target == "" {
+		cfg.Target = defaultTranslationTarget
+	}
+	return cfg, nil
+}
+
+// protectMarkdown replaces code and URLs with [[KEEP_n]] placeholders so the
+// model can't alter them, returning the masked text and the original snippets.
+func protectMarkdown(s string) (string, []string) {
+	var protected []string
+	masked := protectedPattern.ReplaceAllStringFunc(s, func(match string) string {
+		protected = append(protected, match)
+		return fmt.Sprintf("[[KEEP_%d]]", len(protected)-1)
+	})
+	return masked, protected
+}
+
+// restoreMarkdown puts the snippets removed by protectMarkdown back in place.
+func restoreMarkdown(s string, protected []string) string {
+	for i := len(protected) - 1; i >= 0; i-- {
+		s = strings.ReplaceAll(s, fmt.Sprintf("[[KEEP_%d]]", i), protected[i])
+	}
+	return s
+}
@@ -0,0 +1,100 @@
+package agents
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/nieveai/d-agents/internal/testutil"
+	pb "github.com/nieveai/d-agents/proto"
+)
+
+func TestParseTranslationConfig(t *testing.T) {
+	tests := []struct {
+		config  string
+		want    string
+		wantErr bool
+	}{
+		{"", "en", false},
+		{"   ", "en", false},
+		{`{}`, "en", false},
+		{`{"target": ""}`, "en", false},
+		{`{"target": "fr"}`, "fr", false},
+		{`{"target": " de "}`, "de", false},
+		{`{"target": `, "", true},
+	}
+	for _, tt := range tests {
+		cfg, err := parseTranslationConfig(tt.config)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseTranslationConfig(%q) error = %v, wantErr %v", tt.config, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && cfg.Target != tt.want {
+			t.Errorf("parseTranslationConfig(%q) target = %q, want %q", tt.config, cfg.Target, tt.want)
+		}
+	}
+}
+
+func TestProtectMarkdown(t *testing.T) {
+	input := "# Title\n\nRun `go test` first, see https://example.com/docs.\n\n```go\nfmt.Println(\"hello\")\n```\n"
+	masked, protected := protectMarkdown(input)
+	for _, snippet := range []string{"`go test`", "https://example.com/docs.", "fmt.Println"} {
+		if strings.Contains(masked, snippet) {
+			t.Errorf("masked text still contains %q: %q", snippet, masked)
+		}
+	}
+	if len(protected) != 3 {
+		t.Errorf("protected %d snippets, want 3: %q", len(protected), protected)
+	}
+	if got := restoreMarkdown(masked, protected); got != input {
+		t.Errorf("restoreMarkdown = %q, want the input back", got)
+	}
+}
+
+func TestTranslationAgent(t *testing.T) {
+	input := "Hello, run `make` and read https://example.com.\n\n```sh\necho hello\n```"
+	tests := []struct {
+		name   string
+		config string
+		answer func(call testutil.Call) testutil.Response
+		target string
+		want   string
+	}{
+		{
+			name:   "translates and keeps code",
+			config: `{"target": "fr"}`,
+			// The model translates the prose and copies the placeholders.
+			answer: func(call testutil.Call) testutil.Response {
+				return testutil.Response{Text: strings.Replace(call.Input, "Hello, run", "Bonjour, lancez", 1)}
+			},
+			target: "fr",
+			want:   "Bonjour, lancez `make` and read https://example.com.\n\n```sh\necho hello\n```",
+		},
+		{
+			name:   "already in the target language",
+			answer: func(testutil.Call) testutil.Response { return testutil.Response{Text: alreadyTranslatedMarker + "\n"} },
+			target: "en",
+			want:   "_Text is already in the target language (en), nothing to translate._",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := testutil.NewFakeGenAIClient().RespondWith(tt.answer)
+			workload := &pb.Workload{Id: "s1", Models: []string{"m1"}, Payload: []byte(input), Config: tt.config}
+			if err := (&TranslationAgent{}).DoWork(context.Background(), workload, client); err != nil {
+				t.Fatalf("DoWork: %v", err)
+			}
+
+			call, _ := client.LastCall()
+			if strings.Contains(call.Input, "echo hello") || strings.Contains(call.Input, "https://") {
+				t.Errorf("code or URLs were sent to the model: %q", call.Input)
+			}
+			if !strings.Contains(call.SystemPrompt, `"`+tt.target+`"`) {
+				t.Errorf("system prompt doesn't ask for %q: %q", tt.target, call.SystemPrompt)
+			}
+			if got, want := string(workload.Payload), input+"\n\n---\n\n"+tt.want; got != want {
+				t.Errorf("payload = %q, want %q", got, want)
+			}
+		})
+	}
+}
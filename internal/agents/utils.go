@@ -2,15 +2,36 @@ package agents
 
 import (
 	"context"
+	"fmt"
 	"regexp"
+	"strings"
+	"sync"
 
-	"github.com/chromedp/chromedp"
+	"github.com/nieveai/d-agents/internal/browser"
+	"github.com/nieveai/d-agents/internal/textutil"
 )
 
 // extractJSONArray finds and extracts the first JSON array from a string.
 func extractJSONArray(s string) string {
-	re := regexp.MustCompile(`(?s)\[.*\]`)
-	return re.FindString(s)
+	return extractJSON(s, '[')
+}
+
+// extractJSONObject finds and extracts the first JSON object from a string.
+func extractJSONObject(s string) string {
+	return extractJSON(s, '{')
+}
+
+// extractJSON returns the first valid, balanced JSON value starting with open
+// ('[' or '{'). Fenced code blocks are searched first, since models often wrap
+// their answer in one and put prose with stray brackets around it. Models with
+// ResponseCleaners have their answer cleaned before it gets here.
+func extractJSON(s string, open byte) string {
+	for _, block := range textutil.FencedBlocks(s) {
+		if found := textutil.ScanJSON(block, open); found != "" {
+			return found
+		}
+	}
+	return textutil.ScanJSON(s, open)
 }
 
 // extractURL finds the first URL in a string.
@@ -19,19 +40,53 @@ func extractURL(s string) string {
 	return re.FindString(s)
 }
 
-// getHTMLFromURL uses chromedp to get the HTML content of a URL.
-func getHTMLFromURL(url string) (string, error) {
-	ctx, cancel := chromedp.NewContext(context.Background())
-	defer cancel()
+var (
+	browserPool     *browser.BrowserPool
+	browserPoolLock sync.Mutex
+)
+
+// SetBrowserPool sets the browser the agents scrape pages with. The caller
+// owns the pool and closes it. Without one, a default pool is created on first use.
+func SetBrowserPool(pool *browser.BrowserPool) {
+	browserPoolLock.Lock()
+	defer browserPoolLock.Unlock()
+	browserPool = pool
+}
+
+func getBrowserPool() *browser.BrowserPool {
+	browserPoolLock.Lock()
+	defer browserPoolLock.Unlock()
+	if browserPool == nil {
+		browserPool = browser.NewBrowserPool(browser.DefaultOptions())
+	}
+	return browserPool
+}
+
+// getHTMLFromURL gets the HTML content of a URL using the shared browser.
+func getHTMLFromURL(ctx context.Context, url string) (string, error) {
+	return getBrowserPool().Fetch(ctx, url)
+}
+
+// previewStep is one thing an agent would have done in a dry run.
+type previewStep struct {
+	Title string
+	Body  string
+}
 
-	var res string
-	err := chromedp.Run(ctx,
-		chromedp.Navigate(url),
-		chromedp.Evaluate(`document.querySelectorAll('head, script, style, link').forEach(el => el.remove());`, nil),
-		chromedp.OuterHTML("html", &res),
-	)
-	if err != nil {
-		return "", err
+// dryRunPreview appends a markdown report of the steps an agent would have
+// taken to the input, for workloads with DryRun set.
+func dryRunPreview(input string, steps ...previewStep) string {
+	var builder strings.Builder
+	builder.WriteString(input)
+	builder.WriteString(transcriptSeparator)
+	builder.WriteString("**Dry run:** nothing was sent to a model or written to a store.\n")
+	for _, step := range steps {
+		// The fence has to be longer than any backtick run in the body.
+		fence := "```"
+		for strings.Contains(step.Body, fence) {
+			fence += "`"
+		}
+		fmt.Fprintf(&builder, "\n### %s\n\n%s\n%s\n%s\n", step.Title, fence, step.Body, fence)
 	}
-	return res, nil
+	return builder.String()
 }
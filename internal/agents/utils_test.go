@@ -0,0 +1,47 @@
+package agents
+
+import "testing"
+
+func TestExtractJSONArray(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+	}{
+		{"plain", `[1, 2]`, `[1, 2]`},
+		{"nested arrays", `here: [[1, [2]], [3]] done`, `[[1, [2]], [3]]`},
+		{"trailing prose with brackets", `[{"a": 1}] see [note] below`, `[{"a": 1}]`},
+		{"leading prose with brackets", `As listed [above], the answer is ["x"]`, `["x"]`},
+		{"multiple arrays", `["first"] and ["second"]`, `["first"]`},
+		{"brackets in strings", `["a ] b", "c [ d"]`, `["a ] b", "c [ d"]`},
+		{"escaped quote", `["say \"]\" twice"]`, `["say \"]\" twice"]`},
+		{"fenced", "Sure [1]:\n```json\n[{\"name\": \"TSMC\"}]\n```\n", `[{"name": "TSMC"}]`},
+		{"inside an object", `{"items": [1, 2]}`, `[1, 2]`},
+		{"unbalanced", `[1, 2`, ""},
+		{"none", `no JSON here`, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := extractJSONArray(tt.in); got != tt.want {
+				t.Errorf("extractJSONArray(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractJSONObject(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+	}{
+		{"plain", `{"a": 1}`, `{"a": 1}`},
+		{"nested", `ok {"a": {"b": [1, {"c": 2}]}} bye`, `{"a": {"b": [1, {"c": 2}]}}`},
+		{"prose braces first", `Use {placeholders} like {"a": 1}`, `{"a": 1}`},
+		{"mismatched brackets", `{"a": [1}`, ""},
+		{"fenced beats earlier JSON", "{\"draft\": true}\n```\n{\"final\": true}\n```", `{"final": true}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := extractJSONObject(tt.in); got != tt.want {
+				t.Errorf("extractJSONObject(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
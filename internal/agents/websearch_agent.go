@@ -0,0 +1,363 @@
+package agents
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	m "github.com/nieveai/d-agents/internal/models"
+	"github.com/nieveai/d-agents/internal/textutil"
+	pb "github.com/nieveai/d-agents/proto"
+)
+
+// SearchResult is one hit of a web search.
+type SearchResult struct {
+	Title   string `json:"title"`
+	URL     string `json:"url"`
+	Snippet string `json:"snippet,omitempty"`
+}
+
+// Searcher queries a web search API.
+type Searcher interface {
+	Search(ctx context.Context, query string, n int) ([]SearchResult, error)
+}
+
+// WebSearchAgent answers the question in the payload from web pages: it
+// searches for it, reads the top results and has the workload's model write
+// an answer citing them. Unlike Gemini's search grounding it works with any
+// model.
+type WebSearchAgent struct {
+	// Searcher, if set, is used instead of the search API in the workload config.
+	Searcher Searcher
+	// Fetch gets the page at a URL. It defaults to the shared browser.
+	Fetch func(ctx context.Context, url string) (string, error)
+	progress
+}
+
+func init() {
+	m.RegisterAgent("WebSearchAgent", func() (m.AgentInterface, error) {
+		return &WebSearchAgent{}, nil
+	})
+}
+
+// WebSearchConfig is the workload config understood by WebSearchAgent.
+type WebSearchConfig struct {
+	// SearchEngine is searxng, brave or serpapi.
+	SearchEngine string `json:"search_engine"`
+	// SearchURL is the endpoint of the search API. Brave and SerpAPI default
+	// to their public ones; SearxNG needs the URL of an instance.
+	SearchURL    string `json:"search_url,omitempty"`
+	SearchAPIKey string `json:"search_api_key,omitempty"`
+	MaxResults   int    `json:"max_results,omitempty"`
+}
+
+const (
+	defaultMaxSearchResults = 3
+	// maxPageRunes caps how much of each page is sent to the model.
+	maxPageRunes  = 20000
+	searchTimeout = 15 * time.Second
+
+	defaultBraveURL   = "https://api.search.brave.com/res/v1/web/search"
+	defaultSerpAPIURL = "https://serpapi.com/search.json"
+)
+
+const webSearchSystemPrompt = `Answer the user's question using the web pages below. If they don't contain the answer, say so instead of guessing. Cite the pages you used by their number, e.g. [2].
+
+Pages:
+`
+
+const noSearchSystemPrompt = `Answer the user's question. Web search was unavailable, so answer from what you know and say when you are unsure or the answer may be out of date.`
+
+func parseWebSearchConfig(config string) (WebSearchConfig, error) {
+	var cfg WebSearchConfig
+	if strings.TrimSpace(config) != "" {
+		if err := json.Unmarshal([]byte(config), &cfg); err != nil {
+			return cfg, fmt.Errorf("invalid WebSearchAgent config: %w", err)
+		}
+	}
+	if cfg.MaxResults <= 0 {
+		cfg.MaxResults = defaultMaxSearchResults
+	}
+	return cfg, nil
+}
+
+// searcher returns the search API the config describes.
+func (c WebSearchConfig) searcher() (Searcher, error) {
+	client := &http.Client{Timeout: searchTimeout}
+	switch c.SearchEngine {
+	case "searxng":
+		if c.SearchURL == "" {
+			return nil, fmt.Errorf("searxng needs a search_url")
+		}
+		return &SearxNGSearcher{URL: c.SearchURL, Client: client}, nil
+	case "brave":
+		if c.SearchAPIKey == "" {
+			return nil, fmt.Errorf("brave needs a search_api_key")
+		}
+		return &BraveSearcher{URL: c.SearchURL, APIKey: c.SearchAPIKey, Client: client}, nil
+	case "serpapi":
+		if c.SearchAPIKey == "" {
+			return nil, fmt.Errorf("serpapi needs a search_api_key")
+		}
+		return &SerpAPISearcher{URL: c.SearchURL, APIKey: c.SearchAPIKey, Client: client}, nil
+	case "":
+		return nil, fmt.Errorf("no search_engine configured")
+	}
+	return nil, fmt.Errorf("unknown search_engine %q, want searxng, brave or serpapi", c.SearchEngine)
+}
+
+func (a *WebSearchAgent) DoWork(ctx context.Context, workload *pb.Workload, genAIClient m.GenAIClient) error {
+	if workload == nil {
+		return fmt.Errorf("workload is nil")
+	}
+	if genAIClient == nil {
+		return fmt.Errorf("genAIClient is nil")
+	}
+	question := strings.TrimSpace(string(workload.Payload))
+	if question == "" {
+		return fmt.Errorf("payload has no question")
+	}
+	cfg, err := parseWebSearchConfig(workload.Config)
+	if err != nil {
+		return err
+	}
+
+	if workload.DryRun {
+		engine := cfg.SearchEngine
+		if a.Searcher != nil {
+			engine = "the searcher set on the agent"
+		}
+		workload.Payload = []byte(dryRunPreview(question,
+			previewStep{"Search", fmt.Sprintf("search %s for the question and read the top %d pages", engine, cfg.MaxResults)},
+			previewStep{"System prompt", webSearchSystemPrompt + "<pages>"},
+			previewStep{"User message", question},
+		))
+		return nil
+	}
+
+	pages, searchErr := a.search(ctx, cfg, question)
+	a.reportProgress(workload, 50)
+
+	var answer string
+	if searchErr != nil {
+		log.Printf("WebSearchAgent: %v, answering without search", searchErr)
+		answer, err = genAIClient.GenerateContentWithSystemPrompt(ctx, workload, question, noSearchSystemPrompt)
+		if err != nil {
+			return fmt.Errorf("error generating content: %w", err)
+		}
+		answer = fmt.Sprintf("_Web search failed (%v), this answer is from the model alone._\n\n%s", searchErr, answer)
+	} else {
+		answer, err = genAIClient.GenerateContentWithSystemPrompt(ctx, workload, question, webSearchSystemPrompt+formatPages(pages))
+		if err != nil {
+			return fmt.Errorf("error generating content: %w", err)
+		}
+	}
+
+	report := answer + formatSources(pages)
+	metadata := map[string]any{"sources": pages}
+	if searchErr != nil {
+		metadata["search_error"] = searchErr.Error()
+	}
+	FormatResult(workload, question, answer, question+transcriptSeparator+report, metadata)
+	return nil
+}
+
+// searchPage is a search result with the text read from it.
+type searchPage struct {
+	SearchResult
+	Content string `json:"-"`
+}
+
+// search runs the query and fetches the pages found. Pages that can't be
+// fetched are kept with their snippet; only a failed or empty search is an
+// error.
+func (a *WebSearchAgent) search(ctx context.Context, cfg WebSearchConfig, query string) ([]searchPage, error) {
+	searcher := a.Searcher
+	if searcher == nil {
+		var err error
+		if searcher, err = cfg.searcher(); err != nil {
+			return nil, err
+		}
+	}
+	results, err := searcher.Search(ctx, query, cfg.MaxResults)
+	if err != nil {
+		return nil, fmt.Errorf("search failed: %w", err)
+	}
+	if len(results) == 0 {
+		return nil, fmt.Errorf("search found nothing")
+	}
+	if len(results) > cfg.MaxResults {
+		results = results[:cfg.MaxResults]
+	}
+
+	fetch := a.Fetch
+	if fetch == nil {
+		fetch = getHTMLFromURL
+	}
+	pages := make([]searchPage, len(results))
+	for i, result := range results {
+		pages[i] = searchPage{SearchResult: result, Content: result.Snippet}
+		content, err := fetch(ctx, result.URL)
+		if err != nil {
+			log.Printf("WebSearchAgent: using the snippet of %s: %v", result.URL, err)
+			continue
+		}
+		pages[i].Content = textutil.TruncateRunes(textutil.CleanHTML(content), maxPageRunes)
+	}
+	return pages, nil
+}
+
+// formatPages numbers the pages so the model can cite them.
+func formatPages(pages []searchPage) string {
+	var builder strings.Builder
+	for i, page := range pages {
+		fmt.Fprintf(&builder, "[%d] %s (%s)\n%s\n\n", i+1, page.Title, page.URL, page.Content)
+	}
+	return builder.String()
+}
+
+// formatSources lists the pages under the answer, numbered as cited.
+func formatSources(pages []searchPage) string {
+	if len(pages) == 0 {
+		return ""
+	}
+	var builder strings.Builder
+	builder.WriteString("\n\n**Sources:**\n")
+	for i, page := range pages {
+		title := page.Title
+		if title == "" {
+			title = page.URL
+		}
+		fmt.Fprintf(&builder, "%d. [%s](%s)\n", i+1, title, page.URL)
+	}
+	return builder.String()
+}
+
+// SearxNGSearcher uses the JSON API of a SearxNG instance, which must have
+// the json format enabled.
+type SearxNGSearcher struct {
+	URL    string
+	Client *http.Client
+}
+
+func (s *SearxNGSearcher) Search(ctx context.Context, query string, n int) ([]SearchResult, error) {
+	var resp struct {
+		Results []struct {
+			Title   string `json:"title"`
+			URL     string `json:"url"`
+			Content string `json:"content"`
+		} `json:"results"`
+	}
+	endpoint := strings.TrimSuffix(s.URL, "/") + "/search"
+	if err := getJSON(ctx, s.Client, endpoint, url.Values{"q": {query}, "format": {"json"}}, nil, &resp); err != nil {
+		return nil, err
+	}
+	var results []SearchResult
+	for _, r := range resp.Results {
+		results = append(results, SearchResult{Title: r.Title, URL: r.URL, Snippet: r.Content})
+	}
+	return results, nil
+}
+
+// BraveSearcher uses the Brave Search API.
+type BraveSearcher struct {
+	URL    string
+	APIKey string
+	Client *http.Client
+}
+
+func (s *BraveSearcher) Search(ctx context.Context, query string, n int) ([]SearchResult, error) {
+	endpoint := s.URL
+	if endpoint == "" {
+		endpoint = defaultBraveURL
+	}
+	var resp struct {
+		Web struct {
+			Results []struct {
+				Title       string `json:"title"`
+				URL         string `json:"url"`
+				Description string `json:"description"`
+			} `json:"results"`
+		} `json:"web"`
+	}
+	header := http.Header{"X-Subscription-Token": {s.APIKey}, "Accept": {"application/json"}}
+	if err := getJSON(ctx, s.Client, endpoint, url.Values{"q": {query}, "count": {strconv.Itoa(n)}}, header, &resp); err != nil {
+		return nil, err
+	}
+	var results []SearchResult
+	for _, r := range resp.Web.Results {
+		results = append(results, SearchResult{Title: r.Title, URL: r.URL, Snippet: r.Description})
+	}
+	return results, nil
+}
+
+// SerpAPISearcher uses SerpAPI's Google search.
+type SerpAPISearcher struct {
+	URL    string
+	APIKey string
+	Client *http.Client
+}
+
+func (s *SerpAPISearcher) Search(ctx context.Context, query string, n int) ([]SearchResult, error) {
+	endpoint := s.URL
+	if endpoint == "" {
+		endpoint = defaultSerpAPIURL
+	}
+	var resp struct {
+		OrganicResults []struct {
+			Title   string `json:"title"`
+			Link    string `json:"link"`
+			Snippet string `json:"snippet"`
+		} `json:"organic_results"`
+	}
+	params := url.Values{"engine": {"google"}, "q": {query}, "num": {strconv.Itoa(n)}, "api_key": {s.APIKey}}
+	if err := getJSON(ctx, s.Client, endpoint, params, nil, &resp); err != nil {
+		return nil, err
+	}
+	var results []SearchResult
+	for _, r := range resp.OrganicResults {
+		results = append(results, SearchResult{Title: r.Title, URL: r.Link, Snippet: r.Snippet})
+	}
+	return results, nil
+}
+
+// getJSON GETs endpoint with params and decodes the JSON response into v.
+func getJSON(ctx context.Context, client *http.Client, endpoint string, params url.Values, header http.Header, v any) error {
+	if client == nil {
+		client = &http.Client{Timeout: searchTimeout}
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint+"?"+params.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		// The URL carries the API key of some engines.
+		return fmt.Errorf("request to %s failed: %w", endpoint, unwrapURLError(err))
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("request to %s returned %s", endpoint, resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("invalid response from %s: %w", endpoint, err)
+	}
+	return nil
+}
+
+// unwrapURLError drops the *url.Error wrapper, and with it the request URL.
+func unwrapURLError(err error) error {
+	if urlErr, ok := err.(*url.Error); ok {
+		return urlErr.Err
+	}
+	return err
+}
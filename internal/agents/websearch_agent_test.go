@@ -0,0 +1,180 @@
+package agents
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/nieveai/d-agents/internal/testutil"
+	pb "github.com/nieveai/d-agents/proto"
+)
+
+// newFakeSearxNG serves SearxNG's JSON API with a result per page given.
+func newFakeSearxNG(t *testing.T, status int, urls ...string) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/search" || r.URL.Query().Get("format") != "json" || r.URL.Query().Get("q") == "" {
+			t.Errorf("unexpected search request %s", r.URL)
+		}
+		w.WriteHeader(status)
+		var results []string
+		for i, u := range urls {
+			results = append(results, fmt.Sprintf(`{"title": "Result %d", "url": %q, "content": "snippet %d"}`, i+1, u, i+1))
+		}
+		fmt.Fprintf(w, `{"results": [%s]}`, strings.Join(results, ","))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestWebSearchAgent(t *testing.T) {
+	search := newFakeSearxNG(t, http.StatusOK, "https://a.example/tsmc", "https://b.example/down", "https://c.example/more", "https://d.example/extra")
+	var fetched []string
+	agent := &WebSearchAgent{Fetch: func(ctx context.Context, url string) (string, error) {
+		fetched = append(fetched, url)
+		if strings.Contains(url, "down") {
+			return "", errors.New("timed out")
+		}
+		return "<html><script>tracking()</script><body><p>TSMC makes chips at " + url + "</p></body></html>", nil
+	}}
+	client := testutil.NewFakeGenAIClient("TSMC makes Nvidia's chips [1].")
+	workload := &pb.Workload{Id: "s1", Models: []string{"m1"}, Payload: []byte("Who makes Nvidia's chips?"),
+		Config: fmt.Sprintf(`{"search_engine": "searxng", "search_url": %q}`, search.URL)}
+
+	if err := agent.DoWork(context.Background(), workload, client); err != nil {
+		t.Fatalf("DoWork: %v", err)
+	}
+
+	// Only the top 3 results are read.
+	if len(fetched) != 3 {
+		t.Errorf("fetched %q, want the top 3 results", fetched)
+	}
+	call, _ := client.LastCall()
+	for _, want := range []string{
+		"[1] Result 1 (https://a.example/tsmc)\nTSMC makes chips at https://a.example/tsmc",
+		// A page that can't be fetched is given by its snippet.
+		"[2] Result 2 (https://b.example/down)\nsnippet 2",
+		"[3] Result 3",
+	} {
+		if !strings.Contains(call.SystemPrompt, want) {
+			t.Errorf("system prompt doesn't contain %q:\n%s", want, call.SystemPrompt)
+		}
+	}
+	if strings.Contains(call.SystemPrompt, "tracking()") || strings.Contains(call.SystemPrompt, "Result 4") {
+		t.Errorf("system prompt has scripts or more than 3 pages:\n%s", call.SystemPrompt)
+	}
+	if call.Input != "Who makes Nvidia's chips?" {
+		t.Errorf("user message = %q, want the question", call.Input)
+	}
+	payload := string(workload.Payload)
+	if !strings.Contains(payload, "TSMC makes Nvidia's chips [1].\n\n**Sources:**\n1. [Result 1](https://a.example/tsmc)\n") {
+		t.Errorf("payload = %q, want the answer and its sources", payload)
+	}
+}
+
+func TestWebSearchAgentSearchFails(t *testing.T) {
+	tests := []struct {
+		name   string
+		server *httptest.Server
+		want   string
+	}{
+		{"error status", newFakeSearxNG(t, http.StatusServiceUnavailable), "503"},
+		{"no results", newFakeSearxNG(t, http.StatusOK), "search found nothing"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			agent := &WebSearchAgent{Fetch: func(ctx context.Context, url string) (string, error) {
+				t.Errorf("fetched %s after the search failed", url)
+				return "", nil
+			}}
+			client := testutil.NewFakeGenAIClient("Probably TSMC.")
+			workload := &pb.Workload{Id: "s1", Models: []string{"m1"}, Payload: []byte("Who makes Nvidia's chips?"),
+				Config: fmt.Sprintf(`{"search_engine": "searxng", "search_url": %q}`, tt.server.URL)}
+
+			if err := agent.DoWork(context.Background(), workload, client); err != nil {
+				t.Fatalf("DoWork: %v", err)
+			}
+			call, _ := client.LastCall()
+			if call.SystemPrompt != noSearchSystemPrompt {
+				t.Errorf("system prompt = %q, want the one for answering without search", call.SystemPrompt)
+			}
+			payload := string(workload.Payload)
+			if !strings.Contains(payload, "_Web search failed (") || !strings.Contains(payload, tt.want) || !strings.HasSuffix(payload, "Probably TSMC.") {
+				t.Errorf("payload = %q, want the answer with a note about the search", payload)
+			}
+		})
+	}
+}
+
+func TestWebSearchConfigErrors(t *testing.T) {
+	for config, want := range map[string]string{
+		`{"search_engine": "bing"}`:    "unknown search_engine",
+		`{"search_engine": "searxng"}`: "search_url",
+		`{"search_engine": "brave"}`:   "search_api_key",
+		`{"search_engine": "serpapi"}`: "search_api_key",
+	} {
+		cfg, err := parseWebSearchConfig(config)
+		if err != nil {
+			t.Fatalf("parseWebSearchConfig(%s): %v", config, err)
+		}
+		if _, err := cfg.searcher(); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("searcher of %s = %v, want an error about %s", config, err, want)
+		}
+	}
+}
+
+func TestSearchers(t *testing.T) {
+	tests := []struct {
+		name     string
+		response string
+		searcher func(url string) Searcher
+		check    func(t *testing.T, r *http.Request)
+	}{
+		{
+			name:     "brave",
+			response: `{"web": {"results": [{"title": "TSMC", "url": "https://tsmc.example", "description": "foundry"}]}}`,
+			searcher: func(url string) Searcher { return &BraveSearcher{URL: url, APIKey: "brave-key"} },
+			check: func(t *testing.T, r *http.Request) {
+				if r.Header.Get("X-Subscription-Token") != "brave-key" || r.URL.Query().Get("count") != "5" {
+					t.Errorf("request %s with token %q", r.URL, r.Header.Get("X-Subscription-Token"))
+				}
+			},
+		},
+		{
+			name:     "serpapi",
+			response: `{"organic_results": [{"title": "TSMC", "link": "https://tsmc.example", "snippet": "foundry"}]}`,
+			searcher: func(url string) Searcher { return &SerpAPISearcher{URL: url, APIKey: "serp-key"} },
+			check: func(t *testing.T, r *http.Request) {
+				q := r.URL.Query()
+				if q.Get("api_key") != "serp-key" || q.Get("engine") != "google" || q.Get("num") != "5" {
+					t.Errorf("request %s", r.URL)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Query().Get("q") != "who makes chips" {
+					t.Errorf("query = %q", r.URL.Query().Get("q"))
+				}
+				tt.check(t, r)
+				w.Write([]byte(tt.response))
+			}))
+			defer server.Close()
+
+			results, err := tt.searcher(server.URL).Search(context.Background(), "who makes chips", 5)
+			if err != nil {
+				t.Fatalf("Search: %v", err)
+			}
+			want := SearchResult{Title: "TSMC", URL: "https://tsmc.example", Snippet: "foundry"}
+			if len(results) != 1 || results[0] != want {
+				t.Errorf("Search = %+v, want %+v", results, want)
+			}
+		})
+	}
+}
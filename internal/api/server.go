@@ -0,0 +1,506 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nieveai/d-agents/internal/agents"
+	"github.com/nieveai/d-agents/internal/database"
+	"github.com/nieveai/d-agents/internal/models"
+	"github.com/nieveai/d-agents/internal/scheduler"
+	pb "github.com/nieveai/d-agents/proto"
+)
+
+// Server is a REST API over the datastore. Sessions created through it are
+// handed to the workers on the queue.
+type Server struct {
+	db    database.Datastore
+	queue Queue
+
+	// OnModelsChanged, if set, is called with all models after one is added,
+	// updated or deleted, so the LLM client can be rebuilt.
+	OnModelsChanged func(models []*models.Model)
+	// CancelSession, if set, backs POST /sessions/{id}/cancel, usually
+	// scheduler.Cancel so the session is unscheduled too.
+	CancelSession func(id string) error
+}
+
+// Queue is where sessions go to be run, a worker.Queue.
+type Queue interface {
+	// TryPush queues the workload unless the queue is full.
+	TryPush(workload *pb.Workload) bool
+}
+
+func NewServer(db database.Datastore, queue Queue) *Server {
+	return &Server{db: db, queue: queue}
+}
+
+// CreateSessionRequest is the body of POST /sessions. AgentType can be left
+// out when AgentID names a registered agent, and Models when the
+// default_model setting is set.
+type CreateSessionRequest struct {
+	Name      string   `json:"name"`
+	AgentID   string   `json:"agent_id"`
+	AgentType string   `json:"agent_type"`
+	Models    []string `json:"models"`
+	Payload   string   `json:"payload"`
+	Config    string   `json:"config,omitempty"`
+	Pipeline  []string `json:"pipeline,omitempty"`
+	// FallbackModels are tried in order when the first model fails.
+	FallbackModels []string `json:"fallback_models,omitempty"`
+	// DryRun makes the agent report what it would send and store instead.
+	DryRun bool `json:"dry_run,omitempty"`
+	// Priority orders the session in the queue, higher first.
+	Priority int32 `json:"priority,omitempty"`
+	// OutputFormat is markdown, json or plain and replaces any output_format
+	// in Config. It defaults to json, a Result envelope.
+	OutputFormat string `json:"output_format,omitempty"`
+	// Tags label the session, see GET /sessions?tag=.
+	Tags []string `json:"tags,omitempty"`
+}
+
+// Session is the JSON form of a session.
+type Session struct {
+	ID               string   `json:"id"`
+	Name             string   `json:"name"`
+	AgentID          string   `json:"agent_id,omitempty"`
+	AgentType        string   `json:"agent_type"`
+	Models           []string `json:"models"`
+	Payload          string   `json:"payload"`
+	Config           string   `json:"config,omitempty"`
+	Pipeline         []string `json:"pipeline,omitempty"`
+	FallbackModels   []string `json:"fallback_models,omitempty"`
+	DryRun           bool     `json:"dry_run,omitempty"`
+	Stage            string   `json:"stage,omitempty"`
+	Progress         int32    `json:"progress"`
+	Priority         int32    `json:"priority"`
+	Tags             []string `json:"tags"`
+	Status           string   `json:"status"`
+	Error            string   `json:"error,omitempty"`
+	Timestamp        int64    `json:"timestamp"`
+	PromptTokens     int64    `json:"prompt_tokens"`
+	CompletionTokens int64    `json:"completion_tokens"`
+	EstimatedCost    float64  `json:"estimated_cost"`
+}
+
+func toSession(w *pb.Workload) Session {
+	return Session{
+		ID:               w.Id,
+		Name:             w.Name,
+		AgentID:          w.AgentId,
+		AgentType:        w.AgentType,
+		Models:           w.Models,
+		Payload:          string(w.Payload),
+		Config:           w.Config,
+		Pipeline:         w.Pipeline,
+		FallbackModels:   w.FallbackModels,
+		DryRun:           w.DryRun,
+		Stage:            w.Stage,
+		Progress:         w.Progress,
+		Priority:         w.Priority,
+		Tags:             w.Tags,
+		Status:           w.Status.String(),
+		Error:            w.Error,
+		Timestamp:        w.Timestamp,
+		PromptTokens:     w.PromptTokens,
+		CompletionTokens: w.CompletionTokens,
+		EstimatedCost:    w.EstimatedCost,
+	}
+}
+
+// Handler returns the routes of the API.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /sessions", s.createSession)
+	mux.HandleFunc("GET /sessions", s.listSessions)
+	mux.HandleFunc("GET /sessions/{id}", s.getSession)
+	mux.HandleFunc("POST /sessions/{id}/cancel", s.cancelSession)
+	mux.HandleFunc("PUT /sessions/{id}/tags/{tag}", s.tagSession)
+	mux.HandleFunc("DELETE /sessions/{id}/tags/{tag}", s.untagSession)
+
+	mux.HandleFunc("GET /agents", s.listAgents)
+	mux.HandleFunc("POST /agents", s.putAgent)
+	mux.HandleFunc("GET /agents/{id}", s.getAgent)
+	mux.HandleFunc("PUT /agents/{id}", s.putAgent)
+	mux.HandleFunc("DELETE /agents/{id}", s.deleteAgent)
+
+	mux.HandleFunc("GET /models", s.listModels)
+	mux.HandleFunc("POST /models", s.putModel)
+	mux.HandleFunc("GET /models/{id}", s.getModel)
+	mux.HandleFunc("PUT /models/{id}", s.putModel)
+	mux.HandleFunc("DELETE /models/{id}", s.deleteModel)
+	return mux
+}
+
+func (s *Server) createSession(w http.ResponseWriter, r *http.Request) {
+	var req CreateSessionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+		return
+	}
+
+	if req.AgentID != "" && req.AgentType == "" {
+		agent, err := s.db.GetAgent(req.AgentID)
+		if err != nil {
+			writeReferenceError(w, "agent", req.AgentID, err)
+			return
+		}
+		req.AgentType = agent.Type
+	}
+	registered := models.RegisteredAgentTypes()
+	for _, agentType := range append([]string{req.AgentType}, req.Pipeline...) {
+		if !slices.Contains(registered, agentType) {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("unknown agent type: %q", agentType))
+			return
+		}
+	}
+	if len(req.Models) == 0 {
+		defaultModel, err := database.StringSetting(s.db, database.SettingDefaultModel, "")
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		if defaultModel == "" {
+			writeError(w, http.StatusBadRequest, errors.New("at least one model is required"))
+			return
+		}
+		req.Models = strings.Split(defaultModel, ",")
+	}
+	// Models can be given by alias or ModelID too, workloads store IDs.
+	for _, list := range [][]string{req.Models, req.FallbackModels} {
+		for i, ref := range list {
+			model, err := database.ResolveModel(s.db, ref)
+			if err != nil {
+				writeReferenceError(w, "model", ref, err)
+				return
+			}
+			list[i] = model.ID
+		}
+	}
+
+	if req.OutputFormat == "" {
+		req.OutputFormat = agents.OutputJSON
+	}
+	config, err := agents.WithOutputFormat(req.Config, req.OutputFormat)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	tags, err := database.NormalizeTags(req.Tags)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	name := req.Name
+	if name == "" {
+		name = req.AgentType
+	}
+	workload := &pb.Workload{
+		Id:             uuid.New().String(),
+		Name:           name,
+		AgentId:        req.AgentID,
+		AgentType:      req.AgentType,
+		Models:         req.Models,
+		Payload:        []byte(req.Payload),
+		Config:         config,
+		Pipeline:       req.Pipeline,
+		FallbackModels: req.FallbackModels,
+		DryRun:         req.DryRun,
+		Priority:       req.Priority,
+		Tags:           tags,
+		Status:         pb.WorkloadStatus_RUNNING,
+		Timestamp:      time.Now().Unix(),
+	}
+	if err := s.db.AddSession(workload); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if !s.queue.TryPush(workload) {
+		// Don't leave a RUNNING session behind that no worker will pick up.
+		workload.Status = pb.WorkloadStatus_FAILED
+		workload.Error = "workload queue is full"
+		if err := s.db.AddSession(workload); err != nil {
+			log.Printf("Error saving session %s: %s", workload.Id, err)
+		}
+		writeError(w, http.StatusServiceUnavailable, errors.New("workload queue is full, try again later"))
+		return
+	}
+
+	w.Header().Set("Location", "/sessions/"+workload.Id)
+	writeJSON(w, http.StatusAccepted, toSession(workload))
+}
+
+// listSessions lists every session, or with ?tag= the sessions tagged with it.
+func (s *Server) listSessions(w http.ResponseWriter, r *http.Request) {
+	var sessions []*pb.Workload
+	var err error
+	if tag := r.URL.Query().Get("tag"); tag != "" {
+		if tag, err = database.NormalizeTag(tag); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		sessions, err = s.db.ListSessionsByTag(tag)
+	} else {
+		sessions, err = s.db.ListSessions()
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	out := []Session{}
+	for _, session := range sessions {
+		out = append(out, toSession(session))
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+func (s *Server) getSession(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	session, err := s.db.GetSession(id)
+	if err != nil {
+		writeLookupError(w, "session", id, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, toSession(session))
+}
+
+func (s *Server) cancelSession(w http.ResponseWriter, r *http.Request) {
+	if s.CancelSession == nil {
+		writeError(w, http.StatusNotImplemented, errors.New("cancelling sessions is not supported"))
+		return
+	}
+	id := r.PathValue("id")
+	session, err := s.db.GetSession(id)
+	if err != nil {
+		writeLookupError(w, "session", id, err)
+		return
+	}
+	if err := s.CancelSession(session.Id); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, scheduler.ErrNotCancellable) {
+			status = http.StatusConflict
+		}
+		writeError(w, status, err)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (s *Server) tagSession(w http.ResponseWriter, r *http.Request) {
+	s.updateTags(w, r, s.db.AddTag)
+}
+
+func (s *Server) untagSession(w http.ResponseWriter, r *http.Request) {
+	s.updateTags(w, r, s.db.RemoveTag)
+}
+
+// updateTags applies update to the session and tag in the path and answers
+// with the session as it is now.
+func (s *Server) updateTags(w http.ResponseWriter, r *http.Request, update func(id, tag string) error) {
+	id := r.PathValue("id")
+	tag, err := database.NormalizeTag(r.PathValue("tag"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := update(id, tag); err != nil {
+		writeLookupError(w, "session", id, err)
+		return
+	}
+	s.getSession(w, r)
+}
+
+func (s *Server) listAgents(w http.ResponseWriter, r *http.Request) {
+	agents, err := s.db.ListAgents()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if agents == nil {
+		agents = []*models.Agent{}
+	}
+	writeJSON(w, http.StatusOK, agents)
+}
+
+func (s *Server) getAgent(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	agent, err := s.db.GetAgent(id)
+	if err != nil {
+		writeLookupError(w, "agent", id, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, agent)
+}
+
+// putAgent handles both POST /agents and PUT /agents/{id}.
+func (s *Server) putAgent(w http.ResponseWriter, r *http.Request) {
+	var agent models.Agent
+	if err := json.NewDecoder(r.Body).Decode(&agent); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+		return
+	}
+	if id := r.PathValue("id"); id != "" {
+		agent.ID = id
+	}
+	if err := agent.Validate(); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	_, err := s.db.GetAgent(agent.ID)
+	existed := err == nil
+	if err := s.db.AddAgent(&agent); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if existed {
+		writeJSON(w, http.StatusOK, agent)
+	} else {
+		writeJSON(w, http.StatusCreated, agent)
+	}
+}
+
+func (s *Server) deleteAgent(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if err := s.db.DeleteAgent(id); err != nil {
+		writeLookupError(w, "agent", id, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// redactModel returns a copy of the model without its API key, which the API
+// never hands out.
+func redactModel(model *models.Model) *models.Model {
+	m := *model
+	m.APIKey = ""
+	return &m
+}
+
+func (s *Server) listModels(w http.ResponseWriter, r *http.Request) {
+	list, err := s.db.ListModels()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	out := []*models.Model{}
+	for _, model := range list {
+		out = append(out, redactModel(model))
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+func (s *Server) getModel(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	model, err := s.db.GetModel(id)
+	if err != nil {
+		writeLookupError(w, "model", id, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, redactModel(model))
+}
+
+// putModel handles both POST /models and PUT /models/{id}. Leaving out the
+// API key when updating keeps the stored one, since GET never returns it.
+func (s *Server) putModel(w http.ResponseWriter, r *http.Request) {
+	var model models.Model
+	if err := json.NewDecoder(r.Body).Decode(&model); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+		return
+	}
+	if id := r.PathValue("id"); id != "" {
+		model.ID = id
+	}
+
+	existing, err := s.db.GetModel(model.ID)
+	existed := err == nil
+	if existed && model.APIKey == "" {
+		model.APIKey = existing.APIKey
+	}
+	if err := model.Validate(); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := database.CheckModelAlias(s.db, &model); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := s.db.AddModel(&model); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	s.modelsChanged()
+
+	if existed {
+		writeJSON(w, http.StatusOK, redactModel(&model))
+	} else {
+		writeJSON(w, http.StatusCreated, redactModel(&model))
+	}
+}
+
+func (s *Server) deleteModel(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if err := s.db.DeleteModel(id); err != nil {
+		writeLookupError(w, "model", id, err)
+		return
+	}
+	s.modelsChanged()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) modelsChanged() {
+	if s.OnModelsChanged == nil {
+		return
+	}
+	list, err := s.db.ListModels()
+	if err != nil {
+		log.Printf("Error loading models from database: %s", err)
+		return
+	}
+	s.OnModelsChanged(list)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Error writing response: %s", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// writeLookupError answers 404 when the thing wasn't found and 500 otherwise.
+func writeLookupError(w http.ResponseWriter, kind, id string, err error) {
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, http.StatusNotFound, fmt.Errorf("%s %q not found", kind, id))
+		return
+	}
+	writeError(w, http.StatusInternalServerError, err)
+}
+
+// writeReferenceError is writeLookupError for things named in a request body,
+// where a missing one makes the request itself bad.
+func writeReferenceError(w http.ResponseWriter, kind, id string, err error) {
+	if errors.Is(err, database.ErrAmbiguousModel) {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("%s %q not found", kind, id))
+		return
+	}
+	writeError(w, http.StatusInternalServerError, err)
+}
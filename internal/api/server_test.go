@@ -0,0 +1,275 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nieveai/d-agents/internal/database"
+	"github.com/nieveai/d-agents/internal/models"
+	"github.com/nieveai/d-agents/internal/scheduler"
+	"github.com/nieveai/d-agents/internal/worker"
+	pb "github.com/nieveai/d-agents/proto"
+)
+
+// fakeQueue records the workloads pushed, and refuses them once full is set.
+type fakeQueue struct {
+	pushed []*pb.Workload
+	full   bool
+}
+
+func (q *fakeQueue) TryPush(workload *pb.Workload) bool {
+	if q.full {
+		return false
+	}
+	q.pushed = append(q.pushed, workload)
+	return true
+}
+
+// newTestServer returns a server over a memory datastore holding model m1.
+func newTestServer(t *testing.T) (*Server, database.Datastore, *fakeQueue) {
+	t.Helper()
+	db := database.NewMemoryDatastore()
+	if err := db.AddModel(&models.Model{ID: "m1", ModelID: "gpt-4o", APISpec: "openai", APIKey: "secret"}); err != nil {
+		t.Fatal(err)
+	}
+	queue := &fakeQueue{}
+	return NewServer(db, queue), db, queue
+}
+
+// do sends a request to s, with body encoded as JSON unless it is a string.
+func do(t *testing.T, s *Server, method, path string, body any) *httptest.ResponseRecorder {
+	t.Helper()
+	var data []byte
+	switch body := body.(type) {
+	case nil:
+	case string:
+		data = []byte(body)
+	default:
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest(method, path, bytes.NewReader(data)))
+	return rec
+}
+
+// decode decodes the JSON body of rec into v.
+func decode(t *testing.T, rec *httptest.ResponseRecorder, v any) {
+	t.Helper()
+	if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+		t.Fatalf("decoding %q: %v", rec.Body.String(), err)
+	}
+}
+
+func TestCreateSession(t *testing.T) {
+	s, db, queue := newTestServer(t)
+	rec := do(t, s, "POST", "/sessions", CreateSessionRequest{AgentType: "ChatAgent", Models: []string{"m1"}, Payload: "hello"})
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("POST /sessions = %d %s, want 202", rec.Code, rec.Body)
+	}
+	var session Session
+	decode(t, rec, &session)
+	if got := rec.Header().Get("Location"); got != "/sessions/"+session.ID {
+		t.Errorf("Location = %q, want /sessions/%s", got, session.ID)
+	}
+	if session.Status != "RUNNING" || session.Name != "ChatAgent" || session.Payload != "hello" {
+		t.Errorf("session = %+v", session)
+	}
+	if len(queue.pushed) != 1 || queue.pushed[0].Id != session.ID {
+		t.Errorf("queued %v, want the new session", queue.pushed)
+	}
+	if stored, err := db.GetSession(session.ID); err != nil || stored.Status != pb.WorkloadStatus_RUNNING {
+		t.Errorf("stored session = %v, %v", stored, err)
+	}
+
+	rec = do(t, s, "GET", "/sessions/"+session.ID, nil)
+	var got Session
+	decode(t, rec, &got)
+	if rec.Code != http.StatusOK || got.ID != session.ID {
+		t.Errorf("GET /sessions/{id} = %d %+v", rec.Code, got)
+	}
+	rec = do(t, s, "GET", "/sessions", nil)
+	var list []Session
+	decode(t, rec, &list)
+	if rec.Code != http.StatusOK || len(list) != 1 {
+		t.Errorf("GET /sessions = %d %+v", rec.Code, list)
+	}
+}
+
+func TestCreateSessionErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		body any
+		want int
+	}{
+		{"invalid body", "{", http.StatusBadRequest},
+		{"unknown agent type", CreateSessionRequest{AgentType: "NoSuchAgent", Models: []string{"m1"}}, http.StatusBadRequest},
+		{"unknown agent", CreateSessionRequest{AgentID: "missing", Models: []string{"m1"}}, http.StatusBadRequest},
+		{"no models", CreateSessionRequest{AgentType: "ChatAgent"}, http.StatusBadRequest},
+		{"unknown model", CreateSessionRequest{AgentType: "ChatAgent", Models: []string{"missing"}}, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, _, queue := newTestServer(t)
+			if rec := do(t, s, "POST", "/sessions", tt.body); rec.Code != tt.want {
+				t.Errorf("POST /sessions = %d %s, want %d", rec.Code, rec.Body, tt.want)
+			}
+			if len(queue.pushed) != 0 {
+				t.Errorf("queued %v for a bad request", queue.pushed)
+			}
+		})
+	}
+}
+
+func TestCreateSessionQueueFull(t *testing.T) {
+	s, db, queue := newTestServer(t)
+	queue.full = true
+	rec := do(t, s, "POST", "/sessions", CreateSessionRequest{AgentType: "ChatAgent", Models: []string{"m1"}})
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("POST /sessions = %d, want 503", rec.Code)
+	}
+	sessions, _ := db.ListSessions()
+	if len(sessions) != 1 || sessions[0].Status != pb.WorkloadStatus_FAILED {
+		t.Errorf("sessions = %v, want one FAILED", sessions)
+	}
+}
+
+func TestMissing(t *testing.T) {
+	s, _, _ := newTestServer(t)
+	for _, req := range []struct{ method, path string }{
+		{"GET", "/sessions/missing"},
+		{"GET", "/agents/missing"},
+		{"DELETE", "/agents/missing"},
+		{"GET", "/models/missing"},
+		{"DELETE", "/models/missing"},
+	} {
+		if rec := do(t, s, req.method, req.path, nil); rec.Code != http.StatusNotFound {
+			t.Errorf("%s %s = %d, want 404", req.method, req.path, rec.Code)
+		}
+	}
+}
+
+func TestAgents(t *testing.T) {
+	s, _, _ := newTestServer(t)
+	agent := models.Agent{ID: "a1", Name: "Chat", Type: "ChatAgent"}
+	if rec := do(t, s, "POST", "/agents", agent); rec.Code != http.StatusCreated {
+		t.Fatalf("POST /agents = %d %s, want 201", rec.Code, rec.Body)
+	}
+	agent.Name = "Chat v2"
+	if rec := do(t, s, "PUT", "/agents/a1", agent); rec.Code != http.StatusOK {
+		t.Errorf("PUT /agents/a1 = %d %s, want 200", rec.Code, rec.Body)
+	}
+	if rec := do(t, s, "POST", "/agents", models.Agent{ID: "a2", Type: "NoSuchAgent"}); rec.Code != http.StatusBadRequest {
+		t.Errorf("POST of an invalid agent = %d, want 400", rec.Code)
+	}
+
+	rec := do(t, s, "GET", "/agents/a1", nil)
+	var got models.Agent
+	decode(t, rec, &got)
+	if rec.Code != http.StatusOK || got.Name != "Chat v2" {
+		t.Errorf("GET /agents/a1 = %d %+v", rec.Code, got)
+	}
+	var list []models.Agent
+	decode(t, do(t, s, "GET", "/agents", nil), &list)
+	if len(list) != 1 {
+		t.Errorf("GET /agents = %+v, want one agent", list)
+	}
+
+	if rec := do(t, s, "DELETE", "/agents/a1", nil); rec.Code != http.StatusNoContent {
+		t.Errorf("DELETE /agents/a1 = %d, want 204", rec.Code)
+	}
+	if rec := do(t, s, "GET", "/agents/a1", nil); rec.Code != http.StatusNotFound {
+		t.Errorf("GET after DELETE = %d, want 404", rec.Code)
+	}
+}
+
+func TestModels(t *testing.T) {
+	s, db, _ := newTestServer(t)
+	var changed [][]*models.Model
+	s.OnModelsChanged = func(list []*models.Model) { changed = append(changed, list) }
+
+	model := models.Model{ID: "m2", ModelID: "gemini-pro", APISpec: "gemini", APIKey: "key2"}
+	rec := do(t, s, "POST", "/models", model)
+	var got models.Model
+	decode(t, rec, &got)
+	if rec.Code != http.StatusCreated || got.APIKey != "" {
+		t.Errorf("POST /models = %d %+v, want 201 without the key", rec.Code, got)
+	}
+
+	// Updating without a key keeps the stored one.
+	model.APIKey = ""
+	model.ModelID = "gemini-flash"
+	if rec := do(t, s, "PUT", "/models/m2", model); rec.Code != http.StatusOK {
+		t.Errorf("PUT /models/m2 = %d %s, want 200", rec.Code, rec.Body)
+	}
+	if stored, _ := db.GetModel("m2"); stored.APIKey != "key2" || stored.ModelID != "gemini-flash" {
+		t.Errorf("stored model = %+v", stored)
+	}
+	if rec := do(t, s, "POST", "/models", models.Model{ID: "m3", APISpec: "nope"}); rec.Code != http.StatusBadRequest {
+		t.Errorf("POST of an invalid model = %d, want 400", rec.Code)
+	}
+
+	var list []models.Model
+	decode(t, do(t, s, "GET", "/models", nil), &list)
+	for _, model := range list {
+		if model.APIKey != "" {
+			t.Errorf("GET /models hands out the key of %s", model.ID)
+		}
+	}
+	if len(list) != 2 {
+		t.Errorf("GET /models = %d models, want 2", len(list))
+	}
+
+	if rec := do(t, s, "DELETE", "/models/m2", nil); rec.Code != http.StatusNoContent {
+		t.Errorf("DELETE /models/m2 = %d, want 204", rec.Code)
+	}
+	if len(changed) != 3 || len(changed[2]) != 1 {
+		t.Errorf("OnModelsChanged called %d times, want 3 ending with one model", len(changed))
+	}
+}
+
+func TestCancelSession(t *testing.T) {
+	s, db, _ := newTestServer(t)
+	db.AddSession(&pb.Workload{Id: "running", Status: pb.WorkloadStatus_RUNNING})
+	db.AddSession(&pb.Workload{Id: "scheduled", Status: pb.WorkloadStatus_COMPLETED})
+	db.AddSession(&pb.Workload{Id: "done", Status: pb.WorkloadStatus_COMPLETED})
+	db.AddSchedule(&models.Schedule{SessionID: "scheduled", Interval: time.Hour, NextRun: time.Now()})
+	if err := worker.Init(context.Background(), []*models.Model{}, db); err != nil {
+		t.Fatalf("worker.Init: %v", err)
+	}
+	t.Cleanup(func() { worker.Init(context.Background(), []*models.Model{}, nil) })
+
+	if rec := do(t, s, "POST", "/sessions/running/cancel", nil); rec.Code != http.StatusNotImplemented {
+		t.Errorf("cancel without CancelSession = %d, want 501", rec.Code)
+	}
+
+	s.CancelSession = scheduler.New(db, nil).Cancel
+	tests := []struct {
+		id         string
+		want       int
+		wantStatus pb.WorkloadStatus_Status
+	}{
+		{"running", http.StatusAccepted, pb.WorkloadStatus_CANCELLED},
+		{"scheduled", http.StatusAccepted, pb.WorkloadStatus_COMPLETED},
+		{"done", http.StatusConflict, pb.WorkloadStatus_COMPLETED},
+		{"missing", http.StatusNotFound, pb.WorkloadStatus_UNKNOWN},
+	}
+	for _, tt := range tests {
+		if rec := do(t, s, "POST", "/sessions/"+tt.id+"/cancel", nil); rec.Code != tt.want {
+			t.Errorf("cancel %s = %d, want %d", tt.id, rec.Code, tt.want)
+		}
+		if session, err := db.GetSession(tt.id); err == nil && session.Status != tt.wantStatus {
+			t.Errorf("%s status = %v after cancelling, want %v", tt.id, session.Status, tt.wantStatus)
+		}
+	}
+	if schedules, _ := db.ListSchedules(); len(schedules) != 0 {
+		t.Errorf("schedules = %+v after cancelling, want none", schedules)
+	}
+}
@@ -0,0 +1,170 @@
+package browser
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/chromedp/chromedp"
+)
+
+// cleanupScript strips the parts of a page that are noise for an LLM.
+const cleanupScript = `document.querySelectorAll('head, script, style, link').forEach(el => el.remove());`
+
+// DefaultTimeout is how long a page gets to load unless Options say otherwise.
+const DefaultTimeout = 30 * time.Second
+
+// Options configures the browser behind a BrowserPool.
+type Options struct {
+	Headless  bool
+	UserAgent string
+	// Proxy is passed to Chrome as --proxy-server, e.g. "socks5://127.0.0.1:1080".
+	Proxy string
+	// Timeout bounds each Run/Fetch call. Zero means no timeout.
+	Timeout time.Duration
+}
+
+// DefaultOptions returns headless browsing with DefaultTimeout per page.
+func DefaultOptions() Options {
+	return Options{Headless: true, Timeout: DefaultTimeout}
+}
+
+// BrowserPool keeps one browser process around and opens a new tab for each
+// call, instead of starting Chrome for every page.
+type BrowserPool struct {
+	opts Options
+
+	cancelAlloc   context.CancelFunc
+	browserCtx    context.Context
+	cancelBrowser context.CancelFunc
+
+	mu      sync.Mutex
+	started bool
+	closed  bool
+}
+
+// NewBrowserPool sets up the allocator. Chrome itself is started on first use.
+func NewBrowserPool(opts Options) *BrowserPool {
+	allocOpts := append(chromedp.DefaultExecAllocatorOptions[:], chromedp.Flag("headless", opts.Headless))
+	if opts.UserAgent != "" {
+		allocOpts = append(allocOpts, chromedp.UserAgent(opts.UserAgent))
+	}
+	if opts.Proxy != "" {
+		allocOpts = append(allocOpts, chromedp.ProxyServer(opts.Proxy))
+	}
+
+	allocCtx, cancelAlloc := chromedp.NewExecAllocator(context.Background(), allocOpts...)
+	browserCtx, cancelBrowser := chromedp.NewContext(allocCtx)
+	return &BrowserPool{
+		opts:          opts,
+		cancelAlloc:   cancelAlloc,
+		browserCtx:    browserCtx,
+		cancelBrowser: cancelBrowser,
+	}
+}
+
+// Run runs actions in a new tab of the shared browser. The tab is closed when
+// Run returns, or earlier if ctx is cancelled or the timeout hits.
+func (p *BrowserPool) Run(ctx context.Context, actions ...chromedp.Action) error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return fmt.Errorf("browser pool is closed")
+	}
+	// Tabs only share the browser once it's running, otherwise each one would
+	// start its own.
+	if !p.started {
+		if err := chromedp.Run(p.browserCtx); err != nil {
+			p.mu.Unlock()
+			return fmt.Errorf("failed to start browser: %w", err)
+		}
+		p.started = true
+	}
+	tabCtx, cancelTab := chromedp.NewContext(p.browserCtx)
+	p.mu.Unlock()
+	defer cancelTab()
+
+	if p.opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		tabCtx, cancel = context.WithTimeout(tabCtx, p.opts.Timeout)
+		defer cancel()
+	}
+	// Tabs derive from the browser context, so the caller's cancellation has
+	// to be forwarded by hand.
+	stop := context.AfterFunc(ctx, cancelTab)
+	defer stop()
+
+	err := chromedp.Run(tabCtx, actions...)
+	switch {
+	case err == nil:
+		return nil
+	case ctx.Err() != nil:
+		// The caller gave up, e.g. the workload hit its deadline.
+		return fmt.Errorf("browser stopped: %w", context.Cause(ctx))
+	case errors.Is(tabCtx.Err(), context.DeadlineExceeded):
+		return fmt.Errorf("page didn't load within %s: %w", p.opts.Timeout, context.DeadlineExceeded)
+	}
+	return err
+}
+
+// Fetch returns the outer HTML of url with scripts, styles and the head removed.
+func (p *BrowserPool) Fetch(ctx context.Context, url string) (string, error) {
+	var res string
+	err := p.Run(ctx,
+		chromedp.Navigate(url),
+		chromedp.Evaluate(cleanupScript, nil),
+		chromedp.OuterHTML("html", &res),
+	)
+	if err != nil {
+		return "", err
+	}
+	return res, nil
+}
+
+// nextLinkScript returns the absolute URL of the link matched by the selector
+// %s, or of the link around or inside it, or "" when there is none.
+const nextLinkScript = `(() => {
+	const el = document.querySelector(%s);
+	if (!el) return "";
+	const link = el.closest("a[href]") || el.querySelector("a[href]");
+	return link ? link.href : "";
+})()`
+
+// FetchPage is Fetch for paginated pages: it also returns the URL of the next
+// page, the link matched by the CSS selector nextSelector. next is empty on
+// the last page, or when nextSelector is.
+func (p *BrowserPool) FetchPage(ctx context.Context, url, nextSelector string) (html, next string, err error) {
+	if nextSelector == "" {
+		html, err = p.Fetch(ctx, url)
+		return html, "", err
+	}
+	selector, err := json.Marshal(nextSelector)
+	if err != nil {
+		return "", "", err
+	}
+	err = p.Run(ctx,
+		chromedp.Navigate(url),
+		chromedp.Evaluate(fmt.Sprintf(nextLinkScript, selector), &next),
+		chromedp.Evaluate(cleanupScript, nil),
+		chromedp.OuterHTML("html", &html),
+	)
+	if err != nil {
+		return "", "", err
+	}
+	return html, next, nil
+}
+
+// Close shuts down the browser. Calls after the first are no-ops.
+func (p *BrowserPool) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return
+	}
+	p.closed = true
+	p.cancelBrowser()
+	p.cancelAlloc()
+}
@@ -0,0 +1,161 @@
+package browser
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chromedp/chromedp"
+)
+
+// needChrome skips the test when there is no browser for chromedp to start.
+func needChrome(t *testing.T) {
+	t.Helper()
+	for _, name := range []string{"headless_shell", "headless-shell", "chromium", "chromium-browser", "google-chrome", "google-chrome-stable", "chrome"} {
+		if _, err := exec.LookPath(name); err == nil {
+			return
+		}
+	}
+	t.Skip("no Chrome to test with")
+}
+
+func TestFetchReusesTheBrowser(t *testing.T) {
+	needChrome(t)
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		fmt.Fprintf(w, `<html><head><script>var x = 1;</script></head><body><p>page %d</p></body></html>`, requests)
+	}))
+	defer server.Close()
+
+	pool := NewBrowserPool(DefaultOptions())
+	defer pool.Close()
+
+	html, err := pool.Fetch(context.Background(), server.URL)
+	if err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+	if !strings.Contains(html, "page 1") || strings.Contains(html, "<script") {
+		t.Errorf("html = %q, want the body without scripts", html)
+	}
+	browser := chromedp.FromContext(pool.browserCtx).Browser
+
+	for i := 2; i <= 3; i++ {
+		html, err := pool.Fetch(context.Background(), server.URL)
+		if err != nil {
+			t.Fatalf("Fetch %d: %v", i, err)
+		}
+		if !strings.Contains(html, fmt.Sprintf("page %d", i)) {
+			t.Errorf("Fetch %d = %q", i, html)
+		}
+	}
+	if browser == nil || chromedp.FromContext(pool.browserCtx).Browser != browser {
+		t.Error("a later Fetch started another browser")
+	}
+}
+
+func TestFetchCancelled(t *testing.T) {
+	needChrome(t)
+	pool := NewBrowserPool(DefaultOptions())
+	defer pool.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := pool.Fetch(ctx, "about:blank"); err == nil {
+		t.Error("Fetch succeeded with a cancelled context")
+	}
+}
+
+func TestClose(t *testing.T) {
+	pool := NewBrowserPool(DefaultOptions())
+	pool.Close()
+	// A second Close is fine.
+	pool.Close()
+	if _, err := pool.Fetch(context.Background(), "about:blank"); err == nil || !strings.Contains(err.Error(), "closed") {
+		t.Errorf("Fetch after Close = %v, want an error", err)
+	}
+}
+
+func TestFetchTimeout(t *testing.T) {
+	needChrome(t)
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Never answers until the test is over.
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	pool := NewBrowserPool(Options{Headless: true, Timeout: 500 * time.Millisecond})
+	defer pool.Close()
+	// Start the browser first so its startup doesn't count.
+	if _, err := pool.Fetch(context.Background(), "about:blank"); err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+
+	start := time.Now()
+	_, err := pool.Fetch(context.Background(), server.URL)
+	if !errors.Is(err, context.DeadlineExceeded) || !strings.Contains(err.Error(), "didn't load within 500ms") {
+		t.Errorf("Fetch of a slow page = %v, want a timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Fetch took %s with a 500ms timeout", elapsed)
+	}
+
+	// A caller's deadline shorter than the pool's timeout wins.
+	pool.opts.Timeout = time.Minute
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	start = time.Now()
+	if _, err := pool.Fetch(ctx, server.URL); err == nil || !strings.Contains(err.Error(), "browser stopped") {
+		t.Errorf("Fetch past the caller's deadline = %v, want it stopped", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Fetch took %s past a 500ms deadline", elapsed)
+	}
+}
+
+func TestFetchPage(t *testing.T) {
+	needChrome(t)
+	pages := map[string]string{
+		"/catalog":        `<html><body><p>page 1</p><nav class="pager"><a class="next" href="/catalog?page=2">Next</a></nav></body></html>`,
+		"/catalog?page=2": `<html><body><p>page 2</p><nav class="pager"><a class="next" href="?page=3"><span>Next</span></a></nav></body></html>`,
+		"/catalog?page=3": `<html><body><p>page 3</p><nav class="pager"><span class="next">Next</span></nav></body></html>`,
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, pages[r.URL.RequestURI()])
+	}))
+	defer server.Close()
+
+	pool := NewBrowserPool(DefaultOptions())
+	defer pool.Close()
+
+	var got []string
+	url := server.URL + "/catalog"
+	for url != "" && len(got) < 5 {
+		html, next, err := pool.FetchPage(context.Background(), url, ".pager .next")
+		if err != nil {
+			t.Fatalf("FetchPage(%s): %v", url, err)
+		}
+		if want := fmt.Sprintf("page %d", len(got)+1); !strings.Contains(html, want) {
+			t.Errorf("FetchPage(%s) = %q, want %q", url, html, want)
+		}
+		got = append(got, strings.TrimPrefix(url, server.URL))
+		url = next
+	}
+	// The link on the second page is relative, and on the last page the
+	// selector matches something that isn't a link.
+	if want := []string{"/catalog", "/catalog?page=2", "/catalog?page=3"}; strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("followed %q, want %q", got, want)
+	}
+
+	if _, next, err := pool.FetchPage(context.Background(), server.URL+"/catalog", ""); err != nil || next != "" {
+		t.Errorf("FetchPage without a selector = next %q, %v, want no next page", next, err)
+	}
+}
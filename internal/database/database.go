@@ -1,12 +1,16 @@
 package database
 
 import (
+	"context"
 	"database/sql"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"log"
 	"os"
+	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/neo4j/neo4j-go-driver/v4/neo4j"
@@ -17,44 +21,146 @@ import (
 
 var neo4jDriver neo4j.Driver
 
+// settingsStore is where GetNeo4jDriver looks up the connection first.
+var settingsStore Datastore
+
+// UseSettings makes GetNeo4jDriver connect with the Neo4j settings of store.
+// Without them it reads config.json and the credentials file.
+func UseSettings(store Datastore) {
+	settingsStore = store
+}
+
 type Neo4jConfig struct {
 	Uri      string `json:"uri"`
 	Username string `json:"username"`
+	Password string `json:"password"`
+	// Database is the Neo4j database to use, the server default when empty.
+	Database string `json:"database"`
 }
 
-func GetNeo4jDriver() (neo4j.Driver, error) {
-	if neo4jDriver != nil {
-		return neo4jDriver, nil
+// Neo4jPasswordEnv is the environment variable that overrides the Neo4j
+// password from the settings, config.json and the credentials file.
+const Neo4jPasswordEnv = "NEO4J_PASSWORD"
+
+var (
+	// neo4jMu guards neo4jDriver and neo4jDatabase.
+	neo4jMu sync.Mutex
+	// neo4jDatabase is the database of the connection GetNeo4jDriver made.
+	neo4jDatabase string
+	// newNeo4jDriver creates drivers. It is a variable so it can be stubbed.
+	newNeo4jDriver = func(uri string, auth neo4j.AuthToken) (neo4j.Driver, error) {
+		return neo4j.NewDriver(uri, auth)
 	}
+)
 
-	configFile, err := os.Open("config.json")
-	if err != nil {
-		return nil, fmt.Errorf("failed to open config file: %w", err)
-	}
-	defer configFile.Close()
+// GetNeo4jDriver returns the shared Neo4j driver, connecting on first use. The
+// driver is checked on every call, and replaced when it has been closed or
+// lost its connection, e.g. because Neo4j restarted.
+func GetNeo4jDriver() (neo4j.Driver, error) {
+	neo4jMu.Lock()
+	defer neo4jMu.Unlock()
 
-	var config struct {
-		Neo4j Neo4jConfig `json:"neo4j"`
-	}
-	jsonParser := json.NewDecoder(configFile)
-	if err = jsonParser.Decode(&config); err != nil {
-		return nil, fmt.Errorf("failed to decode config file: %w", err)
+	if neo4jDriver != nil {
+		err := neo4jDriver.VerifyConnectivity()
+		if err == nil {
+			return neo4jDriver, nil
+		}
+		if !neo4j.IsUsageError(err) && !neo4j.IsConnectivityError(err) {
+			return nil, fmt.Errorf("failed to verify neo4j connectivity: %w", err)
+		}
+		log.Printf("Neo4j driver unusable, reconnecting: %s", err)
+		neo4jDriver.Close()
+		neo4jDriver = nil
 	}
 
-	password, err := readPassword("data/neo4j/credentials.txt")
+	config, err := neo4jConnection()
 	if err != nil {
-		return nil, fmt.Errorf("failed to read credentials: %w", err)
+		return nil, err
 	}
 
-	driver, err := neo4j.NewDriver(config.Neo4j.Uri, neo4j.BasicAuth(config.Neo4j.Username, password, ""))
+	driver, err := newNeo4jDriver(config.Uri, neo4j.BasicAuth(config.Username, config.Password, ""))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create neo4j driver: %w", err)
 	}
+	if err := driver.VerifyConnectivity(); err != nil {
+		driver.Close()
+		return nil, fmt.Errorf("failed to connect to neo4j at %s: %w", config.Uri, err)
+	}
 
 	neo4jDriver = driver
+	neo4jDatabase = config.Database
 	return neo4jDriver, nil
 }
 
+// Neo4jSessionConfig returns the config for sessions on the configured
+// database.
+func Neo4jSessionConfig(mode neo4j.AccessMode) neo4j.SessionConfig {
+	neo4jMu.Lock()
+	defer neo4jMu.Unlock()
+	return neo4j.SessionConfig{AccessMode: mode, DatabaseName: neo4jDatabase}
+}
+
+// Neo4jConfigured reports whether a Neo4j URI is set in the settings or
+// config.json, so health checks can leave out a Neo4j nobody uses.
+func Neo4jConfigured() bool {
+	if settingsStore != nil {
+		if uri, err := StringSetting(settingsStore, SettingNeo4jURI, ""); err == nil && uri != "" {
+			return true
+		}
+	}
+	file, err := readConfigFile(ConfigFile)
+	return err == nil && file.Neo4j.Uri != ""
+}
+
+// neo4jConnection works out where and how to connect to Neo4j. The URI,
+// username and database come from the settings, or config.json when the
+// settings have no URI. The password is the first one found in
+// NEO4J_PASSWORD, the settings or config.json, and the credentials file.
+func neo4jConnection() (Neo4jConfig, error) {
+	var config Neo4jConfig
+	if settingsStore != nil {
+		settings := []struct {
+			key   string
+			value *string
+		}{
+			{SettingNeo4jURI, &config.Uri},
+			{SettingNeo4jUsername, &config.Username},
+			{SettingNeo4jPassword, &config.Password},
+			{SettingNeo4jDatabase, &config.Database},
+		}
+		for _, setting := range settings {
+			value, err := StringSetting(settingsStore, setting.key, "")
+			if err != nil {
+				return Neo4jConfig{}, err
+			}
+			*setting.value = value
+		}
+	}
+
+	if config.Uri == "" {
+		file, err := readConfigFile(ConfigFile)
+		if err != nil {
+			return Neo4jConfig{}, fmt.Errorf("failed to read config file: %w", err)
+		}
+		config = file.Neo4j
+	}
+
+	if password := os.Getenv(Neo4jPasswordEnv); password != "" {
+		config.Password = password
+	}
+	if config.Password == "" {
+		password, err := readPassword(CredentialsFile)
+		if errors.Is(err, os.ErrNotExist) {
+			return Neo4jConfig{}, fmt.Errorf("no Neo4j password: set %s, neo4j.password in %s, or create %s", Neo4jPasswordEnv, ConfigFile, CredentialsFile)
+		}
+		if err != nil {
+			return Neo4jConfig{}, fmt.Errorf("failed to read credentials: %w", err)
+		}
+		config.Password = password
+	}
+	return config, nil
+}
+
 func readPassword(filepath string) (string, error) {
 	data, err := ioutil.ReadFile(filepath)
 	if err != nil {
@@ -69,9 +175,14 @@ func readPassword(filepath string) (string, error) {
 	return "", fmt.Errorf("password not found in credentials file")
 }
 
+// CloseNeo4jDriver closes the shared driver. A later GetNeo4jDriver connects
+// again.
 func CloseNeo4jDriver() {
+	neo4jMu.Lock()
+	defer neo4jMu.Unlock()
 	if neo4jDriver != nil {
 		neo4jDriver.Close()
+		neo4jDriver = nil
 	}
 }
 
@@ -83,9 +194,48 @@ type Datastore interface {
 	AddSession(session *pb.Workload) error
 	GetSession(id string) (*pb.Workload, error)
 	ListSessions() ([]*pb.Workload, error)
+	// SearchSessions returns the sessions whose name or payload contain all
+	// the words of query, newest first.
+	SearchSessions(query string) ([]*pb.Workload, error)
+	// SetHeartbeat records that session id is still being worked on. Sessions
+	// that are no longer RUNNING are left alone.
+	SetHeartbeat(id string, at time.Time) error
+	// SetProgress records how far along session id is, from 0 to 100. Like
+	// SetHeartbeat it only touches RUNNING sessions.
+	SetProgress(id string, pct int32) error
+	// AddTag and RemoveTag change the tags of session id, returning
+	// sql.ErrNoRows if there is no such session. AddSession only sets the
+	// tags of new sessions.
+	AddTag(id, tag string) error
+	RemoveTag(id, tag string) error
+	// ListSessionsByTag returns the sessions tagged tag, oldest first.
+	ListSessionsByTag(tag string) ([]*pb.Workload, error)
 	AddModel(model *models.Model) error
+	UpdateModel(model *models.Model) error
 	GetModel(id string) (*models.Model, error)
 	ListModels() ([]*models.Model, error)
+	DeleteSession(id string) error
+	DeleteAgent(id string) error
+	DeleteModel(id string) error
+	AddRelationship(rel *models.Relationship) error
+	ListRelationships() ([]*models.Relationship, error)
+	AddChunks(chunks []*models.Chunk) error
+	SearchChunks(collection string, query []float32, k int) ([]*models.Chunk, error)
+	GetSetting(key string) (string, error)
+	SetSetting(key, value string) error
+	ListSettings() (map[string]string, error)
+	// AddSchedule adds the schedule, replacing any the session had.
+	AddSchedule(schedule *models.Schedule) error
+	ListSchedules() ([]*models.Schedule, error)
+	DeleteSchedule(sessionID string) error
+	// AddAuditEntry records a model call of a session. ListAuditEntries
+	// returns the calls of session sessionID, oldest first.
+	AddAuditEntry(entry *models.AuditEntry) error
+	ListAuditEntries(sessionID string) ([]*models.AuditEntry, error)
+	// Ping checks that the database can still be reached, for health
+	// checks. It fails once the datastore is closed.
+	Ping(ctx context.Context) error
+	Close() error
 }
 
 type SQLiteDatastore struct {
@@ -93,107 +243,283 @@ type SQLiteDatastore struct {
 }
 
 func NewSQLiteDatastore(path string) (*SQLiteDatastore, error) {
-	db, err := sql.Open("sqlite3", path)
+	db, err := openSQLite(path)
 	if err != nil {
 		return nil, err
 	}
 
-	// Create agents table if it doesn't exist
-	_, err = db.Exec(`
-		CREATE TABLE IF NOT EXISTS agents (
-			id TEXT PRIMARY KEY,
-			name TEXT,
-			description TEXT,
-			type TEXT
-		);
-	`)
-	if err != nil {
-		return nil, err
+	if err := migrate(db); err != nil {
+		return nil, fmt.Errorf("failed to migrate %s: %w", path, err)
+	}
+	if err := ensureSearchIndex(db); err != nil {
+		return nil, fmt.Errorf("failed to set up session search in %s: %w", path, err)
 	}
 
-	// Create sessions table if it doesn't exist
-	_, err = db.Exec(`
-		CREATE TABLE IF NOT EXISTS sessions (
-			id TEXT PRIMARY KEY,
-			name TEXT,
-			agent_id TEXT,
-			agent_type TEXT,
-			models TEXT,
-			payload BLOB,
-			status TEXT,
-			timestamp DATETIME DEFAULT CURRENT_TIMESTAMP
-		);
-	`)
-	if err != nil {
-		return nil, err
+	return &SQLiteDatastore{db: db}, nil
+}
+
+// DefaultDatabase is the SQLite file used when config.json names no database.
+const DefaultDatabase = "d-agents.db"
+
+// NewDatastore opens the database conn points at. A postgres:// or
+// postgresql:// URL selects PostgreSQL, anything else is a SQLite file path.
+func NewDatastore(conn string) (Datastore, error) {
+	if strings.HasPrefix(conn, "postgres://") || strings.HasPrefix(conn, "postgresql://") {
+		return NewPostgresDatastore(conn)
 	}
+	return NewSQLiteDatastore(conn)
+}
 
-	// Create models table if it doesn't exist
-	_, err = db.Exec(`
-		CREATE TABLE IF NOT EXISTS models (
-			id TEXT PRIMARY KEY,
-			provider TEXT,
-			api_key TEXT,
-			model_id TEXT,
-			api_url TEXT,
-			api_spec TEXT
-		);
-	`)
+// openSQLite opens a SQLite database set up for several goroutines writing at
+// once: WAL so readers don't block the writer, a busy timeout instead of
+// failing straight away with "database is locked", and a single connection
+// since SQLite only allows one writer anyway.
+func openSQLite(path string) (*sql.DB, error) {
+	// A database of its own, e.g. one per project, may go in a directory
+	// that doesn't exist yet.
+	if file, _, _ := strings.Cut(path, "?"); !strings.HasPrefix(file, "file:") && file != ":memory:" {
+		if dir := filepath.Dir(file); dir != "." {
+			if err := os.MkdirAll(dir, 0o755); err != nil {
+				return nil, fmt.Errorf("failed to create %s: %w", dir, err)
+			}
+		}
+	}
+	sep := "?"
+	if strings.Contains(path, "?") {
+		sep = "&"
+	}
+	db, err := sql.Open("sqlite3", path+sep+"_journal_mode=WAL&_busy_timeout=5000")
 	if err != nil {
 		return nil, err
 	}
-
-	return &SQLiteDatastore{db: db}, nil
+	db.SetMaxOpenConns(1)
+	return db, nil
 }
 
 func (db *SQLiteDatastore) GetAgent(id string) (*models.Agent, error) {
-	row := db.db.QueryRow("SELECT id, name, description, type FROM agents WHERE id = ?", id)
+	row := db.db.QueryRow("SELECT id, name, description, type, system_prompt FROM agents WHERE id = ?", id)
+	return scanAgent(row)
+}
 
+func scanAgent(row rowScanner) (*models.Agent, error) {
 	var agent models.Agent
-	err := row.Scan(&agent.ID, &agent.Name, &agent.Description, &agent.Type)
+	var systemPrompt sql.NullString
+	err := row.Scan(&agent.ID, &agent.Name, &agent.Description, &agent.Type, &systemPrompt)
 	if err != nil {
 		return nil, err
 	}
+	agent.SystemPrompt = systemPrompt.String
 
 	return &agent, nil
 }
 
+// AddAgent adds an agent, replacing any existing agent with the same ID.
 func (db *SQLiteDatastore) AddAgent(agent *models.Agent) error {
-	_, err := db.db.Exec("INSERT INTO agents (id, name, description, type) VALUES (?, ?, ?, ?)", agent.ID, agent.Name, agent.Description, agent.Type)
+	_, err := db.db.Exec("INSERT OR REPLACE INTO agents (id, name, description, type, system_prompt) VALUES (?, ?, ?, ?, ?)", agent.ID, agent.Name, agent.Description, agent.Type, agent.SystemPrompt)
 	return err
 }
 
-func (db *SQLiteDatastore) AddSession(session *pb.Workload) error {
-	models := strings.Join(session.Models, ",")
-	_, err := db.db.Exec("INSERT OR REPLACE INTO sessions (id, name, agent_id, agent_type, models, payload, status) VALUES (?, ?, ?, ?, ?, ?, ?)", session.Id, session.Name, session.AgentId, session.AgentType, models, session.Payload, session.Status.String())
-	return err
-}
+// sessionColumns lists the sessions columns in the order scanSession expects.
+const sessionColumns = "id, name, agent_id, agent_type, models, payload, status, timestamp, config, prompt_tokens, completion_tokens, estimated_cost, error, retry_count, pipeline, stage, fallback_models, dry_run, last_heartbeat, progress, priority, tags, history"
 
-func (db *SQLiteDatastore) GetSession(id string) (*pb.Workload, error) {
-	row := db.db.QueryRow("SELECT id, name, agent_id, agent_type, models, payload, status, timestamp FROM sessions WHERE id = ?", id)
+// rowScanner is implemented by both *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...any) error
+}
 
+func scanSession(row rowScanner) (*pb.Workload, error) {
 	var session pb.Workload
 	var timestamp time.Time
 	var models string
 	var status sql.NullString
-	err := row.Scan(&session.Id, &session.Name, &session.AgentId, &session.AgentType, &models, &session.Payload, &status, &timestamp)
+	var config sql.NullString
+	var promptTokens, completionTokens sql.NullInt64
+	var estimatedCost sql.NullFloat64
+	var errorMessage sql.NullString
+	var retryCount sql.NullInt32
+	var pipeline, stage, fallbackModels sql.NullString
+	var dryRun sql.NullBool
+	var lastHeartbeat sql.NullTime
+	var progress, priority sql.NullInt32
+	var tags, history sql.NullString
+	err := row.Scan(&session.Id, &session.Name, &session.AgentId, &session.AgentType, &models, &session.Payload, &status, &timestamp, &config, &promptTokens, &completionTokens, &estimatedCost, &errorMessage, &retryCount, &pipeline, &stage, &fallbackModels, &dryRun, &lastHeartbeat, &progress, &priority, &tags, &history)
 	if err != nil {
 		return nil, err
 	}
 	session.Timestamp = timestamp.Unix()
 	session.Models = strings.Split(models, ",")
-	if status.Valid {
-		st, ok := pb.WorkloadStatus_Status_value[status.String]
-		if ok {
-			session.Status = pb.WorkloadStatus_Status(st)
+	session.Config = config.String
+	session.PromptTokens = promptTokens.Int64
+	session.CompletionTokens = completionTokens.Int64
+	session.EstimatedCost = estimatedCost.Float64
+	session.Error = errorMessage.String
+	session.RetryCount = retryCount.Int32
+	if pipeline.String != "" {
+		session.Pipeline = strings.Split(pipeline.String, ",")
+	}
+	session.Stage = stage.String
+	session.DryRun = dryRun.Bool
+	if fallbackModels.String != "" {
+		session.FallbackModels = strings.Split(fallbackModels.String, ",")
+	}
+	if lastHeartbeat.Valid {
+		session.LastHeartbeat = lastHeartbeat.Time.Unix()
+	}
+	session.Progress = progress.Int32
+	session.Priority = priority.Int32
+	session.Tags = splitTags(tags.String)
+	session.History = splitHistory(session.Id, history.String)
+	session.Status = parseStatus(session.Id, status.String)
+	return &session, nil
+}
+
+// parseStatus maps a stored status back to the enum. Rows written by older
+// versions may have other spellings or statuses that no longer exist; those
+// load as UNKNOWN rather than failing the whole scan.
+func parseStatus(id, status string) pb.WorkloadStatus_Status {
+	if status == "" {
+		return pb.WorkloadStatus_UNKNOWN
+	}
+	st, ok := pb.WorkloadStatus_Status_value[strings.ToUpper(strings.TrimSpace(status))]
+	if !ok {
+		log.Printf("Session %s has unknown status %q, loading it as UNKNOWN", id, status)
+		return pb.WorkloadStatus_UNKNOWN
+	}
+	return pb.WorkloadStatus_Status(st)
+}
+
+func (db *SQLiteDatastore) AddSession(session *pb.Workload) error {
+	models := strings.Join(session.Models, ",")
+	pipeline := strings.Join(session.Pipeline, ",")
+	fallbackModels := strings.Join(session.FallbackModels, ",")
+	// Store the timestamp chosen by the caller; fall back to now for sessions
+	// that never had one set.
+	timestamp := time.Now().UTC()
+	if session.Timestamp != 0 {
+		timestamp = time.Unix(session.Timestamp, 0).UTC()
+	}
+
+	tx, err := db.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	// The replaced row's text has to leave the search index while it is
+	// still in sessions.
+	if err := unindexSession(tx, session.Id); err != nil {
+		return err
+	}
+	var lastHeartbeat *time.Time
+	if session.LastHeartbeat != 0 {
+		t := time.Unix(session.LastHeartbeat, 0).UTC()
+		lastHeartbeat = &t
+	}
+	// An existing session keeps its tags, they are changed with AddTag and
+	// RemoveTag.
+	res, err := tx.Exec("INSERT OR REPLACE INTO sessions ("+sessionColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, COALESCE((SELECT tags FROM sessions WHERE id = ?), ?), ?)", session.Id, session.Name, session.AgentId, session.AgentType, models, session.Payload, session.Status.String(), timestamp, session.Config, session.PromptTokens, session.CompletionTokens, session.EstimatedCost, session.Error, session.RetryCount, pipeline, session.Stage, fallbackModels, session.DryRun, lastHeartbeat, session.Progress, session.Priority, session.Id, joinTags(session.Tags), joinHistory(session.History))
+	if err != nil {
+		return err
+	}
+	rowid, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	if err := indexSession(tx, rowid, session); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (db *SQLiteDatastore) SetHeartbeat(id string, at time.Time) error {
+	_, err := db.db.Exec("UPDATE sessions SET last_heartbeat = ? WHERE id = ? AND status = ?", at.UTC(), id, pb.WorkloadStatus_RUNNING.String())
+	return err
+}
+
+func (db *SQLiteDatastore) SetProgress(id string, pct int32) error {
+	_, err := db.db.Exec("UPDATE sessions SET progress = ? WHERE id = ? AND status = ?", pct, id, pb.WorkloadStatus_RUNNING.String())
+	return err
+}
+
+func (db *SQLiteDatastore) AddTag(id, tag string) error {
+	return db.updateTags(id, tag, withTag)
+}
+
+func (db *SQLiteDatastore) RemoveTag(id, tag string) error {
+	return db.updateTags(id, tag, withoutTag)
+}
+
+// updateTags replaces the tags of session id with update(tags, tag).
+func (db *SQLiteDatastore) updateTags(id, tag string, update func([]string, string) []string) error {
+	tag, err := NormalizeTag(tag)
+	if err != nil {
+		return err
+	}
+	tx, err := db.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var tags sql.NullString
+	if err := tx.QueryRow("SELECT tags FROM sessions WHERE id = ?", id).Scan(&tags); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE sessions SET tags = ? WHERE id = ?", joinTags(update(splitTags(tags.String), tag)), id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (db *SQLiteDatastore) ListSessionsByTag(tag string) ([]*pb.Workload, error) {
+	tag, err := NormalizeTag(tag)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.db.Query("SELECT "+sessionColumns+" FROM sessions WHERE instr(',' || tags || ',', ',' || ? || ',') > 0 ORDER BY timestamp, id", tag)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []*pb.Workload
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return nil, err
 		}
+		sessions = append(sessions, session)
 	}
+	return sessions, rows.Err()
+}
 
-	return &session, nil
+func (db *SQLiteDatastore) SearchSessions(query string) ([]*pb.Workload, error) {
+	where, args := searchCondition(query)
+	if where == "" {
+		return nil, nil
+	}
+	rows, err := db.db.Query("SELECT "+sessionColumns+" FROM sessions WHERE "+where+" ORDER BY timestamp DESC", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []*pb.Workload
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
+
+func (db *SQLiteDatastore) GetSession(id string) (*pb.Workload, error) {
+	row := db.db.QueryRow("SELECT "+sessionColumns+" FROM sessions WHERE id = ?", id)
+	return scanSession(row)
 }
 
 func (db *SQLiteDatastore) ListSessions() ([]*pb.Workload, error) {
-	rows, err := db.db.Query("SELECT id, name, agent_id, agent_type, models, payload, status, timestamp FROM sessions")
+	rows, err := db.db.Query("SELECT " + sessionColumns + " FROM sessions")
 	if err != nil {
 		return nil, err
 	}
@@ -201,46 +527,77 @@ func (db *SQLiteDatastore) ListSessions() ([]*pb.Workload, error) {
 
 	var sessions []*pb.Workload
 	for rows.Next() {
-		var session pb.Workload
-		var timestamp time.Time
-		var models string
-		var status sql.NullString
-		if err := rows.Scan(&session.Id, &session.Name, &session.AgentId, &session.AgentType, &models, &session.Payload, &status, &timestamp); err != nil {
+		session, err := scanSession(rows)
+		if err != nil {
 			return nil, err
 		}
-		session.Timestamp = timestamp.Unix()
-		session.Models = strings.Split(models, ",")
-		if status.Valid {
-			st, ok := pb.WorkloadStatus_Status_value[status.String]
-			if ok {
-				session.Status = pb.WorkloadStatus_Status(st)
-			}
-		}
-		sessions = append(sessions, &session)
+		sessions = append(sessions, session)
 	}
 
 	return sessions, nil
 }
 
+// modelColumns lists the models columns in the order scanModel expects.
+const modelColumns = "id, provider, api_key, model_id, api_url, api_spec, prompt_price, completion_price, enable_web_search, temperature, max_tokens, top_p, dimensions, api_version, rpm, request_template, response_path, max_input_bytes, alias, response_cleaners"
+
+func scanModel(row rowScanner) (*models.Model, error) {
+	var model models.Model
+	var promptPrice, completionPrice sql.NullFloat64
+	var enableWebSearch sql.NullBool
+	var temperature, topP sql.NullFloat64
+	var maxTokens, dimensions, rpm, maxInputBytes sql.NullInt64
+	var apiVersion, requestTemplate, responsePath, alias, responseCleaners sql.NullString
+	err := row.Scan(&model.ID, &model.Provider, &model.APIKey, &model.ModelID, &model.APIURL, &model.APISpec, &promptPrice, &completionPrice, &enableWebSearch, &temperature, &maxTokens, &topP, &dimensions, &apiVersion, &rpm, &requestTemplate, &responsePath, &maxInputBytes, &alias, &responseCleaners)
+	if err != nil {
+		return nil, err
+	}
+	model.PromptPrice = promptPrice.Float64
+	model.CompletionPrice = completionPrice.Float64
+	model.EnableWebSearch = enableWebSearch.Bool
+	if temperature.Valid {
+		model.Temperature = &temperature.Float64
+	}
+	if maxTokens.Valid {
+		n := int(maxTokens.Int64)
+		model.MaxTokens = &n
+	}
+	if topP.Valid {
+		model.TopP = &topP.Float64
+	}
+	model.Dimensions = int(dimensions.Int64)
+	model.APIVersion = apiVersion.String
+	model.RPM = int(rpm.Int64)
+	model.RequestTemplate = requestTemplate.String
+	model.ResponsePath = responsePath.String
+	model.MaxInputBytes = int(maxInputBytes.Int64)
+	model.Alias = alias.String
+	if responseCleaners.String != "" {
+		model.ResponseCleaners = strings.Split(responseCleaners.String, ",")
+	}
+	return &model, nil
+}
+
+// AddModel adds a model, replacing any existing model with the same ID.
 func (db *SQLiteDatastore) AddModel(model *models.Model) error {
-	_, err := db.db.Exec("INSERT INTO models (id, provider, api_key, model_id, api_url, api_spec) VALUES (?, ?, ?, ?, ?, ?)", model.ID, model.Provider, model.APIKey, model.ModelID, model.APIURL, model.APISpec)
+	_, err := db.db.Exec("INSERT OR REPLACE INTO models ("+modelColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)", model.ID, model.Provider, model.APIKey, model.ModelID, model.APIURL, model.APISpec, model.PromptPrice, model.CompletionPrice, model.EnableWebSearch, model.Temperature, model.MaxTokens, model.TopP, model.Dimensions, model.APIVersion, model.RPM, model.RequestTemplate, model.ResponsePath, model.MaxInputBytes, model.Alias, strings.Join(model.ResponseCleaners, ","))
 	return err
 }
 
-func (db *SQLiteDatastore) GetModel(id string) (*models.Model, error) {
-	row := db.db.QueryRow("SELECT id, provider, api_key, model_id, api_url, api_spec FROM models WHERE id = ?", id)
-
-	var model models.Model
-	err := row.Scan(&model.ID, &model.Provider, &model.APIKey, &model.ModelID, &model.APIURL, &model.APISpec)
+func (db *SQLiteDatastore) UpdateModel(model *models.Model) error {
+	res, err := db.db.Exec("UPDATE models SET provider = ?, api_key = ?, model_id = ?, api_url = ?, api_spec = ?, prompt_price = ?, completion_price = ?, enable_web_search = ?, temperature = ?, max_tokens = ?, top_p = ?, dimensions = ?, api_version = ?, rpm = ?, request_template = ?, response_path = ?, max_input_bytes = ?, alias = ?, response_cleaners = ? WHERE id = ?", model.Provider, model.APIKey, model.ModelID, model.APIURL, model.APISpec, model.PromptPrice, model.CompletionPrice, model.EnableWebSearch, model.Temperature, model.MaxTokens, model.TopP, model.Dimensions, model.APIVersion, model.RPM, model.RequestTemplate, model.ResponsePath, model.MaxInputBytes, model.Alias, strings.Join(model.ResponseCleaners, ","), model.ID)
 	if err != nil {
-		return nil, err
+		return err
 	}
+	return checkRowsAffected(res)
+}
 
-	return &model, nil
+func (db *SQLiteDatastore) GetModel(id string) (*models.Model, error) {
+	row := db.db.QueryRow("SELECT "+modelColumns+" FROM models WHERE id = ?", id)
+	return scanModel(row)
 }
 
 func (db *SQLiteDatastore) ListModels() ([]*models.Model, error) {
-	rows, err := db.db.Query("SELECT id, provider, api_key, model_id, api_url, api_spec FROM models")
+	rows, err := db.db.Query("SELECT " + modelColumns + " FROM models")
 	if err != nil {
 		return nil, err
 	}
@@ -248,18 +605,18 @@ func (db *SQLiteDatastore) ListModels() ([]*models.Model, error) {
 
 	var models_list []*models.Model
 	for rows.Next() {
-		var model models.Model
-		if err := rows.Scan(&model.ID, &model.Provider, &model.APIKey, &model.ModelID, &model.APIURL, &model.APISpec); err != nil {
+		model, err := scanModel(rows)
+		if err != nil {
 			return nil, err
 		}
-		models_list = append(models_list, &model)
+		models_list = append(models_list, model)
 	}
 
 	return models_list, nil
 }
 
 func (s *SQLiteDatastore) ListAgents() ([]*models.Agent, error) {
-	rows, err := s.db.Query("SELECT id, name, description, type FROM agents")
+	rows, err := s.db.Query("SELECT id, name, description, type, system_prompt FROM agents")
 	if err != nil {
 		return nil, err
 	}
@@ -267,12 +624,273 @@ func (s *SQLiteDatastore) ListAgents() ([]*models.Agent, error) {
 
 	var agents []*models.Agent
 	for rows.Next() {
-		var agent models.Agent
-		if err := rows.Scan(&agent.ID, &agent.Name, &agent.Description, &agent.Type); err != nil {
+		agent, err := scanAgent(rows)
+		if err != nil {
 			return nil, err
 		}
-		agents = append(agents, &agent)
+		agents = append(agents, agent)
 	}
 
 	return agents, nil
 }
+
+func (db *SQLiteDatastore) DeleteSession(id string) error {
+	tx, err := db.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := unindexSession(tx, id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM audit WHERE session_id = ?", id); err != nil {
+		return err
+	}
+	res, err := tx.Exec("DELETE FROM sessions WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	if err := checkRowsAffected(res); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (db *SQLiteDatastore) DeleteAgent(id string) error {
+	res, err := db.db.Exec("DELETE FROM agents WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	return checkRowsAffected(res)
+}
+
+func (db *SQLiteDatastore) DeleteModel(id string) error {
+	// Sessions keep their model IDs as a comma separated list, so match on the
+	// delimited form to avoid partial ID matches.
+	var refs int
+	err := db.db.QueryRow(`SELECT COUNT(*) FROM sessions WHERE ',' || models || ',' LIKE '%,' || ? || ',%' ESCAPE '\'`, escapeLike(id)).Scan(&refs)
+	if err != nil {
+		return err
+	}
+	if refs > 0 {
+		log.Printf("Warning: deleting model %s which is still referenced by %d session(s)", id, refs)
+	}
+
+	res, err := db.db.Exec("DELETE FROM models WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	return checkRowsAffected(res)
+}
+
+// likeEscaper escapes the LIKE wildcards, for patterns using ESCAPE '\'.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// escapeLike makes s match itself literally in a LIKE pattern, so model IDs
+// like "gpt_4" don't match "gpt-4" too.
+func escapeLike(s string) string {
+	return likeEscaper.Replace(s)
+}
+
+// AddRelationship stores a relationship. Adding the same (source, target, type)
+// triple again is a no-op, so re-running the agent doesn't create duplicates.
+func (s *SQLiteDatastore) AddRelationship(rel *models.Relationship) error {
+	timestamp := rel.Timestamp
+	if timestamp.IsZero() {
+		timestamp = time.Now().UTC()
+	}
+	_, err := s.db.Exec("INSERT OR IGNORE INTO relationships (source, target, type, timestamp) VALUES (?, ?, ?, ?)", rel.Source, rel.Target, rel.Type, timestamp)
+	return err
+}
+
+func (s *SQLiteDatastore) ListRelationships() ([]*models.Relationship, error) {
+	rows, err := s.db.Query("SELECT source, target, type, timestamp FROM relationships ORDER BY target, source, type")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var relationships []*models.Relationship
+	for rows.Next() {
+		var rel models.Relationship
+		var timestamp sql.NullTime
+		if err := rows.Scan(&rel.Source, &rel.Target, &rel.Type, &timestamp); err != nil {
+			return nil, err
+		}
+		rel.Timestamp = timestamp.Time
+		relationships = append(relationships, &rel)
+	}
+
+	return relationships, rows.Err()
+}
+
+// AddChunks stores document chunks with their embeddings. The IDs of the
+// stored chunks are set on chunks.
+func (s *SQLiteDatastore) AddChunks(chunks []*models.Chunk) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	for _, chunk := range chunks {
+		res, err := tx.Exec("INSERT INTO chunks (collection, source, text, embedding, timestamp) VALUES (?, ?, ?, ?, ?)", chunk.Collection, chunk.Source, chunk.Text, encodeEmbedding(chunk.Embedding), now)
+		if err != nil {
+			return err
+		}
+		if chunk.ID, err = res.LastInsertId(); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// SearchChunks returns the k chunks of collection most similar to query.
+func (s *SQLiteDatastore) SearchChunks(collection string, query []float32, k int) ([]*models.Chunk, error) {
+	rows, err := s.db.Query("SELECT id, collection, source, text, embedding FROM chunks WHERE collection = ? ORDER BY id", collection)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var chunks []*models.Chunk
+	for rows.Next() {
+		var chunk models.Chunk
+		var source sql.NullString
+		var embedding []byte
+		if err := rows.Scan(&chunk.ID, &chunk.Collection, &source, &chunk.Text, &embedding); err != nil {
+			return nil, err
+		}
+		chunk.Source = source.String
+		if chunk.Embedding, err = decodeEmbedding(embedding); err != nil {
+			return nil, fmt.Errorf("chunk %d: %w", chunk.ID, err)
+		}
+		chunks = append(chunks, &chunk)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return topChunks(chunks, query, k)
+}
+
+// GetSetting returns the value of key, or sql.ErrNoRows when it isn't set.
+func (s *SQLiteDatastore) GetSetting(key string) (string, error) {
+	var value string
+	err := s.db.QueryRow("SELECT value FROM settings WHERE key = ?", key).Scan(&value)
+	return value, err
+}
+
+func (s *SQLiteDatastore) SetSetting(key, value string) error {
+	_, err := s.db.Exec("INSERT OR REPLACE INTO settings (key, value) VALUES (?, ?)", key, value)
+	return err
+}
+
+func (s *SQLiteDatastore) ListSettings() (map[string]string, error) {
+	rows, err := s.db.Query("SELECT key, value FROM settings")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	settings := make(map[string]string)
+	for rows.Next() {
+		var key, value string
+		if err := rows.Scan(&key, &value); err != nil {
+			return nil, err
+		}
+		settings[key] = value
+	}
+	return settings, rows.Err()
+}
+
+func (s *SQLiteDatastore) AddSchedule(schedule *models.Schedule) error {
+	_, err := s.db.Exec("INSERT OR REPLACE INTO schedules (session_id, interval_seconds, next_run) VALUES (?, ?, ?)", schedule.SessionID, int64(schedule.Interval/time.Second), schedule.NextRun.UTC())
+	return err
+}
+
+func (s *SQLiteDatastore) ListSchedules() ([]*models.Schedule, error) {
+	return listSchedules(s.db)
+}
+
+func (s *SQLiteDatastore) DeleteSchedule(sessionID string) error {
+	res, err := s.db.Exec("DELETE FROM schedules WHERE session_id = ?", sessionID)
+	if err != nil {
+		return err
+	}
+	return checkRowsAffected(res)
+}
+
+// listSchedules reads the schedules table, which is the same in both backends.
+func listSchedules(db *sql.DB) ([]*models.Schedule, error) {
+	rows, err := db.Query("SELECT session_id, interval_seconds, next_run FROM schedules ORDER BY next_run")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var schedules []*models.Schedule
+	for rows.Next() {
+		var schedule models.Schedule
+		var seconds int64
+		if err := rows.Scan(&schedule.SessionID, &seconds, &schedule.NextRun); err != nil {
+			return nil, err
+		}
+		schedule.Interval = time.Duration(seconds) * time.Second
+		schedules = append(schedules, &schedule)
+	}
+	return schedules, rows.Err()
+}
+
+func (s *SQLiteDatastore) AddAuditEntry(entry *models.AuditEntry) error {
+	_, err := s.db.Exec("INSERT INTO audit (session_id, model_id, system_prompt, input, output, error, prompt_tokens, completion_tokens, timestamp) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		entry.SessionID, entry.ModelID, entry.SystemPrompt, entry.Input, entry.Output, entry.Error, entry.PromptTokens, entry.CompletionTokens, entry.Timestamp.UTC())
+	return err
+}
+
+func (s *SQLiteDatastore) ListAuditEntries(sessionID string) ([]*models.AuditEntry, error) {
+	rows, err := s.db.Query("SELECT "+auditColumns+" FROM audit WHERE session_id = ? ORDER BY id", sessionID)
+	if err != nil {
+		return nil, err
+	}
+	return scanAuditEntries(rows)
+}
+
+func (db *SQLiteDatastore) Ping(ctx context.Context) error {
+	return db.db.PingContext(ctx)
+}
+
+func (db *SQLiteDatastore) Close() error {
+	return db.db.Close()
+}
+
+const auditColumns = "session_id, model_id, system_prompt, input, output, error, prompt_tokens, completion_tokens, timestamp"
+
+// scanAuditEntries reads rows of auditColumns, which are the same in both
+// backends.
+func scanAuditEntries(rows *sql.Rows) ([]*models.AuditEntry, error) {
+	defer rows.Close()
+	var entries []*models.AuditEntry
+	for rows.Next() {
+		var entry models.AuditEntry
+		var modelID, systemPrompt, input, output, errText sql.NullString
+		if err := rows.Scan(&entry.SessionID, &modelID, &systemPrompt, &input, &output, &errText, &entry.PromptTokens, &entry.CompletionTokens, &entry.Timestamp); err != nil {
+			return nil, err
+		}
+		entry.ModelID, entry.SystemPrompt, entry.Input, entry.Output, entry.Error = modelID.String, systemPrompt.String, input.String, output.String, errText.String
+		entries = append(entries, &entry)
+	}
+	return entries, rows.Err()
+}
+
+// checkRowsAffected returns sql.ErrNoRows when a statement did not touch any row.
+func checkRowsAffected(res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
@@ -0,0 +1,379 @@
+package database
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nieveai/d-agents/internal/models"
+	pb "github.com/nieveai/d-agents/proto"
+)
+
+// newTestSQLite opens a fresh SQLite datastore in a temporary directory.
+func newTestSQLite(t *testing.T) *SQLiteDatastore {
+	t.Helper()
+	store, err := NewSQLiteDatastore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("NewSQLiteDatastore: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func TestDeleteThenGet(t *testing.T) {
+	tests := []struct {
+		name   string
+		add    func(s *SQLiteDatastore) error
+		get    func(s *SQLiteDatastore) error
+		delete func(s *SQLiteDatastore) error
+	}{
+		{
+			name:   "session",
+			add:    func(s *SQLiteDatastore) error { return s.AddSession(&pb.Workload{Id: "s1", Models: []string{"m1"}}) },
+			get:    func(s *SQLiteDatastore) error { _, err := s.GetSession("s1"); return err },
+			delete: func(s *SQLiteDatastore) error { return s.DeleteSession("s1") },
+		},
+		{
+			name: "agent",
+			add: func(s *SQLiteDatastore) error {
+				return s.AddAgent(&models.Agent{ID: "a1", Name: "Chat", Type: "ChatAgent"})
+			},
+			get:    func(s *SQLiteDatastore) error { _, err := s.GetAgent("a1"); return err },
+			delete: func(s *SQLiteDatastore) error { return s.DeleteAgent("a1") },
+		},
+		{
+			name: "model",
+			add: func(s *SQLiteDatastore) error {
+				return s.AddModel(&models.Model{ID: "m1", ModelID: "gpt-4o", APISpec: "openai"})
+			},
+			get:    func(s *SQLiteDatastore) error { _, err := s.GetModel("m1"); return err },
+			delete: func(s *SQLiteDatastore) error { return s.DeleteModel("m1") },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newTestSQLite(t)
+			if err := tt.add(store); err != nil {
+				t.Fatalf("add: %v", err)
+			}
+			if err := tt.get(store); err != nil {
+				t.Fatalf("get before delete: %v", err)
+			}
+			if err := tt.delete(store); err != nil {
+				t.Fatalf("delete: %v", err)
+			}
+			if err := tt.get(store); !errors.Is(err, sql.ErrNoRows) {
+				t.Errorf("get after delete = %v, want sql.ErrNoRows", err)
+			}
+			if err := tt.delete(store); !errors.Is(err, sql.ErrNoRows) {
+				t.Errorf("second delete = %v, want sql.ErrNoRows", err)
+			}
+		})
+	}
+}
+
+func TestDeleteModelWarnsAboutReferences(t *testing.T) {
+	tests := []struct {
+		name     string
+		sessions [][]string
+		model    string
+		warn     bool
+	}{
+		{"referenced", [][]string{{"gpt-4"}}, "gpt-4", true},
+		{"one of several", [][]string{{"fast", "gpt-4", "slow"}}, "gpt-4", true},
+		{"longer ID", [][]string{{"gpt-4o"}}, "gpt-4", false},
+		{"underscore is not a wildcard", [][]string{{"gpt-4"}}, "gpt_4", false},
+		{"percent is not a wildcard", [][]string{{"gpt-4"}}, "gpt%", false},
+		{"unreferenced", nil, "gpt-4", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newTestSQLite(t)
+			for i, ids := range tt.sessions {
+				if err := store.AddSession(&pb.Workload{Id: string(rune('a' + i)), Models: ids}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := store.AddModel(&models.Model{ID: tt.model, ModelID: "x", APISpec: "openai"}); err != nil {
+				t.Fatal(err)
+			}
+
+			var logs bytes.Buffer
+			log.SetOutput(&logs)
+			err := store.DeleteModel(tt.model)
+			log.SetOutput(os.Stderr)
+			if err != nil {
+				t.Fatalf("DeleteModel: %v", err)
+			}
+			if warned := strings.Contains(logs.String(), "still referenced"); warned != tt.warn {
+				t.Errorf("warned = %v, want %v (log: %q)", warned, tt.warn, logs.String())
+			}
+		})
+	}
+}
+
+func TestEscapeLike(t *testing.T) {
+	tests := map[string]string{
+		"gpt-4":   "gpt-4",
+		"gpt_4":   `gpt\_4`,
+		"100%":    `100\%`,
+		`back\sl`: `back\\sl`,
+	}
+	for in, want := range tests {
+		if got := escapeLike(in); got != want {
+			t.Errorf("escapeLike(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestSessionConfigRoundTrip(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+	}{
+		{"empty", ""},
+		{"json", `{"target_language": "German", "keep_code": true}`},
+		{"multi-line", "line one\nline 'two'\n\"three\""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newTestSQLite(t)
+			if err := store.AddSession(&pb.Workload{Id: "s1", Models: []string{"m1"}, Config: tt.config}); err != nil {
+				t.Fatalf("AddSession: %v", err)
+			}
+			got, err := store.GetSession("s1")
+			if err != nil {
+				t.Fatalf("GetSession: %v", err)
+			}
+			if got.Config != tt.config {
+				t.Errorf("Config = %q, want %q", got.Config, tt.config)
+			}
+		})
+	}
+}
+
+func TestSessionTimestampRoundTrip(t *testing.T) {
+	store := newTestSQLite(t)
+	want := time.Date(2024, 3, 15, 12, 30, 45, 0, time.UTC)
+	if err := store.AddSession(&pb.Workload{Id: "s1", Models: []string{"m1"}, Timestamp: want.Unix()}); err != nil {
+		t.Fatalf("AddSession: %v", err)
+	}
+	got, err := store.GetSession("s1")
+	if err != nil {
+		t.Fatalf("GetSession: %v", err)
+	}
+	if diff := time.Unix(got.Timestamp, 0).Sub(want); diff < -time.Second || diff > time.Second {
+		t.Errorf("Timestamp = %s, want %s", time.Unix(got.Timestamp, 0).UTC(), want)
+	}
+}
+
+func TestSessionTimestampDefaultsToNow(t *testing.T) {
+	store := newTestSQLite(t)
+	before := time.Now().Add(-time.Second)
+	if err := store.AddSession(&pb.Workload{Id: "s1", Models: []string{"m1"}}); err != nil {
+		t.Fatalf("AddSession: %v", err)
+	}
+	got, err := store.GetSession("s1")
+	if err != nil {
+		t.Fatalf("GetSession: %v", err)
+	}
+	if ts := time.Unix(got.Timestamp, 0); ts.Before(before) || ts.After(time.Now().Add(time.Second)) {
+		t.Errorf("Timestamp = %s, want about now", ts)
+	}
+}
+
+func TestSessionUsageRoundTrip(t *testing.T) {
+	store := newTestSQLite(t)
+	want := &pb.Workload{Id: "s1", Models: []string{"m1"}, PromptTokens: 1200, CompletionTokens: 345, EstimatedCost: 0.0125}
+	if err := store.AddSession(want); err != nil {
+		t.Fatalf("AddSession: %v", err)
+	}
+	got, err := store.GetSession("s1")
+	if err != nil {
+		t.Fatalf("GetSession: %v", err)
+	}
+	if got.PromptTokens != want.PromptTokens || got.CompletionTokens != want.CompletionTokens || got.EstimatedCost != want.EstimatedCost {
+		t.Errorf("usage = %d/%d $%v, want %d/%d $%v", got.PromptTokens, got.CompletionTokens, got.EstimatedCost,
+			want.PromptTokens, want.CompletionTokens, want.EstimatedCost)
+	}
+}
+
+func TestModelEnableWebSearchRoundTrip(t *testing.T) {
+	store := newTestSQLite(t)
+	for _, enabled := range []bool{false, true} {
+		id := fmt.Sprintf("gemini-%v", enabled)
+		if err := store.AddModel(&models.Model{ID: id, ModelID: "gemini-2.5-flash", APISpec: "gemini", EnableWebSearch: enabled}); err != nil {
+			t.Fatalf("AddModel: %v", err)
+		}
+		got, err := store.GetModel(id)
+		if err != nil {
+			t.Fatalf("GetModel: %v", err)
+		}
+		if got.EnableWebSearch != enabled {
+			t.Errorf("EnableWebSearch = %v, want %v", got.EnableWebSearch, enabled)
+		}
+	}
+}
+
+func TestModelGenerationParamsRoundTrip(t *testing.T) {
+	store := newTestSQLite(t)
+	temperature, topP, maxTokens := 0.7, 0.9, 1024
+	if err := store.AddModel(&models.Model{ID: "set", ModelID: "gpt-4o", APISpec: "openai", Temperature: &temperature, TopP: &topP, MaxTokens: &maxTokens}); err != nil {
+		t.Fatalf("AddModel: %v", err)
+	}
+	if err := store.AddModel(&models.Model{ID: "unset", ModelID: "gpt-4o", APISpec: "openai"}); err != nil {
+		t.Fatalf("AddModel: %v", err)
+	}
+
+	got, err := store.GetModel("set")
+	if err != nil {
+		t.Fatalf("GetModel: %v", err)
+	}
+	if got.Temperature == nil || *got.Temperature != temperature || got.TopP == nil || *got.TopP != topP || got.MaxTokens == nil || *got.MaxTokens != maxTokens {
+		t.Errorf("params = %v/%v/%v, want %v/%v/%v", got.Temperature, got.TopP, got.MaxTokens, temperature, topP, maxTokens)
+	}
+	got, err = store.GetModel("unset")
+	if err != nil {
+		t.Fatalf("GetModel: %v", err)
+	}
+	if got.Temperature != nil || got.TopP != nil || got.MaxTokens != nil {
+		t.Errorf("unset params = %v/%v/%v, want nil", got.Temperature, got.TopP, got.MaxTokens)
+	}
+}
+
+func TestSessionErrorRoundTrip(t *testing.T) {
+	store := newTestSQLite(t)
+	if err := store.AddSession(&pb.Workload{Id: "s1", Models: []string{"m1"}, Status: pb.WorkloadStatus_FAILED, Error: "error processing workload: boom"}); err != nil {
+		t.Fatalf("AddSession: %v", err)
+	}
+	got, err := store.GetSession("s1")
+	if err != nil {
+		t.Fatalf("GetSession: %v", err)
+	}
+	if got.Status != pb.WorkloadStatus_FAILED || got.Error != "error processing workload: boom" {
+		t.Errorf("session = %v %q", got.Status, got.Error)
+	}
+}
+
+func TestRelationshipDedup(t *testing.T) {
+	forEachDatastore(t, func(t *testing.T, store Datastore) {
+		first := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		rels := []*models.Relationship{
+			{Source: "Acme", Target: "Globex", Type: "vendor", Timestamp: first},
+			{Source: "Acme", Target: "Globex", Type: "vendor", Timestamp: first.Add(time.Hour)},
+			{Source: "Acme", Target: "Globex", Type: "customer"},
+			{Source: "Initech", Target: "Globex", Type: "vendor"},
+			{Source: "Acme", Target: "Globex", Type: "vendor"},
+		}
+		for _, rel := range rels {
+			if err := store.AddRelationship(rel); err != nil {
+				t.Fatalf("AddRelationship: %v", err)
+			}
+		}
+
+		got, err := store.ListRelationships()
+		if err != nil {
+			t.Fatalf("ListRelationships: %v", err)
+		}
+		var keys []string
+		for _, rel := range got {
+			keys = append(keys, rel.Source+">"+rel.Target+":"+rel.Type)
+		}
+		want := []string{"Acme>Globex:customer", "Acme>Globex:vendor", "Initech>Globex:vendor"}
+		if strings.Join(keys, ",") != strings.Join(want, ",") {
+			t.Errorf("relationships = %v, want %v", keys, want)
+		}
+		// The first one added is kept.
+		if !got[1].Timestamp.Equal(first) {
+			t.Errorf("timestamp = %s, want the first one's %s", got[1].Timestamp, first)
+		}
+	})
+}
+
+func TestConcurrentAddSession(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	// Two handles on one file, like two worker processes sharing a database.
+	var stores []*SQLiteDatastore
+	for range 2 {
+		store, err := NewSQLiteDatastore(path)
+		if err != nil {
+			t.Fatalf("NewSQLiteDatastore: %v", err)
+		}
+		t.Cleanup(func() { store.Close() })
+		stores = append(stores, store)
+	}
+
+	var mode string
+	if err := stores[0].db.QueryRow("PRAGMA journal_mode").Scan(&mode); err != nil || mode != "wal" {
+		t.Errorf("journal_mode = %q, %v, want wal", mode, err)
+	}
+
+	const n = 100
+	errs := make(chan error, n)
+	var wg sync.WaitGroup
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			store := stores[i%len(stores)]
+			session := &pb.Workload{Id: fmt.Sprintf("s%d", i), Models: []string{"m1"}, Payload: []byte("hello"), Status: pb.WorkloadStatus_RUNNING}
+			if err := store.AddSession(session); err != nil {
+				errs <- err
+				return
+			}
+			session.Status = pb.WorkloadStatus_COMPLETED
+			errs <- store.AddSession(session)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("AddSession: %v", err)
+		}
+	}
+
+	sessions, err := stores[1].ListSessions()
+	if err != nil {
+		t.Fatalf("ListSessions: %v", err)
+	}
+	if len(sessions) != n {
+		t.Errorf("%d sessions stored, want %d", len(sessions), n)
+	}
+}
+
+func TestUnknownStoredStatus(t *testing.T) {
+	tests := []struct {
+		stored string
+		want   pb.WorkloadStatus_Status
+	}{
+		{"CANCELLED", pb.WorkloadStatus_CANCELLED},
+		{" failed", pb.WorkloadStatus_FAILED},
+		{"ABORTED", pb.WorkloadStatus_UNKNOWN},
+		{"", pb.WorkloadStatus_UNKNOWN},
+	}
+	store := newTestSQLite(t)
+	if err := store.AddSession(&pb.Workload{Id: "s1", Status: pb.WorkloadStatus_RUNNING}); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		if _, err := store.db.Exec("UPDATE sessions SET status = ? WHERE id = 's1'", tt.stored); err != nil {
+			t.Fatal(err)
+		}
+		got, err := store.GetSession("s1")
+		if err != nil {
+			t.Fatalf("GetSession with status %q: %v", tt.stored, err)
+		}
+		if got.Status != tt.want {
+			t.Errorf("stored %q loads as %v, want %v", tt.stored, got.Status, tt.want)
+		}
+	}
+}
@@ -0,0 +1,511 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"maps"
+	"slices"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/nieveai/d-agents/internal/models"
+	pb "github.com/nieveai/d-agents/proto"
+	"google.golang.org/protobuf/proto"
+)
+
+// datastores are the Datastore implementations the shared tests run against.
+var datastores = map[string]func(t *testing.T) Datastore{
+	"sqlite": func(t *testing.T) Datastore { return newTestSQLite(t) },
+	"memory": func(t *testing.T) Datastore { return NewMemoryDatastore() },
+}
+
+// forEachDatastore runs test against a fresh store of every implementation.
+func forEachDatastore(t *testing.T, test func(t *testing.T, store Datastore)) {
+	t.Helper()
+	for _, name := range slices.Sorted(maps.Keys(datastores)) {
+		t.Run(name, func(t *testing.T) { test(t, datastores[name](t)) })
+	}
+}
+
+func TestDatastoreAgents(t *testing.T) {
+	forEachDatastore(t, func(t *testing.T, store Datastore) {
+		agents := []*models.Agent{
+			{ID: "a2", Name: "Shopper", Description: "finds deals", Type: "ShoppingAgent"},
+			{ID: "a1", Name: "Chat", Type: "ChatAgent", SystemPrompt: "be brief"},
+		}
+		for _, agent := range agents {
+			if err := store.AddAgent(agent); err != nil {
+				t.Fatalf("AddAgent: %v", err)
+			}
+		}
+
+		got, err := store.GetAgent("a1")
+		if err != nil {
+			t.Fatalf("GetAgent: %v", err)
+		}
+		if *got != *agents[1] {
+			t.Errorf("GetAgent = %+v, want %+v", *got, *agents[1])
+		}
+
+		// Adding an agent again replaces it.
+		if err := store.AddAgent(&models.Agent{ID: "a1", Name: "Chat 2", Type: "ChatAgent"}); err != nil {
+			t.Fatalf("AddAgent: %v", err)
+		}
+		list, err := store.ListAgents()
+		if err != nil {
+			t.Fatalf("ListAgents: %v", err)
+		}
+		sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+		if len(list) != 2 || list[0].Name != "Chat 2" || list[1].Name != "Shopper" {
+			t.Errorf("ListAgents = %v", list)
+		}
+
+		if _, err := store.GetAgent("missing"); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("GetAgent(missing) = %v, want sql.ErrNoRows", err)
+		}
+	})
+}
+
+func TestDatastoreSessions(t *testing.T) {
+	forEachDatastore(t, func(t *testing.T, store Datastore) {
+		session := &pb.Workload{
+			Id:             "s1",
+			Name:           "Prices",
+			Models:         []string{"m1", "m2"},
+			Payload:        []byte("hello\x00world"),
+			Timestamp:      1700000000,
+			AgentId:        "a1",
+			Status:         pb.WorkloadStatus_RUNNING,
+			AgentType:      "ChatAgent",
+			Config:         `{"stream": true}`,
+			RetryCount:     2,
+			Pipeline:       []string{"ChatAgent", "TranslationAgent"},
+			FallbackModels: []string{"m3"},
+			DryRun:         true,
+			Priority:       1,
+			Tags:           []string{"weekly"},
+			History:        []*pb.ChatMessage{{Role: "user", Content: "hi\n\n---\n\nthere"}, {Role: "assistant", Content: `"hello"`}},
+		}
+		if err := store.AddSession(session); err != nil {
+			t.Fatalf("AddSession: %v", err)
+		}
+		got, err := store.GetSession("s1")
+		if err != nil {
+			t.Fatalf("GetSession: %v", err)
+		}
+		if !proto.Equal(got, session) {
+			t.Errorf("GetSession = %v\nwant %v", got, session)
+		}
+
+		// Changing what was returned doesn't change what is stored.
+		got.Payload[0] = 'J'
+		got.Models[0] = "changed"
+		if again, _ := store.GetSession("s1"); again.Payload[0] != 'h' || again.Models[0] != "m1" {
+			t.Errorf("the stored session changed with the returned one: %v", again)
+		}
+
+		updated := proto.Clone(session).(*pb.Workload)
+		updated.Status = pb.WorkloadStatus_COMPLETED
+		updated.Payload = []byte("done")
+		if err := store.AddSession(updated); err != nil {
+			t.Fatalf("AddSession: %v", err)
+		}
+		if err := store.AddSession(&pb.Workload{Id: "s2", Models: []string{"m1"}}); err != nil {
+			t.Fatalf("AddSession: %v", err)
+		}
+		list, err := store.ListSessions()
+		if err != nil {
+			t.Fatalf("ListSessions: %v", err)
+		}
+		sort.Slice(list, func(i, j int) bool { return list[i].Id < list[j].Id })
+		if len(list) != 2 || list[0].Status != pb.WorkloadStatus_COMPLETED || string(list[0].Payload) != "done" {
+			t.Errorf("ListSessions = %v", list)
+		}
+	})
+}
+
+func TestDatastoreModels(t *testing.T) {
+	forEachDatastore(t, func(t *testing.T, store Datastore) {
+		model := &models.Model{ID: "m1", Provider: "openai", ModelID: "gpt-4o", APISpec: "openai", APIKey: "key", APIURL: "https://api.test", RPM: 60}
+		if err := store.AddModel(model); err != nil {
+			t.Fatalf("AddModel: %v", err)
+		}
+		got, err := store.GetModel("m1")
+		if err != nil {
+			t.Fatalf("GetModel: %v", err)
+		}
+		if got.ModelID != "gpt-4o" || got.APIKey != "key" || got.APIURL != "https://api.test" || got.RPM != 60 {
+			t.Errorf("GetModel = %+v", got)
+		}
+
+		got.ModelID = "gpt-4o-mini"
+		if err := store.UpdateModel(got); err != nil {
+			t.Fatalf("UpdateModel: %v", err)
+		}
+		if again, _ := store.GetModel("m1"); again.ModelID != "gpt-4o-mini" {
+			t.Errorf("ModelID after UpdateModel = %q", again.ModelID)
+		}
+		if err := store.UpdateModel(&models.Model{ID: "missing", ModelID: "x", APISpec: "openai"}); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("UpdateModel(missing) = %v, want sql.ErrNoRows", err)
+		}
+
+		list, err := store.ListModels()
+		if err != nil || len(list) != 1 {
+			t.Errorf("ListModels = %v, %v", list, err)
+		}
+	})
+}
+
+func TestDatastoreDeleteThenGet(t *testing.T) {
+	forEachDatastore(t, func(t *testing.T, store Datastore) {
+		store.AddSession(&pb.Workload{Id: "s1", Models: []string{"m1"}})
+		store.AddAgent(&models.Agent{ID: "a1", Name: "Chat", Type: "ChatAgent"})
+		store.AddModel(&models.Model{ID: "m1", ModelID: "gpt-4o", APISpec: "openai"})
+
+		for _, step := range []struct {
+			name   string
+			delete func(id string) error
+			get    func(id string) error
+			id     string
+		}{
+			{"session", store.DeleteSession, func(id string) error { _, err := store.GetSession(id); return err }, "s1"},
+			{"agent", store.DeleteAgent, func(id string) error { _, err := store.GetAgent(id); return err }, "a1"},
+			{"model", store.DeleteModel, func(id string) error { _, err := store.GetModel(id); return err }, "m1"},
+		} {
+			if err := step.delete(step.id); err != nil {
+				t.Errorf("delete %s: %v", step.name, err)
+			}
+			if err := step.get(step.id); !errors.Is(err, sql.ErrNoRows) {
+				t.Errorf("get %s after delete = %v, want sql.ErrNoRows", step.name, err)
+			}
+			if err := step.delete(step.id); !errors.Is(err, sql.ErrNoRows) {
+				t.Errorf("second delete of %s = %v, want sql.ErrNoRows", step.name, err)
+			}
+		}
+	})
+}
+
+func TestDatastoreReimport(t *testing.T) {
+	imports := []struct {
+		agent, model string
+	}{
+		{`{"id": "a1", "name": "Chat", "type": "ChatAgent"}`, `{"id": "m1", "model_id": "gpt-4o", "api_spec": "openai", "api_key": "old"}`},
+		{`{"id": "a1", "name": "Chat v2", "description": "newer", "type": "ChatAgent"}`, `{"id": "m1", "model_id": "gpt-4o-mini", "api_spec": "openai", "api_key": "new"}`},
+	}
+	forEachDatastore(t, func(t *testing.T, store Datastore) {
+		for _, imp := range imports {
+			var agent models.Agent
+			var model models.Model
+			if err := json.Unmarshal([]byte(imp.agent), &agent); err != nil {
+				t.Fatal(err)
+			}
+			if err := json.Unmarshal([]byte(imp.model), &model); err != nil {
+				t.Fatal(err)
+			}
+			if err := store.AddAgent(&agent); err != nil {
+				t.Fatalf("AddAgent: %v", err)
+			}
+			if err := store.AddModel(&model); err != nil {
+				t.Fatalf("AddModel: %v", err)
+			}
+		}
+
+		agent, err := store.GetAgent("a1")
+		if err != nil || agent.Name != "Chat v2" || agent.Description != "newer" {
+			t.Errorf("GetAgent = %+v, %v, want the second import", agent, err)
+		}
+		model, err := store.GetModel("m1")
+		if err != nil || model.ModelID != "gpt-4o-mini" || model.APIKey != "new" {
+			t.Errorf("GetModel = %+v, %v, want the second import", model, err)
+		}
+		if list, _ := store.ListModels(); len(list) != 1 {
+			t.Errorf("%d models after re-importing, want 1", len(list))
+		}
+	})
+}
+
+func TestDatastoreSessionStatuses(t *testing.T) {
+	forEachDatastore(t, func(t *testing.T, store Datastore) {
+		for value, name := range pb.WorkloadStatus_Status_name {
+			status := pb.WorkloadStatus_Status(value)
+			if err := store.AddSession(&pb.Workload{Id: name, Status: status}); err != nil {
+				t.Fatalf("AddSession(%s): %v", name, err)
+			}
+			got, err := store.GetSession(name)
+			if err != nil {
+				t.Fatalf("GetSession(%s): %v", name, err)
+			}
+			if got.Status != status {
+				t.Errorf("status = %v, want %v", got.Status, status)
+			}
+		}
+	})
+}
+
+func TestDatastoreSettings(t *testing.T) {
+	forEachDatastore(t, func(t *testing.T, store Datastore) {
+		if _, err := store.GetSetting(SettingWorkers); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("GetSetting of a missing key = %v, want sql.ErrNoRows", err)
+		}
+		for _, value := range []string{"4", "8"} {
+			if err := store.SetSetting(SettingWorkers, value); err != nil {
+				t.Fatalf("SetSetting: %v", err)
+			}
+			if got, err := store.GetSetting(SettingWorkers); err != nil || got != value {
+				t.Errorf("GetSetting = %q, %v, want %q", got, err, value)
+			}
+		}
+		if err := store.SetSetting(SettingNeo4jURI, "neo4j://db:7687"); err != nil {
+			t.Fatalf("SetSetting: %v", err)
+		}
+		settings, err := store.ListSettings()
+		if err != nil {
+			t.Fatalf("ListSettings: %v", err)
+		}
+		want := map[string]string{SettingWorkers: "8", SettingNeo4jURI: "neo4j://db:7687"}
+		if !maps.Equal(settings, want) {
+			t.Errorf("ListSettings = %v, want %v", settings, want)
+		}
+	})
+}
+
+func TestDatastoreSearchSessions(t *testing.T) {
+	forEachDatastore(t, func(t *testing.T, store Datastore) {
+		sessions := []*pb.Workload{
+			{Id: "s1", Name: "Nvidia suppliers", Payload: []byte("TSMC makes the chips, 100% of them."), Timestamp: 1700000000},
+			{Id: "s2", Name: "Prices", Payload: []byte("The hub from Anker costs $24."), Timestamp: 1700000100},
+			{Id: "s3", Name: "Chat", Payload: []byte("Is TSMC bigger than Anker?"), Timestamp: 1700000200},
+		}
+		for _, session := range sessions {
+			if err := store.AddSession(session); err != nil {
+				t.Fatalf("AddSession: %v", err)
+			}
+		}
+
+		tests := []struct {
+			query string
+			want  []string
+		}{
+			{"TSMC", []string{"s3", "s1"}},
+			{"tsmc", []string{"s3", "s1"}},
+			{"nvidia", []string{"s1"}},
+			{"anker hub", []string{"s2"}},
+			{"Samsung", nil},
+			{"TSMC Samsung", nil},
+			{`TSMC" OR "Samsung`, nil},
+			{"   ", nil},
+		}
+		for _, tt := range tests {
+			found, err := store.SearchSessions(tt.query)
+			if err != nil {
+				t.Errorf("SearchSessions(%q): %v", tt.query, err)
+				continue
+			}
+			var ids []string
+			for _, session := range found {
+				ids = append(ids, session.Id)
+			}
+			if !slices.Equal(ids, tt.want) {
+				t.Errorf("SearchSessions(%q) = %v, want %v", tt.query, ids, tt.want)
+			}
+		}
+
+		// Saving a session again replaces its text in the index.
+		if err := store.AddSession(&pb.Workload{Id: "s1", Name: "Nvidia suppliers", Payload: []byte("Samsung too.")}); err != nil {
+			t.Fatal(err)
+		}
+		if found, _ := store.SearchSessions("TSMC"); len(found) != 1 || found[0].Id != "s3" {
+			t.Errorf("SearchSessions(TSMC) after the update = %v, want only s3", found)
+		}
+		if found, _ := store.SearchSessions("samsung"); len(found) != 1 || found[0].Id != "s1" {
+			t.Errorf("SearchSessions(samsung) after the update = %v, want s1", found)
+		}
+		if err := store.DeleteSession("s3"); err != nil {
+			t.Fatal(err)
+		}
+		if found, _ := store.SearchSessions("TSMC"); len(found) != 0 {
+			t.Errorf("SearchSessions(TSMC) after deleting s3 = %v, want nothing", found)
+		}
+	})
+}
+
+func TestDatastoreHeartbeat(t *testing.T) {
+	forEachDatastore(t, func(t *testing.T, store Datastore) {
+		store.AddSession(&pb.Workload{Id: "running", Status: pb.WorkloadStatus_RUNNING})
+		store.AddSession(&pb.Workload{Id: "done", Status: pb.WorkloadStatus_COMPLETED})
+		at := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+		for _, id := range []string{"running", "done", "missing"} {
+			if err := store.SetHeartbeat(id, at); err != nil {
+				t.Errorf("SetHeartbeat(%s): %v", id, err)
+			}
+		}
+		if got, _ := store.GetSession("running"); got.LastHeartbeat != at.Unix() {
+			t.Errorf("heartbeat of the running session = %d, want %d", got.LastHeartbeat, at.Unix())
+		}
+		// A finished session isn't brought back to look alive.
+		if got, _ := store.GetSession("done"); got.LastHeartbeat != 0 {
+			t.Errorf("heartbeat of the finished session = %d, want none", got.LastHeartbeat)
+		}
+	})
+}
+
+func TestDatastoreProgress(t *testing.T) {
+	forEachDatastore(t, func(t *testing.T, store Datastore) {
+		store.AddSession(&pb.Workload{Id: "running", Status: pb.WorkloadStatus_RUNNING})
+		store.AddSession(&pb.Workload{Id: "done", Status: pb.WorkloadStatus_COMPLETED, Progress: 100})
+		for _, id := range []string{"running", "done", "missing"} {
+			if err := store.SetProgress(id, 45); err != nil {
+				t.Errorf("SetProgress(%s): %v", id, err)
+			}
+		}
+		if got, _ := store.GetSession("running"); got.Progress != 45 {
+			t.Errorf("progress of the running session = %d, want 45", got.Progress)
+		}
+		if got, _ := store.GetSession("done"); got.Progress != 100 {
+			t.Errorf("progress of the finished session = %d, want it left at 100", got.Progress)
+		}
+	})
+}
+
+func TestDatastoreSchedules(t *testing.T) {
+	forEachDatastore(t, func(t *testing.T, store Datastore) {
+		now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+		store.AddSchedule(&models.Schedule{SessionID: "hourly", Interval: time.Hour, NextRun: now.Add(time.Hour)})
+		store.AddSchedule(&models.Schedule{SessionID: "daily", Interval: 24 * time.Hour, NextRun: now.Add(30 * time.Minute)})
+		// Replaces the first schedule of the session.
+		if err := store.AddSchedule(&models.Schedule{SessionID: "hourly", Interval: time.Hour, NextRun: now.Add(2 * time.Hour)}); err != nil {
+			t.Fatalf("AddSchedule: %v", err)
+		}
+
+		schedules, err := store.ListSchedules()
+		if err != nil {
+			t.Fatalf("ListSchedules: %v", err)
+		}
+		if len(schedules) != 2 {
+			t.Fatalf("ListSchedules returned %d schedules, want 2", len(schedules))
+		}
+		// The next one due comes first.
+		if s := schedules[0]; s.SessionID != "daily" || s.Interval != 24*time.Hour || !s.NextRun.Equal(now.Add(30*time.Minute)) {
+			t.Errorf("first schedule = %+v", s)
+		}
+		if s := schedules[1]; s.SessionID != "hourly" || !s.NextRun.Equal(now.Add(2*time.Hour)) {
+			t.Errorf("second schedule = %+v", s)
+		}
+
+		if err := store.DeleteSchedule("daily"); err != nil {
+			t.Fatalf("DeleteSchedule: %v", err)
+		}
+		if err := store.DeleteSchedule("daily"); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("DeleteSchedule of a missing schedule = %v, want sql.ErrNoRows", err)
+		}
+		if schedules, _ := store.ListSchedules(); len(schedules) != 1 {
+			t.Errorf("%d schedules left, want 1", len(schedules))
+		}
+	})
+}
+
+func TestDatastoreTags(t *testing.T) {
+	forEachDatastore(t, func(t *testing.T, store Datastore) {
+		store.AddSession(&pb.Workload{Id: "s1", Timestamp: 1})
+		store.AddSession(&pb.Workload{Id: "s2", Timestamp: 2, Tags: []string{"shopping"}})
+		store.AddSession(&pb.Workload{Id: "s3", Timestamp: 3})
+
+		for _, add := range [][2]string{{"s1", "Research"}, {"s1", "shopping"}, {"s1", "research"}, {"s3", "test"}} {
+			if err := store.AddTag(add[0], add[1]); err != nil {
+				t.Fatalf("AddTag(%q, %q): %v", add[0], add[1], err)
+			}
+		}
+		if got, _ := store.GetSession("s1"); !slices.Equal(got.Tags, []string{"research", "shopping"}) {
+			t.Errorf("tags of s1 = %q, want research and shopping once each", got.Tags)
+		}
+		// Sessions without tags load as before.
+		if got, _ := store.GetSession("s3"); !slices.Equal(got.Tags, []string{"test"}) {
+			t.Errorf("tags of s3 = %q", got.Tags)
+		}
+
+		tagged, err := store.ListSessionsByTag("Shopping")
+		if err != nil {
+			t.Fatalf("ListSessionsByTag: %v", err)
+		}
+		var ids []string
+		for _, session := range tagged {
+			ids = append(ids, session.Id)
+		}
+		if !slices.Equal(ids, []string{"s1", "s2"}) {
+			t.Errorf("ListSessionsByTag(shopping) = %q, want s1 and s2, oldest first", ids)
+		}
+		// A tag only matches whole tags.
+		if tagged, _ := store.ListSessionsByTag("shop"); len(tagged) != 0 {
+			t.Errorf("ListSessionsByTag(shop) returned %d sessions", len(tagged))
+		}
+
+		if err := store.RemoveTag("s1", "shopping"); err != nil {
+			t.Fatalf("RemoveTag: %v", err)
+		}
+		if tagged, _ := store.ListSessionsByTag("shopping"); len(tagged) != 1 || tagged[0].Id != "s2" {
+			t.Errorf("ListSessionsByTag after RemoveTag = %v, want only s2", tagged)
+		}
+		// Saving the session again keeps its tags.
+		session, _ := store.GetSession("s1")
+		session.Tags = nil
+		store.AddSession(session)
+		if got, _ := store.GetSession("s1"); !slices.Equal(got.Tags, []string{"research"}) {
+			t.Errorf("tags after AddSession = %q, want them kept", got.Tags)
+		}
+
+		if err := store.AddTag("missing", "test"); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("AddTag of a missing session = %v, want sql.ErrNoRows", err)
+		}
+		if err := store.AddTag("s1", "two words"); err == nil {
+			t.Error("AddTag accepted a tag with a space")
+		}
+	})
+}
+
+func TestDatastoreAudit(t *testing.T) {
+	forEachDatastore(t, func(t *testing.T, store Datastore) {
+		store.AddSession(&pb.Workload{Id: "s1"})
+		store.AddSession(&pb.Workload{Id: "s2"})
+		at := time.Date(2024, 5, 6, 9, 0, 0, 0, time.FixedZone("CEST", 2*60*60))
+		entries := []*models.AuditEntry{
+			{SessionID: "s1", ModelID: "m1", SystemPrompt: "be brief", Input: "hello", Output: "hi", PromptTokens: 10, CompletionTokens: 5, Timestamp: at},
+			{SessionID: "s2", ModelID: "m1", Input: "other", Output: "session", Timestamp: at},
+			{SessionID: "s1", ModelID: "m2", Input: "again", Error: "quota exceeded", Timestamp: at.Add(time.Second)},
+		}
+		for _, entry := range entries {
+			if err := store.AddAuditEntry(entry); err != nil {
+				t.Fatalf("AddAuditEntry: %v", err)
+			}
+		}
+
+		got, err := store.ListAuditEntries("s1")
+		if err != nil {
+			t.Fatalf("ListAuditEntries: %v", err)
+		}
+		if len(got) != 2 {
+			t.Fatalf("got %d entries for s1, want 2", len(got))
+		}
+		for i, want := range []*models.AuditEntry{entries[0], entries[2]} {
+			if !got[i].Timestamp.Equal(want.Timestamp) {
+				t.Errorf("entry %d timestamp = %v, want %v", i, got[i].Timestamp, want.Timestamp)
+			}
+			g, w := *got[i], *want
+			g.Timestamp, w.Timestamp = time.Time{}, time.Time{}
+			if g != w {
+				t.Errorf("entry %d = %+v, want %+v", i, g, w)
+			}
+		}
+
+		if err := store.DeleteSession("s1"); err != nil {
+			t.Fatalf("DeleteSession: %v", err)
+		}
+		if got, _ := store.ListAuditEntries("s1"); len(got) != 0 {
+			t.Errorf("got %d entries of a deleted session", len(got))
+		}
+		if got, _ := store.ListAuditEntries("s2"); len(got) != 1 {
+			t.Errorf("got %d entries for s2 after deleting s1, want 1", len(got))
+		}
+	})
+}
@@ -0,0 +1,74 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// DigestDB remembers how far EmailDigestAgent got in each mailbox.
+type DigestDB struct {
+	*sql.DB
+}
+
+// MailboxState is the last message of a mailbox that was put in a digest.
+// UIDs are only comparable while the mailbox keeps its UIDValidity.
+type MailboxState struct {
+	UIDValidity uint32
+	LastUID     uint32
+}
+
+func NewDigestDB() (*DigestDB, error) {
+	db, err := openSQLite("./email_digest.db")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS mailboxes (
+			account TEXT NOT NULL,
+			mailbox TEXT NOT NULL,
+			uid_validity INTEGER NOT NULL,
+			last_uid INTEGER NOT NULL,
+			updated TEXT,
+			PRIMARY KEY(account, mailbox)
+		)
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create table: %w", err)
+	}
+
+	return &DigestDB{db}, nil
+}
+
+// GetMailboxState returns where the last digest of mailbox in account
+// stopped, or a zero state if there was none yet.
+func (db *DigestDB) GetMailboxState(account, mailbox string) (MailboxState, error) {
+	var state MailboxState
+	err := db.QueryRow("SELECT uid_validity, last_uid FROM mailboxes WHERE account = ? AND mailbox = ?", account, mailbox).
+		Scan(&state.UIDValidity, &state.LastUID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return MailboxState{}, nil
+	}
+	if err != nil {
+		return MailboxState{}, fmt.Errorf("failed to get state of mailbox %s: %w", mailbox, err)
+	}
+	return state, nil
+}
+
+// SetMailboxState records state as the last message of mailbox in account
+// that was put in a digest.
+func (db *DigestDB) SetMailboxState(account, mailbox string, state MailboxState) error {
+	_, err := db.Exec(`
+		INSERT INTO mailboxes (account, mailbox, uid_validity, last_uid, updated) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(account, mailbox) DO UPDATE SET uid_validity = excluded.uid_validity, last_uid = excluded.last_uid, updated = excluded.updated`,
+		account, mailbox, state.UIDValidity, state.LastUID, time.Now().Format(time.RFC3339),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save state of mailbox %s: %w", mailbox, err)
+	}
+	return nil
+}
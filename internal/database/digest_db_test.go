@@ -0,0 +1,40 @@
+package database
+
+import "testing"
+
+func TestDigestDBMailboxState(t *testing.T) {
+	t.Chdir(t.TempDir())
+	db, err := NewDigestDB()
+	if err != nil {
+		t.Fatalf("NewDigestDB: %v", err)
+	}
+	defer db.Close()
+
+	if state, err := db.GetMailboxState("me@imap.test:993", "INBOX"); err != nil || state != (MailboxState{}) {
+		t.Errorf("state before the first digest = %+v, %v, want a zero state", state, err)
+	}
+
+	if err := db.SetMailboxState("me@imap.test:993", "INBOX", MailboxState{UIDValidity: 7, LastUID: 12}); err != nil {
+		t.Fatalf("SetMailboxState: %v", err)
+	}
+	if err := db.SetMailboxState("me@imap.test:993", "INBOX", MailboxState{UIDValidity: 7, LastUID: 20}); err != nil {
+		t.Fatalf("SetMailboxState: %v", err)
+	}
+	if err := db.SetMailboxState("me@imap.test:993", "Work", MailboxState{UIDValidity: 3, LastUID: 5}); err != nil {
+		t.Fatalf("SetMailboxState: %v", err)
+	}
+
+	tests := []struct {
+		account, mailbox string
+		want             MailboxState
+	}{
+		{"me@imap.test:993", "INBOX", MailboxState{UIDValidity: 7, LastUID: 20}},
+		{"me@imap.test:993", "Work", MailboxState{UIDValidity: 3, LastUID: 5}},
+		{"you@imap.test:993", "INBOX", MailboxState{}},
+	}
+	for _, tt := range tests {
+		if state, err := db.GetMailboxState(tt.account, tt.mailbox); err != nil || state != tt.want {
+			t.Errorf("GetMailboxState(%q, %q) = %+v, %v, want %+v", tt.account, tt.mailbox, state, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,462 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nieveai/d-agents/internal/models"
+	pb "github.com/nieveai/d-agents/proto"
+	"google.golang.org/protobuf/proto"
+)
+
+// MemoryDatastore is a map backed Datastore for tests. It behaves like
+// SQLiteDatastore: missing rows return sql.ErrNoRows, adding an existing ID
+// replaces it, and everything going in or out is copied so callers can't
+// change stored values behind its back.
+type MemoryDatastore struct {
+	mu            sync.RWMutex
+	agents        map[string]*models.Agent
+	sessions      map[string]*pb.Workload
+	models        map[string]*models.Model
+	relationships map[models.Relationship]time.Time
+	chunks        []*models.Chunk
+	settings      map[string]string
+	schedules     map[string]models.Schedule
+	audit         map[string][]models.AuditEntry
+	closed        bool
+}
+
+var _ Datastore = (*MemoryDatastore)(nil)
+
+func NewMemoryDatastore() *MemoryDatastore {
+	return &MemoryDatastore{
+		agents:        make(map[string]*models.Agent),
+		sessions:      make(map[string]*pb.Workload),
+		models:        make(map[string]*models.Model),
+		relationships: make(map[models.Relationship]time.Time),
+		settings:      make(map[string]string),
+		schedules:     make(map[string]models.Schedule),
+		audit:         make(map[string][]models.AuditEntry),
+	}
+}
+
+func (s *MemoryDatastore) AddAgent(agent *models.Agent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a := *agent
+	s.agents[agent.ID] = &a
+	return nil
+}
+
+func (s *MemoryDatastore) GetAgent(id string) (*models.Agent, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	agent, ok := s.agents[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	a := *agent
+	return &a, nil
+}
+
+func (s *MemoryDatastore) ListAgents() ([]*models.Agent, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var agents []*models.Agent
+	for _, id := range sortedKeys(s.agents) {
+		a := *s.agents[id]
+		agents = append(agents, &a)
+	}
+	return agents, nil
+}
+
+func (s *MemoryDatastore) DeleteAgent(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.agents[id]; !ok {
+		return sql.ErrNoRows
+	}
+	delete(s.agents, id)
+	return nil
+}
+
+func (s *MemoryDatastore) AddSession(session *pb.Workload) error {
+	stored := proto.Clone(session).(*pb.Workload)
+	// Match SQLiteDatastore, which only keeps whole seconds and fills in now.
+	if stored.Timestamp == 0 {
+		stored.Timestamp = time.Now().Unix()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// Like the other stores, only new sessions take their tags from session.
+	if existing, ok := s.sessions[session.Id]; ok {
+		stored.Tags = existing.Tags
+	}
+	s.sessions[session.Id] = stored
+	return nil
+}
+
+func (s *MemoryDatastore) GetSession(id string) (*pb.Workload, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	session, ok := s.sessions[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return proto.Clone(session).(*pb.Workload), nil
+}
+
+func (s *MemoryDatastore) ListSessions() ([]*pb.Workload, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var sessions []*pb.Workload
+	for _, id := range sortedKeys(s.sessions) {
+		sessions = append(sessions, proto.Clone(s.sessions[id]).(*pb.Workload))
+	}
+	return sessions, nil
+}
+
+func (s *MemoryDatastore) SetHeartbeat(id string, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if session, ok := s.sessions[id]; ok && session.Status == pb.WorkloadStatus_RUNNING {
+		session.LastHeartbeat = at.Unix()
+	}
+	return nil
+}
+
+func (s *MemoryDatastore) SetProgress(id string, pct int32) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if session, ok := s.sessions[id]; ok && session.Status == pb.WorkloadStatus_RUNNING {
+		session.Progress = pct
+	}
+	return nil
+}
+
+func (s *MemoryDatastore) AddTag(id, tag string) error {
+	return s.updateTags(id, tag, withTag)
+}
+
+func (s *MemoryDatastore) RemoveTag(id, tag string) error {
+	return s.updateTags(id, tag, withoutTag)
+}
+
+func (s *MemoryDatastore) updateTags(id, tag string, update func([]string, string) []string) error {
+	tag, err := NormalizeTag(tag)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session, ok := s.sessions[id]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	session.Tags = update(session.Tags, tag)
+	return nil
+}
+
+func (s *MemoryDatastore) ListSessionsByTag(tag string) ([]*pb.Workload, error) {
+	tag, err := NormalizeTag(tag)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var sessions []*pb.Workload
+	for _, id := range sortedKeys(s.sessions) {
+		if session := s.sessions[id]; slices.Contains(session.Tags, tag) {
+			sessions = append(sessions, proto.Clone(session).(*pb.Workload))
+		}
+	}
+	sort.SliceStable(sessions, func(i, j int) bool {
+		return sessions[i].Timestamp < sessions[j].Timestamp
+	})
+	return sessions, nil
+}
+
+func (s *MemoryDatastore) SearchSessions(query string) ([]*pb.Workload, error) {
+	words := strings.Fields(strings.ToLower(query))
+	if len(words) == 0 {
+		return nil, nil
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var sessions []*pb.Workload
+	for _, id := range sortedKeys(s.sessions) {
+		session := s.sessions[id]
+		text := strings.ToLower(session.Name + "\n" + string(session.Payload))
+		found := true
+		for _, word := range words {
+			if !strings.Contains(text, word) {
+				found = false
+				break
+			}
+		}
+		if found {
+			sessions = append(sessions, proto.Clone(session).(*pb.Workload))
+		}
+	}
+	sort.SliceStable(sessions, func(i, j int) bool {
+		return sessions[i].Timestamp > sessions[j].Timestamp
+	})
+	return sessions, nil
+}
+
+func (s *MemoryDatastore) DeleteSession(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.sessions[id]; !ok {
+		return sql.ErrNoRows
+	}
+	delete(s.sessions, id)
+	delete(s.audit, id)
+	return nil
+}
+
+func (s *MemoryDatastore) AddModel(model *models.Model) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.models[model.ID] = copyModel(model)
+	return nil
+}
+
+func (s *MemoryDatastore) UpdateModel(model *models.Model) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.models[model.ID]; !ok {
+		return sql.ErrNoRows
+	}
+	s.models[model.ID] = copyModel(model)
+	return nil
+}
+
+func (s *MemoryDatastore) GetModel(id string) (*models.Model, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	model, ok := s.models[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return copyModel(model), nil
+}
+
+func (s *MemoryDatastore) ListModels() ([]*models.Model, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var list []*models.Model
+	for _, id := range sortedKeys(s.models) {
+		list = append(list, copyModel(s.models[id]))
+	}
+	return list, nil
+}
+
+func (s *MemoryDatastore) DeleteModel(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.models[id]; !ok {
+		return sql.ErrNoRows
+	}
+	delete(s.models, id)
+	return nil
+}
+
+func (s *MemoryDatastore) AddRelationship(rel *models.Relationship) error {
+	key := models.Relationship{Source: rel.Source, Target: rel.Target, Type: rel.Type}
+	timestamp := rel.Timestamp
+	if timestamp.IsZero() {
+		timestamp = time.Now().UTC()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.relationships[key]; !ok {
+		s.relationships[key] = timestamp
+	}
+	return nil
+}
+
+func (s *MemoryDatastore) ListRelationships() ([]*models.Relationship, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var relationships []*models.Relationship
+	for key, timestamp := range s.relationships {
+		rel := key
+		rel.Timestamp = timestamp
+		relationships = append(relationships, &rel)
+	}
+	// Same order as SQLiteDatastore.ListRelationships.
+	sort.Slice(relationships, func(i, j int) bool {
+		a, b := relationships[i], relationships[j]
+		if a.Target != b.Target {
+			return a.Target < b.Target
+		}
+		if a.Source != b.Source {
+			return a.Source < b.Source
+		}
+		return a.Type < b.Type
+	})
+	return relationships, nil
+}
+
+// copyModel copies a model including the values behind its optional fields.
+func copyModel(model *models.Model) *models.Model {
+	m := *model
+	if model.Temperature != nil {
+		v := *model.Temperature
+		m.Temperature = &v
+	}
+	if model.MaxTokens != nil {
+		v := *model.MaxTokens
+		m.MaxTokens = &v
+	}
+	if model.TopP != nil {
+		v := *model.TopP
+		m.TopP = &v
+	}
+	m.ResponseCleaners = append([]string(nil), model.ResponseCleaners...)
+	return &m
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (s *MemoryDatastore) AddChunks(chunks []*models.Chunk) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, chunk := range chunks {
+		chunk.ID = int64(len(s.chunks) + 1)
+		s.chunks = append(s.chunks, copyChunk(chunk))
+	}
+	return nil
+}
+
+func (s *MemoryDatastore) SearchChunks(collection string, query []float32, k int) ([]*models.Chunk, error) {
+	s.mu.RLock()
+	var chunks []*models.Chunk
+	for _, chunk := range s.chunks {
+		if chunk.Collection == collection {
+			chunks = append(chunks, copyChunk(chunk))
+		}
+	}
+	s.mu.RUnlock()
+	return topChunks(chunks, query, k)
+}
+
+func copyChunk(chunk *models.Chunk) *models.Chunk {
+	c := *chunk
+	c.Embedding = append([]float32(nil), chunk.Embedding...)
+	return &c
+}
+
+func (s *MemoryDatastore) GetSetting(key string) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	value, ok := s.settings[key]
+	if !ok {
+		return "", sql.ErrNoRows
+	}
+	return value, nil
+}
+
+func (s *MemoryDatastore) SetSetting(key, value string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.settings[key] = value
+	return nil
+}
+
+func (s *MemoryDatastore) ListSettings() (map[string]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	settings := make(map[string]string, len(s.settings))
+	for key, value := range s.settings {
+		settings[key] = value
+	}
+	return settings, nil
+}
+
+func (s *MemoryDatastore) AddSchedule(schedule *models.Schedule) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sc := *schedule
+	// Stored like the SQL backends do, to the second.
+	sc.Interval = sc.Interval.Truncate(time.Second)
+	sc.NextRun = sc.NextRun.UTC().Truncate(time.Second)
+	s.schedules[schedule.SessionID] = sc
+	return nil
+}
+
+func (s *MemoryDatastore) ListSchedules() ([]*models.Schedule, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	schedules := make([]*models.Schedule, 0, len(s.schedules))
+	for _, schedule := range s.schedules {
+		sc := schedule
+		schedules = append(schedules, &sc)
+	}
+	sort.Slice(schedules, func(i, j int) bool { return schedules[i].NextRun.Before(schedules[j].NextRun) })
+	return schedules, nil
+}
+
+func (s *MemoryDatastore) DeleteSchedule(sessionID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.schedules[sessionID]; !ok {
+		return sql.ErrNoRows
+	}
+	delete(s.schedules, sessionID)
+	return nil
+}
+
+func (s *MemoryDatastore) AddAuditEntry(entry *models.AuditEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e := *entry
+	e.Timestamp = e.Timestamp.UTC()
+	s.audit[entry.SessionID] = append(s.audit[entry.SessionID], e)
+	return nil
+}
+
+func (s *MemoryDatastore) ListAuditEntries(sessionID string) ([]*models.AuditEntry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var entries []*models.AuditEntry
+	for _, entry := range s.audit[sessionID] {
+		e := entry
+		entries = append(entries, &e)
+	}
+	return entries, nil
+}
+
+// Ping fails once Close has been called. The data stays readable, as there
+// is nothing to disconnect from.
+func (s *MemoryDatastore) Ping(ctx context.Context) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		return errors.New("datastore is closed")
+	}
+	return nil
+}
+
+func (s *MemoryDatastore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	return nil
+}
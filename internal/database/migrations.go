@@ -0,0 +1,268 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// migration is one step of a schema. Migrations run in order, once each, and
+// are recorded in schema_migrations by their position in the list. Only ever
+// append to migrations and postgresMigrations; never edit or reorder ones that
+// shipped.
+type migration struct {
+	name string
+	up   func(tx *sql.Tx) error
+}
+
+var migrations = []migration{
+	{"create agents, sessions and models", execAll(`
+		CREATE TABLE IF NOT EXISTS agents (
+			id TEXT PRIMARY KEY,
+			name TEXT,
+			description TEXT,
+			type TEXT
+		);`, `
+		CREATE TABLE IF NOT EXISTS sessions (
+			id TEXT PRIMARY KEY,
+			name TEXT,
+			agent_id TEXT,
+			agent_type TEXT,
+			models TEXT,
+			payload BLOB,
+			status TEXT,
+			timestamp DATETIME DEFAULT CURRENT_TIMESTAMP
+		);`, `
+		CREATE TABLE IF NOT EXISTS models (
+			id TEXT PRIMARY KEY,
+			provider TEXT,
+			api_key TEXT,
+			model_id TEXT,
+			api_url TEXT,
+			api_spec TEXT
+		);`)},
+	{"add session config", addColumns("sessions", "config TEXT")},
+	{"add token usage and model prices", func(tx *sql.Tx) error {
+		if err := addColumns("sessions", "prompt_tokens INTEGER DEFAULT 0", "completion_tokens INTEGER DEFAULT 0", "estimated_cost REAL DEFAULT 0")(tx); err != nil {
+			return err
+		}
+		return addColumns("models", "prompt_price REAL DEFAULT 0", "completion_price REAL DEFAULT 0")(tx)
+	}},
+	{"add model web search", addColumns("models", "enable_web_search INTEGER DEFAULT 0")},
+	{"add model generation params", addColumns("models", "temperature REAL", "max_tokens INTEGER", "top_p REAL")},
+	{"add session error", addColumns("sessions", "error TEXT")},
+	{"add session retry count", addColumns("sessions", "retry_count INTEGER DEFAULT 0")},
+	{"add session pipeline", addColumns("sessions", "pipeline TEXT", "stage TEXT")},
+	{"create relationships", execAll(`
+		CREATE TABLE IF NOT EXISTS relationships (
+			source TEXT NOT NULL,
+			target TEXT NOT NULL,
+			type TEXT NOT NULL,
+			timestamp DATETIME,
+			UNIQUE (source, target, type)
+		);`)},
+	{"add session fallback models", addColumns("sessions", "fallback_models TEXT")},
+	{"add model dimensions", addColumns("models", "dimensions INTEGER DEFAULT 0")},
+	{"create chunks", execAll(`
+		CREATE TABLE IF NOT EXISTS chunks (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			collection TEXT NOT NULL,
+			source TEXT,
+			text TEXT NOT NULL,
+			embedding BLOB NOT NULL,
+			timestamp DATETIME
+		);`, `CREATE INDEX IF NOT EXISTS chunks_collection ON chunks (collection);`)},
+	{"add session dry run", addColumns("sessions", "dry_run INTEGER DEFAULT 0")},
+	{"create settings", execAll(`
+		CREATE TABLE IF NOT EXISTS settings (
+			key TEXT PRIMARY KEY,
+			value TEXT NOT NULL
+		);`)},
+	{"add model api version", addColumns("models", "api_version TEXT")},
+	// FTS4 rather than FTS5, which go-sqlite3 only builds with the sqlite_fts5
+	// tag. The index reads its text from sessions, keyed by rowid.
+	{"create sessions fts", execAll(`
+		CREATE VIRTUAL TABLE IF NOT EXISTS sessions_fts USING fts4(content="sessions", name, payload);`, `
+		INSERT INTO sessions_fts(sessions_fts) VALUES('rebuild');`)},
+	{"add session heartbeat", addColumns("sessions", "last_heartbeat DATETIME")},
+	{"add model rpm", addColumns("models", "rpm INTEGER DEFAULT 0")},
+	{"add session progress", addColumns("sessions", "progress INTEGER DEFAULT 0")},
+	{"add model request template", addColumns("models", "request_template TEXT", "response_path TEXT")},
+	{"add session priority", addColumns("sessions", "priority INTEGER DEFAULT 0")},
+	{"create schedules", execAll(`
+		CREATE TABLE IF NOT EXISTS schedules (
+			session_id TEXT PRIMARY KEY,
+			interval_seconds INTEGER NOT NULL,
+			next_run DATETIME NOT NULL
+		);`)},
+	{"add model max input bytes", addColumns("models", "max_input_bytes INTEGER DEFAULT 0")},
+	{"add session tags", addColumns("sessions", "tags TEXT DEFAULT ''")},
+	{"add agent system prompt", addColumns("agents", "system_prompt TEXT DEFAULT ''")},
+	{"create audit", execAll(`
+		CREATE TABLE IF NOT EXISTS audit (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			session_id TEXT NOT NULL,
+			model_id TEXT,
+			system_prompt TEXT,
+			input TEXT,
+			output TEXT,
+			error TEXT,
+			prompt_tokens INTEGER DEFAULT 0,
+			completion_tokens INTEGER DEFAULT 0,
+			timestamp DATETIME NOT NULL
+		);`, `
+		CREATE INDEX IF NOT EXISTS audit_session ON audit(session_id, id);`)},
+	{"add model alias", addColumns("models", "alias TEXT DEFAULT ''")},
+	{"add model response cleaners", addColumns("models", "response_cleaners TEXT DEFAULT ''")},
+	// Session search moved to an FTS5 index that isn't created here, as only
+	// builds with the sqlite_fts5 tag have FTS5, see search_fts5.go. The
+	// index is stale until such a build has filled it.
+	{"move session search to fts5", execAll(`
+		DROP TABLE IF EXISTS sessions_fts;`, `
+		CREATE TABLE IF NOT EXISTS search_index (stale INTEGER NOT NULL);`, `
+		INSERT INTO search_index (stale) VALUES (1);`)},
+	{"add session history", addColumns("sessions", "history TEXT DEFAULT ''")},
+}
+
+// dialect is what the migration runner needs to know about a backend's SQL.
+type dialect struct {
+	// timestamp is the column type for points in time.
+	timestamp string
+	// placeholder returns bind parameter n, counting from 1.
+	placeholder func(n int) string
+	// lock, if set, is run at the start of every migration so processes
+	// starting at the same time don't migrate concurrently.
+	lock string
+}
+
+var sqliteDialect = dialect{
+	timestamp:   "DATETIME",
+	placeholder: func(int) string { return "?" },
+}
+
+// migrate brings the SQLite database up to the latest schema.
+func migrate(db *sql.DB) error {
+	return runMigrations(db, migrations, sqliteDialect)
+}
+
+// runMigrations applies the migrations of list that db hasn't seen yet.
+func runMigrations(db *sql.DB, list []migration, d dialect) error {
+	_, err := db.Exec(fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version INTEGER PRIMARY KEY,
+			name TEXT,
+			applied_at %s
+		);
+	`, d.timestamp))
+	if err != nil {
+		return err
+	}
+
+	var current int
+	if err := db.QueryRow("SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&current); err != nil {
+		return err
+	}
+
+	for i := current; i < len(list); i++ {
+		version := i + 1
+		applied, err := runMigration(db, version, list[i], d)
+		if err != nil {
+			return fmt.Errorf("migration %d (%s): %w", version, list[i].name, err)
+		}
+		if applied {
+			log.Printf("Applied database migration %d: %s", version, list[i].name)
+		}
+	}
+	return nil
+}
+
+// runMigration applies m as version, unless another process got there first.
+func runMigration(db *sql.DB, version int, m migration, d dialect) (bool, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	if d.lock != "" {
+		if _, err := tx.Exec(d.lock); err != nil {
+			return false, err
+		}
+	}
+	var done int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM schema_migrations WHERE version = "+d.placeholder(1), version).Scan(&done); err != nil {
+		return false, err
+	}
+	if done > 0 {
+		return false, nil
+	}
+
+	if err := m.up(tx); err != nil {
+		return false, err
+	}
+	insert := fmt.Sprintf("INSERT INTO schema_migrations (version, name, applied_at) VALUES (%s, %s, %s)", d.placeholder(1), d.placeholder(2), d.placeholder(3))
+	if _, err := tx.Exec(insert, version, m.name, time.Now().UTC()); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+func execAll(statements ...string) func(tx *sql.Tx) error {
+	return func(tx *sql.Tx) error {
+		for _, stmt := range statements {
+			if _, err := tx.Exec(stmt); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// addColumns adds columns given as "name definition". Databases from before
+// the migration runner may already have some of them, so existing columns are
+// skipped rather than treated as errors.
+func addColumns(table string, columns ...string) func(tx *sql.Tx) error {
+	return func(tx *sql.Tx) error {
+		for _, col := range columns {
+			name, definition, _ := strings.Cut(col, " ")
+			if err := addColumnIfMissing(tx, table, name, definition); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// addColumnIfMissing adds a column to an existing table unless it is already there.
+func addColumnIfMissing(tx *sql.Tx, table, column, definition string) error {
+	rows, err := tx.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid        int
+			name       string
+			colType    string
+			notNull    int
+			defaultVal sql.NullString
+			pk         int
+		)
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultVal, &pk); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
+}
@@ -0,0 +1,92 @@
+package database
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+
+	pb "github.com/nieveai/d-agents/proto"
+)
+
+// openFixture writes the schema and rows of testdata/name to a new database
+// file and returns its path.
+func openFixture(t *testing.T, name string) string {
+	t.Helper()
+	fixture, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "d-agents.db")
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec(string(fixture)); err != nil {
+		t.Fatalf("loading %s: %v", name, err)
+	}
+	return path
+}
+
+func TestMigrateOldSchema(t *testing.T) {
+	for _, fixture := range []string{"baseline.sql", "pre-migrations.sql"} {
+		t.Run(fixture, func(t *testing.T) {
+			path := openFixture(t, fixture)
+
+			store, err := NewSQLiteDatastore(path)
+			if err != nil {
+				t.Fatalf("NewSQLiteDatastore: %v", err)
+			}
+			var applied int
+			if err := store.db.QueryRow("SELECT COUNT(*) FROM schema_migrations").Scan(&applied); err != nil {
+				t.Fatal(err)
+			}
+			if applied != len(migrations) {
+				t.Errorf("%d migrations recorded, want %d", applied, len(migrations))
+			}
+
+			// The old rows are still there.
+			session, err := store.GetSession("s1")
+			if err != nil {
+				t.Fatalf("GetSession: %v", err)
+			}
+			if session.Name != "Old chat" || string(session.Payload) != "hello" || session.Status != pb.WorkloadStatus_COMPLETED || len(session.Models) != 2 {
+				t.Errorf("GetSession = %v", session)
+			}
+			if agent, err := store.GetAgent("a1"); err != nil || agent.Name != "Chat" {
+				t.Errorf("GetAgent = %v, %v", agent, err)
+			}
+			if model, err := store.GetModel("m1"); err != nil || model.ModelID != "gpt-4o" {
+				t.Errorf("GetModel = %v, %v", model, err)
+			}
+
+			// And the columns and tables migrations added are usable.
+			session.Config = `{"stream": false}`
+			session.RetryCount = 2
+			session.Tags = []string{"old"}
+			session.Priority = 10
+			if err := store.AddSession(session); err != nil {
+				t.Fatalf("AddSession: %v", err)
+			}
+			if got, err := store.GetSession("s1"); err != nil || got.Config != session.Config || got.RetryCount != 2 || got.Priority != 10 {
+				t.Errorf("GetSession after update = %v, %v", got, err)
+			}
+			if err := store.SetSetting("max_retries", "3"); err != nil {
+				t.Errorf("SetSetting: %v", err)
+			}
+			store.Close()
+
+			// Opening it again runs nothing.
+			store, err = NewSQLiteDatastore(path)
+			if err != nil {
+				t.Fatalf("reopening: %v", err)
+			}
+			defer store.Close()
+			store.db.QueryRow("SELECT COUNT(*) FROM schema_migrations").Scan(&applied)
+			if applied != len(migrations) {
+				t.Errorf("%d migrations recorded after reopening, want %d", applied, len(migrations))
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/nieveai/d-agents/internal/models"
+)
+
+// ErrAmbiguousModel is returned by ResolveModel when a reference matches
+// more than one model.
+var ErrAmbiguousModel = errors.New("ambiguous model reference")
+
+// ResolveModel finds the model ref refers to: its ID, its alias or, failing
+// those, the provider's ModelID, e.g. gpt-4o. Unknown references return
+// sql.ErrNoRows, and an alias or ModelID shared by several models
+// ErrAmbiguousModel.
+func ResolveModel(store Datastore, ref string) (*models.Model, error) {
+	ref = strings.TrimSpace(ref)
+	if ref == "" {
+		return nil, modelNotFoundError(ref)
+	}
+	list, err := store.ListModels()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list models: %w", err)
+	}
+	var byAlias, byModelID []*models.Model
+	for _, model := range list {
+		switch {
+		case model.ID == ref:
+			return model, nil
+		case model.Alias == ref:
+			byAlias = append(byAlias, model)
+		case model.ModelID == ref:
+			byModelID = append(byModelID, model)
+		}
+	}
+	for _, matches := range [][]*models.Model{byAlias, byModelID} {
+		switch len(matches) {
+		case 0:
+			continue
+		case 1:
+			return matches[0], nil
+		}
+		ids := make([]string, len(matches))
+		for i, model := range matches {
+			ids[i] = model.ID
+		}
+		return nil, fmt.Errorf("%w: %q matches models %s, use one of their IDs", ErrAmbiguousModel, ref, strings.Join(ids, ", "))
+	}
+	return nil, modelNotFoundError(ref)
+}
+
+// modelNotFoundError is sql.ErrNoRows with a message fit for users.
+type modelNotFoundError string
+
+func (e modelNotFoundError) Error() string {
+	return fmt.Sprintf("model %q not found", string(e))
+}
+
+func (e modelNotFoundError) Unwrap() error { return sql.ErrNoRows }
+
+// ResolveModels resolves a comma separated list of model references to
+// model IDs, in order.
+func ResolveModels(store Datastore, refs string) ([]string, error) {
+	var ids []string
+	for _, ref := range strings.Split(refs, ",") {
+		if strings.TrimSpace(ref) == "" {
+			continue
+		}
+		model, err := ResolveModel(store, ref)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, model.ID)
+	}
+	if len(ids) == 0 {
+		return nil, errors.New("no models given")
+	}
+	return ids, nil
+}
+
+// CheckModelAlias checks that the alias of model doesn't clash with the ID or
+// alias of another model, which would make references to it ambiguous.
+func CheckModelAlias(store Datastore, model *models.Model) error {
+	if model.Alias == "" {
+		return nil
+	}
+	list, err := store.ListModels()
+	if err != nil {
+		return fmt.Errorf("failed to list models: %w", err)
+	}
+	for _, other := range list {
+		if other.ID == model.ID {
+			continue
+		}
+		if other.ID == model.Alias || other.Alias == model.Alias {
+			return fmt.Errorf("alias %q is already used by model %s", model.Alias, other.ID)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/nieveai/d-agents/internal/models"
+)
+
+// newModelRefsStore returns a store with two gpt-4o models and a gemini one.
+func newModelRefsStore(t *testing.T) Datastore {
+	t.Helper()
+	store := NewMemoryDatastore()
+	for _, model := range []*models.Model{
+		{ID: "m1", Alias: "fast", ModelID: "gpt-4o", APISpec: "openai"},
+		{ID: "m2", ModelID: "gpt-4o", APISpec: "openai"},
+		{ID: "m3", Alias: "smart", ModelID: "gemini-2.5-pro", APISpec: "gemini"},
+	} {
+		if err := store.AddModel(model); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return store
+}
+
+func TestResolveModel(t *testing.T) {
+	store := newModelRefsStore(t)
+	tests := []struct {
+		ref     string
+		want    string
+		wantErr error
+	}{
+		{"m2", "m2", nil},
+		{" fast ", "m1", nil},
+		{"gemini-2.5-pro", "m3", nil},
+		{"gpt-4o", "", ErrAmbiguousModel},
+		{"claude", "", sql.ErrNoRows},
+		{"", "", sql.ErrNoRows},
+	}
+	for _, tt := range tests {
+		model, err := ResolveModel(store, tt.ref)
+		if tt.wantErr != nil {
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("ResolveModel(%q) = %v, want %v", tt.ref, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || model.ID != tt.want {
+			t.Errorf("ResolveModel(%q) = %v, %v, want %s", tt.ref, model, err, tt.want)
+		}
+	}
+
+	_, err := ResolveModel(store, "gpt-4o")
+	if err == nil || !strings.Contains(err.Error(), "m1, m2") {
+		t.Errorf("ResolveModel of a shared model ID = %v, want it to name both models", err)
+	}
+}
+
+func TestResolveModels(t *testing.T) {
+	store := newModelRefsStore(t)
+	if ids, err := ResolveModels(store, "smart, m2,,fast"); err != nil || !slices.Equal(ids, []string{"m3", "m2", "m1"}) {
+		t.Errorf("ResolveModels = %v, %v", ids, err)
+	}
+	if _, err := ResolveModels(store, "m1,nope"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("ResolveModels with an unknown model = %v, want sql.ErrNoRows", err)
+	}
+	if _, err := ResolveModels(store, " , "); err == nil {
+		t.Error("ResolveModels accepted no models")
+	}
+}
+
+func TestCheckModelAlias(t *testing.T) {
+	store := newModelRefsStore(t)
+	tests := []struct {
+		model *models.Model
+		ok    bool
+	}{
+		{&models.Model{ID: "m4", Alias: "cheap"}, true},
+		{&models.Model{ID: "m4"}, true},
+		{&models.Model{ID: "m4", Alias: "fast"}, false},
+		{&models.Model{ID: "m4", Alias: "m2"}, false},
+		// A model keeps its own alias.
+		{&models.Model{ID: "m1", Alias: "fast"}, true},
+	}
+	for _, tt := range tests {
+		if err := CheckModelAlias(store, tt.model); (err == nil) != tt.ok {
+			t.Errorf("CheckModelAlias(%s, %q) = %v, want ok %v", tt.model.ID, tt.model.Alias, err, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package database
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/neo4j/neo4j-go-driver/v4/neo4j"
+)
+
+func TestNeo4jPasswordPrecedence(t *testing.T) {
+	tests := []struct {
+		name        string
+		env         string
+		config      string
+		credentials string
+		want        string
+		wantErr     string
+	}{
+		{
+			name:        "env first",
+			env:         "from-env",
+			config:      `{"neo4j": {"uri": "neo4j://db:7687", "password": "from-config"}}`,
+			credentials: "password: from-file\n",
+			want:        "from-env",
+		},
+		{
+			name:        "then config.json",
+			config:      `{"neo4j": {"uri": "neo4j://db:7687", "password": "from-config"}}`,
+			credentials: "password: from-file\n",
+			want:        "from-config",
+		},
+		{
+			name:        "then the credentials file",
+			config:      `{"neo4j": {"uri": "neo4j://db:7687"}}`,
+			credentials: "user: neo4j\npassword:  from-file \n",
+			want:        "from-file",
+		},
+		{
+			name:    "nowhere",
+			config:  `{"neo4j": {"uri": "neo4j://db:7687"}}`,
+			wantErr: "no Neo4j password: set NEO4J_PASSWORD, neo4j.password in config.json, or create " + CredentialsFile,
+		},
+		{
+			name:        "credentials file without a password",
+			config:      `{"neo4j": {"uri": "neo4j://db:7687"}}`,
+			credentials: "user: neo4j\n",
+			wantErr:     "password not found",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Chdir(t.TempDir())
+			t.Setenv(Neo4jPasswordEnv, tt.env)
+			writeFile(t, ".", ConfigFile, tt.config)
+			if tt.credentials != "" {
+				writeFile(t, ".", CredentialsFile, tt.credentials)
+			}
+
+			config, err := neo4jConnection()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("neo4jConnection = %+v, %v, want an error containing %q", config, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("neo4jConnection: %v", err)
+			}
+			if config.Password != tt.want {
+				t.Errorf("password = %q, want %q", config.Password, tt.want)
+			}
+		})
+	}
+}
+
+func TestNeo4jDatabaseFromConfig(t *testing.T) {
+	t.Chdir(t.TempDir())
+	t.Setenv(Neo4jPasswordEnv, "secret")
+	writeFile(t, ".", ConfigFile, `{"neo4j": {"uri": "neo4j://db:7687", "username": "neo4j", "database": "companies"}}`)
+
+	config, err := neo4jConnection()
+	if err != nil {
+		t.Fatalf("neo4jConnection: %v", err)
+	}
+	if config.Database != "companies" || config.Username != "neo4j" || config.Password != "secret" {
+		t.Errorf("neo4jConnection = %+v, want the database and user from config.json", config)
+	}
+}
+
+// stubDriver is a neo4j.Driver that is alive until it is closed.
+type stubDriver struct {
+	neo4j.Driver
+	uri    string
+	closed bool
+	// broken makes VerifyConnectivity fail with an error that isn't about the
+	// connection.
+	broken bool
+}
+
+func (d *stubDriver) VerifyConnectivity() error {
+	if d.closed {
+		return &neo4j.UsageError{Message: "Trying to verify connectivity on closed driver"}
+	}
+	if d.broken {
+		return errors.New("other failure")
+	}
+	return nil
+}
+
+func (d *stubDriver) Close() error {
+	d.closed = true
+	return nil
+}
+
+// stubNeo4j makes GetNeo4jDriver create stubDrivers, and returns the drivers
+// it created.
+func stubNeo4j(t *testing.T) *[]*stubDriver {
+	t.Helper()
+	t.Chdir(t.TempDir())
+	t.Setenv(Neo4jPasswordEnv, "secret")
+	writeFile(t, ".", ConfigFile, `{"neo4j": {"uri": "neo4j://db:7687", "username": "neo4j", "database": "companies"}}`)
+
+	var created []*stubDriver
+	old := newNeo4jDriver
+	newNeo4jDriver = func(uri string, auth neo4j.AuthToken) (neo4j.Driver, error) {
+		d := &stubDriver{uri: uri}
+		created = append(created, d)
+		return d, nil
+	}
+	t.Cleanup(func() {
+		CloseNeo4jDriver()
+		newNeo4jDriver = old
+	})
+	return &created
+}
+
+func TestGetNeo4jDriverReconnects(t *testing.T) {
+	created := stubNeo4j(t)
+
+	first, err := GetNeo4jDriver()
+	if err != nil {
+		t.Fatalf("GetNeo4jDriver: %v", err)
+	}
+	if again, _ := GetNeo4jDriver(); again != first || len(*created) != 1 {
+		t.Errorf("second GetNeo4jDriver made a new driver, want the shared one")
+	}
+	if first.(*stubDriver).uri != "neo4j://db:7687" {
+		t.Errorf("connected to %q", first.(*stubDriver).uri)
+	}
+	if got := Neo4jSessionConfig(neo4j.AccessModeWrite).DatabaseName; got != "companies" {
+		t.Errorf("session database = %q, want companies", got)
+	}
+
+	// Something closed the shared driver: the next call connects again.
+	first.Close()
+	second, err := GetNeo4jDriver()
+	if err != nil {
+		t.Fatalf("GetNeo4jDriver after the driver closed: %v", err)
+	}
+	if second == first || len(*created) != 2 {
+		t.Errorf("GetNeo4jDriver returned the closed driver")
+	}
+
+	// CloseNeo4jDriver forgets the driver, so it can connect again later.
+	CloseNeo4jDriver()
+	if !second.(*stubDriver).closed {
+		t.Error("CloseNeo4jDriver didn't close the driver")
+	}
+	third, err := GetNeo4jDriver()
+	if err != nil || third == second || len(*created) != 3 {
+		t.Errorf("GetNeo4jDriver after CloseNeo4jDriver = %v, %v, want a new driver", third, err)
+	}
+}
+
+func TestGetNeo4jDriverOtherErrors(t *testing.T) {
+	created := stubNeo4j(t)
+	driver, err := GetNeo4jDriver()
+	if err != nil {
+		t.Fatalf("GetNeo4jDriver: %v", err)
+	}
+
+	// An error that isn't about the connection is returned, not papered
+	// over with a new driver.
+	driver.(*stubDriver).broken = true
+	if _, err := GetNeo4jDriver(); err == nil || !strings.Contains(err.Error(), "failed to verify neo4j connectivity") {
+		t.Errorf("GetNeo4jDriver = %v, want the verify error", err)
+	}
+	if len(*created) != 1 {
+		t.Errorf("%d drivers created, want 1", len(*created))
+	}
+}
@@ -0,0 +1,95 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// NewsDB remembers the headlines NewsMonitorAgent has already reported.
+type NewsDB struct {
+	*sql.DB
+}
+
+type NewsItem struct {
+	Topic    string
+	Headline string
+	URL      string
+	Date     time.Time
+}
+
+func NewNewsDB() (*NewsDB, error) {
+	db, err := openSQLite("./news.db")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	// A URL is only reported once per topic.
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS news (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			topic TEXT NOT NULL,
+			headline TEXT,
+			url TEXT NOT NULL,
+			date TEXT,
+			UNIQUE(topic, url)
+		)
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create table: %w", err)
+	}
+
+	return &NewsDB{db}, nil
+}
+
+// AddNewItems stores the items of topic that haven't been seen before and
+// returns them. Items without a URL are skipped since they can't be deduplicated.
+func (db *NewsDB) AddNewItems(topic string, items []NewsItem, date time.Time) ([]NewsItem, error) {
+	var added []NewsItem
+	for _, item := range items {
+		item.Topic = topic
+		item.URL = strings.TrimSpace(item.URL)
+		item.Date = date
+		if item.URL == "" {
+			continue
+		}
+		res, err := db.Exec(
+			"INSERT OR IGNORE INTO news (topic, headline, url, date) VALUES (?, ?, ?, ?)",
+			item.Topic, item.Headline, item.URL, item.Date.Format(time.RFC3339),
+		)
+		if err != nil {
+			return added, fmt.Errorf("failed to insert news item %s: %w", item.URL, err)
+		}
+		if n, err := res.RowsAffected(); err == nil && n > 0 {
+			added = append(added, item)
+		}
+	}
+	return added, nil
+}
+
+// GetNews returns the items stored for topic, newest first.
+func (db *NewsDB) GetNews(topic string) ([]*NewsItem, error) {
+	rows, err := db.Query("SELECT topic, headline, url, date FROM news WHERE topic = ? ORDER BY date DESC, id DESC", topic)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query news: %w", err)
+	}
+	defer rows.Close()
+
+	var items []*NewsItem
+	for rows.Next() {
+		var item NewsItem
+		var dateStr string
+		if err := rows.Scan(&item.Topic, &item.Headline, &item.URL, &dateStr); err != nil {
+			return nil, fmt.Errorf("failed to scan news item: %w", err)
+		}
+		item.Date, err = time.Parse(time.RFC3339, dateStr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse date: %w", err)
+		}
+		items = append(items, &item)
+	}
+	return items, rows.Err()
+}
@@ -0,0 +1,70 @@
+package database
+
+import (
+	"testing"
+	"time"
+)
+
+func newTestNewsDB(t *testing.T) *NewsDB {
+	t.Helper()
+	t.Chdir(t.TempDir())
+	db, err := NewNewsDB()
+	if err != nil {
+		t.Fatalf("NewNewsDB: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestNewsDBAddNewItems(t *testing.T) {
+	db := newTestNewsDB(t)
+	yesterday := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+	today := yesterday.AddDate(0, 0, 1)
+	seed := []NewsItem{
+		{Headline: "Chip sales up", URL: "https://news.test/1"},
+		{Headline: "New fab opens", URL: "https://news.test/2"},
+	}
+	if added, err := db.AddNewItems("chips", seed, yesterday); err != nil || len(added) != 2 {
+		t.Fatalf("seeding added %d items, err %v", len(added), err)
+	}
+
+	added, err := db.AddNewItems("chips", []NewsItem{
+		{Headline: "Chip sales up, again", URL: " https://news.test/1 "},
+		{Headline: "Export rules change", URL: "https://news.test/3"},
+		{Headline: "No link"},
+		{Headline: "Export rules change", URL: "https://news.test/3"},
+	}, today)
+	if err != nil {
+		t.Fatalf("AddNewItems: %v", err)
+	}
+	if len(added) != 1 || added[0].URL != "https://news.test/3" || added[0].Topic != "chips" || !added[0].Date.Equal(today) {
+		t.Errorf("added = %+v, want only the unseen URL", added)
+	}
+
+	// The same URL is new for another topic.
+	if added, _ := db.AddNewItems("fabs", seed[1:], today); len(added) != 1 {
+		t.Errorf("added %d items for another topic, want 1", len(added))
+	}
+
+	items, err := db.GetNews("chips")
+	if err != nil {
+		t.Fatalf("GetNews: %v", err)
+	}
+	var urls []string
+	for _, item := range items {
+		urls = append(urls, item.URL)
+	}
+	want := []string{"https://news.test/3", "https://news.test/2", "https://news.test/1"}
+	if len(urls) != len(want) {
+		t.Fatalf("stored %v, want %v", urls, want)
+	}
+	for i := range want {
+		if urls[i] != want[i] {
+			t.Errorf("stored %v, want %v newest first", urls, want)
+			break
+		}
+	}
+	if items[2].Headline != "Chip sales up" {
+		t.Errorf("headline of a seen URL = %q, want the first one kept", items[2].Headline)
+	}
+}
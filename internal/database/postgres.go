@@ -0,0 +1,509 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	_ "github.com/lib/pq"
+	"github.com/nieveai/d-agents/internal/models"
+	pb "github.com/nieveai/d-agents/proto"
+)
+
+var postgresDialect = dialect{
+	timestamp:   "TIMESTAMPTZ",
+	placeholder: func(n int) string { return fmt.Sprintf("$%d", n) },
+	lock:        "SELECT pg_advisory_xact_lock(4242001)",
+}
+
+// postgresMigrations is the Postgres schema. It starts at the schema the
+// SQLite migrations had reached, and both lists grow together from there.
+var postgresMigrations = []migration{
+	{"create schema", execAll(`
+		CREATE TABLE IF NOT EXISTS agents (
+			id TEXT PRIMARY KEY,
+			name TEXT,
+			description TEXT,
+			type TEXT
+		);`, `
+		CREATE TABLE IF NOT EXISTS sessions (
+			id TEXT PRIMARY KEY,
+			name TEXT,
+			agent_id TEXT,
+			agent_type TEXT,
+			models TEXT,
+			payload BYTEA,
+			status TEXT,
+			timestamp TIMESTAMPTZ DEFAULT now(),
+			config TEXT,
+			prompt_tokens BIGINT DEFAULT 0,
+			completion_tokens BIGINT DEFAULT 0,
+			estimated_cost DOUBLE PRECISION DEFAULT 0,
+			error TEXT,
+			retry_count INTEGER DEFAULT 0,
+			pipeline TEXT,
+			stage TEXT,
+			fallback_models TEXT,
+			dry_run BOOLEAN DEFAULT FALSE,
+			last_heartbeat TIMESTAMPTZ,
+			search TSVECTOR
+		);`, `
+		CREATE INDEX IF NOT EXISTS sessions_search ON sessions USING GIN (search);`, `
+		CREATE TABLE IF NOT EXISTS models (
+			id TEXT PRIMARY KEY,
+			provider TEXT,
+			api_key TEXT,
+			model_id TEXT,
+			api_url TEXT,
+			api_spec TEXT,
+			prompt_price DOUBLE PRECISION DEFAULT 0,
+			completion_price DOUBLE PRECISION DEFAULT 0,
+			enable_web_search BOOLEAN DEFAULT FALSE,
+			temperature DOUBLE PRECISION,
+			max_tokens INTEGER,
+			top_p DOUBLE PRECISION,
+			dimensions INTEGER DEFAULT 0,
+			api_version TEXT
+		);`, `
+		CREATE TABLE IF NOT EXISTS relationships (
+			source TEXT NOT NULL,
+			target TEXT NOT NULL,
+			type TEXT NOT NULL,
+			timestamp TIMESTAMPTZ,
+			UNIQUE (source, target, type)
+		);`, `
+		CREATE TABLE IF NOT EXISTS chunks (
+			id BIGSERIAL PRIMARY KEY,
+			collection TEXT NOT NULL,
+			source TEXT,
+			text TEXT NOT NULL,
+			embedding BYTEA NOT NULL,
+			timestamp TIMESTAMPTZ
+		);`, `
+		CREATE INDEX IF NOT EXISTS chunks_collection ON chunks (collection);`, `
+		CREATE TABLE IF NOT EXISTS settings (
+			key TEXT PRIMARY KEY,
+			value TEXT NOT NULL
+		);`)},
+	{"add model rpm", execAll(`ALTER TABLE models ADD COLUMN IF NOT EXISTS rpm INTEGER DEFAULT 0;`)},
+	{"add session progress", execAll(`ALTER TABLE sessions ADD COLUMN IF NOT EXISTS progress INTEGER DEFAULT 0;`)},
+	{"add model request template", execAll(
+		`ALTER TABLE models ADD COLUMN IF NOT EXISTS request_template TEXT;`,
+		`ALTER TABLE models ADD COLUMN IF NOT EXISTS response_path TEXT;`)},
+	{"add session priority", execAll(`ALTER TABLE sessions ADD COLUMN IF NOT EXISTS priority INTEGER DEFAULT 0;`)},
+	{"create schedules", execAll(`
+		CREATE TABLE IF NOT EXISTS schedules (
+			session_id TEXT PRIMARY KEY,
+			interval_seconds BIGINT NOT NULL,
+			next_run TIMESTAMPTZ NOT NULL
+		);`)},
+	{"add model max input bytes", execAll(`ALTER TABLE models ADD COLUMN IF NOT EXISTS max_input_bytes INTEGER DEFAULT 0;`)},
+	{"add session tags", execAll(`ALTER TABLE sessions ADD COLUMN IF NOT EXISTS tags TEXT DEFAULT '';`)},
+	{"add agent system prompt", execAll(`ALTER TABLE agents ADD COLUMN IF NOT EXISTS system_prompt TEXT DEFAULT '';`)},
+	{"create audit", execAll(`
+		CREATE TABLE IF NOT EXISTS audit (
+			id BIGSERIAL PRIMARY KEY,
+			session_id TEXT NOT NULL,
+			model_id TEXT,
+			system_prompt TEXT,
+			input TEXT,
+			output TEXT,
+			error TEXT,
+			prompt_tokens BIGINT DEFAULT 0,
+			completion_tokens BIGINT DEFAULT 0,
+			timestamp TIMESTAMPTZ NOT NULL
+		);`, `
+		CREATE INDEX IF NOT EXISTS audit_session ON audit(session_id, id);`)},
+	{"add model alias", execAll(`ALTER TABLE models ADD COLUMN IF NOT EXISTS alias TEXT DEFAULT '';`)},
+	{"add model response cleaners", execAll(`ALTER TABLE models ADD COLUMN IF NOT EXISTS response_cleaners TEXT DEFAULT '';`)},
+	{"add session history", execAll(`ALTER TABLE sessions ADD COLUMN IF NOT EXISTS history TEXT DEFAULT '';`)},
+}
+
+// PostgresDatastore is a Datastore on PostgreSQL, for deployments where
+// several processes write at once.
+type PostgresDatastore struct {
+	db *sql.DB
+}
+
+// NewPostgresDatastore connects to the database at conn, a postgres:// URL or
+// key=value connection string, and migrates it.
+func NewPostgresDatastore(conn string) (*PostgresDatastore, error) {
+	db, err := sql.Open("postgres", conn)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to connect to postgres: %w", err)
+	}
+	if err := runMigrations(db, postgresMigrations, postgresDialect); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to migrate postgres: %w", err)
+	}
+	return &PostgresDatastore{db: db}, nil
+}
+
+// upsertColumns is the SET clause that overwrites every column but id and
+// the keep columns with the row being inserted.
+func upsertColumns(columns string, keep ...string) string {
+	var set []string
+	for _, col := range strings.Split(columns, ", ") {
+		if col != "id" && !slices.Contains(keep, col) {
+			set = append(set, col+" = EXCLUDED."+col)
+		}
+	}
+	return strings.Join(set, ", ")
+}
+
+func (s *PostgresDatastore) AddAgent(agent *models.Agent) error {
+	_, err := s.db.Exec("INSERT INTO agents (id, name, description, type, system_prompt) VALUES ($1, $2, $3, $4, $5) ON CONFLICT (id) DO UPDATE SET "+upsertColumns("id, name, description, type, system_prompt"), agent.ID, agent.Name, agent.Description, agent.Type, agent.SystemPrompt)
+	return err
+}
+
+func (s *PostgresDatastore) GetAgent(id string) (*models.Agent, error) {
+	return scanAgent(s.db.QueryRow("SELECT id, name, description, type, system_prompt FROM agents WHERE id = $1", id))
+}
+
+func (s *PostgresDatastore) ListAgents() ([]*models.Agent, error) {
+	rows, err := s.db.Query("SELECT id, name, description, type, system_prompt FROM agents ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var agents []*models.Agent
+	for rows.Next() {
+		agent, err := scanAgent(rows)
+		if err != nil {
+			return nil, err
+		}
+		agents = append(agents, agent)
+	}
+	return agents, rows.Err()
+}
+
+func (s *PostgresDatastore) AddSession(session *pb.Workload) error {
+	timestamp := time.Now().UTC()
+	if session.Timestamp != 0 {
+		timestamp = time.Unix(session.Timestamp, 0).UTC()
+	}
+	var lastHeartbeat *time.Time
+	if session.LastHeartbeat != 0 {
+		t := time.Unix(session.LastHeartbeat, 0).UTC()
+		lastHeartbeat = &t
+	}
+	// Postgres text can't hold NUL or invalid UTF-8, which a payload may.
+	searchText := strings.ToValidUTF8(strings.ReplaceAll(session.Name+" "+string(session.Payload), "\x00", " "), " ")
+
+	_, err := s.db.Exec("INSERT INTO sessions ("+sessionColumns+", search) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22, $23, to_tsvector('simple', $24)) ON CONFLICT (id) DO UPDATE SET "+upsertColumns(sessionColumns+", search", "tags"),
+		session.Id, session.Name, session.AgentId, session.AgentType, strings.Join(session.Models, ","), session.Payload, session.Status.String(), timestamp, session.Config, session.PromptTokens, session.CompletionTokens, session.EstimatedCost, session.Error, session.RetryCount, strings.Join(session.Pipeline, ","), session.Stage, strings.Join(session.FallbackModels, ","), session.DryRun, lastHeartbeat, session.Progress, session.Priority, joinTags(session.Tags), joinHistory(session.History), searchText)
+	return err
+}
+
+func (s *PostgresDatastore) GetSession(id string) (*pb.Workload, error) {
+	return scanSession(s.db.QueryRow("SELECT "+sessionColumns+" FROM sessions WHERE id = $1", id))
+}
+
+func (s *PostgresDatastore) ListSessions() ([]*pb.Workload, error) {
+	return s.querySessions("SELECT " + sessionColumns + " FROM sessions ORDER BY timestamp, id")
+}
+
+func (s *PostgresDatastore) AddTag(id, tag string) error {
+	return s.updateTags(id, tag, withTag)
+}
+
+func (s *PostgresDatastore) RemoveTag(id, tag string) error {
+	return s.updateTags(id, tag, withoutTag)
+}
+
+// updateTags replaces the tags of session id with update(tags, tag).
+func (s *PostgresDatastore) updateTags(id, tag string, update func([]string, string) []string) error {
+	tag, err := NormalizeTag(tag)
+	if err != nil {
+		return err
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var tags sql.NullString
+	if err := tx.QueryRow("SELECT tags FROM sessions WHERE id = $1 FOR UPDATE", id).Scan(&tags); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE sessions SET tags = $1 WHERE id = $2", joinTags(update(splitTags(tags.String), tag)), id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *PostgresDatastore) ListSessionsByTag(tag string) ([]*pb.Workload, error) {
+	tag, err := NormalizeTag(tag)
+	if err != nil {
+		return nil, err
+	}
+	return s.querySessions("SELECT "+sessionColumns+" FROM sessions WHERE $1 = ANY(string_to_array(tags, ',')) ORDER BY timestamp, id", tag)
+}
+
+func (s *PostgresDatastore) SearchSessions(query string) ([]*pb.Workload, error) {
+	if strings.TrimSpace(query) == "" {
+		return nil, nil
+	}
+	// plainto_tsquery ANDs the words and ignores any operators in them.
+	return s.querySessions("SELECT "+sessionColumns+" FROM sessions WHERE search @@ plainto_tsquery('simple', $1) ORDER BY timestamp DESC", query)
+}
+
+func (s *PostgresDatastore) querySessions(query string, args ...any) ([]*pb.Workload, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []*pb.Workload
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
+
+func (s *PostgresDatastore) SetHeartbeat(id string, at time.Time) error {
+	_, err := s.db.Exec("UPDATE sessions SET last_heartbeat = $1 WHERE id = $2 AND status = $3", at.UTC(), id, pb.WorkloadStatus_RUNNING.String())
+	return err
+}
+
+func (s *PostgresDatastore) SetProgress(id string, pct int32) error {
+	_, err := s.db.Exec("UPDATE sessions SET progress = $1 WHERE id = $2 AND status = $3", pct, id, pb.WorkloadStatus_RUNNING.String())
+	return err
+}
+
+func (s *PostgresDatastore) DeleteSession(id string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("DELETE FROM audit WHERE session_id = $1", id); err != nil {
+		return err
+	}
+	res, err := tx.Exec("DELETE FROM sessions WHERE id = $1", id)
+	if err != nil {
+		return err
+	}
+	if err := checkRowsAffected(res); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *PostgresDatastore) AddModel(model *models.Model) error {
+	_, err := s.db.Exec("INSERT INTO models ("+modelColumns+") VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20) ON CONFLICT (id) DO UPDATE SET "+upsertColumns(modelColumns),
+		model.ID, model.Provider, model.APIKey, model.ModelID, model.APIURL, model.APISpec, model.PromptPrice, model.CompletionPrice, model.EnableWebSearch, model.Temperature, model.MaxTokens, model.TopP, model.Dimensions, model.APIVersion, model.RPM, model.RequestTemplate, model.ResponsePath, model.MaxInputBytes, model.Alias, strings.Join(model.ResponseCleaners, ","))
+	return err
+}
+
+func (s *PostgresDatastore) UpdateModel(model *models.Model) error {
+	res, err := s.db.Exec("UPDATE models SET provider = $1, api_key = $2, model_id = $3, api_url = $4, api_spec = $5, prompt_price = $6, completion_price = $7, enable_web_search = $8, temperature = $9, max_tokens = $10, top_p = $11, dimensions = $12, api_version = $13, rpm = $14, request_template = $15, response_path = $16, max_input_bytes = $17, alias = $18, response_cleaners = $19 WHERE id = $20",
+		model.Provider, model.APIKey, model.ModelID, model.APIURL, model.APISpec, model.PromptPrice, model.CompletionPrice, model.EnableWebSearch, model.Temperature, model.MaxTokens, model.TopP, model.Dimensions, model.APIVersion, model.RPM, model.RequestTemplate, model.ResponsePath, model.MaxInputBytes, model.Alias, strings.Join(model.ResponseCleaners, ","), model.ID)
+	if err != nil {
+		return err
+	}
+	return checkRowsAffected(res)
+}
+
+func (s *PostgresDatastore) GetModel(id string) (*models.Model, error) {
+	return scanModel(s.db.QueryRow("SELECT "+modelColumns+" FROM models WHERE id = $1", id))
+}
+
+func (s *PostgresDatastore) ListModels() ([]*models.Model, error) {
+	rows, err := s.db.Query("SELECT " + modelColumns + " FROM models ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []*models.Model
+	for rows.Next() {
+		model, err := scanModel(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, model)
+	}
+	return list, rows.Err()
+}
+
+func (s *PostgresDatastore) DeleteAgent(id string) error {
+	res, err := s.db.Exec("DELETE FROM agents WHERE id = $1", id)
+	if err != nil {
+		return err
+	}
+	return checkRowsAffected(res)
+}
+
+func (s *PostgresDatastore) DeleteModel(id string) error {
+	var refs int
+	err := s.db.QueryRow(`SELECT COUNT(*) FROM sessions WHERE ',' || models || ',' LIKE '%,' || $1::text || ',%' ESCAPE '\'`, escapeLike(id)).Scan(&refs)
+	if err != nil {
+		return err
+	}
+	if refs > 0 {
+		log.Printf("Warning: deleting model %s which is still referenced by %d session(s)", id, refs)
+	}
+
+	res, err := s.db.Exec("DELETE FROM models WHERE id = $1", id)
+	if err != nil {
+		return err
+	}
+	return checkRowsAffected(res)
+}
+
+func (s *PostgresDatastore) AddRelationship(rel *models.Relationship) error {
+	timestamp := rel.Timestamp
+	if timestamp.IsZero() {
+		timestamp = time.Now().UTC()
+	}
+	_, err := s.db.Exec("INSERT INTO relationships (source, target, type, timestamp) VALUES ($1, $2, $3, $4) ON CONFLICT DO NOTHING", rel.Source, rel.Target, rel.Type, timestamp)
+	return err
+}
+
+func (s *PostgresDatastore) ListRelationships() ([]*models.Relationship, error) {
+	rows, err := s.db.Query("SELECT source, target, type, timestamp FROM relationships ORDER BY target, source, type")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var relationships []*models.Relationship
+	for rows.Next() {
+		var rel models.Relationship
+		var timestamp sql.NullTime
+		if err := rows.Scan(&rel.Source, &rel.Target, &rel.Type, &timestamp); err != nil {
+			return nil, err
+		}
+		rel.Timestamp = timestamp.Time
+		relationships = append(relationships, &rel)
+	}
+	return relationships, rows.Err()
+}
+
+func (s *PostgresDatastore) AddChunks(chunks []*models.Chunk) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	for _, chunk := range chunks {
+		err := tx.QueryRow("INSERT INTO chunks (collection, source, text, embedding, timestamp) VALUES ($1, $2, $3, $4, $5) RETURNING id",
+			chunk.Collection, chunk.Source, chunk.Text, encodeEmbedding(chunk.Embedding), now).Scan(&chunk.ID)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *PostgresDatastore) SearchChunks(collection string, query []float32, k int) ([]*models.Chunk, error) {
+	rows, err := s.db.Query("SELECT id, collection, source, text, embedding FROM chunks WHERE collection = $1 ORDER BY id", collection)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var chunks []*models.Chunk
+	for rows.Next() {
+		var chunk models.Chunk
+		var source sql.NullString
+		var embedding []byte
+		if err := rows.Scan(&chunk.ID, &chunk.Collection, &source, &chunk.Text, &embedding); err != nil {
+			return nil, err
+		}
+		chunk.Source = source.String
+		if chunk.Embedding, err = decodeEmbedding(embedding); err != nil {
+			return nil, fmt.Errorf("chunk %d: %w", chunk.ID, err)
+		}
+		chunks = append(chunks, &chunk)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return topChunks(chunks, query, k)
+}
+
+func (s *PostgresDatastore) GetSetting(key string) (string, error) {
+	var value string
+	err := s.db.QueryRow("SELECT value FROM settings WHERE key = $1", key).Scan(&value)
+	return value, err
+}
+
+func (s *PostgresDatastore) SetSetting(key, value string) error {
+	_, err := s.db.Exec("INSERT INTO settings (key, value) VALUES ($1, $2) ON CONFLICT (key) DO UPDATE SET value = EXCLUDED.value", key, value)
+	return err
+}
+
+func (s *PostgresDatastore) AddSchedule(schedule *models.Schedule) error {
+	_, err := s.db.Exec("INSERT INTO schedules (session_id, interval_seconds, next_run) VALUES ($1, $2, $3) ON CONFLICT (session_id) DO UPDATE SET interval_seconds = EXCLUDED.interval_seconds, next_run = EXCLUDED.next_run", schedule.SessionID, int64(schedule.Interval/time.Second), schedule.NextRun.UTC())
+	return err
+}
+
+func (s *PostgresDatastore) ListSchedules() ([]*models.Schedule, error) {
+	return listSchedules(s.db)
+}
+
+func (s *PostgresDatastore) DeleteSchedule(sessionID string) error {
+	res, err := s.db.Exec("DELETE FROM schedules WHERE session_id = $1", sessionID)
+	if err != nil {
+		return err
+	}
+	return checkRowsAffected(res)
+}
+
+func (s *PostgresDatastore) AddAuditEntry(entry *models.AuditEntry) error {
+	_, err := s.db.Exec("INSERT INTO audit ("+auditColumns+") VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)",
+		entry.SessionID, entry.ModelID, entry.SystemPrompt, entry.Input, entry.Output, entry.Error, entry.PromptTokens, entry.CompletionTokens, entry.Timestamp.UTC())
+	return err
+}
+
+func (s *PostgresDatastore) ListAuditEntries(sessionID string) ([]*models.AuditEntry, error) {
+	rows, err := s.db.Query("SELECT "+auditColumns+" FROM audit WHERE session_id = $1 ORDER BY id", sessionID)
+	if err != nil {
+		return nil, err
+	}
+	return scanAuditEntries(rows)
+}
+
+func (s *PostgresDatastore) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+func (s *PostgresDatastore) Close() error {
+	return s.db.Close()
+}
+
+func (s *PostgresDatastore) ListSettings() (map[string]string, error) {
+	rows, err := s.db.Query("SELECT key, value FROM settings")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	settings := make(map[string]string)
+	for rows.Next() {
+		var key, value string
+		if err := rows.Scan(&key, &value); err != nil {
+			return nil, err
+		}
+		settings[key] = value
+	}
+	return settings, rows.Err()
+}
@@ -0,0 +1,42 @@
+//go:build !sqlite_fts5
+
+package database
+
+import (
+	"database/sql"
+	"strings"
+
+	pb "github.com/nieveai/d-agents/proto"
+)
+
+// Without the sqlite_fts5 tag go-sqlite3 is built without FTS5, so sessions
+// are searched by scanning them with LIKE. Build with -tags sqlite_fts5 for
+// the full-text index in search_fts5.go.
+
+// ensureSearchIndex marks the FTS5 index as stale, since the sessions this
+// build writes don't go into it. A build with FTS5 fills it again on open.
+func ensureSearchIndex(db *sql.DB) error {
+	_, err := db.Exec("UPDATE search_index SET stale = 1")
+	return err
+}
+
+func indexSession(tx *sql.Tx, rowid int64, session *pb.Workload) error { return nil }
+
+func unindexSession(tx *sql.Tx, id string) error { return nil }
+
+// searchCondition returns the WHERE clause matching sessions whose name or
+// payload contain all the words of query.
+func searchCondition(query string) (string, []any) {
+	words := strings.Fields(query)
+	if len(words) == 0 {
+		return "", nil
+	}
+	conditions := make([]string, len(words))
+	var args []any
+	for i, word := range words {
+		conditions[i] = `(name LIKE ? ESCAPE '\' OR CAST(payload AS TEXT) LIKE ? ESCAPE '\')`
+		pattern := "%" + escapeLike(word) + "%"
+		args = append(args, pattern, pattern)
+	}
+	return strings.Join(conditions, " AND "), args
+}
@@ -0,0 +1,83 @@
+//go:build sqlite_fts5
+
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	pb "github.com/nieveai/d-agents/proto"
+)
+
+// Sessions are searched with sessions_fts5, a contentless FTS5 index: it only
+// keeps the words of each session's name and payload, under the session's
+// rowid, rather than a second copy of the text. contentless_delete lets a
+// session be dropped from it by rowid alone.
+const searchIndexSchema = `CREATE VIRTUAL TABLE IF NOT EXISTS sessions_fts5 USING fts5(name, payload, content='', contentless_delete=1)`
+
+// ensureSearchIndex creates the index, and fills it from sessions when it is
+// new or a build without FTS5 has opened the database since it was filled.
+func ensureSearchIndex(db *sql.DB) error {
+	var exists, stale bool
+	if err := db.QueryRow("SELECT COUNT(*) > 0 FROM sqlite_master WHERE name = 'sessions_fts5'").Scan(&exists); err != nil {
+		return err
+	}
+	if err := db.QueryRow("SELECT stale FROM search_index").Scan(&stale); err != nil {
+		return err
+	}
+	if exists && !stale {
+		return nil
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(searchIndexSchema); err != nil {
+		return fmt.Errorf("failed to create the search index: %w", err)
+	}
+	if _, err := tx.Exec("INSERT INTO sessions_fts5 (sessions_fts5) VALUES ('delete-all')"); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("INSERT INTO sessions_fts5 (rowid, name, payload) SELECT rowid, name, payload FROM sessions"); err != nil {
+		return fmt.Errorf("failed to fill the search index: %w", err)
+	}
+	if _, err := tx.Exec("UPDATE search_index SET stale = 0"); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// indexSession adds the session stored at rowid to the search index.
+func indexSession(tx *sql.Tx, rowid int64, session *pb.Workload) error {
+	_, err := tx.Exec("INSERT INTO sessions_fts5 (rowid, name, payload) VALUES (?, ?, ?)", rowid, session.Name, session.Payload)
+	if err != nil {
+		return fmt.Errorf("failed to index session: %w", err)
+	}
+	return nil
+}
+
+// unindexSession removes session id from the search index, if it is there.
+func unindexSession(tx *sql.Tx, id string) error {
+	_, err := tx.Exec("DELETE FROM sessions_fts5 WHERE rowid IN (SELECT rowid FROM sessions WHERE id = ?)", id)
+	if err != nil {
+		return fmt.Errorf("failed to unindex session: %w", err)
+	}
+	return nil
+}
+
+// searchCondition returns the WHERE clause matching sessions with all the
+// words of query. Every word is quoted, so operators and stray quotes in what
+// the user typed are searched for rather than parsed as FTS syntax.
+func searchCondition(query string) (string, []any) {
+	words := strings.Fields(query)
+	if len(words) == 0 {
+		return "", nil
+	}
+	for i, word := range words {
+		words[i] = `"` + strings.ReplaceAll(word, `"`, `""`) + `"`
+	}
+	return "rowid IN (SELECT rowid FROM sessions_fts5 WHERE sessions_fts5 MATCH ?)", []any{strings.Join(words, " ")}
+}
@@ -0,0 +1,51 @@
+//go:build sqlite_fts5
+
+package database
+
+import (
+	"path/filepath"
+	"testing"
+
+	pb "github.com/nieveai/d-agents/proto"
+)
+
+func TestSearchIndexIsRebuiltWhenStale(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	store, err := NewSQLiteDatastore(path)
+	if err != nil {
+		t.Fatalf("NewSQLiteDatastore: %v", err)
+	}
+	if err := store.AddSession(&pb.Workload{Id: "s1", Payload: []byte("indexed")}); err != nil {
+		t.Fatal(err)
+	}
+	// As a build without FTS5 would: leave a session out of the index and
+	// mark it stale.
+	if err := store.AddSession(&pb.Workload{Id: "s2", Payload: []byte("unindexed")}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.db.Exec("DELETE FROM sessions_fts5 WHERE rowid = (SELECT rowid FROM sessions WHERE id = 's2')"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.db.Exec("UPDATE search_index SET stale = 1"); err != nil {
+		t.Fatal(err)
+	}
+	if found, _ := store.SearchSessions("unindexed"); len(found) != 0 {
+		t.Fatalf("found %v before the rebuild", found)
+	}
+	store.Close()
+
+	store, err = NewSQLiteDatastore(path)
+	if err != nil {
+		t.Fatalf("NewSQLiteDatastore: %v", err)
+	}
+	defer store.Close()
+	for _, word := range []string{"indexed", "unindexed"} {
+		if found, err := store.SearchSessions(word); err != nil || len(found) != 1 {
+			t.Errorf("SearchSessions(%q) after reopening = %v, %v, want one session", word, found, err)
+		}
+	}
+	var stale bool
+	if err := store.db.QueryRow("SELECT stale FROM search_index").Scan(&stale); err != nil || stale {
+		t.Errorf("stale = %v, %v, want the index marked fresh", stale, err)
+	}
+}
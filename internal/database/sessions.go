@@ -0,0 +1,274 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/google/uuid"
+	"github.com/nieveai/d-agents/internal/textutil"
+	pb "github.com/nieveai/d-agents/proto"
+)
+
+// CloneSession copies session id into a new PENDING session, so it can be
+// edited and run again without touching the original. The setup and payload
+// are copied, the results of earlier runs are not.
+func CloneSession(store Datastore, id string) (*pb.Workload, error) {
+	session, err := store.GetSession(id)
+	if err != nil {
+		return nil, fmt.Errorf("error loading session %s: %w", id, err)
+	}
+
+	clone := &pb.Workload{
+		Id:             uuid.New().String(),
+		Name:           session.Name + " copy",
+		Description:    session.Description,
+		AgentId:        session.AgentId,
+		AgentType:      session.AgentType,
+		Models:         slices.Clone(session.Models),
+		Payload:        slices.Clone(session.Payload),
+		History:        cloneHistory(session.History),
+		Config:         session.Config,
+		Pipeline:       slices.Clone(session.Pipeline),
+		FallbackModels: slices.Clone(session.FallbackModels),
+		DryRun:         session.DryRun,
+		Priority:       session.Priority,
+		Tags:           slices.Clone(session.Tags),
+		Status:         pb.WorkloadStatus_PENDING,
+		Timestamp:      time.Now().Unix(),
+	}
+	if err := store.AddSession(clone); err != nil {
+		return nil, fmt.Errorf("error saving clone of session %s: %w", id, err)
+	}
+	return clone, nil
+}
+
+// maxTagLength is the longest tag NormalizeTag accepts, in bytes.
+const maxTagLength = 64
+
+// NormalizeTag lowercases tag and checks it is made of letters, digits and
+// "-_.:/" only. Tags are stored comma separated and typed as one word in the
+// TUI, so commas and spaces can't be part of one.
+func NormalizeTag(tag string) (string, error) {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	if tag == "" {
+		return "", fmt.Errorf("tag is empty")
+	}
+	if len(tag) > maxTagLength {
+		return "", fmt.Errorf("tag %q is longer than %d bytes", tag, maxTagLength)
+	}
+	for _, r := range tag {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && !strings.ContainsRune("-_.:/", r) {
+			return "", fmt.Errorf("tag %q can only have letters, digits and -_.:/", tag)
+		}
+	}
+	return tag, nil
+}
+
+// NormalizeTags normalizes every tag of tags, dropping repeats.
+func NormalizeTags(tags []string) ([]string, error) {
+	var normalized []string
+	for _, tag := range tags {
+		tag, err := NormalizeTag(tag)
+		if err != nil {
+			return nil, err
+		}
+		normalized = withTag(normalized, tag)
+	}
+	return normalized, nil
+}
+
+// withTag returns tags with tag added at the end, unless it is there already.
+func withTag(tags []string, tag string) []string {
+	if slices.Contains(tags, tag) {
+		return tags
+	}
+	return append(slices.Clone(tags), tag)
+}
+
+func withoutTag(tags []string, tag string) []string {
+	return slices.DeleteFunc(slices.Clone(tags), func(t string) bool { return t == tag })
+}
+
+func joinTags(tags []string) string {
+	return strings.Join(tags, ",")
+}
+
+func splitTags(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
+
+// chatMessage is how one message of a session's history is stored.
+type chatMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// joinHistory stores history as a JSON array, or "" if it is empty.
+func joinHistory(history []*pb.ChatMessage) string {
+	if len(history) == 0 {
+		return ""
+	}
+	stored := make([]chatMessage, len(history))
+	for i, message := range history {
+		stored[i] = chatMessage{Role: message.Role, Content: message.Content}
+	}
+	// Structs of strings always marshal.
+	data, _ := json.Marshal(stored)
+	return string(data)
+}
+
+// splitHistory loads a history stored by joinHistory. A history that doesn't
+// parse loads as empty rather than failing the whole scan.
+func splitHistory(id, s string) []*pb.ChatMessage {
+	if s == "" {
+		return nil
+	}
+	var stored []chatMessage
+	if err := json.Unmarshal([]byte(s), &stored); err != nil {
+		log.Printf("Session %s has an unreadable history, loading it without: %v", id, err)
+		return nil
+	}
+	history := make([]*pb.ChatMessage, len(stored))
+	for i, message := range stored {
+		history[i] = &pb.ChatMessage{Role: message.Role, Content: message.Content}
+	}
+	return history
+}
+
+func cloneHistory(history []*pb.ChatMessage) []*pb.ChatMessage {
+	var clone []*pb.ChatMessage
+	for _, message := range history {
+		clone = append(clone, &pb.ChatMessage{Role: message.Role, Content: message.Content})
+	}
+	return clone
+}
+
+// SessionsWithStatus returns the sessions of store that have status.
+func SessionsWithStatus(store Datastore, status pb.WorkloadStatus_Status) ([]*pb.Workload, error) {
+	sessions, err := store.ListSessions()
+	if err != nil {
+		return nil, fmt.Errorf("error listing sessions: %w", err)
+	}
+	var matched []*pb.Workload
+	for _, session := range sessions {
+		if session.Status == status {
+			matched = append(matched, session)
+		}
+	}
+	return matched, nil
+}
+
+// DeleteSessionsWithStatus deletes every session of store that has status and
+// returns the IDs it deleted. It stops at the first failure.
+func DeleteSessionsWithStatus(store Datastore, status pb.WorkloadStatus_Status) ([]string, error) {
+	sessions, err := SessionsWithStatus(store, status)
+	if err != nil {
+		return nil, err
+	}
+	var deleted []string
+	for _, session := range sessions {
+		if err := store.DeleteSession(session.Id); err != nil {
+			return deleted, fmt.Errorf("error deleting session %s: %w", session.Id, err)
+		}
+		deleted = append(deleted, session.Id)
+	}
+	return deleted, nil
+}
+
+// DefaultDuplicateThreshold is the similarity DuplicateThreshold falls back to
+// when asked for one while the setting is off.
+const DefaultDuplicateThreshold = 0.6
+
+// DuplicateThreshold returns the duplicate_threshold setting of store as a
+// similarity from 0 to 1. Zero means duplicates aren't flagged.
+func DuplicateThreshold(store Datastore) (float64, error) {
+	percent, err := IntSetting(store, SettingDuplicateThreshold, 0)
+	if err != nil {
+		return 0, err
+	}
+	return float64(percent) / 100, nil
+}
+
+// SimilarSession is a session and how similar its payload is to another
+// session's, from 0 to 1.
+type SimilarSession struct {
+	Session    *pb.Workload
+	Similarity float64
+}
+
+// SimilarSessions returns the sessions of store running the same agent type
+// as session id whose payload is at least threshold similar to its payload,
+// most similar first. It only reads from store.
+func SimilarSessions(store Datastore, id string, threshold float64) ([]SimilarSession, error) {
+	session, err := store.GetSession(id)
+	if err != nil {
+		return nil, fmt.Errorf("error loading session %s: %w", id, err)
+	}
+	sessions, err := store.ListSessions()
+	if err != nil {
+		return nil, fmt.Errorf("error listing sessions: %w", err)
+	}
+
+	fingerprint := textutil.NewFingerprint(string(session.Payload))
+	if fingerprint.Empty() {
+		return nil, nil
+	}
+	var similar []SimilarSession
+	for _, other := range sessions {
+		if other.Id == id || other.AgentType != session.AgentType {
+			continue
+		}
+		otherFingerprint := textutil.NewFingerprint(string(other.Payload))
+		if fingerprint.MaxSimilarity(otherFingerprint) < threshold {
+			continue
+		}
+		if similarity := fingerprint.Similarity(otherFingerprint); similarity >= threshold {
+			similar = append(similar, SimilarSession{Session: other, Similarity: similarity})
+		}
+	}
+	sort.SliceStable(similar, func(i, j int) bool { return similar[i].Similarity > similar[j].Similarity })
+	return similar, nil
+}
+
+// FindDuplicates maps the ID of every session that is at least threshold
+// similar to an older session running the same agent type to the oldest such
+// session. Sessions without a payload are never duplicates, and with a
+// threshold of zero nothing is.
+func FindDuplicates(sessions []*pb.Workload, threshold float64) map[string]SimilarSession {
+	duplicates := make(map[string]SimilarSession)
+	if threshold <= 0 {
+		return duplicates
+	}
+	ordered := slices.Clone(sessions)
+	sort.SliceStable(ordered, func(i, j int) bool { return ordered[i].Timestamp < ordered[j].Timestamp })
+	fingerprints := make([]textutil.Fingerprint, len(ordered))
+	for i, session := range ordered {
+		fingerprints[i] = textutil.NewFingerprint(string(session.Payload))
+	}
+
+	for i, session := range ordered {
+		if fingerprints[i].Empty() {
+			continue
+		}
+		for j := 0; j < i; j++ {
+			older := ordered[j]
+			if older.AgentType != session.AgentType || fingerprints[i].MaxSimilarity(fingerprints[j]) < threshold {
+				continue
+			}
+			if similarity := fingerprints[i].Similarity(fingerprints[j]); similarity >= threshold {
+				duplicates[session.Id] = SimilarSession{Session: older, Similarity: similarity}
+				break
+			}
+		}
+	}
+	return duplicates
+}
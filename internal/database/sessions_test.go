@@ -0,0 +1,184 @@
+package database
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	pb "github.com/nieveai/d-agents/proto"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestCloneSession(t *testing.T) {
+	forEachDatastore(t, func(t *testing.T, store Datastore) {
+		original := &pb.Workload{
+			Id:        "s1",
+			Name:      "prices",
+			AgentId:   "a1",
+			AgentType: "ChatAgent",
+			Models:    []string{"m1", "m2"},
+			Payload:   []byte("what's the price of 東京 🍣?"),
+			History:   []*pb.ChatMessage{{Role: "user", Content: "hi"}, {Role: "assistant", Content: "hello"}},
+			Config:    `{"target": "fr"}`,
+			Status:    pb.WorkloadStatus_COMPLETED,
+			Error:     "an earlier failure",
+			Timestamp: 1000,
+		}
+		if err := store.AddSession(original); err != nil {
+			t.Fatal(err)
+		}
+
+		clone, err := CloneSession(store, "s1")
+		if err != nil {
+			t.Fatalf("CloneSession: %v", err)
+		}
+		if clone.Id == "" || clone.Id == original.Id {
+			t.Errorf("clone ID = %q, want a new one", clone.Id)
+		}
+		stored, err := store.GetSession(clone.Id)
+		if err != nil {
+			t.Fatalf("clone wasn't stored: %v", err)
+		}
+		if string(stored.Payload) != string(original.Payload) || !slices.Equal(stored.Models, original.Models) {
+			t.Errorf("clone payload, models = %q, %v, want %q, %v", stored.Payload, stored.Models, original.Payload, original.Models)
+		}
+		if !slices.EqualFunc(stored.History, original.History, func(a, b *pb.ChatMessage) bool { return proto.Equal(a, b) }) {
+			t.Errorf("clone history = %v, want %v", stored.History, original.History)
+		}
+		if stored.Name != "prices copy" || stored.AgentId != "a1" || stored.AgentType != "ChatAgent" || stored.Config != original.Config {
+			t.Errorf("clone = %+v, want the setup of the original", stored)
+		}
+		if stored.Status != pb.WorkloadStatus_PENDING || stored.Error != "" || stored.Timestamp <= original.Timestamp {
+			t.Errorf("clone status, error, timestamp = %v, %q, %d, want a new PENDING session", stored.Status, stored.Error, stored.Timestamp)
+		}
+
+		// Changing the clone leaves the original alone.
+		clone.Models[0] = "changed"
+		if got, _ := store.GetSession("s1"); got.Models[0] != "m1" || got.Status != pb.WorkloadStatus_COMPLETED {
+			t.Errorf("original = %+v after cloning, want it unchanged", got)
+		}
+		if _, err := CloneSession(store, "missing"); err == nil {
+			t.Error("CloneSession of a missing session succeeded")
+		}
+	})
+}
+
+func TestDeleteSessionsWithStatus(t *testing.T) {
+	forEachDatastore(t, func(t *testing.T, store Datastore) {
+		statuses := map[string]pb.WorkloadStatus_Status{
+			"done1":   pb.WorkloadStatus_COMPLETED,
+			"done2":   pb.WorkloadStatus_COMPLETED,
+			"failed":  pb.WorkloadStatus_FAILED,
+			"running": pb.WorkloadStatus_RUNNING,
+		}
+		for id, status := range statuses {
+			store.AddSession(&pb.Workload{Id: id, Status: status})
+		}
+
+		deleted, err := DeleteSessionsWithStatus(store, pb.WorkloadStatus_COMPLETED)
+		if err != nil {
+			t.Fatalf("DeleteSessionsWithStatus: %v", err)
+		}
+		slices.Sort(deleted)
+		if !slices.Equal(deleted, []string{"done1", "done2"}) {
+			t.Errorf("deleted %q, want the completed sessions", deleted)
+		}
+		left, _ := store.ListSessions()
+		var ids []string
+		for _, session := range left {
+			ids = append(ids, session.Id)
+		}
+		slices.Sort(ids)
+		if !slices.Equal(ids, []string{"failed", "running"}) {
+			t.Errorf("sessions left = %q, want the failed and running ones", ids)
+		}
+
+		if deleted, err := DeleteSessionsWithStatus(store, pb.WorkloadStatus_CANCELLED); err != nil || len(deleted) != 0 {
+			t.Errorf("DeleteSessionsWithStatus with nothing to delete = %q, %v", deleted, err)
+		}
+	})
+}
+
+// paraphrasedSessions are ShoppingAgent sessions: flights2 asks what
+// flights1 does, shoes doesn't, and chat runs another agent.
+var paraphrasedSessions = []*pb.Workload{
+	{Id: "flights1", AgentType: "ShoppingAgent", Timestamp: 1, Payload: []byte("Find the cheapest flights from London to New York in March.")},
+	{Id: "flights2", AgentType: "ShoppingAgent", Timestamp: 2, Payload: []byte("find the cheapest flights from London to New York in April")},
+	{Id: "shoes", AgentType: "ShoppingAgent", Timestamp: 3, Payload: []byte("Compare prices of running shoes in size 42.")},
+	{Id: "chat", AgentType: "ChatAgent", Timestamp: 4, Payload: []byte("Find the cheapest flights from London to New York in March.")},
+	{Id: "empty", AgentType: "ShoppingAgent", Timestamp: 5},
+}
+
+func TestSimilarSessions(t *testing.T) {
+	forEachDatastore(t, func(t *testing.T, store Datastore) {
+		for _, session := range paraphrasedSessions {
+			store.AddSession(session)
+		}
+
+		similar, err := SimilarSessions(store, "flights1", DefaultDuplicateThreshold)
+		if err != nil {
+			t.Fatalf("SimilarSessions: %v", err)
+		}
+		if len(similar) != 1 || similar[0].Session.Id != "flights2" || similar[0].Similarity < DefaultDuplicateThreshold {
+			t.Errorf("SimilarSessions = %+v, want only flights2", similar)
+		}
+		// Everything of the same agent type with some payload is similar at 0.
+		if similar, _ := SimilarSessions(store, "flights1", 0); len(similar) != 3 || similar[0].Session.Id != "flights2" {
+			t.Errorf("SimilarSessions with no threshold = %+v, want the other ShoppingAgent sessions, flights2 first", similar)
+		}
+		if similar, err := SimilarSessions(store, "empty", 0); len(similar) != 0 || err != nil {
+			t.Errorf("SimilarSessions of an empty payload = %+v, %v", similar, err)
+		}
+		if _, err := SimilarSessions(store, "missing", 0.5); err == nil {
+			t.Error("SimilarSessions of a missing session succeeded")
+		}
+		if sessions, _ := store.ListSessions(); len(sessions) != len(paraphrasedSessions) {
+			t.Errorf("%d sessions left, want all %d", len(sessions), len(paraphrasedSessions))
+		}
+	})
+}
+
+func TestFindDuplicates(t *testing.T) {
+	duplicates := FindDuplicates(paraphrasedSessions, DefaultDuplicateThreshold)
+	if len(duplicates) != 1 || duplicates["flights2"].Session.Id != "flights1" {
+		t.Errorf("FindDuplicates = %+v, want flights2 as a duplicate of flights1", duplicates)
+	}
+	if duplicates := FindDuplicates(paraphrasedSessions, 0); len(duplicates) != 0 {
+		t.Errorf("FindDuplicates with a threshold of 0 = %+v, want none", duplicates)
+	}
+}
+
+func TestDuplicateThreshold(t *testing.T) {
+	store := NewMemoryDatastore()
+	if threshold, err := DuplicateThreshold(store); threshold != 0 || err != nil {
+		t.Errorf("DuplicateThreshold without the setting = %v, %v, want 0", threshold, err)
+	}
+	store.SetSetting(SettingDuplicateThreshold, "75")
+	if threshold, err := DuplicateThreshold(store); threshold != 0.75 || err != nil {
+		t.Errorf("DuplicateThreshold = %v, %v, want 0.75", threshold, err)
+	}
+}
+
+func TestNormalizeTag(t *testing.T) {
+	tests := []struct {
+		in, want string
+		ok       bool
+	}{
+		{" Research ", "research", true},
+		{"q3-2024/eu_team:v1.2", "q3-2024/eu_team:v1.2", true},
+		{"café", "café", true},
+		{"", "", false},
+		{"a,b", "", false},
+		{"two words", "", false},
+		{strings.Repeat("x", 65), "", false},
+	}
+	for _, tt := range tests {
+		got, err := NormalizeTag(tt.in)
+		if got != tt.want || (err == nil) != tt.ok {
+			t.Errorf("NormalizeTag(%q) = %q, %v, want %q, ok %v", tt.in, got, err, tt.want, tt.ok)
+		}
+	}
+	if tags, err := NormalizeTags([]string{"Test", "test", "shopping"}); err != nil || !slices.Equal(tags, []string{"test", "shopping"}) {
+		t.Errorf("NormalizeTags = %q, %v", tags, err)
+	}
+}
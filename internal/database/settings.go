@@ -0,0 +1,290 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Keys of the settings table.
+const (
+	SettingWorkers       = "workers"
+	SettingQueueDepth    = "queue_depth"
+	SettingMaxRetries    = "max_retries"
+	SettingNeo4jURI      = "neo4j.uri"
+	SettingNeo4jUsername = "neo4j.username"
+	SettingNeo4jPassword = "neo4j.password"
+	SettingNeo4jDatabase = "neo4j.database"
+	SettingDefaultModel  = "default_model"
+	// SettingDuplicateThreshold is a percentage, see DuplicateThreshold.
+	SettingDuplicateThreshold = "duplicate_threshold"
+	// SettingAgentLimits caps the workloads of each agent type run at once,
+	// see ParseAgentLimits.
+	SettingAgentLimits = "agent_limits"
+)
+
+// Files the settings are seeded from, and read from when a setting is missing.
+const (
+	ConfigFile      = "config.json"
+	CredentialsFile = "data/neo4j/credentials.txt"
+)
+
+type SettingInfo struct {
+	Key         string
+	Description string
+	Int         bool
+	Secret      bool
+}
+
+// KnownSettings are the settings the controllers read, in display order.
+var KnownSettings = []SettingInfo{
+	{Key: SettingWorkers, Description: "Number of local workers", Int: true},
+	{Key: SettingQueueDepth, Description: "Maximum number of queued workloads", Int: true},
+	{Key: SettingMaxRetries, Description: "Number of times a failing workload is retried", Int: true},
+	{Key: SettingAgentLimits, Description: "Most workloads of an agent type run at once, e.g. ShoppingAgent=2,ChatAgent=10"},
+	{Key: SettingDefaultModel, Description: "Model used by sessions started without one"},
+	{Key: SettingDuplicateThreshold, Description: "Flag sessions whose payload is at least this % similar to an older one's, 0 to not flag any", Int: true},
+	{Key: SettingNeo4jURI, Description: "Neo4j connection URI, e.g. neo4j://localhost:7687"},
+	{Key: SettingNeo4jUsername, Description: "Neo4j user name"},
+	{Key: SettingNeo4jPassword, Description: "Neo4j password, overridden by $NEO4J_PASSWORD", Secret: true},
+	{Key: SettingNeo4jDatabase, Description: "Neo4j database, empty for the server default"},
+}
+
+// ValidateSetting checks that key is a known setting and value fits it.
+func ValidateSetting(key, value string) error {
+	for _, info := range KnownSettings {
+		if info.Key != key {
+			continue
+		}
+		if info.Int {
+			if n, err := strconv.Atoi(value); err != nil || n < 0 {
+				return fmt.Errorf("%s must be a non-negative number", key)
+			}
+		}
+		if key == SettingAgentLimits {
+			if _, err := ParseAgentLimits(value); err != nil {
+				return fmt.Errorf("%s: %w", key, err)
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown setting %q", key)
+}
+
+// fileConfig is the layout of config.json.
+type fileConfig struct {
+	Workers      int         `json:"workers"`
+	QueueDepth   int         `json:"queue_depth"`
+	MaxRetries   *int        `json:"max_retries"`
+	DefaultModel string      `json:"default_model"`
+	Neo4j        Neo4jConfig `json:"neo4j"`
+	Database     string      `json:"database"`
+	// AgentLimits maps agent types to the most of them run at once.
+	AgentLimits map[string]int `json:"agent_limits"`
+}
+
+func readConfigFile(path string) (*fileConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var config fileConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %w", path, err)
+	}
+	return &config, nil
+}
+
+// DatabaseFromConfig returns the database named in configPath, or
+// DefaultDatabase when there is none. It can't be a setting, since the
+// settings live in that database.
+func DatabaseFromConfig(configPath string) (string, error) {
+	config, err := readConfigFile(configPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return DefaultDatabase, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if config.Database == "" {
+		return DefaultDatabase, nil
+	}
+	return config.Database, nil
+}
+
+// DatabaseEnv is the environment variable naming the database, used when
+// there is no -db flag. It wins over config.json.
+const DatabaseEnv = "NEW_DB"
+
+// OpenDatastore opens conn, a SQLite file or a postgres:// URL. When conn is
+// empty the database is taken from $NEW_DB, then config.json, and is
+// DefaultDatabase without either.
+func OpenDatastore(conn string) (Datastore, error) {
+	if conn == "" {
+		conn = os.Getenv(DatabaseEnv)
+	}
+	if conn == "" {
+		var err error
+		conn, err = DatabaseFromConfig(ConfigFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return NewDatastore(conn)
+}
+
+// RegisterFlags adds -db to fs. Call the returned func after parsing fs to
+// open the database it names, see OpenDatastore.
+func RegisterFlags(fs *flag.FlagSet) func() (Datastore, error) {
+	conn := fs.String("db", "", "Database to use, a SQLite file or a postgres:// URL (default $"+DatabaseEnv+", else the database in "+ConfigFile+", else "+DefaultDatabase+")")
+	return func() (Datastore, error) {
+		return OpenDatastore(*conn)
+	}
+}
+
+// SeedSettings copies the settings found in configPath and credentialsPath
+// into store. Settings already in the store are left alone, so after the first
+// run the files only fill in what is still missing. Missing files are fine.
+func SeedSettings(store Datastore, configPath, credentialsPath string) error {
+	values := make(map[string]string)
+	config, err := readConfigFile(configPath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if config != nil {
+		if config.Workers > 0 {
+			values[SettingWorkers] = strconv.Itoa(config.Workers)
+		}
+		if config.QueueDepth > 0 {
+			values[SettingQueueDepth] = strconv.Itoa(config.QueueDepth)
+		}
+		if config.MaxRetries != nil {
+			values[SettingMaxRetries] = strconv.Itoa(*config.MaxRetries)
+		}
+		if config.DefaultModel != "" {
+			values[SettingDefaultModel] = config.DefaultModel
+		}
+		if len(config.AgentLimits) > 0 {
+			values[SettingAgentLimits] = FormatAgentLimits(config.AgentLimits)
+		}
+		if config.Neo4j.Uri != "" {
+			values[SettingNeo4jURI] = config.Neo4j.Uri
+		}
+		if config.Neo4j.Username != "" {
+			values[SettingNeo4jUsername] = config.Neo4j.Username
+		}
+		if config.Neo4j.Password != "" {
+			values[SettingNeo4jPassword] = config.Neo4j.Password
+		}
+		if config.Neo4j.Database != "" {
+			values[SettingNeo4jDatabase] = config.Neo4j.Database
+		}
+	}
+	// A password in config.json wins over the credentials file.
+	if _, ok := values[SettingNeo4jPassword]; !ok {
+		if password, err := readPassword(credentialsPath); err == nil {
+			values[SettingNeo4jPassword] = password
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to read credentials: %w", err)
+		}
+	}
+
+	seeded := 0
+	for _, info := range KnownSettings {
+		value, ok := values[info.Key]
+		if !ok {
+			continue
+		}
+		if _, err := store.GetSetting(info.Key); err == nil {
+			continue
+		} else if !errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("failed to get setting %s: %w", info.Key, err)
+		}
+		if err := store.SetSetting(info.Key, value); err != nil {
+			return fmt.Errorf("failed to set setting %s: %w", info.Key, err)
+		}
+		seeded++
+	}
+	if seeded > 0 {
+		log.Printf("Seeded %d settings from %s and %s", seeded, configPath, credentialsPath)
+	}
+	return nil
+}
+
+// StringSetting returns the setting key, or def when it isn't set.
+func StringSetting(store Datastore, key string, def string) (string, error) {
+	value, err := store.GetSetting(key)
+	if errors.Is(err, sql.ErrNoRows) {
+		return def, nil
+	}
+	if err != nil {
+		return def, fmt.Errorf("failed to get setting %s: %w", key, err)
+	}
+	return value, nil
+}
+
+// IntSetting returns the setting key as a number, or def when it isn't set.
+func IntSetting(store Datastore, key string, def int) (int, error) {
+	value, err := StringSetting(store, key, "")
+	if err != nil || value == "" {
+		return def, err
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return def, fmt.Errorf("setting %s is not a number: %q", key, value)
+	}
+	return n, nil
+}
+
+// ParseAgentLimits parses agent limits of the form
+// ShoppingAgent=2,ChatAgent=10, where each number is the most workloads of
+// that agent type run at once. Types left out aren't limited.
+func ParseAgentLimits(value string) (map[string]int, error) {
+	limits := make(map[string]int)
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		agentType, n, ok := strings.Cut(part, "=")
+		agentType = strings.TrimSpace(agentType)
+		if !ok || agentType == "" {
+			return nil, fmt.Errorf("invalid agent limit %q, expected <agent type>=<number>", part)
+		}
+		limit, err := strconv.Atoi(strings.TrimSpace(n))
+		if err != nil || limit <= 0 {
+			return nil, fmt.Errorf("invalid limit %q for %s, expected a positive number", n, agentType)
+		}
+		limits[agentType] = limit
+	}
+	return limits, nil
+}
+
+// FormatAgentLimits is the inverse of ParseAgentLimits, with the agent types
+// sorted.
+func FormatAgentLimits(limits map[string]int) string {
+	parts := make([]string, 0, len(limits))
+	for _, agentType := range sortedKeys(limits) {
+		parts = append(parts, fmt.Sprintf("%s=%d", agentType, limits[agentType]))
+	}
+	return strings.Join(parts, ",")
+}
+
+// AgentLimitsSetting returns the agent_limits setting, parsed.
+func AgentLimitsSetting(store Datastore) (map[string]int, error) {
+	value, err := StringSetting(store, SettingAgentLimits, "")
+	if err != nil {
+		return nil, err
+	}
+	limits, err := ParseAgentLimits(value)
+	if err != nil {
+		return nil, fmt.Errorf("setting %s: %w", SettingAgentLimits, err)
+	}
+	return limits, nil
+}
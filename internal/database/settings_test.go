@@ -0,0 +1,214 @@
+package database
+
+import (
+	"flag"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeFile writes content to name in dir and returns its path.
+func writeFile(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestSeedSettings(t *testing.T) {
+	dir := t.TempDir()
+	config := writeFile(t, dir, "config.json", `{
+		"workers": 3,
+		"max_retries": 0,
+		"default_model": "m1",
+		"agent_limits": {"ShoppingAgent": 2, "ChatAgent": 10},
+		"neo4j": {"uri": "neo4j://graph:7687", "username": "neo4j"}
+	}`)
+	credentials := writeFile(t, dir, "data/neo4j/credentials.txt", "user: neo4j\npassword: s3cret\n")
+	store := NewMemoryDatastore()
+	// Set before the first run, e.g. from the UI.
+	store.SetSetting(SettingDefaultModel, "m2")
+
+	if err := SeedSettings(store, config, credentials); err != nil {
+		t.Fatalf("SeedSettings: %v", err)
+	}
+	want := map[string]string{
+		SettingWorkers:       "3",
+		SettingMaxRetries:    "0",
+		SettingDefaultModel:  "m2",
+		SettingAgentLimits:   "ChatAgent=10,ShoppingAgent=2",
+		SettingNeo4jURI:      "neo4j://graph:7687",
+		SettingNeo4jUsername: "neo4j",
+		SettingNeo4jPassword: "s3cret",
+	}
+	if got, _ := store.ListSettings(); !maps.Equal(got, want) {
+		t.Errorf("settings = %v, want %v", got, want)
+	}
+
+	// Seeding only happens once: later edits to the file don't override the
+	// table, they only fill in settings that are still missing.
+	writeFile(t, dir, "config.json", `{"workers": 9, "queue_depth": 50}`)
+	store.SetSetting(SettingWorkers, "5")
+	if err := SeedSettings(store, config, credentials); err != nil {
+		t.Fatalf("SeedSettings: %v", err)
+	}
+	want[SettingWorkers] = "5"
+	want[SettingQueueDepth] = "50"
+	if got, _ := store.ListSettings(); !maps.Equal(got, want) {
+		t.Errorf("settings after seeding again = %v, want %v", got, want)
+	}
+}
+
+func TestSeedSettingsPasswordFromConfig(t *testing.T) {
+	dir := t.TempDir()
+	config := writeFile(t, dir, "config.json", `{"neo4j": {"password": "from-config"}}`)
+	credentials := writeFile(t, dir, "credentials.txt", "password: from-file\n")
+	store := NewMemoryDatastore()
+	if err := SeedSettings(store, config, credentials); err != nil {
+		t.Fatalf("SeedSettings: %v", err)
+	}
+	if got, _ := store.GetSetting(SettingNeo4jPassword); got != "from-config" {
+		t.Errorf("password = %q, want the one in config.json", got)
+	}
+}
+
+func TestSeedSettingsFiles(t *testing.T) {
+	dir := t.TempDir()
+	missing := filepath.Join(dir, "missing")
+	store := NewMemoryDatastore()
+	if err := SeedSettings(store, missing, missing); err != nil {
+		t.Errorf("SeedSettings without files: %v", err)
+	}
+	if got, _ := store.ListSettings(); len(got) > 0 {
+		t.Errorf("seeded %v without files", got)
+	}
+
+	bad := writeFile(t, dir, "config.json", `{"workers": "three"`)
+	if err := SeedSettings(store, bad, missing); err == nil || !strings.Contains(err.Error(), "failed to decode") {
+		t.Errorf("SeedSettings with a broken config.json = %v, want a decode error", err)
+	}
+}
+
+func TestIntSetting(t *testing.T) {
+	store := NewMemoryDatastore()
+	if n, err := IntSetting(store, SettingWorkers, 4); n != 4 || err != nil {
+		t.Errorf("IntSetting of a missing key = %d, %v, want the default", n, err)
+	}
+	store.SetSetting(SettingWorkers, "7")
+	if n, err := IntSetting(store, SettingWorkers, 4); n != 7 || err != nil {
+		t.Errorf("IntSetting = %d, %v, want 7", n, err)
+	}
+	store.SetSetting(SettingWorkers, "many")
+	if n, err := IntSetting(store, SettingWorkers, 4); n != 4 || err == nil {
+		t.Errorf("IntSetting of %q = %d, %v, want the default and an error", "many", n, err)
+	}
+}
+
+func TestValidateSetting(t *testing.T) {
+	tests := []struct {
+		key, value string
+		ok         bool
+	}{
+		{SettingWorkers, "4", true},
+		{SettingWorkers, "-1", false},
+		{SettingWorkers, "four", false},
+		{SettingNeo4jURI, "anything", true},
+		{SettingAgentLimits, "ShoppingAgent=2, ChatAgent=10", true},
+		{SettingAgentLimits, "ShoppingAgent=0", false},
+		{SettingAgentLimits, "ShoppingAgent", false},
+		{"no_such_setting", "1", false},
+	}
+	for _, tt := range tests {
+		if err := ValidateSetting(tt.key, tt.value); (err == nil) != tt.ok {
+			t.Errorf("ValidateSetting(%q, %q) = %v, want ok %v", tt.key, tt.value, err, tt.ok)
+		}
+	}
+}
+
+func TestAgentLimitsRoundTrip(t *testing.T) {
+	limits, err := ParseAgentLimits(" ShoppingAgent = 2,,ChatAgent=10 ")
+	if err != nil {
+		t.Fatalf("ParseAgentLimits: %v", err)
+	}
+	if want := map[string]int{"ShoppingAgent": 2, "ChatAgent": 10}; !maps.Equal(limits, want) {
+		t.Errorf("ParseAgentLimits = %v, want %v", limits, want)
+	}
+	if got := FormatAgentLimits(limits); got != "ChatAgent=10,ShoppingAgent=2" {
+		t.Errorf("FormatAgentLimits = %q", got)
+	}
+}
+
+func TestNeo4jConnectionPrefersSettings(t *testing.T) {
+	t.Chdir(t.TempDir())
+	t.Setenv(Neo4jPasswordEnv, "")
+	writeFile(t, ".", ConfigFile, `{"neo4j": {"uri": "neo4j://file:7687", "username": "file-user", "password": "file-pass"}}`)
+	t.Cleanup(func() { UseSettings(nil) })
+
+	store := NewMemoryDatastore()
+	UseSettings(store)
+	// Without a URI in the settings, config.json is the fallback.
+	if config, err := neo4jConnection(); err != nil || config.Uri != "neo4j://file:7687" || config.Password != "file-pass" {
+		t.Errorf("neo4jConnection = %+v, %v, want config.json", config, err)
+	}
+
+	store.SetSetting(SettingNeo4jURI, "neo4j://settings:7687")
+	store.SetSetting(SettingNeo4jUsername, "settings-user")
+	store.SetSetting(SettingNeo4jPassword, "settings-pass")
+	if config, err := neo4jConnection(); err != nil || config.Uri != "neo4j://settings:7687" || config.Username != "settings-user" || config.Password != "settings-pass" {
+		t.Errorf("neo4jConnection = %+v, %v, want the settings", config, err)
+	}
+
+	t.Setenv(Neo4jPasswordEnv, "env-pass")
+	if config, _ := neo4jConnection(); config.Password != "env-pass" {
+		t.Errorf("password = %q, want $%s", config.Password, Neo4jPasswordEnv)
+	}
+}
+
+func TestRegisterFlags(t *testing.T) {
+	tests := []struct {
+		name   string
+		args   []string
+		env    string
+		config string
+		want   string
+	}{
+		{"default", nil, "", "", DefaultDatabase},
+		{"config.json", nil, "", `{"database": "from-config.db"}`, "from-config.db"},
+		{"env", nil, "projects/env.db", `{"database": "from-config.db"}`, "projects/env.db"},
+		{"flag", []string{"-db", "projects/a/custom.db"}, "projects/env.db", `{"database": "from-config.db"}`, "projects/a/custom.db"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Chdir(t.TempDir())
+			t.Setenv(DatabaseEnv, tt.env)
+			if tt.config != "" {
+				writeFile(t, ".", ConfigFile, tt.config)
+			}
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+			openDB := RegisterFlags(fs)
+			if err := fs.Parse(tt.args); err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+			store, err := openDB()
+			if err != nil {
+				t.Fatalf("opening the database: %v", err)
+			}
+			defer store.Close()
+			if _, err := os.Stat(tt.want); err != nil {
+				t.Errorf("database not created at %s: %v", tt.want, err)
+			}
+			for _, other := range []string{DefaultDatabase, "from-config.db", "projects/env.db", "projects/a/custom.db"} {
+				if _, err := os.Stat(other); other != tt.want && err == nil {
+					t.Errorf("a database was created at %s too", other)
+				}
+			}
+		})
+	}
+}
@@ -2,7 +2,9 @@ package database
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
+	"sort"
 	"time"
 
 	_ "github.com/mattn/go-sqlite3"
@@ -12,8 +14,13 @@ type ShoppingDB struct {
 	*sql.DB
 }
 
-func NewShoppingDB() (*ShoppingDB, error) {
-	db, err := sql.Open("sqlite3", "./shopping.db")
+// DefaultShoppingDB is where the shopping agents keep prices unless told
+// otherwise.
+const DefaultShoppingDB = "shopping.db"
+
+// NewShoppingDB opens the prices database at path, creating it if needed.
+func NewShoppingDB(path string) (*ShoppingDB, error) {
+	db, err := openSQLite(path)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
@@ -26,20 +33,63 @@ func NewShoppingDB() (*ShoppingDB, error) {
 			price REAL,
 			date TEXT,
 			source TEXT,
-			url TEXT
+			url TEXT,
+			currency TEXT NOT NULL DEFAULT ''
 		)
 	`)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create table: %w", err)
 	}
+	if err := upgradeProducts(db); err != nil {
+		return nil, fmt.Errorf("failed to upgrade products table: %w", err)
+	}
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS crawls (
+			start_url TEXT NOT NULL,
+			next_page_selector TEXT NOT NULL,
+			next_url TEXT NOT NULL,
+			pages INTEGER NOT NULL,
+			updated TEXT NOT NULL,
+			PRIMARY KEY(start_url, next_page_selector)
+		)
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create crawls table: %w", err)
+	}
 
 	return &ShoppingDB{db}, nil
 }
 
-func (db *ShoppingDB) InsertProduct(name string, price float64, date time.Time, source string, url string) error {
+// upgradeProducts brings a products table from before deduplication up to
+// date: it adds the currency column, drops duplicate rows and adds the
+// unique index InsertProduct relies on.
+func upgradeProducts(db *sql.DB) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := addColumns("products", "currency TEXT NOT NULL DEFAULT ''")(tx); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM products WHERE id NOT IN (
+		SELECT MIN(id) FROM products GROUP BY name, source, date)`); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("CREATE UNIQUE INDEX IF NOT EXISTS products_name_source_date ON products (name, source, date)"); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// InsertProduct records the price of a product on the day of date. A product
+// already recorded for the same source and day is left as it is.
+func (db *ShoppingDB) InsertProduct(name string, price float64, currency string, date time.Time, source string, url string) error {
+	day := date.UTC().Truncate(24 * time.Hour)
 	_, err := db.Exec(
-		"INSERT INTO products (name, price, date, source, url) VALUES (?, ?, ?, ?, ?)",
-		name, price, date.Format(time.RFC3339), source, url,
+		"INSERT OR IGNORE INTO products (name, price, currency, date, source, url) VALUES (?, ?, ?, ?, ?, ?)",
+		name, price, currency, day.Format(time.RFC3339), source, url,
 	)
 	if err != nil {
 		return fmt.Errorf("failed to insert product: %w", err)
@@ -47,17 +97,69 @@ func (db *ShoppingDB) InsertProduct(name string, price float64, date time.Time,
 	return nil
 }
 
+// CrawlState is where an unfinished crawl of a paginated shop stopped: the
+// page to fetch next and how many pages were done before it.
+type CrawlState struct {
+	NextURL string
+	Pages   int
+	Updated time.Time
+}
+
+// GetCrawlState returns where the last crawl from startURL following
+// nextPageSelector stopped, or a zero state if it finished or never ran.
+func (db *ShoppingDB) GetCrawlState(startURL, nextPageSelector string) (CrawlState, error) {
+	var state CrawlState
+	var updated string
+	err := db.QueryRow("SELECT next_url, pages, updated FROM crawls WHERE start_url = ? AND next_page_selector = ?", startURL, nextPageSelector).
+		Scan(&state.NextURL, &state.Pages, &updated)
+	if errors.Is(err, sql.ErrNoRows) {
+		return CrawlState{}, nil
+	}
+	if err != nil {
+		return CrawlState{}, fmt.Errorf("failed to get crawl state of %s: %w", startURL, err)
+	}
+	state.Updated, err = time.Parse(time.RFC3339, updated)
+	if err != nil {
+		return CrawlState{}, fmt.Errorf("failed to parse date: %w", err)
+	}
+	return state, nil
+}
+
+// SetCrawlState records state as where the crawl from startURL is at.
+func (db *ShoppingDB) SetCrawlState(startURL, nextPageSelector string, state CrawlState) error {
+	_, err := db.Exec(`
+		INSERT INTO crawls (start_url, next_page_selector, next_url, pages, updated) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(start_url, next_page_selector) DO UPDATE SET next_url = excluded.next_url, pages = excluded.pages, updated = excluded.updated`,
+		startURL, nextPageSelector, state.NextURL, state.Pages, time.Now().UTC().Format(time.RFC3339),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save crawl state of %s: %w", startURL, err)
+	}
+	return nil
+}
+
+// DeleteCrawlState forgets the crawl from startURL, once it has finished.
+func (db *ShoppingDB) DeleteCrawlState(startURL, nextPageSelector string) error {
+	_, err := db.Exec("DELETE FROM crawls WHERE start_url = ? AND next_page_selector = ?", startURL, nextPageSelector)
+	if err != nil {
+		return fmt.Errorf("failed to delete crawl state of %s: %w", startURL, err)
+	}
+	return nil
+}
+
 type Product struct {
-	ID     int
-	Name   string
-	Price  float64
-	Date   time.Time
-	Source string
-	URL    string
+	ID    int
+	Name  string
+	Price float64
+	// Currency is an ISO 4217 code such as USD, or empty when it isn't known.
+	Currency string
+	Date     time.Time
+	Source   string
+	URL      string
 }
 
 func (db *ShoppingDB) GetAllProducts() ([]*Product, error) {
-	rows, err := db.Query("SELECT id, name, price, date, source, url FROM products")
+	rows, err := db.Query("SELECT id, name, price, currency, date, source, url FROM products")
 	if err != nil {
 		return nil, fmt.Errorf("failed to query products: %w", err)
 	}
@@ -67,7 +169,7 @@ func (db *ShoppingDB) GetAllProducts() ([]*Product, error) {
 	for rows.Next() {
 		var p Product
 		var dateStr string
-		if err := rows.Scan(&p.ID, &p.Name, &p.Price, &dateStr, &p.Source, &p.URL); err != nil {
+		if err := rows.Scan(&p.ID, &p.Name, &p.Price, &p.Currency, &dateStr, &p.Source, &p.URL); err != nil {
 			return nil, fmt.Errorf("failed to scan product: %w", err)
 		}
 		p.Date, err = time.Parse(time.RFC3339, dateStr)
@@ -79,3 +181,91 @@ func (db *ShoppingDB) GetAllProducts() ([]*Product, error) {
 
 	return products, nil
 }
+
+// PricePoint is the lowest price of a product on one day. Source is only set
+// by GetPriceHistoryBySource.
+type PricePoint struct {
+	Date     time.Time
+	Price    float64
+	Currency string
+	Source   string
+}
+
+// ListProductNames returns the names of all recorded products, sorted.
+func (db *ShoppingDB) ListProductNames() ([]string, error) {
+	rows, err := db.Query("SELECT DISTINCT name FROM products ORDER BY name")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query product names: %w", err)
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("failed to scan product name: %w", err)
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+// GetPriceHistory returns the lowest price of the product per day across all
+// sources, oldest first. Prices in different currencies get separate points.
+func (db *ShoppingDB) GetPriceHistory(name string) ([]PricePoint, error) {
+	return db.priceHistory(`
+		SELECT date, currency, '', MIN(price) FROM products
+		WHERE name = ? GROUP BY date, currency`, name)
+}
+
+// GetPriceHistoryBySource is like GetPriceHistory but keeps the sources apart,
+// for products with the same name sold in several shops.
+func (db *ShoppingDB) GetPriceHistoryBySource(name string) (map[string][]PricePoint, error) {
+	points, err := db.priceHistory(`
+		SELECT date, currency, source, MIN(price) FROM products
+		WHERE name = ? GROUP BY date, currency, source`, name)
+	if err != nil {
+		return nil, err
+	}
+	bySource := make(map[string][]PricePoint)
+	for _, point := range points {
+		bySource[point.Source] = append(bySource[point.Source], point)
+	}
+	return bySource, nil
+}
+
+func (db *ShoppingDB) priceHistory(query string, name string) ([]PricePoint, error) {
+	rows, err := db.Query(query, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query price history: %w", err)
+	}
+	defer rows.Close()
+
+	var points []PricePoint
+	for rows.Next() {
+		var point PricePoint
+		var dateStr string
+		var source sql.NullString
+		if err := rows.Scan(&dateStr, &point.Currency, &source, &point.Price); err != nil {
+			return nil, fmt.Errorf("failed to scan price: %w", err)
+		}
+		point.Source = source.String
+		point.Date, err = time.Parse(time.RFC3339, dateStr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse date: %w", err)
+		}
+		points = append(points, point)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Sorted here rather than in SQL, as older rows have dates in local time.
+	sort.SliceStable(points, func(i, j int) bool {
+		if !points[i].Date.Equal(points[j].Date) {
+			return points[i].Date.Before(points[j].Date)
+		}
+		return points[i].Currency < points[j].Currency
+	})
+	return points, nil
+}
@@ -0,0 +1,188 @@
+package database
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestShoppingDBConcurrentInserts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "shopping.db")
+	var dbs []*ShoppingDB
+	for range 2 {
+		db, err := NewShoppingDB(path)
+		if err != nil {
+			t.Fatalf("NewShoppingDB: %v", err)
+		}
+		t.Cleanup(func() { db.Close() })
+		dbs = append(dbs, db)
+	}
+
+	const n = 100
+	date := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	var wg sync.WaitGroup
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			name := fmt.Sprintf("product %d", i)
+			if err := dbs[i%len(dbs)].InsertProduct(name, 9.99, "EUR", date, "shop", "https://shop.test"); err != nil {
+				t.Errorf("InsertProduct: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	names, err := dbs[0].ListProductNames()
+	if err != nil {
+		t.Fatalf("ListProductNames: %v", err)
+	}
+	if len(names) != n {
+		t.Errorf("%d products stored, want %d", len(names), n)
+	}
+}
+
+func newTestShoppingDB(t *testing.T) *ShoppingDB {
+	t.Helper()
+	db, err := NewShoppingDB(filepath.Join(t.TempDir(), "shopping.db"))
+	if err != nil {
+		t.Fatalf("NewShoppingDB: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestInsertProductDedup(t *testing.T) {
+	db := newTestShoppingDB(t)
+	morning := time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC)
+	inserts := []struct {
+		name, source string
+		price        float64
+		date         time.Time
+	}{
+		{"Hub", "shop.test", 24.99, morning},
+		{"Hub", "shop.test", 19.99, morning.Add(6 * time.Hour)}, // same day
+		{"Hub", "other.test", 22.50, morning},                   // other source
+		{"Hub", "shop.test", 21.00, morning.AddDate(0, 0, 1)},   // next day
+	}
+	for _, in := range inserts {
+		if err := db.InsertProduct(in.name, in.price, "USD", in.date, in.source, "https://"+in.source); err != nil {
+			t.Fatalf("InsertProduct: %v", err)
+		}
+	}
+
+	products, err := db.GetAllProducts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(products) != 3 {
+		t.Fatalf("stored %d products, want 3: %v", len(products), products)
+	}
+	for _, p := range products {
+		if p.Source == "shop.test" && p.Date.Equal(time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)) && p.Price != 24.99 {
+			t.Errorf("price on the first day = %v, want the first one kept", p.Price)
+		}
+		if p.Currency != "USD" {
+			t.Errorf("currency = %q, want USD", p.Currency)
+		}
+	}
+}
+
+func TestShoppingDBUpgradesOldTable(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "shopping.db")
+	old, err := openSQLite(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The table as it was before deduplication, with a duplicate row.
+	_, err = old.Exec(`
+		CREATE TABLE products (id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT, price REAL, date TEXT, source TEXT, url TEXT);
+		INSERT INTO products (name, price, date, source, url) VALUES
+			('Hub', 24.99, '2024-03-01T00:00:00Z', 'shop.test', 'https://shop.test'),
+			('Hub', 19.99, '2024-03-01T00:00:00Z', 'shop.test', 'https://shop.test'),
+			('Hub', 21.00, '2024-03-02T00:00:00Z', 'shop.test', 'https://shop.test');
+	`)
+	old.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := NewShoppingDB(path)
+	if err != nil {
+		t.Fatalf("NewShoppingDB: %v", err)
+	}
+	defer db.Close()
+	products, err := db.GetAllProducts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(products) != 2 || products[0].Price != 24.99 || products[0].Currency != "" {
+		t.Errorf("products after the upgrade = %+v, want the duplicate dropped", products)
+	}
+	if err := db.InsertProduct("Hub", 18, "USD", time.Date(2024, 3, 2, 9, 0, 0, 0, time.UTC), "shop.test", "https://shop.test"); err != nil {
+		t.Fatal(err)
+	}
+	if products, _ := db.GetAllProducts(); len(products) != 2 {
+		t.Errorf("the unique index is missing, %d products stored", len(products))
+	}
+}
+
+func TestPriceHistory(t *testing.T) {
+	db := newTestShoppingDB(t)
+	day := func(d int) time.Time { return time.Date(2024, 3, d, 10, 0, 0, 0, time.UTC) }
+	// Inserted out of order, with several shops a day.
+	for _, p := range []struct {
+		name, source string
+		price        float64
+		day          int
+	}{
+		{"Hub", "b.test", 21, 3},
+		{"Hub", "a.test", 25, 1},
+		{"Hub", "b.test", 23, 1},
+		{"Hub", "a.test", 19, 2},
+		{"Hub", "b.test", 22, 2},
+		{"Cable", "a.test", 5, 1},
+	} {
+		if err := db.InsertProduct(p.name, p.price, "USD", day(p.day), p.source, "https://"+p.source); err != nil {
+			t.Fatalf("InsertProduct: %v", err)
+		}
+	}
+
+	names, err := db.ListProductNames()
+	if err != nil || !slices.Equal(names, []string{"Cable", "Hub"}) {
+		t.Errorf("ListProductNames = %v, %v", names, err)
+	}
+
+	history, err := db.GetPriceHistory("Hub")
+	if err != nil {
+		t.Fatalf("GetPriceHistory: %v", err)
+	}
+	var prices []float64
+	for i, point := range history {
+		prices = append(prices, point.Price)
+		if i > 0 && !history[i-1].Date.Before(point.Date) {
+			t.Errorf("history isn't sorted by date: %v", history)
+		}
+	}
+	if want := []float64{23, 19, 21}; !slices.Equal(prices, want) {
+		t.Errorf("daily lowest prices = %v, want %v", prices, want)
+	}
+
+	bySource, err := db.GetPriceHistoryBySource("Hub")
+	if err != nil {
+		t.Fatalf("GetPriceHistoryBySource: %v", err)
+	}
+	if len(bySource) != 2 || len(bySource["a.test"]) != 2 || len(bySource["b.test"]) != 3 {
+		t.Fatalf("history by source = %v", bySource)
+	}
+	if a := bySource["a.test"]; a[0].Price != 25 || a[1].Price != 19 || a[0].Source != "a.test" {
+		t.Errorf("a.test history = %v", a)
+	}
+
+	if history, err := db.GetPriceHistory("Missing"); err != nil || len(history) != 0 {
+		t.Errorf("history of an unknown product = %v, %v", history, err)
+	}
+}
@@ -0,0 +1,29 @@
+-- The schema d-agents.db had before any migrations: the three tables the
+-- first releases created, with a row in each.
+CREATE TABLE agents (
+	id TEXT PRIMARY KEY,
+	name TEXT,
+	description TEXT,
+	type TEXT
+);
+CREATE TABLE sessions (
+	id TEXT PRIMARY KEY,
+	name TEXT,
+	agent_id TEXT,
+	agent_type TEXT,
+	models TEXT,
+	payload BLOB,
+	status TEXT,
+	timestamp DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+CREATE TABLE models (
+	id TEXT PRIMARY KEY,
+	provider TEXT,
+	api_key TEXT,
+	model_id TEXT,
+	api_url TEXT,
+	api_spec TEXT
+);
+INSERT INTO agents (id, name, description, type) VALUES ('a1', 'Chat', 'talks', 'ChatAgent');
+INSERT INTO sessions (id, name, agent_id, agent_type, models, payload, status) VALUES ('s1', 'Old chat', 'a1', 'ChatAgent', 'm1,m2', 'hello', 'COMPLETED');
+INSERT INTO models (id, provider, api_key, model_id, api_url, api_spec) VALUES ('m1', 'openai', 'key', 'gpt-4o', 'https://api.test', 'openai');
@@ -0,0 +1,43 @@
+-- A database from just before the migration runner, when columns were added
+-- with ALTER TABLE on startup and nothing recorded which ones had run.
+CREATE TABLE agents (
+	id TEXT PRIMARY KEY,
+	name TEXT,
+	description TEXT,
+	type TEXT
+);
+CREATE TABLE sessions (
+	id TEXT PRIMARY KEY,
+	name TEXT,
+	agent_id TEXT,
+	agent_type TEXT,
+	models TEXT,
+	payload BLOB,
+	status TEXT,
+	timestamp DATETIME DEFAULT CURRENT_TIMESTAMP,
+	config TEXT,
+	prompt_tokens INTEGER DEFAULT 0,
+	completion_tokens INTEGER DEFAULT 0,
+	estimated_cost REAL DEFAULT 0,
+	error TEXT,
+	retry_count INTEGER DEFAULT 0,
+	pipeline TEXT,
+	stage TEXT
+);
+CREATE TABLE models (
+	id TEXT PRIMARY KEY,
+	provider TEXT,
+	api_key TEXT,
+	model_id TEXT,
+	api_url TEXT,
+	api_spec TEXT,
+	prompt_price REAL DEFAULT 0,
+	completion_price REAL DEFAULT 0,
+	enable_web_search INTEGER DEFAULT 0,
+	temperature REAL,
+	max_tokens INTEGER,
+	top_p REAL
+);
+INSERT INTO agents (id, name, description, type) VALUES ('a1', 'Chat', 'talks', 'ChatAgent');
+INSERT INTO sessions (id, name, agent_id, agent_type, models, payload, status, config, retry_count) VALUES ('s1', 'Old chat', 'a1', 'ChatAgent', 'm1,m2', 'hello', 'COMPLETED', '{"stream": true}', 1);
+INSERT INTO models (id, provider, api_key, model_id, api_url, api_spec, temperature) VALUES ('m1', 'openai', 'key', 'gpt-4o', 'https://api.test', 'openai', 0.5);
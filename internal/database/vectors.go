@@ -0,0 +1,61 @@
+package database
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/nieveai/d-agents/internal/models"
+)
+
+// encodeEmbedding packs an embedding as little endian float32s.
+func encodeEmbedding(v []float32) []byte {
+	buf := make([]byte, 4*len(v))
+	for i, f := range v {
+		binary.LittleEndian.PutUint32(buf[4*i:], math.Float32bits(f))
+	}
+	return buf
+}
+
+func decodeEmbedding(buf []byte) ([]float32, error) {
+	if len(buf)%4 != 0 {
+		return nil, fmt.Errorf("embedding blob has %d bytes, not a multiple of 4", len(buf))
+	}
+	v := make([]float32, len(buf)/4)
+	for i := range v {
+		v[i] = math.Float32frombits(binary.LittleEndian.Uint32(buf[4*i:]))
+	}
+	return v, nil
+}
+
+func cosineSimilarity(a, b []float32) float64 {
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
+
+// topChunks scores chunks against query and returns the k most similar, best
+// first. This is a brute force scan, which is fine for small collections.
+func topChunks(chunks []*models.Chunk, query []float32, k int) ([]*models.Chunk, error) {
+	for _, chunk := range chunks {
+		if len(chunk.Embedding) != len(query) {
+			return nil, fmt.Errorf("chunk %d has a %d dimension embedding, the query has %d", chunk.ID, len(chunk.Embedding), len(query))
+		}
+		chunk.Score = cosineSimilarity(chunk.Embedding, query)
+	}
+	sort.SliceStable(chunks, func(i, j int) bool {
+		return chunks[i].Score > chunks[j].Score
+	})
+	if k > 0 && len(chunks) > k {
+		chunks = chunks[:k]
+	}
+	return chunks, nil
+}
@@ -0,0 +1,67 @@
+package database
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/nieveai/d-agents/internal/models"
+)
+
+func TestEmbeddingRoundTrip(t *testing.T) {
+	v := []float32{0, 1.5, -2.25, 1e-7}
+	got, err := decodeEmbedding(encodeEmbedding(v))
+	if err != nil || !slices.Equal(got, v) {
+		t.Errorf("decodeEmbedding(encodeEmbedding(%v)) = %v, %v", v, got, err)
+	}
+	if _, err := decodeEmbedding([]byte{1, 2, 3}); err == nil {
+		t.Error("decodeEmbedding of 3 bytes succeeded")
+	}
+}
+
+func TestSearchChunks(t *testing.T) {
+	// A corpus on the unit circle, so the order by similarity to (1, 0) is
+	// plain to see.
+	corpus := []*models.Chunk{
+		{Collection: "docs", Source: "north", Text: "north", Embedding: []float32{0, 1}},
+		{Collection: "docs", Source: "east", Text: "east", Embedding: []float32{1, 0}},
+		{Collection: "docs", Source: "north-east", Text: "north-east", Embedding: []float32{0.7, 0.7}},
+		{Collection: "docs", Source: "west", Text: "west", Embedding: []float32{-1, 0}},
+		{Collection: "other", Source: "also east", Text: "also east", Embedding: []float32{1, 0}},
+	}
+	tests := []struct {
+		k    int
+		want []string
+	}{
+		{1, []string{"east"}},
+		{3, []string{"east", "north-east", "north"}},
+		{10, []string{"east", "north-east", "north", "west"}},
+	}
+	forEachDatastore(t, func(t *testing.T, store Datastore) {
+		if err := store.AddChunks(corpus); err != nil {
+			t.Fatalf("AddChunks: %v", err)
+		}
+		for _, tt := range tests {
+			chunks, err := store.SearchChunks("docs", []float32{1, 0}, tt.k)
+			if err != nil {
+				t.Fatalf("SearchChunks: %v", err)
+			}
+			var got []string
+			for _, chunk := range chunks {
+				got = append(got, chunk.Text)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("top %d = %v, want %v", tt.k, got, tt.want)
+			}
+			if chunks[0].Score < 0.999 {
+				t.Errorf("score of the exact match = %v, want 1", chunks[0].Score)
+			}
+		}
+
+		if chunks, err := store.SearchChunks("missing", []float32{1, 0}, 3); err != nil || len(chunks) != 0 {
+			t.Errorf("search of an empty collection = %v, %v", chunks, err)
+		}
+		if _, err := store.SearchChunks("docs", []float32{1, 0, 0}, 3); err == nil {
+			t.Error("search with a 3 dimension query of 2 dimension chunks succeeded")
+		}
+	})
+}
@@ -0,0 +1,115 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/nieveai/d-agents/internal/models"
+	pb "github.com/nieveai/d-agents/proto"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// workspaceVersion is bumped when the export format changes incompatibly.
+const workspaceVersion = 1
+
+// Workspace is the JSON document written by ExportWorkspace. Sessions are
+// kept as protojson so every workload field survives the round trip.
+type Workspace struct {
+	Version    int               `json:"version"`
+	ExportedAt time.Time         `json:"exported_at"`
+	Agents     []*models.Agent   `json:"agents"`
+	Models     []*models.Model   `json:"models"`
+	Sessions   []json.RawMessage `json:"sessions"`
+}
+
+// ExportWorkspace writes all agents, models and sessions of store to w. With
+// redactKeys the models are written without their API keys.
+func ExportWorkspace(store Datastore, w io.Writer, redactKeys bool) error {
+	agents, err := store.ListAgents()
+	if err != nil {
+		return fmt.Errorf("error listing agents: %w", err)
+	}
+	dbModels, err := store.ListModels()
+	if err != nil {
+		return fmt.Errorf("error listing models: %w", err)
+	}
+	sessions, err := store.ListSessions()
+	if err != nil {
+		return fmt.Errorf("error listing sessions: %w", err)
+	}
+
+	ws := Workspace{
+		Version:    workspaceVersion,
+		ExportedAt: time.Now().UTC(),
+		Agents:     agents,
+		Models:     dbModels,
+		Sessions:   make([]json.RawMessage, 0, len(sessions)),
+	}
+	if redactKeys {
+		for _, model := range ws.Models {
+			model.APIKey = ""
+		}
+	}
+	for _, session := range sessions {
+		data, err := protojson.Marshal(session)
+		if err != nil {
+			return fmt.Errorf("error encoding session %s: %w", session.Id, err)
+		}
+		ws.Sessions = append(ws.Sessions, data)
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(ws)
+}
+
+// ImportWorkspace reads a workspace written by ExportWorkspace into store.
+// Rows are upserted by ID, so importing the same file twice doesn't duplicate
+// anything. Models exported without an API key keep the key already stored.
+func ImportWorkspace(store Datastore, r io.Reader) error {
+	var ws Workspace
+	if err := json.NewDecoder(r).Decode(&ws); err != nil {
+		return fmt.Errorf("error decoding workspace: %w", err)
+	}
+	if ws.Version != workspaceVersion {
+		return fmt.Errorf("unsupported workspace version %d", ws.Version)
+	}
+
+	// Decode everything before writing so a bad file doesn't leave a half import.
+	sessions := make([]*pb.Workload, len(ws.Sessions))
+	for i, data := range ws.Sessions {
+		sessions[i] = &pb.Workload{}
+		if err := protojson.Unmarshal(data, sessions[i]); err != nil {
+			return fmt.Errorf("error decoding session %d: %w", i, err)
+		}
+	}
+
+	for _, agent := range ws.Agents {
+		if err := store.AddAgent(agent); err != nil {
+			return fmt.Errorf("error importing agent %s: %w", agent.ID, err)
+		}
+	}
+	for _, model := range ws.Models {
+		if model.APIKey == "" {
+			existing, err := store.GetModel(model.ID)
+			if err == nil {
+				model.APIKey = existing.APIKey
+			} else if !errors.Is(err, sql.ErrNoRows) {
+				return fmt.Errorf("error getting model %s: %w", model.ID, err)
+			}
+		}
+		if err := store.AddModel(model); err != nil {
+			return fmt.Errorf("error importing model %s: %w", model.ID, err)
+		}
+	}
+	for _, session := range sessions {
+		if err := store.AddSession(session); err != nil {
+			return fmt.Errorf("error importing session %s: %w", session.Id, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package database
+
+import (
+	"bytes"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/nieveai/d-agents/internal/models"
+	pb "github.com/nieveai/d-agents/proto"
+	"google.golang.org/protobuf/proto"
+)
+
+// newTestWorkspace returns a memory store with a couple of rows in each table.
+func newTestWorkspace(t *testing.T) *MemoryDatastore {
+	t.Helper()
+	store := NewMemoryDatastore()
+	temperature := 0.2
+	for _, agent := range []*models.Agent{
+		{ID: "a1", Name: "Chat", Type: "ChatAgent", SystemPrompt: "be brief"},
+		{ID: "a2", Name: "Shopper", Description: "finds deals", Type: "ShoppingAgent"},
+	} {
+		if err := store.AddAgent(agent); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, model := range []*models.Model{
+		{ID: "m1", Provider: "openai", APIKey: "sk-secret", ModelID: "gpt-4o", APISpec: "openai", Temperature: &temperature},
+		{ID: "m2", Provider: "google", APIKey: "g-secret", ModelID: "gemini-2.5-flash", APISpec: "gemini", EnableWebSearch: true},
+	} {
+		if err := store.AddModel(model); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, session := range []*pb.Workload{
+		{Id: "s1", Name: "Prices", AgentType: "ShoppingAgent", Models: []string{"m1", "m2"}, Payload: []byte("hello\x00world"), Status: pb.WorkloadStatus_COMPLETED, Timestamp: 1700000000},
+		{Id: "s2", AgentType: "ChatAgent", Models: []string{"m2"}, Status: pb.WorkloadStatus_FAILED, Error: "boom", Pipeline: []string{"ChatAgent", "TranslationAgent"}},
+	} {
+		if err := store.AddSession(session); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return store
+}
+
+// sameWorkspace reports how the rows of got differ from want, if they do.
+func sameWorkspace(t *testing.T, got, want Datastore) {
+	t.Helper()
+	gotAgents, _ := got.ListAgents()
+	wantAgents, _ := want.ListAgents()
+	sort.Slice(gotAgents, func(i, j int) bool { return gotAgents[i].ID < gotAgents[j].ID })
+	sort.Slice(wantAgents, func(i, j int) bool { return wantAgents[i].ID < wantAgents[j].ID })
+	if !reflect.DeepEqual(gotAgents, wantAgents) {
+		t.Errorf("agents = %+v, want %+v", gotAgents, wantAgents)
+	}
+
+	gotModels, _ := got.ListModels()
+	wantModels, _ := want.ListModels()
+	sort.Slice(gotModels, func(i, j int) bool { return gotModels[i].ID < gotModels[j].ID })
+	sort.Slice(wantModels, func(i, j int) bool { return wantModels[i].ID < wantModels[j].ID })
+	if !reflect.DeepEqual(gotModels, wantModels) {
+		t.Errorf("models = %+v, want %+v", gotModels, wantModels)
+	}
+
+	gotSessions, _ := got.ListSessions()
+	wantSessions, _ := want.ListSessions()
+	if len(gotSessions) != len(wantSessions) {
+		t.Fatalf("%d sessions, want %d", len(gotSessions), len(wantSessions))
+	}
+	for _, w := range wantSessions {
+		g, err := got.GetSession(w.Id)
+		if err != nil {
+			t.Errorf("GetSession(%s): %v", w.Id, err)
+		} else if !proto.Equal(g, w) {
+			t.Errorf("session %s = %v, want %v", w.Id, g, w)
+		}
+	}
+}
+
+func TestWorkspaceRoundTrip(t *testing.T) {
+	src := newTestWorkspace(t)
+	var buf bytes.Buffer
+	if err := ExportWorkspace(src, &buf, false); err != nil {
+		t.Fatalf("ExportWorkspace: %v", err)
+	}
+
+	dst := NewMemoryDatastore()
+	for range 2 {
+		// Importing again upserts rather than duplicating.
+		if err := ImportWorkspace(dst, bytes.NewReader(buf.Bytes())); err != nil {
+			t.Fatalf("ImportWorkspace: %v", err)
+		}
+	}
+	sameWorkspace(t, dst, src)
+}
+
+func TestWorkspaceRedactKeys(t *testing.T) {
+	src := newTestWorkspace(t)
+	var buf bytes.Buffer
+	if err := ExportWorkspace(src, &buf, true); err != nil {
+		t.Fatalf("ExportWorkspace: %v", err)
+	}
+	if strings.Contains(buf.String(), "secret") {
+		t.Errorf("redacted export has an API key:\n%s", buf.String())
+	}
+	if model, _ := src.GetModel("m1"); model.APIKey != "sk-secret" {
+		t.Errorf("exporting changed the stored key to %q", model.APIKey)
+	}
+
+	// A model that is already there keeps its key, a new one gets none.
+	dst := NewMemoryDatastore()
+	dst.AddModel(&models.Model{ID: "m1", ModelID: "gpt-4", APIKey: "sk-local"})
+	if err := ImportWorkspace(dst, &buf); err != nil {
+		t.Fatalf("ImportWorkspace: %v", err)
+	}
+	if model, _ := dst.GetModel("m1"); model.APIKey != "sk-local" || model.ModelID != "gpt-4o" {
+		t.Errorf("m1 = %+v, want the import with the local key", model)
+	}
+	if model, _ := dst.GetModel("m2"); model.APIKey != "" {
+		t.Errorf("m2 key = %q, want none", model.APIKey)
+	}
+}
+
+func TestImportWorkspaceErrors(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+	}{
+		{"not JSON", "agents:", "error decoding workspace"},
+		{"other version", `{"version": 99}`, "unsupported workspace version 99"},
+		{"bad session", `{"version": 1, "agents": [{"id": "a1"}], "sessions": [{"status": "NOPE"}]}`, "error decoding session 0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewMemoryDatastore()
+			err := ImportWorkspace(store, strings.NewReader(tt.in))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("ImportWorkspace = %v, want an error containing %q", err, tt.want)
+			}
+			// Nothing is written from a file that fails to decode.
+			if agents, _ := store.ListAgents(); len(agents) > 0 {
+				t.Errorf("imported %v from a bad file", agents)
+			}
+		})
+	}
+}
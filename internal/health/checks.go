@@ -0,0 +1,44 @@
+package health
+
+import (
+	"context"
+	"errors"
+
+	"github.com/nieveai/d-agents/internal/database"
+	"github.com/nieveai/d-agents/internal/worker"
+)
+
+// NewServiceChecker returns a Checker that is ready when db and Neo4j, if it
+// is configured, can be reached and the LLM client has a usable model.
+// Binaries with local workers add Workers as a liveness check.
+func NewServiceChecker(db database.Datastore) *Checker {
+	c := NewChecker()
+	c.AddReadiness("database", db.Ping)
+	c.AddReadiness("neo4j", Neo4j)
+	c.AddReadiness("models", Models)
+	return c
+}
+
+// Neo4j checks the connection of the shared Neo4j driver, reconnecting like
+// database.GetNeo4jDriver does. It is skipped when no Neo4j is configured.
+func Neo4j(ctx context.Context) error {
+	if !database.Neo4jConfigured() {
+		return ErrSkipped
+	}
+	_, err := database.GetNeo4jDriver()
+	return err
+}
+
+// Models fails while the LLM client has no model it could initialize.
+func Models(ctx context.Context) error {
+	if worker.UsableModels() == 0 {
+		return errors.New("no usable models")
+	}
+	return nil
+}
+
+// Workers fails when a local worker stopped or hung, see
+// worker.CheckWorkers.
+func Workers(ctx context.Context) error {
+	return worker.CheckWorkers()
+}
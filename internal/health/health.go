@@ -0,0 +1,157 @@
+// Package health serves the /healthz and /readyz checks of d-agents run as a
+// service. Liveness says whether the process should be left running,
+// readiness whether its dependencies can be reached so it can take work.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// DefaultTimeout bounds each check unless the Checker says otherwise.
+const DefaultTimeout = 5 * time.Second
+
+// ErrSkipped is returned by checks of dependencies that aren't configured.
+// They are reported but don't fail the endpoint.
+var ErrSkipped = errors.New("not configured")
+
+// Check returns nil when what it checks is healthy.
+type Check func(ctx context.Context) error
+
+// Result is the outcome of one check: "ok", "failing" or "skipped".
+type Result struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Report is the body of /healthz and /readyz.
+type Report struct {
+	Status string            `json:"status"`
+	Checks map[string]Result `json:"checks"`
+}
+
+type namedCheck struct {
+	name  string
+	check Check
+}
+
+// Checker holds the checks behind the endpoints. Liveness checks are run by
+// both endpoints, readiness checks only by /readyz.
+type Checker struct {
+	// Timeout bounds each check; zero uses DefaultTimeout.
+	Timeout time.Duration
+
+	mu    sync.Mutex
+	live  []namedCheck
+	ready []namedCheck
+}
+
+func NewChecker() *Checker {
+	return &Checker{Timeout: DefaultTimeout}
+}
+
+// AddLiveness adds a check that the process is working, e.g. that its
+// workers haven't hung. A failing one means the process should be
+// restarted.
+func (c *Checker) AddLiveness(name string, check Check) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.live = append(c.live, namedCheck{name, check})
+}
+
+// AddReadiness adds a check of a dependency, e.g. the database.
+func (c *Checker) AddReadiness(name string, check Check) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ready = append(c.ready, namedCheck{name, check})
+}
+
+// Handler serves /healthz and /readyz, answering 200 when every check passes
+// and 503 otherwise.
+func (c *Checker) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		c.serve(w, r, false)
+	})
+	mux.HandleFunc("GET /readyz", func(w http.ResponseWriter, r *http.Request) {
+		c.serve(w, r, true)
+	})
+	return mux
+}
+
+// Serve exposes the endpoints on addr until the listener fails.
+func (c *Checker) Serve(addr string) error {
+	return http.ListenAndServe(addr, c.Handler())
+}
+
+func (c *Checker) serve(w http.ResponseWriter, r *http.Request, ready bool) {
+	report := c.Run(r.Context(), ready)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if report.Status != "ok" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(report)
+}
+
+// Run runs the liveness checks and, with ready, the readiness checks, all at
+// once.
+func (c *Checker) Run(ctx context.Context, ready bool) Report {
+	c.mu.Lock()
+	checks := append([]namedCheck(nil), c.live...)
+	if ready {
+		checks = append(checks, c.ready...)
+	}
+	timeout := c.Timeout
+	c.mu.Unlock()
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+
+	results := make([]Result, len(checks))
+	var wg sync.WaitGroup
+	for i, nc := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = runCheck(ctx, nc.check, timeout)
+		}()
+	}
+	wg.Wait()
+
+	report := Report{Status: "ok", Checks: make(map[string]Result, len(checks))}
+	for i, nc := range checks {
+		report.Checks[nc.name] = results[i]
+		if results[i].Status == "failing" {
+			report.Status = "failing"
+		}
+	}
+	return report
+}
+
+// runCheck runs check, giving up on it after timeout even if it ignores its
+// context.
+func runCheck(ctx context.Context, check Check, timeout time.Duration) Result {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- check(ctx) }()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	switch {
+	case err == nil:
+		return Result{Status: "ok"}
+	case errors.Is(err, ErrSkipped):
+		return Result{Status: "skipped", Error: err.Error()}
+	}
+	return Result{Status: "failing", Error: err.Error()}
+}
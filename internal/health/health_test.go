@@ -0,0 +1,144 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nieveai/d-agents/internal/database"
+	"github.com/nieveai/d-agents/internal/models"
+	"github.com/nieveai/d-agents/internal/worker"
+)
+
+// get requests path from h and returns the status code and report.
+func get(t *testing.T, h http.Handler, path string) (int, Report) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	var report Report
+	if err := json.NewDecoder(rec.Body).Decode(&report); err != nil {
+		t.Fatalf("GET %s: invalid JSON: %v", path, err)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("GET %s: Content-Type = %q", path, ct)
+	}
+	return rec.Code, report
+}
+
+func TestHandler(t *testing.T) {
+	ok := func(context.Context) error { return nil }
+	fail := func(context.Context) error { return errors.New("connection refused") }
+	skipped := func(context.Context) error { return ErrSkipped }
+	tests := []struct {
+		name         string
+		live, ready  Check
+		wantHealthz  int
+		wantReadyz   int
+		wantStatus   string
+		wantDatabase Result
+	}{
+		{"healthy", ok, ok, http.StatusOK, http.StatusOK, "ok", Result{Status: "ok"}},
+		{"dependency down", ok, fail, http.StatusOK, http.StatusServiceUnavailable, "failing", Result{Status: "failing", Error: "connection refused"}},
+		{"not configured", ok, skipped, http.StatusOK, http.StatusOK, "ok", Result{Status: "skipped", Error: "not configured"}},
+		{"hung", fail, ok, http.StatusServiceUnavailable, http.StatusServiceUnavailable, "failing", Result{Status: "ok"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewChecker()
+			c.AddLiveness("workers", tt.live)
+			c.AddReadiness("database", tt.ready)
+			h := c.Handler()
+
+			code, report := get(t, h, "/healthz")
+			if code != tt.wantHealthz {
+				t.Errorf("/healthz = %d, want %d", code, tt.wantHealthz)
+			}
+			if _, ran := report.Checks["database"]; ran {
+				t.Error("/healthz ran a readiness check")
+			}
+			code, report = get(t, h, "/readyz")
+			if code != tt.wantReadyz {
+				t.Errorf("/readyz = %d, want %d", code, tt.wantReadyz)
+			}
+			if got := report.Checks["database"]; got != tt.wantDatabase {
+				t.Errorf("database check = %+v, want %+v", got, tt.wantDatabase)
+			}
+			if report.Status != tt.wantStatus {
+				t.Errorf("/readyz status = %q, want %q", report.Status, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestCheckTimeout(t *testing.T) {
+	c := NewChecker()
+	c.Timeout = 50 * time.Millisecond
+	release := make(chan struct{})
+	defer close(release)
+	// A check that ignores its context.
+	c.AddReadiness("stuck", func(context.Context) error { <-release; return nil })
+
+	start := time.Now()
+	report := c.Run(context.Background(), true)
+	if result := report.Checks["stuck"]; result.Status != "failing" || result.Error != context.DeadlineExceeded.Error() {
+		t.Errorf("stuck check = %+v, want it failing on the timeout", result)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Run took %s with a 50ms timeout", elapsed)
+	}
+}
+
+func TestServiceChecker(t *testing.T) {
+	// No config.json, so Neo4j isn't configured.
+	t.Chdir(t.TempDir())
+	store, err := database.NewSQLiteDatastore(filepath.Join(t.TempDir(), "d-agents.db"))
+	if err != nil {
+		t.Fatalf("NewSQLiteDatastore: %v", err)
+	}
+	model := &models.Model{ID: "m1", Provider: "openai", ModelID: "gpt-test", APISpec: "openai", APIKey: "test-key", APIURL: "http://127.0.0.1:1/v1"}
+	if err := worker.Init(context.Background(), []*models.Model{model}, store); err != nil {
+		t.Fatalf("worker.Init: %v", err)
+	}
+	t.Cleanup(func() { worker.Init(context.Background(), []*models.Model{}, nil) })
+	h := NewServiceChecker(store).Handler()
+
+	code, report := get(t, h, "/readyz")
+	if code != http.StatusOK {
+		t.Errorf("/readyz = %d %+v, want 200", code, report)
+	}
+	want := map[string]string{"database": "ok", "models": "ok", "neo4j": "skipped"}
+	for name, status := range want {
+		if got := report.Checks[name].Status; got != status {
+			t.Errorf("%s check = %q, want %q", name, got, status)
+		}
+	}
+
+	store.Close()
+	code, report = get(t, h, "/readyz")
+	if code != http.StatusServiceUnavailable || report.Checks["database"].Status != "failing" {
+		t.Errorf("/readyz with the datastore closed = %d %+v, want 503 with the database failing", code, report)
+	}
+	// The process itself is fine.
+	if code, _ := get(t, h, "/healthz"); code != http.StatusOK {
+		t.Errorf("/healthz with the datastore closed = %d, want 200", code)
+	}
+}
+
+func TestServiceCheckerWithoutModels(t *testing.T) {
+	t.Chdir(t.TempDir())
+	store := database.NewMemoryDatastore()
+	if err := worker.Init(context.Background(), []*models.Model{}, store); err != nil {
+		t.Fatalf("worker.Init: %v", err)
+	}
+	t.Cleanup(func() { worker.Init(context.Background(), []*models.Model{}, nil) })
+
+	code, report := get(t, NewServiceChecker(store).Handler(), "/readyz")
+	if code != http.StatusServiceUnavailable || report.Checks["models"] != (Result{Status: "failing", Error: "no usable models"}) {
+		t.Errorf("/readyz without models = %d %+v, want 503 for the models", code, report)
+	}
+}
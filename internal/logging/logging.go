@@ -0,0 +1,47 @@
+// Package logging sets up the process-wide slog logger. Once Setup has run,
+// plain log.Printf calls go through the same handler at info level.
+package logging
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// Setup installs a slog logger writing to w as the default logger. level is
+// one of debug, info, warn or error; format is text or json.
+func Setup(w io.Writer, level, format string) (*slog.Logger, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("invalid log level %q: %w", level, err)
+	}
+
+	opts := &slog.HandlerOptions{Level: lvl}
+	var handler slog.Handler
+	switch strings.ToLower(format) {
+	case "", "text":
+		handler = slog.NewTextHandler(w, opts)
+	case "json":
+		handler = slog.NewJSONHandler(w, opts)
+	default:
+		return nil, fmt.Errorf("invalid log format %q (want text or json)", format)
+	}
+
+	logger := slog.New(handler)
+	slog.SetDefault(logger)
+	return logger, nil
+}
+
+// RegisterFlags adds -log-level and -log-format to the default flag set. Call
+// the returned func after flag.Parse to apply them.
+func RegisterFlags() func() error {
+	level := flag.String("log-level", "info", "Log level (debug, info, warn, error)")
+	format := flag.String("log-format", "text", "Log output format (text or json)")
+	return func() error {
+		_, err := Setup(os.Stderr, *level, *format)
+		return err
+	}
+}
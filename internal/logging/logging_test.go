@@ -0,0 +1,76 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestSetup(t *testing.T) {
+	old := slog.Default()
+	t.Cleanup(func() { slog.SetDefault(old) })
+
+	tests := []struct {
+		level, format string
+		wantErr       bool
+		// wantDebug says whether a debug message is written.
+		wantDebug bool
+	}{
+		{level: "info", format: "text"},
+		{level: "DEBUG", format: "", wantDebug: true},
+		{level: "warn", format: "JSON"},
+		{level: "loud", format: "text", wantErr: true},
+		{level: "info", format: "xml", wantErr: true},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		logger, err := Setup(&buf, tt.level, tt.format)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("Setup(%q, %q) succeeded", tt.level, tt.format)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Setup(%q, %q): %v", tt.level, tt.format, err)
+		}
+		if slog.Default() != logger {
+			t.Errorf("Setup(%q, %q) didn't install the logger", tt.level, tt.format)
+		}
+		slog.Debug("debug message")
+		if got := strings.Contains(buf.String(), "debug message"); got != tt.wantDebug {
+			t.Errorf("Setup(%q, %q) wrote debug messages: %v, want %v", tt.level, tt.format, got, tt.wantDebug)
+		}
+	}
+}
+
+func TestSetupJSONAndStandardLog(t *testing.T) {
+	old := slog.Default()
+	t.Cleanup(func() { slog.SetDefault(old) })
+
+	var buf bytes.Buffer
+	if _, err := Setup(&buf, "info", "json"); err != nil {
+		t.Fatal(err)
+	}
+	slog.Info("workload received", "session_id", "s1")
+	// Plain log calls go through the same handler.
+	log.Printf("from the log package")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines, want 2:\n%s", len(lines), buf.String())
+	}
+	var event map[string]any
+	if err := json.Unmarshal([]byte(lines[0]), &event); err != nil {
+		t.Fatalf("line %q isn't JSON: %v", lines[0], err)
+	}
+	if event["msg"] != "workload received" || event["session_id"] != "s1" || event["level"] != "INFO" {
+		t.Errorf("event = %v", event)
+	}
+	if !strings.Contains(lines[1], "from the log package") {
+		t.Errorf("log.Printf line = %q", lines[1])
+	}
+}
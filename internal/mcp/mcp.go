@@ -3,25 +3,86 @@ package mcp
 import (
 	"context"
 	"fmt"
+	"os/exec"
 
 	"github.com/modelcontextprotocol/go-sdk/mcp"
 )
 
+// TransportConfig says how to reach an MCP server.
+type TransportConfig struct {
+	// Transport is "stdio", "command", "sse" or "http".
+	Transport string `json:"transport"`
+	// Command and Args start a server speaking MCP over its stdin/stdout.
+	Command string   `json:"command,omitempty"`
+	Args    []string `json:"args,omitempty"`
+	// Endpoint is the server URL for the sse and http transports.
+	Endpoint string `json:"endpoint,omitempty"`
+}
+
+// NewTransport creates the client transport described by cfg.
+func NewTransport(cfg TransportConfig) (mcp.Transport, error) {
+	switch cfg.Transport {
+	case "stdio":
+		// Talk MCP over our own stdin/stdout, for when we are the subprocess.
+		return &mcp.StdioTransport{}, nil
+	case "command":
+		if cfg.Command == "" {
+			return nil, fmt.Errorf("the command transport needs a command")
+		}
+		return &mcp.CommandTransport{Command: exec.Command(cfg.Command, cfg.Args...)}, nil
+	case "sse":
+		if cfg.Endpoint == "" {
+			return nil, fmt.Errorf("the sse transport needs an endpoint")
+		}
+		return &mcp.SSEClientTransport{Endpoint: cfg.Endpoint}, nil
+	case "http":
+		if cfg.Endpoint == "" {
+			return nil, fmt.Errorf("the http transport needs an endpoint")
+		}
+		return &mcp.StreamableClientTransport{Endpoint: cfg.Endpoint}, nil
+	default:
+		return nil, fmt.Errorf("unknown MCP transport: %q", cfg.Transport)
+	}
+}
+
 func NewClient() *mcp.Client {
 	// Create a new MCP client.
 	client := mcp.NewClient(&mcp.Implementation{Name: "mcp-client", Version: "v1.0.0"}, nil)
 	return client
 }
 
-func Connect(client *mcp.Client, transport mcp.Transport) (*mcp.ClientSession, error) {
+func Connect(ctx context.Context, client *mcp.Client, transport mcp.Transport) (*mcp.ClientSession, error) {
 	// Connect to the server.
-	session, err := client.Connect(context.Background(), transport, nil)
+	session, err := client.Connect(ctx, transport, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to MCP server: %w", err)
 	}
 	return session, nil
 }
 
+// Dial creates the transport described by cfg and connects to the server,
+// saying which transport and endpoint failed if it can't.
+func Dial(ctx context.Context, client *mcp.Client, cfg TransportConfig) (*mcp.ClientSession, error) {
+	transport, err := NewTransport(cfg)
+	if err != nil {
+		return nil, err
+	}
+	session, err := client.Connect(ctx, transport, nil)
+	if err != nil {
+		switch cfg.Transport {
+		case "stdio":
+			return nil, fmt.Errorf("failed to connect to MCP server over stdio (is a server attached to stdin/stdout?): %w", err)
+		case "command":
+			return nil, fmt.Errorf("failed to start MCP server %q: %w", cfg.Command, err)
+		case "sse":
+			return nil, fmt.Errorf("failed to connect to MCP SSE endpoint %s: %w", cfg.Endpoint, err)
+		default:
+			return nil, fmt.Errorf("failed to connect to MCP HTTP endpoint %s: %w", cfg.Endpoint, err)
+		}
+	}
+	return session, nil
+}
+
 func GetServerCapabilities(session *mcp.ClientSession) *mcp.ServerCapabilities {
 	return session.InitializeResult().Capabilities
 }
@@ -0,0 +1,74 @@
+package mcp
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+	"github.com/nieveai/d-agents/internal/database"
+)
+
+func TestNewTransport(t *testing.T) {
+	tests := []struct {
+		cfg     TransportConfig
+		wantErr string
+	}{
+		{TransportConfig{Transport: "stdio"}, ""},
+		{TransportConfig{Transport: "command", Command: "my-server"}, ""},
+		{TransportConfig{Transport: "command"}, "needs a command"},
+		{TransportConfig{Transport: "sse", Endpoint: "http://localhost/sse"}, ""},
+		{TransportConfig{Transport: "sse"}, "needs an endpoint"},
+		{TransportConfig{Transport: "http", Endpoint: "http://localhost/mcp"}, ""},
+		{TransportConfig{Transport: "http"}, "needs an endpoint"},
+		{TransportConfig{Transport: "carrier-pigeon"}, "unknown MCP transport"},
+	}
+	for _, tt := range tests {
+		_, err := NewTransport(tt.cfg)
+		if tt.wantErr == "" && err != nil {
+			t.Errorf("NewTransport(%+v) = %v", tt.cfg, err)
+		}
+		if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+			t.Errorf("NewTransport(%+v) = %v, want an error containing %q", tt.cfg, err, tt.wantErr)
+		}
+	}
+}
+
+func TestDial(t *testing.T) {
+	server := NewServer(database.NewMemoryDatastore(), echoProcess(nil))
+	getServer := func(*http.Request) *mcp.Server { return server }
+	handlers := map[string]http.Handler{
+		"sse":  mcp.NewSSEHandler(getServer),
+		"http": mcp.NewStreamableHTTPHandler(getServer, nil),
+	}
+	for transport, handler := range handlers {
+		t.Run(transport, func(t *testing.T) {
+			ts := httptest.NewServer(handler)
+			defer ts.Close()
+
+			session, err := Dial(context.Background(), NewClient(), TransportConfig{Transport: transport, Endpoint: ts.URL})
+			if err != nil {
+				t.Fatalf("Dial: %v", err)
+			}
+			defer session.Close()
+			caps := GetServerCapabilities(session)
+			if caps == nil || caps.Tools == nil || caps.Resources == nil {
+				t.Errorf("capabilities = %+v, want tools and resources", caps)
+			}
+		})
+	}
+}
+
+func TestDialErrorNamesTheEndpoint(t *testing.T) {
+	ts := httptest.NewServer(http.NotFoundHandler())
+	defer ts.Close()
+
+	for _, transport := range []string{"sse", "http"} {
+		_, err := Dial(context.Background(), NewClient(), TransportConfig{Transport: transport, Endpoint: ts.URL})
+		if err == nil || !strings.Contains(err.Error(), ts.URL) {
+			t.Errorf("Dial over %s = %v, want an error naming %s", transport, err, ts.URL)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package mcp
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+	"github.com/nieveai/d-agents/internal/database"
+	"github.com/nieveai/d-agents/internal/models"
+	pb "github.com/nieveai/d-agents/proto"
+)
+
+// sessionURIPrefix is the scheme of the session payload resources.
+const sessionURIPrefix = "d-agents://sessions/"
+
+// RunAgentInput is the input of the run_agent tool.
+type RunAgentInput struct {
+	AgentType string   `json:"agent_type" jsonschema:"the agent type to run, e.g. ChatAgent"`
+	Payload   string   `json:"payload" jsonschema:"the input for the agent"`
+	Models    []string `json:"models" jsonschema:"the IDs of the models the agent may use"`
+	Config    string   `json:"config,omitempty" jsonschema:"optional agent config as a JSON string"`
+	Name      string   `json:"name,omitempty" jsonschema:"optional session name"`
+}
+
+// RunAgentOutput is the result of the run_agent tool.
+type RunAgentOutput struct {
+	SessionID string `json:"session_id"`
+	Status    string `json:"status"`
+	Payload   string `json:"payload"`
+	Error     string `json:"error,omitempty"`
+}
+
+// SessionSummary is one entry of the list_sessions tool output.
+type SessionSummary struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	AgentType string `json:"agent_type"`
+	Status    string `json:"status"`
+	Error     string `json:"error,omitempty"`
+}
+
+// ListSessionsOutput is the result of the list_sessions tool.
+type ListSessionsOutput struct {
+	Sessions []SessionSummary `json:"sessions"`
+}
+
+// NewServer creates an MCP server exposing d-agents to other MCP hosts. It
+// stores sessions in db and runs them with process, normally
+// worker.ProcessWorkload, which is passed in since the worker package
+// depends on this one through the agents.
+func NewServer(db database.Datastore, process func(ctx context.Context, workload *pb.Workload)) *mcp.Server {
+	server := mcp.NewServer(&mcp.Implementation{Name: "d-agents", Version: "v1.0.0"}, nil)
+
+	mcp.AddTool(server, &mcp.Tool{
+		Name:        "run_agent",
+		Description: "Run a d-agents agent on a payload and return the resulting payload.",
+	}, func(ctx context.Context, req *mcp.CallToolRequest, in RunAgentInput) (*mcp.CallToolResult, RunAgentOutput, error) {
+		return runAgent(ctx, db, process, in)
+	})
+
+	mcp.AddTool(server, &mcp.Tool{
+		Name:        "list_sessions",
+		Description: "List the d-agents sessions with their status.",
+	}, func(ctx context.Context, req *mcp.CallToolRequest, in struct{}) (*mcp.CallToolResult, ListSessionsOutput, error) {
+		sessions, err := db.ListSessions()
+		if err != nil {
+			return nil, ListSessionsOutput{}, fmt.Errorf("error loading sessions: %w", err)
+		}
+		out := ListSessionsOutput{Sessions: []SessionSummary{}}
+		for _, s := range sessions {
+			out.Sessions = append(out.Sessions, SessionSummary{
+				ID:        s.Id,
+				Name:      s.Name,
+				AgentType: s.AgentType,
+				Status:    s.Status.String(),
+				Error:     s.Error,
+			})
+		}
+		return nil, out, nil
+	})
+
+	server.AddResourceTemplate(&mcp.ResourceTemplate{
+		Name:        "session",
+		Description: "The payload of a d-agents session.",
+		MIMEType:    "text/markdown",
+		URITemplate: sessionURIPrefix + "{id}",
+	}, func(ctx context.Context, req *mcp.ReadResourceRequest) (*mcp.ReadResourceResult, error) {
+		uri := req.Params.URI
+		session, err := db.GetSession(strings.TrimPrefix(uri, sessionURIPrefix))
+		if err != nil {
+			return nil, mcp.ResourceNotFoundError(uri)
+		}
+		return &mcp.ReadResourceResult{Contents: []*mcp.ResourceContents{{
+			URI:      uri,
+			MIMEType: "text/markdown",
+			Text:     string(session.Payload),
+		}}}, nil
+	})
+
+	return server
+}
+
+func runAgent(ctx context.Context, db database.Datastore, process func(context.Context, *pb.Workload), in RunAgentInput) (*mcp.CallToolResult, RunAgentOutput, error) {
+	if !slices.Contains(models.RegisteredAgentTypes(), in.AgentType) {
+		return nil, RunAgentOutput{}, fmt.Errorf("unknown agent type: %s", in.AgentType)
+	}
+	if len(in.Models) == 0 {
+		return nil, RunAgentOutput{}, fmt.Errorf("at least one model is required")
+	}
+
+	name := in.Name
+	if name == "" {
+		name = in.AgentType
+	}
+	workload := &pb.Workload{
+		Id:        uuid.New().String(),
+		Name:      name,
+		AgentType: in.AgentType,
+		Models:    in.Models,
+		Payload:   []byte(in.Payload),
+		Config:    in.Config,
+		Status:    pb.WorkloadStatus_RUNNING,
+	}
+	if err := db.AddSession(workload); err != nil {
+		return nil, RunAgentOutput{}, fmt.Errorf("error saving session: %w", err)
+	}
+
+	process(ctx, workload)
+
+	out := RunAgentOutput{
+		SessionID: workload.Id,
+		Status:    workload.Status.String(),
+		Payload:   string(workload.Payload),
+		Error:     workload.Error,
+	}
+	if workload.Status == pb.WorkloadStatus_FAILED {
+		// Report the failure as a tool error so the host's model notices it.
+		return &mcp.CallToolResult{
+			IsError: true,
+			Content: []mcp.Content{&mcp.TextContent{Text: fmt.Sprintf("session %s failed: %s", workload.Id, workload.Error)}},
+		}, out, nil
+	}
+	return nil, out, nil
+}
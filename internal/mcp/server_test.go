@@ -0,0 +1,156 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+	"github.com/nieveai/d-agents/internal/database"
+	"github.com/nieveai/d-agents/internal/models"
+	pb "github.com/nieveai/d-agents/proto"
+)
+
+type nopAgent struct{}
+
+func (nopAgent) DoWork(ctx context.Context, workload *pb.Workload, client models.GenAIClient) error {
+	return nil
+}
+
+// echoProcess stands in for worker.ProcessWorkload. It fails workloads whose
+// payload is "fail" and echoes the others.
+func echoProcess(db database.Datastore) func(ctx context.Context, workload *pb.Workload) {
+	return func(ctx context.Context, workload *pb.Workload) {
+		if string(workload.Payload) == "fail" {
+			workload.Status = pb.WorkloadStatus_FAILED
+			workload.Error = "it broke"
+		} else {
+			workload.Status = pb.WorkloadStatus_COMPLETED
+			workload.Payload = []byte("echo: " + string(workload.Payload))
+		}
+		db.AddSession(workload)
+	}
+}
+
+// connectServer connects a client to a server of NewServer over an in-memory
+// transport.
+func connectServer(t *testing.T, db database.Datastore) *mcp.ClientSession {
+	t.Helper()
+	models.RegisterAgent("nopTestAgent", func() (models.AgentInterface, error) { return nopAgent{}, nil })
+
+	serverTransport, clientTransport := mcp.NewInMemoryTransports()
+	serverSession, err := NewServer(db, echoProcess(db)).Connect(context.Background(), serverTransport, nil)
+	if err != nil {
+		t.Fatalf("server Connect: %v", err)
+	}
+	t.Cleanup(func() { serverSession.Close() })
+	session, err := Connect(context.Background(), NewClient(), clientTransport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { session.Close() })
+	return session
+}
+
+func TestServerTools(t *testing.T) {
+	session := connectServer(t, database.NewMemoryDatastore())
+	res, err := session.ListTools(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("ListTools: %v", err)
+	}
+	var names []string
+	for _, tool := range res.Tools {
+		names = append(names, tool.Name)
+	}
+	slices.Sort(names)
+	if !slices.Equal(names, []string{"list_sessions", "run_agent"}) {
+		t.Errorf("tools = %v, want list_sessions and run_agent", names)
+	}
+}
+
+// runAgentOutput decodes the structured output of a run_agent call.
+func runAgentOutput(t *testing.T, res *mcp.CallToolResult) RunAgentOutput {
+	t.Helper()
+	data, err := json.Marshal(res.StructuredContent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out RunAgentOutput
+	if err := json.Unmarshal(data, &out); err != nil {
+		t.Fatalf("decoding %s: %v", data, err)
+	}
+	return out
+}
+
+func TestServerRunAgent(t *testing.T) {
+	db := database.NewMemoryDatastore()
+	session := connectServer(t, db)
+	ctx := context.Background()
+
+	res, err := session.CallTool(ctx, &mcp.CallToolParams{
+		Name:      "run_agent",
+		Arguments: map[string]any{"agent_type": "nopTestAgent", "payload": "hello", "models": []string{"m1"}},
+	})
+	if err != nil {
+		t.Fatalf("CallTool: %v", err)
+	}
+	out := runAgentOutput(t, res)
+	if res.IsError || out.Status != "COMPLETED" || out.Payload != "echo: hello" || out.SessionID == "" {
+		t.Fatalf("run_agent = %+v (error %v)", out, res.IsError)
+	}
+
+	// The session can be read back as a resource and is listed.
+	read, err := session.ReadResource(ctx, &mcp.ReadResourceParams{URI: sessionURIPrefix + out.SessionID})
+	if err != nil {
+		t.Fatalf("ReadResource: %v", err)
+	}
+	if len(read.Contents) != 1 || read.Contents[0].Text != "echo: hello" {
+		t.Errorf("resource contents = %+v, want the payload", read.Contents)
+	}
+	if _, err := session.ReadResource(ctx, &mcp.ReadResourceParams{URI: sessionURIPrefix + "missing"}); err == nil {
+		t.Error("ReadResource of a missing session succeeded")
+	}
+
+	res, err = session.CallTool(ctx, &mcp.CallToolParams{Name: "list_sessions", Arguments: map[string]any{}})
+	if err != nil {
+		t.Fatalf("CallTool: %v", err)
+	}
+	data, _ := json.Marshal(res.StructuredContent)
+	var list ListSessionsOutput
+	json.Unmarshal(data, &list)
+	if len(list.Sessions) != 1 || list.Sessions[0].ID != out.SessionID || list.Sessions[0].Status != "COMPLETED" {
+		t.Errorf("list_sessions = %+v", list)
+	}
+}
+
+func TestServerRunAgentErrors(t *testing.T) {
+	session := connectServer(t, database.NewMemoryDatastore())
+	tests := []struct {
+		name string
+		args map[string]any
+		want string
+	}{
+		{"unknown agent type", map[string]any{"agent_type": "missingTestAgent", "payload": "hi", "models": []string{"m1"}}, "unknown agent type"},
+		{"no models", map[string]any{"agent_type": "nopTestAgent", "payload": "hi", "models": []string{}}, "at least one model is required"},
+		{"failed session", map[string]any{"agent_type": "nopTestAgent", "payload": "fail", "models": []string{"m1"}}, "failed: it broke"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := session.CallTool(context.Background(), &mcp.CallToolParams{Name: "run_agent", Arguments: tt.args})
+			if err != nil {
+				t.Fatalf("CallTool: %v", err)
+			}
+			var text string
+			for _, c := range res.Content {
+				if tc, ok := c.(*mcp.TextContent); ok {
+					text += tc.Text
+				}
+			}
+			if !res.IsError || !strings.Contains(text, tt.want) {
+				t.Errorf("run_agent = %q (error %v), want an error containing %q", text, res.IsError, tt.want)
+			}
+		})
+	}
+}
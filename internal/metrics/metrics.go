@@ -0,0 +1,78 @@
+// Package metrics holds the Prometheus metrics for workloads and LLM calls.
+// Nothing is served unless Serve is called, so metrics cost next to nothing
+// for binaries that don't expose them.
+package metrics
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	Registry = prometheus.NewRegistry()
+
+	WorkloadsProcessed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "dagents_workloads_processed_total",
+		Help: "Workloads that finished, by agent type and final status.",
+	}, []string{"agent_type", "status"})
+
+	LLMRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "dagents_llm_request_duration_seconds",
+		Help:    "Latency of LLM provider calls.",
+		Buckets: []float64{0.25, 0.5, 1, 2, 5, 10, 20, 40, 80},
+	}, []string{"provider", "model"})
+
+	LLMRequestErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "dagents_llm_request_errors_total",
+		Help: "LLM provider calls that returned an error.",
+	}, []string{"provider", "model"})
+
+	queueMu  sync.Mutex
+	queueLen func() int
+)
+
+func init() {
+	Registry.MustRegister(
+		WorkloadsProcessed,
+		LLMRequestDuration,
+		LLMRequestErrors,
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "dagents_queue_depth",
+			Help: "Workloads waiting in the local queue.",
+		}, queueDepth),
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+// SetQueue tells the queue depth gauge how to read the current queue length.
+func SetQueue(length func() int) {
+	queueMu.Lock()
+	defer queueMu.Unlock()
+	queueLen = length
+}
+
+func queueDepth() float64 {
+	queueMu.Lock()
+	defer queueMu.Unlock()
+	if queueLen == nil {
+		return 0
+	}
+	return float64(queueLen())
+}
+
+// Handler serves the registry in the Prometheus exposition format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+// Serve exposes /metrics on addr until the listener fails.
+func Serve(addr string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", Handler())
+	return http.ListenAndServe(addr, mux)
+}
@@ -0,0 +1,44 @@
+package metrics
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func scrape(t *testing.T) string {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := io.ReadAll(rec.Body)
+	return string(body)
+}
+
+func TestHandler(t *testing.T) {
+	WorkloadsProcessed.WithLabelValues("ChatAgent", "COMPLETED").Inc()
+	LLMRequestDuration.WithLabelValues("openai", "m1").Observe(0.3)
+	LLMRequestErrors.WithLabelValues("openai", "m1").Inc()
+	SetQueue(func() int { return 3 })
+	t.Cleanup(func() { SetQueue(nil) })
+
+	body := scrape(t)
+	for _, want := range []string{
+		`dagents_workloads_processed_total{agent_type="ChatAgent",status="COMPLETED"} 1`,
+		`dagents_llm_request_duration_seconds_bucket{model="m1",provider="openai",le="0.5"} 1`,
+		`dagents_llm_request_errors_total{model="m1",provider="openai"} 1`,
+		"dagents_queue_depth 3",
+		"go_goroutines",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("/metrics has no %q", want)
+		}
+	}
+}
+
+func TestQueueDepthWithoutQueue(t *testing.T) {
+	SetQueue(nil)
+	if !strings.Contains(scrape(t), "dagents_queue_depth 0") {
+		t.Error("queue depth isn't 0 without a queue")
+	}
+}
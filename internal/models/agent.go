@@ -1,6 +1,15 @@
 package models
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
 	pb "github.com/nieveai/d-agents/proto"
 )
 
@@ -10,15 +19,192 @@ type Agent struct {
 	Name        string `json:"name"`
 	Description string `json:"description"`
 	Type        string `json:"type"`
+	// SystemPrompt, if set, replaces the built-in system prompt of agent types
+	// that support it. It is a text/template run with a PromptData, e.g.
+	// "find the suppliers of {{.Name}}".
+	SystemPrompt string `json:"system_prompt,omitempty"`
+}
+
+// PromptData is what a custom system prompt can refer to.
+type PromptData struct {
+	// Name is the session name, the company or product for the built-in
+	// agents.
+	Name        string
+	Description string
+	AgentType   string
+	// Date is today's date, as 2006-01-02.
+	Date string
+}
+
+// RenderSystemPrompt runs prompt, an Agent.SystemPrompt, for workload.
+func RenderSystemPrompt(prompt string, workload *pb.Workload) (string, error) {
+	tmpl, err := template.New("system_prompt").Parse(prompt)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	err = tmpl.Execute(&b, PromptData{
+		Name:        workload.GetName(),
+		Description: workload.GetDescription(),
+		AgentType:   workload.GetAgentType(),
+		Date:        time.Now().Format(time.DateOnly),
+	})
+	if err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// Validate checks the agent's required fields and that its type is registered.
+func (a *Agent) Validate() error {
+	var errs []error
+	if a.ID == "" {
+		errs = append(errs, errors.New("id is required"))
+	}
+	if a.Name == "" {
+		errs = append(errs, errors.New("name is required"))
+	}
+	if a.Type == "" {
+		errs = append(errs, errors.New("type is required"))
+	} else if !isRegistered(a.Type) {
+		errs = append(errs, fmt.Errorf("type %q is not one of %s", a.Type, strings.Join(RegisteredAgentTypes(), ", ")))
+	}
+	if a.SystemPrompt != "" {
+		// Catches both bad syntax and fields PromptData doesn't have.
+		if _, err := RenderSystemPrompt(a.SystemPrompt, &pb.Workload{}); err != nil {
+			errs = append(errs, fmt.Errorf("invalid system_prompt: %w", err))
+		}
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("invalid agent %q: %w", a.ID, err)
+	}
+	return nil
 }
 
 // genAIClient interface for generative AI clients
 type GenAIClient interface {
-	GenerateContent(workload *pb.Workload, input string) (string, error)
-	GenerateContentWithSystemPrompt(workload *pb.Workload, input string, system_prompt string) (string, error)
+	GenerateContent(ctx context.Context, workload *pb.Workload, input string) (string, error)
+	GenerateContentWithSystemPrompt(ctx context.Context, workload *pb.Workload, input string, system_prompt string) (string, error)
+	GenerateContentMulti(ctx context.Context, workload *pb.Workload, input string, system_prompt string) (map[string]string, error)
+	GenerateContentStream(ctx context.Context, workload *pb.Workload, input string, system_prompt string, out chan<- string) error
+	// GenerateChat and GenerateChatStream send a whole conversation, oldest
+	// message first, instead of a single prompt.
+	GenerateChat(ctx context.Context, workload *pb.Workload, messages []Message, system_prompt string) (string, error)
+	GenerateChatStream(ctx context.Context, workload *pb.Workload, messages []Message, system_prompt string, out chan<- string) error
+}
+
+// Embedder is implemented by clients that can produce embeddings.
+type Embedder interface {
+	Embed(ctx context.Context, modelID string, texts []string) ([][]float32, error)
+}
+
+// Tool is a function the model may call while answering. Parameters is the
+// JSON schema of the arguments; Call gets them as the model sent them, as JSON.
+type Tool struct {
+	Name        string
+	Description string
+	Parameters  map[string]any
+	Call        func(ctx context.Context, arguments string) (string, error)
+}
+
+// ErrNoModelsSpecified is returned for workloads without any model to run on.
+var ErrNoModelsSpecified = errors.New("workload has no models specified")
+
+// ErrInvalidResponse is wrapped by errors for answers that can't be used, such
+// as JSON that doesn't parse or doesn't match the schema asked for.
+var ErrInvalidResponse = errors.New("invalid response from model")
+
+// ErrInputTooLarge is returned for input over a model's MaxInputBytes that
+// can't be cut down to fit.
+var ErrInputTooLarge = errors.New("input too large for model")
+
+// ErrToolsNotSupported is returned by GenerateWithTools for models whose
+// provider has no native tool calling.
+var ErrToolsNotSupported = errors.New("model doesn't support tool calling")
+
+// ToolCaller is implemented by clients that can let the model call tools. The
+// tools are called until the model answers without one, at most maxSteps times.
+type ToolCaller interface {
+	GenerateWithTools(ctx context.Context, workload *pb.Workload, input string, system_prompt string, tools []Tool, maxSteps int) (string, error)
+}
+
+// ErrStructuredOutputNotSupported is returned by GenerateStructured for models
+// whose provider can't be made to follow a JSON schema.
+var ErrStructuredOutputNotSupported = errors.New("model doesn't support structured output")
+
+// StructuredGenerator is implemented by clients that can make the model answer
+// with JSON following schema, a JSON schema. The JSON returned has been
+// checked against it with ValidateJSON.
+type StructuredGenerator interface {
+	GenerateStructured(ctx context.Context, workload *pb.Workload, input string, system_prompt string, schema any) (json.RawMessage, error)
+}
+
+// ErrImagesNotSupported is returned for images sent to models whose provider
+// can't take them.
+var ErrImagesNotSupported = errors.New("model doesn't support image input")
+
+// MultimodalGenerator is implemented by clients that can send images along
+// with the prompt, for vision models. mime is the type of every image, e.g.
+// image/png; when empty it is guessed from each image's content.
+type MultimodalGenerator interface {
+	GenerateContentMultimodal(ctx context.Context, workload *pb.Workload, input string, images [][]byte, mime string, system_prompt string) (string, error)
+}
+
+// Message roles.
+const (
+	RoleUser      = "user"
+	RoleAssistant = "assistant"
+)
+
+// Message is one turn of a conversation.
+type Message struct {
+	Role    string
+	Content string
+	// Images are sent along with Content, see MultimodalGenerator.
+	Images []Image
+}
+
+// Image is an image attached to a Message.
+type Image struct {
+	Data     []byte
+	MIMEType string
+}
+
+// ModelErrors collects per-model failures from a multi-model generation.
+type ModelErrors map[string]error
+
+func (e ModelErrors) Error() string {
+	ids := make([]string, 0, len(e))
+	for id := range e {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	msgs := make([]string, 0, len(ids))
+	for _, id := range ids {
+		msgs = append(msgs, fmt.Sprintf("%s: %v", id, e[id]))
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Unwrap returns the errors of the models, so errors.Is and errors.As look
+// at all of them.
+func (e ModelErrors) Unwrap() []error {
+	ids := make([]string, 0, len(e))
+	for id := range e {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	errs := make([]error, 0, len(ids))
+	for _, id := range ids {
+		errs = append(errs, e[id])
+	}
+	return errs
 }
 
 // Agent interface for agents to implement
 type AgentInterface interface {
-	DoWork(workload *pb.Workload, genAIClient GenAIClient) error
+	DoWork(ctx context.Context, workload *pb.Workload, genAIClient GenAIClient) error
 }
@@ -0,0 +1,31 @@
+package models
+
+import "testing"
+
+func TestAgentValidate(t *testing.T) {
+	RegisterAgent("nopTestAgent", func() (AgentInterface, error) { return nopAgent{}, nil })
+	valid := func() Agent {
+		return Agent{ID: "a1", Name: "Nop", Type: "nopTestAgent"}
+	}
+	tests := []struct {
+		name    string
+		change  func(a *Agent)
+		wantErr string
+	}{
+		{"valid", func(a *Agent) {}, ""},
+		{"missing id", func(a *Agent) { a.ID = "" }, "id is required"},
+		{"missing name", func(a *Agent) { a.Name = "" }, "name is required"},
+		{"missing type", func(a *Agent) { a.Type = "" }, "type is required"},
+		{"unregistered type", func(a *Agent) { a.Type = "missingTestAgent" }, `type "missingTestAgent" is not one of`},
+		{"system prompt", func(a *Agent) { a.SystemPrompt = "suppliers of {{.Name}} on {{.Date}}" }, ""},
+		{"broken system prompt", func(a *Agent) { a.SystemPrompt = "suppliers of {{.Name" }, "invalid system_prompt"},
+		{"unknown prompt field", func(a *Agent) { a.SystemPrompt = "suppliers of {{.Company}}" }, "invalid system_prompt"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := valid()
+			tt.change(&a)
+			checkValidateErr(t, a.Validate(), tt.wantErr)
+		})
+	}
+}
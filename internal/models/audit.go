@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// AuditEntry is one model call made for a session, with what was sent and
+// what came back, for debugging prompts.
+type AuditEntry struct {
+	SessionID    string `json:"session_id"`
+	ModelID      string `json:"model_id"`
+	SystemPrompt string `json:"system_prompt"`
+	Input        string `json:"input"`
+	Output       string `json:"output"`
+	// Error is set instead of Output when the call failed.
+	Error            string    `json:"error,omitempty"`
+	PromptTokens     int64     `json:"prompt_tokens"`
+	CompletionTokens int64     `json:"completion_tokens"`
+	Timestamp        time.Time `json:"timestamp"`
+}
@@ -0,0 +1,12 @@
+package models
+
+// Chunk is a piece of a document stored with its embedding for retrieval.
+type Chunk struct {
+	ID         int64     `json:"id"`
+	Collection string    `json:"collection"`
+	Source     string    `json:"source"`
+	Text       string    `json:"text"`
+	Embedding  []float32 `json:"-"`
+	// Score is the cosine similarity to the query, set by searches.
+	Score float64 `json:"score,omitempty"`
+}
@@ -0,0 +1,35 @@
+package models
+
+import (
+	"sort"
+
+	"github.com/nieveai/d-agents/internal/textutil"
+)
+
+// responseCleaners are the strategies a model's ResponseCleaners can name.
+var responseCleaners = map[string]func(string) string{
+	"strip_fences":  textutil.StripFences,
+	"trim_preamble": textutil.TrimPreamble,
+	"first_json":    textutil.FirstJSON,
+}
+
+// ResponseCleanerNames returns the names ResponseCleaners can use, sorted.
+func ResponseCleanerNames() []string {
+	names := make([]string, 0, len(responseCleaners))
+	for name := range responseCleaners {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// CleanResponse runs the model's ResponseCleaners over text, in order.
+// Unknown names are skipped; Validate rejects them.
+func (m *Model) CleanResponse(text string) string {
+	for _, name := range m.ResponseCleaners {
+		if clean, ok := responseCleaners[name]; ok {
+			text = clean(text)
+		}
+	}
+	return text
+}
@@ -0,0 +1,51 @@
+package models
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestCleanResponse(t *testing.T) {
+	// Answers as different models give them.
+	const (
+		fenced    = "```json\n[{\"name\": \"Hub\"}]\n```"
+		chatty    = "Sure! Here are the products I found:\n\n```json\n[{\"name\": \"Hub\"}]\n```\n\nLet me know if you need more."
+		inline    = "The products are [{\"name\": \"Hub\"}], all in stock."
+		clean     = `[{"name": "Hub"}]`
+		noJSON    = "I couldn't find any products on that page."
+		twoBlocks = "```python\nprint([1])\n```\nand the result:\n```json\n[{\"name\": \"Hub\"}]\n```"
+	)
+	tests := []struct {
+		name     string
+		cleaners []string
+		text     string
+		want     string
+	}{
+		{"none", nil, chatty, chatty},
+		{"strip_fences", []string{"strip_fences"}, fenced, clean},
+		{"strip_fences with prose", []string{"strip_fences"}, chatty, clean},
+		{"trim_preamble", []string{"trim_preamble"}, chatty, fenced},
+		{"first_json", []string{"first_json"}, inline, clean},
+		{"trim_preamble then strip_fences", []string{"trim_preamble", "strip_fences"}, chatty, clean},
+		{"strip_fences then first_json", []string{"strip_fences", "first_json"}, inline, clean},
+		{"all three", []string{"trim_preamble", "strip_fences", "first_json"}, chatty, clean},
+		{"already clean", []string{"trim_preamble", "strip_fences", "first_json"}, clean, clean},
+		{"nothing to find", []string{"trim_preamble", "strip_fences", "first_json"}, noJSON, noJSON},
+		// Order matters: the first fence isn't the JSON one.
+		{"strip_fences picks the first block", []string{"strip_fences", "first_json"}, twoBlocks, "[1]"},
+		{"first_json alone", []string{"first_json"}, twoBlocks, "[1]"},
+		{"unknown names are skipped", []string{"no_such_cleaner", "strip_fences"}, fenced, clean},
+	}
+	for _, tt := range tests {
+		model := &Model{ResponseCleaners: tt.cleaners}
+		if got := model.CleanResponse(tt.text); got != tt.want {
+			t.Errorf("%s: CleanResponse(%q) = %q, want %q", tt.name, tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestResponseCleanerNames(t *testing.T) {
+	if got, want := ResponseCleanerNames(), []string{"first_json", "strip_fences", "trim_preamble"}; !slices.Equal(got, want) {
+		t.Errorf("ResponseCleanerNames() = %q, want %q", got, want)
+	}
+}
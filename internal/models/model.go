@@ -1,5 +1,16 @@
 package models
 
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// APISpecs are the API specs LLMClient knows how to talk to.
+var APISpecs = []string{"gemini", "openai", "azure", "ollama", "custom"}
+
 type Model struct {
 	ID       string `json:"id"`
 	Provider string `json:"provider"`
@@ -7,4 +18,144 @@ type Model struct {
 	ModelID  string `json:"model_id"`
 	APIURL   string `json:"api_url,omitempty"`
 	APISpec  string `json:"api_spec,omitempty"`
+	// APIVersion is the api-version of Azure OpenAI models, e.g. 2024-06-01.
+	// Their ModelID is the deployment name and APIURL the resource endpoint.
+	APIVersion string `json:"api_version,omitempty"`
+	// Prices in USD per million tokens, used to estimate session cost.
+	PromptPrice     float64 `json:"prompt_price,omitempty"`
+	CompletionPrice float64 `json:"completion_price,omitempty"`
+	// EnableWebSearch attaches the provider's search grounding tool (Gemini only).
+	EnableWebSearch bool `json:"enable_web_search,omitempty"`
+	// Generation parameters; nil means use the provider default.
+	Temperature *float64 `json:"temperature,omitempty"`
+	MaxTokens   *int     `json:"max_tokens,omitempty"`
+	TopP        *float64 `json:"top_p,omitempty"`
+	// Dimensions is the vector size requested from embedding models; zero
+	// uses the model's default.
+	Dimensions int `json:"dimensions,omitempty"`
+	// RPM caps the requests per minute sent to the model; zero is unlimited.
+	RPM int `json:"rpm,omitempty"`
+	// RequestTemplate and ResponsePath describe the API of custom models.
+	// RequestTemplate is a text/template for the JSON body POSTed to APIURL,
+	// e.g. {"model": {{json .Model}}, "message": {{json .Input}}}, and
+	// ResponsePath where the text is in the response, e.g. $.choices[0].text.
+	RequestTemplate string `json:"request_template,omitempty"`
+	ResponsePath    string `json:"response_path,omitempty"`
+	// MaxInputBytes caps the size of the prompt sent to the model. Longer
+	// input has its middle cut out; zero is unlimited.
+	MaxInputBytes int `json:"max_input_bytes,omitempty"`
+	// Alias is a short name to refer to the model by in commands instead of
+	// its ID, e.g. "fast".
+	Alias string `json:"alias,omitempty"`
+	// ResponseCleaners name the cleanups run over the model's answers, in
+	// order, before agents parse them: strip_fences, trim_preamble and
+	// first_json. They're for models that wrap their JSON in prose or
+	// markdown; other models are best left without.
+	ResponseCleaners []string `json:"response_cleaners,omitempty"`
+}
+
+// Usage holds the token counts reported by a provider for one or more calls.
+type Usage struct {
+	PromptTokens     int64
+	CompletionTokens int64
+	TotalTokens      int64
+}
+
+// Add accumulates other into u.
+func (u *Usage) Add(other Usage) {
+	u.PromptTokens += other.PromptTokens
+	u.CompletionTokens += other.CompletionTokens
+	u.TotalTokens += other.TotalTokens
+}
+
+// EstimatedCost returns the cost of the usage in USD based on the model prices.
+func (m *Model) EstimatedCost(usage Usage) float64 {
+	return (float64(usage.PromptTokens)*m.PromptPrice + float64(usage.CompletionTokens)*m.CompletionPrice) / 1e6
+}
+
+// Validate checks that the model has what LLMClient needs to use it.
+func (m *Model) Validate() error {
+	var errs []error
+	if m.ID == "" {
+		errs = append(errs, errors.New("id is required"))
+	}
+	if m.ModelID == "" {
+		errs = append(errs, errors.New("model_id is required"))
+	}
+	known := false
+	for _, spec := range APISpecs {
+		if m.APISpec == spec {
+			known = true
+		}
+	}
+	if !known {
+		errs = append(errs, fmt.Errorf("api_spec %q is not one of %s", m.APISpec, strings.Join(APISpecs, ", ")))
+	}
+	// Ollama runs locally and doesn't need a key.
+	if m.APIKey == "" && (m.APISpec == "gemini" || m.APISpec == "openai" || m.APISpec == "azure") {
+		errs = append(errs, fmt.Errorf("api_key is required for %s models", m.APISpec))
+	}
+	if m.APISpec == "azure" && (m.APIURL == "" || m.APIVersion == "") {
+		errs = append(errs, errors.New("api_url and api_version are required for azure models"))
+	}
+	if m.PromptPrice < 0 || m.CompletionPrice < 0 {
+		errs = append(errs, errors.New("prices can't be negative"))
+	}
+	if m.Temperature != nil && *m.Temperature < 0 {
+		errs = append(errs, errors.New("temperature can't be negative"))
+	}
+	if m.MaxTokens != nil && *m.MaxTokens <= 0 {
+		errs = append(errs, errors.New("max_tokens must be positive"))
+	}
+	if m.TopP != nil && (*m.TopP <= 0 || *m.TopP > 1) {
+		errs = append(errs, errors.New("top_p must be in (0, 1]"))
+	}
+	if m.Dimensions < 0 {
+		errs = append(errs, errors.New("dimensions can't be negative"))
+	}
+	if m.RPM < 0 {
+		errs = append(errs, errors.New("rpm can't be negative"))
+	}
+	if m.MaxInputBytes < 0 {
+		errs = append(errs, errors.New("max_input_bytes can't be negative"))
+	}
+	if strings.ContainsAny(m.Alias, ", \t\r\n") {
+		errs = append(errs, errors.New("alias can't contain commas or spaces"))
+	}
+	for _, name := range m.ResponseCleaners {
+		if _, ok := responseCleaners[name]; !ok {
+			errs = append(errs, fmt.Errorf("response cleaner %q is not one of %s", name, strings.Join(ResponseCleanerNames(), ", ")))
+		}
+	}
+	if m.APISpec == "custom" {
+		if m.APIURL == "" || m.RequestTemplate == "" || m.ResponsePath == "" {
+			errs = append(errs, errors.New("api_url, request_template and response_path are required for custom models"))
+		}
+		if _, err := m.ParseRequestTemplate(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("invalid model %q: %w", m.ID, err)
+	}
+	return nil
+}
+
+// requestTemplateFuncs are the functions request templates can use. json
+// quotes a value, so text from the payload can't break the request body.
+var requestTemplateFuncs = template.FuncMap{
+	"json": func(v any) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+}
+
+// ParseRequestTemplate parses the RequestTemplate of a custom model.
+func (m *Model) ParseRequestTemplate() (*template.Template, error) {
+	tmpl, err := template.New(m.ID).Funcs(requestTemplateFuncs).Option("missingkey=error").Parse(m.RequestTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("invalid request_template: %w", err)
+	}
+	return tmpl, nil
 }
@@ -0,0 +1,67 @@
+package models
+
+import (
+	"strings"
+	"testing"
+)
+
+func ptr[T any](v T) *T { return &v }
+
+func TestModelValidate(t *testing.T) {
+	valid := func() Model {
+		return Model{ID: "m1", Provider: "openai", ModelID: "gpt-4o", APISpec: "openai", APIKey: "key"}
+	}
+	tests := []struct {
+		name    string
+		change  func(m *Model)
+		wantErr string
+	}{
+		{"valid", func(m *Model) {}, ""},
+		{"missing id", func(m *Model) { m.ID = "" }, "id is required"},
+		{"missing model_id", func(m *Model) { m.ModelID = "" }, "model_id is required"},
+		{"unknown api_spec", func(m *Model) { m.APISpec = "anthropic" }, `api_spec "anthropic" is not one of`},
+		{"empty api_spec", func(m *Model) { m.APISpec = "" }, `api_spec "" is not one of`},
+		{"missing api_key", func(m *Model) { m.APIKey = "" }, "api_key is required for openai models"},
+		{"gemini needs a key", func(m *Model) { m.APISpec, m.APIKey = "gemini", "" }, "api_key is required for gemini models"},
+		{"ollama doesn't need a key", func(m *Model) { m.APISpec, m.APIKey = "ollama", "" }, ""},
+		{"negative price", func(m *Model) { m.CompletionPrice = -1 }, "prices can't be negative"},
+		{"negative temperature", func(m *Model) { m.Temperature = ptr(-0.1) }, "temperature can't be negative"},
+		{"zero temperature", func(m *Model) { m.Temperature = ptr(0.0) }, ""},
+		{"zero max_tokens", func(m *Model) { m.MaxTokens = ptr(0) }, "max_tokens must be positive"},
+		{"zero top_p", func(m *Model) { m.TopP = ptr(0.0) }, "top_p must be in (0, 1]"},
+		{"top_p above 1", func(m *Model) { m.TopP = ptr(1.5) }, "top_p must be in (0, 1]"},
+		{"top_p of 1", func(m *Model) { m.TopP = ptr(1.0) }, ""},
+		{"response cleaners", func(m *Model) { m.ResponseCleaners = []string{"trim_preamble", "strip_fences"} }, ""},
+		{"unknown response cleaner", func(m *Model) { m.ResponseCleaners = []string{"strip_fences", "strip_json"} }, `response cleaner "strip_json" is not one of first_json, strip_fences, trim_preamble`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := valid()
+			tt.change(&m)
+			checkValidateErr(t, m.Validate(), tt.wantErr)
+		})
+	}
+}
+
+func TestModelValidateReportsEveryProblem(t *testing.T) {
+	err := (&Model{APISpec: "openai"}).Validate()
+	for _, want := range []string{"id is required", "model_id is required", "api_key is required"} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("Validate() = %v, want it to mention %q", err, want)
+		}
+	}
+}
+
+// checkValidateErr fails unless err contains want, or is nil when want is "".
+func checkValidateErr(t *testing.T, err error, want string) {
+	t.Helper()
+	if want == "" {
+		if err != nil {
+			t.Errorf("Validate() = %v, want nil", err)
+		}
+		return
+	}
+	if err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("Validate() = %v, want an error containing %q", err, want)
+	}
+}
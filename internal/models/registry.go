@@ -0,0 +1,80 @@
+package models
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	pb "github.com/nieveai/d-agents/proto"
+)
+
+// AgentFactory creates a new agent instance for a workload.
+type AgentFactory func() (AgentInterface, error)
+
+// UpdateNotifier is implemented by agents that can report partial results
+// while they run.
+type UpdateNotifier interface {
+	SetOnUpdate(fn func(workload *pb.Workload))
+}
+
+// ProgressNotifier is implemented by agents that work in several steps and
+// can say how far along they are, as a percentage from 0 to 100.
+type ProgressNotifier interface {
+	SetOnProgress(fn func(id string, pct int32))
+}
+
+// SystemPromptSetter is implemented by agents whose system prompt can be
+// replaced by an Agent.SystemPrompt. prompt is the template, the agent renders
+// it for each workload with RenderSystemPrompt.
+type SystemPromptSetter interface {
+	SetSystemPrompt(prompt string)
+}
+
+var (
+	agentFactories = make(map[string]AgentFactory)
+	registryMutex  sync.RWMutex
+)
+
+// RegisterAgent makes an agent type available to workers. Registering the
+// same type again replaces the previous factory.
+func RegisterAgent(agentType string, factory AgentFactory) {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+	agentFactories[agentType] = factory
+}
+
+// NewAgent creates an agent of a registered type.
+func NewAgent(agentType string) (AgentInterface, error) {
+	registryMutex.RLock()
+	factory, ok := agentFactories[agentType]
+	registryMutex.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown agent type: %s", agentType)
+	}
+
+	agent, err := factory()
+	if err != nil {
+		return nil, fmt.Errorf("error creating %s: %w", agentType, err)
+	}
+	return agent, nil
+}
+
+// RegisteredAgentTypes returns the registered agent types in sorted order.
+func RegisteredAgentTypes() []string {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+
+	types := make([]string, 0, len(agentFactories))
+	for t := range agentFactories {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	return types
+}
+
+func isRegistered(agentType string) bool {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+	_, ok := agentFactories[agentType]
+	return ok
+}
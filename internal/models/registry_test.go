@@ -0,0 +1,39 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	pb "github.com/nieveai/d-agents/proto"
+)
+
+type nopAgent struct{}
+
+func (nopAgent) DoWork(ctx context.Context, workload *pb.Workload, client GenAIClient) error {
+	return nil
+}
+
+func TestRegistry(t *testing.T) {
+	RegisterAgent("nopTestAgent", func() (AgentInterface, error) { return nopAgent{}, nil })
+	RegisterAgent("brokenTestAgent", func() (AgentInterface, error) { return nil, errors.New("no browser") })
+
+	if _, err := NewAgent("nopTestAgent"); err != nil {
+		t.Errorf("NewAgent: %v", err)
+	}
+	if _, err := NewAgent("brokenTestAgent"); err == nil {
+		t.Error("NewAgent succeeded with a failing factory")
+	}
+	if _, err := NewAgent("missingTestAgent"); err == nil {
+		t.Error("NewAgent succeeded for an unregistered type")
+	}
+
+	types := RegisteredAgentTypes()
+	if !slices.IsSorted(types) || !slices.Contains(types, "nopTestAgent") || slices.Contains(types, "missingTestAgent") {
+		t.Errorf("RegisteredAgentTypes = %v", types)
+	}
+	if !isRegistered("nopTestAgent") || isRegistered("missingTestAgent") {
+		t.Error("isRegistered is wrong")
+	}
+}
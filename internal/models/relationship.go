@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+// Relationship is a directed, typed link between two companies, e.g. a vendor
+// of the source company.
+type Relationship struct {
+	Source    string    `json:"source"`
+	Target    string    `json:"target"`
+	Type      string    `json:"type"`
+	Timestamp time.Time `json:"timestamp"`
+}
@@ -0,0 +1,10 @@
+package models
+
+import "time"
+
+// Schedule runs a session again every Interval. NextRun is when it is due.
+type Schedule struct {
+	SessionID string        `json:"session_id"`
+	Interval  time.Duration `json:"interval"`
+	NextRun   time.Time     `json:"next_run"`
+}
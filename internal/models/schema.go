@@ -0,0 +1,127 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// ValidateJSON checks that data is JSON that follows schema. Only the parts of
+// JSON schema the agents use are checked: type, properties, required,
+// additionalProperties, items and enum.
+func ValidateJSON(data []byte, schema any) error {
+	if !json.Valid(data) {
+		return fmt.Errorf("not valid JSON")
+	}
+	schemaData, err := json.Marshal(schema)
+	if err != nil {
+		return fmt.Errorf("invalid schema: %w", err)
+	}
+	var rules map[string]any
+	if err := json.Unmarshal(schemaData, &rules); err != nil {
+		return fmt.Errorf("invalid schema: %w", err)
+	}
+	var value any
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	return validateValue("$", value, rules)
+}
+
+func validateValue(path string, value any, rules map[string]any) error {
+	if err := checkType(path, value, rules["type"]); err != nil {
+		return err
+	}
+	if enum, ok := rules["enum"].([]any); ok && !slices.ContainsFunc(enum, func(v any) bool { return v == value }) {
+		return fmt.Errorf("%s: %v is not one of the allowed values", path, value)
+	}
+
+	switch v := value.(type) {
+	case map[string]any:
+		properties, _ := rules["properties"].(map[string]any)
+		required, _ := rules["required"].([]any)
+		for _, name := range required {
+			if key, ok := name.(string); ok {
+				if _, ok := v[key]; !ok {
+					return fmt.Errorf("%s: missing required property %q", path, key)
+				}
+			}
+		}
+		for key, child := range v {
+			childRules, ok := properties[key].(map[string]any)
+			if !ok {
+				if rules["additionalProperties"] == false {
+					return fmt.Errorf("%s: unexpected property %q", path, key)
+				}
+				continue
+			}
+			if err := validateValue(path+"."+key, child, childRules); err != nil {
+				return err
+			}
+		}
+	case []any:
+		if items, ok := rules["items"].(map[string]any); ok {
+			for i, item := range v {
+				if err := validateValue(fmt.Sprintf("%s[%d]", path, i), item, items); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// checkType checks value against the type of a schema, which may be a single
+// type or a list of them.
+func checkType(path string, value any, schemaType any) error {
+	var types []string
+	switch t := schemaType.(type) {
+	case nil:
+		return nil
+	case string:
+		types = []string{t}
+	case []any:
+		for _, name := range t {
+			if s, ok := name.(string); ok {
+				types = append(types, s)
+			}
+		}
+	}
+	for _, t := range types {
+		if hasType(value, t) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s: expected %s, got %s", path, strings.Join(types, " or "), jsonType(value))
+}
+
+func hasType(value any, schemaType string) bool {
+	switch schemaType {
+	case "integer":
+		n, ok := value.(float64)
+		return ok && n == float64(int64(n))
+	case "number":
+		_, ok := value.(float64)
+		return ok
+	}
+	return jsonType(value) == schemaType
+}
+
+func jsonType(value any) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	}
+	return fmt.Sprintf("%T", value)
+}
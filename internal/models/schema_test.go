@@ -0,0 +1,59 @@
+package models
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateJSON(t *testing.T) {
+	schema := map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"items": map[string]any{
+				"type": "array",
+				"items": map[string]any{
+					"type": "object",
+					"properties": map[string]any{
+						"name":  map[string]any{"type": "string"},
+						"kind":  map[string]any{"type": "string", "enum": []string{"vendor", "customer"}},
+						"count": map[string]any{"type": "integer"},
+						"price": map[string]any{"type": []string{"number", "null"}},
+					},
+					"required":             []string{"name"},
+					"additionalProperties": false,
+				},
+			},
+		},
+		"required": []string{"items"},
+	}
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{"valid", `{"items": [{"name": "TSMC", "kind": "vendor", "count": 2, "price": 1.5}, {"name": "AMD", "price": null}]}`, ""},
+		{"empty list", `{"items": []}`, ""},
+		{"extra top-level property", `{"items": [], "note": "fine"}`, ""},
+		{"not JSON", `{"items": [`, "not valid JSON"},
+		{"answer around the JSON", `Here you go: {"items": []}`, "not valid JSON"},
+		{"wrong top-level type", `[]`, "$: expected object, got array"},
+		{"missing required", `{}`, `missing required property "items"`},
+		{"missing required in item", `{"items": [{"kind": "vendor"}]}`, `$.items[0]: missing required property "name"`},
+		{"unexpected property", `{"items": [{"name": "TSMC", "ceo": "C.C. Wei"}]}`, `unexpected property "ceo"`},
+		{"not in enum", `{"items": [{"name": "TSMC", "kind": "rival"}]}`, "$.items[0].kind: rival is not one of the allowed values"},
+		{"not an integer", `{"items": [{"name": "TSMC", "count": 1.5}]}`, "$.items[0].count: expected integer, got number"},
+		{"none of the types", `{"items": [{"name": "TSMC", "price": "cheap"}]}`, "expected number or null, got string"},
+	}
+	for _, tt := range tests {
+		err := ValidateJSON([]byte(tt.data), schema)
+		if tt.want == "" {
+			if err != nil {
+				t.Errorf("%s: ValidateJSON = %v, want it valid", tt.name, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: ValidateJSON = %v, want an error containing %q", tt.name, err, tt.want)
+		}
+	}
+}
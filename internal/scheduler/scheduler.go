@@ -0,0 +1,202 @@
+// Package scheduler runs sessions again at fixed intervals. Schedules are kept
+// in the datastore, so they outlive the process that made them and any
+// controller sharing the database can run them.
+package scheduler
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/nieveai/d-agents/internal/database"
+	"github.com/nieveai/d-agents/internal/models"
+	"github.com/nieveai/d-agents/internal/worker"
+	pb "github.com/nieveai/d-agents/proto"
+)
+
+// CheckInterval is how often Run looks for schedules that are due.
+const CheckInterval = 30 * time.Second
+
+// ErrNotCancellable is returned by Cancel for sessions that are neither
+// running nor scheduled.
+var ErrNotCancellable = errors.New("session is neither running nor scheduled")
+
+// Scheduler queues scheduled sessions when they are due.
+type Scheduler struct {
+	db    database.Datastore
+	queue *worker.Queue
+
+	// OnRun, if set, is called with every session the scheduler queued.
+	OnRun func(session *pb.Workload)
+
+	// mu keeps RunDue from saving a schedule that was removed meanwhile.
+	mu sync.Mutex
+}
+
+func New(db database.Datastore, queue *worker.Queue) *Scheduler {
+	return &Scheduler{db: db, queue: queue}
+}
+
+// Schedule runs the session every interval, the first time one interval from
+// now. It replaces any schedule the session had.
+func (s *Scheduler) Schedule(sessionID string, interval time.Duration) error {
+	if interval < time.Second {
+		return fmt.Errorf("interval must be at least a second, not %s", interval)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	schedule := &models.Schedule{SessionID: sessionID, Interval: interval, NextRun: time.Now().Add(interval)}
+	if err := s.db.AddSchedule(schedule); err != nil {
+		return fmt.Errorf("error saving schedule of session %s: %w", sessionID, err)
+	}
+	return nil
+}
+
+// Unschedule stops running the session. It reports whether the session had a
+// schedule.
+func (s *Scheduler) Unschedule(sessionID string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.db.DeleteSchedule(sessionID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("error deleting schedule of session %s: %w", sessionID, err)
+	}
+	return true, nil
+}
+
+// Cancel stops the session for good: its schedule is removed and a run in
+// progress is cancelled with worker.CancelWorkload. Every controller cancels
+// sessions through here, so a cancelled session doesn't start again at its
+// next scheduled run.
+func (s *Scheduler) Cancel(sessionID string) error {
+	scheduled, err := s.Unschedule(sessionID)
+	if err != nil {
+		return err
+	}
+	session, err := s.db.GetSession(sessionID)
+	if err != nil {
+		return fmt.Errorf("error getting session %s: %w", sessionID, err)
+	}
+	if session.Status != pb.WorkloadStatus_RUNNING {
+		if scheduled {
+			return nil
+		}
+		return fmt.Errorf("session %s has status %s: %w", sessionID, session.Status, ErrNotCancellable)
+	}
+	return worker.CancelWorkload(sessionID)
+}
+
+// Get returns the schedule of the session, or nil when it has none.
+func (s *Scheduler) Get(sessionID string) (*models.Schedule, error) {
+	schedules, err := s.db.ListSchedules()
+	if err != nil {
+		return nil, fmt.Errorf("error loading schedules: %w", err)
+	}
+	for _, schedule := range schedules {
+		if schedule.SessionID == sessionID {
+			return schedule, nil
+		}
+	}
+	return nil, nil
+}
+
+// Run calls RunDue every CheckInterval, and once straight away to catch up on
+// runs that came due while nothing was running, until ctx is done.
+func (s *Scheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(CheckInterval)
+	defer ticker.Stop()
+	for {
+		if _, err := s.RunDue(time.Now()); err != nil {
+			log.Printf("Error running scheduled sessions: %s", err)
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// RunDue queues the sessions whose schedules are due at now and moves their
+// next run on, and returns the sessions queued. A session that is still
+// running is skipped until its next run. Runs missed while no scheduler was
+// running are not made up for, the session runs once.
+func (s *Scheduler) RunDue(now time.Time) ([]*pb.Workload, error) {
+	due, err := s.takeDue(now)
+	for _, session := range due {
+		s.queue.Push(session)
+		if s.OnRun != nil {
+			s.OnRun(session)
+		}
+	}
+	return due, err
+}
+
+// takeDue marks the sessions that are due RUNNING and saves their next run.
+func (s *Scheduler) takeDue(now time.Time) ([]*pb.Workload, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	schedules, err := s.db.ListSchedules()
+	if err != nil {
+		return nil, fmt.Errorf("error loading schedules: %w", err)
+	}
+
+	var due []*pb.Workload
+	var errs []error
+	for _, schedule := range schedules {
+		if schedule.NextRun.After(now) {
+			continue
+		}
+		session, err := s.db.GetSession(schedule.SessionID)
+		if errors.Is(err, sql.ErrNoRows) {
+			log.Printf("Session %s no longer exists, removing its schedule", schedule.SessionID)
+			if err := s.db.DeleteSchedule(schedule.SessionID); err != nil {
+				errs = append(errs, fmt.Errorf("error deleting schedule of session %s: %w", schedule.SessionID, err))
+			}
+			continue
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("error loading session %s: %w", schedule.SessionID, err))
+			continue
+		}
+
+		schedule.NextRun = nextRun(schedule, now)
+		if err := s.db.AddSchedule(schedule); err != nil {
+			errs = append(errs, fmt.Errorf("error saving schedule of session %s: %w", schedule.SessionID, err))
+			continue
+		}
+		if session.Status == pb.WorkloadStatus_RUNNING {
+			log.Printf("Session %s is already running. Skipping scheduled run.", session.Id)
+			continue
+		}
+
+		session.Status = pb.WorkloadStatus_RUNNING
+		session.Error = ""
+		session.RetryCount = 0
+		session.LastHeartbeat = 0
+		session.Stage = ""
+		session.Progress = 0
+		if err := s.db.AddSession(session); err != nil {
+			errs = append(errs, fmt.Errorf("error saving session %s: %w", session.Id, err))
+			continue
+		}
+		due = append(due, session)
+	}
+	return due, errors.Join(errs...)
+}
+
+// nextRun is the first run of schedule after now, keeping to the times the
+// schedule started with.
+func nextRun(schedule *models.Schedule, now time.Time) time.Time {
+	interval := max(schedule.Interval, time.Second)
+	missed := now.Sub(schedule.NextRun)/interval + 1
+	return schedule.NextRun.Add(missed * interval)
+}
@@ -0,0 +1,185 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nieveai/d-agents/internal/database"
+	"github.com/nieveai/d-agents/internal/models"
+	"github.com/nieveai/d-agents/internal/worker"
+	pb "github.com/nieveai/d-agents/proto"
+)
+
+var start = time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+// newTestScheduler returns a scheduler over a memory datastore holding the
+// sessions, each with a schedule of every hour that is due at start.
+func newTestScheduler(t *testing.T, sessions ...*pb.Workload) (*Scheduler, *database.MemoryDatastore, *worker.Queue) {
+	t.Helper()
+	store := database.NewMemoryDatastore()
+	for _, session := range sessions {
+		if err := store.AddSession(session); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.AddSchedule(&models.Schedule{SessionID: session.Id, Interval: time.Hour, NextRun: start}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	queue := worker.NewQueue(10)
+	return New(store, queue), store, queue
+}
+
+func TestRunDue(t *testing.T) {
+	s, store, queue := newTestScheduler(t, &pb.Workload{Id: "s1", Status: pb.WorkloadStatus_COMPLETED, Error: "last time", Progress: 100, RetryCount: 2})
+	var ran []string
+	s.OnRun = func(session *pb.Workload) { ran = append(ran, session.Id) }
+
+	// Not due yet.
+	if due, err := s.RunDue(start.Add(-time.Second)); len(due) != 0 || err != nil {
+		t.Errorf("RunDue before the schedule = %v, %v", due, err)
+	}
+
+	due, err := s.RunDue(start)
+	if err != nil {
+		t.Fatalf("RunDue: %v", err)
+	}
+	if len(due) != 1 || due[0].Id != "s1" || queue.Len() != 1 || len(ran) != 1 {
+		t.Fatalf("RunDue queued %v (queue holds %d, OnRun saw %v), want s1", due, queue.Len(), ran)
+	}
+	session, _ := store.GetSession("s1")
+	if session.Status != pb.WorkloadStatus_RUNNING || session.Error != "" || session.Progress != 0 || session.RetryCount != 0 {
+		t.Errorf("session = %v, error %q, progress %d, retries %d, want a fresh RUNNING run", session.Status, session.Error, session.Progress, session.RetryCount)
+	}
+	if schedule, _ := s.Get("s1"); !schedule.NextRun.Equal(start.Add(time.Hour)) {
+		t.Errorf("next run = %s, want %s", schedule.NextRun, start.Add(time.Hour))
+	}
+
+	// Already taken, so not queued twice.
+	if due, _ := s.RunDue(start); len(due) != 0 {
+		t.Errorf("second RunDue queued %v", due)
+	}
+}
+
+func TestRunDueSkipsRunningSessions(t *testing.T) {
+	s, _, queue := newTestScheduler(t, &pb.Workload{Id: "s1", Status: pb.WorkloadStatus_RUNNING})
+	if due, err := s.RunDue(start); len(due) != 0 || err != nil || queue.Len() != 0 {
+		t.Errorf("RunDue of a running session = %v, %v", due, err)
+	}
+	// It is tried again at the next run, not straight away.
+	if schedule, _ := s.Get("s1"); !schedule.NextRun.Equal(start.Add(time.Hour)) {
+		t.Errorf("next run = %s, want %s", schedule.NextRun, start.Add(time.Hour))
+	}
+}
+
+func TestRunDueMissedRuns(t *testing.T) {
+	s, _, queue := newTestScheduler(t, &pb.Workload{Id: "s1"})
+	// Three runs were missed while nothing was running: the session runs once
+	// and keeps to its times.
+	if due, _ := s.RunDue(start.Add(3*time.Hour + 20*time.Minute)); len(due) != 1 || queue.Len() != 1 {
+		t.Errorf("RunDue queued %d sessions, want 1", queue.Len())
+	}
+	if schedule, _ := s.Get("s1"); !schedule.NextRun.Equal(start.Add(4 * time.Hour)) {
+		t.Errorf("next run = %s, want %s", schedule.NextRun, start.Add(4*time.Hour))
+	}
+}
+
+func TestRunDueRemovesScheduleOfDeletedSession(t *testing.T) {
+	s, store, _ := newTestScheduler(t, &pb.Workload{Id: "s1"})
+	store.DeleteSession("s1")
+	if due, err := s.RunDue(start); len(due) != 0 || err != nil {
+		t.Errorf("RunDue = %v, %v", due, err)
+	}
+	if schedule, _ := s.Get("s1"); schedule != nil {
+		t.Errorf("schedule %+v is left", schedule)
+	}
+}
+
+func TestScheduleAndUnschedule(t *testing.T) {
+	s, _, _ := newTestScheduler(t)
+	if err := s.Schedule("s1", time.Millisecond); err == nil {
+		t.Error("Schedule accepted an interval under a second")
+	}
+	before := time.Now()
+	if err := s.Schedule("s1", time.Hour); err != nil {
+		t.Fatalf("Schedule: %v", err)
+	}
+	schedule, err := s.Get("s1")
+	if err != nil || schedule == nil || schedule.Interval != time.Hour {
+		t.Fatalf("Get = %+v, %v", schedule, err)
+	}
+	// Stored to the second.
+	if next := schedule.NextRun; next.Before(before.Add(time.Hour).Truncate(time.Second)) || next.After(time.Now().Add(time.Hour)) {
+		t.Errorf("next run = %s, want an hour from now", next)
+	}
+
+	if ok, err := s.Unschedule("s1"); !ok || err != nil {
+		t.Errorf("Unschedule = %v, %v", ok, err)
+	}
+	if ok, err := s.Unschedule("s1"); ok || err != nil {
+		t.Errorf("Unschedule of an unscheduled session = %v, %v, want false", ok, err)
+	}
+}
+
+func TestCancel(t *testing.T) {
+	s, store, _ := newTestScheduler(t,
+		&pb.Workload{Id: "scheduled", Status: pb.WorkloadStatus_COMPLETED},
+		&pb.Workload{Id: "running", Status: pb.WorkloadStatus_RUNNING},
+	)
+	store.AddSession(&pb.Workload{Id: "done", Status: pb.WorkloadStatus_COMPLETED})
+	if err := worker.Init(context.Background(), []*models.Model{}, store); err != nil {
+		t.Fatalf("worker.Init: %v", err)
+	}
+	t.Cleanup(func() { worker.Init(context.Background(), []*models.Model{}, nil) })
+
+	tests := []struct {
+		id         string
+		wantErr    error
+		wantStatus pb.WorkloadStatus_Status
+	}{
+		{"scheduled", nil, pb.WorkloadStatus_COMPLETED},
+		// The queued run is cancelled too.
+		{"running", nil, pb.WorkloadStatus_CANCELLED},
+		{"done", ErrNotCancellable, pb.WorkloadStatus_COMPLETED},
+	}
+	for _, tt := range tests {
+		if err := s.Cancel(tt.id); !errors.Is(err, tt.wantErr) {
+			t.Errorf("Cancel(%s) = %v, want %v", tt.id, err, tt.wantErr)
+		}
+		if schedule, _ := s.Get(tt.id); schedule != nil {
+			t.Errorf("%s is still scheduled after cancelling", tt.id)
+		}
+		if session, _ := store.GetSession(tt.id); session.Status != tt.wantStatus {
+			t.Errorf("%s status = %v after cancelling, want %v", tt.id, session.Status, tt.wantStatus)
+		}
+	}
+	if err := s.Cancel("missing"); err == nil {
+		t.Error("Cancel of a missing session succeeded")
+	}
+}
+
+func TestSchedulesSurviveARestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	store, err := database.NewSQLiteDatastore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.AddSession(&pb.Workload{Id: "s1", Status: pb.WorkloadStatus_COMPLETED})
+	if err := New(store, worker.NewQueue(1)).Schedule("s1", time.Minute); err != nil {
+		t.Fatalf("Schedule: %v", err)
+	}
+	store.Close()
+
+	store, err = database.NewSQLiteDatastore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	queue := worker.NewQueue(1)
+	due, err := New(store, queue).RunDue(time.Now().Add(time.Minute))
+	if err != nil || len(due) != 1 || queue.Len() != 1 {
+		t.Errorf("RunDue after a restart = %v, %v, want s1 queued", due, err)
+	}
+}
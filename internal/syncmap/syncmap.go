@@ -0,0 +1,58 @@
+// Package syncmap has a typed map that is safe to use from several
+// goroutines, for state shared by UI, worker and polling goroutines.
+package syncmap
+
+import "sync"
+
+// Map is a map guarded by a RWMutex. The zero value is an empty map ready to
+// use. A Map must not be copied after first use.
+type Map[K comparable, V any] struct {
+	mu sync.RWMutex
+	m  map[K]V
+}
+
+func (m *Map[K, V]) Load(key K) (V, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	value, ok := m.m[key]
+	return value, ok
+}
+
+func (m *Map[K, V]) Store(key K, value V) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.m == nil {
+		m.m = make(map[K]V)
+	}
+	m.m[key] = value
+}
+
+// LoadAndDelete removes key and returns the value it had, if any.
+func (m *Map[K, V]) LoadAndDelete(key K) (V, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	value, ok := m.m[key]
+	delete(m.m, key)
+	return value, ok
+}
+
+func (m *Map[K, V]) Delete(key K) {
+	m.LoadAndDelete(key)
+}
+
+func (m *Map[K, V]) Len() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return len(m.m)
+}
+
+// Values returns a snapshot of the values, in no particular order.
+func (m *Map[K, V]) Values() []V {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	values := make([]V, 0, len(m.m))
+	for _, value := range m.m {
+		values = append(values, value)
+	}
+	return values
+}
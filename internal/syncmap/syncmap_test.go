@@ -0,0 +1,72 @@
+package syncmap
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+)
+
+func TestMap(t *testing.T) {
+	var m Map[string, int]
+	if _, ok := m.Load("a"); ok || m.Len() != 0 || len(m.Values()) != 0 {
+		t.Fatal("the zero Map isn't empty")
+	}
+	// Deleting from the zero Map is fine.
+	m.Delete("a")
+
+	m.Store("a", 1)
+	m.Store("b", 2)
+	m.Store("a", 3)
+	if v, ok := m.Load("a"); !ok || v != 3 {
+		t.Errorf("Load(a) = %d, %v, want 3", v, ok)
+	}
+	values := m.Values()
+	slices.Sort(values)
+	if !slices.Equal(values, []int{2, 3}) || m.Len() != 2 {
+		t.Errorf("Values = %v, Len = %d, want [2 3], 2", values, m.Len())
+	}
+
+	if v, ok := m.LoadAndDelete("b"); !ok || v != 2 {
+		t.Errorf("LoadAndDelete(b) = %d, %v, want 2", v, ok)
+	}
+	if _, ok := m.LoadAndDelete("b"); ok {
+		t.Error("LoadAndDelete of a deleted key found it")
+	}
+	m.Delete("a")
+	if m.Len() != 0 {
+		t.Errorf("Len = %d after deleting everything", m.Len())
+	}
+}
+
+// TestConcurrentAccess is for go test -race.
+func TestConcurrentAccess(t *testing.T) {
+	var m Map[string, int]
+	var wg sync.WaitGroup
+	for g := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range 200 {
+				key := fmt.Sprint(i % 20)
+				switch (g + i) % 5 {
+				case 0:
+					m.Store(key, i)
+				case 1:
+					m.Load(key)
+				case 2:
+					m.Delete(key)
+				case 3:
+					for range m.Values() {
+					}
+				case 4:
+					m.Len()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if n := m.Len(); n > 20 {
+		t.Errorf("Len = %d, want at most one entry per key", n)
+	}
+}
@@ -0,0 +1,240 @@
+// Package testutil has helpers for exercising agents without a real LLM.
+package testutil
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	m "github.com/nieveai/d-agents/internal/models"
+	pb "github.com/nieveai/d-agents/proto"
+)
+
+// Response is one scripted answer of a FakeGenAIClient. When Err is set it is
+// returned instead of Text.
+type Response struct {
+	Text string
+	Err  error
+}
+
+// Call records one call made to a FakeGenAIClient.
+type Call struct {
+	// Method is the name of the GenAIClient method called.
+	Method       string
+	Models       []string
+	Input        string
+	SystemPrompt string
+	// Messages is only set for GenerateChat and GenerateChatStream.
+	Messages []m.Message
+	// Images is only set for GenerateContentMultimodal.
+	Images [][]byte
+}
+
+// FakeGenAIClient is an m.GenAIClient that answers from a script and records
+// every call. Answers queued with Respond and Fail are used in order; once they
+// run out, Default is returned. RespondWith queues a func instead, to compute
+// the answer from the call. It is safe for concurrent use.
+type FakeGenAIClient struct {
+	// Default is returned when no scripted answer is left.
+	Default Response
+
+	mu       sync.Mutex
+	script   []func(Call) Response
+	perModel map[string]Response
+	calls    []Call
+}
+
+var (
+	_ m.GenAIClient         = (*FakeGenAIClient)(nil)
+	_ m.MultimodalGenerator = (*FakeGenAIClient)(nil)
+)
+
+// NewFakeGenAIClient returns a fake that answers responses, in order.
+func NewFakeGenAIClient(responses ...string) *FakeGenAIClient {
+	f := &FakeGenAIClient{}
+	for _, text := range responses {
+		f.Respond(text)
+	}
+	return f
+}
+
+// Respond queues text as the next answer.
+func (f *FakeGenAIClient) Respond(text string) *FakeGenAIClient {
+	return f.RespondWith(func(Call) Response { return Response{Text: text} })
+}
+
+// Fail queues err as the next answer.
+func (f *FakeGenAIClient) Fail(err error) *FakeGenAIClient {
+	return f.RespondWith(func(Call) Response { return Response{Err: err} })
+}
+
+// RespondWith queues fn to compute the next answer from the call.
+func (f *FakeGenAIClient) RespondWith(fn func(call Call) Response) *FakeGenAIClient {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.script = append(f.script, fn)
+	return f
+}
+
+// RespondForModel makes GenerateContentMulti answer with resp for modelID,
+// without using up the script. Models without one use the script as usual.
+func (f *FakeGenAIClient) RespondForModel(modelID string, resp Response) *FakeGenAIClient {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.perModel == nil {
+		f.perModel = make(map[string]Response)
+	}
+	f.perModel[modelID] = resp
+	return f
+}
+
+// Calls returns the calls made so far, oldest first.
+func (f *FakeGenAIClient) Calls() []Call {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]Call(nil), f.calls...)
+}
+
+// LastCall returns the latest call, and false if there was none.
+func (f *FakeGenAIClient) LastCall() (Call, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.calls) == 0 {
+		return Call{}, false
+	}
+	return f.calls[len(f.calls)-1], true
+}
+
+// Reset forgets the recorded calls and any answers still queued.
+func (f *FakeGenAIClient) Reset() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.script = nil
+	f.perModel = nil
+	f.calls = nil
+}
+
+// answer records call and returns the next scripted answer.
+func (f *FakeGenAIClient) answer(ctx context.Context, call Call) Response {
+	f.record(call)
+	return f.next(ctx, call)
+}
+
+func (f *FakeGenAIClient) record(call Call) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, call)
+}
+
+// next returns the next scripted answer, or Default once there is none left.
+func (f *FakeGenAIClient) next(ctx context.Context, call Call) Response {
+	f.mu.Lock()
+	fn := func(Call) Response { return f.Default }
+	if len(f.script) > 0 {
+		fn, f.script = f.script[0], f.script[1:]
+	}
+	f.mu.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return Response{Err: err}
+	}
+	return fn(call)
+}
+
+func newCall(method string, workload *pb.Workload, input, system_prompt string) Call {
+	return Call{Method: method, Models: append([]string(nil), workload.GetModels()...), Input: input, SystemPrompt: system_prompt}
+}
+
+func (f *FakeGenAIClient) GenerateContent(ctx context.Context, workload *pb.Workload, input string) (string, error) {
+	resp := f.answer(ctx, newCall("GenerateContent", workload, input, ""))
+	return resp.Text, resp.Err
+}
+
+func (f *FakeGenAIClient) GenerateContentWithSystemPrompt(ctx context.Context, workload *pb.Workload, input string, system_prompt string) (string, error) {
+	resp := f.answer(ctx, newCall("GenerateContentWithSystemPrompt", workload, input, system_prompt))
+	return resp.Text, resp.Err
+}
+
+// GenerateContentMulti answers for every model of the workload, using the
+// answer set with RespondForModel or else the next one of the script. Failing
+// models are reported in an m.ModelErrors, like the real client does.
+func (f *FakeGenAIClient) GenerateContentMulti(ctx context.Context, workload *pb.Workload, input string, system_prompt string) (map[string]string, error) {
+	if len(workload.GetModels()) == 0 {
+		return nil, m.ErrNoModelsSpecified
+	}
+	// The models share one call in Calls, as they share the prompt.
+	call := newCall("GenerateContentMulti", workload, input, system_prompt)
+	f.record(call)
+	results := make(map[string]string)
+	errs := make(m.ModelErrors)
+	for _, modelID := range workload.Models {
+		f.mu.Lock()
+		resp, ok := f.perModel[modelID]
+		f.mu.Unlock()
+		if !ok {
+			resp = f.next(ctx, call)
+		}
+		if resp.Err != nil {
+			errs[modelID] = resp.Err
+			continue
+		}
+		results[modelID] = resp.Text
+	}
+	if len(errs) > 0 {
+		return results, errs
+	}
+	return results, nil
+}
+
+func (f *FakeGenAIClient) GenerateContentMultimodal(ctx context.Context, workload *pb.Workload, input string, images [][]byte, mime string, system_prompt string) (string, error) {
+	call := newCall("GenerateContentMultimodal", workload, input, system_prompt)
+	call.Images = append([][]byte(nil), images...)
+	resp := f.answer(ctx, call)
+	return resp.Text, resp.Err
+}
+
+func (f *FakeGenAIClient) GenerateChat(ctx context.Context, workload *pb.Workload, messages []m.Message, system_prompt string) (string, error) {
+	resp := f.answer(ctx, chatCall("GenerateChat", workload, messages, system_prompt))
+	return resp.Text, resp.Err
+}
+
+// GenerateContentStream sends the answer word by word and closes out, like
+// the real client.
+func (f *FakeGenAIClient) GenerateContentStream(ctx context.Context, workload *pb.Workload, input string, system_prompt string, out chan<- string) error {
+	return f.stream(ctx, newCall("GenerateContentStream", workload, input, system_prompt), out)
+}
+
+func (f *FakeGenAIClient) GenerateChatStream(ctx context.Context, workload *pb.Workload, messages []m.Message, system_prompt string, out chan<- string) error {
+	return f.stream(ctx, chatCall("GenerateChatStream", workload, messages, system_prompt), out)
+}
+
+func chatCall(method string, workload *pb.Workload, messages []m.Message, system_prompt string) Call {
+	call := newCall(method, workload, "", system_prompt)
+	call.Messages = append([]m.Message(nil), messages...)
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role == m.RoleUser {
+			call.Input = messages[i].Content
+			break
+		}
+	}
+	return call
+}
+
+func (f *FakeGenAIClient) stream(ctx context.Context, call Call, out chan<- string) error {
+	defer close(out)
+	resp := f.answer(ctx, call)
+	if resp.Err != nil {
+		return resp.Err
+	}
+	for _, chunk := range strings.SplitAfter(resp.Text, " ") {
+		if chunk == "" {
+			continue
+		}
+		select {
+		case out <- chunk:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package testutil
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	m "github.com/nieveai/d-agents/internal/models"
+	pb "github.com/nieveai/d-agents/proto"
+)
+
+func TestFakeGenAIClientScript(t *testing.T) {
+	quota := errors.New("quota exceeded")
+	f := NewFakeGenAIClient("first").Fail(quota).RespondWith(func(call Call) Response {
+		return Response{Text: "echo: " + call.Input}
+	})
+	f.Default = Response{Text: "default"}
+	workload := &pb.Workload{Models: []string{"m1"}}
+	ctx := context.Background()
+
+	tests := []struct {
+		want    string
+		wantErr error
+	}{
+		{"first", nil},
+		{"", quota},
+		{"echo: third", nil},
+		{"default", nil},
+		{"default", nil},
+	}
+	inputs := []string{"one", "two", "third", "four", "five"}
+	for i, tt := range tests {
+		got, err := f.GenerateContentWithSystemPrompt(ctx, workload, inputs[i], "be brief")
+		if got != tt.want || !errors.Is(err, tt.wantErr) {
+			t.Errorf("call %d = %q, %v, want %q, %v", i+1, got, err, tt.want, tt.wantErr)
+		}
+	}
+
+	calls := f.Calls()
+	if len(calls) != 5 {
+		t.Fatalf("%d calls recorded, want 5", len(calls))
+	}
+	if c := calls[2]; c.Method != "GenerateContentWithSystemPrompt" || c.Input != "third" || c.SystemPrompt != "be brief" || c.Models[0] != "m1" {
+		t.Errorf("call 3 = %+v", c)
+	}
+	// The recorded models don't change with the workload.
+	workload.Models[0] = "changed"
+	if last, _ := f.LastCall(); last.Models[0] != "m1" || last.Input != "five" {
+		t.Errorf("LastCall = %+v", last)
+	}
+
+	f.Reset()
+	if _, ok := f.LastCall(); ok || len(f.Calls()) != 0 {
+		t.Error("Reset kept the calls")
+	}
+}
+
+func TestFakeGenAIClientCancelled(t *testing.T) {
+	f := NewFakeGenAIClient("unused")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := f.GenerateContent(ctx, &pb.Workload{}, "hi"); !errors.Is(err, context.Canceled) {
+		t.Errorf("GenerateContent with a cancelled context = %v", err)
+	}
+}
+
+func TestFakeGenAIClientChat(t *testing.T) {
+	f := NewFakeGenAIClient("streamed in words")
+	messages := []m.Message{{Role: m.RoleUser, Content: "hi"}, {Role: m.RoleAssistant, Content: "hello"}, {Role: m.RoleUser, Content: "how are you?"}}
+
+	out := make(chan string)
+	errCh := make(chan error, 1)
+	go func() { errCh <- f.GenerateChatStream(context.Background(), &pb.Workload{}, messages, "", out) }()
+	var chunks []string
+	for chunk := range out {
+		chunks = append(chunks, chunk)
+	}
+	if err := <-errCh; err != nil || len(chunks) != 3 || strings.Join(chunks, "") != "streamed in words" {
+		t.Errorf("streamed %q, %v, want the answer in 3 chunks", chunks, err)
+	}
+	call, _ := f.LastCall()
+	if call.Method != "GenerateChatStream" || call.Input != "how are you?" || len(call.Messages) != 3 {
+		t.Errorf("call = %+v, want the chat with the latest user message as input", call)
+	}
+}
+
+func TestFakeGenAIClientMulti(t *testing.T) {
+	down := errors.New("down")
+	f := NewFakeGenAIClient("from the script").RespondForModel("fast", Response{Text: "fast answer"}).RespondForModel("broken", Response{Err: down})
+
+	results, err := f.GenerateContentMulti(context.Background(), &pb.Workload{Models: []string{"fast", "slow", "broken"}}, "hi", "")
+	var errs m.ModelErrors
+	if !errors.As(err, &errs) || !errors.Is(errs["broken"], down) || len(errs) != 1 {
+		t.Errorf("GenerateContentMulti error = %v, want only broken to fail", err)
+	}
+	if results["fast"] != "fast answer" || results["slow"] != "from the script" {
+		t.Errorf("results = %v", results)
+	}
+	if len(f.Calls()) != 1 {
+		t.Errorf("%d calls recorded, want one for all models", len(f.Calls()))
+	}
+	if _, err := f.GenerateContentMulti(context.Background(), &pb.Workload{}, "hi", ""); !errors.Is(err, m.ErrNoModelsSpecified) {
+		t.Errorf("GenerateContentMulti without models = %v", err)
+	}
+}
@@ -0,0 +1,221 @@
+package textutil
+
+import (
+	"bytes"
+	"io"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// droppedElements are left out of CleanHTML along with everything in them:
+// code, styling and the site navigation around the content.
+var droppedElements = map[string]bool{
+	"script": true, "style": true, "noscript": true, "svg": true,
+	"template": true, "iframe": true, "select": true, "nav": true,
+	"footer": true,
+}
+
+// blockElements start on a new line. Paragraphs and headings also get a blank
+// line around them.
+var blockElements = map[string]bool{
+	"div": true, "section": true, "article": true, "main": true, "header": true,
+	"aside": true, "ul": true, "ol": true, "li": true, "dl": true, "dt": true,
+	"dd": true, "table": true, "tr": true, "form": true, "figure": true,
+	"figcaption": true, "blockquote": true, "pre": true, "address": true,
+	"title": true, "br": true, "hr": true,
+	"p": true, "h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+}
+
+// CleanHTML turns a scraped page into markdown for a model to read. Only the
+// text is kept, with links as [text](href), headings, list items and table
+// cells marked; markup, scripts, styles and the page's nav and footer are
+// dropped. Prices and product names, being text, come through as they are.
+func CleanHTML(s string) string {
+	w := &markdownWriter{}
+	z := html.NewTokenizer(strings.NewReader(s))
+	// skip is the element being dropped and depth how deep in it z is.
+	skip, depth := "", 0
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			if z.Err() != io.EOF {
+				// Not HTML the tokenizer can make sense of.
+				return strings.Join(strings.Fields(s), " ")
+			}
+			return w.String()
+		}
+		token := z.Token()
+
+		if skip != "" {
+			switch {
+			case tt == html.StartTagToken && token.Data == skip:
+				depth++
+			case tt == html.EndTagToken && token.Data == skip:
+				depth--
+				if depth == 0 {
+					skip = ""
+				}
+			}
+			continue
+		}
+
+		switch tt {
+		case html.StartTagToken, html.SelfClosingTagToken:
+			if droppedElements[token.Data] {
+				if tt == html.StartTagToken {
+					skip, depth = token.Data, 1
+				}
+				continue
+			}
+			w.start(token, tt == html.SelfClosingTagToken)
+		case html.EndTagToken:
+			w.end(token.Data)
+		case html.TextToken:
+			w.text(token.Data)
+		}
+		// Comments and doctypes are dropped.
+	}
+}
+
+// markdownWriter builds the markdown of CleanHTML.
+type markdownWriter struct {
+	buf bytes.Buffer
+	// links holds, for every <a> open, the href and where its text starts.
+	links []link
+}
+
+type link struct {
+	href  string
+	start int
+}
+
+func (w *markdownWriter) start(token html.Token, selfClosing bool) {
+	tag := token.Data
+	if blockElements[tag] {
+		w.newline(blankLineAround(tag))
+	}
+	switch tag {
+	case "h1", "h2", "h3", "h4", "h5", "h6":
+		w.buf.WriteString(strings.Repeat("#", int(tag[1]-'0')) + " ")
+	case "li":
+		w.buf.WriteString("- ")
+	case "td", "th":
+		if !w.atLineStart() {
+			w.buf.WriteString(" | ")
+		}
+	case "img":
+		w.text(attr(token, "alt"))
+	case "a":
+		if selfClosing {
+			return
+		}
+		href := attr(token, "href")
+		if href == "" || strings.HasPrefix(href, "#") || strings.HasPrefix(href, "javascript:") {
+			href = ""
+		}
+		w.links = append(w.links, link{href: href, start: w.buf.Len()})
+	}
+}
+
+func (w *markdownWriter) end(tag string) {
+	if tag == "a" && len(w.links) > 0 {
+		l := w.links[len(w.links)-1]
+		w.links = w.links[:len(w.links)-1]
+		// Product cards often wrap several blocks in one link.
+		text := strings.Join(strings.Fields(w.buf.String()[l.start:]), " ")
+		if l.href == "" || text == "" {
+			return
+		}
+		w.buf.Truncate(l.start)
+		w.space()
+		w.buf.WriteString("[" + text + "](" + l.href + ") ")
+		return
+	}
+	if blockElements[tag] {
+		w.newline(blankLineAround(tag))
+	}
+}
+
+// text writes s with its whitespace collapsed, keeping it apart from the
+// words before it when s starts with a space.
+func (w *markdownWriter) text(s string) {
+	collapsed := strings.Join(strings.Fields(s), " ")
+	if collapsed == "" {
+		if s != "" {
+			w.space()
+		}
+		return
+	}
+	if strings.TrimLeft(s, " \t\r\n") != s {
+		w.space()
+	}
+	w.buf.WriteString(collapsed)
+	if strings.TrimRight(s, " \t\r\n") != s {
+		w.buf.WriteByte(' ')
+	}
+}
+
+// space separates the next words from the text before them.
+func (w *markdownWriter) space() {
+	b := w.buf.Bytes()
+	if len(b) > 0 && !strings.ContainsRune(" \n[", rune(b[len(b)-1])) {
+		w.buf.WriteByte(' ')
+	}
+}
+
+func (w *markdownWriter) atLineStart() bool {
+	b := bytes.TrimRight(w.buf.Bytes(), " ")
+	return len(b) == 0 || b[len(b)-1] == '\n'
+}
+
+// newline ends the current line, with a blank line after it if blank is set,
+// unless the text already ends that way.
+func (w *markdownWriter) newline(blank bool) {
+	b := bytes.TrimRight(w.buf.Bytes(), " ")
+	w.buf.Truncate(len(b))
+	if len(b) == 0 {
+		return
+	}
+	want := "\n"
+	if blank {
+		want = "\n\n"
+	}
+	for !bytes.HasSuffix(w.buf.Bytes(), []byte(want)) {
+		w.buf.WriteByte('\n')
+	}
+}
+
+// String returns the markdown with lines trimmed and runs of blank lines
+// squeezed into one.
+func (w *markdownWriter) String() string {
+	var lines []string
+	blank := false
+	for _, line := range strings.Split(w.buf.String(), "\n") {
+		line = strings.TrimSpace(line)
+		// Markers of empty list items and headings are left out too.
+		if strings.Trim(line, "#- ") == "" {
+			blank = len(lines) > 0
+			continue
+		}
+		if blank {
+			lines = append(lines, "")
+			blank = false
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func blankLineAround(tag string) bool {
+	return tag == "p" || (len(tag) == 2 && tag[0] == 'h' && tag[1] >= '1' && tag[1] <= '6')
+}
+
+func attr(token html.Token, key string) string {
+	for _, a := range token.Attr {
+		if a.Key == key {
+			return strings.TrimSpace(a.Val)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,109 @@
+package textutil
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCleanHTML(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+	}{
+		{
+			"attributes and scripts",
+			`<html><head><title>USB-C Hub</title><style>.p{color:red}</style><script>track()</script></head>` +
+				`<body><div class="product" data-id="42" style="margin:0"><span   class="name">USB-C   Hub</span>` +
+				` <span class="price">$24.99</span></div></body></html>`,
+			"USB-C Hub\nUSB-C Hub $24.99",
+		},
+		{
+			"nav and footer",
+			`<nav><a href="/">Home</a><a href="/deals">Deals</a></nav><main><h1>Hub</h1><p>In stock</p></main><footer>© Shop</footer>`,
+			"# Hub\n\nIn stock",
+		},
+		{
+			"links and lists",
+			`<ul><li><a href="https://shop.test/hub">Hub</a> $24.99</li><li>Cable</li></ul>`,
+			"- [Hub](https://shop.test/hub) $24.99\n- Cable",
+		},
+		{"plain text", "  just\n\n  some   text ", "just some text"},
+	}
+	for _, tt := range tests {
+		if got := CleanHTML(tt.in); got != tt.want {
+			t.Errorf("%s: CleanHTML = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCleanHTMLShrinksScrapedPages(t *testing.T) {
+	row := `<tr class="row" data-sku="A1" onclick="select(this)"><td class="cell name">Hub</td><td class="cell price">$24.99</td></tr>`
+	page := "<table>" + strings.Repeat(row, 100) + "</table><script>" + strings.Repeat("x", 10000) + "</script>"
+	got := CleanHTML(page)
+	if len(got) > len(page)/5 {
+		t.Errorf("CleanHTML kept %d of %d bytes", len(got), len(page))
+	}
+	if strings.Count(got, "$24.99") != 100 {
+		t.Errorf("CleanHTML lost prices: %d of 100 kept", strings.Count(got, "$24.99"))
+	}
+}
+
+// productPage is a product page as a shop serves it.
+const productPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <title>Anker USB-C Hub 7-in-1 | Shop</title>
+  <link rel="stylesheet" href="/static/app.css">
+  <script async src="https://analytics.test/tag.js"></script>
+</head>
+<body class="product-page" data-theme="light">
+  <!-- header -->
+  <nav class="top-nav"><a href="/">Home</a> <a href="/electronics">Electronics</a> <a href="/cart">Cart (0)</a></nav>
+  <div id="root" class="container mx-auto px-4">
+    <div class="breadcrumbs"><svg viewBox="0 0 16 16"><path d="M0 0h16v16H0z"/></svg></div>
+    <main class="product" itemscope itemtype="https://schema.org/Product">
+      <h1 class="product-title" itemprop="name">Anker USB-C Hub 7-in-1</h1>
+      <div class="price-box">
+        <span class="price price--sale" itemprop="price" content="24.99">$24.99</span>
+        <span class="price price--was"><s>$39.99</s></span>
+      </div>
+      <p class="availability" style="color: green">In stock. Ships in 1-2 days.</p>
+      <ul class="features">
+        <li>4K HDMI</li>
+        <li>100W Power Delivery</li>
+      </ul>
+      <form action="/cart/add" method="post">
+        <select name="qty"><option>1</option><option>2</option></select>
+        <button type="submit" class="btn btn-primary">Add to cart</button>
+      </form>
+      <p>Sold by <a href="https://shop.test/sellers/anker" class="seller-link">Anker Official</a></p>
+    </main>
+  </div>
+  <noscript><img src="https://analytics.test/pixel.gif"></noscript>
+  <footer class="site-footer"><p>© 2024 Shop. All rights reserved.</p><a href="/privacy">Privacy</a></footer>
+  <script>window.__STATE__ = {"cart": [], "user": null};</script>
+</body>
+</html>`
+
+func TestCleanHTMLProductPage(t *testing.T) {
+	want := `Anker USB-C Hub 7-in-1 | Shop
+
+# Anker USB-C Hub 7-in-1
+
+$24.99 $39.99
+
+In stock. Ships in 1-2 days.
+
+- 4K HDMI
+- 100W Power Delivery
+Add to cart
+
+Sold by [Anker Official](https://shop.test/sellers/anker)`
+	got := CleanHTML(productPage)
+	if got != want {
+		t.Errorf("CleanHTML of the product page =\n%s\n\nwant\n%s", got, want)
+	}
+	if len(got)*5 > len(productPage) {
+		t.Errorf("CleanHTML kept %d of %d bytes", len(got), len(productPage))
+	}
+}
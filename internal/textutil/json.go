@@ -0,0 +1,128 @@
+package textutil
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+)
+
+// fencePattern matches markdown code blocks such as ```json ... ```.
+var fencePattern = regexp.MustCompile("(?s)```[A-Za-z]*[ \t]*\n(.*?)```")
+
+// FencedBlocks returns the contents of the markdown code blocks in s, in
+// order.
+func FencedBlocks(s string) []string {
+	var blocks []string
+	for _, match := range fencePattern.FindAllStringSubmatch(s, -1) {
+		blocks = append(blocks, match[1])
+	}
+	return blocks
+}
+
+// StripFences returns the contents of the first code block in s. An answer
+// cut off before its closing fence loses just the opening one. Anything else
+// is returned as it is.
+func StripFences(s string) string {
+	if blocks := FencedBlocks(s); len(blocks) > 0 {
+		return strings.TrimSpace(blocks[0])
+	}
+	trimmed := strings.TrimSpace(s)
+	if strings.HasPrefix(trimmed, "```") {
+		_, rest, _ := strings.Cut(trimmed, "\n")
+		return strings.TrimSpace(rest)
+	}
+	return s
+}
+
+// TrimPreamble drops the prose models put around an answer, like "Here is
+// the JSON:" and "Let me know if...": the lines before the first that starts
+// with a bracket or code fence, and the lines after the last that ends with
+// one. Without such lines s is returned as it is.
+func TrimPreamble(s string) string {
+	lines := strings.Split(s, "\n")
+	first, last := -1, -1
+	for i, line := range lines {
+		line = strings.TrimSpace(line)
+		if first < 0 && (strings.HasPrefix(line, "{") || strings.HasPrefix(line, "[") || strings.HasPrefix(line, "```")) {
+			first = i
+		}
+		if strings.HasSuffix(line, "}") || strings.HasSuffix(line, "]") || strings.HasSuffix(line, "```") {
+			last = i
+		}
+	}
+	if first < 0 || last < first {
+		return s
+	}
+	return strings.Join(lines[first:last+1], "\n")
+}
+
+// FirstJSON returns the first valid JSON object or array in s, or s as it is
+// when there is none.
+func FirstJSON(s string) string {
+	for start := strings.IndexAny(s, "[{"); start >= 0; {
+		if end := matchBracket(s, start); end > 0 && json.Valid([]byte(s[start:end])) {
+			return s[start:end]
+		}
+		next := strings.IndexAny(s[start+1:], "[{")
+		if next < 0 {
+			break
+		}
+		start += next + 1
+	}
+	return s
+}
+
+// ScanJSON tries every open bracket in s in turn and returns the first one
+// that closes into valid JSON, or "" when none does. open is '[' or '{'.
+func ScanJSON(s string, open byte) string {
+	for start := strings.IndexByte(s, open); start >= 0; {
+		if end := matchBracket(s, start); end > 0 && json.Valid([]byte(s[start:end])) {
+			return s[start:end]
+		}
+		next := strings.IndexByte(s[start+1:], open)
+		if next < 0 {
+			break
+		}
+		start += next + 1
+	}
+	return ""
+}
+
+// matchBracket returns the index just past the bracket closing the one at
+// start, skipping brackets inside JSON strings. It returns -1 if the brackets
+// don't balance.
+func matchBracket(s string, start int) int {
+	var stack []byte
+	inString, escaped := false, false
+	for i := start; i < len(s); i++ {
+		c := s[i]
+		if inString {
+			switch {
+			case escaped:
+				escaped = false
+			case c == '\\':
+				escaped = true
+			case c == '"':
+				inString = false
+			}
+			continue
+		}
+		switch c {
+		case '"':
+			inString = true
+		case '[':
+			stack = append(stack, ']')
+		case '{':
+			stack = append(stack, '}')
+		case ']', '}':
+			if len(stack) == 0 || stack[len(stack)-1] != c {
+				return -1
+			}
+			stack = stack[:len(stack)-1]
+			if len(stack) == 0 {
+				return i + 1
+			}
+		}
+	}
+	return -1
+}
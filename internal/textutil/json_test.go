@@ -0,0 +1,83 @@
+package textutil
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestStripFences(t *testing.T) {
+	tests := []struct {
+		name, s, want string
+	}{
+		{"json fence", "```json\n[1, 2]\n```", "[1, 2]"},
+		{"bare fence", "```\n{\"a\": 1}\n```", `{"a": 1}`},
+		{"prose around it", "Here you go:\n```json\n[1]\n```\nAnything else?", "[1]"},
+		{"first of two", "```json\n[1]\n```\nor\n```json\n[2]\n```", "[1]"},
+		{"cut off", "```json\n[1, 2, 3", "[1, 2, 3"},
+		{"no fence", "Sure! [1, 2]", "Sure! [1, 2]"},
+		{"empty", "", ""},
+	}
+	for _, tt := range tests {
+		if got := StripFences(tt.s); got != tt.want {
+			t.Errorf("%s: StripFences(%q) = %q, want %q", tt.name, tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestTrimPreamble(t *testing.T) {
+	tests := []struct {
+		name, s, want string
+	}{
+		{"preamble", "Here is the JSON you asked for:\n[\n  {\"a\": 1}\n]", "[\n  {\"a\": 1}\n]"},
+		{"trailing note", "{\"a\": 1}\nLet me know if you need anything else.", `{"a": 1}`},
+		{"both", "Sure.\n\n```json\n[1]\n```\n\nHope this helps!", "```json\n[1]\n```"},
+		{"indented", "Result:\n  [1, 2]  \nDone.", "  [1, 2]  "},
+		{"no brackets", "I couldn't find any products.", "I couldn't find any products."},
+		{"single line", `The list is [1, 2].`, `The list is [1, 2].`},
+	}
+	for _, tt := range tests {
+		if got := TrimPreamble(tt.s); got != tt.want {
+			t.Errorf("%s: TrimPreamble(%q) = %q, want %q", tt.name, tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestFirstJSON(t *testing.T) {
+	tests := []struct {
+		name, s, want string
+	}{
+		{"inline", `The list is [1, 2]. Thanks!`, "[1, 2]"},
+		{"object", `Answer: {"label": "positive", "score": 0.6} (confident)`, `{"label": "positive", "score": 0.6}`},
+		{"brackets in prose first", `[note] see below: [{"name": "a"}]`, `[{"name": "a"}]`},
+		{"brackets in strings", `{"name": "a ] b", "tags": ["x}"]} trailing`, `{"name": "a ] b", "tags": ["x}"]}`},
+		// The array isn't valid, but the first object in it is.
+		{"cut off", `[{"name": "a"}, {"name": "b"`, `{"name": "a"}`},
+		{"cut off object", `{"name": "a", "price": 1`, `{"name": "a", "price": 1`},
+		{"none", "no JSON here", "no JSON here"},
+	}
+	for _, tt := range tests {
+		if got := FirstJSON(tt.s); got != tt.want {
+			t.Errorf("%s: FirstJSON(%q) = %q, want %q", tt.name, tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestScanJSON(t *testing.T) {
+	s := `{"note": "x"} and the list [oops] [1, 2]`
+	if got := ScanJSON(s, '['); got != "[1, 2]" {
+		t.Errorf("ScanJSON(%q, '[') = %q, want [1, 2]", s, got)
+	}
+	if got := ScanJSON(s, '{'); got != `{"note": "x"}` {
+		t.Errorf("ScanJSON(%q, '{') = %q", s, got)
+	}
+	if got := ScanJSON("[1, 2", '['); got != "" {
+		t.Errorf("ScanJSON of unbalanced brackets = %q, want none", got)
+	}
+}
+
+func TestFencedBlocks(t *testing.T) {
+	s := "a\n```json\n[1]\n```\nb\n```python\nprint()\n```\n```\nunclosed"
+	if got, want := FencedBlocks(s), []string{"[1]\n", "print()\n"}; !slices.Equal(got, want) {
+		t.Errorf("FencedBlocks = %q, want %q", got, want)
+	}
+}
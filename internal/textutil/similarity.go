@@ -0,0 +1,85 @@
+package textutil
+
+import (
+	"hash/fnv"
+	"strings"
+	"unicode"
+)
+
+// shingleSize is the number of words in a shingle. Pairs of words still
+// match text where a word was changed here and there, which longer shingles
+// don't.
+const shingleSize = 2
+
+// Fingerprint summarizes a text for Similarity, so a text compared to many
+// others is only normalized once.
+type Fingerprint struct {
+	hash     uint64
+	shingles map[string]struct{}
+}
+
+// NewFingerprint normalizes s, ignoring case, punctuation and spacing, and
+// splits it into overlapping runs of words.
+func NewFingerprint(s string) Fingerprint {
+	words := strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	normalized := strings.Join(words, " ")
+	h := fnv.New64a()
+	h.Write([]byte(normalized))
+
+	f := Fingerprint{hash: h.Sum64(), shingles: make(map[string]struct{})}
+	if len(words) < shingleSize {
+		if normalized != "" {
+			f.shingles[normalized] = struct{}{}
+		}
+		return f
+	}
+	for i := 0; i+shingleSize <= len(words); i++ {
+		f.shingles[strings.Join(words[i:i+shingleSize], " ")] = struct{}{}
+	}
+	return f
+}
+
+// Empty reports whether the text had no words.
+func (f Fingerprint) Empty() bool {
+	return len(f.shingles) == 0
+}
+
+// Similarity returns the Jaccard similarity of the shingles of f and o, from
+// 0 for nothing in common to 1 for the same text once normalized. Empty texts
+// are similar to nothing.
+func (f Fingerprint) Similarity(o Fingerprint) float64 {
+	if f.Empty() || o.Empty() {
+		return 0
+	}
+	if f.hash == o.hash {
+		return 1
+	}
+	small, large := f.shingles, o.shingles
+	if len(small) > len(large) {
+		small, large = large, small
+	}
+	shared := 0
+	for shingle := range small {
+		if _, ok := large[shingle]; ok {
+			shared++
+		}
+	}
+	return float64(shared) / float64(len(small)+len(large)-shared)
+}
+
+// MaxSimilarity is an upper bound of f.Similarity(o) that only looks at the
+// number of shingles, to skip texts of very different lengths cheaply.
+func (f Fingerprint) MaxSimilarity(o Fingerprint) float64 {
+	a, b := len(f.shingles), len(o.shingles)
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return float64(min(a, b)) / float64(max(a, b))
+}
+
+// Similarity returns how similar texts a and b are, see Fingerprint.Similarity.
+func Similarity(a, b string) float64 {
+	return NewFingerprint(a).Similarity(NewFingerprint(b))
+}
@@ -0,0 +1,49 @@
+package textutil
+
+import "testing"
+
+func TestSimilarity(t *testing.T) {
+	tests := []struct {
+		name     string
+		a, b     string
+		min, max float64
+	}{
+		{"same text", "Find cheap flights to Paris", "Find cheap flights to Paris", 1, 1},
+		{"case, punctuation and spacing", "Find cheap flights to Paris!", "  find CHEAP flights,\nto paris", 1, 1},
+		{"another month", "Find the cheapest flights from London to New York in March.", "find the cheapest flights from London to New York in April", 0.8, 0.9},
+		{"another shop", "Compare prices of the Sony WH-1000XM5 headphones at Amazon, Best Buy and Walmart.", "Compare the prices of Sony WH-1000XM5 headphones at Amazon, Best Buy, and Target.", 0.5, 0.6},
+		{"longer", "Summarize the relationships between Apple and its suppliers in Taiwan.", "Summarize the relationships between Apple and its main suppliers in Taiwan and China.", 0.6, 0.7},
+		{"unrelated", "Find the cheapest flights from London to New York in March.", "Translate this recipe for apple pie into French.", 0, 0},
+		{"unrelated, some words shared", "Compare prices of headphones at Amazon.", "Summarize the relationships between Amazon and its suppliers.", 0, 0.1},
+		{"same words, other order", "new york to london", "london to new york", 0.1, 0.3},
+		{"one word", "hello", "Hello.", 1, 1},
+		{"empty", "", "", 0, 0},
+		{"only punctuation", "...", "!!!", 0, 0},
+	}
+	for _, tt := range tests {
+		got := Similarity(tt.a, tt.b)
+		if got < tt.min || got > tt.max {
+			t.Errorf("%s: Similarity(%q, %q) = %.2f, want %.2f to %.2f", tt.name, tt.a, tt.b, got, tt.min, tt.max)
+		}
+		if back := Similarity(tt.b, tt.a); back != got {
+			t.Errorf("%s: Similarity isn't symmetric: %.2f and %.2f", tt.name, got, back)
+		}
+	}
+}
+
+func TestMaxSimilarity(t *testing.T) {
+	pairs := [][2]string{
+		{"a short one", "a much longer text that shares a short one with the other"},
+		{"the cheapest flights to New York", "cheapest flights to New York in March"},
+		{"", "anything"},
+	}
+	for _, p := range pairs {
+		a, b := NewFingerprint(p[0]), NewFingerprint(p[1])
+		if a.MaxSimilarity(b) < a.Similarity(b) {
+			t.Errorf("MaxSimilarity(%q, %q) = %.2f is below the similarity %.2f", p[0], p[1], a.MaxSimilarity(b), a.Similarity(b))
+		}
+	}
+	if !NewFingerprint(" - ").Empty() || NewFingerprint("a").Empty() {
+		t.Error("Empty is wrong")
+	}
+}
@@ -0,0 +1,77 @@
+// Package textutil has helpers for showing stored text, such as payloads, in
+// lists and tables, for fitting it into a model's input, for digging the JSON
+// out of a model's answer, and for telling when two of them say the same
+// thing.
+package textutil
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Ellipsis is appended to truncated text.
+const Ellipsis = "..."
+
+// TruncateRunes returns s cut to at most n runes, with Ellipsis appended when
+// anything was cut. Unlike slicing bytes it never splits a multi-byte character.
+func TruncateRunes(s string, n int) string {
+	if n < 0 {
+		n = 0
+	}
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	i := 0
+	for j := range s {
+		if i == n {
+			return s[:j] + Ellipsis
+		}
+		i++
+	}
+	return s
+}
+
+// Preview squeezes s onto one line and truncates it to n runes. Without line
+// breaks a cut payload can't leave a markdown block such as a code fence or
+// list open in whatever it is shown in.
+func Preview(s string, n int) string {
+	return TruncateRunes(strings.Join(strings.Fields(s), " "), n)
+}
+
+// TruncateMiddle returns s cut to at most n bytes by dropping its middle, as
+// the start and end of a long text, like the head and the last lines of a
+// page or log, tend to matter most. A note saying how much was cut replaces
+// the middle. Multi-byte characters are never split.
+func TruncateMiddle(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	if n <= 0 {
+		return ""
+	}
+	note := func(cut int) string { return fmt.Sprintf("\n[... %d bytes cut ...]\n", cut) }
+	// The note is sized for the longest number it can hold.
+	keep := n - len(note(len(s)))
+	if keep <= 0 {
+		return truncateBytes(s, n)
+	}
+	// Two thirds of what is kept come from the start.
+	head := truncateBytes(s, keep*2/3)
+	tail := s[len(s)-(keep-len(head)):]
+	for len(tail) > 0 && !utf8.RuneStart(tail[0]) {
+		tail = tail[1:]
+	}
+	return head + note(len(s)-len(head)-len(tail)) + tail
+}
+
+// truncateBytes cuts s to at most n bytes without splitting a character.
+func truncateBytes(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
@@ -0,0 +1,91 @@
+package textutil
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestTruncateRunes(t *testing.T) {
+	tests := []struct {
+		s    string
+		n    int
+		want string
+	}{
+		{"hello", 10, "hello"},
+		{"hello", 5, "hello"},
+		{"hello world", 5, "hello..."},
+		{"", 3, ""},
+		{"hello", 0, "..."},
+		{"hello", -1, "..."},
+		// Each of these is several bytes, so slicing bytes would split them.
+		{"😀😃😄😁", 2, "😀😃..."},
+		{"👍🏽 ok", 1, "👍..."},
+		{"日本語のテキスト", 3, "日本語..."},
+		{"価格: ¥1,200", 4, "価格: ..."},
+		{"中文", 2, "中文"},
+	}
+	for _, tt := range tests {
+		got := TruncateRunes(tt.s, tt.n)
+		if got != tt.want {
+			t.Errorf("TruncateRunes(%q, %d) = %q, want %q", tt.s, tt.n, got, tt.want)
+		}
+		if !utf8.ValidString(got) {
+			t.Errorf("TruncateRunes(%q, %d) = %q is not valid UTF-8", tt.s, tt.n, got)
+		}
+	}
+}
+
+func TestPreview(t *testing.T) {
+	tests := []struct {
+		s    string
+		n    int
+		want string
+	}{
+		{"one\ntwo  three", 100, "one two three"},
+		{"```go\nfmt.Println()\n```", 100, "```go fmt.Println() ```"},
+		{"- 項目一\n- 項目二", 5, "- 項目一..."},
+		{"  \n\t ", 10, ""},
+	}
+	for _, tt := range tests {
+		if got := Preview(tt.s, tt.n); got != tt.want {
+			t.Errorf("Preview(%q, %d) = %q, want %q", tt.s, tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestTruncateMiddle(t *testing.T) {
+	if got := TruncateMiddle("short", 10); got != "short" {
+		t.Errorf("TruncateMiddle of a short text = %q, want it unchanged", got)
+	}
+	if got := TruncateMiddle("anything", 0); got != "" {
+		t.Errorf("TruncateMiddle to 0 bytes = %q, want nothing", got)
+	}
+
+	for _, s := range []string{
+		strings.Repeat("a", 500) + strings.Repeat("z", 500),
+		strings.Repeat("日本語", 200),
+		strings.Repeat("😀", 300),
+	} {
+		got := TruncateMiddle(s, 200)
+		if len(got) > 200 {
+			t.Errorf("TruncateMiddle(%.10q..., 200) is %d bytes", s, len(got))
+		}
+		if !utf8.ValidString(got) {
+			t.Errorf("TruncateMiddle(%.10q..., 200) = %q is not valid UTF-8", s, got)
+		}
+		if !strings.Contains(got, "bytes cut") {
+			t.Errorf("TruncateMiddle(%.10q..., 200) = %q, want a note of the cut", s, got)
+		}
+		first, _ := utf8.DecodeRuneInString(s)
+		last, _ := utf8.DecodeLastRuneInString(s)
+		if !strings.HasPrefix(got, string(first)) || !strings.HasSuffix(got, string(last)) {
+			t.Errorf("TruncateMiddle(%.10q..., 200) = %q, want the start and end kept", s, got)
+		}
+	}
+
+	// Too small for the note: just the start is kept.
+	if got := TruncateMiddle("日本語のテキスト", 7); got != "日本" {
+		t.Errorf("TruncateMiddle to 7 bytes = %q, want %q", got, "日本")
+	}
+}
@@ -0,0 +1,108 @@
+package worker
+
+import (
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+	"time"
+
+	m "github.com/nieveai/d-agents/internal/models"
+	pb "github.com/nieveai/d-agents/proto"
+)
+
+// AuditLog stores the model calls made for sessions. database.Datastore is
+// one.
+type AuditLog interface {
+	AddAuditEntry(entry *m.AuditEntry) error
+}
+
+// redacted replaces secrets and, with WithAuditLog's redactInput, the input
+// in audit entries.
+const redacted = "[REDACTED]"
+
+// secretPatterns match API keys that look like one, whether or not they are
+// the key of a configured model.
+var secretPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`\bsk-[A-Za-z0-9_-]{16,}`),
+	regexp.MustCompile(`\bAIza[0-9A-Za-z_-]{30,}`),
+	regexp.MustCompile(`(?i)\b(bearer\s+)[A-Za-z0-9._~+/=-]{16,}`),
+	regexp.MustCompile(`(?i)\b((?:api[_-]?key|x-api-key|access[_-]?token)["']?\s*[:=]\s*["']?)[^\s"',}]+`),
+}
+
+// WithAuditLog records every model call made for a session in log, with the
+// API keys of the configured models and anything that looks like a key
+// masked. With redactInput the input is left out as well, for sessions with
+// sensitive data.
+func WithAuditLog(log AuditLog, redactInput bool) LLMClientOption {
+	return func(llm *LLMClient) {
+		llm.auditLog = log
+		llm.redactInput = redactInput
+	}
+}
+
+// audit records a call to modelID made for workload. Calls outside a session,
+// like model tests, aren't recorded. A failing audit log doesn't fail the
+// call.
+func (llm *LLMClient) audit(workload *pb.Workload, modelID string, messages []m.Message, system_prompt, output string, usage m.Usage, err error) {
+	if llm.auditLog == nil || workload.GetId() == "" {
+		return
+	}
+	entry := &m.AuditEntry{
+		SessionID:        workload.Id,
+		ModelID:          modelID,
+		SystemPrompt:     llm.redactSecrets(system_prompt),
+		Input:            redacted,
+		Output:           llm.redactSecrets(output),
+		PromptTokens:     usage.PromptTokens,
+		CompletionTokens: usage.CompletionTokens,
+		Timestamp:        time.Now(),
+	}
+	if !llm.redactInput {
+		entry.Input = llm.redactSecrets(auditInput(messages))
+	}
+	if err != nil {
+		entry.Error = llm.redactSecrets(err.Error())
+	}
+	if err := llm.auditLog.AddAuditEntry(entry); err != nil {
+		slog.Warn("error writing audit entry", "session_id", workload.Id, "model_id", modelID, "error", err)
+	}
+}
+
+// auditInput writes messages as text. A single prompt is written as it is,
+// conversations with the role of each message.
+func auditInput(messages []m.Message) string {
+	if len(messages) == 1 && messages[0].Role == m.RoleUser && len(messages[0].Images) == 0 {
+		return messages[0].Content
+	}
+	var builder strings.Builder
+	for i, msg := range messages {
+		if i > 0 {
+			builder.WriteString("\n\n")
+		}
+		fmt.Fprintf(&builder, "[%s]\n%s", msg.Role, msg.Content)
+		if len(msg.Images) > 0 {
+			fmt.Fprintf(&builder, "\n(%d images)", len(msg.Images))
+		}
+	}
+	return builder.String()
+}
+
+// redactSecrets masks the API keys of the configured models in s, and
+// anything else that looks like a key.
+func (llm *LLMClient) redactSecrets(s string) string {
+	if s == "" {
+		return s
+	}
+	for _, model := range llm.modelInfo {
+		// Short keys, like the placeholder of local servers, would mask
+		// ordinary words.
+		if len(model.APIKey) >= 8 {
+			s = strings.ReplaceAll(s, model.APIKey, redacted)
+		}
+	}
+	for _, pattern := range secretPatterns {
+		s = pattern.ReplaceAllString(s, "${1}"+redacted)
+	}
+	return s
+}
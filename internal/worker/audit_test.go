@@ -0,0 +1,124 @@
+package worker
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/nieveai/d-agents/internal/database"
+	m "github.com/nieveai/d-agents/internal/models"
+	pb "github.com/nieveai/d-agents/proto"
+)
+
+// useAudit sets the audit settings for the rest of the test.
+func useAudit(t *testing.T, enabled, redactInput bool) {
+	t.Helper()
+	oldEnabled, oldRedact := auditEnabled, auditRedact
+	SetAudit(enabled, redactInput)
+	t.Cleanup(func() { SetAudit(oldEnabled, oldRedact) })
+}
+
+// runAudited runs a ChatAgent session with payload on a model answering
+// reply, and returns the audit entries it left.
+func runAudited(t *testing.T, payload string, reply fakeReply) []*m.AuditEntry {
+	t.Helper()
+	server := newFakeOpenAI(t, func(map[string]any) fakeReply { return reply })
+	model := openaiModel("m1", server.URL)
+	model.APIKey = "test-key-0123456789"
+	store := database.NewMemoryDatastore()
+	if err := initForTest(t, []*m.Model{model}, store); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+	session := addRunningSession(t, store, "s1")
+	session.Payload = []byte(payload)
+	ProcessWorkload(context.Background(), session)
+
+	entries, err := store.ListAuditEntries("s1")
+	if err != nil {
+		t.Fatalf("ListAuditEntries: %v", err)
+	}
+	return entries
+}
+
+func TestCompletedWorkloadIsAudited(t *testing.T) {
+	useAudit(t, true, false)
+	entries := runAudited(t, "what is the capital of France?", fakeReply{Text: "Paris."})
+
+	if len(entries) != 1 {
+		t.Fatalf("got %d audit entries, want 1", len(entries))
+	}
+	entry := entries[0]
+	if entry.SessionID != "s1" || entry.ModelID != "m1" {
+		t.Errorf("entry is for session %q and model %q, want s1 and m1", entry.SessionID, entry.ModelID)
+	}
+	if !strings.Contains(entry.Input, "what is the capital of France?") || entry.Output != "Paris." || entry.Error != "" {
+		t.Errorf("entry input %q, output %q, error %q, want the prompt and the answer", entry.Input, entry.Output, entry.Error)
+	}
+	if entry.PromptTokens != 10 || entry.CompletionTokens != 5 {
+		t.Errorf("usage = %d + %d tokens, want 10 + 5", entry.PromptTokens, entry.CompletionTokens)
+	}
+	if entry.Timestamp.IsZero() {
+		t.Error("entry has no timestamp")
+	}
+}
+
+func TestAuditRedactsSecrets(t *testing.T) {
+	useAudit(t, true, false)
+	entries := runAudited(t, "my keys are test-key-0123456789 and sk-abcdefghijklmnopqrstuvwx", fakeReply{Text: `use "api_key": "hunter2hunter2"`})
+
+	if len(entries) != 1 {
+		t.Fatalf("got %d audit entries, want 1", len(entries))
+	}
+	for _, secret := range []string{"test-key-0123456789", "sk-abcdefghijklmnopqrstuvwx", "hunter2hunter2"} {
+		if strings.Contains(entries[0].Input, secret) || strings.Contains(entries[0].Output, secret) {
+			t.Errorf("audit entry has %q:\ninput %q\noutput %q", secret, entries[0].Input, entries[0].Output)
+		}
+	}
+	if !strings.Contains(entries[0].Input, "my keys are "+redacted) {
+		t.Errorf("input = %q, want the keys masked", entries[0].Input)
+	}
+}
+
+func TestAuditSettings(t *testing.T) {
+	t.Run("redacted input", func(t *testing.T) {
+		useAudit(t, true, true)
+		entries := runAudited(t, "my medical history", fakeReply{Text: "noted"})
+		if len(entries) != 1 || entries[0].Input != redacted || entries[0].Output != "noted" {
+			t.Errorf("entries = %+v, want one without the input", entries)
+		}
+	})
+	t.Run("disabled", func(t *testing.T) {
+		useAudit(t, false, false)
+		if entries := runAudited(t, "hello", fakeReply{Text: "hi"}); len(entries) != 0 {
+			t.Errorf("got %d audit entries with the audit off", len(entries))
+		}
+	})
+	t.Run("failed call", func(t *testing.T) {
+		useAudit(t, true, false)
+		entries := runAudited(t, "hello", fakeReply{Status: http.StatusBadRequest})
+		if len(entries) == 0 {
+			t.Fatal("a failed call wasn't audited")
+		}
+		if last := entries[len(entries)-1]; last.Error == "" || last.Output != "" {
+			t.Errorf("entry of a failed call = %+v, want the error", last)
+		}
+	})
+}
+
+func TestCallsOutsideSessionsAreNotAudited(t *testing.T) {
+	useAudit(t, true, false)
+	server := newFakeOpenAI(t, nil)
+	store := database.NewMemoryDatastore()
+	if err := initForTest(t, []*m.Model{openaiModel("m1", server.URL)}, store); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+	// As when a model is tested from the UI.
+	workload := &pb.Workload{Models: []string{"m1"}}
+	if _, err := currentLLMClient().GenerateContent(context.Background(), workload, "ping"); err != nil {
+		t.Fatalf("GenerateContent: %v", err)
+	}
+	if entries, _ := store.ListAuditEntries(""); len(entries) != 0 {
+		t.Errorf("got %d audit entries for a call outside a session", len(entries))
+	}
+}
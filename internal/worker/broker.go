@@ -0,0 +1,95 @@
+package worker
+
+import (
+	"sync"
+
+	pb "github.com/nieveai/d-agents/proto"
+	"google.golang.org/protobuf/proto"
+)
+
+// broker, if set, gets the session updates made by the workers of this
+// process, see SetBroker.
+var broker *Broker
+
+// SetBroker makes the workers publish the status, progress and payload of the
+// sessions they run to b. A nil broker turns publishing off.
+func SetBroker(b *Broker) {
+	broker = b
+}
+
+// Broker pushes session updates to subscribers, e.g. the session tabs of the
+// UI, so they needn't poll the datastore. It only sees the sessions run by
+// this process, including the results remote workers report to it.
+type Broker struct {
+	mu   sync.Mutex
+	subs map[string][]chan *pb.Workload
+}
+
+func NewBroker() *Broker {
+	return &Broker{subs: make(map[string][]chan *pb.Workload)}
+}
+
+// SessionUpdates returns a channel that gets a copy of session id each time
+// it is updated. The channel is closed after the update that finishes the
+// session, or by Unsubscribe. A subscriber that falls behind only gets the
+// latest update.
+func (b *Broker) SessionUpdates(id string) <-chan *pb.Workload {
+	updates := make(chan *pb.Workload, 1)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subs[id] = append(b.subs[id], updates)
+	return updates
+}
+
+// Unsubscribe stops updates, returned by SessionUpdates(id), and closes it if
+// it's still open.
+func (b *Broker) Unsubscribe(id string, updates <-chan *pb.Workload) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	subs := b.subs[id]
+	for i, sub := range subs {
+		if sub == updates {
+			close(sub)
+			subs = append(subs[:i], subs[i+1:]...)
+			break
+		}
+	}
+	if len(subs) == 0 {
+		delete(b.subs, id)
+	} else {
+		b.subs[id] = subs
+	}
+}
+
+// Publish sends a copy of session to its subscribers without waiting for
+// them. Publishing a finished session ends their subscriptions.
+func (b *Broker) Publish(session *pb.Workload) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	subs := b.subs[session.Id]
+	if len(subs) == 0 {
+		return
+	}
+	for _, sub := range subs {
+		// Updates are whole sessions, so an unread one can be replaced.
+		select {
+		case <-sub:
+		default:
+		}
+		sub <- proto.Clone(session).(*pb.Workload)
+	}
+	switch session.Status {
+	case pb.WorkloadStatus_COMPLETED, pb.WorkloadStatus_FAILED, pb.WorkloadStatus_CANCELLED:
+		for _, sub := range subs {
+			close(sub)
+		}
+		delete(b.subs, session.Id)
+	}
+}
+
+// publish sends session to the broker, if there is one.
+func publish(session *pb.Workload) {
+	if broker != nil {
+		broker.Publish(session)
+	}
+}
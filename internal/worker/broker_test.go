@@ -0,0 +1,116 @@
+package worker
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nieveai/d-agents/internal/database"
+	"github.com/nieveai/d-agents/internal/testutil"
+	pb "github.com/nieveai/d-agents/proto"
+)
+
+// useBroker makes the workers publish to a new broker for the rest of the
+// test.
+func useBroker(t *testing.T) *Broker {
+	t.Helper()
+	old := broker
+	b := NewBroker()
+	SetBroker(b)
+	t.Cleanup(func() { SetBroker(old) })
+	return b
+}
+
+// drain returns the updates left on updates, failing if it isn't closed soon.
+func drain(t *testing.T, updates <-chan *pb.Workload) []*pb.Workload {
+	t.Helper()
+	var got []*pb.Workload
+	for {
+		select {
+		case update, ok := <-updates:
+			if !ok {
+				return got
+			}
+			got = append(got, update)
+		case <-time.After(time.Second):
+			t.Fatalf("updates not closed, got %d so far", len(got))
+		}
+	}
+}
+
+func TestCompletedWorkloadIsPublished(t *testing.T) {
+	store := database.NewMemoryDatastore()
+	initTestWorker(t, store)
+	b := useBroker(t)
+	session := addRunningSession(t, store, "s1")
+	updates := b.SessionUpdates("s1")
+	other := b.SessionUpdates("s2")
+
+	ProcessWorkloadWithClient(context.Background(), session, testutil.NewFakeGenAIClient("hi there"))
+
+	got := drain(t, updates)
+	if len(got) != 1 {
+		t.Fatalf("got %d updates, want 1", len(got))
+	}
+	if got[0].Status != pb.WorkloadStatus_COMPLETED || !strings.HasSuffix(string(got[0].Payload), "hi there") || got[0].Progress != 100 {
+		t.Errorf("update = status %v, payload %q, progress %d, want the completed session", got[0].Status, got[0].Payload, got[0].Progress)
+	}
+	select {
+	case update := <-other:
+		t.Errorf("subscriber of s2 got %+v", update)
+	default:
+	}
+}
+
+func TestReportedResultIsPublished(t *testing.T) {
+	store := database.NewMemoryDatastore()
+	initTestWorker(t, store)
+	b := useBroker(t)
+	addRunningSession(t, store, "s1")
+	updates := b.SessionUpdates("s1")
+
+	result := &pb.Workload{Id: "s1", AgentType: "ChatAgent", Payload: []byte("from afar"), Status: pb.WorkloadStatus_FAILED, Error: "model exploded"}
+	if _, err := (&RemoteServer{}).ReportResult(context.Background(), result); err != nil {
+		t.Fatalf("ReportResult: %v", err)
+	}
+	got := drain(t, updates)
+	if len(got) != 1 || got[0].Status != pb.WorkloadStatus_FAILED || got[0].Error != "model exploded" {
+		t.Errorf("updates = %+v, want the failed session", got)
+	}
+}
+
+func TestBroker(t *testing.T) {
+	b := NewBroker()
+	running := func(progress int32) *pb.Workload {
+		return &pb.Workload{Id: "s1", Status: pb.WorkloadStatus_RUNNING, Progress: progress}
+	}
+
+	slow, gone := b.SessionUpdates("s1"), b.SessionUpdates("s1")
+	b.Publish(running(10))
+	b.Publish(running(50))
+	b.Unsubscribe("s1", gone)
+	// drain fails if it isn't closed.
+	drain(t, gone)
+
+	// A subscriber that falls behind only gets the latest update.
+	if update := <-slow; update.Progress != 50 {
+		t.Errorf("progress = %d, want the latest, 50", update.Progress)
+	}
+
+	// Subscribers get copies.
+	session := running(70)
+	b.Publish(session)
+	session.Progress = 80
+	if update := <-slow; update.Progress != 70 {
+		t.Errorf("progress = %d, want 70 as published", update.Progress)
+	}
+
+	b.Publish(&pb.Workload{Id: "s1", Status: pb.WorkloadStatus_CANCELLED})
+	if got := drain(t, slow); len(got) != 1 || got[0].Status != pb.WorkloadStatus_CANCELLED {
+		t.Errorf("updates after cancelling = %+v, want the cancelled session", got)
+	}
+	// Nobody is left, publishing doesn't block.
+	b.Publish(running(90))
+	b.Unsubscribe("s1", slow)
+}
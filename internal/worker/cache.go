@@ -0,0 +1,126 @@
+package worker
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+
+	m "github.com/nieveai/d-agents/internal/models"
+)
+
+// DefaultCacheSize is the number of responses a ResponseCache keeps when no
+// size is given.
+const DefaultCacheSize = 256
+
+// ResponseCache is an in-memory LRU of LLM responses with a TTL. It lives
+// outside LLMClient so it survives the client being reinitialized.
+type ResponseCache struct {
+	// IncludeWebSearch caches responses of models with EnableWebSearch too.
+	// Those answers are time-sensitive, so they bypass the cache by default.
+	IncludeWebSearch bool
+
+	mu      sync.Mutex
+	ttl     time.Duration
+	size    int
+	order   *list.List
+	entries map[string]*list.Element
+	now     func() time.Time
+}
+
+type cacheEntry struct {
+	key     string
+	text    string
+	expires time.Time
+}
+
+// NewResponseCache creates a cache holding up to size responses for ttl each.
+// A zero ttl keeps responses until they are evicted.
+func NewResponseCache(size int, ttl time.Duration) *ResponseCache {
+	if size <= 0 {
+		size = DefaultCacheSize
+	}
+	return &ResponseCache{
+		ttl:     ttl,
+		size:    size,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+		now:     time.Now,
+	}
+}
+
+// cacheKey hashes everything that affects the model's answer.
+func cacheKey(model *m.Model, messages []m.Message, system_prompt string) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00", model.ID, model.ModelID)
+	if model.Temperature != nil {
+		fmt.Fprintf(h, "t=%v", *model.Temperature)
+	}
+	if model.MaxTokens != nil {
+		fmt.Fprintf(h, "m=%v", *model.MaxTokens)
+	}
+	if model.TopP != nil {
+		fmt.Fprintf(h, "p=%v", *model.TopP)
+	}
+	fmt.Fprintf(h, "\x00%s", system_prompt)
+	for _, msg := range messages {
+		fmt.Fprintf(h, "\x00%s\x00%s", msg.Role, msg.Content)
+		for _, image := range msg.Images {
+			fmt.Fprintf(h, "\x00%s\x00%x", image.MIMEType, sha256.Sum256(image.Data))
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// cacheable reports whether responses of model may be served from the cache.
+func (c *ResponseCache) cacheable(model *m.Model) bool {
+	return c != nil && (!model.EnableWebSearch || c.IncludeWebSearch)
+}
+
+func (c *ResponseCache) Get(key string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[key]
+	if !ok {
+		return "", false
+	}
+	entry := el.Value.(*cacheEntry)
+	if c.ttl > 0 && c.now().After(entry.expires) {
+		c.order.Remove(el)
+		delete(c.entries, key)
+		return "", false
+	}
+	c.order.MoveToFront(el)
+	return entry.text, true
+}
+
+func (c *ResponseCache) Put(key string, text string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	expires := c.now().Add(c.ttl)
+	if el, ok := c.entries[key]; ok {
+		entry := el.Value.(*cacheEntry)
+		entry.text = text
+		entry.expires = expires
+		c.order.MoveToFront(el)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(&cacheEntry{key: key, text: text, expires: expires})
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+// Len returns the number of cached responses, expired ones included.
+func (c *ResponseCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
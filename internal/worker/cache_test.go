@@ -0,0 +1,114 @@
+package worker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	m "github.com/nieveai/d-agents/internal/models"
+	pb "github.com/nieveai/d-agents/proto"
+)
+
+func TestResponseCacheTTL(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	cache := NewResponseCache(10, time.Minute)
+	cache.now = func() time.Time { return now }
+
+	if _, ok := cache.Get("k"); ok {
+		t.Fatal("hit on an empty cache")
+	}
+	cache.Put("k", "answer")
+	if got, ok := cache.Get("k"); !ok || got != "answer" {
+		t.Errorf("Get = %q, %v, want a hit", got, ok)
+	}
+	now = now.Add(59 * time.Second)
+	if _, ok := cache.Get("k"); !ok {
+		t.Error("miss before the TTL")
+	}
+	now = now.Add(2 * time.Second)
+	if _, ok := cache.Get("k"); ok {
+		t.Error("hit after the TTL")
+	}
+	if cache.Len() != 0 {
+		t.Errorf("Len = %d, want the expired entry dropped", cache.Len())
+	}
+
+	// A zero TTL never expires.
+	forever := NewResponseCache(10, 0)
+	forever.Put("k", "answer")
+	forever.now = func() time.Time { return now.Add(24 * 365 * time.Hour) }
+	if _, ok := forever.Get("k"); !ok {
+		t.Error("miss with a zero TTL")
+	}
+}
+
+func TestResponseCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	cache := NewResponseCache(2, 0)
+	cache.Put("a", "1")
+	cache.Put("b", "2")
+	cache.Get("a")
+	cache.Put("c", "3")
+
+	for key, want := range map[string]bool{"a": true, "b": false, "c": true} {
+		if _, ok := cache.Get(key); ok != want {
+			t.Errorf("Get(%q) hit = %v, want %v", key, ok, want)
+		}
+	}
+}
+
+func TestCacheKey(t *testing.T) {
+	model := &m.Model{ID: "m1", ModelID: "gpt-4o"}
+	base := cacheKey(model, userMessage("hello"), "be brief")
+	if cacheKey(model, userMessage("hello"), "be brief") != base {
+		t.Error("the same call hashes differently")
+	}
+	temperature := 0.5
+	for name, key := range map[string]string{
+		"input":         cacheKey(model, userMessage("hello!"), "be brief"),
+		"system prompt": cacheKey(model, userMessage("hello"), "be long"),
+		"model":         cacheKey(&m.Model{ID: "m2", ModelID: "gpt-4o"}, userMessage("hello"), "be brief"),
+		"temperature":   cacheKey(&m.Model{ID: "m1", ModelID: "gpt-4o", Temperature: &temperature}, userMessage("hello"), "be brief"),
+	} {
+		if key == base {
+			t.Errorf("changing the %s doesn't change the key", name)
+		}
+	}
+}
+
+func TestLLMClientCache(t *testing.T) {
+	tests := []struct {
+		name             string
+		webSearch        bool
+		includeWebSearch bool
+		wantRequests     int
+	}{
+		{"repeated prompt is cached", false, false, 1},
+		{"web search bypasses the cache", true, false, 2},
+		{"web search cached when asked", true, true, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newFakeGemini(t, "cached answer")
+			model := geminiModel("m1")
+			model.EnableWebSearch = tt.webSearch
+			cache := NewResponseCache(10, time.Hour)
+			cache.IncludeWebSearch = tt.includeWebSearch
+			llm, err := NewLLMClient(context.Background(), []*m.Model{model}, WithResponseCache(cache))
+			if err != nil {
+				t.Fatalf("NewLLMClient: %v", err)
+			}
+			useFakeGemini(t, llm, "m1", server)
+			workload := &pb.Workload{Id: "s1", Models: []string{"m1"}}
+
+			for range 2 {
+				got, err := llm.GenerateContentWithSystemPrompt(context.Background(), workload, "hello", "be brief")
+				if err != nil || got != "cached answer" {
+					t.Fatalf("GenerateContentWithSystemPrompt = %q, %v", got, err)
+				}
+			}
+			if n := len(server.Requests()); n != tt.wantRequests {
+				t.Errorf("%d requests, want %d", n, tt.wantRequests)
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+
+	pb "github.com/nieveai/d-agents/proto"
+)
+
+// ErrWorkloadCancelled is the cancellation cause of workloads stopped with
+// CancelWorkload.
+var ErrWorkloadCancelled = errors.New("workload cancelled")
+
+var (
+	cancelMu sync.Mutex
+	// running holds the cancel funcs of the workloads this process is running.
+	running = make(map[string]context.CancelCauseFunc)
+	// cancelRequested holds workloads cancelled while queued or on a remote
+	// worker. They are finished as CANCELLED when they turn up.
+	cancelRequested = make(map[string]bool)
+)
+
+// CancelWorkload stops the session with the given ID. A running workload has
+// its context cancelled and ends up CANCELLED once its agent returns; a
+// workload that is still queued is marked CANCELLED straight away and skipped
+// when a worker picks it up.
+func CancelWorkload(id string) error {
+	cancelMu.Lock()
+	cancel, ok := running[id]
+	cancelMu.Unlock()
+	if ok {
+		slog.Info("cancelling workload", "session_id", id)
+		cancel(ErrWorkloadCancelled)
+		return nil
+	}
+
+	session, err := db.GetSession(id)
+	if err != nil {
+		return fmt.Errorf("error getting session %s: %w", id, err)
+	}
+	if session.Status != pb.WorkloadStatus_RUNNING {
+		return fmt.Errorf("session %s is not running (status %s)", id, session.Status)
+	}
+
+	cancelMu.Lock()
+	cancelRequested[id] = true
+	cancelMu.Unlock()
+	slog.Info("cancelling queued workload", "session_id", id)
+	finishWorkload(session, pb.WorkloadStatus_CANCELLED, ErrWorkloadCancelled.Error())
+	return nil
+}
+
+// trackWorkload makes the workload cancellable through CancelWorkload. The
+// returned func must be called once the workload is done.
+func trackWorkload(ctx context.Context, id string) (context.Context, func()) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	cancelMu.Lock()
+	running[id] = cancel
+	cancelMu.Unlock()
+	return ctx, func() {
+		cancelMu.Lock()
+		delete(running, id)
+		cancelMu.Unlock()
+		cancel(nil)
+	}
+}
+
+// takeCancelRequest reports whether the workload was cancelled before it
+// started, clearing the request.
+func takeCancelRequest(id string) bool {
+	cancelMu.Lock()
+	defer cancelMu.Unlock()
+	if cancelRequested[id] {
+		delete(cancelRequested, id)
+		return true
+	}
+	return false
+}
+
+// wasCancelled reports whether ctx was stopped by CancelWorkload.
+func wasCancelled(ctx context.Context) bool {
+	return errors.Is(context.Cause(ctx), ErrWorkloadCancelled)
+}
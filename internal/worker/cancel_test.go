@@ -0,0 +1,98 @@
+package worker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nieveai/d-agents/internal/database"
+	m "github.com/nieveai/d-agents/internal/models"
+	"github.com/nieveai/d-agents/internal/testutil"
+	pb "github.com/nieveai/d-agents/proto"
+)
+
+// blockingAgent closes started and then waits for its context to be done.
+type blockingAgent struct{ started chan struct{} }
+
+func (a blockingAgent) DoWork(ctx context.Context, workload *pb.Workload, client m.GenAIClient) error {
+	close(a.started)
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestCancelRunningWorkload(t *testing.T) {
+	store := database.NewMemoryDatastore()
+	initTestWorker(t, store)
+	// A retry would run the agent again and block forever.
+	maxRetries = 2
+	started := make(chan struct{})
+	RegisterAgent("blockingTestAgent", func() (m.AgentInterface, error) { return blockingAgent{started}, nil })
+	session := addRunningSession(t, store, "s1")
+	session.AgentType = "blockingTestAgent"
+
+	done := make(chan struct{})
+	go func() {
+		ProcessWorkloadWithClient(context.Background(), session, testutil.NewFakeGenAIClient())
+		close(done)
+	}()
+	<-started
+	if err := CancelWorkload("s1"); err != nil {
+		t.Fatalf("CancelWorkload: %v", err)
+	}
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("the agent is still running after CancelWorkload")
+	}
+
+	if got, _ := store.GetSession("s1"); got.Status != pb.WorkloadStatus_CANCELLED || got.Error != ErrWorkloadCancelled.Error() {
+		t.Errorf("session = %v %q, want CANCELLED", got.Status, got.Error)
+	}
+	// It is no longer running, so cancelling it again fails.
+	if err := CancelWorkload("s1"); err == nil {
+		t.Error("CancelWorkload of a cancelled session succeeded")
+	}
+}
+
+func TestCancelQueuedWorkload(t *testing.T) {
+	store := database.NewMemoryDatastore()
+	initTestWorker(t, store)
+	created := false
+	RegisterAgent("queuedTestAgent", func() (m.AgentInterface, error) {
+		created = true
+		return appendAgent{"!"}, nil
+	})
+	session := addRunningSession(t, store, "s1")
+	session.AgentType = "queuedTestAgent"
+
+	if err := CancelWorkload("s1"); err != nil {
+		t.Fatalf("CancelWorkload: %v", err)
+	}
+	if got, _ := store.GetSession("s1"); got.Status != pb.WorkloadStatus_CANCELLED {
+		t.Errorf("status = %v right after cancelling, want CANCELLED", got.Status)
+	}
+
+	// The worker skips it when it comes off the queue.
+	ProcessWorkloadWithClient(context.Background(), session, testutil.NewFakeGenAIClient())
+	if created {
+		t.Error("the agent of a cancelled workload ran")
+	}
+	if session.Status != pb.WorkloadStatus_CANCELLED {
+		t.Errorf("workload status = %v, want CANCELLED", session.Status)
+	}
+}
+
+func TestCancelErrors(t *testing.T) {
+	store := database.NewMemoryDatastore()
+	initTestWorker(t, store)
+	store.AddSession(&pb.Workload{Id: "done", Status: pb.WorkloadStatus_COMPLETED})
+
+	for _, id := range []string{"done", "missing"} {
+		if err := CancelWorkload(id); err == nil {
+			t.Errorf("CancelWorkload(%q) succeeded", id)
+		}
+	}
+	if got, _ := store.GetSession("done"); got.Status != pb.WorkloadStatus_COMPLETED {
+		t.Errorf("status of the completed session = %v, want it unchanged", got.Status)
+	}
+}
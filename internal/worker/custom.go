@@ -0,0 +1,194 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"text/template"
+
+	m "github.com/nieveai/d-agents/internal/models"
+)
+
+// customClient talks to providers with their own JSON API, as described by
+// the RequestTemplate and ResponsePath of a "custom" model.
+type customClient struct {
+	model *m.Model
+	tmpl  *template.Template
+	path  []any
+	http  *http.Client
+}
+
+// customRequest is what request templates are executed with. Input is the
+// latest user message, Messages the whole conversation.
+type customRequest struct {
+	Model       string
+	System      string
+	Input       string
+	Messages    []m.Message
+	Temperature *float64
+	MaxTokens   *int
+	TopP        *float64
+}
+
+// customAPIError is a non-2xx answer from a custom model's API.
+type customAPIError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *customAPIError) Error() string {
+	return fmt.Sprintf("%d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Body)
+}
+
+// maxErrorBody caps how much of an error response ends up in the error.
+const maxErrorBody = 512
+
+func newCustomClient(model *m.Model) (*customClient, error) {
+	tmpl, err := model.ParseRequestTemplate()
+	if err != nil {
+		return nil, err
+	}
+	path, err := parseResponsePath(model.ResponsePath)
+	if err != nil {
+		return nil, err
+	}
+	return &customClient{model: model, tmpl: tmpl, path: path, http: &http.Client{}}, nil
+}
+
+// buildRequest renders the request body for messages.
+func (c *customClient) buildRequest(messages []m.Message, system_prompt string) ([]byte, error) {
+	data := customRequest{
+		Model:       c.model.ModelID,
+		System:      system_prompt,
+		Messages:    messages,
+		Temperature: c.model.Temperature,
+		MaxTokens:   c.model.MaxTokens,
+		TopP:        c.model.TopP,
+	}
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role == m.RoleUser {
+			data.Input = messages[i].Content
+			break
+		}
+	}
+
+	var body bytes.Buffer
+	if err := c.tmpl.Execute(&body, data); err != nil {
+		return nil, fmt.Errorf("error executing request_template: %w", err)
+	}
+	if !json.Valid(body.Bytes()) {
+		return nil, fmt.Errorf("request_template of model '%s' doesn't produce valid JSON", c.model.ID)
+	}
+	return body.Bytes(), nil
+}
+
+// generate POSTs the request to the model's APIURL and returns the text found
+// at its ResponsePath.
+func (c *customClient) generate(ctx context.Context, messages []m.Message, system_prompt string) (string, error) {
+	body, err := c.buildRequest(messages, system_prompt)
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.model.APIURL, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.model.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.model.APIKey)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("error reading response: %w", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		if len(respBody) > maxErrorBody {
+			respBody = respBody[:maxErrorBody]
+		}
+		return "", &customAPIError{StatusCode: resp.StatusCode, Body: string(respBody)}
+	}
+	text, err := extractResponse(respBody, c.path)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", m.ErrInvalidResponse, err)
+	}
+	return text, nil
+}
+
+// parseResponsePath splits a JSONPath like $.choices[0].message.content into
+// its object keys (strings) and array indexes (ints). Only plain child and
+// index steps are supported.
+func parseResponsePath(path string) ([]any, error) {
+	rest := strings.TrimPrefix(strings.TrimSpace(path), "$")
+	var steps []any
+	for rest != "" {
+		switch rest[0] {
+		case '.':
+			rest = rest[1:]
+		case '[':
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("invalid response_path %q: missing ]", path)
+			}
+			index, err := strconv.Atoi(rest[1:end])
+			if err != nil || index < 0 {
+				return nil, fmt.Errorf("invalid response_path %q: bad index %q", path, rest[1:end])
+			}
+			steps = append(steps, index)
+			rest = rest[end+1:]
+		default:
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			steps = append(steps, rest[:end])
+			rest = rest[end:]
+		}
+	}
+	if len(steps) == 0 {
+		return nil, fmt.Errorf("invalid response_path %q: it selects nothing", path)
+	}
+	return steps, nil
+}
+
+// extractResponse follows path through the JSON document data. A string at
+// the end is returned as is, anything else as JSON.
+func extractResponse(data []byte, path []any) (string, error) {
+	var value any
+	if err := json.Unmarshal(data, &value); err != nil {
+		return "", fmt.Errorf("response is not JSON: %w", err)
+	}
+	for _, step := range path {
+		switch step := step.(type) {
+		case string:
+			object, ok := value.(map[string]any)
+			if !ok {
+				return "", fmt.Errorf("response has no object where key %q was expected", step)
+			}
+			if value, ok = object[step]; !ok {
+				return "", fmt.Errorf("response has no key %q", step)
+			}
+		case int:
+			array, ok := value.([]any)
+			if !ok || step >= len(array) {
+				return "", fmt.Errorf("response has no array element %d", step)
+			}
+			value = array[step]
+		}
+	}
+	if text, ok := value.(string); ok {
+		return text, nil
+	}
+	text, err := json.Marshal(value)
+	return string(text), err
+}
@@ -0,0 +1,152 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	m "github.com/nieveai/d-agents/internal/models"
+	pb "github.com/nieveai/d-agents/proto"
+)
+
+// cohereTemplate builds a Cohere style request out of the conversation.
+const cohereTemplate = `{
+	"model": {{json .Model}},
+	"preamble": {{json .System}},
+	"message": {{json .Input}},
+	"chat_history": [{{range $i, $m := .Messages}}{{if $i}},{{end}}{"role": {{json $m.Role}}, "message": {{json $m.Content}}}{{end}}]
+	{{- with .Temperature}}, "temperature": {{.}}{{end}}
+}`
+
+func TestCustomModel(t *testing.T) {
+	var gotBody map[string]any
+	var gotAuth string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAuth = r.Header.Get("Authorization")
+		data, _ := io.ReadAll(r.Body)
+		if err := json.Unmarshal(data, &gotBody); err != nil {
+			t.Errorf("request body isn't JSON: %v\n%s", err, data)
+		}
+		w.Write([]byte(`{"generations": [{"text": "ignored"}, {"text": "Hola, \"mundo\""}], "meta": {"tokens": 3}}`))
+	}))
+	defer server.Close()
+
+	temperature := 0.2
+	model := &m.Model{ID: "cohere", ModelID: "command-r", APISpec: "custom", APIKey: "secret", APIURL: server.URL,
+		RequestTemplate: cohereTemplate, ResponsePath: "$.generations[1].text", Temperature: &temperature}
+	llm, err := NewLLMClient(context.Background(), []*m.Model{model})
+	if err != nil {
+		t.Fatalf("NewLLMClient: %v", err)
+	}
+
+	// Quotes and newlines in the input can't break the JSON.
+	input := "Say \"hello\"\nin Spanish"
+	text, err := llm.GenerateContentWithSystemPrompt(context.Background(), &pb.Workload{Id: "s1", Models: []string{"cohere"}}, input, "be brief")
+	if err != nil {
+		t.Fatalf("GenerateContentWithSystemPrompt: %v", err)
+	}
+	if text != `Hola, "mundo"` {
+		t.Errorf("text = %q, want the text at the response path", text)
+	}
+	if gotAuth != "Bearer secret" {
+		t.Errorf("Authorization = %q", gotAuth)
+	}
+	if gotBody["model"] != "command-r" || gotBody["preamble"] != "be brief" || gotBody["message"] != input || gotBody["temperature"] != 0.2 {
+		t.Errorf("request body = %v", gotBody)
+	}
+	if history, _ := gotBody["chat_history"].([]any); len(history) != 1 {
+		t.Errorf("chat_history = %v, want the one message", gotBody["chat_history"])
+	}
+}
+
+func TestCustomModelErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		path     string
+		status   int
+		response string
+		want     string
+		wantIs   error
+	}{
+		{"error status", `{"prompt": {{json .Input}}}`, "text", http.StatusTooManyRequests, `{"error": "slow down"}`, "429 Too Many Requests", nil},
+		{"missing key", `{"prompt": {{json .Input}}}`, "output.text", http.StatusOK, `{"output": {}}`, `no key "text"`, m.ErrInvalidResponse},
+		{"not JSON", `{"prompt": {{json .Input}}}`, "text", http.StatusOK, `hello`, "not JSON", m.ErrInvalidResponse},
+		{"template isn't JSON", `{"prompt": {{.Input}}}`, "text", http.StatusOK, `{"text": "ok"}`, "doesn't produce valid JSON", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.response))
+			}))
+			defer server.Close()
+			model := &m.Model{ID: "c1", ModelID: "x", APISpec: "custom", APIURL: server.URL, RequestTemplate: tt.template, ResponsePath: tt.path}
+			client, err := newCustomClient(model)
+			if err != nil {
+				t.Fatalf("newCustomClient: %v", err)
+			}
+
+			_, err = client.generate(context.Background(), []m.Message{{Role: m.RoleUser, Content: "hi"}}, "")
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("generate = %v, want an error containing %q", err, tt.want)
+			}
+			if tt.wantIs != nil && !errors.Is(err, tt.wantIs) {
+				t.Errorf("generate = %v, want it to wrap %v", err, tt.wantIs)
+			}
+		})
+	}
+}
+
+func TestParseResponsePath(t *testing.T) {
+	tests := []struct {
+		path    string
+		want    []any
+		wantErr bool
+	}{
+		{"text", []any{"text"}, false},
+		{"$.choices[0].message.content", []any{"choices", 0, "message", "content"}, false},
+		{"output[2][1]", []any{"output", 2, 1}, false},
+		{"$", nil, true},
+		{"", nil, true},
+		{"choices[0", nil, true},
+		{"choices[-1]", nil, true},
+		{"choices[x]", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := parseResponsePath(tt.path)
+		if (err != nil) != tt.wantErr || !slices.Equal(got, tt.want) {
+			t.Errorf("parseResponsePath(%q) = %v, %v, want %v, error %v", tt.path, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestExtractResponse(t *testing.T) {
+	data := []byte(`{"a": {"b": [{"c": "deep"}, 42]}, "obj": {"x": 1}}`)
+	tests := []struct {
+		path    string
+		want    string
+		wantErr bool
+	}{
+		{"a.b[0].c", "deep", false},
+		// Values that aren't strings come back as JSON.
+		{"a.b[1]", "42", false},
+		{"obj", `{"x":1}`, false},
+		{"a.b[2]", "", true},
+		{"a.b.c", "", true},
+		{"a.missing", "", true},
+	}
+	for _, tt := range tests {
+		path, _ := parseResponsePath(tt.path)
+		got, err := extractResponse(data, path)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("extractResponse(%q) = %q, %v, want %q, error %v", tt.path, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
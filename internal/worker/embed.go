@@ -0,0 +1,112 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	m "github.com/nieveai/d-agents/internal/models"
+	"github.com/openai/openai-go/v2"
+	"google.golang.org/genai"
+)
+
+// embedBatchSize is how many texts go into a single embeddings request.
+const embedBatchSize = 100
+
+// Embed returns one embedding per text, in order, using the model modelID.
+// Texts are sent in batches, each of which takes a slot of the concurrency
+// limit like any other provider call.
+func (llm *LLMClient) Embed(ctx context.Context, modelID string, texts []string) ([][]float32, error) {
+	model, client, err := llm.lookupClient(modelID)
+	if err != nil {
+		return nil, err
+	}
+
+	vectors := make([][]float32, 0, len(texts))
+	for start := 0; start < len(texts); start += embedBatchSize {
+		end := min(start+embedBatchSize, len(texts))
+		batch, err := llm.embedBatch(ctx, model, client, texts[start:end])
+		if err != nil {
+			return nil, err
+		}
+		if len(batch) != end-start {
+			return nil, fmt.Errorf("expected %d embeddings from model '%s', got %d", end-start, model.ID, len(batch))
+		}
+		vectors = append(vectors, batch...)
+	}
+	return vectors, nil
+}
+
+func (llm *LLMClient) embedBatch(ctx context.Context, model *m.Model, client interface{}, texts []string) (vectors [][]float32, err error) {
+	release, err := llm.acquire(ctx, model.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	start := time.Now()
+	defer func() {
+		observeLLMCall(model, start, err)
+		slog.Debug("embeddings request finished", "model_id", model.ID, "texts", len(texts), "duration", time.Since(start), "error", err)
+	}()
+
+	switch c := client.(type) {
+	case *genai.Client:
+		contents := make([]*genai.Content, len(texts))
+		for i, text := range texts {
+			contents[i] = genai.NewContentFromText(text, genai.RoleUser)
+		}
+		config := &genai.EmbedContentConfig{}
+		if model.Dimensions > 0 {
+			d := int32(model.Dimensions)
+			config.OutputDimensionality = &d
+		}
+		resp, e := c.Models.EmbedContent(ctx, model.ModelID, contents, config)
+		if e != nil {
+			return nil, fmt.Errorf("error calling Gemini embeddings API: %s", e)
+		}
+		for _, embedding := range resp.Embeddings {
+			vectors = append(vectors, embedding.Values)
+		}
+		return vectors, nil
+
+	case *openai.Client:
+		params := openai.EmbeddingNewParams{
+			Input:          openai.EmbeddingNewParamsInputUnion{OfArrayOfStrings: texts},
+			Model:          openai.EmbeddingModel(model.ModelID),
+			EncodingFormat: openai.EmbeddingNewParamsEncodingFormatFloat,
+		}
+		if model.Dimensions > 0 {
+			params.Dimensions = openai.Int(int64(model.Dimensions))
+		}
+		resp, e := c.Embeddings.New(ctx, params)
+		if e != nil {
+			return nil, fmt.Errorf("error calling OpenAI embeddings API: %s", e)
+		}
+		// The response carries an index per embedding; don't rely on order.
+		vectors = make([][]float32, len(texts))
+		for _, embedding := range resp.Data {
+			if embedding.Index < 0 || int(embedding.Index) >= len(texts) {
+				return nil, fmt.Errorf("embedding index %d out of range", embedding.Index)
+			}
+			vector := make([]float32, len(embedding.Embedding))
+			for i, v := range embedding.Embedding {
+				vector[i] = float32(v)
+			}
+			vectors[embedding.Index] = vector
+		}
+		for i, vector := range vectors {
+			if vector == nil {
+				return nil, fmt.Errorf("no embedding returned for text %d", i)
+			}
+		}
+		return vectors, nil
+
+	case *customClient:
+		return nil, fmt.Errorf("model '%s' has a custom API, which doesn't support embeddings", model.ID)
+
+	default:
+		return nil, fmt.Errorf("unknown client type for model '%s'", model.ID)
+	}
+}
@@ -0,0 +1,96 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+
+	m "github.com/nieveai/d-agents/internal/models"
+)
+
+func texts(n int) []string {
+	out := make([]string, n)
+	for i := range out {
+		out[i] = fmt.Sprintf("text %d", i)
+	}
+	return out
+}
+
+func TestEmbedOpenAI(t *testing.T) {
+	server := newFakeOpenAI(t, nil)
+	model := openaiModel("emb", server.URL)
+	model.ModelID = "text-embedding-3-small"
+	model.Dimensions = 3
+	llm, err := NewLLMClient(context.Background(), []*m.Model{model})
+	if err != nil {
+		t.Fatalf("NewLLMClient: %v", err)
+	}
+
+	input := texts(embedBatchSize + 50)
+	vectors, err := llm.Embed(context.Background(), "emb", input)
+	if err != nil {
+		t.Fatalf("Embed: %v", err)
+	}
+	if len(vectors) != len(input) {
+		t.Fatalf("%d vectors for %d texts", len(vectors), len(input))
+	}
+	// The fake answers [index in the batch, 1, 0].
+	if want := []float32{49, 1, 0}; !slices.Equal(vectors[embedBatchSize+49], want) {
+		t.Errorf("vector %d = %v, want %v", embedBatchSize+49, vectors[embedBatchSize+49], want)
+	}
+
+	requests := server.Requests()
+	if len(requests) != 2 {
+		t.Fatalf("%d requests, want 2 batches", len(requests))
+	}
+	for i, wantLen := range []int{embedBatchSize, 50} {
+		req := requests[i]
+		if got := len(req["input"].([]any)); got != wantLen {
+			t.Errorf("batch %d has %d texts, want %d", i, got, wantLen)
+		}
+		if req["model"] != "text-embedding-3-small" || req["encoding_format"] != "float" || req["dimensions"] != 3.0 {
+			t.Errorf("batch %d = model %v, encoding %v, dimensions %v", i, req["model"], req["encoding_format"], req["dimensions"])
+		}
+	}
+	if paths := server.Paths(); !strings.HasSuffix(paths[0], "/embeddings") {
+		t.Errorf("path = %s, want the embeddings endpoint", paths[0])
+	}
+}
+
+func TestEmbedGemini(t *testing.T) {
+	server := newFakeGemini(t, "")
+	model := geminiModel("emb")
+	model.Dimensions = 2
+	llm := newGeminiLLMClient(t, server, model)
+
+	vectors, err := llm.Embed(context.Background(), "emb", texts(3))
+	if err != nil {
+		t.Fatalf("Embed: %v", err)
+	}
+	if len(vectors) != 3 || !slices.Equal(vectors[2], []float32{2, 1}) {
+		t.Errorf("vectors = %v, want 3 with the last [2 1]", vectors)
+	}
+	requests := server.Requests()[0]["requests"].([]any)
+	first := requests[0].(map[string]any)
+	text := first["content"].(map[string]any)["parts"].([]any)[0].(map[string]any)["text"]
+	if len(requests) != 3 || text != "text 0" || first["outputDimensionality"] != 2.0 {
+		t.Errorf("request = %v", server.Requests()[0])
+	}
+}
+
+func TestEmbedErrors(t *testing.T) {
+	server := newFakeOpenAI(t, nil)
+	custom := &m.Model{ID: "custom", ModelID: "x", APISpec: "custom", APIURL: server.URL, RequestTemplate: `{"prompt": {{json .Input}}}`, ResponsePath: "text"}
+	llm, err := NewLLMClient(context.Background(), []*m.Model{custom})
+	if err != nil {
+		t.Fatalf("NewLLMClient: %v", err)
+	}
+	if _, err := llm.Embed(context.Background(), "missing", texts(1)); err == nil {
+		t.Error("Embed with an unknown model succeeded")
+	}
+	if _, err := llm.Embed(context.Background(), "custom", texts(1)); err == nil || !strings.Contains(err.Error(), "doesn't support embeddings") {
+		t.Errorf("Embed with a custom model = %v, want it unsupported", err)
+	}
+}
@@ -0,0 +1,119 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	m "github.com/nieveai/d-agents/internal/models"
+	"google.golang.org/genai"
+)
+
+// fakeGemini is a Gemini API server answering generateContent with text.
+type fakeGemini struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	text     string
+	requests []map[string]any
+	// failures are the error statuses the next requests get, in order.
+	failures []int
+}
+
+func newFakeGemini(t *testing.T, text string) *fakeGemini {
+	t.Helper()
+	f := &fakeGemini{text: text}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *fakeGemini) serve(w http.ResponseWriter, r *http.Request) {
+	data, _ := io.ReadAll(r.Body)
+	var body map[string]any
+	json.Unmarshal(data, &body)
+	f.mu.Lock()
+	f.requests = append(f.requests, body)
+	status := 0
+	if len(f.failures) > 0 {
+		status, f.failures = f.failures[0], f.failures[1:]
+	}
+	f.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if strings.HasSuffix(r.URL.Path, ":batchEmbedContents") {
+		f.serveEmbeddings(w, body)
+		return
+	}
+	if status != 0 {
+		w.WriteHeader(status)
+		fmt.Fprintf(w, `{"error": {"code": %d, "message": "fake error %d", "status": "ERROR"}}`, status, status)
+		return
+	}
+	fmt.Fprintf(w, `{
+		"candidates": [{"content": {"role": "model", "parts": [{"text": %q}]}, "finishReason": "STOP"}],
+		"usageMetadata": {"promptTokenCount": 7, "candidatesTokenCount": 3, "totalTokenCount": 10}
+	}`, f.text)
+}
+
+// serveEmbeddings answers with a 2 dimensional vector per request.
+func (f *fakeGemini) serveEmbeddings(w http.ResponseWriter, body map[string]any) {
+	requests, _ := body["requests"].([]any)
+	var embeddings []map[string]any
+	for i := range requests {
+		embeddings = append(embeddings, map[string]any{"values": []float64{float64(i), 1}})
+	}
+	json.NewEncoder(w).Encode(map[string]any{"embeddings": embeddings})
+}
+
+// Fail makes the next requests fail with statuses, one each.
+func (f *fakeGemini) Fail(statuses ...int) *fakeGemini {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.failures = append(f.failures, statuses...)
+	return f
+}
+
+// Requests returns the bodies of the requests made so far.
+func (f *fakeGemini) Requests() []map[string]any {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]map[string]any(nil), f.requests...)
+}
+
+// newGeminiLLMClient returns an LLMClient whose only model is the Gemini model
+// given, talking to f.
+func newGeminiLLMClient(t *testing.T, f *fakeGemini, model *m.Model) *LLMClient {
+	t.Helper()
+	llm, err := NewLLMClient(context.Background(), []*m.Model{model})
+	if err != nil {
+		t.Fatalf("NewLLMClient: %v", err)
+	}
+	useFakeGemini(t, llm, model.ID, f)
+	return llm
+}
+
+// useFakeGemini points the Gemini model modelID of llm at f instead of Google.
+func useFakeGemini(t *testing.T, llm *LLMClient, modelID string, f *fakeGemini) {
+	t.Helper()
+	client, err := genai.NewClient(context.Background(), &genai.ClientConfig{
+		APIKey:      "test-key",
+		Backend:     genai.BackendGeminiAPI,
+		HTTPOptions: genai.HTTPOptions{BaseURL: f.URL + "/"},
+	})
+	if err != nil {
+		t.Fatalf("genai.NewClient: %v", err)
+	}
+	llm.clients[modelID] = client
+}
+
+// geminiModel returns a Gemini model.
+func geminiModel(id string) *m.Model {
+	return &m.Model{ID: id, Provider: "google", ModelID: "gemini-test", APISpec: "gemini", APIKey: "test-key"}
+}
@@ -0,0 +1,167 @@
+package worker
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	m "github.com/nieveai/d-agents/internal/models"
+)
+
+// fakeReply is how a fakeOpenAI answers one chat completion. A Status other
+// than 200 answers with an API error instead of Text.
+type fakeReply struct {
+	Text   string
+	Status int
+	// ToolCalls, if set, are returned instead of Text, as name to arguments.
+	ToolCalls [][2]string
+}
+
+// fakeOpenAI is an OpenAI compatible server. It also answers Ollama's
+// /api/tags, so it can stand in for a local model server.
+type fakeOpenAI struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	chat     func(body map[string]any) fakeReply
+	requests []map[string]any
+	headers  []http.Header
+	paths    []string
+}
+
+// newFakeOpenAI starts a fakeOpenAI answering chat completions with chat. A
+// nil chat answers "ok".
+func newFakeOpenAI(t *testing.T, chat func(body map[string]any) fakeReply) *fakeOpenAI {
+	t.Helper()
+	if chat == nil {
+		chat = func(map[string]any) fakeReply { return fakeReply{Text: "ok"} }
+	}
+	f := &fakeOpenAI{chat: chat}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *fakeOpenAI) serve(w http.ResponseWriter, r *http.Request) {
+	if strings.HasSuffix(r.URL.Path, "/api/tags") {
+		fmt.Fprint(w, `{"models": []}`)
+		return
+	}
+	data, _ := io.ReadAll(r.Body)
+	var body map[string]any
+	json.Unmarshal(data, &body)
+	f.mu.Lock()
+	f.requests = append(f.requests, body)
+	f.headers = append(f.headers, r.Header.Clone())
+	f.paths = append(f.paths, r.URL.RequestURI())
+	f.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if strings.HasSuffix(r.URL.Path, "/embeddings") {
+		f.serveEmbeddings(w, body)
+		return
+	}
+
+	reply := f.chat(body)
+	if reply.Status != 0 && reply.Status != http.StatusOK {
+		w.WriteHeader(reply.Status)
+		fmt.Fprintf(w, `{"error": {"message": "fake error %d", "type": "error"}}`, reply.Status)
+		return
+	}
+	if stream, _ := body["stream"].(bool); stream {
+		f.serveStream(w, reply.Text)
+		return
+	}
+
+	message := map[string]any{"role": "assistant", "content": reply.Text}
+	finish := "stop"
+	if len(reply.ToolCalls) > 0 {
+		var calls []map[string]any
+		for i, call := range reply.ToolCalls {
+			calls = append(calls, map[string]any{
+				"id":       fmt.Sprintf("call_%d", i),
+				"type":     "function",
+				"function": map[string]any{"name": call[0], "arguments": call[1]},
+			})
+		}
+		message = map[string]any{"role": "assistant", "content": nil, "tool_calls": calls}
+		finish = "tool_calls"
+	}
+	json.NewEncoder(w).Encode(map[string]any{
+		"id":      "chatcmpl-1",
+		"object":  "chat.completion",
+		"model":   body["model"],
+		"choices": []map[string]any{{"index": 0, "message": message, "finish_reason": finish}},
+		"usage":   map[string]any{"prompt_tokens": 10, "completion_tokens": 5, "total_tokens": 15},
+	})
+}
+
+// serveStream sends text word by word as server sent events, then the usage.
+func (f *fakeOpenAI) serveStream(w http.ResponseWriter, text string) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	flusher, _ := w.(http.Flusher)
+	for _, word := range strings.SplitAfter(text, " ") {
+		chunk, _ := json.Marshal(map[string]any{
+			"id":      "chatcmpl-1",
+			"object":  "chat.completion.chunk",
+			"choices": []map[string]any{{"index": 0, "delta": map[string]any{"content": word}}},
+		})
+		fmt.Fprintf(w, "data: %s\n\n", chunk)
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	fmt.Fprint(w, `data: {"id":"chatcmpl-1","object":"chat.completion.chunk","choices":[],"usage":{"prompt_tokens":10,"completion_tokens":5,"total_tokens":15}}`+"\n\n")
+	fmt.Fprint(w, "data: [DONE]\n\n")
+}
+
+// serveEmbeddings answers with a 3 dimensional vector per input.
+func (f *fakeOpenAI) serveEmbeddings(w http.ResponseWriter, body map[string]any) {
+	var inputs []any
+	switch input := body["input"].(type) {
+	case []any:
+		inputs = input
+	default:
+		inputs = []any{input}
+	}
+	var data []map[string]any
+	for i := range inputs {
+		data = append(data, map[string]any{"object": "embedding", "index": i, "embedding": []float64{float64(i), 1, 0}})
+	}
+	json.NewEncoder(w).Encode(map[string]any{
+		"object": "list",
+		"data":   data,
+		"usage":  map[string]any{"prompt_tokens": 3, "total_tokens": 3},
+	})
+}
+
+// Requests returns the bodies of the requests made so far.
+func (f *fakeOpenAI) Requests() []map[string]any {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]map[string]any(nil), f.requests...)
+}
+
+// Headers returns the headers of the requests made so far.
+func (f *fakeOpenAI) Headers() []http.Header {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]http.Header(nil), f.headers...)
+}
+
+// Paths returns the paths, with query, of the requests made so far.
+func (f *fakeOpenAI) Paths() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.paths...)
+}
+
+// openaiModel returns an OpenAI model talking to url.
+func openaiModel(id, url string) *m.Model {
+	return &m.Model{ID: id, Provider: "openai", ModelID: "gpt-test", APISpec: "openai", APIKey: "test-key", APIURL: url}
+}
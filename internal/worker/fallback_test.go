@@ -0,0 +1,93 @@
+package worker
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	m "github.com/nieveai/d-agents/internal/models"
+	pb "github.com/nieveai/d-agents/proto"
+)
+
+func TestFallback(t *testing.T) {
+	old := modelRetryDelay
+	modelRetryDelay = time.Millisecond
+	t.Cleanup(func() { modelRetryDelay = old })
+
+	tests := []struct {
+		name string
+		// primary and fallback are the error statuses each model answers
+		// with before it succeeds.
+		primary, fallback []int
+		want              string
+		wantErr           string
+		// wantRequests are the requests each model gets.
+		wantRequests [2]int
+	}{
+		{
+			name:         "first model errors, second succeeds",
+			primary:      []int{400, 400, 400, 400},
+			want:         "from the fallback",
+			wantRequests: [2]int{1, 1},
+		},
+		{
+			name:         "rate limit is retried on the same model",
+			primary:      []int{http.StatusTooManyRequests},
+			want:         "from the primary",
+			wantRequests: [2]int{2, 0},
+		},
+		{
+			name:         "falls back once the retries are used up",
+			primary:      []int{503, 503, 503, 503},
+			want:         "from the fallback",
+			wantRequests: [2]int{1 + modelRetries, 1},
+		},
+		{
+			name:         "every model fails",
+			primary:      []int{400},
+			fallback:     []int{404},
+			wantErr:      "all 2 models failed",
+			wantRequests: [2]int{1, 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			primary := newFakeGemini(t, "from the primary").Fail(tt.primary...)
+			fallback := newFakeGemini(t, "from the fallback").Fail(tt.fallback...)
+			llm, err := NewLLMClient(context.Background(), []*m.Model{geminiModel("primary"), geminiModel("fallback")})
+			if err != nil {
+				t.Fatalf("NewLLMClient: %v", err)
+			}
+			useFakeGemini(t, llm, "primary", primary)
+			useFakeGemini(t, llm, "fallback", fallback)
+			workload := &pb.Workload{Id: "s1", Models: []string{"primary"}, FallbackModels: []string{"fallback"}}
+
+			got, err := llm.GenerateContentWithSystemPrompt(context.Background(), workload, "hello", "")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("GenerateContentWithSystemPrompt = %q, %v, want an error containing %q", got, err, tt.wantErr)
+				}
+			} else if err != nil || got != tt.want {
+				t.Errorf("GenerateContentWithSystemPrompt = %q, %v, want %q", got, err, tt.want)
+			}
+			if n := [2]int{len(primary.Requests()), len(fallback.Requests())}; n != tt.wantRequests {
+				t.Errorf("requests = %v, want %v", n, tt.wantRequests)
+			}
+		})
+	}
+}
+
+func TestRetryableModelError(t *testing.T) {
+	for status, want := range map[int]bool{
+		400: false, 401: false, 404: false,
+		408: true, 429: true, 500: true, 502: true, 503: true, 504: true,
+	} {
+		llm := newGeminiLLMClient(t, newFakeGemini(t, "").Fail(status), geminiModel("m1"))
+		_, _, err := llm.generateForModel(context.Background(), &pb.Workload{Id: "s1"}, "m1", userMessage("hi"), "", nil)
+		if got := retryableModelError(err); got != want {
+			t.Errorf("retryableModelError(%d) = %v, want %v", status, got, want)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+var (
+	// workersMu guards the state of the workers started by RunWorkers, which
+	// CheckWorkers looks at.
+	workersMu      sync.Mutex
+	workersStarted int
+	workersRunning int
+	nextWorkerID   int
+	// busySince is when each busy worker picked up its workload.
+	busySince = make(map[int]time.Time)
+)
+
+// RunWorkers starts n workers that run the workloads in queue until ctx is
+// done.
+func RunWorkers(ctx context.Context, n int, queue *Queue) {
+	workersMu.Lock()
+	defer workersMu.Unlock()
+	for i := 0; i < n; i++ {
+		workersStarted++
+		workersRunning++
+		go runWorker(ctx, nextWorkerID, queue)
+		nextWorkerID++
+	}
+}
+
+func runWorker(ctx context.Context, id int, queue *Queue) {
+	defer func() {
+		workersMu.Lock()
+		workersRunning--
+		delete(busySince, id)
+		workersMu.Unlock()
+	}()
+	for {
+		workload, release, ok := queue.popWithSlots(ctx)
+		if !ok {
+			return
+		}
+		slog.Info("worker processing workload", "worker", id, "session_id", workload.Id, "models", workload.Models)
+		workersMu.Lock()
+		busySince[id] = time.Now()
+		workersMu.Unlock()
+
+		ProcessWorkload(ctx, workload)
+		release()
+
+		workersMu.Lock()
+		delete(busySince, id)
+		workersMu.Unlock()
+	}
+}
+
+// CheckWorkers returns an error when a worker started by RunWorkers has
+// stopped, or has been on one workload for twice the workload timeout, long
+// after it should have given up on it.
+func CheckWorkers() error {
+	workersMu.Lock()
+	defer workersMu.Unlock()
+	if workersRunning < workersStarted {
+		return fmt.Errorf("%d of %d workers stopped", workersStarted-workersRunning, workersStarted)
+	}
+	if workloadTimeout <= 0 {
+		return nil
+	}
+	for id, since := range busySince {
+		if busy := time.Since(since); busy > 2*workloadTimeout {
+			return fmt.Errorf("worker %d has been on the same workload for %s", id, busy.Round(time.Second))
+		}
+	}
+	return nil
+}
+
+// UsableModels returns how many models the LLM client could initialize.
+// Every workload fails while there are none.
+func UsableModels() int {
+	llmMutex.RLock()
+	defer llmMutex.RUnlock()
+	if llmClient == nil {
+		return 0
+	}
+	return len(llmClient.clients)
+}
@@ -0,0 +1,94 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	pb "github.com/nieveai/d-agents/proto"
+)
+
+const (
+	// HeartbeatInterval is how often a running workload reports in.
+	HeartbeatInterval = 30 * time.Second
+	// DefaultReapInterval is how often RunReaper looks for stale workloads.
+	DefaultReapInterval = time.Minute
+	// DefaultStaleAfter is how long a RUNNING workload may go without a
+	// heartbeat before it is considered lost.
+	DefaultStaleAfter = 5 * time.Minute
+)
+
+// startHeartbeat records a heartbeat for the workload now and then every
+// HeartbeatInterval by calling beat, until the returned func is called.
+func startHeartbeat(id string, beat func(id string) error) func() {
+	if err := beat(id); err != nil {
+		slog.Warn("error recording heartbeat", "session_id", id, "error", err)
+	}
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(HeartbeatInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := beat(id); err != nil {
+					slog.Warn("error recording heartbeat", "session_id", id, "error", err)
+				}
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+// localHeartbeat writes a heartbeat straight to the datastore.
+func localHeartbeat(id string) error {
+	if db == nil {
+		return nil
+	}
+	return db.SetHeartbeat(id, time.Now())
+}
+
+// ReapStaleWorkloads marks RUNNING sessions whose last heartbeat is older than
+// staleAfter as FAILED, e.g. because the remote worker running them died.
+// Sessions that never had a heartbeat are still queued and left alone.
+func ReapStaleWorkloads(staleAfter time.Duration) ([]*pb.Workload, error) {
+	sessions, err := db.ListSessions()
+	if err != nil {
+		return nil, fmt.Errorf("error loading sessions: %w", err)
+	}
+
+	cutoff := time.Now().Add(-staleAfter)
+	var reaped []*pb.Workload
+	for _, session := range sessions {
+		if session.Status != pb.WorkloadStatus_RUNNING || session.LastHeartbeat == 0 {
+			continue
+		}
+		lastHeartbeat := time.Unix(session.LastHeartbeat, 0)
+		if !lastHeartbeat.Before(cutoff) {
+			continue
+		}
+		slog.Warn("reaping stale workload", "session_id", session.Id, "agent_type", session.AgentType, "last_heartbeat", lastHeartbeat)
+		finishWorkload(session, pb.WorkloadStatus_FAILED, fmt.Sprintf("worker stopped responding, no heartbeat since %s", lastHeartbeat.Format(time.RFC3339)))
+		reaped = append(reaped, session)
+	}
+	return reaped, nil
+}
+
+// RunReaper calls ReapStaleWorkloads every interval until ctx is done.
+func RunReaper(ctx context.Context, interval, staleAfter time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := ReapStaleWorkloads(staleAfter); err != nil {
+				slog.Error("error reaping stale workloads", "error", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package worker
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nieveai/d-agents/internal/database"
+	m "github.com/nieveai/d-agents/internal/models"
+	"github.com/nieveai/d-agents/internal/testutil"
+	pb "github.com/nieveai/d-agents/proto"
+)
+
+// addHeartbeatSessions stores a stale and a fresh RUNNING session, one that
+// hasn't started yet and a stale one that already finished.
+func addHeartbeatSessions(t *testing.T, store database.Datastore) {
+	t.Helper()
+	now := time.Now()
+	for _, session := range []*pb.Workload{
+		{Id: "stale", Status: pb.WorkloadStatus_RUNNING, LastHeartbeat: now.Add(-10 * time.Minute).Unix()},
+		{Id: "fresh", Status: pb.WorkloadStatus_RUNNING, LastHeartbeat: now.Add(-10 * time.Second).Unix()},
+		{Id: "queued", Status: pb.WorkloadStatus_RUNNING},
+		{Id: "done", Status: pb.WorkloadStatus_COMPLETED, LastHeartbeat: now.Add(-time.Hour).Unix()},
+	} {
+		if err := store.AddSession(session); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestReapStaleWorkloads(t *testing.T) {
+	store := database.NewMemoryDatastore()
+	initTestWorker(t, store)
+	addHeartbeatSessions(t, store)
+
+	reaped, err := ReapStaleWorkloads(5 * time.Minute)
+	if err != nil {
+		t.Fatalf("ReapStaleWorkloads: %v", err)
+	}
+	if len(reaped) != 1 || reaped[0].Id != "stale" {
+		t.Fatalf("reaped %v, want only the stale session", reaped)
+	}
+
+	want := map[string]pb.WorkloadStatus_Status{
+		"stale":  pb.WorkloadStatus_FAILED,
+		"fresh":  pb.WorkloadStatus_RUNNING,
+		"queued": pb.WorkloadStatus_RUNNING,
+		"done":   pb.WorkloadStatus_COMPLETED,
+	}
+	for id, status := range want {
+		got, _ := store.GetSession(id)
+		if got.Status != status {
+			t.Errorf("%s: status = %v, want %v", id, got.Status, status)
+		}
+	}
+	if got, _ := store.GetSession("stale"); !strings.Contains(got.Error, "no heartbeat since") {
+		t.Errorf("error = %q, want it to say the heartbeat stopped", got.Error)
+	}
+
+	// Reaping again finds nothing new.
+	if reaped, _ := ReapStaleWorkloads(5 * time.Minute); len(reaped) != 0 {
+		t.Errorf("second reap = %v, want nothing", reaped)
+	}
+}
+
+func TestRunReaper(t *testing.T) {
+	store := database.NewMemoryDatastore()
+	initTestWorker(t, store)
+	addHeartbeatSessions(t, store)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		RunReaper(ctx, 10*time.Millisecond, 5*time.Minute)
+		close(done)
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if got, _ := store.GetSession("stale"); got.Status == pb.WorkloadStatus_FAILED {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the reaper didn't reap the stale session")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("RunReaper didn't stop with its context")
+	}
+	if got, _ := store.GetSession("fresh"); got.Status != pb.WorkloadStatus_RUNNING {
+		t.Errorf("fresh session status = %v, want it left RUNNING", got.Status)
+	}
+}
+
+// heartbeatAgent records the heartbeat stored for its session while it runs.
+type heartbeatAgent struct{ seen *int64 }
+
+func (a heartbeatAgent) DoWork(ctx context.Context, workload *pb.Workload, client m.GenAIClient) error {
+	session, err := db.GetSession(workload.Id)
+	if err != nil {
+		return err
+	}
+	*a.seen = session.LastHeartbeat
+	return nil
+}
+
+func TestRunningWorkloadHasAHeartbeat(t *testing.T) {
+	store := database.NewMemoryDatastore()
+	initTestWorker(t, store)
+	var seen int64
+	RegisterAgent("heartbeatTestAgent", func() (m.AgentInterface, error) { return heartbeatAgent{&seen}, nil })
+	session := addRunningSession(t, store, "s1")
+	session.AgentType = "heartbeatTestAgent"
+
+	start := time.Now().Unix()
+	ProcessWorkloadWithClient(context.Background(), session, testutil.NewFakeGenAIClient())
+	if seen < start {
+		t.Errorf("heartbeat while running = %d, want one from when it started (%d)", seen, start)
+	}
+	// A reaper running now leaves it alone.
+	if reaped, _ := ReapStaleWorkloads(time.Minute); len(reaped) != 0 {
+		t.Errorf("reaped %v right after it ran", reaped)
+	}
+}
+
+func TestStartHeartbeat(t *testing.T) {
+	beats := make(chan string, 10)
+	stop := startHeartbeat("s1", func(id string) error {
+		beats <- id
+		return nil
+	})
+	select {
+	case id := <-beats:
+		if id != "s1" {
+			t.Errorf("heartbeat for %q, want s1", id)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no heartbeat when the workload started")
+	}
+	stop()
+}
@@ -0,0 +1,36 @@
+package worker
+
+import (
+	"fmt"
+	"log/slog"
+
+	m "github.com/nieveai/d-agents/internal/models"
+	"github.com/nieveai/d-agents/internal/textutil"
+)
+
+// fitInput makes messages and the system prompt fit the model's
+// MaxInputBytes by cutting the middle out of the latest message, usually the
+// scraped page or document. When the rest is over the limit by itself,
+// m.ErrInputTooLarge is returned instead of an opaque provider error.
+func fitInput(model *m.Model, messages []m.Message, system_prompt string) ([]m.Message, error) {
+	if model.MaxInputBytes <= 0 || len(messages) == 0 {
+		return messages, nil
+	}
+	size := len(system_prompt)
+	for _, msg := range messages {
+		size += len(msg.Content)
+	}
+	if size <= model.MaxInputBytes {
+		return messages, nil
+	}
+
+	last := messages[len(messages)-1]
+	budget := model.MaxInputBytes - (size - len(last.Content))
+	if budget <= 0 {
+		return nil, fmt.Errorf("%w: the system prompt and earlier messages take %d bytes, model %s takes at most %d", m.ErrInputTooLarge, size-len(last.Content), model.ID, model.MaxInputBytes)
+	}
+	slog.Warn("input over the model's limit, cutting it", "model_id", model.ID, "bytes", size, "max_input_bytes", model.MaxInputBytes)
+	fitted := append([]m.Message(nil), messages...)
+	fitted[len(fitted)-1].Content = textutil.TruncateMiddle(last.Content, budget)
+	return fitted, nil
+}
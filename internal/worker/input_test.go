@@ -0,0 +1,111 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	m "github.com/nieveai/d-agents/internal/models"
+	pb "github.com/nieveai/d-agents/proto"
+)
+
+// sizeOf is the size fitInput counts for messages and the system prompt.
+func sizeOf(messages []m.Message, system_prompt string) int {
+	size := len(system_prompt)
+	for _, msg := range messages {
+		size += len(msg.Content)
+	}
+	return size
+}
+
+func TestFitInput(t *testing.T) {
+	page := "<title>USB-C Hub</title>" + strings.Repeat("<div>filler</div>", 1000) + "<p>Price: $24.99</p>"
+	history := []m.Message{{Role: m.RoleUser, Content: "find the price"}, {Role: m.RoleAssistant, Content: "send the page"}}
+	tests := []struct {
+		name          string
+		maxInputBytes int
+		messages      []m.Message
+		system_prompt string
+		wantCut       bool
+		wantErr       error
+	}{
+		{"no limit", 0, []m.Message{{Role: m.RoleUser, Content: page}}, "extract prices", false, nil},
+		{"under the limit", 100000, []m.Message{{Role: m.RoleUser, Content: page}}, "extract prices", false, nil},
+		{"over the limit", 2000, []m.Message{{Role: m.RoleUser, Content: page}}, "extract prices", true, nil},
+		{"over the limit with history", 2000, append(history, m.Message{Role: m.RoleUser, Content: page}), "extract prices", true, nil},
+		{"system prompt alone too large", 10, []m.Message{{Role: m.RoleUser, Content: page}}, "extract prices", false, m.ErrInputTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			model := &m.Model{ID: "m1", MaxInputBytes: tt.maxInputBytes}
+			original := append([]m.Message(nil), tt.messages...)
+			fitted, err := fitInput(model, tt.messages, tt.system_prompt)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) || !strings.Contains(err.Error(), "model m1 takes at most 10") {
+					t.Errorf("fitInput = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("fitInput: %v", err)
+			}
+			last := fitted[len(fitted)-1].Content
+			if !tt.wantCut {
+				if last != page {
+					t.Errorf("the input was changed under the limit")
+				}
+				return
+			}
+			if size := sizeOf(fitted, tt.system_prompt); size > tt.maxInputBytes || size < tt.maxInputBytes-100 {
+				t.Errorf("fitted input is %d bytes, want just under %d", size, tt.maxInputBytes)
+			}
+			// The head and tail of a page are kept, the middle goes.
+			if !strings.HasPrefix(last, "<title>USB-C Hub</title>") || !strings.HasSuffix(last, "<p>Price: $24.99</p>") || !strings.Contains(last, "bytes cut") {
+				t.Errorf("fitted input = %.80q...%q, want the head and tail of the page", last, last[max(0, len(last)-40):])
+			}
+			for i := range fitted[:len(fitted)-1] {
+				if fitted[i].Role != tt.messages[i].Role || fitted[i].Content != tt.messages[i].Content {
+					t.Errorf("message %d was changed", i+1)
+				}
+			}
+			if tt.messages[len(tt.messages)-1].Content != original[len(original)-1].Content {
+				t.Error("fitInput changed the caller's messages")
+			}
+		})
+	}
+}
+
+func TestMaxInputBytesIsApplied(t *testing.T) {
+	server := newFakeOpenAI(t, nil)
+	model := openaiModel("m1", server.URL)
+	model.MaxInputBytes = 500
+	llm, err := NewLLMClient(context.Background(), []*m.Model{model})
+	if err != nil {
+		t.Fatalf("NewLLMClient: %v", err)
+	}
+	workload := &pb.Workload{Id: "s1", Models: []string{"m1"}}
+	input := strings.Repeat("scraped text ", 1000)
+	if _, err := llm.GenerateContentWithSystemPrompt(context.Background(), workload, input, "be brief"); err != nil {
+		t.Fatalf("GenerateContentWithSystemPrompt: %v", err)
+	}
+	sent := 0
+	for _, msg := range server.Requests()[0]["messages"].([]any) {
+		sent += len(msg.(map[string]any)["content"].(string))
+	}
+	if sent > 500 {
+		t.Errorf("sent %d bytes, want at most 500", sent)
+	}
+
+	model.MaxInputBytes = 5
+	llm, err = NewLLMClient(context.Background(), []*m.Model{model})
+	if err != nil {
+		t.Fatalf("NewLLMClient: %v", err)
+	}
+	if _, err := llm.GenerateContentWithSystemPrompt(context.Background(), workload, input, "be brief"); !errors.Is(err, m.ErrInputTooLarge) {
+		t.Errorf("GenerateContentWithSystemPrompt with a longer system prompt than the limit = %v, want ErrInputTooLarge", err)
+	}
+	if len(server.Requests()) != 1 {
+		t.Error("input too large was sent anyway")
+	}
+}
@@ -0,0 +1,114 @@
+package worker
+
+import (
+	"log/slog"
+	"slices"
+	"sync"
+
+	"github.com/nieveai/d-agents/internal/database"
+	m "github.com/nieveai/d-agents/internal/models"
+	pb "github.com/nieveai/d-agents/proto"
+)
+
+var (
+	agentSlotsMu sync.Mutex
+	// agentSlots holds a semaphore for each agent type with a limit.
+	agentSlots = make(map[string]chan struct{})
+	// slotsFreed is closed and replaced whenever slots are freed or the
+	// limits change, waking the workers waiting for a slot.
+	slotsFreed = make(chan struct{})
+)
+
+// SetAgentLimits caps how many workloads of each agent type the workers of
+// this process run at once, e.g. to keep the browser based agents from using
+// up the memory. Workloads over the cap stay queued while the workers take
+// the ones behind them, so the wait doesn't hold up a worker or count against
+// the workload timeout. Agent types without a limit aren't capped.
+func SetAgentLimits(limits map[string]int) {
+	registered := m.RegisteredAgentTypes()
+	slots := make(map[string]chan struct{}, len(limits))
+	for agentType, n := range limits {
+		if n <= 0 {
+			continue
+		}
+		if !slices.Contains(registered, agentType) {
+			slog.Warn("limit set for an unknown agent type", "agent_type", agentType)
+		}
+		slots[agentType] = make(chan struct{}, n)
+	}
+	agentSlotsMu.Lock()
+	defer agentSlotsMu.Unlock()
+	agentSlots = slots
+	wakeSlotWaiters()
+}
+
+// ConfigureAgentLimits sets the agent limits from flag, as parsed by
+// database.ParseAgentLimits, or from the agent_limits setting of settings if
+// flag is empty.
+func ConfigureAgentLimits(flag string, settings database.Datastore) error {
+	if flag != "" {
+		limits, err := database.ParseAgentLimits(flag)
+		if err != nil {
+			return err
+		}
+		SetAgentLimits(limits)
+		return nil
+	}
+	limits, err := database.AgentLimitsSetting(settings)
+	if err != nil {
+		return err
+	}
+	SetAgentLimits(limits)
+	return nil
+}
+
+// reserveAgents takes a slot for every limited agent type the workload runs,
+// its agent or the agents of its pipeline, all of them or none. It reports
+// false when one is at its limit. The slots are held for the whole workload,
+// until the returned func is called.
+func reserveAgents(workload *pb.Workload) (func(), bool) {
+	agentTypes := workload.Pipeline
+	if len(agentTypes) == 0 {
+		agentTypes = []string{workload.AgentType}
+	}
+	agentSlotsMu.Lock()
+	defer agentSlotsMu.Unlock()
+	var taken []chan struct{}
+	for _, agentType := range agentTypes {
+		slots, ok := agentSlots[agentType]
+		if !ok || slices.Contains(taken, slots) {
+			continue
+		}
+		select {
+		case slots <- struct{}{}:
+			taken = append(taken, slots)
+		default:
+			for _, slots := range taken {
+				<-slots
+			}
+			return nil, false
+		}
+	}
+	return func() {
+		agentSlotsMu.Lock()
+		defer agentSlotsMu.Unlock()
+		for _, slots := range taken {
+			<-slots
+		}
+		wakeSlotWaiters()
+	}, true
+}
+
+// agentSlotsFreed returns a channel that is closed the next time slots are
+// freed.
+func agentSlotsFreed() <-chan struct{} {
+	agentSlotsMu.Lock()
+	defer agentSlotsMu.Unlock()
+	return slotsFreed
+}
+
+// wakeSlotWaiters must be called with agentSlotsMu held.
+func wakeSlotWaiters() {
+	close(slotsFreed)
+	slotsFreed = make(chan struct{})
+}
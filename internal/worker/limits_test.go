@@ -0,0 +1,201 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nieveai/d-agents/internal/database"
+	m "github.com/nieveai/d-agents/internal/models"
+	pb "github.com/nieveai/d-agents/proto"
+)
+
+// countingAgent records how many of its kind run at once, each running until
+// release is closed.
+type countingAgent struct {
+	mu         sync.Mutex
+	running    int
+	maxRunning int
+	release    chan struct{}
+}
+
+func (a *countingAgent) DoWork(ctx context.Context, workload *pb.Workload, client m.GenAIClient) error {
+	a.mu.Lock()
+	a.running++
+	a.maxRunning = max(a.maxRunning, a.running)
+	a.mu.Unlock()
+	defer func() {
+		a.mu.Lock()
+		a.running--
+		a.mu.Unlock()
+	}()
+
+	select {
+	case <-a.release:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (a *countingAgent) counts() (running, maxRunning int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.running, a.maxRunning
+}
+
+// useAgentLimits sets limits for the rest of the test.
+func useAgentLimits(t *testing.T, limits map[string]int) {
+	t.Helper()
+	SetAgentLimits(limits)
+	t.Cleanup(func() { SetAgentLimits(nil) })
+}
+
+func TestAgentLimits(t *testing.T) {
+	const workloads = 6
+	tests := []struct {
+		name   string
+		limits map[string]int
+		want   int
+	}{
+		{"limited", map[string]int{"countTestAgent": 2}, 2},
+		{"limit on another type", map[string]int{"ChatAgent": 1}, workloads},
+		{"no limits", nil, workloads},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := database.NewMemoryDatastore()
+			initTestWorker(t, store)
+			useAgentLimits(t, tt.limits)
+			agent := &countingAgent{release: make(chan struct{})}
+			RegisterAgent("countTestAgent", func() (m.AgentInterface, error) { return agent, nil })
+
+			queue := NewQueue(workloads)
+			for i := range workloads {
+				session := addRunningSession(t, store, fmt.Sprintf("s%d", i))
+				session.AgentType = "countTestAgent"
+				queue.Push(session)
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			RunWorkers(ctx, workloads, queue)
+
+			deadline := time.Now().Add(5 * time.Second)
+			for running, _ := agent.counts(); running < tt.want; running, _ = agent.counts() {
+				if time.Now().After(deadline) {
+					t.Fatalf("%d agents running, want %d", running, tt.want)
+				}
+				time.Sleep(time.Millisecond)
+			}
+			// Give the other workers a chance to get past the limit.
+			time.Sleep(50 * time.Millisecond)
+			// The workloads over the limit wait in the queue, not in a worker.
+			if n := queue.Len(); n != workloads-tt.want {
+				t.Errorf("%d workloads queued, want %d", n, workloads-tt.want)
+			}
+			close(agent.release)
+			for i := range workloads {
+				if got := waitForStatus(t, store, fmt.Sprintf("s%d", i)); got.Status != pb.WorkloadStatus_COMPLETED {
+					t.Errorf("session s%d = %v %q, want COMPLETED", i, got.Status, got.Error)
+				}
+			}
+
+			if _, maxRunning := agent.counts(); maxRunning != tt.want {
+				t.Errorf("at most %d agents ran at once, want %d", maxRunning, tt.want)
+			}
+		})
+	}
+}
+
+func TestWorkloadsOverTheLimitStayQueued(t *testing.T) {
+	store := database.NewMemoryDatastore()
+	initTestWorker(t, store)
+	useAgentLimits(t, map[string]int{"countTestAgent": 1})
+	done := make(chan struct{})
+	close(done)
+	RegisterAgent("countTestAgent", func() (m.AgentInterface, error) { return &countingAgent{release: done}, nil })
+	RegisterAgent("otherTestAgent", func() (m.AgentInterface, error) { return &countingAgent{release: done}, nil })
+	SetWorkloadTimeout(50 * time.Millisecond)
+	t.Cleanup(func() { SetWorkloadTimeout(DefaultWorkloadTimeout) })
+
+	// Something else has the only slot.
+	release, ok := reserveAgents(&pb.Workload{AgentType: "countTestAgent"})
+	if !ok {
+		t.Fatal("no slot for the first countTestAgent")
+	}
+	if _, ok := reserveAgents(&pb.Workload{Pipeline: []string{"otherTestAgent", "countTestAgent"}}); ok {
+		t.Error("a pipeline got a slot for an agent at its limit")
+	}
+
+	queue := NewQueue(10)
+	limited := addRunningSession(t, store, "limited")
+	limited.AgentType = "countTestAgent"
+	queue.Push(limited)
+	other := addRunningSession(t, store, "other")
+	other.AgentType = "otherTestAgent"
+	queue.Push(other)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	RunWorkers(ctx, 1, queue)
+
+	// The only worker isn't held up by the workload waiting for a slot.
+	if got := waitForStatus(t, store, "other"); got.Status != pb.WorkloadStatus_COMPLETED {
+		t.Errorf("other session = %v %q, want COMPLETED", got.Status, got.Error)
+	}
+	// Waiting longer than the workload timeout is fine, it starts once the
+	// workload has its slot.
+	time.Sleep(100 * time.Millisecond)
+	if n := queue.Len(); n != 1 {
+		t.Errorf("%d workloads queued, want the limited one", n)
+	}
+	release()
+	if got := waitForStatus(t, store, "limited"); got.Status != pb.WorkloadStatus_COMPLETED {
+		t.Errorf("limited session = %v %q, want COMPLETED", got.Status, got.Error)
+	}
+}
+
+func TestConfigureAgentLimits(t *testing.T) {
+	t.Cleanup(func() { SetAgentLimits(nil) })
+	withSetting := database.NewMemoryDatastore()
+	withSetting.SetSetting(database.SettingAgentLimits, "ShoppingAgent=2,ChatAgent=10")
+	badSetting := database.NewMemoryDatastore()
+	badSetting.SetSetting(database.SettingAgentLimits, "ShoppingAgent")
+
+	tests := []struct {
+		name     string
+		flag     string
+		settings database.Datastore
+		want     map[string]int
+		wantErr  bool
+	}{
+		{"setting", "", withSetting, map[string]int{"ShoppingAgent": 2, "ChatAgent": 10}, false},
+		{"flag wins", "ShoppingAgent=1", withSetting, map[string]int{"ShoppingAgent": 1}, false},
+		{"flag over a bad setting", "ChatAgent=3", badSetting, map[string]int{"ChatAgent": 3}, false},
+		{"neither", "", database.NewMemoryDatastore(), map[string]int{}, false},
+		{"bad flag", "ShoppingAgent=0", withSetting, nil, true},
+		{"bad setting", "", badSetting, nil, true},
+	}
+	for _, tt := range tests {
+		SetAgentLimits(map[string]int{"before": 1})
+		err := ConfigureAgentLimits(tt.flag, tt.settings)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: ConfigureAgentLimits(%q) error = %v, want error %v", tt.name, tt.flag, err, tt.wantErr)
+			continue
+		}
+		got := make(map[string]int)
+		agentSlotsMu.Lock()
+		for agentType, slots := range agentSlots {
+			got[agentType] = cap(slots)
+		}
+		agentSlotsMu.Unlock()
+		if tt.wantErr {
+			// The limits stay as they were.
+			tt.want = map[string]int{"before": 1}
+		}
+		if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("%s: limits = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
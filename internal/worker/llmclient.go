@@ -2,138 +2,783 @@ package worker
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/nieveai/d-agents/internal/metrics"
 	m "github.com/nieveai/d-agents/internal/models"
 	pb "github.com/nieveai/d-agents/proto"
 	"github.com/openai/openai-go/v2"
+	"github.com/openai/openai-go/v2/azure"
 	openai_option "github.com/openai/openai-go/v2/option"
+	"github.com/openai/openai-go/v2/shared"
+	"golang.org/x/time/rate"
 	"google.golang.org/genai"
 )
 
+const defaultOllamaURL = "http://localhost:11434/v1"
+
+// modelRetries is how many more times a model that fails with a rate limit or
+// a transient error is asked before falling back to the next one, waiting
+// modelRetryDelay longer each time.
+var (
+	modelRetries    = 2
+	modelRetryDelay = time.Second
+)
+
+// DefaultMaxInFlight is how many provider calls an LLMClient allows at once
+// unless WithMaxInFlight says otherwise.
+const DefaultMaxInFlight = 4
+
 type LLMClient struct {
 	clients   map[string]interface{}
 	modelInfo map[string]*m.Model
+	// slots limits the number of provider calls in flight across all models.
+	slots chan struct{}
+	// limiters holds the request rate limit of each model with an RPM.
+	limiters map[string]*rate.Limiter
+	cache    *ResponseCache
+	// auditLog, if set, gets every call made for a session, see WithAuditLog.
+	auditLog    AuditLog
+	redactInput bool
 }
 
-func NewLLMClient(ctx context.Context, models []*m.Model) (*LLMClient, error) {
+// LLMClientOption configures an LLMClient.
+type LLMClientOption func(*LLMClient)
+
+// WithMaxInFlight caps the number of concurrent provider calls. Values below
+// one are ignored.
+func WithMaxInFlight(n int) LLMClientOption {
+	return func(llm *LLMClient) {
+		if n > 0 {
+			llm.slots = make(chan struct{}, n)
+		}
+	}
+}
+
+// WithResponseCache serves repeated prompts from cache instead of calling the
+// provider again.
+func WithResponseCache(cache *ResponseCache) LLMClientOption {
+	return func(llm *LLMClient) {
+		llm.cache = cache
+	}
+}
+
+func NewLLMClient(ctx context.Context, models []*m.Model, opts ...LLMClientOption) (*LLMClient, error) {
 	llm := &LLMClient{
 		clients:   make(map[string]interface{}),
 		modelInfo: make(map[string]*m.Model),
+		slots:     make(chan struct{}, DefaultMaxInFlight),
+		limiters:  make(map[string]*rate.Limiter),
+	}
+	for _, opt := range opts {
+		opt(llm)
 	}
 
 	for _, model := range models {
 		llm.modelInfo[model.ID] = model
+		if model.RPM > 0 {
+			// A burst of one spaces the calls out evenly over the minute.
+			llm.limiters[model.ID] = rate.NewLimiter(rate.Every(time.Minute/time.Duration(model.RPM)), 1)
+		}
 
 		if _, ok := llm.clients[model.ID]; ok {
 			continue
 		}
 
-		var client interface{}
-		var err error
-
-		switch model.APISpec {
-		case "gemini":
-			client, err = genai.NewClient(ctx,
-				&genai.ClientConfig{
-					APIKey:  model.APIKey,
-					Backend: genai.BackendGeminiAPI,
-				})
-		case "openai":
-			opts := []openai_option.RequestOption{openai_option.WithAPIKey(model.APIKey)}
-			if model.APIURL != "" {
-				opts = append(opts, openai_option.WithBaseURL(model.APIURL))
-			}
-			c := openai.NewClient(opts...)
-			client = &c
-		default:
-			log.Printf("Unknown or unspecified API spec for model %s: '%s'", model.ID, model.APISpec)
+		client, err := newProviderClient(ctx, model)
+		if err != nil {
+			slog.Warn("skipping model", "model_id", model.ID, "api_spec", model.APISpec, "error", err)
 			continue
 		}
+		llm.clients[model.ID] = client
+		slog.Info("initialized client", "model_id", model.ID, "api_spec", model.APISpec)
+	}
+	// Not an error, models can be added later, but every workload fails
+	// until then.
+	if len(models) == 0 {
+		slog.Warn("NO MODELS CONFIGURED: workloads will fail until a model is added")
+	} else if len(llm.clients) == 0 {
+		slog.Warn("NO USABLE MODELS: none of the configured models could be initialized, workloads will fail", "models", len(models))
+	}
+	return llm, nil
+}
 
-		if err != nil {
-			log.Printf("Error initializing client for provider %s: %v", model.ID, err)
-			continue
+// newProviderClient creates the client for model: a *genai.Client, an
+// *openai.Client or a *customClient.
+func newProviderClient(ctx context.Context, model *m.Model) (interface{}, error) {
+	switch model.APISpec {
+	case "gemini":
+		return genai.NewClient(ctx,
+			&genai.ClientConfig{
+				APIKey:  model.APIKey,
+				Backend: genai.BackendGeminiAPI,
+			})
+	case "openai":
+		opts := []openai_option.RequestOption{openai_option.WithAPIKey(model.APIKey)}
+		if model.APIURL != "" {
+			opts = append(opts, openai_option.WithBaseURL(model.APIURL))
+		}
+		c := openai.NewClient(opts...)
+		return &c, nil
+	case "azure":
+		// The azure options rewrite requests to
+		// {APIURL}/openai/deployments/{ModelID}/...?api-version={APIVersion}.
+		c := openai.NewClient(
+			azure.WithEndpoint(model.APIURL, model.APIVersion),
+			azure.WithAPIKey(model.APIKey),
+		)
+		return &c, nil
+	case "ollama":
+		// Ollama (and llama.cpp) expose an OpenAI compatible API under /v1
+		// and don't need a real API key.
+		baseURL := model.APIURL
+		if baseURL == "" {
+			baseURL = defaultOllamaURL
 		}
+		apiKey := model.APIKey
+		if apiKey == "" {
+			apiKey = "ollama"
+		}
+		if err := pingOllama(ctx, baseURL); err != nil {
+			return nil, fmt.Errorf("local model server at %s is unreachable: %w", baseURL, err)
+		}
+		c := openai.NewClient(openai_option.WithAPIKey(apiKey), openai_option.WithBaseURL(baseURL))
+		return &c, nil
+	case "custom":
+		return newCustomClient(model)
+	default:
+		return nil, fmt.Errorf("unknown or unspecified API spec %q", model.APISpec)
+	}
+}
 
-		if client != nil {
-			llm.clients[model.ID] = client
-			log.Printf("Initialized client for provider: %s", model.ID)
+// acquire waits until the model's rate limit allows another request and a
+// provider slot is free. The returned func releases the slot.
+func (llm *LLMClient) acquire(ctx context.Context, modelID string) (func(), error) {
+	if limiter, ok := llm.limiters[modelID]; ok {
+		if err := limiter.Wait(ctx); err != nil {
+			return nil, fmt.Errorf("waiting for the rate limit of model %s: %w", modelID, err)
 		}
 	}
-	return llm, nil
+	select {
+	case llm.slots <- struct{}{}:
+		return func() { <-llm.slots }, nil
+	case <-ctx.Done():
+		return nil, fmt.Errorf("waiting for an LLM slot: %w", ctx.Err())
+	}
 }
 
-func (llm *LLMClient) GenerateContent(workload *pb.Workload, input string) (string, error) {
-	return llm.GenerateContentWithSystemPrompt(workload, input, "")
+// pingOllama checks that a local Ollama server is up by listing its models.
+func pingOllama(ctx context.Context, baseURL string) error {
+	root := strings.TrimSuffix(strings.TrimSuffix(baseURL, "/"), "/v1")
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, root+"/api/tags", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status from %s/api/tags: %s", root, resp.Status)
+	}
+	return nil
 }
 
-func (llm *LLMClient) GenerateContentWithSystemPrompt(workload *pb.Workload, input string, system_prompt string) (string, error) {
+func (llm *LLMClient) GenerateContent(ctx context.Context, workload *pb.Workload, input string) (string, error) {
+	return llm.GenerateContentWithSystemPrompt(ctx, workload, input, "")
+}
+
+func (llm *LLMClient) GenerateContentWithSystemPrompt(ctx context.Context, workload *pb.Workload, input string, system_prompt string) (string, error) {
 	if len(workload.Models) == 0 {
-		return "", fmt.Errorf("workload has no models specified")
+		return "", m.ErrNoModelsSpecified
 	}
-	// For now, just process the first model in the list.
-	modelID := workload.Models[0]
-	log.Printf("Processing workload for model ID: %s", modelID)
+	text, _, err := llm.GenerateContentWithUsage(ctx, workload, input, system_prompt)
+	return text, err
+}
 
-	model, ok := llm.modelInfo[modelID]
-	if !ok {
-		return "", fmt.Errorf("model information not found for model ID '%s'", modelID)
+// GenerateContentWithUsage is like GenerateContentWithSystemPrompt but also
+// returns the token usage reported by the provider. Usage is accumulated on the
+// workload either way.
+func (llm *LLMClient) GenerateContentWithUsage(ctx context.Context, workload *pb.Workload, input string, system_prompt string) (string, m.Usage, error) {
+	if len(workload.Models) == 0 {
+		return "", m.Usage{}, m.ErrNoModelsSpecified
 	}
+	return llm.generateWithFallback(ctx, workload, userMessage(input), system_prompt, nil)
+}
 
-	client, ok := llm.clients[model.ID]
-	if !ok {
-		return "", fmt.Errorf("llm client not found for model '%s'", model.ID)
+// GenerateChat sends a conversation to the workload's model, with the same
+// fallback behaviour as GenerateContentWithSystemPrompt.
+func (llm *LLMClient) GenerateChat(ctx context.Context, workload *pb.Workload, messages []m.Message, system_prompt string) (string, error) {
+	if len(workload.Models) == 0 {
+		return "", m.ErrNoModelsSpecified
 	}
+	text, _, err := llm.generateWithFallback(ctx, workload, messages, system_prompt, nil)
+	return text, err
+}
 
-	var responseText string
+// GenerateStructured has the workload's model answer with JSON following
+// schema, using the provider's structured output mode, with the same fallback
+// behaviour as GenerateContentWithSystemPrompt. Output that isn't valid JSON
+// or doesn't match the schema counts as a failed call.
+func (llm *LLMClient) GenerateStructured(ctx context.Context, workload *pb.Workload, input string, system_prompt string, schema any) (json.RawMessage, error) {
+	if len(workload.Models) == 0 {
+		return nil, m.ErrNoModelsSpecified
+	}
+	if schema == nil {
+		return nil, fmt.Errorf("no schema given")
+	}
+	text, _, err := llm.generateWithFallback(ctx, workload, userMessage(input), system_prompt, schema)
+	if err != nil {
+		return nil, err
+	}
+	return json.RawMessage(text), nil
+}
+
+// GenerateContentMultimodal sends images along with the prompt, with the same
+// fallback behaviour as GenerateContentWithSystemPrompt. Models whose provider
+// can't take images fail with m.ErrImagesNotSupported.
+func (llm *LLMClient) GenerateContentMultimodal(ctx context.Context, workload *pb.Workload, input string, images [][]byte, mime string, system_prompt string) (string, error) {
+	if len(workload.Models) == 0 {
+		return "", m.ErrNoModelsSpecified
+	}
+	messages, err := imageMessage(input, images, mime)
+	if err != nil {
+		return "", err
+	}
+	text, _, err := llm.generateWithFallback(ctx, workload, messages, system_prompt, nil)
+	return text, err
+}
+
+// imageMessage wraps a prompt and its images as a conversation.
+func imageMessage(input string, images [][]byte, mime string) ([]m.Message, error) {
+	msg := m.Message{Role: m.RoleUser, Content: input}
+	for i, data := range images {
+		if len(data) == 0 {
+			return nil, fmt.Errorf("image %d is empty", i+1)
+		}
+		imageType := mime
+		if imageType == "" {
+			imageType = http.DetectContentType(data)
+		}
+		if !strings.HasPrefix(imageType, "image/") {
+			return nil, fmt.Errorf("image %d is %s, not an image", i+1, imageType)
+		}
+		msg.Images = append(msg.Images, m.Image{Data: data, MIMEType: imageType})
+	}
+	return []m.Message{msg}, nil
+}
+
+// hasImages reports whether any of messages has images attached.
+func hasImages(messages []m.Message) bool {
+	for _, msg := range messages {
+		if len(msg.Images) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// generateWithFallback gets a single response from the first model in the
+// list, falling back to the workload's fallback models in order; use
+// GenerateContentMulti to query all of them. A non-nil schema asks for
+// structured output, see GenerateStructured.
+func (llm *LLMClient) generateWithFallback(ctx context.Context, workload *pb.Workload, messages []m.Message, system_prompt string, schema any) (string, m.Usage, error) {
+	candidates := fallbackChain(workload)
 	var err error
+	for i, modelID := range candidates {
+		var text string
+		var usage m.Usage
+		text, usage, err = llm.generateWithRetries(ctx, workload, modelID, messages, system_prompt, schema)
+		if err == nil {
+			if i > 0 {
+				slog.Info("served by fallback model", "session_id", workload.Id, "model_id", modelID, "primary_model", candidates[0])
+			}
+			llm.recordUsage(workload, modelID, usage)
+			return text, usage, nil
+		}
+		// A cancelled or timed out workload would fail on every model.
+		if ctx.Err() != nil {
+			return "", m.Usage{}, err
+		}
+		if i < len(candidates)-1 {
+			slog.Warn("model failed, trying fallback", "session_id", workload.Id, "model_id", modelID, "fallback_model", candidates[i+1], "error", err)
+		}
+	}
+	if len(candidates) > 1 {
+		return "", m.Usage{}, fmt.Errorf("all %d models failed, last error: %w", len(candidates), err)
+	}
+	return "", m.Usage{}, err
+}
+
+// generateWithRetries asks modelID, asking again while it fails with a rate
+// limit or a transient error, so generateWithFallback only moves on to the
+// next model once that is unlikely to help. OpenAI clients retry those
+// failures themselves and get no extra attempts here.
+func (llm *LLMClient) generateWithRetries(ctx context.Context, workload *pb.Workload, modelID string, messages []m.Message, system_prompt string, schema any) (string, m.Usage, error) {
+	retries := modelRetries
+	if _, client, err := llm.lookupClient(modelID); err == nil {
+		if _, ok := client.(*openai.Client); ok {
+			retries = 0
+		}
+	}
+	for attempt := 1; ; attempt++ {
+		text, usage, err := llm.generateForModel(ctx, workload, modelID, messages, system_prompt, schema)
+		if err == nil || attempt > retries || !retryableModelError(err) || ctx.Err() != nil {
+			return text, usage, err
+		}
+		slog.Warn("model call failed, retrying", "session_id", workload.Id, "model_id", modelID, "attempt", attempt, "error", err)
+		select {
+		case <-time.After(modelRetryDelay * time.Duration(attempt)):
+		case <-ctx.Done():
+			return "", m.Usage{}, err
+		}
+	}
+}
+
+// userMessage wraps a single prompt as a conversation.
+func userMessage(input string) []m.Message {
+	return []m.Message{{Role: m.RoleUser, Content: input}}
+}
+
+// fallbackChain lists the models generateWithFallback tries, in order:
+// the workload's first model followed by its fallback models.
+func fallbackChain(workload *pb.Workload) []string {
+	chain := []string{workload.Models[0]}
+	for _, id := range workload.FallbackModels {
+		if !slices.Contains(chain, id) {
+			chain = append(chain, id)
+		}
+	}
+	return chain
+}
+
+// recordUsage adds usage and its estimated cost to the workload totals.
+func (llm *LLMClient) recordUsage(workload *pb.Workload, modelID string, usage m.Usage) {
+	workload.PromptTokens += usage.PromptTokens
+	workload.CompletionTokens += usage.CompletionTokens
+	if model, ok := llm.modelInfo[modelID]; ok {
+		workload.EstimatedCost += model.EstimatedCost(usage)
+	}
+}
+
+// GenerateContentMulti sends the same prompt to every model in the workload.
+// Results are keyed by model ID. A failing model doesn't stop the others; its
+// error is reported in the returned m.ModelErrors alongside partial results.
+func (llm *LLMClient) GenerateContentMulti(ctx context.Context, workload *pb.Workload, input string, system_prompt string) (map[string]string, error) {
+	if len(workload.Models) == 0 {
+		return nil, m.ErrNoModelsSpecified
+	}
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		results = make(map[string]string)
+		usages  = make(map[string]m.Usage)
+		errs    = make(m.ModelErrors)
+	)
+	for _, modelID := range workload.Models {
+		wg.Add(1)
+		go func(modelID string) {
+			defer wg.Done()
+			text, usage, err := llm.generateForModel(ctx, workload, modelID, userMessage(input), system_prompt, nil)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs[modelID] = err
+				return
+			}
+			results[modelID] = text
+			usages[modelID] = usage
+		}(modelID)
+	}
+	wg.Wait()
+
+	for modelID, usage := range usages {
+		llm.recordUsage(workload, modelID, usage)
+	}
+
+	if len(errs) > 0 {
+		return results, errs
+	}
+	return results, nil
+}
+
+// generateForModel makes one call to modelID for workload, and records it in
+// the audit log as it was sent.
+func (llm *LLMClient) generateForModel(ctx context.Context, workload *pb.Workload, modelID string, messages []m.Message, system_prompt string, schema any) (text string, usage m.Usage, err error) {
+	defer func() { llm.audit(workload, modelID, messages, system_prompt, text, usage, err) }()
+
+	model, client, err := llm.lookupClient(modelID)
+	if err != nil {
+		return "", m.Usage{}, err
+	}
+	if _, ok := client.(*customClient); ok {
+		if schema != nil {
+			return "", m.Usage{}, fmt.Errorf("%w: %s", m.ErrStructuredOutputNotSupported, model.ID)
+		}
+		if hasImages(messages) {
+			return "", m.Usage{}, fmt.Errorf("%w: %s", m.ErrImagesNotSupported, model.ID)
+		}
+	}
+	messages, err = fitInput(model, messages, system_prompt)
+	if err != nil {
+		return "", m.Usage{}, err
+	}
+
+	var key string
+	if schema == nil && llm.cache.cacheable(model) {
+		key = cacheKey(model, messages, system_prompt)
+		if text, ok := llm.cache.Get(key); ok {
+			slog.Info("LLM cache hit", "model_id", modelID)
+			return model.CleanResponse(text), m.Usage{}, nil
+		}
+	}
+
+	release, err := llm.acquire(ctx, model.ID)
+	if err != nil {
+		return "", m.Usage{}, err
+	}
+	defer release()
+
+	var responseText string
+	start := time.Now()
 
 	// Use a type switch to handle different client types
 	switch c := client.(type) {
 	case *genai.Client:
-		var fullInput string
-		config := &genai.GenerateContentConfig{}
-		if system_prompt != "" {
-			config.SystemInstruction = &genai.Content{Parts: []*genai.Part{&genai.Part{Text: system_prompt}}}
+		config := geminiConfig(model, system_prompt)
+		if schema != nil {
+			// Gemini can't search and answer in JSON at once.
+			config.Tools = nil
+			config.ResponseMIMEType = "application/json"
+			config.ResponseJsonSchema = schema
 		}
-		config.Tools = []*genai.Tool{
-			{GoogleSearch: &genai.GoogleSearch{}},
-		}
-		fullInput = input
-
-		result, e := c.Models.GenerateContent(context.Background(), model.ModelID, genai.Text(fullInput), config)
+		result, e := c.Models.GenerateContent(ctx, model.ModelID, geminiContents(messages), config)
 		if e != nil {
-			err = fmt.Errorf("error calling Gemini API: %s", e)
+			err = fmt.Errorf("error calling Gemini API: %w", e)
 		} else {
 			responseText = result.Text()
+			usage = geminiUsage(result.UsageMetadata)
 		}
 
 	case *openai.Client:
-		messages := []openai.ChatCompletionMessageParamUnion{}
-		if system_prompt != "" {
-			messages = append(messages, openai.SystemMessage(system_prompt))
-		}
-		messages = append(messages, openai.UserMessage(string(input)))
 		// Use the specific model ID (e.g., "gpt-4o") for the API call
-		resp, e := c.Chat.Completions.New(context.TODO(), openai.ChatCompletionNewParams{
-			Messages: messages,
-			Model:    openai.ChatModel(model.ModelID),
-		})
-
+		params := openaiParams(model, messages, system_prompt)
+		if schema != nil {
+			params.ResponseFormat = openai.ChatCompletionNewParamsResponseFormatUnion{
+				OfJSONSchema: &shared.ResponseFormatJSONSchemaParam{
+					JSONSchema: shared.ResponseFormatJSONSchemaJSONSchemaParam{
+						Name:   "response",
+						Schema: schema,
+						Strict: openai.Bool(true),
+					},
+				},
+			}
+		}
+		resp, e := c.Chat.Completions.New(ctx, params)
 		if e != nil {
-			err = fmt.Errorf("error calling OpenAI API: %s", e)
+			err = fmt.Errorf("error calling OpenAI API: %w", e)
 		} else {
 			responseText = resp.Choices[0].Message.Content
+			usage = openaiUsage(resp.Usage)
+		}
+
+	case *customClient:
+		// Custom APIs don't say how usage is reported, so none is recorded.
+		responseText, err = c.generate(ctx, messages, system_prompt)
+		if err != nil {
+			err = fmt.Errorf("error calling custom API: %w", err)
 		}
 	default:
 		err = fmt.Errorf("unknown client type for model '%s'", model.ID)
 	}
 
+	// The cache keeps the raw answer, so changing the cleaners applies to
+	// cached answers too.
+	rawText := responseText
+	if err == nil {
+		responseText = model.CleanResponse(responseText)
+	}
+	if err == nil && schema != nil {
+		if e := m.ValidateJSON([]byte(responseText), schema); e != nil {
+			err = fmt.Errorf("%w: model %s returned output that doesn't match the schema: %w", m.ErrInvalidResponse, model.ID, e)
+		}
+	}
+	if ctx.Err() == nil {
+		err = classifyModelError(err)
+	}
+	observeLLMCall(model, start, err)
 	if err != nil {
-		return "", err
+		slog.Warn("LLM call failed", "model_id", modelID, "duration", time.Since(start), "error", err)
+		return "", m.Usage{}, err
+	}
+
+	slog.Info("LLM call finished", "model_id", modelID, "duration", time.Since(start),
+		"prompt_tokens", usage.PromptTokens, "completion_tokens", usage.CompletionTokens)
+	if key != "" {
+		llm.cache.Put(key, rawText)
+	}
+	return responseText, usage, nil
+}
+
+// observeLLMCall records the latency and outcome of a provider call.
+func observeLLMCall(model *m.Model, start time.Time, err error) {
+	metrics.LLMRequestDuration.WithLabelValues(model.APISpec, model.ID).Observe(time.Since(start).Seconds())
+	if err != nil {
+		metrics.LLMRequestErrors.WithLabelValues(model.APISpec, model.ID).Inc()
+	}
+}
+
+func geminiUsage(meta *genai.GenerateContentResponseUsageMetadata) m.Usage {
+	if meta == nil {
+		return m.Usage{}
+	}
+	return m.Usage{
+		PromptTokens:     int64(meta.PromptTokenCount),
+		CompletionTokens: int64(meta.CandidatesTokenCount),
+		TotalTokens:      int64(meta.TotalTokenCount),
+	}
+}
+
+func openaiUsage(usage openai.CompletionUsage) m.Usage {
+	return m.Usage{
+		PromptTokens:     usage.PromptTokens,
+		CompletionTokens: usage.CompletionTokens,
+		TotalTokens:      usage.TotalTokens,
+	}
+}
+
+// GenerateContentStream streams the response of the workload's first model
+// into out as it is generated. out is always closed when the call returns.
+func (llm *LLMClient) GenerateContentStream(ctx context.Context, workload *pb.Workload, input string, system_prompt string, out chan<- string) error {
+	return llm.GenerateChatStream(ctx, workload, userMessage(input), system_prompt, out)
+}
+
+// GenerateChatStream is the streaming form of GenerateChat. Streams don't
+// fall back to other models, as part of the answer may already be sent, and
+// aren't run through the model's ResponseCleaners.
+func (llm *LLMClient) GenerateChatStream(ctx context.Context, workload *pb.Workload, messages []m.Message, system_prompt string, out chan<- string) (err error) {
+	defer close(out)
+
+	if len(workload.Models) == 0 {
+		return m.ErrNoModelsSpecified
+	}
+	model, client, err := llm.lookupClient(workload.Models[0])
+	if err != nil {
+		return err
+	}
+	messages, err = fitInput(model, messages, system_prompt)
+	if err != nil {
+		return err
+	}
+
+	release, err := llm.acquire(ctx, model.ID)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	// streamed is the answer so far and usage what the provider reported,
+	// for the audit log.
+	var streamed strings.Builder
+	var usage m.Usage
+	start := time.Now()
+	defer func() {
+		observeLLMCall(model, start, err)
+		llm.audit(workload, model.ID, messages, system_prompt, streamed.String(), usage, err)
+	}()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	send := func(chunk string) error {
+		if chunk == "" {
+			return nil
+		}
+		streamed.WriteString(chunk)
+		select {
+		case out <- chunk:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	switch c := client.(type) {
+	case *genai.Client:
+		for result, e := range c.Models.GenerateContentStream(ctx, model.ModelID, geminiContents(messages), geminiConfig(model, system_prompt)) {
+			if e != nil {
+				return classifyModelError(fmt.Errorf("error calling Gemini API: %w", e))
+			}
+			// Usage metadata is cumulative, the last chunk carries the totals.
+			if result.UsageMetadata != nil {
+				usage = geminiUsage(result.UsageMetadata)
+			}
+			if e := send(result.Text()); e != nil {
+				return e
+			}
+		}
+		llm.recordUsage(workload, model.ID, usage)
+		return nil
+
+	case *openai.Client:
+		params := openaiParams(model, messages, system_prompt)
+		params.StreamOptions = openai.ChatCompletionStreamOptionsParam{IncludeUsage: openai.Bool(true)}
+		stream := c.Chat.Completions.NewStreaming(ctx, params)
+		defer stream.Close()
+		for stream.Next() {
+			chunk := stream.Current()
+			// The usage chunk is sent last and has no choices.
+			if chunk.Usage.TotalTokens > 0 {
+				usage = openaiUsage(chunk.Usage)
+			}
+			if len(chunk.Choices) == 0 {
+				continue
+			}
+			if e := send(chunk.Choices[0].Delta.Content); e != nil {
+				return e
+			}
+		}
+		if e := stream.Err(); e != nil {
+			return classifyModelError(fmt.Errorf("error calling OpenAI API: %w", e))
+		}
+		llm.recordUsage(workload, model.ID, usage)
+		return nil
+
+	case *customClient:
+		if hasImages(messages) {
+			return fmt.Errorf("%w: %s", m.ErrImagesNotSupported, model.ID)
+		}
+		// Custom APIs are not streamed, the answer is sent in one piece.
+		text, e := c.generate(ctx, messages, system_prompt)
+		if e != nil {
+			return classifyModelError(fmt.Errorf("error calling custom API: %w", e))
+		}
+		return send(text)
+
+	default:
+		return fmt.Errorf("unknown client type for model '%s'", model.ID)
 	}
+}
 
-	return responseText, nil
+func (llm *LLMClient) lookupClient(modelID string) (*m.Model, interface{}, error) {
+	model, ok := llm.modelInfo[modelID]
+	if !ok {
+		return nil, nil, fmt.Errorf("%w: no model with ID '%s' is configured", ErrModelNotFound, modelID)
+	}
+
+	client, ok := llm.clients[model.ID]
+	if !ok {
+		return nil, nil, fmt.Errorf("llm client not found for model '%s'", model.ID)
+	}
+	return model, client, nil
+}
+
+// geminiContents converts a conversation to Gemini contents.
+func geminiContents(messages []m.Message) []*genai.Content {
+	contents := make([]*genai.Content, 0, len(messages))
+	for _, msg := range messages {
+		role := genai.RoleUser
+		if msg.Role == m.RoleAssistant {
+			role = genai.RoleModel
+		}
+		if len(msg.Images) == 0 {
+			contents = append(contents, genai.NewContentFromText(msg.Content, genai.Role(role)))
+			continue
+		}
+		parts := []*genai.Part{genai.NewPartFromText(msg.Content)}
+		for _, image := range msg.Images {
+			parts = append(parts, genai.NewPartFromBytes(image.Data, image.MIMEType))
+		}
+		contents = append(contents, genai.NewContentFromParts(parts, genai.Role(role)))
+	}
+	return contents
+}
+
+func geminiConfig(model *m.Model, system_prompt string) *genai.GenerateContentConfig {
+	config := &genai.GenerateContentConfig{}
+	if system_prompt != "" {
+		config.SystemInstruction = &genai.Content{Parts: []*genai.Part{&genai.Part{Text: system_prompt}}}
+	}
+	if model.Temperature != nil {
+		t := float32(*model.Temperature)
+		config.Temperature = &t
+	}
+	if model.TopP != nil {
+		p := float32(*model.TopP)
+		config.TopP = &p
+	}
+	if model.MaxTokens != nil {
+		config.MaxOutputTokens = int32(*model.MaxTokens)
+	}
+	// Search grounding isn't available on every model/project, so it is opt-in.
+	if model.EnableWebSearch {
+		config.Tools = []*genai.Tool{
+			{GoogleSearch: &genai.GoogleSearch{}},
+		}
+	}
+	return config
+}
+
+func openaiParams(model *m.Model, messages []m.Message, system_prompt string) openai.ChatCompletionNewParams {
+	params := openai.ChatCompletionNewParams{
+		Messages: openaiMessages(messages, system_prompt),
+		Model:    openai.ChatModel(model.ModelID),
+	}
+	if model.Temperature != nil {
+		params.Temperature = openai.Float(*model.Temperature)
+	}
+	if model.TopP != nil {
+		params.TopP = openai.Float(*model.TopP)
+	}
+	// max_tokens rather than max_completion_tokens, as it is what most
+	// OpenAI compatible servers understand.
+	if model.MaxTokens != nil {
+		params.MaxTokens = openai.Int(int64(*model.MaxTokens))
+	}
+	return params
+}
+
+// openaiMessages converts a conversation to OpenAI chat messages.
+func openaiMessages(messages []m.Message, system_prompt string) []openai.ChatCompletionMessageParamUnion {
+	params := []openai.ChatCompletionMessageParamUnion{}
+	if system_prompt != "" {
+		params = append(params, openai.SystemMessage(system_prompt))
+	}
+	for _, msg := range messages {
+		if msg.Role == m.RoleAssistant {
+			params = append(params, openai.AssistantMessage(msg.Content))
+		} else if len(msg.Images) > 0 {
+			params = append(params, openai.UserMessage(openaiImageParts(msg)))
+		} else {
+			params = append(params, openai.UserMessage(msg.Content))
+		}
+	}
+	return params
+}
+
+// openaiImageParts sends the message text followed by its images, inlined as
+// base64 data URLs.
+func openaiImageParts(msg m.Message) []openai.ChatCompletionContentPartUnionParam {
+	parts := []openai.ChatCompletionContentPartUnionParam{openai.TextContentPart(msg.Content)}
+	for _, image := range msg.Images {
+		url := "data:" + image.MIMEType + ";base64," + base64.StdEncoding.EncodeToString(image.Data)
+		parts = append(parts, openai.ImageContentPart(openai.ChatCompletionContentPartImageImageURLParam{URL: url}))
+	}
+	return parts
 }
@@ -0,0 +1,508 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	m "github.com/nieveai/d-agents/internal/models"
+	pb "github.com/nieveai/d-agents/proto"
+)
+
+func TestOllamaModelWithoutKey(t *testing.T) {
+	server := newFakeOpenAI(t, func(map[string]any) fakeReply { return fakeReply{Text: "hello from llama"} })
+	model := &m.Model{ID: "local", ModelID: "llama3", APISpec: "ollama", APIURL: server.URL + "/v1"}
+
+	llm, err := NewLLMClient(context.Background(), []*m.Model{model})
+	if err != nil {
+		t.Fatalf("NewLLMClient: %v", err)
+	}
+	if _, ok := llm.clients["local"]; !ok {
+		t.Fatal("the ollama model wasn't initialized")
+	}
+	text, err := llm.GenerateContent(context.Background(), &pb.Workload{Models: []string{"local"}}, "hi")
+	if err != nil {
+		t.Fatalf("GenerateContent: %v", err)
+	}
+	if text != "hello from llama" {
+		t.Errorf("text = %q", text)
+	}
+	headers := server.Headers()
+	if len(headers) != 1 {
+		t.Fatalf("got %d requests, want 1", len(headers))
+	}
+	if got := headers[0].Get("Authorization"); got != "Bearer ollama" {
+		t.Errorf("Authorization = %q, want the dummy key", got)
+	}
+}
+
+func TestAzureModel(t *testing.T) {
+	server := newFakeOpenAI(t, func(body map[string]any) fakeReply { return fakeReply{Text: "hello from azure"} })
+	model := &m.Model{ID: "az", ModelID: "my-deployment", APISpec: "azure", APIURL: server.URL, APIVersion: "2024-06-01", APIKey: "azure-key"}
+
+	llm, err := NewLLMClient(context.Background(), []*m.Model{model})
+	if err != nil {
+		t.Fatalf("NewLLMClient: %v", err)
+	}
+	text, err := llm.GenerateContent(context.Background(), &pb.Workload{Models: []string{"az"}}, "hi")
+	if err != nil {
+		t.Fatalf("GenerateContent: %v", err)
+	}
+	if text != "hello from azure" {
+		t.Errorf("text = %q", text)
+	}
+
+	paths := server.Paths()
+	if len(paths) != 1 || paths[0] != "/openai/deployments/my-deployment/chat/completions?api-version=2024-06-01" {
+		t.Errorf("requests = %q, want the deployment path with the api-version", paths)
+	}
+	header := server.Headers()[0]
+	if header.Get("Api-Key") != "azure-key" || header.Get("Authorization") != "" {
+		t.Errorf("auth headers = api-key %q, authorization %q, want only the api-key", header.Get("Api-Key"), header.Get("Authorization"))
+	}
+}
+
+func TestOllamaUnreachableIsSkipped(t *testing.T) {
+	server := httptest.NewServer(nil)
+	url := server.URL
+	server.Close()
+	model := &m.Model{ID: "local", ModelID: "llama3", APISpec: "ollama", APIURL: url + "/v1"}
+
+	llm, err := NewLLMClient(context.Background(), []*m.Model{model})
+	if err != nil {
+		t.Fatalf("NewLLMClient: %v", err)
+	}
+	if _, ok := llm.clients["local"]; ok {
+		t.Error("an unreachable ollama server was initialized")
+	}
+	if _, err := llm.GenerateContent(context.Background(), &pb.Workload{Models: []string{"local"}}, "hi"); err == nil {
+		t.Error("GenerateContent succeeded without a server")
+	}
+}
+
+func TestPingOllama(t *testing.T) {
+	server := newFakeOpenAI(t, nil)
+	for _, url := range []string{server.URL, server.URL + "/", server.URL + "/v1", server.URL + "/v1/"} {
+		if err := pingOllama(context.Background(), url); err != nil {
+			t.Errorf("pingOllama(%q): %v", url, err)
+		}
+	}
+	notOllama := httptest.NewServer(nil)
+	defer notOllama.Close()
+	if err := pingOllama(context.Background(), notOllama.URL); err == nil {
+		t.Error("pingOllama succeeded against a server without /api/tags")
+	}
+}
+
+func TestGenerateContentMultiPartialFailure(t *testing.T) {
+	good := newFakeOpenAI(t, func(map[string]any) fakeReply { return fakeReply{Text: "fine"} })
+	bad := newFakeOpenAI(t, func(map[string]any) fakeReply { return fakeReply{Status: http.StatusBadRequest} })
+	llm, err := NewLLMClient(context.Background(), []*m.Model{openaiModel("good", good.URL), openaiModel("bad", bad.URL)})
+	if err != nil {
+		t.Fatalf("NewLLMClient: %v", err)
+	}
+
+	workload := &pb.Workload{Id: "s1", Models: []string{"good", "bad", "missing"}}
+	results, err := llm.GenerateContentMulti(context.Background(), workload, "hi", "")
+	if len(results) != 1 || results["good"] != "fine" {
+		t.Errorf("results = %v, want only the good model's answer", results)
+	}
+	var modelErrs m.ModelErrors
+	if !errors.As(err, &modelErrs) {
+		t.Fatalf("err = %v, want m.ModelErrors", err)
+	}
+	if len(modelErrs) != 2 || modelErrs["bad"] == nil || modelErrs["missing"] == nil {
+		t.Errorf("model errors = %v, want bad and missing", modelErrs)
+	}
+	if workload.PromptTokens != 10 || workload.CompletionTokens != 5 {
+		t.Errorf("usage = %d/%d, want only the good model's 10/5", workload.PromptTokens, workload.CompletionTokens)
+	}
+}
+
+// collect runs GenerateContentStream on ctx and returns the chunks it sent.
+// It fails the test if out isn't closed.
+func collect(t *testing.T, ctx context.Context, llm *LLMClient, workload *pb.Workload) ([]string, error) {
+	t.Helper()
+	out := make(chan string)
+	errCh := make(chan error, 1)
+	go func() { errCh <- llm.GenerateContentStream(ctx, workload, "hi", "", out) }()
+	var chunks []string
+	for chunk := range out {
+		chunks = append(chunks, chunk)
+	}
+	select {
+	case err := <-errCh:
+		return chunks, err
+	case <-time.After(5 * time.Second):
+		t.Fatal("GenerateContentStream didn't return after closing out")
+		return nil, nil
+	}
+}
+
+func TestGenerateContentStream(t *testing.T) {
+	server := newFakeOpenAI(t, func(map[string]any) fakeReply { return fakeReply{Text: "one two three"} })
+	llm, err := NewLLMClient(context.Background(), []*m.Model{openaiModel("gpt", server.URL)})
+	if err != nil {
+		t.Fatalf("NewLLMClient: %v", err)
+	}
+
+	workload := &pb.Workload{Id: "s1", Models: []string{"gpt"}}
+	chunks, err := collect(t, context.Background(), llm, workload)
+	if err != nil {
+		t.Fatalf("GenerateContentStream: %v", err)
+	}
+	if len(chunks) != 3 || strings.Join(chunks, "") != "one two three" {
+		t.Errorf("chunks = %q, want the answer in 3 pieces", chunks)
+	}
+	if workload.PromptTokens != 10 || workload.CompletionTokens != 5 {
+		t.Errorf("usage = %d/%d, want 10/5", workload.PromptTokens, workload.CompletionTokens)
+	}
+	if stream, _ := server.Requests()[0]["stream"].(bool); !stream {
+		t.Error("the request didn't ask for a stream")
+	}
+}
+
+func TestGenerateContentStreamClosesOnError(t *testing.T) {
+	server := newFakeOpenAI(t, func(map[string]any) fakeReply { return fakeReply{Status: http.StatusBadRequest} })
+	llm, err := NewLLMClient(context.Background(), []*m.Model{openaiModel("gpt", server.URL)})
+	if err != nil {
+		t.Fatalf("NewLLMClient: %v", err)
+	}
+
+	for _, models := range [][]string{{"gpt"}, {"missing"}, nil} {
+		chunks, err := collect(t, context.Background(), llm, &pb.Workload{Id: "s1", Models: models})
+		if err == nil {
+			t.Errorf("models %v: GenerateContentStream succeeded", models)
+		}
+		if len(chunks) != 0 {
+			t.Errorf("models %v: got chunks %q", models, chunks)
+		}
+	}
+}
+
+func TestGenerateContentStreamCancelled(t *testing.T) {
+	server := newFakeOpenAI(t, func(map[string]any) fakeReply { return fakeReply{Text: "one two three"} })
+	llm, err := NewLLMClient(context.Background(), []*m.Model{openaiModel("gpt", server.URL)})
+	if err != nil {
+		t.Fatalf("NewLLMClient: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	out := make(chan string)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- llm.GenerateContentStream(ctx, &pb.Workload{Id: "s1", Models: []string{"gpt"}}, "hi", "", out)
+	}()
+	if chunk := <-out; chunk != "one " {
+		t.Fatalf("first chunk = %q", chunk)
+	}
+	// Nobody reads the rest, so only the cancellation can end the stream.
+	cancel()
+	select {
+	case err := <-errCh:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("err = %v, want context.Canceled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the stream didn't stop after the context was cancelled")
+	}
+	if _, open := <-out; open {
+		t.Error("out wasn't closed")
+	}
+}
+
+func TestGeminiWebSearchIsOptIn(t *testing.T) {
+	for _, enabled := range []bool{false, true} {
+		server := newFakeGemini(t, "answer")
+		model := geminiModel("gemini")
+		model.EnableWebSearch = enabled
+		llm := newGeminiLLMClient(t, server, model)
+
+		if _, err := llm.GenerateContent(context.Background(), &pb.Workload{Id: "s1", Models: []string{"gemini"}}, "hi"); err != nil {
+			t.Fatalf("GenerateContent: %v", err)
+		}
+		tools, _ := server.Requests()[0]["tools"].([]any)
+		want := 0
+		if enabled {
+			want = 1
+		}
+		if len(tools) != want || (enabled && tools[0].(map[string]any)["googleSearch"] == nil) {
+			t.Errorf("EnableWebSearch = %v: tools = %v", enabled, tools)
+		}
+	}
+}
+
+func TestGenerationParams(t *testing.T) {
+	temperature, topP, maxTokens := 0.25, 0.5, 256
+	tests := []struct {
+		name  string
+		set   bool
+		check func(t *testing.T, openaiBody, geminiBody map[string]any)
+	}{
+		{
+			name: "provider defaults",
+			check: func(t *testing.T, openaiBody, geminiBody map[string]any) {
+				for _, key := range []string{"temperature", "top_p", "max_tokens"} {
+					if v, ok := openaiBody[key]; ok {
+						t.Errorf("openai %s = %v, want it unset", key, v)
+					}
+				}
+				if config, ok := geminiBody["generationConfig"].(map[string]any); ok && len(config) > 0 {
+					t.Errorf("gemini generationConfig = %v, want it empty", config)
+				}
+			},
+		},
+		{
+			name: "set",
+			set:  true,
+			check: func(t *testing.T, openaiBody, geminiBody map[string]any) {
+				if openaiBody["temperature"] != 0.25 || openaiBody["top_p"] != 0.5 || openaiBody["max_tokens"] != 256.0 {
+					t.Errorf("openai params = %v/%v/%v, want 0.25/0.5/256", openaiBody["temperature"], openaiBody["top_p"], openaiBody["max_tokens"])
+				}
+				config, _ := geminiBody["generationConfig"].(map[string]any)
+				if config["temperature"] != 0.25 || config["topP"] != 0.5 || config["maxOutputTokens"] != 256.0 {
+					t.Errorf("gemini generationConfig = %v", config)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			openaiServer := newFakeOpenAI(t, nil)
+			geminiServer := newFakeGemini(t, "ok")
+			om, gm := openaiModel("openai", openaiServer.URL), geminiModel("gemini")
+			if tt.set {
+				for _, model := range []*m.Model{om, gm} {
+					model.Temperature, model.TopP, model.MaxTokens = &temperature, &topP, &maxTokens
+				}
+			}
+			llm := newGeminiLLMClient(t, geminiServer, gm)
+			openaiLLM, err := NewLLMClient(context.Background(), []*m.Model{om})
+			if err != nil {
+				t.Fatalf("NewLLMClient: %v", err)
+			}
+
+			if _, err := openaiLLM.GenerateContent(context.Background(), &pb.Workload{Models: []string{"openai"}}, "hi"); err != nil {
+				t.Fatalf("openai: %v", err)
+			}
+			if _, err := llm.GenerateContent(context.Background(), &pb.Workload{Models: []string{"gemini"}}, "hi"); err != nil {
+				t.Fatalf("gemini: %v", err)
+			}
+			tt.check(t, openaiServer.Requests()[0], geminiServer.Requests()[0])
+		})
+	}
+}
+
+func TestMaxInFlight(t *testing.T) {
+	const limit = 2
+	var mu sync.Mutex
+	inFlight, peak := 0, 0
+	server := newFakeOpenAI(t, func(map[string]any) fakeReply {
+		mu.Lock()
+		inFlight++
+		peak = max(peak, inFlight)
+		mu.Unlock()
+		time.Sleep(20 * time.Millisecond)
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+		return fakeReply{Text: "ok"}
+	})
+	llm, err := NewLLMClient(context.Background(), []*m.Model{openaiModel("m1", server.URL), openaiModel("m2", server.URL)}, WithMaxInFlight(limit))
+	if err != nil {
+		t.Fatalf("NewLLMClient: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// The limit is shared by all models.
+			workload := &pb.Workload{Id: "s1", Models: []string{[]string{"m1", "m2"}[i%2]}}
+			if _, err := llm.GenerateContentWithSystemPrompt(context.Background(), workload, "hello", ""); err != nil {
+				t.Errorf("GenerateContentWithSystemPrompt: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if peak != limit {
+		t.Errorf("%d calls were in flight at once, want %d", peak, limit)
+	}
+}
+
+func TestMaxInFlightWaitIsCancellable(t *testing.T) {
+	unblock := make(chan struct{})
+	server := newFakeOpenAI(t, func(map[string]any) fakeReply {
+		<-unblock
+		return fakeReply{Text: "ok"}
+	})
+	defer close(unblock)
+	llm, err := NewLLMClient(context.Background(), []*m.Model{openaiModel("m1", server.URL)}, WithMaxInFlight(1))
+	if err != nil {
+		t.Fatalf("NewLLMClient: %v", err)
+	}
+	workload := &pb.Workload{Id: "s1", Models: []string{"m1"}}
+
+	// Take the only slot.
+	go llm.GenerateContentWithSystemPrompt(context.Background(), workload, "first", "")
+	for len(server.Requests()) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := llm.GenerateContentWithSystemPrompt(ctx, workload, "second", ""); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("GenerateContentWithSystemPrompt = %v, want it to give up waiting for a slot", err)
+	}
+	if n := len(server.Requests()); n != 1 {
+		t.Errorf("%d requests reached the provider, want 1", n)
+	}
+}
+
+func TestGenerateChatSendsTheHistory(t *testing.T) {
+	history := []m.Message{
+		{Role: m.RoleUser, Content: "hi"},
+		{Role: m.RoleAssistant, Content: "hello"},
+		{Role: m.RoleUser, Content: "how are you?"},
+	}
+	t.Run("openai", func(t *testing.T) {
+		server := newFakeOpenAI(t, nil)
+		llm, err := NewLLMClient(context.Background(), []*m.Model{openaiModel("m1", server.URL)})
+		if err != nil {
+			t.Fatalf("NewLLMClient: %v", err)
+		}
+		if _, err := llm.GenerateChat(context.Background(), &pb.Workload{Id: "s1", Models: []string{"m1"}}, history, "be brief"); err != nil {
+			t.Fatalf("GenerateChat: %v", err)
+		}
+		var got []string
+		for _, msg := range server.Requests()[0]["messages"].([]any) {
+			msg := msg.(map[string]any)
+			got = append(got, fmt.Sprintf("%s: %v", msg["role"], msg["content"]))
+		}
+		want := []string{"system: be brief", "user: hi", "assistant: hello", "user: how are you?"}
+		if !slices.Equal(got, want) {
+			t.Errorf("messages = %q, want %q", got, want)
+		}
+	})
+	t.Run("gemini", func(t *testing.T) {
+		server := newFakeGemini(t, "fine")
+		llm := newGeminiLLMClient(t, server, geminiModel("m1"))
+		if _, err := llm.GenerateChat(context.Background(), &pb.Workload{Id: "s1", Models: []string{"m1"}}, history, ""); err != nil {
+			t.Fatalf("GenerateChat: %v", err)
+		}
+		var got []string
+		for _, content := range server.Requests()[0]["contents"].([]any) {
+			content := content.(map[string]any)
+			text := content["parts"].([]any)[0].(map[string]any)["text"]
+			got = append(got, fmt.Sprintf("%s: %v", content["role"], text))
+		}
+		want := []string{"user: hi", "model: hello", "user: how are you?"}
+		if !slices.Equal(got, want) {
+			t.Errorf("contents = %q, want %q", got, want)
+		}
+	})
+}
+
+func TestProviderErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		want   error
+	}{
+		{"bad key", http.StatusUnauthorized, ErrModelAuth},
+		{"forbidden", http.StatusForbidden, ErrModelAuth},
+		{"unknown model", http.StatusNotFound, ErrModelNotFound},
+	}
+	workload := &pb.Workload{Id: "s1", Models: []string{"m1"}}
+	for _, tt := range tests {
+		t.Run("openai "+tt.name, func(t *testing.T) {
+			server := newFakeOpenAI(t, func(map[string]any) fakeReply { return fakeReply{Status: tt.status} })
+			llm, err := NewLLMClient(context.Background(), []*m.Model{openaiModel("m1", server.URL)})
+			if err != nil {
+				t.Fatalf("NewLLMClient: %v", err)
+			}
+			if _, err := llm.GenerateContent(context.Background(), workload, "hi"); !errors.Is(err, tt.want) {
+				t.Errorf("GenerateContent = %v, want %v", err, tt.want)
+			}
+		})
+		t.Run("gemini "+tt.name, func(t *testing.T) {
+			server := newFakeGemini(t, "unused").Fail(tt.status)
+			llm := newGeminiLLMClient(t, server, geminiModel("m1"))
+			if _, err := llm.GenerateContent(context.Background(), workload, "hi"); !errors.Is(err, tt.want) {
+				t.Errorf("GenerateContent = %v, want %v", err, tt.want)
+			}
+		})
+	}
+
+	llm, err := NewLLMClient(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("NewLLMClient: %v", err)
+	}
+	if _, err := llm.GenerateContent(context.Background(), workload, "hi"); !errors.Is(err, ErrModelNotFound) {
+		t.Errorf("GenerateContent with an unconfigured model = %v, want ErrModelNotFound", err)
+	}
+	if _, err := llm.GenerateContent(context.Background(), &pb.Workload{Id: "s1"}, "hi"); !errors.Is(err, m.ErrNoModelsSpecified) {
+		t.Errorf("GenerateContent without models = %v, want ErrNoModelsSpecified", err)
+	}
+}
+
+func TestResponseCleaners(t *testing.T) {
+	const chatty = "Sure! Here is the JSON:\n```json\n[{\"name\": \"Hub\"}]\n```\nLet me know if you need more."
+	server := newFakeOpenAI(t, func(map[string]any) fakeReply { return fakeReply{Text: chatty} })
+	cache := NewResponseCache(10, time.Hour)
+	newClient := func(cleaners ...string) *LLMClient {
+		t.Helper()
+		model := openaiModel("m1", server.URL)
+		model.ResponseCleaners = cleaners
+		llm, err := NewLLMClient(context.Background(), []*m.Model{model}, WithResponseCache(cache))
+		if err != nil {
+			t.Fatalf("NewLLMClient: %v", err)
+		}
+		return llm
+	}
+	workload := &pb.Workload{Id: "s1", Models: []string{"m1"}}
+	generate := func(llm *LLMClient) string {
+		t.Helper()
+		got, err := llm.GenerateContentWithSystemPrompt(context.Background(), workload, "list the hubs", "answer in JSON")
+		if err != nil {
+			t.Fatalf("GenerateContentWithSystemPrompt: %v", err)
+		}
+		return got
+	}
+
+	if got := generate(newClient()); got != chatty {
+		t.Errorf("answer without cleaners = %q, want it as the model gave it", got)
+	}
+	// The cache has the raw answer, so other cleaners apply to it as well.
+	if got := generate(newClient("trim_preamble", "strip_fences")); got != `[{"name": "Hub"}]` {
+		t.Errorf("cleaned answer = %q", got)
+	}
+	if got := generate(newClient("trim_preamble")); got != "```json\n[{\"name\": \"Hub\"}]\n```" {
+		t.Errorf("answer with only trim_preamble = %q", got)
+	}
+	if n := len(server.Requests()); n != 1 {
+		t.Errorf("%d requests, want 1 with the rest cached", n)
+	}
+
+	// Streams go out as they come.
+	out := make(chan string)
+	done := make(chan error, 1)
+	go func() {
+		done <- newClient("strip_fences").GenerateContentStream(context.Background(), workload, "list the hubs again", "", out)
+	}()
+	var streamed strings.Builder
+	for chunk := range out {
+		streamed.WriteString(chunk)
+	}
+	if err := <-done; err != nil || streamed.String() != chatty {
+		t.Errorf("stream = %q, %v, want the raw answer", streamed.String(), err)
+	}
+}
@@ -0,0 +1,128 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/nieveai/d-agents/internal/database"
+	m "github.com/nieveai/d-agents/internal/models"
+	pb "github.com/nieveai/d-agents/proto"
+)
+
+// captureLogs sends the default slog logger to a buffer as JSON, at every
+// level, until the test ends.
+func captureLogs(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	old := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	t.Cleanup(func() { slog.SetDefault(old) })
+	return &buf
+}
+
+// logEvents decodes the JSON log lines in buf.
+func logEvents(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	t.Helper()
+	var events []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var event map[string]any
+		if err := json.Unmarshal([]byte(line), &event); err != nil {
+			t.Fatalf("log line %q isn't JSON: %v", line, err)
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+// askAgent asks the model about the payload and appends the answer.
+type askAgent struct{}
+
+func (askAgent) DoWork(ctx context.Context, workload *pb.Workload, client m.GenAIClient) error {
+	answer, err := client.GenerateContent(ctx, workload, string(workload.Payload))
+	if err != nil {
+		return err
+	}
+	workload.Payload = append(workload.Payload, " "+answer...)
+	return nil
+}
+
+func TestWorkloadLifecycleIsLogged(t *testing.T) {
+	RegisterAgent("askTestAgent", func() (m.AgentInterface, error) { return askAgent{}, nil })
+	RegisterAgent("failTestAgent", func() (m.AgentInterface, error) { return failAgent{}, nil })
+	server := newFakeOpenAI(t, nil)
+	llm, err := NewLLMClient(context.Background(), []*m.Model{openaiModel("m1", server.URL)})
+	if err != nil {
+		t.Fatalf("NewLLMClient: %v", err)
+	}
+
+	// logEvent is a message expected in the log, with the fields it should
+	// have. Except for the LLM call, every event has the session_id too.
+	type logEvent struct {
+		msg, level string
+		fields     map[string]any
+		timed      bool
+	}
+	tests := []struct {
+		agentType string
+		want      []logEvent
+	}{
+		{
+			agentType: "askTestAgent",
+			want: []logEvent{
+				{"workload received", "INFO", map[string]any{"agent_type": "askTestAgent"}, false},
+				{"agent dispatched", "DEBUG", map[string]any{"agent_type": "askTestAgent"}, false},
+				{"LLM call finished", "INFO", map[string]any{"model_id": "m1", "prompt_tokens": 10.0}, true},
+				{"workload completed", "INFO", map[string]any{"agent_type": "askTestAgent"}, true},
+			},
+		},
+		{
+			agentType: "failTestAgent",
+			want: []logEvent{
+				{"workload received", "INFO", map[string]any{"agent_type": "failTestAgent"}, false},
+				{"agent dispatched", "DEBUG", map[string]any{"agent_type": "failTestAgent"}, false},
+				{"workload failed", "ERROR", map[string]any{"agent_type": "failTestAgent", "error": "error processing workload: stage broke"}, false},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.agentType, func(t *testing.T) {
+			store := database.NewMemoryDatastore()
+			initTestWorker(t, store)
+			session := addRunningSession(t, store, "s1")
+			session.AgentType = tt.agentType
+			logs := captureLogs(t)
+
+			ProcessWorkloadWithClient(context.Background(), session, llm)
+
+			next := 0
+			for _, event := range logEvents(t, logs) {
+				if next == len(tt.want) || event["msg"] != tt.want[next].msg {
+					continue
+				}
+				want := tt.want[next]
+				if event["level"] != want.level {
+					t.Errorf("%q: level = %v, want %s", want.msg, event["level"], want.level)
+				}
+				for key, value := range want.fields {
+					if event[key] != value {
+						t.Errorf("%q: %s = %v, want %v", want.msg, key, event[key], value)
+					}
+				}
+				if _, ok := want.fields["model_id"]; !ok && event["session_id"] != "s1" {
+					t.Errorf("%q: session_id = %v, want s1", want.msg, event["session_id"])
+				}
+				if _, ok := event["duration"]; ok != want.timed {
+					t.Errorf("%q: has a duration: %v, want %v", want.msg, ok, want.timed)
+				}
+				next++
+			}
+			if next != len(tt.want) {
+				t.Errorf("logged %d of the expected events, missing %q; got:\n%s", next, tt.want[next].msg, logs)
+			}
+		})
+	}
+}
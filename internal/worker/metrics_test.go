@@ -0,0 +1,87 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/nieveai/d-agents/internal/database"
+	"github.com/nieveai/d-agents/internal/metrics"
+	m "github.com/nieveai/d-agents/internal/models"
+)
+
+// metricValue returns the value of the sample of /metrics named series, e.g.
+// `name{label="value"}`, or 0 if there is none yet.
+func metricValue(t *testing.T, series string) float64 {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	for _, line := range strings.Split(rec.Body.String(), "\n") {
+		if value, ok := strings.CutPrefix(line, series+" "); ok {
+			v, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				t.Fatalf("metric %s: %v", series, err)
+			}
+			return v
+		}
+	}
+	return 0
+}
+
+func TestWorkloadMetrics(t *testing.T) {
+	RegisterAgent("askTestAgent", func() (m.AgentInterface, error) { return askAgent{}, nil })
+	failing := false
+	server := newFakeOpenAI(t, func(map[string]any) fakeReply {
+		if failing {
+			return fakeReply{Status: 400}
+		}
+		return fakeReply{Text: "ok"}
+	})
+	llm, err := NewLLMClient(context.Background(), []*m.Model{openaiModel("metricsTestModel", server.URL)})
+	if err != nil {
+		t.Fatalf("NewLLMClient: %v", err)
+	}
+
+	processed := func(status string) string {
+		return fmt.Sprintf(`dagents_workloads_processed_total{agent_type="askTestAgent",status=%q}`, status)
+	}
+	const (
+		calls     = `dagents_llm_request_duration_seconds_count{model="metricsTestModel",provider="openai"}`
+		llmErrors = `dagents_llm_request_errors_total{model="metricsTestModel",provider="openai"}`
+	)
+	tests := []struct {
+		name       string
+		failing    bool
+		wantStatus string
+		wantErrors float64
+	}{
+		{"completed", false, "COMPLETED", 0},
+		{"failed", true, "FAILED", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := database.NewMemoryDatastore()
+			initTestWorker(t, store)
+			session := addRunningSession(t, store, "s1")
+			session.AgentType = "askTestAgent"
+			session.Models = []string{"metricsTestModel"}
+			failing = tt.failing
+
+			beforeProcessed, beforeCalls, beforeErrors := metricValue(t, processed(tt.wantStatus)), metricValue(t, calls), metricValue(t, llmErrors)
+			ProcessWorkloadWithClient(context.Background(), session, llm)
+
+			if got := metricValue(t, processed(tt.wantStatus)) - beforeProcessed; got != 1 {
+				t.Errorf("%s went up by %v, want 1", processed(tt.wantStatus), got)
+			}
+			if got := metricValue(t, calls) - beforeCalls; got != 1 {
+				t.Errorf("LLM calls went up by %v, want 1", got)
+			}
+			if got := metricValue(t, llmErrors) - beforeErrors; got != tt.wantErrors {
+				t.Errorf("LLM errors went up by %v, want %v", got, tt.wantErrors)
+			}
+		})
+	}
+}
@@ -0,0 +1,116 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	m "github.com/nieveai/d-agents/internal/models"
+	"github.com/openai/openai-go/v2"
+	openai_option "github.com/openai/openai-go/v2/option"
+	"google.golang.org/genai"
+)
+
+// modelTestTimeout bounds TestModel so a black-holed endpoint doesn't hang the UI.
+const modelTestTimeout = 15 * time.Second
+
+// Errors TestModel and the LLMClient calls wrap, so callers can tell what to
+// fix.
+var (
+	ErrModelAuth        = errors.New("authentication failed, check the API key")
+	ErrModelNotFound    = errors.New("model not found, check the model ID")
+	ErrModelUnreachable = errors.New("endpoint unreachable, check the API URL and network")
+)
+
+// TestModel checks that model's endpoint, API key and model ID work by
+// looking the model up on the provider, which costs no tokens. Azure can't
+// look up deployments and custom APIs have no lookup, so they get a one word
+// prompt instead.
+func TestModel(ctx context.Context, model *m.Model) error {
+	ctx, cancel := context.WithTimeout(ctx, modelTestTimeout)
+	defer cancel()
+
+	client, err := newProviderClient(ctx, model)
+	if err != nil {
+		return classifyModelError(err)
+	}
+	switch c := client.(type) {
+	case *genai.Client:
+		_, err = c.Models.Get(ctx, model.ModelID, nil)
+	case *openai.Client:
+		// Retries only delay the answer here.
+		noRetries := openai_option.WithMaxRetries(0)
+		if model.APISpec == "azure" {
+			_, err = c.Chat.Completions.New(ctx, openai.ChatCompletionNewParams{
+				Model:    openai.ChatModel(model.ModelID),
+				Messages: []openai.ChatCompletionMessageParamUnion{openai.UserMessage("ping")},
+			}, noRetries)
+		} else {
+			_, err = c.Models.Get(ctx, model.ModelID, noRetries)
+		}
+	case *customClient:
+		_, err = c.generate(ctx, userMessage("ping"), "")
+	default:
+		return fmt.Errorf("unknown client type for model '%s'", model.ID)
+	}
+	return classifyModelError(err)
+}
+
+// classifyModelError wraps err with one of the ErrModel errors when it can
+// tell auth, lookup and network failures apart.
+func classifyModelError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	status := providerStatus(err)
+	// Gemini answers a bad key with 400 INVALID_ARGUMENT.
+	var geminiErr genai.APIError
+	if errors.As(err, &geminiErr) && strings.Contains(geminiErr.Message, "API key") {
+		status = http.StatusUnauthorized
+	}
+	switch status {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return fmt.Errorf("%w: %w", ErrModelAuth, err)
+	case http.StatusNotFound:
+		return fmt.Errorf("%w: %w", ErrModelNotFound, err)
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("%w: %w", ErrModelUnreachable, err)
+	}
+	return err
+}
+
+// providerStatus returns the HTTP status of a provider API error, or 0 if err
+// isn't one.
+func providerStatus(err error) int {
+	var geminiErr genai.APIError
+	var openaiErr *openai.Error
+	var customErr *customAPIError
+	switch {
+	case errors.As(err, &geminiErr):
+		return geminiErr.Code
+	case errors.As(err, &openaiErr):
+		return openaiErr.StatusCode
+	case errors.As(err, &customErr):
+		return customErr.StatusCode
+	}
+	return 0
+}
+
+// retryableModelError reports whether err is a rate limit or a transient
+// failure, which asking the same model again may get past.
+func retryableModelError(err error) bool {
+	switch providerStatus(err) {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests, http.StatusInternalServerError,
+		http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return errors.Is(err, ErrModelUnreachable)
+}
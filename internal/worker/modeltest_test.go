@@ -0,0 +1,154 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	m "github.com/nieveai/d-agents/internal/models"
+	"google.golang.org/genai"
+)
+
+// newModelServer returns a server answering every request with status, and
+// the paths it was asked for.
+func newModelServer(t *testing.T, status int) (*httptest.Server, *[]string) {
+	t.Helper()
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.Method+" "+r.URL.Path)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		if status != http.StatusOK {
+			fmt.Fprintf(w, `{"error": {"message": "fake error %d", "type": "error"}}`, status)
+			return
+		}
+		fmt.Fprint(w, `{"id": "gpt-test", "object": "model", "text": "pong",
+			"choices": [{"index": 0, "message": {"role": "assistant", "content": "pong"}, "finish_reason": "stop"}]}`)
+	}))
+	t.Cleanup(server.Close)
+	return server, &paths
+}
+
+func TestTestModel(t *testing.T) {
+	tests := []struct {
+		name     string
+		model    func(url string) *m.Model
+		status   int
+		want     error
+		wantPath string
+	}{
+		{
+			name:     "openai ok",
+			model:    func(url string) *m.Model { return openaiModel("m1", url) },
+			status:   http.StatusOK,
+			wantPath: "GET /models/gpt-test",
+		},
+		{
+			name:   "openai bad key",
+			model:  func(url string) *m.Model { return openaiModel("m1", url) },
+			status: http.StatusUnauthorized,
+			want:   ErrModelAuth,
+		},
+		{
+			name:   "openai unknown model",
+			model:  func(url string) *m.Model { return openaiModel("m1", url) },
+			status: http.StatusNotFound,
+			want:   ErrModelNotFound,
+		},
+		{
+			name: "azure pings the deployment",
+			model: func(url string) *m.Model {
+				return &m.Model{ID: "m1", APISpec: "azure", ModelID: "gpt-deploy", APIURL: url, APIVersion: "2024-06-01", APIKey: "test-key"}
+			},
+			status:   http.StatusOK,
+			wantPath: "POST /openai/deployments/gpt-deploy/chat/completions",
+		},
+		{
+			name: "azure forbidden",
+			model: func(url string) *m.Model {
+				return &m.Model{ID: "m1", APISpec: "azure", ModelID: "gpt-deploy", APIURL: url, APIVersion: "2024-06-01", APIKey: "test-key"}
+			},
+			status: http.StatusForbidden,
+			want:   ErrModelAuth,
+		},
+		{
+			name: "custom",
+			model: func(url string) *m.Model {
+				return &m.Model{ID: "m1", APISpec: "custom", ModelID: "x", APIURL: url, RequestTemplate: `{"prompt": {{json .Input}}}`, ResponsePath: "text"}
+			},
+			status:   http.StatusOK,
+			wantPath: "POST /",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, paths := newModelServer(t, tt.status)
+			err := TestModel(context.Background(), tt.model(server.URL))
+			if tt.want == nil && err != nil {
+				t.Fatalf("TestModel: %v", err)
+			}
+			if !errors.Is(err, tt.want) {
+				t.Errorf("TestModel = %v, want %v", err, tt.want)
+			}
+			// Only one request, without retries.
+			if len(*paths) != 1 {
+				t.Errorf("requests = %q, want one", *paths)
+			}
+			if tt.wantPath != "" && len(*paths) > 0 && (*paths)[0] != tt.wantPath {
+				t.Errorf("request = %q, want %q", (*paths)[0], tt.wantPath)
+			}
+		})
+	}
+}
+
+func TestTestModelUnreachable(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	url := server.URL
+	server.Close()
+
+	err := TestModel(context.Background(), openaiModel("m1", url))
+	if !errors.Is(err, ErrModelUnreachable) {
+		t.Errorf("TestModel of a closed server = %v, want ErrModelUnreachable", err)
+	}
+	if errors.Is(err, ErrModelAuth) {
+		t.Errorf("a network failure was reported as an auth error: %v", err)
+	}
+}
+
+func TestClassifyModelError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{"gemini bad key", genai.APIError{Code: 400, Message: "API key not valid. Please pass a valid API key.", Status: "INVALID_ARGUMENT"}, ErrModelAuth},
+		{"gemini permission", genai.APIError{Code: 403, Message: "denied", Status: "PERMISSION_DENIED"}, ErrModelAuth},
+		{"gemini unknown model", genai.APIError{Code: 404, Message: "models/nope is not found", Status: "NOT_FOUND"}, ErrModelNotFound},
+		{"gemini other bad request", genai.APIError{Code: 400, Message: "bad schema", Status: "INVALID_ARGUMENT"}, nil},
+		{"custom unauthorized", &customAPIError{StatusCode: http.StatusUnauthorized}, ErrModelAuth},
+		{"deadline", fmt.Errorf("calling model: %w", context.DeadlineExceeded), ErrModelUnreachable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := classifyModelError(tt.err)
+			if !strings.Contains(got.Error(), tt.err.Error()) || providerStatus(got) != providerStatus(tt.err) {
+				t.Errorf("classifyModelError(%v) = %v, lost the original error", tt.err, got)
+			}
+			for _, sentinel := range []error{ErrModelAuth, ErrModelNotFound, ErrModelUnreachable} {
+				if errors.Is(got, sentinel) != (sentinel == tt.want) {
+					t.Errorf("classifyModelError(%v) = %v, want %v", tt.err, got, tt.want)
+				}
+			}
+			if tt.want == nil && strings.Contains(got.Error(), "check the") {
+				t.Errorf("classifyModelError(%v) = %v, want it unchanged", tt.err, got)
+			}
+		})
+	}
+	if classifyModelError(nil) != nil {
+		t.Error("classifyModelError(nil) isn't nil")
+	}
+}
@@ -0,0 +1,108 @@
+package worker
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	m "github.com/nieveai/d-agents/internal/models"
+	pb "github.com/nieveai/d-agents/proto"
+)
+
+// tinyPNG is a 1x1 transparent PNG.
+var tinyPNG, _ = base64.StdEncoding.DecodeString("iVBORw0KGgoAAAANSUhEUgAAAAEAAAABCAYAAAAfFcSJAAAADUlEQVR42mNkYPhfDwAChwGA60e6kgAAAABJRU5ErkJggg==")
+
+func TestGenerateContentMultimodal(t *testing.T) {
+	workload := &pb.Workload{Id: "s1", Models: []string{"m1"}}
+	t.Run("openai", func(t *testing.T) {
+		server := newFakeOpenAI(t, func(map[string]any) fakeReply { return fakeReply{Text: "a dot"} })
+		llm, err := NewLLMClient(context.Background(), []*m.Model{openaiModel("m1", server.URL)})
+		if err != nil {
+			t.Fatalf("NewLLMClient: %v", err)
+		}
+		// The type is detected when not given.
+		text, err := llm.GenerateContentMultimodal(context.Background(), workload, "what is this?", [][]byte{tinyPNG}, "", "be brief")
+		if err != nil || text != "a dot" {
+			t.Fatalf("GenerateContentMultimodal = %q, %v", text, err)
+		}
+
+		messages := server.Requests()[0]["messages"].([]any)
+		if len(messages) != 2 {
+			t.Fatalf("messages = %v, want the system prompt and the user's", messages)
+		}
+		parts, ok := messages[1].(map[string]any)["content"].([]any)
+		if !ok || len(parts) != 2 {
+			t.Fatalf("content = %v, want a text and an image part", messages[1])
+		}
+		if part := parts[0].(map[string]any); part["type"] != "text" || part["text"] != "what is this?" {
+			t.Errorf("first part = %v, want the prompt", part)
+		}
+		want := "data:image/png;base64," + base64.StdEncoding.EncodeToString(tinyPNG)
+		part := parts[1].(map[string]any)
+		if url, _ := part["image_url"].(map[string]any); part["type"] != "image_url" || url["url"] != want {
+			t.Errorf("second part = %v, want the PNG as a data URL", part)
+		}
+	})
+	t.Run("gemini", func(t *testing.T) {
+		server := newFakeGemini(t, "a dot")
+		llm := newGeminiLLMClient(t, server, geminiModel("m1"))
+		text, err := llm.GenerateContentMultimodal(context.Background(), workload, "what is this?", [][]byte{tinyPNG, tinyPNG}, "image/png", "")
+		if err != nil || text != "a dot" {
+			t.Fatalf("GenerateContentMultimodal = %q, %v", text, err)
+		}
+
+		contents := server.Requests()[0]["contents"].([]any)
+		parts := contents[0].(map[string]any)["parts"].([]any)
+		if len(parts) != 3 || parts[0].(map[string]any)["text"] != "what is this?" {
+			t.Fatalf("parts = %v, want the prompt and two images", parts)
+		}
+		for _, part := range parts[1:] {
+			data, _ := part.(map[string]any)["inlineData"].(map[string]any)
+			if data["mimeType"] != "image/png" || data["data"] != base64.StdEncoding.EncodeToString(tinyPNG) {
+				t.Errorf("part = %v, want the PNG inline", part)
+			}
+		}
+	})
+}
+
+func TestGenerateContentMultimodalErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("a model without vision was called")
+	}))
+	defer server.Close()
+	custom := &m.Model{ID: "custom", ModelID: "text-only", APISpec: "custom", APIURL: server.URL,
+		RequestTemplate: `{"prompt": {{json .Input}}}`, ResponsePath: "$.text"}
+	llm, err := NewLLMClient(context.Background(), []*m.Model{custom})
+	if err != nil {
+		t.Fatalf("NewLLMClient: %v", err)
+	}
+
+	workload := &pb.Workload{Id: "s1", Models: []string{"custom"}}
+	if _, err := llm.GenerateContentMultimodal(context.Background(), workload, "what is this?", [][]byte{tinyPNG}, "", ""); !errors.Is(err, m.ErrImagesNotSupported) {
+		t.Errorf("GenerateContentMultimodal with a custom model = %v, want ErrImagesNotSupported", err)
+	}
+
+	tests := []struct {
+		name    string
+		images  [][]byte
+		mime    string
+		wantErr string
+	}{
+		{"empty image", [][]byte{tinyPNG, nil}, "", "image 2 is empty"},
+		{"not an image", [][]byte{[]byte("just some text")}, "", "image 1 is text/plain"},
+		{"wrong type given", [][]byte{tinyPNG}, "application/pdf", "not an image"},
+	}
+	for _, tt := range tests {
+		_, err := llm.GenerateContentMultimodal(context.Background(), workload, "what is this?", tt.images, tt.mime, "")
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s: GenerateContentMultimodal = %v, want %q", tt.name, err, tt.wantErr)
+		}
+	}
+	if _, err := llm.GenerateContentMultimodal(context.Background(), &pb.Workload{}, "hi", [][]byte{tinyPNG}, "", ""); !errors.Is(err, m.ErrNoModelsSpecified) {
+		t.Errorf("GenerateContentMultimodal without models = %v", err)
+	}
+}
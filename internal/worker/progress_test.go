@@ -0,0 +1,89 @@
+package worker
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/nieveai/d-agents/internal/database"
+	m "github.com/nieveai/d-agents/internal/models"
+	"github.com/nieveai/d-agents/internal/testutil"
+	pb "github.com/nieveai/d-agents/proto"
+)
+
+// progressAgent reports the progress in steps, and records the progress the
+// session has after each.
+type progressAgent struct {
+	steps      []int32
+	onProgress func(id string, pct int32)
+	stored     *[]int32
+}
+
+func (a *progressAgent) SetOnProgress(fn func(id string, pct int32)) { a.onProgress = fn }
+
+func (a *progressAgent) DoWork(ctx context.Context, workload *pb.Workload, client m.GenAIClient) error {
+	for _, pct := range a.steps {
+		a.onProgress(workload.Id, pct)
+		session, err := db.GetSession(workload.Id)
+		if err != nil {
+			return err
+		}
+		*a.stored = append(*a.stored, session.Progress)
+	}
+	return nil
+}
+
+func TestReportedProgress(t *testing.T) {
+	tests := []struct {
+		name     string
+		steps    []int32
+		pipeline []string
+		want     []int32
+	}{
+		{
+			name:  "goes up",
+			steps: []int32{10, 50, 100},
+			want:  []int32{10, 50, 100},
+		},
+		{
+			name:  "never goes back",
+			steps: []int32{60, 30, 80},
+			want:  []int32{60, 60, 80},
+		},
+		{
+			name:  "clamped",
+			steps: []int32{-5, 150},
+			want:  []int32{0, 100},
+		},
+		{
+			name:     "each stage gets its share of a pipeline",
+			steps:    []int32{50, 100},
+			pipeline: []string{"progressTestAgent", "progressTestAgent"},
+			want:     []int32{25, 50, 75, 100},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := database.NewMemoryDatastore()
+			initTestWorker(t, store)
+			var stored []int32
+			RegisterAgent("progressTestAgent", func() (m.AgentInterface, error) {
+				return &progressAgent{steps: tt.steps, stored: &stored}, nil
+			})
+			session := addRunningSession(t, store, "s1")
+			session.AgentType = "progressTestAgent"
+			session.Pipeline = tt.pipeline
+
+			ProcessWorkloadWithClient(context.Background(), session, testutil.NewFakeGenAIClient())
+			if !slices.Equal(stored, tt.want) {
+				t.Errorf("stored progress = %v, want %v", stored, tt.want)
+			}
+		})
+	}
+}
+
+func TestReportProgressWithoutDatastore(t *testing.T) {
+	initTestWorker(t, nil)
+	// Remote workers have no datastore, this mustn't panic.
+	ReportProgress("s1", 50)
+}
@@ -0,0 +1,332 @@
+package worker
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	m "github.com/nieveai/d-agents/internal/models"
+	pb "github.com/nieveai/d-agents/proto"
+)
+
+const (
+	// DefaultQueueDepth is the default capacity of the workload queue.
+	DefaultQueueDepth = 100
+	// DefaultMaxRetries is the default number of retries for a failing workload.
+	DefaultMaxRetries = 2
+)
+
+var (
+	maxRetries = DefaultMaxRetries
+	retryDelay = 5 * time.Second
+)
+
+// Priorities offered by the controllers. Any other value works too.
+const (
+	PriorityLow    int32 = -10
+	PriorityNormal int32 = 0
+	PriorityHigh   int32 = 10
+)
+
+// PriorityNames are the names ParsePriority takes, lowest first.
+var PriorityNames = []string{"low", "normal", "high"}
+
+// ParsePriority reads a priority given by name or as a number.
+func ParsePriority(s string) (int32, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "low":
+		return PriorityLow, nil
+	case "normal":
+		return PriorityNormal, nil
+	case "high":
+		return PriorityHigh, nil
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(s), 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid priority %q: use %s or a number", s, strings.Join(PriorityNames, ", "))
+	}
+	return int32(n), nil
+}
+
+// PriorityName returns the name of priority, or the number when it has none.
+func PriorityName(priority int32) string {
+	switch priority {
+	case PriorityLow:
+		return "low"
+	case PriorityNormal:
+		return "normal"
+	case PriorityHigh:
+		return "high"
+	}
+	return strconv.Itoa(int(priority))
+}
+
+// Queue holds workloads waiting for a worker. Pop hands out the one with the
+// highest Priority first, and among equal priorities the oldest by Timestamp,
+// then the first pushed. Push blocks while the queue is full.
+type Queue struct {
+	mu    sync.Mutex
+	items workloadHeap
+	seq   uint64
+	// slots has a token for every workload in the queue, so a full queue
+	// blocks Push.
+	slots chan struct{}
+	// added is closed and replaced whenever a workload is added, waking the
+	// Pops waiting for one.
+	added chan struct{}
+}
+
+// NewQueue returns a queue that holds at most depth workloads.
+func NewQueue(depth int) *Queue {
+	depth = max(depth, 1)
+	return &Queue{slots: make(chan struct{}, depth), added: make(chan struct{})}
+}
+
+// Push adds the workload, waiting for room if the queue is full.
+func (q *Queue) Push(workload *pb.Workload) {
+	q.slots <- struct{}{}
+	q.add(workload)
+}
+
+// TryPush adds the workload unless the queue is full, and reports whether it
+// did.
+func (q *Queue) TryPush(workload *pb.Workload) bool {
+	select {
+	case q.slots <- struct{}{}:
+		q.add(workload)
+		return true
+	default:
+		return false
+	}
+}
+
+func (q *Queue) add(workload *pb.Workload) {
+	q.mu.Lock()
+	q.seq++
+	heap.Push(&q.items, queuedWorkload{workload: workload, seq: q.seq})
+	close(q.added)
+	q.added = make(chan struct{})
+	q.mu.Unlock()
+}
+
+// Pop waits for a workload and takes the first one in line. ok is false when
+// ctx is done first.
+func (q *Queue) Pop(ctx context.Context) (workload *pb.Workload, ok bool) {
+	workload, _, ok = q.pop(ctx, nil)
+	return workload, ok
+}
+
+// popWithSlots is Pop for the workers of this process. It takes the first
+// workload in line whose agents are under their limits and reserves slots for
+// them, see reserveAgents. Workloads over a limit stay queued and the ones
+// behind them go first. The returned func frees the slots.
+func (q *Queue) popWithSlots(ctx context.Context) (*pb.Workload, func(), bool) {
+	return q.pop(ctx, reserveAgents)
+}
+
+func (q *Queue) pop(ctx context.Context, reserve func(*pb.Workload) (func(), bool)) (*pb.Workload, func(), bool) {
+	for {
+		// Taken before looking, so a slot freed meanwhile isn't missed.
+		freed := agentSlotsFreed()
+		q.mu.Lock()
+		workload, release, ok := q.take(reserve)
+		added := q.added
+		q.mu.Unlock()
+		if ok {
+			<-q.slots
+			return workload, release, true
+		}
+		select {
+		case <-added:
+		case <-freed:
+		case <-ctx.Done():
+			return nil, nil, false
+		}
+	}
+}
+
+// take removes the first workload in line that reserve accepts, or the first
+// one when reserve is nil.
+func (q *Queue) take(reserve func(*pb.Workload) (func(), bool)) (*pb.Workload, func(), bool) {
+	if len(q.items) == 0 {
+		return nil, nil, false
+	}
+	if reserve == nil {
+		return heap.Pop(&q.items).(queuedWorkload).workload, func() {}, true
+	}
+	// The heap only keeps the first in line in order.
+	order := make([]int, len(q.items))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(a, b int) bool { return q.items.Less(order[a], order[b]) })
+	for _, i := range order {
+		if release, ok := reserve(q.items[i].workload); ok {
+			return heap.Remove(&q.items, i).(queuedWorkload).workload, release, true
+		}
+	}
+	return nil, nil, false
+}
+
+// Len returns the number of workloads waiting.
+func (q *Queue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.items)
+}
+
+type queuedWorkload struct {
+	workload *pb.Workload
+	seq      uint64
+}
+
+// workloadHeap implements heap.Interface, with the next workload to run first.
+type workloadHeap []queuedWorkload
+
+func (h workloadHeap) Len() int { return len(h) }
+
+func (h workloadHeap) Less(i, j int) bool {
+	a, b := h[i].workload, h[j].workload
+	if a.Priority != b.Priority {
+		return a.Priority > b.Priority
+	}
+	if a.Timestamp != b.Timestamp {
+		return a.Timestamp < b.Timestamp
+	}
+	return h[i].seq < h[j].seq
+}
+
+func (h workloadHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *workloadHeap) Push(x any) { *h = append(*h, x.(queuedWorkload)) }
+
+func (h *workloadHeap) Pop() any {
+	old := *h
+	item := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return item
+}
+
+// SetMaxRetries sets how many times a failing workload is retried before it is
+// marked FAILED.
+func SetMaxRetries(n int) {
+	if n < 0 {
+		n = 0
+	}
+	maxRetries = n
+}
+
+// runWithRetries runs the workload's agent, retrying failures until the
+// workload's retry count reaches maxRetries. The retry count is persisted so a
+// workload recovered after a crash doesn't start over.
+func runWithRetries(ctx context.Context, workload *pb.Workload, client m.GenAIClient) error {
+	// Every attempt starts from the original payload, so a failed pipeline
+	// doesn't feed its half-finished output back into the first stage.
+	payload := workload.Payload
+	for {
+		workload.Payload = payload
+		err := runAgent(ctx, workload, client)
+		if err == nil {
+			return nil
+		}
+		// Nothing left to retry with once the context is done.
+		if ctx.Err() != nil {
+			return err
+		}
+		if permanentError(err) {
+			slog.Warn("workload failed, not retrying", "session_id", workload.Id, "error", err)
+			return err
+		}
+		if int(workload.RetryCount) >= maxRetries {
+			if maxRetries > 0 {
+				return fmt.Errorf("%w (gave up after %d retries)", err, workload.RetryCount)
+			}
+			return err
+		}
+
+		workload.RetryCount++
+		slog.Warn("workload failed, retrying", "session_id", workload.Id, "attempt", workload.RetryCount, "max_retries", maxRetries, "error", err)
+		saveRetryCount(workload)
+
+		select {
+		case <-time.After(retryDelay * time.Duration(workload.RetryCount)):
+		case <-ctx.Done():
+			return err
+		}
+	}
+}
+
+// permanentError reports whether err would only happen again on a retry, as
+// it needs the model or the workload fixed first.
+func permanentError(err error) bool {
+	return errors.Is(err, ErrModelAuth) ||
+		errors.Is(err, ErrModelNotFound) ||
+		errors.Is(err, m.ErrNoModelsSpecified) ||
+		errors.Is(err, m.ErrImagesNotSupported) ||
+		errors.Is(err, m.ErrToolsNotSupported) ||
+		errors.Is(err, m.ErrInputTooLarge)
+}
+
+func saveRetryCount(workload *pb.Workload) {
+	if controller != nil {
+		sendUpdate(workload)
+		return
+	}
+	if db == nil {
+		return
+	}
+	session, err := db.GetSession(workload.Id)
+	if err != nil {
+		slog.Error("error getting session from db", "session_id", workload.Id, "error", err)
+		return
+	}
+	session.RetryCount = workload.RetryCount
+	if err := db.AddSession(session); err != nil {
+		slog.Error("error saving retry count", "session_id", workload.Id, "error", err)
+	}
+}
+
+// RecoverWorkloads re-enqueues sessions left RUNNING by a previous process,
+// e.g. after a crash, and returns them. Controllers mark a session RUNNING
+// before queueing it, so this covers both queued and in-flight workloads;
+// PENDING sessions are drafts that were never submitted and are left alone.
+// The sessions are sent from a goroutine so a full queue doesn't block
+// startup.
+func RecoverWorkloads(queue *Queue) ([]*pb.Workload, error) {
+	sessions, err := db.ListSessions()
+	if err != nil {
+		return nil, fmt.Errorf("error loading sessions: %w", err)
+	}
+
+	var recovered []*pb.Workload
+	for _, session := range sessions {
+		if session.Status != pb.WorkloadStatus_RUNNING {
+			continue
+		}
+		// Queued again, so the reaper mustn't go by the old heartbeat.
+		if session.LastHeartbeat != 0 {
+			session.LastHeartbeat = 0
+			if err := db.AddSession(session); err != nil {
+				return nil, fmt.Errorf("error resetting heartbeat of session %s: %w", session.Id, err)
+			}
+		}
+		recovered = append(recovered, session)
+	}
+
+	if len(recovered) > 0 {
+		slog.Info("re-enqueueing interrupted workloads", "count", len(recovered))
+		go func() {
+			for _, session := range recovered {
+				queue.Push(session)
+			}
+		}()
+	}
+	return recovered, nil
+}
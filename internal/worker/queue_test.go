@@ -0,0 +1,293 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/nieveai/d-agents/internal/database"
+	m "github.com/nieveai/d-agents/internal/models"
+	"github.com/nieveai/d-agents/internal/testutil"
+	pb "github.com/nieveai/d-agents/proto"
+)
+
+func TestRecoverWorkloads(t *testing.T) {
+	store := database.NewMemoryDatastore()
+	initTestWorker(t, store)
+	sessions := []*pb.Workload{
+		{Id: "running", Status: pb.WorkloadStatus_RUNNING, Payload: []byte("hi"), LastHeartbeat: time.Now().Unix()},
+		{Id: "queued", Status: pb.WorkloadStatus_RUNNING, Payload: []byte("hi")},
+		// Saved drafts and clones that were never submitted.
+		{Id: "draft", Status: pb.WorkloadStatus_PENDING, Payload: []byte("hi")},
+		{Id: "empty", Status: pb.WorkloadStatus_PENDING},
+		{Id: "completed", Status: pb.WorkloadStatus_COMPLETED, Payload: []byte("hi")},
+		{Id: "failed", Status: pb.WorkloadStatus_FAILED, Payload: []byte("hi")},
+		{Id: "cancelled", Status: pb.WorkloadStatus_CANCELLED, Payload: []byte("hi")},
+	}
+	for _, session := range sessions {
+		if err := store.AddSession(session); err != nil {
+			t.Fatalf("AddSession: %v", err)
+		}
+	}
+
+	queue := NewQueue(10)
+	recovered, err := RecoverWorkloads(queue)
+	if err != nil {
+		t.Fatalf("RecoverWorkloads: %v", err)
+	}
+	if len(recovered) != 2 {
+		t.Fatalf("recovered %d sessions, want 2", len(recovered))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	queued := make(map[string]bool)
+	for range 2 {
+		workload, ok := queue.Pop(ctx)
+		if !ok {
+			t.Fatal("the recovered sessions weren't queued")
+		}
+		queued[workload.Id] = true
+	}
+	if !queued["running"] || !queued["queued"] {
+		t.Errorf("queued %v, want running and queued", queued)
+	}
+	if n := queue.Len(); n != 0 {
+		t.Errorf("%d more sessions queued", n)
+	}
+	for _, id := range []string{"running", "queued"} {
+		session, err := store.GetSession(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if session.Status != pb.WorkloadStatus_RUNNING || session.LastHeartbeat != 0 {
+			t.Errorf("%s: status %v, heartbeat %d; want RUNNING without heartbeat", id, session.Status, session.LastHeartbeat)
+		}
+	}
+	for _, id := range []string{"draft", "empty"} {
+		if session, _ := store.GetSession(id); session.Status != pb.WorkloadStatus_PENDING {
+			t.Errorf("%s: status = %v, want it left PENDING", id, session.Status)
+		}
+	}
+}
+
+func TestRetriesKeepTheCount(t *testing.T) {
+	store := database.NewMemoryDatastore()
+	initTestWorker(t, store)
+	maxRetries = 2
+	oldDelay := retryDelay
+	retryDelay = time.Millisecond
+	t.Cleanup(func() { retryDelay = oldDelay })
+
+	tests := []struct {
+		name       string
+		failures   int
+		wantStatus pb.WorkloadStatus_Status
+		wantCount  int32
+	}{
+		{"succeeds first time", 0, pb.WorkloadStatus_COMPLETED, 0},
+		{"succeeds on a retry", 2, pb.WorkloadStatus_COMPLETED, 2},
+		{"retries exhausted", 3, pb.WorkloadStatus_FAILED, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			session := addRunningSession(t, store, tt.name)
+			client := testutil.NewFakeGenAIClient()
+			for range tt.failures {
+				client.Fail(errors.New("temporarily down"))
+			}
+			client.Respond("done")
+
+			ProcessWorkloadWithClient(context.Background(), session, client)
+			got, err := store.GetSession(tt.name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Status != tt.wantStatus || got.RetryCount != tt.wantCount {
+				t.Errorf("session = %v after %d retries, want %v after %d", got.Status, got.RetryCount, tt.wantStatus, tt.wantCount)
+			}
+		})
+	}
+}
+
+func TestPermanentErrorsAreNotRetried(t *testing.T) {
+	store := database.NewMemoryDatastore()
+	initTestWorker(t, store)
+	maxRetries = 2
+	oldDelay := retryDelay
+	retryDelay = time.Millisecond
+	t.Cleanup(func() { retryDelay = oldDelay })
+
+	for _, sentinel := range []error{ErrModelAuth, ErrModelNotFound, m.ErrNoModelsSpecified, m.ErrImagesNotSupported, m.ErrToolsNotSupported, m.ErrInputTooLarge} {
+		t.Run(sentinel.Error(), func(t *testing.T) {
+			session := addRunningSession(t, store, "s1")
+			client := testutil.NewFakeGenAIClient().Fail(fmt.Errorf("calling m1: %w", sentinel)).Respond("too late")
+
+			ProcessWorkloadWithClient(context.Background(), session, client)
+			if calls := len(client.Calls()); calls != 1 {
+				t.Errorf("the agent ran %d times, want once", calls)
+			}
+			if got, _ := store.GetSession("s1"); got.Status != pb.WorkloadStatus_FAILED || got.RetryCount != 0 {
+				t.Errorf("session = %v after %d retries, want FAILED without retries", got.Status, got.RetryCount)
+			}
+		})
+	}
+	// Anything else is worth another try.
+	if permanentError(m.ErrInvalidResponse) || permanentError(ErrModelUnreachable) {
+		t.Error("invalid responses and unreachable models aren't retried")
+	}
+}
+
+func TestRecoveredRetryCountIsKept(t *testing.T) {
+	store := database.NewMemoryDatastore()
+	initTestWorker(t, store)
+	maxRetries = 2
+	// A session that already used up its retries before the crash.
+	session := addRunningSession(t, store, "s1")
+	session.RetryCount = 2
+	if err := store.AddSession(session); err != nil {
+		t.Fatal(err)
+	}
+
+	recovered, err := RecoverWorkloads(NewQueue(1))
+	if err != nil || len(recovered) != 1 {
+		t.Fatalf("RecoverWorkloads = %v, %v", recovered, err)
+	}
+	client := testutil.NewFakeGenAIClient().Fail(errors.New("still down"))
+	ProcessWorkloadWithClient(context.Background(), recovered[0], client)
+
+	if calls := len(client.Calls()); calls != 1 {
+		t.Errorf("the agent ran %d times, want once", calls)
+	}
+	if got, _ := store.GetSession("s1"); got.Status != pb.WorkloadStatus_FAILED {
+		t.Errorf("status = %v, want FAILED", got.Status)
+	}
+}
+
+func TestQueueDepth(t *testing.T) {
+	queue := NewQueue(2)
+	for i, want := range []bool{true, true, false} {
+		if got := queue.TryPush(&pb.Workload{Id: "w"}); got != want {
+			t.Errorf("push %d = %v, want %v", i+1, got, want)
+		}
+	}
+	if queue.Len() != 2 {
+		t.Errorf("Len = %d, want 2", queue.Len())
+	}
+	if _, ok := queue.Pop(context.Background()); !ok {
+		t.Fatal("Pop failed")
+	}
+	if !queue.TryPush(&pb.Workload{Id: "w"}) {
+		t.Error("no room after a Pop")
+	}
+}
+
+func TestQueuePriority(t *testing.T) {
+	queue := NewQueue(10)
+	for _, w := range []*pb.Workload{
+		{Id: "batch", Priority: PriorityLow, Timestamp: 1},
+		{Id: "normal-late", Timestamp: 5},
+		{Id: "chat", Priority: PriorityHigh, Timestamp: 9},
+		{Id: "normal-early", Timestamp: 2},
+		{Id: "normal-late-again", Timestamp: 5},
+		{Id: "urgent", Priority: 50, Timestamp: 10},
+	} {
+		queue.Push(w)
+	}
+
+	var got []string
+	for queue.Len() > 0 {
+		w, ok := queue.Pop(context.Background())
+		if !ok {
+			t.Fatal("Pop failed")
+		}
+		got = append(got, w.Id)
+	}
+	want := []string{"urgent", "chat", "normal-early", "normal-late", "normal-late-again", "batch"}
+	if !slices.Equal(got, want) {
+		t.Errorf("popped %v, want %v", got, want)
+	}
+}
+
+// orderAgent sends the id of each workload it runs.
+type orderAgent struct{ ran chan<- string }
+
+func (a orderAgent) DoWork(ctx context.Context, workload *pb.Workload, client m.GenAIClient) error {
+	a.ran <- workload.Id
+	return nil
+}
+
+func TestWorkersTakeTheHighestPriorityFirst(t *testing.T) {
+	store := database.NewMemoryDatastore()
+	initTestWorker(t, store)
+	ran := make(chan string, 10)
+	RegisterAgent("orderTestAgent", func() (m.AgentInterface, error) { return orderAgent{ran}, nil })
+
+	queue := NewQueue(10)
+	priorities := map[string]int32{"low": PriorityLow, "normal": PriorityNormal, "high": PriorityHigh}
+	for _, id := range []string{"low", "normal", "high"} {
+		session := addRunningSession(t, store, id)
+		session.AgentType = "orderTestAgent"
+		session.Priority = priorities[id]
+		queue.Push(session)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	RunWorkers(ctx, 1, queue)
+
+	var got []string
+	for range 3 {
+		select {
+		case id := <-ran:
+			got = append(got, id)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("ran only %v", got)
+		}
+	}
+	if want := []string{"high", "normal", "low"}; !slices.Equal(got, want) {
+		t.Errorf("ran %v, want %v", got, want)
+	}
+}
+
+func TestQueuePopCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, ok := NewQueue(1).Pop(ctx); ok {
+		t.Error("Pop of an empty queue succeeded with a cancelled context")
+	}
+}
+
+func TestParsePriority(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int32
+		ok   bool
+	}{
+		{"high", PriorityHigh, true},
+		{" Low ", PriorityLow, true},
+		{"normal", PriorityNormal, true},
+		{"25", 25, true},
+		{"-3", -3, true},
+		{"urgent", 0, false},
+		{"99999999999", 0, false},
+	}
+	for _, tt := range tests {
+		got, err := ParsePriority(tt.in)
+		if got != tt.want || (err == nil) != tt.ok {
+			t.Errorf("ParsePriority(%q) = %d, %v, want %d, ok %v", tt.in, got, err, tt.want, tt.ok)
+		}
+	}
+	for _, name := range PriorityNames {
+		p, _ := ParsePriority(name)
+		if PriorityName(p) != name {
+			t.Errorf("PriorityName(%d) = %q, want %q", p, PriorityName(p), name)
+		}
+	}
+	if got := PriorityName(7); got != "7" {
+		t.Errorf("PriorityName(7) = %q", got)
+	}
+}
@@ -0,0 +1,106 @@
+package worker
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	m "github.com/nieveai/d-agents/internal/models"
+	pb "github.com/nieveai/d-agents/proto"
+)
+
+// timedServer is a fake OpenAI server that records when each request came in.
+func timedServer(t *testing.T) (*fakeOpenAI, func() []time.Time) {
+	var mu sync.Mutex
+	var times []time.Time
+	server := newFakeOpenAI(t, func(map[string]any) fakeReply {
+		mu.Lock()
+		times = append(times, time.Now())
+		mu.Unlock()
+		return fakeReply{Text: "ok"}
+	})
+	return server, func() []time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		sorted := slices.Clone(times)
+		slices.SortFunc(sorted, func(a, b time.Time) int { return a.Compare(b) })
+		return sorted
+	}
+}
+
+func TestModelRPM(t *testing.T) {
+	if testing.Short() {
+		t.Skip("takes a few seconds")
+	}
+	slowServer, slowTimes := timedServer(t)
+	fastServer, fastTimes := timedServer(t)
+	slow := openaiModel("slow", slowServer.URL)
+	slow.RPM = 60
+	llm, err := NewLLMClient(context.Background(), []*m.Model{slow, openaiModel("fast", fastServer.URL)})
+	if err != nil {
+		t.Fatalf("NewLLMClient: %v", err)
+	}
+
+	const calls = 4
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := range 2 * calls {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			workload := &pb.Workload{Id: "s1", Models: []string{[]string{"slow", "fast"}[i%2]}}
+			if _, err := llm.GenerateContentWithSystemPrompt(context.Background(), workload, "hello", ""); err != nil {
+				t.Errorf("GenerateContentWithSystemPrompt: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// 60 RPM is a call a second.
+	times := slowTimes()
+	if len(times) != calls {
+		t.Fatalf("%d calls to the limited model, want %d", len(times), calls)
+	}
+	for i := 1; i < len(times); i++ {
+		if gap := times[i].Sub(times[i-1]); gap < 900*time.Millisecond || gap > 2*time.Second {
+			t.Errorf("call %d came %s after the one before, want about a second", i+1, gap)
+		}
+	}
+	// The unlimited model isn't held up by the limited one.
+	for _, at := range fastTimes() {
+		if elapsed := at.Sub(start); elapsed > 500*time.Millisecond {
+			t.Errorf("a call to the unlimited model waited %s", elapsed)
+		}
+	}
+}
+
+func TestModelRPMWaitIsCancellable(t *testing.T) {
+	server, times := timedServer(t)
+	model := openaiModel("m1", server.URL)
+	model.RPM = 1
+	llm, err := NewLLMClient(context.Background(), []*m.Model{model})
+	if err != nil {
+		t.Fatalf("NewLLMClient: %v", err)
+	}
+	workload := &pb.Workload{Id: "s1", Models: []string{"m1"}}
+	if _, err := llm.GenerateContentWithSystemPrompt(context.Background(), workload, "first", ""); err != nil {
+		t.Fatalf("GenerateContentWithSystemPrompt: %v", err)
+	}
+
+	// The next call isn't allowed for a minute.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := llm.GenerateContentWithSystemPrompt(ctx, workload, "second", ""); err == nil || !strings.Contains(err.Error(), "rate limit of model m1") {
+		t.Errorf("GenerateContentWithSystemPrompt = %v, want it to give up waiting", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("gave up after %s, want as soon as the context was done", elapsed)
+	}
+	if n := len(times()); n != 1 {
+		t.Errorf("%d requests reached the provider, want 1", n)
+	}
+}
@@ -0,0 +1,283 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/nieveai/d-agents/internal/metrics"
+	m "github.com/nieveai/d-agents/internal/models"
+	pb "github.com/nieveai/d-agents/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/proto"
+)
+
+// controllerTimeout bounds the calls a remote worker makes to the controller
+// while a workload runs.
+const controllerTimeout = 10 * time.Second
+
+// controller is the controller this process runs workloads for as a remote
+// worker, set while RunRemoteWorker runs. The running state of the workloads
+// goes to it instead of the datastore.
+var controller pb.WorkerServiceClient
+
+// RemoteServer hands workloads to remote workers over gRPC and stores the
+// results they report.
+type RemoteServer struct {
+	pb.UnimplementedWorkerServiceServer
+	workloads *Queue
+
+	mu sync.Mutex
+	// dispatched has a channel for each workload sent to a remote worker,
+	// closed once its result is reported.
+	dispatched map[string]chan struct{}
+}
+
+func NewRemoteServer(workloads *Queue) *RemoteServer {
+	return &RemoteServer{workloads: workloads, dispatched: make(map[string]chan struct{})}
+}
+
+// ServeRemoteWorkers listens on addr and serves remote workers until the
+// listener fails. Remote workers share workloads with any local ones.
+func ServeRemoteWorkers(addr string, workloads *Queue) error {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+	s := grpc.NewServer()
+	pb.RegisterWorkerServiceServer(s, NewRemoteServer(workloads))
+	slog.Info("accepting remote workers", "addr", lis.Addr().String())
+	return s.Serve(lis)
+}
+
+func (s *RemoteServer) StreamWorkloads(info *pb.WorkerInfo, stream grpc.ServerStreamingServer[pb.Workload]) error {
+	slog.Info("remote worker connected", "worker_id", info.WorkerId)
+	defer slog.Info("remote worker disconnected", "worker_id", info.WorkerId)
+
+	for {
+		workload, ok := s.workloads.Pop(stream.Context())
+		if !ok {
+			return nil
+		}
+		if takeCancelRequest(workload.Id) {
+			slog.Info("skipping cancelled workload", "session_id", workload.Id)
+			continue
+		}
+		// The worker has no datastore to look the agent up in.
+		sent := proto.Clone(workload).(*pb.Workload)
+		sent.SystemPrompt = lookupSystemPrompt(workload)
+		reported := s.dispatch(workload.Id)
+		if err := stream.Send(sent); err != nil {
+			s.reported(workload.Id)
+			failWorkload(workload, fmt.Errorf("failed to send workload to remote worker %s: %w", info.WorkerId, err))
+			return err
+		}
+		slog.Info("workload dispatched to remote worker", "session_id", workload.Id, "agent_type", workload.AgentType, "worker_id", info.WorkerId)
+
+		// Remote workers run one workload at a time, so the next one stays
+		// queued, for the other workers or by priority, until this one is
+		// reported. The heartbeats start once the worker starts it.
+		select {
+		case <-reported:
+		case <-stream.Context().Done():
+			s.reported(workload.Id)
+			s.abandoned(workload, info.WorkerId)
+			return nil
+		}
+	}
+}
+
+// dispatch returns a channel that is closed when the result of workload id is
+// reported.
+func (s *RemoteServer) dispatch(id string) <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	reported := make(chan struct{})
+	s.dispatched[id] = reported
+	return reported
+}
+
+// reported closes the channel dispatch returned for workload id, if any.
+func (s *RemoteServer) reported(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if reported, ok := s.dispatched[id]; ok {
+		close(reported)
+		delete(s.dispatched, id)
+	}
+}
+
+// abandoned handles a workload whose worker went away without reporting it.
+// One the worker never started is queued again; one it did is left to the
+// reaper, as its heartbeats have stopped.
+func (s *RemoteServer) abandoned(workload *pb.Workload, workerID string) {
+	session, err := db.GetSession(workload.Id)
+	if err != nil {
+		slog.Error("error getting session from db", "session_id", workload.Id, "error", err)
+		return
+	}
+	if session.Status != pb.WorkloadStatus_RUNNING || session.LastHeartbeat != 0 {
+		return
+	}
+	if s.workloads.TryPush(workload) {
+		slog.Info("re-enqueueing workload of a disconnected remote worker", "session_id", workload.Id, "worker_id", workerID)
+		return
+	}
+	failWorkload(workload, fmt.Errorf("remote worker %s disconnected before starting the workload and the queue is full", workerID))
+}
+
+func (s *RemoteServer) Heartbeat(ctx context.Context, status *pb.WorkloadStatus) (*pb.WorkloadStatus, error) {
+	if err := localHeartbeat(status.WorkloadId); err != nil {
+		return nil, fmt.Errorf("error recording heartbeat for workload %s: %w", status.WorkloadId, err)
+	}
+	return status, nil
+}
+
+func (s *RemoteServer) UpdateWorkload(ctx context.Context, workload *pb.Workload) (*pb.WorkloadStatus, error) {
+	session, err := db.GetSession(workload.Id)
+	if err != nil {
+		return nil, fmt.Errorf("error getting session %s: %w", workload.Id, err)
+	}
+	if session.Status != pb.WorkloadStatus_RUNNING {
+		return nil, fmt.Errorf("workload %s is no longer running (status %s)", workload.Id, session.Status)
+	}
+	session.Payload = workload.Payload
+	session.Stage = workload.Stage
+	session.Progress = max(session.Progress, clampProgress(workload.Progress))
+	session.RetryCount = workload.RetryCount
+	if err := db.AddSession(session); err != nil {
+		return nil, fmt.Errorf("error saving session %s: %w", workload.Id, err)
+	}
+	publish(session)
+	return &pb.WorkloadStatus{WorkloadId: workload.Id, Status: session.Status}, nil
+}
+
+func (s *RemoteServer) RecordAudit(ctx context.Context, entry *pb.AuditEntry) (*pb.WorkloadStatus, error) {
+	err := db.AddAuditEntry(&m.AuditEntry{
+		SessionID:        entry.SessionId,
+		ModelID:          entry.ModelId,
+		SystemPrompt:     entry.SystemPrompt,
+		Input:            entry.Input,
+		Output:           entry.Output,
+		Error:            entry.Error,
+		PromptTokens:     entry.PromptTokens,
+		CompletionTokens: entry.CompletionTokens,
+		Timestamp:        time.Unix(0, entry.Timestamp),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error recording audit entry for workload %s: %w", entry.SessionId, err)
+	}
+	return &pb.WorkloadStatus{WorkloadId: entry.SessionId}, nil
+}
+
+func (s *RemoteServer) ReportResult(ctx context.Context, workload *pb.Workload) (*pb.WorkloadStatus, error) {
+	switch workload.Status {
+	case pb.WorkloadStatus_COMPLETED, pb.WorkloadStatus_FAILED:
+	default:
+		return nil, fmt.Errorf("unexpected result status %s for workload %s", workload.Status, workload.Id)
+	}
+	defer s.reported(workload.Id)
+
+	// Remote workers can't be interrupted; a session cancelled while it ran
+	// there stays cancelled.
+	if takeCancelRequest(workload.Id) {
+		finishWorkload(workload, pb.WorkloadStatus_CANCELLED, ErrWorkloadCancelled.Error())
+		return &pb.WorkloadStatus{WorkloadId: workload.Id, Status: workload.Status, Message: workload.Error}, nil
+	}
+	// A result that turns up after the session was given up on, e.g. reaped
+	// for missing heartbeats, doesn't bring it back.
+	if session, err := db.GetSession(workload.Id); err == nil && session.Status != pb.WorkloadStatus_RUNNING {
+		return nil, fmt.Errorf("workload %s is no longer running (status %s)", workload.Id, session.Status)
+	}
+	finishWorkload(workload, workload.Status, workload.Error)
+	return &pb.WorkloadStatus{WorkloadId: workload.Id, Status: workload.Status, Message: workload.Error}, nil
+}
+
+// RunRemoteWorker connects to a controller at addr, processes the workloads it
+// streams with the local LLM client and reports each result back. It returns
+// when ctx is cancelled or the stream ends.
+func RunRemoteWorker(ctx context.Context, addr string, workerID string) error {
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return fmt.Errorf("failed to connect to controller at %s: %w", addr, err)
+	}
+	defer conn.Close()
+
+	client := pb.NewWorkerServiceClient(conn)
+	stream, err := client.StreamWorkloads(ctx, &pb.WorkerInfo{WorkerId: workerID})
+	if err != nil {
+		return fmt.Errorf("failed to stream workloads: %w", err)
+	}
+	controller = client
+	defer func() { controller = nil }()
+
+	for {
+		workload, err := stream.Recv()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("workload stream closed: %w", err)
+		}
+
+		slog.Info("workload received", "session_id", workload.Id, "agent_type", workload.AgentType, "models", workload.Models)
+		stopHeartbeat := startHeartbeat(workload.Id, func(id string) error {
+			_, err := client.Heartbeat(ctx, &pb.WorkloadStatus{WorkloadId: id, Status: pb.WorkloadStatus_RUNNING})
+			return err
+		})
+		err = execute(ctx, workload, currentLLMClient())
+		stopHeartbeat()
+		if err != nil {
+			slog.Error("workload failed", "session_id", workload.Id, "agent_type", workload.AgentType, "error", err)
+			workload.Status = pb.WorkloadStatus_FAILED
+			workload.Error = err.Error()
+		} else {
+			workload.Status = pb.WorkloadStatus_COMPLETED
+			workload.Error = ""
+		}
+		metrics.WorkloadsProcessed.WithLabelValues(workload.AgentType, workload.Status.String()).Inc()
+
+		if _, err := client.ReportResult(ctx, workload); err != nil {
+			slog.Error("error reporting workload result", "session_id", workload.Id, "error", err)
+		}
+	}
+}
+
+// sendUpdate sends the running state of workload to the controller.
+func sendUpdate(workload *pb.Workload) {
+	ctx, cancel := context.WithTimeout(context.Background(), controllerTimeout)
+	defer cancel()
+	if _, err := controller.UpdateWorkload(ctx, workload); err != nil {
+		slog.Warn("error sending workload update to the controller", "session_id", workload.Id, "error", err)
+	}
+}
+
+// controllerAudit is the audit log of remote workers, it sends the entries to
+// the controller.
+type controllerAudit struct{}
+
+func (controllerAudit) AddAuditEntry(entry *m.AuditEntry) error {
+	client := controller
+	if client == nil {
+		return errors.New("not connected to a controller")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), controllerTimeout)
+	defer cancel()
+	_, err := client.RecordAudit(ctx, &pb.AuditEntry{
+		SessionId:        entry.SessionID,
+		ModelId:          entry.ModelID,
+		SystemPrompt:     entry.SystemPrompt,
+		Input:            entry.Input,
+		Output:           entry.Output,
+		Error:            entry.Error,
+		PromptTokens:     entry.PromptTokens,
+		CompletionTokens: entry.CompletionTokens,
+		Timestamp:        entry.Timestamp.UnixNano(),
+	})
+	return err
+}
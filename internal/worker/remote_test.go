@@ -0,0 +1,397 @@
+package worker
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nieveai/d-agents/internal/agents"
+	"github.com/nieveai/d-agents/internal/database"
+	m "github.com/nieveai/d-agents/internal/models"
+	pb "github.com/nieveai/d-agents/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// useLLMClient makes llm the shared LLM client for the rest of the test.
+func useLLMClient(t *testing.T, llm *LLMClient) {
+	t.Helper()
+	llmMutex.Lock()
+	old := llmClient
+	llmClient = llm
+	llmMutex.Unlock()
+	t.Cleanup(func() {
+		llmMutex.Lock()
+		llmClient = old
+		llmMutex.Unlock()
+	})
+}
+
+// serveRemoteWorkers serves remote workers from queue for the rest of the test
+// and returns the address.
+func serveRemoteWorkers(t *testing.T, queue *Queue) string {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := grpc.NewServer()
+	pb.RegisterWorkerServiceServer(s, NewRemoteServer(queue))
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+	return lis.Addr().String()
+}
+
+// runRemoteWorker runs a remote worker for the controller at addr until the
+// end of the test.
+func runRemoteWorker(t *testing.T, addr string) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		RunRemoteWorker(ctx, addr, "test-worker")
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+}
+
+// waitForStatus waits until session id has left RUNNING and returns it.
+func waitForStatus(t *testing.T, store database.Datastore, id string) *pb.Workload {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		session, err := store.GetSession(id)
+		if err != nil {
+			t.Fatalf("GetSession: %v", err)
+		}
+		if session.Status != pb.WorkloadStatus_RUNNING {
+			return session
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("session %s is still RUNNING", id)
+	return nil
+}
+
+func TestRemoteWorker(t *testing.T) {
+	store := database.NewMemoryDatastore()
+	initTestWorker(t, store)
+	server := newFakeOpenAI(t, func(body map[string]any) fakeReply {
+		messages, _ := body["messages"].([]any)
+		last, _ := messages[len(messages)-1].(map[string]any)
+		if strings.Contains(last["content"].(string), "fail") {
+			return fakeReply{Status: 400}
+		}
+		return fakeReply{Text: "remote answer"}
+	})
+	llm, err := NewLLMClient(context.Background(), []*m.Model{openaiModel("m1", server.URL)})
+	if err != nil {
+		t.Fatalf("NewLLMClient: %v", err)
+	}
+	useLLMClient(t, llm)
+
+	queue := NewQueue(10)
+	addr := serveRemoteWorkers(t, queue)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- RunRemoteWorker(ctx, addr, "test-worker") }()
+
+	ok := addRunningSession(t, store, "ok")
+	failing := addRunningSession(t, store, "failing")
+	failing.Payload = []byte("please fail")
+	queue.Push(ok)
+	queue.Push(failing)
+
+	if got := waitForStatus(t, store, "ok"); got.Status != pb.WorkloadStatus_COMPLETED || !strings.HasSuffix(string(got.Payload), "remote answer") {
+		t.Errorf("ok session = %v %q, want COMPLETED with the answer", got.Status, got.Payload)
+	}
+	if got := waitForStatus(t, store, "failing"); got.Status != pb.WorkloadStatus_FAILED || got.Error == "" {
+		t.Errorf("failing session = %v %q, want FAILED with an error", got.Status, got.Error)
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("RunRemoteWorker: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("RunRemoteWorker didn't return after the context was cancelled")
+	}
+}
+
+func TestReportResultRejectsRunning(t *testing.T) {
+	initTestWorker(t, database.NewMemoryDatastore())
+	s := NewRemoteServer(NewQueue(1))
+	if _, err := s.ReportResult(context.Background(), &pb.Workload{Id: "s1", Status: pb.WorkloadStatus_RUNNING}); err == nil {
+		t.Error("ReportResult accepted a RUNNING result")
+	}
+}
+
+func TestRemoteWorkerTakesOneWorkloadAtATime(t *testing.T) {
+	store := database.NewMemoryDatastore()
+	initTestWorker(t, store)
+	agent := &countingAgent{release: make(chan struct{})}
+	RegisterAgent("countTestAgent", func() (m.AgentInterface, error) { return agent, nil })
+	queue := NewQueue(10)
+	addr := serveRemoteWorkers(t, queue)
+
+	runRemoteWorker(t, addr)
+
+	for _, id := range []string{"first", "second"} {
+		session := addRunningSession(t, store, id)
+		session.AgentType = "countTestAgent"
+		queue.Push(session)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for running, _ := agent.counts(); running == 0; running, _ = agent.counts() {
+		if time.Now().After(deadline) {
+			t.Fatal("the remote worker didn't start the first workload")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	// Give the server a chance to send more than it should.
+	time.Sleep(50 * time.Millisecond)
+
+	if n := queue.Len(); n != 1 {
+		t.Fatalf("%d workloads queued while the first runs remotely, want the second", n)
+	}
+	if got, _ := store.GetSession("first"); got.LastHeartbeat == 0 {
+		t.Error("the first workload has no heartbeat while it runs")
+	}
+	if got, _ := store.GetSession("second"); got.LastHeartbeat != 0 {
+		t.Error("the queued workload has a heartbeat")
+	}
+	// A local worker can take it in the meantime.
+	popCtx, popCancel := context.WithTimeout(context.Background(), time.Second)
+	defer popCancel()
+	second, ok := queue.Pop(popCtx)
+	if !ok || second.Id != "second" {
+		t.Fatalf("Pop = %v, %v, want the second workload", second, ok)
+	}
+	queue.Push(second)
+
+	close(agent.release)
+	for _, id := range []string{"first", "second"} {
+		if got := waitForStatus(t, store, id); got.Status != pb.WorkloadStatus_COMPLETED {
+			t.Errorf("%s = %v %q, want COMPLETED", id, got.Status, got.Error)
+		}
+	}
+	if _, maxRunning := agent.counts(); maxRunning != 1 {
+		t.Errorf("%d workloads ran at once on one remote worker, want 1", maxRunning)
+	}
+}
+
+func TestDisconnectedRemoteWorker(t *testing.T) {
+	store := database.NewMemoryDatastore()
+	initTestWorker(t, store)
+	queue := NewQueue(10)
+	addr := serveRemoteWorkers(t, queue)
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// A worker that goes away before starting the workload, so before its
+	// first heartbeat.
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, err := pb.NewWorkerServiceClient(conn).StreamWorkloads(ctx, &pb.WorkerInfo{WorkerId: "flaky"})
+	if err != nil {
+		t.Fatalf("StreamWorkloads: %v", err)
+	}
+	queue.Push(addRunningSession(t, store, "s1"))
+	if _, err := stream.Recv(); err != nil {
+		t.Fatalf("Recv: %v", err)
+	}
+	cancel()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for queue.Len() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("the workload of the disconnected worker wasn't queued again")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if got, _ := store.GetSession("s1"); got.Status != pb.WorkloadStatus_RUNNING {
+		t.Errorf("session = %v, want it RUNNING", got.Status)
+	}
+}
+
+func TestLateResultIsDropped(t *testing.T) {
+	store := database.NewMemoryDatastore()
+	initTestWorker(t, store)
+	session := addRunningSession(t, store, "s1")
+	session.LastHeartbeat = time.Now().Add(-time.Hour).Unix()
+	store.AddSession(session)
+	if _, err := ReapStaleWorkloads(DefaultStaleAfter); err != nil {
+		t.Fatalf("ReapStaleWorkloads: %v", err)
+	}
+
+	result := &pb.Workload{Id: "s1", Payload: []byte("finally"), Status: pb.WorkloadStatus_COMPLETED}
+	if _, err := NewRemoteServer(NewQueue(1)).ReportResult(context.Background(), result); err == nil {
+		t.Error("ReportResult accepted the result of a reaped workload")
+	}
+	if got, _ := store.GetSession("s1"); got.Status != pb.WorkloadStatus_FAILED {
+		t.Errorf("session = %v, want it to stay FAILED", got.Status)
+	}
+}
+
+// reportingAgent reports a partial payload and progress, then runs until
+// release is closed.
+type reportingAgent struct {
+	onUpdate   func(workload *pb.Workload)
+	onProgress func(id string, pct int32)
+	release    chan struct{}
+}
+
+func (a *reportingAgent) SetOnUpdate(fn func(workload *pb.Workload))  { a.onUpdate = fn }
+func (a *reportingAgent) SetOnProgress(fn func(id string, pct int32)) { a.onProgress = fn }
+
+func (a *reportingAgent) DoWork(ctx context.Context, workload *pb.Workload, client m.GenAIClient) error {
+	workload.Payload = []byte("halfway")
+	a.onUpdate(workload)
+	a.onProgress(workload.Id, 50)
+	select {
+	case <-a.release:
+		workload.Payload = []byte("done")
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// The controller's datastore is the only one in these tests, so the worker
+// using it instead of the RPCs would go unnoticed: what they check is that
+// the RPCs get there.
+
+func TestRemoteWorkerReportsRunningState(t *testing.T) {
+	store := database.NewMemoryDatastore()
+	initTestWorker(t, store)
+	b := useBroker(t)
+	release := make(chan struct{})
+	RegisterAgent("reportTestAgent", func() (m.AgentInterface, error) { return &reportingAgent{release: release}, nil })
+	queue := NewQueue(10)
+	runRemoteWorker(t, serveRemoteWorkers(t, queue))
+
+	session := addRunningSession(t, store, "s1")
+	session.AgentType = "reportTestAgent"
+	updates := b.SessionUpdates("s1")
+	queue.Push(session)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		got, _ := store.GetSession("s1")
+		if string(got.Payload) == "halfway" && got.Progress == 50 {
+			if got.Status != pb.WorkloadStatus_RUNNING {
+				t.Errorf("status = %v while the agent runs", got.Status)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("session = %q at %d%%, want the partial payload at 50%%", got.Payload, got.Progress)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	select {
+	case update := <-updates:
+		if update.Status != pb.WorkloadStatus_RUNNING {
+			t.Errorf("first update = %v, want a RUNNING one", update.Status)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("no update published while the workload ran")
+	}
+
+	close(release)
+	if got := waitForStatus(t, store, "s1"); got.Status != pb.WorkloadStatus_COMPLETED || string(got.Payload) != "done" {
+		t.Errorf("session = %v %q, want COMPLETED with the final payload", got.Status, got.Payload)
+	}
+}
+
+func TestRemoteWorkerGetsSystemPromptAndAudits(t *testing.T) {
+	store := database.NewMemoryDatastore()
+	initTestWorker(t, store)
+	store.AddAgent(&m.Agent{ID: "custom", Name: "Custom", Type: "promptTestAgent", SystemPrompt: "custom"})
+	RegisterAgent("promptTestAgent", func() (m.AgentInterface, error) { return &promptAgent{prompt: "built-in"}, nil })
+	server := newFakeOpenAI(t, func(map[string]any) fakeReply { return fakeReply{Text: "answer"} })
+	llm, err := NewLLMClient(context.Background(), []*m.Model{openaiModel("m1", server.URL)}, WithAuditLog(controllerAudit{}, false))
+	if err != nil {
+		t.Fatalf("NewLLMClient: %v", err)
+	}
+	useLLMClient(t, llm)
+	queue := NewQueue(10)
+	runRemoteWorker(t, serveRemoteWorkers(t, queue))
+
+	session := addRunningSession(t, store, "s1")
+	session.AgentType, session.AgentId = "promptTestAgent", "custom"
+	queue.Push(session)
+	if got := waitForStatus(t, store, "s1"); got.Status != pb.WorkloadStatus_COMPLETED {
+		t.Fatalf("session = %v %q, want COMPLETED", got.Status, got.Error)
+	}
+
+	messages, _ := server.Requests()[0]["messages"].([]any)
+	if first, _ := messages[0].(map[string]any); first["role"] != "system" || first["content"] != "custom" {
+		t.Errorf("first message = %v, want the agent's system prompt", first)
+	}
+	entries, err := store.ListAuditEntries("s1")
+	if err != nil {
+		t.Fatalf("ListAuditEntries: %v", err)
+	}
+	if len(entries) != 1 || entries[0].SystemPrompt != "custom" || entries[0].Output != "answer" || entries[0].PromptTokens != 10 {
+		t.Errorf("audit entries = %+v, want the call", entries)
+	}
+	if session.SystemPrompt != "" {
+		t.Error("the controller's copy of the session got the system prompt")
+	}
+}
+
+func TestUpdateWorkloadOfFinishedSession(t *testing.T) {
+	store := database.NewMemoryDatastore()
+	initTestWorker(t, store)
+	store.AddSession(&pb.Workload{Id: "s1", Payload: []byte("final"), Status: pb.WorkloadStatus_CANCELLED})
+
+	update := &pb.Workload{Id: "s1", Payload: []byte("partial"), Progress: 50}
+	if _, err := NewRemoteServer(NewQueue(1)).UpdateWorkload(context.Background(), update); err == nil {
+		t.Error("UpdateWorkload accepted an update of a cancelled session")
+	}
+	if got, _ := store.GetSession("s1"); string(got.Payload) != "final" {
+		t.Errorf("payload = %q, want it unchanged", got.Payload)
+	}
+}
+
+func TestInitRemote(t *testing.T) {
+	initTestWorker(t, database.NewMemoryDatastore())
+	useLLMClient(t, nil)
+	t.Cleanup(func() {
+		remote = false
+		database.UseSettings(nil)
+		agents.SetRelationshipStore(nil)
+		agents.SetVectorStore(nil)
+	})
+	local := database.NewMemoryDatastore()
+	local.AddModel(openaiModel("m1", newFakeOpenAI(t, nil).URL))
+
+	if err := InitRemote(context.Background(), nil, local); err != nil {
+		t.Fatalf("InitRemote: %v", err)
+	}
+	if db != nil {
+		t.Error("remote worker has a datastore for sessions")
+	}
+	llm := currentLLMClient().(*LLMClient)
+	if _, ok := llm.modelInfo["m1"]; !ok {
+		t.Error("the local models weren't loaded")
+	}
+	if _, ok := llm.auditLog.(controllerAudit); !ok {
+		t.Errorf("audit log = %T, want the controller", llm.auditLog)
+	}
+	// Not connected, nothing to send the state to and no datastore to
+	// write it to.
+	saveRunningState(&pb.Workload{Id: "s1"})
+	saveRetryCount(&pb.Workload{Id: "s1"})
+}
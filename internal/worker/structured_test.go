@@ -0,0 +1,74 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	m "github.com/nieveai/d-agents/internal/models"
+	pb "github.com/nieveai/d-agents/proto"
+)
+
+var companiesSchema = map[string]any{
+	"type": "object",
+	"properties": map[string]any{
+		"items": map[string]any{"type": "array", "items": map[string]any{"type": "string"}},
+	},
+	"required":             []string{"items"},
+	"additionalProperties": false,
+}
+
+func TestGenerateStructured(t *testing.T) {
+	tests := []struct {
+		name    string
+		reply   string
+		wantErr error
+	}{
+		{"valid", `{"items": ["TSMC", "AMD"]}`, nil},
+		{"not JSON", `Sure! {"items": ["TSMC"]}`, m.ErrInvalidResponse},
+		{"doesn't match the schema", `{"items": [1, 2]}`, m.ErrInvalidResponse},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newFakeOpenAI(t, func(map[string]any) fakeReply { return fakeReply{Text: tt.reply} })
+			llm, err := NewLLMClient(context.Background(), []*m.Model{openaiModel("m1", server.URL)})
+			if err != nil {
+				t.Fatalf("NewLLMClient: %v", err)
+			}
+
+			raw, err := llm.GenerateStructured(context.Background(), &pb.Workload{Id: "s1", Models: []string{"m1"}}, "list Nvidia's suppliers", "", companiesSchema)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) || raw != nil {
+					t.Errorf("GenerateStructured = %s, %v, want %v", raw, err, tt.wantErr)
+				}
+			} else if err != nil || string(raw) != tt.reply {
+				t.Errorf("GenerateStructured = %s, %v, want %s", raw, err, tt.reply)
+			}
+
+			// The schema is sent for the provider to enforce.
+			format, _ := server.Requests()[0]["response_format"].(map[string]any)
+			schema, _ := format["json_schema"].(map[string]any)
+			if format["type"] != "json_schema" || schema["strict"] != true || schema["schema"] == nil {
+				t.Errorf("response_format = %v, want the strict json schema", format)
+			}
+		})
+	}
+}
+
+func TestGenerateStructuredNotSupported(t *testing.T) {
+	custom := &m.Model{ID: "c1", ModelID: "x", APISpec: "custom", APIURL: "http://127.0.0.1:1", RequestTemplate: `{"prompt": {{json .Input}}}`, ResponsePath: "text"}
+	llm, err := NewLLMClient(context.Background(), []*m.Model{custom})
+	if err != nil {
+		t.Fatalf("NewLLMClient: %v", err)
+	}
+	workload := &pb.Workload{Id: "s1", Models: []string{"c1"}}
+	if _, err := llm.GenerateStructured(context.Background(), workload, "hi", "", companiesSchema); !errors.Is(err, m.ErrStructuredOutputNotSupported) {
+		t.Errorf("GenerateStructured with a custom model = %v, want %v", err, m.ErrStructuredOutputNotSupported)
+	}
+	if _, err := llm.GenerateStructured(context.Background(), workload, "hi", "", nil); err == nil {
+		t.Error("GenerateStructured without a schema succeeded")
+	}
+	if _, err := llm.GenerateStructured(context.Background(), &pb.Workload{Id: "s1"}, "hi", "", companiesSchema); !errors.Is(err, m.ErrNoModelsSpecified) {
+		t.Errorf("GenerateStructured without models = %v", err)
+	}
+}
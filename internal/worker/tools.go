@@ -0,0 +1,115 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	m "github.com/nieveai/d-agents/internal/models"
+	pb "github.com/nieveai/d-agents/proto"
+	"github.com/openai/openai-go/v2"
+	"github.com/openai/openai-go/v2/packages/param"
+	"github.com/openai/openai-go/v2/shared"
+)
+
+// GenerateWithTools sends input to the workload's first model along with
+// tools. Whenever the model asks for tool calls they are run and their results
+// sent back, until the model gives a final answer. A tool that fails reports
+// its error to the model instead of ending the loop. Only OpenAI compatible
+// models are supported and there is no fallback, as the tools may have side
+// effects.
+func (llm *LLMClient) GenerateWithTools(ctx context.Context, workload *pb.Workload, input string, system_prompt string, tools []m.Tool, maxSteps int) (string, error) {
+	if len(workload.Models) == 0 {
+		return "", m.ErrNoModelsSpecified
+	}
+	model, client, err := llm.lookupClient(workload.Models[0])
+	if err != nil {
+		return "", err
+	}
+	c, ok := client.(*openai.Client)
+	if !ok {
+		return "", fmt.Errorf("%w: %s", m.ErrToolsNotSupported, model.ID)
+	}
+
+	messages, err := fitInput(model, userMessage(input), system_prompt)
+	if err != nil {
+		return "", err
+	}
+	byName := make(map[string]m.Tool, len(tools))
+	params := openaiParams(model, messages, system_prompt)
+	for _, tool := range tools {
+		byName[tool.Name] = tool
+		params.Tools = append(params.Tools, openai.ChatCompletionFunctionTool(shared.FunctionDefinitionParam{
+			Name:        tool.Name,
+			Description: param.NewOpt(tool.Description),
+			Parameters:  shared.FunctionParameters(tool.Parameters),
+		}))
+	}
+
+	for step := 0; step <= maxSteps; step++ {
+		resp, err := llm.completeWithTools(ctx, c, model, params)
+		if err != nil {
+			return "", err
+		}
+		llm.recordUsage(workload, model.ID, openaiUsage(resp.Usage))
+		if len(resp.Choices) == 0 {
+			return "", fmt.Errorf("OpenAI API returned no choices")
+		}
+		msg := resp.Choices[0].Message
+		if len(msg.ToolCalls) == 0 {
+			return msg.Content, nil
+		}
+		if step == maxSteps {
+			break
+		}
+
+		params.Messages = append(params.Messages, msg.ToParam())
+		for _, call := range msg.ToolCalls {
+			result := llm.callTool(ctx, byName, call)
+			params.Messages = append(params.Messages, openai.ToolMessage(result, call.ID))
+		}
+	}
+	return "", fmt.Errorf("model still calling tools after %d steps", maxSteps)
+}
+
+func (llm *LLMClient) completeWithTools(ctx context.Context, c *openai.Client, model *m.Model, params openai.ChatCompletionNewParams) (*openai.ChatCompletion, error) {
+	release, err := llm.acquire(ctx, model.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	start := time.Now()
+	resp, err := c.Chat.Completions.New(ctx, params)
+	if err != nil {
+		err = fmt.Errorf("error calling OpenAI API: %s", err)
+	}
+	observeLLMCall(model, start, err)
+	if err != nil {
+		slog.Warn("LLM call failed", "model_id", model.ID, "duration", time.Since(start), "error", err)
+		return nil, err
+	}
+	slog.Info("LLM call finished", "model_id", model.ID, "duration", time.Since(start),
+		"prompt_tokens", resp.Usage.PromptTokens, "completion_tokens", resp.Usage.CompletionTokens,
+		"tool_calls", len(resp.Choices) > 0 && len(resp.Choices[0].Message.ToolCalls) > 0)
+	return resp, nil
+}
+
+// callTool runs one tool call and returns what to tell the model.
+func (llm *LLMClient) callTool(ctx context.Context, tools map[string]m.Tool, call openai.ChatCompletionMessageToolCallUnion) string {
+	name := call.Function.Name
+	tool, ok := tools[name]
+	if !ok || tool.Call == nil {
+		slog.Warn("model called unknown tool", "tool", name)
+		return fmt.Sprintf("error: unknown tool %q", name)
+	}
+	start := time.Now()
+	result, err := tool.Call(ctx, call.Function.Arguments)
+	if err != nil {
+		slog.Warn("tool call failed", "tool", name, "duration", time.Since(start), "error", err)
+		return fmt.Sprintf("error: %v", err)
+	}
+	slog.Info("tool call finished", "tool", name, "duration", time.Since(start))
+	return result
+}
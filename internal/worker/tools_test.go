@@ -0,0 +1,124 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	m "github.com/nieveai/d-agents/internal/models"
+	pb "github.com/nieveai/d-agents/proto"
+)
+
+// addTool adds the numbers a and b, and records the arguments it got.
+func addTool(got *[]string) m.Tool {
+	return m.Tool{
+		Name:        "add",
+		Description: "adds two numbers",
+		Parameters: map[string]any{
+			"type":       "object",
+			"properties": map[string]any{"a": map[string]any{"type": "number"}, "b": map[string]any{"type": "number"}},
+		},
+		Call: func(ctx context.Context, arguments string) (string, error) {
+			*got = append(*got, arguments)
+			if strings.Contains(arguments, "-") {
+				return "", errors.New("negative numbers aren't supported")
+			}
+			return "5", nil
+		},
+	}
+}
+
+// toolMessages returns the contents of the tool messages in a chat request.
+func toolMessages(body map[string]any) []string {
+	messages, _ := body["messages"].([]any)
+	var contents []string
+	for _, msg := range messages {
+		msg, _ := msg.(map[string]any)
+		if msg["role"] == "tool" {
+			content, _ := msg["content"].(string)
+			contents = append(contents, msg["tool_call_id"].(string)+": "+content)
+		}
+	}
+	return contents
+}
+
+func TestGenerateWithTools(t *testing.T) {
+	tests := []struct {
+		name      string
+		call      [2]string
+		wantTool  string
+		wantCalls int
+	}{
+		{"tool result", [2]string{"add", `{"a": 2, "b": 3}`}, "call_0: 5", 1},
+		{"failing tool", [2]string{"add", `{"a": -2, "b": 3}`}, "call_0: error: negative numbers aren't supported", 1},
+		{"unknown tool", [2]string{"multiply", `{}`}, `call_0: error: unknown tool "multiply"`, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newFakeOpenAI(t, func(body map[string]any) fakeReply {
+				if len(toolMessages(body)) == 0 {
+					return fakeReply{ToolCalls: [][2]string{tt.call}}
+				}
+				return fakeReply{Text: "done"}
+			})
+			llm, err := NewLLMClient(context.Background(), []*m.Model{openaiModel("m1", server.URL)})
+			if err != nil {
+				t.Fatalf("NewLLMClient: %v", err)
+			}
+			var got []string
+			answer, err := llm.GenerateWithTools(context.Background(), &pb.Workload{Models: []string{"m1"}}, "what is 2 + 3?", "use the tools", []m.Tool{addTool(&got)}, 5)
+			if err != nil {
+				t.Fatalf("GenerateWithTools: %v", err)
+			}
+			if answer != "done" {
+				t.Errorf("answer = %q, want the final message", answer)
+			}
+			if len(got) != tt.wantCalls || (tt.wantCalls > 0 && got[0] != tt.call[1]) {
+				t.Errorf("tool called with %q, want %q %d times", got, tt.call[1], tt.wantCalls)
+			}
+
+			requests := server.Requests()
+			if len(requests) != 2 {
+				t.Fatalf("%d requests, want the call and the answer", len(requests))
+			}
+			tools, _ := requests[0]["tools"].([]any)
+			var function map[string]any
+			if len(tools) == 1 {
+				function, _ = tools[0].(map[string]any)["function"].(map[string]any)
+			}
+			if function["name"] != "add" || function["parameters"] == nil {
+				t.Errorf("tools sent = %v, want add with its parameters", tools)
+			}
+			if results := toolMessages(requests[1]); len(results) != 1 || results[0] != tt.wantTool {
+				t.Errorf("tool results sent back = %q, want %q", results, tt.wantTool)
+			}
+		})
+	}
+}
+
+func TestGenerateWithToolsMaxSteps(t *testing.T) {
+	server := newFakeOpenAI(t, func(map[string]any) fakeReply {
+		return fakeReply{ToolCalls: [][2]string{{"add", `{"a": 2, "b": 3}`}}}
+	})
+	llm, err := NewLLMClient(context.Background(), []*m.Model{openaiModel("m1", server.URL)})
+	if err != nil {
+		t.Fatalf("NewLLMClient: %v", err)
+	}
+	var got []string
+	_, err = llm.GenerateWithTools(context.Background(), &pb.Workload{Models: []string{"m1"}}, "loop", "", []m.Tool{addTool(&got)}, 2)
+	if err == nil || !strings.Contains(err.Error(), "after 2 steps") {
+		t.Errorf("GenerateWithTools = %v, want the step limit", err)
+	}
+	if len(got) != 2 || len(server.Requests()) != 3 {
+		t.Errorf("%d tool calls in %d requests, want 2 in 3", len(got), len(server.Requests()))
+	}
+}
+
+func TestGenerateWithToolsNeedsOpenAI(t *testing.T) {
+	llm := newGeminiLLMClient(t, newFakeGemini(t, "hi"), geminiModel("g1"))
+	_, err := llm.GenerateWithTools(context.Background(), &pb.Workload{Models: []string{"g1"}}, "hi", "", nil, 1)
+	if !errors.Is(err, m.ErrToolsNotSupported) {
+		t.Errorf("GenerateWithTools on Gemini = %v, want ErrToolsNotSupported", err)
+	}
+}
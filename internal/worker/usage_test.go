@@ -0,0 +1,94 @@
+package worker
+
+import (
+	"context"
+	"math"
+	"testing"
+
+	"github.com/nieveai/d-agents/internal/database"
+	m "github.com/nieveai/d-agents/internal/models"
+	pb "github.com/nieveai/d-agents/proto"
+)
+
+// priced sets model's prices to 1 and 2 per token.
+func priced(model *m.Model) *m.Model {
+	model.PromptPrice, model.CompletionPrice = 1e6, 2e6
+	return model
+}
+
+func TestUsageIsRecorded(t *testing.T) {
+	tests := []struct {
+		name   string
+		client func(t *testing.T) *LLMClient
+		want   m.Usage
+	}{
+		{
+			name: "gemini",
+			client: func(t *testing.T) *LLMClient {
+				return newGeminiLLMClient(t, newFakeGemini(t, "answer"), priced(geminiModel("model")))
+			},
+			want: m.Usage{PromptTokens: 7, CompletionTokens: 3, TotalTokens: 10},
+		},
+		{
+			name: "openai",
+			client: func(t *testing.T) *LLMClient {
+				server := newFakeOpenAI(t, func(map[string]any) fakeReply { return fakeReply{Text: "answer"} })
+				llm, err := NewLLMClient(context.Background(), []*m.Model{priced(openaiModel("model", server.URL))})
+				if err != nil {
+					t.Fatalf("NewLLMClient: %v", err)
+				}
+				return llm
+			},
+			want: m.Usage{PromptTokens: 10, CompletionTokens: 5, TotalTokens: 15},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			llm := tt.client(t)
+			workload := &pb.Workload{Id: "s1", Models: []string{"model"}}
+
+			text, usage, err := llm.GenerateContentWithUsage(context.Background(), workload, "hi", "be brief")
+			if err != nil {
+				t.Fatalf("GenerateContentWithUsage: %v", err)
+			}
+			if text != "answer" {
+				t.Errorf("text = %q", text)
+			}
+			if usage != tt.want {
+				t.Errorf("usage = %+v, want %+v", usage, tt.want)
+			}
+			// A second call adds up.
+			if _, _, err := llm.GenerateContentWithUsage(context.Background(), workload, "again", ""); err != nil {
+				t.Fatalf("GenerateContentWithUsage: %v", err)
+			}
+			if workload.PromptTokens != 2*tt.want.PromptTokens || workload.CompletionTokens != 2*tt.want.CompletionTokens {
+				t.Errorf("workload tokens = %d/%d, want twice %+v", workload.PromptTokens, workload.CompletionTokens, tt.want)
+			}
+			wantCost := 2 * float64(tt.want.PromptTokens+2*tt.want.CompletionTokens)
+			if math.Abs(workload.EstimatedCost-wantCost) > 1e-9 {
+				t.Errorf("EstimatedCost = %v, want %v", workload.EstimatedCost, wantCost)
+			}
+		})
+	}
+}
+
+func TestSessionUsageIsPersisted(t *testing.T) {
+	store := database.NewMemoryDatastore()
+	initTestWorker(t, store)
+	session := addRunningSession(t, store, "s1")
+	session.Models = []string{"model"}
+	server := newFakeOpenAI(t, nil)
+	llm, err := NewLLMClient(context.Background(), []*m.Model{priced(openaiModel("model", server.URL))})
+	if err != nil {
+		t.Fatalf("NewLLMClient: %v", err)
+	}
+
+	ProcessWorkloadWithClient(context.Background(), session, llm)
+	got, err := store.GetSession("s1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Status != pb.WorkloadStatus_COMPLETED || got.PromptTokens != 10 || got.CompletionTokens != 5 || got.EstimatedCost != 20 {
+		t.Errorf("stored session = %v %d/%d $%v, want COMPLETED 10/5 $20", got.Status, got.PromptTokens, got.CompletionTokens, got.EstimatedCost)
+	}
+}
@@ -2,11 +2,16 @@ package worker
 
 import (
 	"context"
-	"log"
+	"errors"
+	"fmt"
+	"log/slog"
 	"sync"
+	"time"
 
+	// Importing agents also registers the built-in agent types.
 	"github.com/nieveai/d-agents/internal/agents"
 	"github.com/nieveai/d-agents/internal/database"
+	"github.com/nieveai/d-agents/internal/metrics"
 	m "github.com/nieveai/d-agents/internal/models"
 	pb "github.com/nieveai/d-agents/proto"
 )
@@ -15,72 +20,356 @@ var (
 	llmClient *LLMClient
 	db        database.Datastore
 	llmMutex  = &sync.RWMutex{}
+
+	workloadTimeout = DefaultWorkloadTimeout
+	maxInFlight     = DefaultMaxInFlight
+	responseCache   *ResponseCache
+	auditEnabled    = true
+	auditRedact     bool
+	// remote is set by InitRemote.
+	remote bool
 )
 
+// DefaultWorkloadTimeout bounds how long a single workload may run, retries included.
+const DefaultWorkloadTimeout = 10 * time.Minute
+
+// SetWorkloadTimeout sets the per-workload timeout. Zero disables it.
+func SetWorkloadTimeout(d time.Duration) {
+	workloadTimeout = d
+}
+
+// SetMaxInFlight sets how many LLM calls may run at once. It applies the next
+// time the LLM client is (re)initialized.
+func SetMaxInFlight(n int) {
+	maxInFlight = n
+}
+
+// SetResponseCache makes LLM clients created from now on share cache. A nil
+// cache disables caching.
+func SetResponseCache(cache *ResponseCache) {
+	responseCache = cache
+}
+
+// SetAudit sets whether LLM clients created from now on record the calls
+// made for sessions in the datastore given to Init, and whether they leave the
+// input out of the records.
+func SetAudit(enabled, redactInput bool) {
+	auditEnabled = enabled
+	auditRedact = redactInput
+}
+
+// Init sets up the worker with database_conn and an LLM client for models.
+// With nil models, the models configured in database_conn are used.
 func Init(ctx context.Context, models []*m.Model, database_conn database.Datastore) error {
 	db = database_conn
+	remote = false
+	agents.SetRelationshipStore(database_conn)
+	agents.SetVectorStore(database_conn)
+	database.UseSettings(database_conn)
+	if models == nil && database_conn != nil {
+		var err error
+		models, err = database_conn.ListModels()
+		if err != nil {
+			return fmt.Errorf("error loading models: %w", err)
+		}
+	}
+	return ReinitializeLLMClient(ctx, models)
+}
+
+// InitRemote sets up a remote worker with an LLM client for models, or the
+// models in local when nil. Remote workers have no datastore for sessions:
+// what a workload needs comes with it, and its running state and audit
+// entries are sent to the controller, see RunRemoteWorker. The agents keep
+// their settings and data in local.
+func InitRemote(ctx context.Context, models []*m.Model, local database.Datastore) error {
+	db = nil
+	remote = true
+	agents.SetRelationshipStore(local)
+	agents.SetVectorStore(local)
+	database.UseSettings(local)
+	if models == nil && local != nil {
+		var err error
+		models, err = local.ListModels()
+		if err != nil {
+			return fmt.Errorf("error loading models: %w", err)
+		}
+	}
 	return ReinitializeLLMClient(ctx, models)
 }
 
 func ReinitializeLLMClient(ctx context.Context, models []*m.Model) error {
 	llmMutex.Lock()
 	defer llmMutex.Unlock()
+	return reinitializeLocked(ctx, models)
+}
 
-	var err error
-	llmClient, err = NewLLMClient(ctx, models)
+// ReloadModels reinitializes the LLM client with the models in the datastore
+// given to Init, so models added, changed or deleted since are used by the
+// next workload. The models are read under llmMutex, so concurrent reloads
+// can't leave an older list in place.
+func ReloadModels(ctx context.Context) error {
+	if db == nil {
+		return fmt.Errorf("worker not initialized")
+	}
+	llmMutex.Lock()
+	defer llmMutex.Unlock()
+	models, err := db.ListModels()
+	if err != nil {
+		return fmt.Errorf("error loading models: %w", err)
+	}
+	return reinitializeLocked(ctx, models)
+}
+
+func reinitializeLocked(ctx context.Context, models []*m.Model) error {
+	opts := []LLMClientOption{WithMaxInFlight(maxInFlight), WithResponseCache(responseCache)}
+	if auditEnabled && db != nil {
+		opts = append(opts, WithAuditLog(db, auditRedact))
+	} else if auditEnabled && remote {
+		opts = append(opts, WithAuditLog(controllerAudit{}, auditRedact))
+	}
+	client, err := NewLLMClient(ctx, models, opts...)
 	if err != nil {
 		return err
 	}
-	log.Println("LLM Client reinitialized with updated models.")
+	llmClient = client
+	slog.Info("LLM client reinitialized", "models", len(models))
 	return nil
 }
 
-func ProcessWorkload(workload *pb.Workload) {
-	var agent m.AgentInterface
-	var err error
+// ProcessWorkload runs the workload with the LLM client set up by Init.
+func ProcessWorkload(ctx context.Context, workload *pb.Workload) {
+	ProcessWorkloadWithClient(ctx, workload, currentLLMClient())
+}
 
-	switch workload.AgentType {
-	case "ChatAgent":
-		agent = &agents.ChatAgent{}
-	case "CompanyRelationshipAgent":
-		agent, err = agents.NewCompanyRelationshipAgent()
-		if err != nil {
-			log.Printf("Error creating CompanyRelationshipAgent: %s", err)
-			return
-		}
-	case "ShoppingAgent":
-		agent, err = agents.NewShoppingAgent()
-		if err != nil {
-			log.Printf("Error creating ShoppingAgent: %s", err)
+// ProcessWorkloadWithClient runs the workload with client instead of the
+// shared LLM client, e.g. a testutil.FakeGenAIClient.
+func ProcessWorkloadWithClient(ctx context.Context, workload *pb.Workload, client m.GenAIClient) {
+	if takeCancelRequest(workload.Id) {
+		slog.Info("skipping cancelled workload", "session_id", workload.Id)
+		workload.Status = pb.WorkloadStatus_CANCELLED
+		workload.Error = ErrWorkloadCancelled.Error()
+		return
+	}
+
+	slog.Info("workload received", "session_id", workload.Id, "agent_type", workload.AgentType, "models", workload.Models)
+	start := time.Now()
+	ctx, done := trackWorkload(ctx, workload.Id)
+	defer done()
+	stopHeartbeat := startHeartbeat(workload.Id, localHeartbeat)
+	err := execute(ctx, workload, client)
+	stopHeartbeat()
+	if err != nil {
+		if wasCancelled(ctx) {
+			slog.Info("workload cancelled", "session_id", workload.Id, "agent_type", workload.AgentType, "duration", time.Since(start))
+			finishWorkload(workload, pb.WorkloadStatus_CANCELLED, ErrWorkloadCancelled.Error())
 			return
 		}
-	default:
-		log.Printf("Unknown agent type: %s", workload.AgentType)
+		failWorkload(workload, err)
 		return
 	}
 
+	slog.Info("workload completed", "session_id", workload.Id, "agent_type", workload.AgentType, "duration", time.Since(start))
+	finishWorkload(workload, pb.WorkloadStatus_COMPLETED, "")
+}
+
+// currentLLMClient returns the client made by the last ReinitializeLLMClient.
+func currentLLMClient() m.GenAIClient {
 	llmMutex.RLock()
-	client := llmClient
-	llmMutex.RUnlock()
+	defer llmMutex.RUnlock()
+	return llmClient
+}
+
+// execute runs the workload's agent under the workload timeout, with retries.
+func execute(ctx context.Context, workload *pb.Workload, client m.GenAIClient) error {
+	if workloadTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, workloadTimeout)
+		defer cancel()
+	}
+
+	err := runWithRetries(ctx, workload, client)
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("workload timed out after %s: %w", workloadTimeout, err)
+	}
+	return err
+}
 
-	err = agent.DoWork(workload, client)
+// RegisterAgent makes a new agent type available to ProcessWorkload. The
+// built-in agents register themselves from the agents package.
+func RegisterAgent(agentType string, factory func() (m.AgentInterface, error)) {
+	m.RegisterAgent(agentType, factory)
+}
+
+// runAgent runs the workload's agent, or each agent of its pipeline in turn,
+// updating the workload in place. Each pipeline stage works on the payload the
+// previous one left behind, and the first failing stage stops the pipeline.
+func runAgent(ctx context.Context, workload *pb.Workload, client m.GenAIClient) error {
+	if len(workload.Pipeline) == 0 {
+		return runStage(ctx, workload, client, workload.AgentType, 0, 100)
+	}
+
+	stages := int32(len(workload.Pipeline))
+	for i, agentType := range workload.Pipeline {
+		workload.Stage = fmt.Sprintf("%d/%d %s", i+1, len(workload.Pipeline), agentType)
+		saveRunningState(workload)
+		from, to := int32(i)*100/stages, int32(i+1)*100/stages
+		setProgress(workload, from)
+		if err := runStage(ctx, workload, client, agentType, from, to); err != nil {
+			return fmt.Errorf("pipeline stage %d (%s) failed: %w", i+1, agentType, err)
+		}
+	}
+	workload.Stage = ""
+	return nil
+}
+
+// runStage creates a single agent and runs it on the workload. The progress
+// the agent reports is scaled to the part from to to of the whole workload.
+func runStage(ctx context.Context, workload *pb.Workload, client m.GenAIClient, agentType string, from, to int32) error {
+	agent, err := m.NewAgent(agentType)
 	if err != nil {
-		log.Printf("Error processing workload: %s", err)
-		// Optionally, update workload status to FAILED
-		return
+		return err
+	}
+	if notifier, ok := agent.(m.UpdateNotifier); ok {
+		notifier.SetOnUpdate(saveRunningState)
+	}
+	if notifier, ok := agent.(m.ProgressNotifier); ok {
+		notifier.SetOnProgress(func(id string, pct int32) {
+			setProgress(workload, from+(to-from)*clampProgress(pct)/100)
+		})
+	}
+	// Other pipeline stages aren't the session's agent and keep their own.
+	if agentType == workload.AgentType {
+		if prompt := agentSystemPrompt(workload); prompt != "" {
+			if setter, ok := agent.(m.SystemPromptSetter); ok {
+				setter.SetSystemPrompt(prompt)
+			} else {
+				slog.Warn("agent type has no replaceable system prompt, ignoring the agent's", "session_id", workload.Id, "agent_type", agentType)
+			}
+		}
+	}
+
+	slog.Debug("agent dispatched", "session_id", workload.Id, "agent_type", agentType, "stage", workload.Stage)
+	if err := agent.DoWork(ctx, workload, client); err != nil {
+		return fmt.Errorf("error processing workload: %w", err)
+	}
+	return nil
+}
+
+// agentSystemPrompt returns the custom system prompt of the workload's agent,
+// or "" when it has none.
+func agentSystemPrompt(workload *pb.Workload) string {
+	// The controller looks it up for remote workers.
+	if controller != nil {
+		return workload.SystemPrompt
+	}
+	return lookupSystemPrompt(workload)
+}
+
+// lookupSystemPrompt loads the custom system prompt of the workload's agent
+// from the datastore.
+func lookupSystemPrompt(workload *pb.Workload) string {
+	if db == nil || workload.AgentId == "" {
+		return ""
+	}
+	agent, err := db.GetAgent(workload.AgentId)
+	if err != nil {
+		slog.Warn("error loading agent, using the built-in system prompt", "session_id", workload.Id, "agent_id", workload.AgentId, "error", err)
+		return ""
+	}
+	return agent.SystemPrompt
+}
+
+// failWorkload marks the workload as FAILED and records the error on the session.
+func failWorkload(workload *pb.Workload, err error) {
+	slog.Error("workload failed", "session_id", workload.Id, "agent_type", workload.AgentType, "error", err)
+	finishWorkload(workload, pb.WorkloadStatus_FAILED, err.Error())
+}
+
+// finishWorkload stores the final status of a workload along with its payload and usage.
+func finishWorkload(workload *pb.Workload, status pb.WorkloadStatus_Status, errorMessage string) {
+	workload.Status = status
+	workload.Error = errorMessage
+	if status == pb.WorkloadStatus_COMPLETED {
+		workload.Progress = 100
 	}
+	metrics.WorkloadsProcessed.WithLabelValues(workload.AgentType, status.String()).Inc()
 
 	session, err := db.GetSession(workload.Id)
 	if err != nil {
-		log.Printf("Error getting session %s from db: %s", workload.Id, err)
+		slog.Error("error getting session from db", "session_id", workload.Id, "error", err)
+		publish(workload)
 		return
 	}
 
 	session.Payload = workload.Payload
-	session.Status = pb.WorkloadStatus_COMPLETED
+	session.History = workload.History
+	session.Status = status
+	session.Error = errorMessage
+	session.Stage = workload.Stage
+	session.Progress = workload.Progress
+	session.PromptTokens = workload.PromptTokens
+	session.CompletionTokens = workload.CompletionTokens
+	session.EstimatedCost = workload.EstimatedCost
 
 	if err := db.AddSession(session); err != nil {
-		log.Printf("Error saving updated session %s to db: %s", workload.Id, err)
+		slog.Error("error saving updated session to db", "session_id", workload.Id, "error", err)
 	}
+	publish(session)
 }
 
+// saveRunningState persists the payload and pipeline stage of a workload that
+// is still running, so pollers can show partial results. Remote workers send
+// them to the controller.
+func saveRunningState(workload *pb.Workload) {
+	if controller != nil {
+		sendUpdate(workload)
+		return
+	}
+	if db == nil {
+		return
+	}
+	session, err := db.GetSession(workload.Id)
+	if err != nil {
+		slog.Error("error getting session from db", "session_id", workload.Id, "error", err)
+		return
+	}
+
+	session.Payload = workload.Payload
+	session.Stage = workload.Stage
+	if err := db.AddSession(session); err != nil {
+		slog.Error("error saving partial payload", "session_id", workload.Id, "error", err)
+	}
+	publish(session)
+}
+
+// ReportProgress records that workload id is pct percent done, pct going from
+// 0 to 100. Remote workers have no datastore, so there it does nothing;
+// setProgress sends their progress to the controller instead.
+func ReportProgress(id string, pct int32) {
+	if db == nil {
+		return
+	}
+	if err := db.SetProgress(id, clampProgress(pct)); err != nil {
+		slog.Warn("error recording progress", "session_id", id, "error", err)
+	}
+}
+
+// setProgress moves the workload's progress to pct and records it. Progress
+// never goes back while the workload runs.
+func setProgress(workload *pb.Workload, pct int32) {
+	pct = clampProgress(pct)
+	if pct <= workload.Progress {
+		return
+	}
+	workload.Progress = pct
+	if controller != nil {
+		sendUpdate(workload)
+	} else {
+		ReportProgress(workload.Id, pct)
+	}
+	publish(workload)
+}
+
+func clampProgress(pct int32) int32 {
+	return min(max(pct, 0), 100)
+}
@@ -0,0 +1,407 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nieveai/d-agents/internal/agents"
+	"github.com/nieveai/d-agents/internal/database"
+	m "github.com/nieveai/d-agents/internal/models"
+	"github.com/nieveai/d-agents/internal/testutil"
+	pb "github.com/nieveai/d-agents/proto"
+	"google.golang.org/protobuf/proto"
+)
+
+// initTestWorker makes the worker use store, without retries, for the rest of
+// the test.
+func initTestWorker(t *testing.T, store database.Datastore) {
+	t.Helper()
+	oldDB, oldRetries := db, maxRetries
+	db, maxRetries = store, 0
+	t.Cleanup(func() { db, maxRetries = oldDB, oldRetries })
+}
+
+// addRunningSession stores a RUNNING ChatAgent session and returns it.
+func addRunningSession(t *testing.T, store database.Datastore, id string) *pb.Workload {
+	t.Helper()
+	session := &pb.Workload{Id: id, AgentType: "ChatAgent", Models: []string{"m1"}, Payload: []byte("hello"), Status: pb.WorkloadStatus_RUNNING}
+	if err := store.AddSession(session); err != nil {
+		t.Fatalf("AddSession: %v", err)
+	}
+	return session
+}
+
+func TestFailingAgentMarksSessionFailed(t *testing.T) {
+	store := database.NewMemoryDatastore()
+	initTestWorker(t, store)
+	session := addRunningSession(t, store, "s1")
+
+	client := testutil.NewFakeGenAIClient().Fail(errors.New("model exploded"))
+	ProcessWorkloadWithClient(context.Background(), session, client)
+
+	got, err := store.GetSession("s1")
+	if err != nil {
+		t.Fatalf("GetSession: %v", err)
+	}
+	if got.Status != pb.WorkloadStatus_FAILED {
+		t.Errorf("status = %v, want FAILED", got.Status)
+	}
+	if !strings.Contains(got.Error, "model exploded") {
+		t.Errorf("error = %q, want the agent's error", got.Error)
+	}
+}
+
+func TestSucceedingAgentClearsError(t *testing.T) {
+	store := database.NewMemoryDatastore()
+	initTestWorker(t, store)
+	session := addRunningSession(t, store, "s1")
+	session.Error = "an earlier failure"
+
+	ProcessWorkloadWithClient(context.Background(), session, testutil.NewFakeGenAIClient("hi there"))
+
+	got, err := store.GetSession("s1")
+	if err != nil {
+		t.Fatalf("GetSession: %v", err)
+	}
+	if got.Status != pb.WorkloadStatus_COMPLETED || got.Error != "" {
+		t.Errorf("session = %v %q, want COMPLETED without error", got.Status, got.Error)
+	}
+	if !strings.HasSuffix(string(got.Payload), "hi there") {
+		t.Errorf("payload = %q, want the answer", got.Payload)
+	}
+}
+
+// slowAgent blocks until its context is done.
+type slowAgent struct{}
+
+func (slowAgent) DoWork(ctx context.Context, workload *pb.Workload, client m.GenAIClient) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestWorkloadTimeout(t *testing.T) {
+	store := database.NewMemoryDatastore()
+	initTestWorker(t, store)
+	RegisterAgent("slowTestAgent", func() (m.AgentInterface, error) { return slowAgent{}, nil })
+	SetWorkloadTimeout(50 * time.Millisecond)
+	t.Cleanup(func() { SetWorkloadTimeout(DefaultWorkloadTimeout) })
+	session := addRunningSession(t, store, "s1")
+	session.AgentType = "slowTestAgent"
+
+	done := make(chan struct{})
+	go func() {
+		ProcessWorkloadWithClient(context.Background(), session, testutil.NewFakeGenAIClient())
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the slow agent wasn't stopped by the timeout")
+	}
+
+	got, err := store.GetSession("s1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Status != pb.WorkloadStatus_FAILED || !strings.Contains(got.Error, "timed out") {
+		t.Errorf("session = %v %q, want FAILED with a timeout", got.Status, got.Error)
+	}
+}
+
+func TestLLMCallUsesTheWorkloadContext(t *testing.T) {
+	store := database.NewMemoryDatastore()
+	initTestWorker(t, store)
+	SetWorkloadTimeout(50 * time.Millisecond)
+	t.Cleanup(func() { SetWorkloadTimeout(DefaultWorkloadTimeout) })
+	server := newFakeOpenAI(t, func(map[string]any) fakeReply {
+		time.Sleep(300 * time.Millisecond)
+		return fakeReply{Text: "too late"}
+	})
+	llm, err := NewLLMClient(context.Background(), []*m.Model{openaiModel("m1", server.URL)})
+	if err != nil {
+		t.Fatalf("NewLLMClient: %v", err)
+	}
+	session := addRunningSession(t, store, "s1")
+
+	start := time.Now()
+	ProcessWorkloadWithClient(context.Background(), session, llm)
+	if elapsed := time.Since(start); elapsed > 250*time.Millisecond {
+		t.Errorf("the LLM call took %s, it wasn't cancelled by the timeout", elapsed)
+	}
+	if got, _ := store.GetSession("s1"); got.Status != pb.WorkloadStatus_FAILED || !strings.Contains(got.Error, "timed out") {
+		t.Errorf("session = %v %q, want FAILED with a timeout", got.Status, got.Error)
+	}
+}
+
+// appendAgent appends its suffix to the payload.
+type appendAgent struct{ suffix string }
+
+func (a appendAgent) DoWork(ctx context.Context, workload *pb.Workload, client m.GenAIClient) error {
+	workload.Payload = append(workload.Payload, a.suffix...)
+	return nil
+}
+
+func TestProcessWorkloadDispatchesToRegisteredAgent(t *testing.T) {
+	store := database.NewMemoryDatastore()
+	initTestWorker(t, store)
+	created := 0
+	RegisterAgent("fakeTestAgent", func() (m.AgentInterface, error) {
+		created++
+		return appendAgent{" faked"}, nil
+	})
+
+	session := addRunningSession(t, store, "s1")
+	session.AgentType = "fakeTestAgent"
+	ProcessWorkloadWithClient(context.Background(), session, testutil.NewFakeGenAIClient())
+
+	if created != 1 {
+		t.Errorf("factory called %d times, want once", created)
+	}
+	got, err := store.GetSession("s1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Status != pb.WorkloadStatus_COMPLETED || string(got.Payload) != "hello faked" {
+		t.Errorf("session = %v %q, want COMPLETED \"hello faked\"", got.Status, got.Payload)
+	}
+}
+
+func TestProcessWorkloadUnknownAgentType(t *testing.T) {
+	store := database.NewMemoryDatastore()
+	initTestWorker(t, store)
+	session := addRunningSession(t, store, "s1")
+	session.AgentType = "NoSuchAgent"
+
+	ProcessWorkloadWithClient(context.Background(), session, testutil.NewFakeGenAIClient())
+	got, _ := store.GetSession("s1")
+	if got.Status != pb.WorkloadStatus_FAILED || !strings.Contains(got.Error, "unknown agent type") {
+		t.Errorf("session = %v %q, want FAILED for the unknown type", got.Status, got.Error)
+	}
+}
+
+// promptAgent sends the payload to the model with its system prompt, the one
+// set on its Agent if there is one.
+type promptAgent struct{ prompt string }
+
+func (a *promptAgent) SetSystemPrompt(prompt string) { a.prompt = prompt }
+
+func (a *promptAgent) DoWork(ctx context.Context, workload *pb.Workload, client m.GenAIClient) error {
+	_, err := client.GenerateContentWithSystemPrompt(ctx, workload, string(workload.Payload), a.prompt)
+	return err
+}
+
+func TestAgentSystemPromptIsApplied(t *testing.T) {
+	store := database.NewMemoryDatastore()
+	initTestWorker(t, store)
+	RegisterAgent("promptTestAgent", func() (m.AgentInterface, error) { return &promptAgent{prompt: "built-in"}, nil })
+	for _, agent := range []*m.Agent{
+		{ID: "custom", Name: "Custom", Type: "promptTestAgent", SystemPrompt: "custom"},
+		{ID: "plain", Name: "Plain", Type: "promptTestAgent"},
+	} {
+		if err := store.AddAgent(agent); err != nil {
+			t.Fatalf("AddAgent: %v", err)
+		}
+	}
+
+	tests := []struct{ agentID, want string }{
+		{"custom", "custom"},
+		{"plain", "built-in"},
+		// A deleted agent leaves the session with the built-in prompt.
+		{"deleted", "built-in"},
+		{"", "built-in"},
+	}
+	for _, tt := range tests {
+		session := addRunningSession(t, store, "s-"+tt.agentID)
+		session.AgentType, session.AgentId = "promptTestAgent", tt.agentID
+		client := testutil.NewFakeGenAIClient("ok")
+		ProcessWorkloadWithClient(context.Background(), session, client)
+		if call, _ := client.LastCall(); call.SystemPrompt != tt.want {
+			t.Errorf("agent %q: system prompt = %q, want %q", tt.agentID, call.SystemPrompt, tt.want)
+		}
+	}
+}
+
+// stageAgent records the stage stored for its session while it runs.
+type stageAgent struct{ seen *[]string }
+
+func (a stageAgent) DoWork(ctx context.Context, workload *pb.Workload, client m.GenAIClient) error {
+	session, err := db.GetSession(workload.Id)
+	if err != nil {
+		return err
+	}
+	*a.seen = append(*a.seen, session.Stage)
+	return nil
+}
+
+func TestPipeline(t *testing.T) {
+	var seen []string
+	RegisterAgent("upperTestAgent", func() (m.AgentInterface, error) { return appendAgent{" +upper"}, nil })
+	RegisterAgent("lowerTestAgent", func() (m.AgentInterface, error) { return appendAgent{" +lower"}, nil })
+	RegisterAgent("stageTestAgent", func() (m.AgentInterface, error) { return stageAgent{&seen}, nil })
+	RegisterAgent("failTestAgent", func() (m.AgentInterface, error) { return failAgent{}, nil })
+
+	tests := []struct {
+		name        string
+		pipeline    []string
+		wantStatus  pb.WorkloadStatus_Status
+		wantPayload string
+		wantStages  []string
+	}{
+		{
+			name:        "payload accumulates",
+			pipeline:    []string{"upperTestAgent", "lowerTestAgent", "upperTestAgent"},
+			wantStatus:  pb.WorkloadStatus_COMPLETED,
+			wantPayload: "hello +upper +lower +upper",
+		},
+		{
+			name:        "stage is stored",
+			pipeline:    []string{"stageTestAgent", "upperTestAgent", "stageTestAgent"},
+			wantStatus:  pb.WorkloadStatus_COMPLETED,
+			wantPayload: "hello +upper",
+			wantStages:  []string{"1/3 stageTestAgent", "3/3 stageTestAgent"},
+		},
+		{
+			name:        "first failure stops",
+			pipeline:    []string{"upperTestAgent", "failTestAgent", "lowerTestAgent"},
+			wantStatus:  pb.WorkloadStatus_FAILED,
+			wantPayload: "hello +upper",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			seen = nil
+			store := database.NewMemoryDatastore()
+			initTestWorker(t, store)
+			session := addRunningSession(t, store, "s1")
+			session.Pipeline = tt.pipeline
+			session.AgentType = tt.pipeline[0]
+
+			ProcessWorkloadWithClient(context.Background(), session, testutil.NewFakeGenAIClient())
+			got, err := store.GetSession("s1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Status != tt.wantStatus || string(got.Payload) != tt.wantPayload {
+				t.Errorf("session = %v %q, want %v %q", got.Status, got.Payload, tt.wantStatus, tt.wantPayload)
+			}
+			if tt.wantStatus == pb.WorkloadStatus_FAILED && !strings.Contains(got.Error, "pipeline stage 2 (failTestAgent)") {
+				t.Errorf("error = %q, want it to name the failed stage", got.Error)
+			}
+			if tt.wantStages != nil && !slices.Equal(seen, tt.wantStages) {
+				t.Errorf("stages seen = %q, want %q", seen, tt.wantStages)
+			}
+		})
+	}
+}
+
+// failAgent always fails, without a retry helping.
+type failAgent struct{}
+
+func (failAgent) DoWork(ctx context.Context, workload *pb.Workload, client m.GenAIClient) error {
+	return errors.New("stage broke")
+}
+
+// initForTest calls Init, and undoes it when the test ends.
+func initForTest(t *testing.T, models []*m.Model, store database.Datastore) error {
+	t.Helper()
+	initTestWorker(t, store)
+	useLLMClient(t, nil)
+	t.Cleanup(func() {
+		database.UseSettings(nil)
+		agents.SetRelationshipStore(nil)
+		agents.SetVectorStore(nil)
+	})
+	return Init(context.Background(), models, store)
+}
+
+func TestInitLoadsModelsFromTheDatastore(t *testing.T) {
+	server := newFakeOpenAI(t, func(map[string]any) fakeReply { return fakeReply{Text: "from m1"} })
+	store := database.NewMemoryDatastore()
+	store.AddModel(openaiModel("m1", server.URL))
+
+	if err := initForTest(t, nil, store); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+	if UsableModels() != 1 {
+		t.Fatalf("UsableModels = %d, want the model in the datastore", UsableModels())
+	}
+	text, err := currentLLMClient().GenerateContent(context.Background(), &pb.Workload{Id: "s1", Models: []string{"m1"}}, "hi")
+	if err != nil || text != "from m1" {
+		t.Errorf("GenerateContent = %q, %v, want m1's answer", text, err)
+	}
+}
+
+func TestInitWithoutModels(t *testing.T) {
+	logs := captureLogs(t)
+	store := database.NewMemoryDatastore()
+	if err := initForTest(t, nil, store); err != nil {
+		t.Fatalf("Init without models = %v, want only a warning", err)
+	}
+	warned := false
+	for _, event := range logEvents(t, logs) {
+		if event["level"] == "WARN" && strings.Contains(event["msg"].(string), "NO MODELS CONFIGURED") {
+			warned = true
+		}
+	}
+	if !warned {
+		t.Errorf("no warning about the missing models in %s", logs)
+	}
+
+	session := addRunningSession(t, store, "s1")
+	session.AgentType = "askTestAgent"
+	RegisterAgent("askTestAgent", func() (m.AgentInterface, error) { return askAgent{}, nil })
+	ProcessWorkload(context.Background(), session)
+	if got, _ := store.GetSession("s1"); got.Status != pb.WorkloadStatus_FAILED || !strings.Contains(got.Error, "no model with ID 'm1'") {
+		t.Errorf("session = %v %q, want it failed on the missing model", got.Status, got.Error)
+	}
+}
+
+func TestReloadModels(t *testing.T) {
+	server := newFakeOpenAI(t, func(map[string]any) fakeReply { return fakeReply{Text: "from m2"} })
+	store := database.NewMemoryDatastore()
+	if err := initForTest(t, nil, store); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+	workload := &pb.Workload{Id: "s1", Models: []string{"m2"}}
+	if _, err := currentLLMClient().GenerateContent(context.Background(), workload, "hi"); !errors.Is(err, ErrModelNotFound) {
+		t.Fatalf("GenerateContent before m2 is added = %v, want ErrModelNotFound", err)
+	}
+
+	// Added while the worker runs, e.g. from a controller.
+	store.AddModel(openaiModel("m2", server.URL))
+	if err := ReloadModels(context.Background()); err != nil {
+		t.Fatalf("ReloadModels: %v", err)
+	}
+	if text, err := currentLLMClient().GenerateContent(context.Background(), workload, "hi"); err != nil || text != "from m2" {
+		t.Errorf("GenerateContent after ReloadModels = %q, %v, want m2's answer", text, err)
+	}
+
+	store.DeleteModel("m2")
+	// Workloads running meanwhile keep a usable client.
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(2)
+		go func() { defer wg.Done(); ReloadModels(context.Background()) }()
+		go func() {
+			defer wg.Done()
+			// Each has its own workload, as the usage is added to it.
+			currentLLMClient().GenerateContent(context.Background(), proto.Clone(workload).(*pb.Workload), "hi")
+		}()
+	}
+	wg.Wait()
+	if _, err := currentLLMClient().GenerateContent(context.Background(), workload, "hi"); !errors.Is(err, ErrModelNotFound) {
+		t.Errorf("GenerateContent after m2 was deleted = %v, want ErrModelNotFound", err)
+	}
+}
+
+func TestReloadModelsBeforeInit(t *testing.T) {
+	initTestWorker(t, nil)
+	if err := ReloadModels(context.Background()); err == nil {
+		t.Error("ReloadModels succeeded before Init")
+	}
+}
@@ -29,6 +29,7 @@ const (
 	WorkloadStatus_RUNNING   WorkloadStatus_Status = 2
 	WorkloadStatus_COMPLETED WorkloadStatus_Status = 3
 	WorkloadStatus_FAILED    WorkloadStatus_Status = 4
+	WorkloadStatus_CANCELLED WorkloadStatus_Status = 5
 )
 
 // Enum value maps for WorkloadStatus_Status.
@@ -39,6 +40,7 @@ var (
 		2: "RUNNING",
 		3: "COMPLETED",
 		4: "FAILED",
+		5: "CANCELLED",
 	}
 	WorkloadStatus_Status_value = map[string]int32{
 		"UNKNOWN":   0,
@@ -46,6 +48,7 @@ var (
 		"RUNNING":   2,
 		"COMPLETED": 3,
 		"FAILED":    4,
+		"CANCELLED": 5,
 	}
 )
 
@@ -73,20 +76,53 @@ func (x WorkloadStatus_Status) Number() protoreflect.EnumNumber {
 
 // Deprecated: Use WorkloadStatus_Status.Descriptor instead.
 func (WorkloadStatus_Status) EnumDescriptor() ([]byte, []int) {
-	return file_proto_d_agents_proto_rawDescGZIP(), []int{1, 0}
+	return file_proto_d_agents_proto_rawDescGZIP(), []int{2, 0}
 }
 
 type Workload struct {
-	state         protoimpl.MessageState `protogen:"open.v1"`
-	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
-	Name          string                 `protobuf:"bytes,2,opt,name=name,proto3" json:"name,omitempty"`
-	Models        []string               `protobuf:"bytes,3,rep,name=models,proto3" json:"models,omitempty"`
-	Description   string                 `protobuf:"bytes,4,opt,name=description,proto3" json:"description,omitempty"`
-	Payload       []byte                 `protobuf:"bytes,5,opt,name=payload,proto3" json:"payload,omitempty"`
-	Timestamp     int64                  `protobuf:"varint,6,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
-	AgentId       string                 `protobuf:"bytes,7,opt,name=agent_id,json=agentId,proto3" json:"agent_id,omitempty"`
-	Status        WorkloadStatus_Status  `protobuf:"varint,8,opt,name=status,proto3,enum=proto.WorkloadStatus_Status" json:"status,omitempty"`
-	AgentType     string                 `protobuf:"bytes,9,opt,name=agent_type,json=agentType,proto3" json:"agent_type,omitempty"`
+	state            protoimpl.MessageState `protogen:"open.v1"`
+	Id               string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Name             string                 `protobuf:"bytes,2,opt,name=name,proto3" json:"name,omitempty"`
+	Models           []string               `protobuf:"bytes,3,rep,name=models,proto3" json:"models,omitempty"`
+	Description      string                 `protobuf:"bytes,4,opt,name=description,proto3" json:"description,omitempty"`
+	Payload          []byte                 `protobuf:"bytes,5,opt,name=payload,proto3" json:"payload,omitempty"`
+	Timestamp        int64                  `protobuf:"varint,6,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
+	AgentId          string                 `protobuf:"bytes,7,opt,name=agent_id,json=agentId,proto3" json:"agent_id,omitempty"`
+	Status           WorkloadStatus_Status  `protobuf:"varint,8,opt,name=status,proto3,enum=proto.WorkloadStatus_Status" json:"status,omitempty"`
+	AgentType        string                 `protobuf:"bytes,9,opt,name=agent_type,json=agentType,proto3" json:"agent_type,omitempty"`
+	Config           string                 `protobuf:"bytes,10,opt,name=config,proto3" json:"config,omitempty"`
+	PromptTokens     int64                  `protobuf:"varint,11,opt,name=prompt_tokens,json=promptTokens,proto3" json:"prompt_tokens,omitempty"`
+	CompletionTokens int64                  `protobuf:"varint,12,opt,name=completion_tokens,json=completionTokens,proto3" json:"completion_tokens,omitempty"`
+	EstimatedCost    float64                `protobuf:"fixed64,13,opt,name=estimated_cost,json=estimatedCost,proto3" json:"estimated_cost,omitempty"`
+	Error            string                 `protobuf:"bytes,14,opt,name=error,proto3" json:"error,omitempty"`
+	RetryCount       int32                  `protobuf:"varint,15,opt,name=retry_count,json=retryCount,proto3" json:"retry_count,omitempty"`
+	// Agent types to run in sequence, each one working on the previous payload.
+	Pipeline []string `protobuf:"bytes,16,rep,name=pipeline,proto3" json:"pipeline,omitempty"`
+	// The pipeline stage currently running, if any.
+	Stage string `protobuf:"bytes,17,opt,name=stage,proto3" json:"stage,omitempty"`
+	// Models tried in order when the first model fails.
+	FallbackModels []string `protobuf:"bytes,18,rep,name=fallback_models,json=fallbackModels,proto3" json:"fallback_models,omitempty"`
+	// Agents only report what they would do, without calling models or
+	// writing to any store.
+	DryRun bool `protobuf:"varint,19,opt,name=dry_run,json=dryRun,proto3" json:"dry_run,omitempty"`
+	// When the worker running the workload last reported in, in Unix seconds.
+	// Zero while the workload is still queued.
+	LastHeartbeat int64 `protobuf:"varint,20,opt,name=last_heartbeat,json=lastHeartbeat,proto3" json:"last_heartbeat,omitempty"`
+	// How far along a running workload is, from 0 to 100.
+	Progress int32 `protobuf:"varint,21,opt,name=progress,proto3" json:"progress,omitempty"`
+	// Queued workloads with a higher priority run first; equal ones run oldest
+	// first.
+	Priority int32 `protobuf:"varint,22,opt,name=priority,proto3" json:"priority,omitempty"`
+	// Tags are lowercase labels to group sessions by. They are set when the
+	// session is created and changed with AddTag and RemoveTag afterwards.
+	Tags []string `protobuf:"bytes,23,rep,name=tags,proto3" json:"tags,omitempty"`
+	// The custom system prompt of the session's agent. Only set on the
+	// workloads sent to remote workers, which can't look it up.
+	SystemPrompt string `protobuf:"bytes,24,opt,name=system_prompt,json=systemPrompt,proto3" json:"system_prompt,omitempty"`
+	// The earlier turns of a chat session, oldest first. They are kept apart
+	// from the payload so a conversation can be continued whatever the output
+	// format of the previous run.
+	History       []*ChatMessage `protobuf:"bytes,25,rep,name=history,proto3" json:"history,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -184,6 +220,170 @@ func (x *Workload) GetAgentType() string {
 	return ""
 }
 
+func (x *Workload) GetConfig() string {
+	if x != nil {
+		return x.Config
+	}
+	return ""
+}
+
+func (x *Workload) GetPromptTokens() int64 {
+	if x != nil {
+		return x.PromptTokens
+	}
+	return 0
+}
+
+func (x *Workload) GetCompletionTokens() int64 {
+	if x != nil {
+		return x.CompletionTokens
+	}
+	return 0
+}
+
+func (x *Workload) GetEstimatedCost() float64 {
+	if x != nil {
+		return x.EstimatedCost
+	}
+	return 0
+}
+
+func (x *Workload) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+func (x *Workload) GetRetryCount() int32 {
+	if x != nil {
+		return x.RetryCount
+	}
+	return 0
+}
+
+func (x *Workload) GetPipeline() []string {
+	if x != nil {
+		return x.Pipeline
+	}
+	return nil
+}
+
+func (x *Workload) GetStage() string {
+	if x != nil {
+		return x.Stage
+	}
+	return ""
+}
+
+func (x *Workload) GetFallbackModels() []string {
+	if x != nil {
+		return x.FallbackModels
+	}
+	return nil
+}
+
+func (x *Workload) GetDryRun() bool {
+	if x != nil {
+		return x.DryRun
+	}
+	return false
+}
+
+func (x *Workload) GetLastHeartbeat() int64 {
+	if x != nil {
+		return x.LastHeartbeat
+	}
+	return 0
+}
+
+func (x *Workload) GetProgress() int32 {
+	if x != nil {
+		return x.Progress
+	}
+	return 0
+}
+
+func (x *Workload) GetPriority() int32 {
+	if x != nil {
+		return x.Priority
+	}
+	return 0
+}
+
+func (x *Workload) GetTags() []string {
+	if x != nil {
+		return x.Tags
+	}
+	return nil
+}
+
+func (x *Workload) GetSystemPrompt() string {
+	if x != nil {
+		return x.SystemPrompt
+	}
+	return ""
+}
+
+func (x *Workload) GetHistory() []*ChatMessage {
+	if x != nil {
+		return x.History
+	}
+	return nil
+}
+
+type ChatMessage struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Role          string                 `protobuf:"bytes,1,opt,name=role,proto3" json:"role,omitempty"`
+	Content       string                 `protobuf:"bytes,2,opt,name=content,proto3" json:"content,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ChatMessage) Reset() {
+	*x = ChatMessage{}
+	mi := &file_proto_d_agents_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ChatMessage) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ChatMessage) ProtoMessage() {}
+
+func (x *ChatMessage) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_d_agents_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ChatMessage.ProtoReflect.Descriptor instead.
+func (*ChatMessage) Descriptor() ([]byte, []int) {
+	return file_proto_d_agents_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *ChatMessage) GetRole() string {
+	if x != nil {
+		return x.Role
+	}
+	return ""
+}
+
+func (x *ChatMessage) GetContent() string {
+	if x != nil {
+		return x.Content
+	}
+	return ""
+}
+
 type WorkloadStatus struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	WorkloadId    string                 `protobuf:"bytes,1,opt,name=workload_id,json=workloadId,proto3" json:"workload_id,omitempty"`
@@ -195,7 +395,7 @@ type WorkloadStatus struct {
 
 func (x *WorkloadStatus) Reset() {
 	*x = WorkloadStatus{}
-	mi := &file_proto_d_agents_proto_msgTypes[1]
+	mi := &file_proto_d_agents_proto_msgTypes[2]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -207,7 +407,7 @@ func (x *WorkloadStatus) String() string {
 func (*WorkloadStatus) ProtoMessage() {}
 
 func (x *WorkloadStatus) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_d_agents_proto_msgTypes[1]
+	mi := &file_proto_d_agents_proto_msgTypes[2]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -220,7 +420,7 @@ func (x *WorkloadStatus) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use WorkloadStatus.ProtoReflect.Descriptor instead.
 func (*WorkloadStatus) Descriptor() ([]byte, []int) {
-	return file_proto_d_agents_proto_rawDescGZIP(), []int{1}
+	return file_proto_d_agents_proto_rawDescGZIP(), []int{2}
 }
 
 func (x *WorkloadStatus) GetWorkloadId() string {
@@ -244,11 +444,165 @@ func (x *WorkloadStatus) GetMessage() string {
 	return ""
 }
 
+type WorkerInfo struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	WorkerId      string                 `protobuf:"bytes,1,opt,name=worker_id,json=workerId,proto3" json:"worker_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *WorkerInfo) Reset() {
+	*x = WorkerInfo{}
+	mi := &file_proto_d_agents_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *WorkerInfo) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WorkerInfo) ProtoMessage() {}
+
+func (x *WorkerInfo) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_d_agents_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WorkerInfo.ProtoReflect.Descriptor instead.
+func (*WorkerInfo) Descriptor() ([]byte, []int) {
+	return file_proto_d_agents_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *WorkerInfo) GetWorkerId() string {
+	if x != nil {
+		return x.WorkerId
+	}
+	return ""
+}
+
+// AuditEntry is a model call made for a session, see models.AuditEntry.
+type AuditEntry struct {
+	state            protoimpl.MessageState `protogen:"open.v1"`
+	SessionId        string                 `protobuf:"bytes,1,opt,name=session_id,json=sessionId,proto3" json:"session_id,omitempty"`
+	ModelId          string                 `protobuf:"bytes,2,opt,name=model_id,json=modelId,proto3" json:"model_id,omitempty"`
+	SystemPrompt     string                 `protobuf:"bytes,3,opt,name=system_prompt,json=systemPrompt,proto3" json:"system_prompt,omitempty"`
+	Input            string                 `protobuf:"bytes,4,opt,name=input,proto3" json:"input,omitempty"`
+	Output           string                 `protobuf:"bytes,5,opt,name=output,proto3" json:"output,omitempty"`
+	Error            string                 `protobuf:"bytes,6,opt,name=error,proto3" json:"error,omitempty"`
+	PromptTokens     int64                  `protobuf:"varint,7,opt,name=prompt_tokens,json=promptTokens,proto3" json:"prompt_tokens,omitempty"`
+	CompletionTokens int64                  `protobuf:"varint,8,opt,name=completion_tokens,json=completionTokens,proto3" json:"completion_tokens,omitempty"`
+	// When the call was made, in Unix nanoseconds.
+	Timestamp     int64 `protobuf:"varint,9,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AuditEntry) Reset() {
+	*x = AuditEntry{}
+	mi := &file_proto_d_agents_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AuditEntry) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AuditEntry) ProtoMessage() {}
+
+func (x *AuditEntry) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_d_agents_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AuditEntry.ProtoReflect.Descriptor instead.
+func (*AuditEntry) Descriptor() ([]byte, []int) {
+	return file_proto_d_agents_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *AuditEntry) GetSessionId() string {
+	if x != nil {
+		return x.SessionId
+	}
+	return ""
+}
+
+func (x *AuditEntry) GetModelId() string {
+	if x != nil {
+		return x.ModelId
+	}
+	return ""
+}
+
+func (x *AuditEntry) GetSystemPrompt() string {
+	if x != nil {
+		return x.SystemPrompt
+	}
+	return ""
+}
+
+func (x *AuditEntry) GetInput() string {
+	if x != nil {
+		return x.Input
+	}
+	return ""
+}
+
+func (x *AuditEntry) GetOutput() string {
+	if x != nil {
+		return x.Output
+	}
+	return ""
+}
+
+func (x *AuditEntry) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+func (x *AuditEntry) GetPromptTokens() int64 {
+	if x != nil {
+		return x.PromptTokens
+	}
+	return 0
+}
+
+func (x *AuditEntry) GetCompletionTokens() int64 {
+	if x != nil {
+		return x.CompletionTokens
+	}
+	return 0
+}
+
+func (x *AuditEntry) GetTimestamp() int64 {
+	if x != nil {
+		return x.Timestamp
+	}
+	return 0
+}
+
 var File_proto_d_agents_proto protoreflect.FileDescriptor
 
 const file_proto_d_agents_proto_rawDesc = "" +
 	"\n" +
-	"\x14proto/d-agents.proto\x12\x05proto\"\x90\x02\n" +
+	"\x14proto/d-agents.proto\x12\x05proto\"\x92\x06\n" +
 	"\bWorkload\x12\x0e\n" +
 	"\x02id\x18\x01 \x01(\tR\x02id\x12\x12\n" +
 	"\x04name\x18\x02 \x01(\tR\x04name\x12\x16\n" +
@@ -259,21 +613,64 @@ const file_proto_d_agents_proto_rawDesc = "" +
 	"\bagent_id\x18\a \x01(\tR\aagentId\x124\n" +
 	"\x06status\x18\b \x01(\x0e2\x1c.proto.WorkloadStatus.StatusR\x06status\x12\x1d\n" +
 	"\n" +
-	"agent_type\x18\t \x01(\tR\tagentType\"\xcd\x01\n" +
+	"agent_type\x18\t \x01(\tR\tagentType\x12\x16\n" +
+	"\x06config\x18\n" +
+	" \x01(\tR\x06config\x12#\n" +
+	"\rprompt_tokens\x18\v \x01(\x03R\fpromptTokens\x12+\n" +
+	"\x11completion_tokens\x18\f \x01(\x03R\x10completionTokens\x12%\n" +
+	"\x0eestimated_cost\x18\r \x01(\x01R\restimatedCost\x12\x14\n" +
+	"\x05error\x18\x0e \x01(\tR\x05error\x12\x1f\n" +
+	"\vretry_count\x18\x0f \x01(\x05R\n" +
+	"retryCount\x12\x1a\n" +
+	"\bpipeline\x18\x10 \x03(\tR\bpipeline\x12\x14\n" +
+	"\x05stage\x18\x11 \x01(\tR\x05stage\x12'\n" +
+	"\x0ffallback_models\x18\x12 \x03(\tR\x0efallbackModels\x12\x17\n" +
+	"\adry_run\x18\x13 \x01(\bR\x06dryRun\x12%\n" +
+	"\x0elast_heartbeat\x18\x14 \x01(\x03R\rlastHeartbeat\x12\x1a\n" +
+	"\bprogress\x18\x15 \x01(\x05R\bprogress\x12\x1a\n" +
+	"\bpriority\x18\x16 \x01(\x05R\bpriority\x12\x12\n" +
+	"\x04tags\x18\x17 \x03(\tR\x04tags\x12#\n" +
+	"\rsystem_prompt\x18\x18 \x01(\tR\fsystemPrompt\x12,\n" +
+	"\ahistory\x18\x19 \x03(\v2\x12.proto.ChatMessageR\ahistory\";\n" +
+	"\vChatMessage\x12\x12\n" +
+	"\x04role\x18\x01 \x01(\tR\x04role\x12\x18\n" +
+	"\acontent\x18\x02 \x01(\tR\acontent\"\xdc\x01\n" +
 	"\x0eWorkloadStatus\x12\x1f\n" +
 	"\vworkload_id\x18\x01 \x01(\tR\n" +
 	"workloadId\x124\n" +
 	"\x06status\x18\x02 \x01(\x0e2\x1c.proto.WorkloadStatus.StatusR\x06status\x12\x18\n" +
-	"\amessage\x18\x03 \x01(\tR\amessage\"J\n" +
+	"\amessage\x18\x03 \x01(\tR\amessage\"Y\n" +
 	"\x06Status\x12\v\n" +
 	"\aUNKNOWN\x10\x00\x12\v\n" +
 	"\aPENDING\x10\x01\x12\v\n" +
 	"\aRUNNING\x10\x02\x12\r\n" +
 	"\tCOMPLETED\x10\x03\x12\n" +
 	"\n" +
-	"\x06FAILED\x10\x042C\n" +
+	"\x06FAILED\x10\x04\x12\r\n" +
+	"\tCANCELLED\x10\x05\")\n" +
+	"\n" +
+	"WorkerInfo\x12\x1b\n" +
+	"\tworker_id\x18\x01 \x01(\tR\bworkerId\"\x9f\x02\n" +
+	"\n" +
+	"AuditEntry\x12\x1d\n" +
+	"\n" +
+	"session_id\x18\x01 \x01(\tR\tsessionId\x12\x19\n" +
+	"\bmodel_id\x18\x02 \x01(\tR\amodelId\x12#\n" +
+	"\rsystem_prompt\x18\x03 \x01(\tR\fsystemPrompt\x12\x14\n" +
+	"\x05input\x18\x04 \x01(\tR\x05input\x12\x16\n" +
+	"\x06output\x18\x05 \x01(\tR\x06output\x12\x14\n" +
+	"\x05error\x18\x06 \x01(\tR\x05error\x12#\n" +
+	"\rprompt_tokens\x18\a \x01(\x03R\fpromptTokens\x12+\n" +
+	"\x11completion_tokens\x18\b \x01(\x03R\x10completionTokens\x12\x1c\n" +
+	"\ttimestamp\x18\t \x01(\x03R\ttimestamp2C\n" +
 	"\x06Worker\x129\n" +
-	"\x0fExecuteWorkload\x12\x0f.proto.Workload\x1a\x15.proto.WorkloadStatusB#Z!github.com/nieveai/d-agents/protob\x06proto3"
+	"\x0fExecuteWorkload\x12\x0f.proto.Workload\x1a\x15.proto.WorkloadStatus2\xae\x02\n" +
+	"\rWorkerService\x127\n" +
+	"\x0fStreamWorkloads\x12\x11.proto.WorkerInfo\x1a\x0f.proto.Workload0\x01\x126\n" +
+	"\fReportResult\x12\x0f.proto.Workload\x1a\x15.proto.WorkloadStatus\x129\n" +
+	"\tHeartbeat\x12\x15.proto.WorkloadStatus\x1a\x15.proto.WorkloadStatus\x128\n" +
+	"\x0eUpdateWorkload\x12\x0f.proto.Workload\x1a\x15.proto.WorkloadStatus\x127\n" +
+	"\vRecordAudit\x12\x11.proto.AuditEntry\x1a\x15.proto.WorkloadStatusB#Z!github.com/nieveai/d-agents/protob\x06proto3"
 
 var (
 	file_proto_d_agents_proto_rawDescOnce sync.Once
@@ -288,22 +685,36 @@ func file_proto_d_agents_proto_rawDescGZIP() []byte {
 }
 
 var file_proto_d_agents_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
-var file_proto_d_agents_proto_msgTypes = make([]protoimpl.MessageInfo, 2)
+var file_proto_d_agents_proto_msgTypes = make([]protoimpl.MessageInfo, 5)
 var file_proto_d_agents_proto_goTypes = []any{
 	(WorkloadStatus_Status)(0), // 0: proto.WorkloadStatus.Status
 	(*Workload)(nil),           // 1: proto.Workload
-	(*WorkloadStatus)(nil),     // 2: proto.WorkloadStatus
+	(*ChatMessage)(nil),        // 2: proto.ChatMessage
+	(*WorkloadStatus)(nil),     // 3: proto.WorkloadStatus
+	(*WorkerInfo)(nil),         // 4: proto.WorkerInfo
+	(*AuditEntry)(nil),         // 5: proto.AuditEntry
 }
 var file_proto_d_agents_proto_depIdxs = []int32{
 	0, // 0: proto.Workload.status:type_name -> proto.WorkloadStatus.Status
-	0, // 1: proto.WorkloadStatus.status:type_name -> proto.WorkloadStatus.Status
-	1, // 2: proto.Worker.ExecuteWorkload:input_type -> proto.Workload
-	2, // 3: proto.Worker.ExecuteWorkload:output_type -> proto.WorkloadStatus
-	3, // [3:4] is the sub-list for method output_type
-	2, // [2:3] is the sub-list for method input_type
-	2, // [2:2] is the sub-list for extension type_name
-	2, // [2:2] is the sub-list for extension extendee
-	0, // [0:2] is the sub-list for field type_name
+	2, // 1: proto.Workload.history:type_name -> proto.ChatMessage
+	0, // 2: proto.WorkloadStatus.status:type_name -> proto.WorkloadStatus.Status
+	1, // 3: proto.Worker.ExecuteWorkload:input_type -> proto.Workload
+	4, // 4: proto.WorkerService.StreamWorkloads:input_type -> proto.WorkerInfo
+	1, // 5: proto.WorkerService.ReportResult:input_type -> proto.Workload
+	3, // 6: proto.WorkerService.Heartbeat:input_type -> proto.WorkloadStatus
+	1, // 7: proto.WorkerService.UpdateWorkload:input_type -> proto.Workload
+	5, // 8: proto.WorkerService.RecordAudit:input_type -> proto.AuditEntry
+	3, // 9: proto.Worker.ExecuteWorkload:output_type -> proto.WorkloadStatus
+	1, // 10: proto.WorkerService.StreamWorkloads:output_type -> proto.Workload
+	3, // 11: proto.WorkerService.ReportResult:output_type -> proto.WorkloadStatus
+	3, // 12: proto.WorkerService.Heartbeat:output_type -> proto.WorkloadStatus
+	3, // 13: proto.WorkerService.UpdateWorkload:output_type -> proto.WorkloadStatus
+	3, // 14: proto.WorkerService.RecordAudit:output_type -> proto.WorkloadStatus
+	9, // [9:15] is the sub-list for method output_type
+	3, // [3:9] is the sub-list for method input_type
+	3, // [3:3] is the sub-list for extension type_name
+	3, // [3:3] is the sub-list for extension extendee
+	0, // [0:3] is the sub-list for field type_name
 }
 
 func init() { file_proto_d_agents_proto_init() }
@@ -317,9 +728,9 @@ func file_proto_d_agents_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_proto_d_agents_proto_rawDesc), len(file_proto_d_agents_proto_rawDesc)),
 			NumEnums:      1,
-			NumMessages:   2,
+			NumMessages:   5,
 			NumExtensions: 0,
-			NumServices:   1,
+			NumServices:   2,
 		},
 		GoTypes:           file_proto_d_agents_proto_goTypes,
 		DependencyIndexes: file_proto_d_agents_proto_depIdxs,
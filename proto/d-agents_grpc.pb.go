@@ -119,3 +119,275 @@ var Worker_ServiceDesc = grpc.ServiceDesc{
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "proto/d-agents.proto",
 }
+
+const (
+	WorkerService_StreamWorkloads_FullMethodName = "/proto.WorkerService/StreamWorkloads"
+	WorkerService_ReportResult_FullMethodName    = "/proto.WorkerService/ReportResult"
+	WorkerService_Heartbeat_FullMethodName       = "/proto.WorkerService/Heartbeat"
+	WorkerService_UpdateWorkload_FullMethodName  = "/proto.WorkerService/UpdateWorkload"
+	WorkerService_RecordAudit_FullMethodName     = "/proto.WorkerService/RecordAudit"
+)
+
+// WorkerServiceClient is the client API for WorkerService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// WorkerService lets remote workers pull workloads from the controller and
+// report the results back.
+type WorkerServiceClient interface {
+	StreamWorkloads(ctx context.Context, in *WorkerInfo, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Workload], error)
+	ReportResult(ctx context.Context, in *Workload, opts ...grpc.CallOption) (*WorkloadStatus, error)
+	// Heartbeat tells the controller a workload is still being worked on.
+	Heartbeat(ctx context.Context, in *WorkloadStatus, opts ...grpc.CallOption) (*WorkloadStatus, error)
+	// UpdateWorkload records the payload, stage, progress and retry count of a
+	// workload still running on a remote worker.
+	UpdateWorkload(ctx context.Context, in *Workload, opts ...grpc.CallOption) (*WorkloadStatus, error)
+	// RecordAudit stores the audit entry of a model call a remote worker made.
+	RecordAudit(ctx context.Context, in *AuditEntry, opts ...grpc.CallOption) (*WorkloadStatus, error)
+}
+
+type workerServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewWorkerServiceClient(cc grpc.ClientConnInterface) WorkerServiceClient {
+	return &workerServiceClient{cc}
+}
+
+func (c *workerServiceClient) StreamWorkloads(ctx context.Context, in *WorkerInfo, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Workload], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &WorkerService_ServiceDesc.Streams[0], WorkerService_StreamWorkloads_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[WorkerInfo, Workload]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type WorkerService_StreamWorkloadsClient = grpc.ServerStreamingClient[Workload]
+
+func (c *workerServiceClient) ReportResult(ctx context.Context, in *Workload, opts ...grpc.CallOption) (*WorkloadStatus, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(WorkloadStatus)
+	err := c.cc.Invoke(ctx, WorkerService_ReportResult_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *workerServiceClient) Heartbeat(ctx context.Context, in *WorkloadStatus, opts ...grpc.CallOption) (*WorkloadStatus, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(WorkloadStatus)
+	err := c.cc.Invoke(ctx, WorkerService_Heartbeat_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *workerServiceClient) UpdateWorkload(ctx context.Context, in *Workload, opts ...grpc.CallOption) (*WorkloadStatus, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(WorkloadStatus)
+	err := c.cc.Invoke(ctx, WorkerService_UpdateWorkload_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *workerServiceClient) RecordAudit(ctx context.Context, in *AuditEntry, opts ...grpc.CallOption) (*WorkloadStatus, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(WorkloadStatus)
+	err := c.cc.Invoke(ctx, WorkerService_RecordAudit_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// WorkerServiceServer is the server API for WorkerService service.
+// All implementations must embed UnimplementedWorkerServiceServer
+// for forward compatibility.
+//
+// WorkerService lets remote workers pull workloads from the controller and
+// report the results back.
+type WorkerServiceServer interface {
+	StreamWorkloads(*WorkerInfo, grpc.ServerStreamingServer[Workload]) error
+	ReportResult(context.Context, *Workload) (*WorkloadStatus, error)
+	// Heartbeat tells the controller a workload is still being worked on.
+	Heartbeat(context.Context, *WorkloadStatus) (*WorkloadStatus, error)
+	// UpdateWorkload records the payload, stage, progress and retry count of a
+	// workload still running on a remote worker.
+	UpdateWorkload(context.Context, *Workload) (*WorkloadStatus, error)
+	// RecordAudit stores the audit entry of a model call a remote worker made.
+	RecordAudit(context.Context, *AuditEntry) (*WorkloadStatus, error)
+	mustEmbedUnimplementedWorkerServiceServer()
+}
+
+// UnimplementedWorkerServiceServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedWorkerServiceServer struct{}
+
+func (UnimplementedWorkerServiceServer) StreamWorkloads(*WorkerInfo, grpc.ServerStreamingServer[Workload]) error {
+	return status.Errorf(codes.Unimplemented, "method StreamWorkloads not implemented")
+}
+func (UnimplementedWorkerServiceServer) ReportResult(context.Context, *Workload) (*WorkloadStatus, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ReportResult not implemented")
+}
+func (UnimplementedWorkerServiceServer) Heartbeat(context.Context, *WorkloadStatus) (*WorkloadStatus, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Heartbeat not implemented")
+}
+func (UnimplementedWorkerServiceServer) UpdateWorkload(context.Context, *Workload) (*WorkloadStatus, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UpdateWorkload not implemented")
+}
+func (UnimplementedWorkerServiceServer) RecordAudit(context.Context, *AuditEntry) (*WorkloadStatus, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RecordAudit not implemented")
+}
+func (UnimplementedWorkerServiceServer) mustEmbedUnimplementedWorkerServiceServer() {}
+func (UnimplementedWorkerServiceServer) testEmbeddedByValue()                       {}
+
+// UnsafeWorkerServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to WorkerServiceServer will
+// result in compilation errors.
+type UnsafeWorkerServiceServer interface {
+	mustEmbedUnimplementedWorkerServiceServer()
+}
+
+func RegisterWorkerServiceServer(s grpc.ServiceRegistrar, srv WorkerServiceServer) {
+	// If the following call pancis, it indicates UnimplementedWorkerServiceServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&WorkerService_ServiceDesc, srv)
+}
+
+func _WorkerService_StreamWorkloads_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WorkerInfo)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(WorkerServiceServer).StreamWorkloads(m, &grpc.GenericServerStream[WorkerInfo, Workload]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type WorkerService_StreamWorkloadsServer = grpc.ServerStreamingServer[Workload]
+
+func _WorkerService_ReportResult_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Workload)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WorkerServiceServer).ReportResult(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: WorkerService_ReportResult_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WorkerServiceServer).ReportResult(ctx, req.(*Workload))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _WorkerService_Heartbeat_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(WorkloadStatus)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WorkerServiceServer).Heartbeat(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: WorkerService_Heartbeat_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WorkerServiceServer).Heartbeat(ctx, req.(*WorkloadStatus))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _WorkerService_UpdateWorkload_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Workload)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WorkerServiceServer).UpdateWorkload(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: WorkerService_UpdateWorkload_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WorkerServiceServer).UpdateWorkload(ctx, req.(*Workload))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _WorkerService_RecordAudit_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AuditEntry)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WorkerServiceServer).RecordAudit(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: WorkerService_RecordAudit_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WorkerServiceServer).RecordAudit(ctx, req.(*AuditEntry))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// WorkerService_ServiceDesc is the grpc.ServiceDesc for WorkerService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var WorkerService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "proto.WorkerService",
+	HandlerType: (*WorkerServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ReportResult",
+			Handler:    _WorkerService_ReportResult_Handler,
+		},
+		{
+			MethodName: "Heartbeat",
+			Handler:    _WorkerService_Heartbeat_Handler,
+		},
+		{
+			MethodName: "UpdateWorkload",
+			Handler:    _WorkerService_UpdateWorkload_Handler,
+		},
+		{
+			MethodName: "RecordAudit",
+			Handler:    _WorkerService_RecordAudit_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamWorkloads",
+			Handler:       _WorkerService_StreamWorkloads_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "proto/d-agents.proto",
+}